@@ -4,6 +4,9 @@ go 1.12
 
 require (
 	github.com/PuerkitoBio/goquery v1.5.0
+	github.com/chromedp/cdproto v0.0.0-20191009033829-c22f49c9ff0a
+	github.com/chromedp/chromedp v0.5.1
+	github.com/coreos/go-oidc v2.2.1+incompatible
 	github.com/disintegration/imaging v1.6.0
 	github.com/fatih/color v1.7.0
 	github.com/go-shiori/go-readability v0.0.0-20190809152430-5413e9c4ec86
@@ -18,12 +21,20 @@ require (
 	github.com/mattn/go-sqlite3 v1.10.0
 	github.com/muesli/go-app-paths v0.0.0-20181030220709-913f7f7ac60f
 	github.com/patrickmn/go-cache v2.1.0+incompatible
+	github.com/pquerna/cachecontrol v0.0.0-20180517163645-1555304b9b35 // indirect
 	github.com/shurcooL/httpfs v0.0.0-20181222201310-74dc9339e414 // indirect
 	github.com/shurcooL/vfsgen v0.0.0-20181202132449-6a9ea43bcacd
 	github.com/sirupsen/logrus v1.4.2
 	github.com/spf13/cobra v0.0.5
+	go.etcd.io/bbolt v1.3.3
 	golang.org/x/crypto v0.0.0-20190701094942-4def268fd1a4
 	golang.org/x/image v0.0.0-20190802002840-cff245a6509b // indirect
+	golang.org/x/net v0.0.0-20190926025831-c00fd9afed17
+	golang.org/x/oauth2 v0.0.0-20190220154721-9b3c75971fc9
+	golang.org/x/text v0.3.2
 	golang.org/x/tools v0.0.0-20190809145639-6d4652c779c4 // indirect
 	google.golang.org/appengine v1.6.1 // indirect
+	gopkg.in/asn1-ber.v1 v1.0.0-20181015200546-f715ec2f112d // indirect
+	gopkg.in/ldap.v3 v3.1.0
+	gopkg.in/square/go-jose.v2 v2.4.0 // indirect
 )
@@ -24,6 +24,8 @@ func addCmd() *cobra.Command {
 	cmd.Flags().BoolP("offline", "o", false, "Save bookmark without fetching data from internet")
 	cmd.Flags().BoolP("no-archival", "a", false, "Save bookmark without creating offline archive")
 	cmd.Flags().Bool("log-archival", false, "Log the archival process")
+	cmd.Flags().Bool("pdf", false, "Save the page as PDF as well, which requires headless browser support")
+	cmd.Flags().String("archive-format", core.ArchiveFormatWARC, "Format of offline archive, either warc or singlefile")
 
 	return cmd
 }
@@ -37,9 +39,16 @@ func addHandler(cmd *cobra.Command, args []string) {
 	offline, _ := cmd.Flags().GetBool("offline")
 	noArchival, _ := cmd.Flags().GetBool("no-archival")
 	logArchival, _ := cmd.Flags().GetBool("log-archival")
+	createPDF, _ := cmd.Flags().GetBool("pdf")
+	archiveFormat, _ := cmd.Flags().GetString("archive-format")
+
+	if err := core.CheckArchiveFormat(archiveFormat); err != nil {
+		cError.Println(err)
+		os.Exit(1)
+	}
 
 	// Normalize input
-	title = validateTitle(title, "")
+	title = core.CleanTitle(title, "")
 	excerpt = normalizeSpace(excerpt)
 
 	// Create bookmark item
@@ -48,6 +57,8 @@ func addHandler(cmd *cobra.Command, args []string) {
 		Title:         title,
 		Excerpt:       excerpt,
 		CreateArchive: !noArchival,
+		CreatePDF:     createPDF,
+		ArchiveFormat: archiveFormat,
 	}
 
 	// Set bookmark tags
@@ -65,7 +76,7 @@ func addHandler(cmd *cobra.Command, args []string) {
 	}
 
 	// Clean up bookmark URL
-	book.URL, err = core.RemoveUTMParams(book.URL)
+	book.URL, err = core.RemoveUTMParams(book.URL, keptQueryParams)
 	if err != nil {
 		cError.Printf("Failed to clean URL: %v\n", err)
 		os.Exit(1)
@@ -76,20 +87,27 @@ func addHandler(cmd *cobra.Command, args []string) {
 		cInfo.Println("Downloading article...")
 
 		var isFatalErr bool
-		content, contentType, err := core.DownloadBookmark(book.URL)
+		content, contentType, statusCode, waybackURL, err := core.FetchBookmark(book.URL, renderPolicy)
 		if err != nil {
 			cError.Printf("Failed to download: %v\n", err)
 		}
 
+		book.LastStatusCode = statusCode
+		book.WaybackURL = waybackURL
+
 		if err == nil && content != nil {
 			request := core.ProcessRequest{
-				DataDir:     dataDir,
-				Bookmark:    book,
-				Content:     content,
-				ContentType: contentType,
-				LogArchival: logArchival,
-				KeepTitle:   title != "",
-				KeepExcerpt: excerpt != "",
+				DataDir:        dataDir,
+				Bookmark:       book,
+				Content:        content,
+				ContentType:    contentType,
+				LogArchival:    logArchival,
+				KeepTitle:      title != "",
+				KeepExcerpt:    excerpt != "",
+				MaxResources:   maxResources,
+				MaxSnapshots:   maxSnapshots,
+				RenderPolicy:   renderPolicy,
+				ArchivalPolicy: archivalPolicy,
 			}
 
 			book, isFatalErr, err = core.ProcessBookmark(request)
@@ -99,6 +117,10 @@ func addHandler(cmd *cobra.Command, args []string) {
 				cError.Printf("Failed: %v\n", err)
 			}
 
+			for _, warning := range book.Warnings {
+				cError.Printf("Warning: %s\n", warning)
+			}
+
 			if isFatalErr {
 				os.Exit(1)
 			}
@@ -106,9 +128,7 @@ func addHandler(cmd *cobra.Command, args []string) {
 	}
 
 	// Make sure bookmark's title not empty
-	if book.Title == "" {
-		book.Title = book.URL
-	}
+	core.EnsureTitle(&book)
 
 	// Save bookmark to database
 	_, err = db.SaveBookmarks(book)
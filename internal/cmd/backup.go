@@ -0,0 +1,148 @@
+package cmd
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	fp "path/filepath"
+	"time"
+
+	"shiori/internal/core"
+	"shiori/internal/database"
+	"github.com/spf13/cobra"
+)
+
+// annotationNoDatabase marks command that must run before the database is
+// opened, e.g. since it replaces the database file.
+const annotationNoDatabase = "noDatabase"
+
+func backupCmd() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "backup target-file",
+		Short: "Back up database and archives into a single file",
+		Long: "Back up the database, thumbnails and archives into gzipped tar file, " +
+			"which can be restored using restore command. MySQL and PostgreSQL databases " +
+			"can't be included, so they must be backed up using their own dump tool, " +
+			"then backed up with --skip-database for the rest.",
+		Args: cobra.ExactArgs(1),
+		Run:  backupHandler,
+	}
+
+	cmd.Flags().Bool("skip-database", false, "Back up only thumbnails and archives when the database can't be included")
+
+	return cmd
+}
+
+func backupHandler(cmd *cobra.Command, args []string) {
+	skipDatabase, _ := cmd.Flags().GetBool("skip-database")
+
+	// Copy the database, so it's consistent even if it's being used
+	tmpDir, err := ioutil.TempDir("", "shiori-backup-")
+	if err != nil {
+		cError.Printf("Failed to create temporary directory: %v\n", err)
+		os.Exit(1)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	dbPath := fp.Join(tmpDir, "shiori.db")
+	err = db.Backup(dbPath)
+	if err == database.ErrBackupUnsupported && skipDatabase {
+		cError.Println("Database is not included, back it up using its own dump tool")
+		dbPath = ""
+	} else if err == database.ErrBackupUnsupported {
+		cError.Println("Database can't be backed up, back it up using its own dump tool, " +
+			"then use --skip-database to back up the rest")
+		os.Exit(1)
+	} else if err != nil {
+		cError.Printf("Failed to back up database: %v\n", err)
+		os.Exit(1)
+	}
+
+	// Write the backup
+	dstFile, err := os.Create(args[0])
+	if err != nil {
+		cError.Printf("Failed to create backup file: %v\n", err)
+		os.Exit(1)
+	}
+	defer dstFile.Close()
+
+	manifest := core.BackupManifest{
+		Version: version,
+		Created: time.Now().UTC().Format(time.RFC3339),
+	}
+
+	if err = core.WriteBackup(dstFile, dataDir, dbPath, manifest); err == nil {
+		err = dstFile.Sync()
+	}
+
+	if err != nil {
+		cError.Printf("Failed to write backup: %v\n", err)
+		os.Exit(1)
+	}
+
+	fmt.Println("Backup finished")
+}
+
+func restoreCmd() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "restore source-file",
+		Short: "Restore database and archives from backup file",
+		Long: "Restore the database, thumbnails and archives from file created by backup command. " +
+			"The current database and archives in data dir are replaced, so make sure " +
+			"Shiori is not running while they're restored.",
+		Args:        cobra.ExactArgs(1),
+		Annotations: map[string]string{annotationNoDatabase: "true"},
+		Run:         restoreHandler,
+	}
+
+	cmd.Flags().BoolP("yes", "y", false, "Skip confirmation prompt and replace the current data")
+
+	return cmd
+}
+
+func restoreHandler(cmd *cobra.Command, args []string) {
+	// Parse flags
+	skipConfirm, _ := cmd.Flags().GetBool("yes")
+
+	// Open backup file
+	srcFile, err := os.Open(args[0])
+	if err != nil {
+		cError.Printf("Failed to open %s: %v\n", args[0], err)
+		os.Exit(1)
+	}
+	defer srcFile.Close()
+
+	// Confirm to user, since the current data will be lost
+	if !skipConfirm {
+		confirmRestore := ""
+		fmt.Printf("Replace database and archives in %s? (y/N): ", dataDir)
+		fmt.Scanln(&confirmRestore)
+
+		if confirmRestore != "y" {
+			fmt.Println("Nothing restored")
+			return
+		}
+	}
+
+	// Restore the backup
+	manifest, err := core.RestoreBackup(srcFile, dataDir)
+	if err != nil {
+		cError.Printf("Failed to restore backup: %v\n", err)
+		os.Exit(1)
+	}
+
+	if !manifest.Database {
+		cError.Println("Backup doesn't include database, restore it using its own dump tool")
+	} else if dbms, _ := os.LookupEnv("SHIORI_DBMS"); dbms == "mysql" || dbms == "postgresql" {
+		cError.Printf("Backup includes SQLite database, which is not used with %s\n", dbms)
+	}
+
+	// Open the restored database, so it's migrated to the current version
+	db, err = openDatabase()
+	if err != nil {
+		cError.Printf("Failed to open restored database: %v\n", err)
+		os.Exit(1)
+	}
+
+	fmt.Printf("Backup created by Shiori %s at %s restored\n", manifest.Version, manifest.Created)
+}
@@ -0,0 +1,123 @@
+package cmd
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"strings"
+
+	"shiori/internal/core"
+	"shiori/internal/model"
+	"github.com/spf13/cobra"
+)
+
+func captureCmd() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "capture source-file...",
+		Short: "Import pages captured as WARC or MHTML files as bookmarks",
+		Long: "Import pages that captured by other tool as WARC or MHTML files. " +
+			"Each page becomes a bookmark, which is archived as single-file archive " +
+			"from the captured resources, without downloading the page again.",
+		Args: cobra.MinimumNArgs(1),
+		Run:  captureHandler,
+	}
+
+	cmd.Flags().StringSliceP("tags", "t", []string{}, "Comma-separated tags for the imported bookmarks")
+
+	return cmd
+}
+
+func captureHandler(cmd *cobra.Command, args []string) {
+	tags, _ := cmd.Flags().GetStringSlice("tags")
+
+	bookmarks := []model.Bookmark{}
+	for _, srcPath := range args {
+		book, err := importCapture(srcPath, tags)
+		if err != nil {
+			cError.Printf("Failed to import %s: %v\n", srcPath, err)
+			continue
+		}
+
+		for _, warning := range book.Warnings {
+			cError.Printf("Warning: %s\n", warning)
+		}
+
+		bookmarks = append(bookmarks, book)
+	}
+
+	if len(bookmarks) == 0 {
+		os.Exit(1)
+	}
+
+	// Print imported bookmarks
+	fmt.Println()
+	printBookmarks(bookmarks...)
+}
+
+// importCapture saves the page captured in file at srcPath as bookmark.
+func importCapture(srcPath string, tags []string) (model.Bookmark, error) {
+	srcFile, err := os.Open(srcPath)
+	if err != nil {
+		return model.Bookmark{}, err
+	}
+	defer srcFile.Close()
+
+	capture, err := core.ParseCapture(srcFile)
+	if err != nil {
+		return model.Bookmark{}, err
+	}
+
+	capture.Content, err = core.SanitizeHTML(bytes.NewReader(capture.Content))
+	if err != nil {
+		return model.Bookmark{}, fmt.Errorf("invalid captured html: %v", err)
+	}
+
+	url, err := core.RemoveUTMParams(capture.URL, keptQueryParams)
+	if err != nil {
+		return model.Bookmark{}, fmt.Errorf("failed to clean URL: %v", err)
+	}
+
+	for _, equivalentURL := range core.EquivalentURLs(url) {
+		if _, exist := db.GetBookmark(0, equivalentURL); exist {
+			return model.Bookmark{}, fmt.Errorf("URL %s already exists", url)
+		}
+	}
+
+	book := model.Bookmark{
+		URL:           url,
+		CreateArchive: true,
+		ArchiveFormat: core.ArchiveFormatSingleFile,
+	}
+
+	for _, tag := range tags {
+		book.Tags = append(book.Tags, model.Tag{Name: strings.TrimSpace(tag)})
+	}
+
+	book.ID, err = db.CreateNewID("bookmark")
+	if err != nil {
+		return book, fmt.Errorf("failed to create ID: %v", err)
+	}
+
+	request := core.ProcessRequest{
+		DataDir:        dataDir,
+		Bookmark:       book,
+		Content:        bytes.NewReader(capture.Content),
+		ContentType:    capture.ContentType,
+		ArchivalPolicy: archivalPolicy,
+		Capture:        capture,
+	}
+
+	book, _, err = core.ProcessBookmark(request)
+	if err != nil {
+		return book, err
+	}
+
+	core.EnsureTitle(&book)
+
+	results, err := db.SaveBookmarks(book)
+	if err != nil || len(results) == 0 {
+		return book, fmt.Errorf("failed to save bookmark: %v", err)
+	}
+
+	return results[0], nil
+}
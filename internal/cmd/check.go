@@ -69,7 +69,7 @@ func checkHandler(cmd *cobra.Command, args []string) {
 	chDone := make(chan struct{})
 	chProblem := make(chan int, 10)
 	chMessage := make(chan interface{}, 10)
-	semaphore := make(chan struct{}, 10)
+	semaphore := make(chan struct{}, concurrency)
 
 	for i, book := range bookmarks {
 		wg.Add(1)
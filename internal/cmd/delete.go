@@ -4,9 +4,9 @@ import (
 	"fmt"
 	"os"
 	fp "path/filepath"
-	"strconv"
 	"strings"
 
+	"shiori/internal/core"
 	"github.com/spf13/cobra"
 )
 
@@ -52,7 +52,7 @@ func deleteHandler(cmd *cobra.Command, args []string) {
 	}
 
 	// Delete bookmarks from database
-	err = db.DeleteBookmarks(ids...)
+	nDeleted, err := db.DeleteBookmarks(ids...)
 	if err != nil {
 		cError.Printf("Failed to delete bookmarks: %v\n", err)
 		os.Exit(1)
@@ -62,26 +62,29 @@ func deleteHandler(cmd *cobra.Command, args []string) {
 	if len(ids) == 0 {
 		thumbDir := fp.Join(dataDir, "thumb")
 		archiveDir := fp.Join(dataDir, "archive")
+		snapshotDir := fp.Join(dataDir, "snapshot")
+		screenshotDir := fp.Join(dataDir, "screenshot")
+		pdfDir := fp.Join(dataDir, "pdf")
+		singleFileDir := fp.Join(dataDir, "singlefile")
 		os.RemoveAll(thumbDir)
 		os.RemoveAll(archiveDir)
+		os.RemoveAll(snapshotDir)
+		os.RemoveAll(screenshotDir)
+		os.RemoveAll(pdfDir)
+		os.RemoveAll(singleFileDir)
 	} else {
 		for _, id := range ids {
-			strID := strconv.Itoa(id)
-			imgPath := fp.Join(dataDir, "thumb", strID)
-			archivePath := fp.Join(dataDir, "archive", strID)
-
-			os.Remove(imgPath)
-			os.Remove(archivePath)
+			core.RemoveBookmarkArtifacts(dataDir, id)
 		}
 	}
 
 	// Show finish message
 	switch len(args) {
 	case 0:
-		fmt.Println("All bookmarks have been deleted")
+		fmt.Printf("All %d bookmark(s) have been deleted\n", nDeleted)
 	case 1, 2, 3, 4, 5:
 		fmt.Printf("Bookmark(s) %s have been deleted\n", strings.Join(args, ", "))
 	default:
-		fmt.Println("Bookmark(s) have been deleted")
+		fmt.Printf("%d bookmark(s) have been deleted\n", nDeleted)
 	}
 }
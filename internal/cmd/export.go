@@ -4,9 +4,8 @@ import (
 	"fmt"
 	"os"
 	fp "path/filepath"
-	"strings"
-	"time"
 
+	"shiori/internal/core"
 	"shiori/internal/database"
 	"github.com/spf13/cobra"
 )
@@ -48,38 +47,23 @@ func exportHandler(cmd *cobra.Command, args []string) {
 	defer dstFile.Close()
 
 	// Write exported bookmark to file
-	fmt.Fprintln(dstFile, ``+
-		`<!DOCTYPE NETSCAPE-Bookmark-file-1>`+
-		`<META HTTP-EQUIV="Content-Type" CONTENT="text/html; charset=UTF-8">`+
-		`<TITLE>Bookmarks</TITLE>`+
-		`<H1>Bookmarks</H1>`+
-		`<DL>`)
-
-	for _, book := range bookmarks {
-		// Create Unix timestamp for bookmark
-		modifiedTime, err := time.Parse("2006-01-02 15:04:05", book.Modified)
-		if err != nil {
-			modifiedTime = time.Now()
-		}
-		unixTimestamp := modifiedTime.Unix()
-
-		// Create tags for bookmarks
-		tags := []string{}
-		for _, tag := range book.Tags {
-			tags = append(tags, tag.Name)
+	writer, err := core.NewNetscapeWriter(dstFile)
+	if err == nil {
+		for _, book := range bookmarks {
+			if err = writer.Write(book); err != nil {
+				break
+			}
 		}
-		strTags := strings.Join(tags, ",")
-
-		// Make sure title is valid
-		book.Title = validateTitle(book.Title, book.URL)
+	}
 
-		// Write to file
-		exportLine := fmt.Sprintf(`<DT><A HREF="%s" ADD_DATE="%d" LAST_MODIFIED="%d" TAGS="%s">%s</A>`,
-			book.URL, unixTimestamp, unixTimestamp, strTags, book.Title)
-		fmt.Fprintln(dstFile, exportLine)
+	if err == nil {
+		err = writer.Close()
 	}
 
-	fmt.Fprintln(dstFile, "</DL>")
+	if err != nil {
+		cError.Printf("Failed to export the bookmarks: %v\n", err)
+		os.Exit(1)
+	}
 
 	// Flush data to storage
 	err = dstFile.Sync()
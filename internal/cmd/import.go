@@ -3,6 +3,7 @@ package cmd
 import (
 	"fmt"
 	"os"
+	"strconv"
 	"strings"
 
 	"github.com/PuerkitoBio/goquery"
@@ -20,6 +21,7 @@ func importCmd() *cobra.Command {
 	}
 
 	cmd.Flags().BoolP("generate-tag", "t", false, "Auto generate tag from bookmark's category")
+	cmd.Flags().Bool("keep-folders", false, "Keep bookmark's folders as collections")
 
 	return cmd
 }
@@ -27,6 +29,7 @@ func importCmd() *cobra.Command {
 func importHandler(cmd *cobra.Command, args []string) {
 	// Parse flags
 	generateTag := cmd.Flags().Changed("generate-tag")
+	keepFolders, _ := cmd.Flags().GetBool("keep-folders")
 
 	// If user doesn't specify, ask if tag need to be generated
 	if !generateTag {
@@ -37,13 +40,6 @@ func importHandler(cmd *cobra.Command, args []string) {
 		generateTag = submit == "y"
 	}
 
-	// Prepare bookmark's ID
-	bookID, err := db.CreateNewID("bookmark")
-	if err != nil {
-		cError.Printf("Failed to create ID: %v\n", err)
-		os.Exit(1)
-	}
-
 	// Open bookmark's file
 	srcFile, err := os.Open(args[0])
 	if err != nil {
@@ -55,6 +51,7 @@ func importHandler(cmd *cobra.Command, args []string) {
 	// Parse bookmark's file
 	bookmarks := []model.Bookmark{}
 	mapURL := make(map[string]struct{})
+	report := importReport{}
 
 	doc, err := goquery.NewDocumentFromReader(srcFile)
 	if err != nil {
@@ -62,6 +59,23 @@ func importHandler(cmd *cobra.Command, args []string) {
 		os.Exit(1)
 	}
 
+	// Existing collections are reused, so the folders won't be duplicated
+	// when the same file is imported again
+	collectionIDs := map[string]int{}
+	if keepFolders {
+		collections, err := db.GetCollections(0)
+		if err != nil {
+			cError.Printf("Failed to get collections: %v\n", err)
+			os.Exit(1)
+		}
+
+		for _, collection := range collections {
+			if collection.OwnerID == 0 {
+				collectionIDs[collectionKey(collection.ParentID, collection.Name)] = collection.ID
+			}
+		}
+	}
+
 	doc.Find("dt>a").Each(func(_ int, a *goquery.Selection) {
 		// Get related elements
 		dt := a.Parent()
@@ -70,29 +84,29 @@ func importHandler(cmd *cobra.Command, args []string) {
 
 		// Get metadata
 		title := a.Text()
-		url, _ := a.Attr("href")
+		rawURL, _ := a.Attr("href")
 		strTags, _ := a.Attr("tags")
+		strCreated, _ := a.Attr("add_date")
 
 		// Clean up URL
-		var err error
-		url, err = core.RemoveUTMParams(url)
+		url, err := core.RemoveUTMParams(rawURL, keptQueryParams)
 		if err != nil {
-			cError.Printf("Skip %s: URL is not valid\n", url)
+			report.fail(rawURL, "URL is not valid")
 			return
 		}
 
 		// Make sure title is valid Utf-8
-		title = validateTitle(title, url)
+		title = core.CleanTitle(title, url)
 
 		// Check if the URL already exist before, both in bookmark
 		// file or in database
 		if _, exist := mapURL[url]; exist {
-			cError.Printf("Skip %s: URL already exists\n", url)
+			report.skip(rawURL, "URL is duplicated in the file")
 			return
 		}
 
 		if _, exist := db.GetBookmark(0, url); exist {
-			cError.Printf("Skip %s: URL already exists\n", url)
+			report.skip(rawURL, "URL already exists")
 			mapURL[url] = struct{}{}
 			return
 		}
@@ -115,25 +129,122 @@ func importHandler(cmd *cobra.Command, args []string) {
 
 		// Add item to list
 		bookmark := model.Bookmark{
-			ID:    bookID,
-			URL:   url,
-			Title: title,
-			Tags:  tags,
+			URL:     url,
+			Title:   title,
+			Created: parseUnixTime(strCreated),
+			Tags:    tags,
+		}
+
+		// Put the bookmark in collection of its folders (if necessary)
+		if folders := bookmarkFolders(a); keepFolders && len(folders) > 0 {
+			bookmark.CollectionID, err = importCollection(folders, collectionIDs)
+			if err != nil {
+				report.fail(rawURL, "failed to create collection: "+err.Error())
+				return
+			}
 		}
 
-		bookID++
 		mapURL[url] = struct{}{}
 		bookmarks = append(bookmarks, bookmark)
 	})
 
-	// Save bookmark to database
-	bookmarks, err = db.SaveBookmarks(bookmarks...)
-	if err != nil {
-		cError.Printf("Failed to save bookmarks: %v\n", err)
-		os.Exit(1)
+	// Save bookmark to database, then print the imported ones
+	bookmarks = report.save(bookmarks)
+	report.print(bookmarks)
+}
+
+// bookmarkFolders returns names of the folders that contain the bookmark's
+// link, outermost first. Folder is declared by H3, followed by DL that
+// contains its bookmarks.
+func bookmarkFolders(a *goquery.Selection) []string {
+	folders := []string{}
+	a.ParentsFiltered("dl").Each(func(_ int, dl *goquery.Selection) {
+		name := normalizeSpace(dl.Parent().ChildrenFiltered("h3").First().Text())
+		if name != "" {
+			folders = append([]string{name}, folders...)
+		}
+	})
+
+	return folders
+}
+
+// collectionKey returns the key of collection in collectionIDs.
+func collectionKey(parentID int, name string) string {
+	return strconv.Itoa(parentID) + "/" + name
+}
+
+// importCollection returns ID of the collection for the imported folders,
+// outermost first, creating the ones that don't exist yet in collectionIDs.
+func importCollection(folders []string, collectionIDs map[string]int) (int, error) {
+	parentID := 0
+	for _, name := range folders {
+		key := collectionKey(parentID, name)
+		id, exist := collectionIDs[key]
+		if !exist {
+			collection, err := db.SaveCollection(model.Collection{
+				Name:     name,
+				ParentID: parentID,
+			})
+			if err != nil {
+				return 0, err
+			}
+
+			id = collection.ID
+			collectionIDs[key] = id
+		}
+
+		parentID = id
 	}
 
-	// Print imported bookmark
+	return parentID, nil
+}
+
+// importReport reports the result of each entry in imported file, so user
+// knows which entries are not imported and why. Entry that can't be
+// imported is skipped, without stopping the others.
+type importReport struct {
+	nSkipped int
+	nFailed  int
+}
+
+// skip reports entry that skipped, e.g. because its URL already exists.
+func (report *importReport) skip(input string, reason string) {
+	cError.Printf("Skipped %s: %s\n", input, reason)
+	report.nSkipped++
+}
+
+// fail reports entry that can't be imported.
+func (report *importReport) fail(input string, reason string) {
+	cError.Printf("Failed %s: %s\n", input, reason)
+	report.nFailed++
+}
+
+// save saves the imported bookmarks at once. If it failed, they're saved
+// one by one, so only the problematic ones are lost. Returns the bookmarks
+// that saved successfully.
+func (report *importReport) save(bookmarks []model.Bookmark) []model.Bookmark {
+	if saved, err := db.SaveBookmarks(bookmarks...); err == nil {
+		return saved
+	}
+
+	result := []model.Bookmark{}
+	for _, book := range bookmarks {
+		saved, err := db.SaveBookmarks(book)
+		if err != nil {
+			report.fail(book.URL, err.Error())
+			continue
+		}
+
+		result = append(result, saved...)
+	}
+
+	return result
+}
+
+// print prints the imported bookmarks, followed by the number of entries
+// by their result.
+func (report *importReport) print(imported []model.Bookmark) {
 	fmt.Println()
-	printBookmarks(bookmarks...)
+	printBookmarks(imported...)
+	fmt.Printf("Imported %d, skipped %d, failed %d\n", len(imported), report.nSkipped, report.nFailed)
 }
@@ -0,0 +1,45 @@
+package cmd
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/PuerkitoBio/goquery"
+)
+
+func Test_bookmarkFolders(t *testing.T) {
+	doc, err := goquery.NewDocumentFromReader(strings.NewReader(`<!DOCTYPE NETSCAPE-Bookmark-file-1>
+<TITLE>Bookmarks</TITLE>
+<H1>Bookmarks</H1>
+<DL><p>
+	<DT><A HREF="https://example.com/root">Root</A>
+	<DT><H3>Dev</H3>
+	<DL><p>
+		<DT><A HREF="https://example.com/dev">Dev</A>
+		<DT><H3> Go
+			Lang </H3>
+		<DL><p>
+			<DT><A HREF="https://example.com/go">Go</A>
+		</DL><p>
+		<DT><A HREF="https://example.com/after">After</A>
+	</DL><p>
+</DL><p>`))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := map[string][]string{
+		"https://example.com/root":  {},
+		"https://example.com/dev":   {"Dev"},
+		"https://example.com/go":    {"Dev", "Go Lang"},
+		"https://example.com/after": {"Dev"},
+	}
+
+	doc.Find("dt>a").Each(func(_ int, a *goquery.Selection) {
+		url, _ := a.Attr("href")
+		if got := bookmarkFolders(a); !reflect.DeepEqual(got, want[url]) {
+			t.Errorf("bookmarkFolders(%s) = %v, want %v", url, got, want[url])
+		}
+	})
+}
@@ -0,0 +1,114 @@
+package cmd
+
+import (
+	"os"
+	"strconv"
+
+	"shiori/internal/core"
+	"shiori/internal/model"
+	"github.com/spf13/cobra"
+)
+
+func instapaperCmd() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "instapaper source-file",
+		Short: "Import bookmarks from Instapaper's exported CSV file",
+		Long: "Import bookmarks from CSV file exported by Instapaper. The folders " +
+			"are kept as tags and the selections as excerpts, while the archived " +
+			"items are marked as read and the starred ones are starred.",
+		Args: cobra.ExactArgs(1),
+		Run:  instapaperHandler,
+	}
+
+	return cmd
+}
+
+func instapaperHandler(cmd *cobra.Command, args []string) {
+	// Open instapaper's file
+	srcFile, err := os.Open(args[0])
+	if err != nil {
+		cError.Println(err)
+		os.Exit(1)
+	}
+	defer srcFile.Close()
+
+	// Parse instapaper's file
+	bookmarks := []model.Bookmark{}
+	mapURL := make(map[string]struct{})
+	report := importReport{}
+
+	err = core.ParseInstapaperBookmarks(srcFile, func(item core.InstapaperBookmark) error {
+		// Clean up URL
+		url, err := core.RemoveUTMParams(item.URL, keptQueryParams)
+		if err != nil {
+			report.fail(item.URL, "URL is not valid")
+			return nil
+		}
+
+		// Make sure title is valid Utf-8
+		title := core.CleanTitle(item.Title, url)
+
+		// Check if the URL already exist before, both in bookmark
+		// file or in database
+		if _, exist := mapURL[url]; exist {
+			report.skip(item.URL, "URL is duplicated in the file")
+			return nil
+		}
+
+		if _, exist := db.GetBookmark(0, url); exist {
+			report.skip(item.URL, "URL already exists")
+			mapURL[url] = struct{}{}
+			return nil
+		}
+
+		// Use the folder as tag
+		tags := []model.Tag{}
+		if item.Folder != "" {
+			tags = append(tags, model.Tag{Name: item.Folder})
+		}
+
+		// Add item to list
+		bookmark := model.Bookmark{
+			URL:     url,
+			Title:   title,
+			Excerpt: item.Selection,
+			Created: parseUnixTime(strconv.FormatInt(item.AddDate, 10)),
+			Tags:    tags,
+			Read:    item.Read,
+			Starred: item.Starred,
+		}
+
+		mapURL[url] = struct{}{}
+		bookmarks = append(bookmarks, bookmark)
+		return nil
+	})
+
+	if err != nil {
+		cError.Println(err)
+		os.Exit(1)
+	}
+
+	// Save bookmark to database
+	bookmarks = report.save(bookmarks)
+
+	// Keep the status of bookmarks, which isn't saved together with them
+	readIDs := []int{}
+	for _, book := range bookmarks {
+		if book.Read {
+			readIDs = append(readIDs, book.ID)
+		}
+
+		if book.Starred {
+			if err = db.SetBookmarkStarred(book.ID, true); err != nil {
+				cError.Printf("Failed to star %s: %v\n", book.URL, err)
+			}
+		}
+	}
+
+	if _, err = db.SetBookmarksRead(readIDs, true); err != nil {
+		cError.Printf("Failed to mark bookmarks as read: %v\n", err)
+	}
+
+	// Print imported bookmark
+	report.print(bookmarks)
+}
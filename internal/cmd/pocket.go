@@ -1,13 +1,9 @@
 package cmd
 
 import (
-	"fmt"
 	"os"
 	"strconv"
-	"strings"
-	"time"
 
-	"github.com/PuerkitoBio/goquery"
 	"shiori/internal/core"
 	"shiori/internal/model"
 	"github.com/spf13/cobra"
@@ -16,22 +12,18 @@ import (
 func pocketCmd() *cobra.Command {
 	cmd := &cobra.Command{
 		Use:   "pocket source-file",
-		Short: "Import bookmarks from Pocket's exported HTML file",
-		Args:  cobra.ExactArgs(1),
-		Run:   pocketHandler,
+		Short: "Import bookmarks from Pocket's exported HTML or CSV file",
+		Long: "Import bookmarks from file exported by Pocket, either in HTML or CSV. " +
+			"The tags and the time they're added are kept, while the archived items " +
+			"are marked as read and the favorite ones are starred.",
+		Args: cobra.ExactArgs(1),
+		Run:  pocketHandler,
 	}
 
 	return cmd
 }
 
 func pocketHandler(cmd *cobra.Command, args []string) {
-	// Prepare bookmark's ID
-	bookID, err := db.CreateNewID("bookmark")
-	if err != nil {
-		cError.Printf("Failed to create ID: %v\n", err)
-		return
-	}
-
 	// Open pocket's file
 	srcFile, err := os.Open(args[0])
 	if err != nil {
@@ -43,76 +35,79 @@ func pocketHandler(cmd *cobra.Command, args []string) {
 	// Parse pocket's file
 	bookmarks := []model.Bookmark{}
 	mapURL := make(map[string]struct{})
+	report := importReport{}
 
-	doc, err := goquery.NewDocumentFromReader(srcFile)
-	if err != nil {
-		cError.Println(err)
-		os.Exit(1)
-	}
-
-	doc.Find("a").Each(func(_ int, a *goquery.Selection) {
-		// Get metadata
-		title := a.Text()
-		url, _ := a.Attr("href")
-		strTags, _ := a.Attr("tags")
-		strModified, _ := a.Attr("time_added")
-		intModified, _ := strconv.ParseInt(strModified, 10, 64)
-		modified := time.Unix(intModified, 0)
-
+	err = core.ParsePocketBookmarks(srcFile, func(item core.PocketBookmark) error {
 		// Clean up URL
-		var err error
-		url, err = core.RemoveUTMParams(url)
+		url, err := core.RemoveUTMParams(item.URL, keptQueryParams)
 		if err != nil {
-			cError.Printf("Skip %s: URL is not valid\n", url)
-			return
+			report.fail(item.URL, "URL is not valid")
+			return nil
 		}
 
 		// Make sure title is valid Utf-8
-		title = validateTitle(title, url)
+		title := core.CleanTitle(item.Title, url)
 
 		// Check if the URL already exist before, both in bookmark
 		// file or in database
 		if _, exist := mapURL[url]; exist {
-			cError.Printf("Skip %s: URL already exists\n", url)
-			return
+			report.skip(item.URL, "URL is duplicated in the file")
+			return nil
 		}
 
 		if _, exist := db.GetBookmark(0, url); exist {
-			cError.Printf("Skip %s: URL already exists\n", url)
+			report.skip(item.URL, "URL already exists")
 			mapURL[url] = struct{}{}
-			return
+			return nil
 		}
 
 		// Get bookmark tags
 		tags := []model.Tag{}
-		for _, strTag := range strings.Split(strTags, ",") {
-			if strTag != "" {
-				tags = append(tags, model.Tag{Name: strTag})
-			}
+		for _, strTag := range item.Tags {
+			tags = append(tags, model.Tag{Name: strTag})
 		}
 
 		// Add item to list
 		bookmark := model.Bookmark{
-			ID:       bookID,
-			URL:      url,
-			Title:    title,
-			Modified: modified.Format("2006-01-02 15:04:05"),
-			Tags:     tags,
+			URL:     url,
+			Title:   title,
+			Created: parseUnixTime(strconv.FormatInt(item.AddDate, 10)),
+			Tags:    tags,
+			Read:    item.Read,
+			Starred: item.Favorite,
 		}
 
-		bookID++
 		mapURL[url] = struct{}{}
 		bookmarks = append(bookmarks, bookmark)
+		return nil
 	})
 
-	// Save bookmark to database
-	bookmarks, err = db.SaveBookmarks(bookmarks...)
 	if err != nil {
-		cError.Printf("Failed to save bookmarks: %v\n", err)
+		cError.Println(err)
 		os.Exit(1)
 	}
 
+	// Save bookmark to database
+	bookmarks = report.save(bookmarks)
+
+	// Keep the status of bookmarks, which isn't saved together with them
+	readIDs := []int{}
+	for _, book := range bookmarks {
+		if book.Read {
+			readIDs = append(readIDs, book.ID)
+		}
+
+		if book.Starred {
+			if err = db.SetBookmarkStarred(book.ID, true); err != nil {
+				cError.Printf("Failed to star %s: %v\n", book.URL, err)
+			}
+		}
+	}
+
+	if _, err = db.SetBookmarksRead(readIDs, true); err != nil {
+		cError.Printf("Failed to mark bookmarks as read: %v\n", err)
+	}
+
 	// Print imported bookmark
-	fmt.Println()
-	printBookmarks(bookmarks...)
+	report.print(bookmarks)
 }
@@ -27,6 +27,10 @@ func printCmd() *cobra.Command {
 	cmd.Flags().StringP("search", "s", "", "Search bookmark with specified keyword")
 	cmd.Flags().StringSliceP("tags", "t", []string{}, "Print bookmarks with matching tag(s)")
 	cmd.Flags().StringSliceP("exclude-tags", "e", []string{}, "Print bookmarks without these tag(s)")
+	cmd.Flags().String("created-after", "", "Print bookmarks added at or after this date (e.g. 2023-03-01) or RFC3339 time")
+	cmd.Flags().String("created-before", "", "Print bookmarks added before this date (e.g. 2023-04-01) or RFC3339 time")
+	cmd.Flags().String("modified-after", "", "Print bookmarks modified at or after this date or RFC3339 time")
+	cmd.Flags().String("modified-before", "", "Print bookmarks modified before this date or RFC3339 time")
 
 	return cmd
 }
@@ -40,6 +44,23 @@ func printHandler(cmd *cobra.Command, args []string) {
 	orderLatest, _ := cmd.Flags().GetBool("latest")
 	excludedTags, _ := cmd.Flags().GetStringSlice("exclude-tags")
 
+	// Parse date range
+	dateRange := map[string]string{}
+	for _, flag := range []string{"created-after", "created-before", "modified-after", "modified-before"} {
+		strTime, _ := cmd.Flags().GetString(flag)
+		if strTime == "" {
+			continue
+		}
+
+		t, err := parseTimeFlag(strTime)
+		if err != nil {
+			cError.Printf("Invalid --%s: %v\n", flag, err)
+			return
+		}
+
+		dateRange[flag] = t.UTC().Format("2006-01-02 15:04:05")
+	}
+
 	// Convert args to ids
 	ids, err := parseStrIndices(args)
 	if err != nil {
@@ -54,11 +75,15 @@ func printHandler(cmd *cobra.Command, args []string) {
 	}
 
 	searchOptions := database.GetBookmarksOptions{
-		IDs:          ids,
-		Tags:         tags,
-		ExcludedTags: excludedTags,
-		Keyword:      keyword,
-		OrderMethod:  orderMethod,
+		IDs:           ids,
+		Tags:          tags,
+		ExcludedTags:  excludedTags,
+		Keyword:       keyword,
+		CreatedSince:  dateRange["created-after"],
+		CreatedBefore: dateRange["created-before"],
+		UpdatedSince:  dateRange["modified-after"],
+		UpdatedBefore: dateRange["modified-before"],
+		OrderMethod:   orderMethod,
 	}
 
 	bookmarks, err := db.GetBookmarks(searchOptions)
@@ -71,7 +96,7 @@ func printHandler(cmd *cobra.Command, args []string) {
 		switch {
 		case len(ids) > 0:
 			cError.Println("No matching index found")
-		case keyword != "", len(tags) > 0:
+		case keyword != "", len(tags) > 0, len(dateRange) > 0:
 			cError.Println("No matching bookmarks found")
 		default:
 			cError.Println("No bookmarks saved yet")
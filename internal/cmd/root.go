@@ -4,9 +4,12 @@ import (
 	"fmt"
 	"os"
 	fp "path/filepath"
+	"time"
 
+	"shiori/internal/core"
 	"shiori/internal/database"
 	apppaths "github.com/muesli/go-app-paths"
+	"github.com/sirupsen/logrus"
 	"github.com/spf13/cobra"
 )
 
@@ -14,17 +17,45 @@ var (
 	db              database.DB
 	dataDir         string
 	developmentMode bool
+	concurrency     int
+	maxResources    int
+	maxSnapshots    int
+	archivalPolicy  core.ArchivalPolicy
+	renderPolicy    core.RenderPolicy
+	keptQueryParams core.KeptQueryParams
+)
+
+// Build information, populated at build time using ldflags, e.g.
+// -ldflags "-X shiori/internal/cmd.version=1.5.0 -X shiori/internal/cmd.commit=abc1234"
+var (
+	version   = "dev"
+	commit    = "unknown"
+	buildDate = "unknown"
 )
 
 // ShioriCmd returns the root command for shiori
 func ShioriCmd() *cobra.Command {
 	rootCmd := &cobra.Command{
-		Use:   "shiori",
-		Short: "Simple command-line bookmark manager built with Go",
+		Use:     "shiori",
+		Short:   "Simple command-line bookmark manager built with Go",
+		Version: version,
 	}
 
 	rootCmd.PersistentPreRun = preRunRootHandler
 	rootCmd.PersistentFlags().Bool("portable", false, "run shiori in portable mode")
+	rootCmd.PersistentFlags().Int("concurrency", 10, "max number of bookmarks that downloaded concurrently")
+	rootCmd.PersistentFlags().Int("max-archive-resources", 0, "max number of sub-resources archived for each bookmark, 0 means no limit")
+	rootCmd.PersistentFlags().Int("max-snapshots", 10, "max number of past archives kept for bookmark with versioned archive, 0 means no limit")
+	rootCmd.PersistentFlags().StringSlice("archive-allow", []string{}, "comma-separated domains that may be archived, all domains if empty")
+	rootCmd.PersistentFlags().StringSlice("archive-block", []string{}, "comma-separated domains that never archived")
+	rootCmd.PersistentFlags().Bool("render-all", false, "render every page in headless browser before processing it")
+	rootCmd.PersistentFlags().StringSlice("render-domain", []string{}, "comma-separated domains whose pages are rendered in headless browser before processing")
+	rootCmd.PersistentFlags().Duration("render-timeout", 30*time.Second, "max duration for rendering a page in headless browser")
+	rootCmd.PersistentFlags().Int("screenshot-width", 0, "viewport width of full-page screenshot captured in headless browser when page is archived, 0 means no screenshot")
+	rootCmd.PersistentFlags().Bool("wayback-fallback", false, "archive the closest snapshot in Wayback Machine when the page can't be downloaded or is not found")
+	rootCmd.PersistentFlags().StringSlice("keep-query-param", []string{}, "comma-separated domain=param pairs, the param is never removed from URL of that domain")
+	rootCmd.PersistentFlags().String("log-level", "info", "minimum level of logged messages, one of debug, info, warn or error")
+	rootCmd.PersistentFlags().String("log-format", "text", "format of logged messages, either text or json")
 	rootCmd.AddCommand(
 		addCmd(),
 		printCmd(),
@@ -32,10 +63,15 @@ func ShioriCmd() *cobra.Command {
 		deleteCmd(),
 		openCmd(),
 		importCmd(),
+		captureCmd(),
 		exportCmd(),
 		pocketCmd(),
+		instapaperCmd(),
 		serveCmd(),
 		checkCmd(),
+		tagCmd(),
+		backupCmd(),
+		restoreCmd(),
 	)
 
 	return rootCmd
@@ -45,6 +81,66 @@ func preRunRootHandler(cmd *cobra.Command, args []string) {
 	// Read flag
 	var err error
 	portableMode, _ := cmd.Flags().GetBool("portable")
+	concurrency, _ = cmd.Flags().GetInt("concurrency")
+	maxResources, _ = cmd.Flags().GetInt("max-archive-resources")
+	maxSnapshots, _ = cmd.Flags().GetInt("max-snapshots")
+	archivalPolicy.AllowedDomains, _ = cmd.Flags().GetStringSlice("archive-allow")
+	archivalPolicy.BlockedDomains, _ = cmd.Flags().GetStringSlice("archive-block")
+	renderPolicy.All, _ = cmd.Flags().GetBool("render-all")
+	renderPolicy.Domains, _ = cmd.Flags().GetStringSlice("render-domain")
+	renderPolicy.Timeout, _ = cmd.Flags().GetDuration("render-timeout")
+	renderPolicy.ScreenshotWidth, _ = cmd.Flags().GetInt("screenshot-width")
+	renderPolicy.WaybackFallback, _ = cmd.Flags().GetBool("wayback-fallback")
+	strKeptQueryParams, _ := cmd.Flags().GetStringSlice("keep-query-param")
+	logLevel, _ := cmd.Flags().GetString("log-level")
+	logFormat, _ := cmd.Flags().GetString("log-format")
+
+	// Configure logger first, so the rest is logged as requested
+	level, err := logrus.ParseLevel(logLevel)
+	if err != nil {
+		cError.Printf("Invalid --log-level: %v\n", err)
+		os.Exit(1)
+	}
+
+	formatter, err := newLogFormatter(logFormat)
+	if err != nil {
+		cError.Printf("Invalid --log-format: %v\n", err)
+		os.Exit(1)
+	}
+
+	logrus.SetLevel(level)
+	logrus.SetFormatter(formatter)
+
+	if concurrency < 1 {
+		cError.Println("Concurrency must be at least 1")
+		os.Exit(1)
+	}
+
+	if maxSnapshots < 0 {
+		cError.Println("Max snapshots must not be negative")
+		os.Exit(1)
+	}
+
+	if (renderPolicy.All || len(renderPolicy.Domains) > 0) && !core.RenderingSupported() {
+		cError.Println("Shiori is built without headless browser support, rebuild it with `-tags headless` to render pages")
+		os.Exit(1)
+	}
+
+	if renderPolicy.ScreenshotWidth < 0 {
+		cError.Println("Screenshot width must not be negative")
+		os.Exit(1)
+	}
+
+	if renderPolicy.ScreenshotWidth > 0 && !core.RenderingSupported() {
+		cError.Println("Shiori is built without headless browser support, rebuild it with `-tags headless` to capture screenshots")
+		os.Exit(1)
+	}
+
+	keptQueryParams, err = parseKeptQueryParams(strKeptQueryParams)
+	if err != nil {
+		cError.Printf("Invalid --keep-query-param: %v\n", err)
+		os.Exit(1)
+	}
 
 	// Get and create data dir
 	dataDir, err = getDataDir(portableMode)
@@ -59,7 +155,11 @@ func preRunRootHandler(cmd *cobra.Command, args []string) {
 		os.Exit(1)
 	}
 
-	// Open database
+	// Open database, unless the command needs it closed
+	if _, skip := cmd.Annotations[annotationNoDatabase]; skip {
+		return
+	}
+
 	db, err = openDatabase()
 	if err != nil {
 		cError.Printf("Failed to open database: %v\n", err)
@@ -117,23 +217,45 @@ func openSQLiteDatabase() (database.DB, error) {
 }
 
 func openMySQLDatabase() (database.DB, error) {
-	user, _ := os.LookupEnv("SHIORI_MYSQL_USER")
-	password, _ := os.LookupEnv("SHIORI_MYSQL_PASS")
-	dbName, _ := os.LookupEnv("SHIORI_MYSQL_NAME")
-	dbAddress, _ := os.LookupEnv("SHIORI_MYSQL_ADDRESS")
+	return database.OpenMySQLDatabase(mysqlConnString(os.LookupEnv))
+}
+
+// mysqlConnString returns the connection string for MySQL or MariaDB. The
+// full DSN can be set in SHIORI_MYSQL_CONN, e.g. to pass extra parameters
+// required by shared hosting. Otherwise it's built from the separate
+// SHIORI_MYSQL_* variables.
+func mysqlConnString(lookupEnv func(string) (string, bool)) string {
+	if connString, _ := lookupEnv("SHIORI_MYSQL_CONN"); connString != "" {
+		return connString
+	}
 
-	connString := fmt.Sprintf("%s:%s@%s/%s?charset=utf8mb4", user, password, dbAddress, dbName)
-	return database.OpenMySQLDatabase(connString)
+	user, _ := lookupEnv("SHIORI_MYSQL_USER")
+	password, _ := lookupEnv("SHIORI_MYSQL_PASS")
+	dbName, _ := lookupEnv("SHIORI_MYSQL_NAME")
+	dbAddress, _ := lookupEnv("SHIORI_MYSQL_ADDRESS")
+
+	return fmt.Sprintf("%s:%s@%s/%s?charset=utf8mb4", user, password, dbAddress, dbName)
 }
 
 func openPostgreSQLDatabase() (database.DB, error) {
-	host, _ := os.LookupEnv("SHIORI_PG_HOST")
-	port, _ := os.LookupEnv("SHIORI_PG_PORT")
-	user, _ := os.LookupEnv("SHIORI_PG_USER")
-	password, _ := os.LookupEnv("SHIORI_PG_PASS")
-	dbName, _ := os.LookupEnv("SHIORI_PG_NAME")
+	return database.OpenPGDatabase(pgConnString(os.LookupEnv))
+}
+
+// pgConnString returns the connection string for PostgreSQL. The full
+// connection string can be set in SHIORI_PG_CONN, either as URL or as
+// key=value pairs, e.g. to enable SSL. Otherwise it's built from the
+// separate SHIORI_PG_* variables with SSL disabled.
+func pgConnString(lookupEnv func(string) (string, bool)) string {
+	if connString, _ := lookupEnv("SHIORI_PG_CONN"); connString != "" {
+		return connString
+	}
+
+	host, _ := lookupEnv("SHIORI_PG_HOST")
+	port, _ := lookupEnv("SHIORI_PG_PORT")
+	user, _ := lookupEnv("SHIORI_PG_USER")
+	password, _ := lookupEnv("SHIORI_PG_PASS")
+	dbName, _ := lookupEnv("SHIORI_PG_NAME")
 
-	connString := fmt.Sprintf("host=%s port=%s user=%s password=%s dbname=%s sslmode=disable",
+	return fmt.Sprintf("host=%s port=%s user=%s password=%s dbname=%s sslmode=disable",
 		host, port, user, password, dbName)
-	return database.OpenPGDatabase(connString)
 }
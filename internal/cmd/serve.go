@@ -1,8 +1,12 @@
 package cmd
 
 import (
+	"net/url"
+	fp "path/filepath"
 	"strings"
+	"time"
 
+	"shiori/internal/core"
 	"shiori/internal/webserver"
 	"github.com/sirupsen/logrus"
 	"github.com/spf13/cobra"
@@ -21,6 +25,46 @@ func serveCmd() *cobra.Command {
 	cmd.Flags().IntP("port", "p", 8080, "Port used by the server")
 	cmd.Flags().StringP("address", "a", "", "Address the server listens to")
 	cmd.Flags().StringP("webroot", "r", "/", "Root path that used by server")
+	cmd.Flags().Int64("max-body-size", 1<<20, "Max size in bytes of request body for API")
+	cmd.Flags().Int64("max-upload-size", 32<<20, "Max size in bytes of request body for import and extension API")
+	cmd.Flags().Bool("strict-json", false, "Reject API request that contains unknown JSON fields")
+	cmd.Flags().Bool("strict-processing", false, "Don't save new bookmark when its content failed to be processed")
+	cmd.Flags().Bool("archive-on-insert", false, "Archive new bookmark by default, unless the insert request disables it")
+	cmd.Flags().IntSlice("fail-on-status", []int{}, "Comma-separated HTTP status codes (e.g. 404,410) that make new bookmark rejected when its page returns one of them")
+	cmd.Flags().Int("archive-limit", 5, "Max number of bookmarks to update with archival in a single API request")
+	cmd.Flags().Int("max-page-size", 100, "Max number of bookmarks in each page that API client may request, 0 means no limit")
+	cmd.Flags().Int("insert-quota", 0, "Max number of bookmarks each client may insert per hour, 0 means unlimited")
+	cmd.Flags().Int("archival-quota", 0, "Max number of archival each client may run at the same time, 0 means unlimited")
+	cmd.Flags().Int("archive-max-age", 0, "Prune archives older than this many days, 0 means never")
+	cmd.Flags().Int64("archive-max-size", 0, "Prune the oldest archives when their total size in MB exceeds this, 0 means never")
+	cmd.Flags().Duration("prune-interval", time.Hour, "Interval between archive pruning")
+	cmd.Flags().Duration("snapshot-interval", 0, "Interval between archiving again bookmarks with versioned archive, 0 means never")
+	cmd.Flags().Duration("link-check-interval", 0, "Interval between checking whether bookmark URLs are still reachable, 0 means never")
+	cmd.Flags().String("rearchive-schedule", "", "Cron expression when bookmarks are archived again, e.g. \"0 3 * * 0\" or @weekly, empty means never")
+	cmd.Flags().StringSlice("rearchive-tag", []string{}, "Comma-separated tags whose bookmarks are archived again on schedule, all bookmarks if empty")
+	cmd.Flags().Bool("read-only", false, "Start in read-only mode, which rejects every change until disabled in maintenance API")
+	cmd.Flags().String("tls-cert", "", "Path to TLS certificate file, enables HTTPS when used with --tls-key")
+	cmd.Flags().String("tls-key", "", "Path to TLS private key file, enables HTTPS when used with --tls-cert")
+	cmd.Flags().StringSlice("acme-domain", []string{}, "Comma-separated domains to obtain certificates for via ACME (Let's Encrypt)")
+	cmd.Flags().String("acme-email", "", "Contact email registered with the ACME provider")
+	cmd.Flags().String("acme-cache", "", "Directory for caching ACME certificates, default to autocert dir inside data dir")
+	cmd.Flags().String("redirect-http", "", "Address for plain HTTP listener that redirects to HTTPS (e.g. :80)")
+	cmd.Flags().String("ldap-url", "", "URL of LDAP or Active Directory server that used to authenticate accounts (e.g. ldaps://ldap.example.com)")
+	cmd.Flags().String("ldap-bind-dn", "", "DN of service account for searching users in LDAP, anonymous search is used if not specified")
+	cmd.Flags().String("ldap-bind-password", "", "Password of LDAP service account")
+	cmd.Flags().String("ldap-base-dn", "", "DN where users are searched in LDAP")
+	cmd.Flags().String("ldap-user-filter", "(uid=%s)", "Filter for searching user in LDAP, %s is replaced by the username")
+	cmd.Flags().String("ldap-owner-group", "", "DN of LDAP group whose members have owner role")
+	cmd.Flags().String("oidc-issuer", "", "Issuer URL of OpenID Connect provider that accounts may log in with (e.g. https://auth.example.com)")
+	cmd.Flags().String("oidc-client-id", "", "Client ID that registered in OpenID Connect provider")
+	cmd.Flags().String("oidc-client-secret", "", "Client secret that registered in OpenID Connect provider")
+	cmd.Flags().String("oidc-redirect-url", "", "Callback URL that registered in OpenID Connect provider (e.g. https://shiori.example.com/auth/oidc/callback)")
+	cmd.Flags().String("oidc-username-claim", "preferred_username", "Claim of ID token that links the user to the account with the same username, e.g. email")
+	cmd.Flags().Int("login-max-failures", 10, "Number of failed logins in a row that lock the username, 0 means never locked")
+	cmd.Flags().Duration("login-lockout", 15*time.Minute, "How long the username is locked after too many failed logins")
+	cmd.Flags().StringSlice("webhook-url", []string{}, "Comma-separated URLs that notified when bookmarks are created, updated, deleted or archived")
+	cmd.Flags().String("webhook-secret", "", "Secret for signing webhook payloads, sent as HMAC-SHA256 in X-Shiori-Signature header")
+	cmd.Flags().Duration("shutdown-timeout", time.Minute, "How long to wait for running requests and archival once asked to shut down")
 
 	return cmd
 }
@@ -30,6 +74,46 @@ func serveHandler(cmd *cobra.Command, args []string) {
 	port, _ := cmd.Flags().GetInt("port")
 	address, _ := cmd.Flags().GetString("address")
 	rootPath, _ := cmd.Flags().GetString("webroot")
+	maxBodySize, _ := cmd.Flags().GetInt64("max-body-size")
+	maxUploadSize, _ := cmd.Flags().GetInt64("max-upload-size")
+	strictJSON, _ := cmd.Flags().GetBool("strict-json")
+	strictProcess, _ := cmd.Flags().GetBool("strict-processing")
+	archiveOnInsert, _ := cmd.Flags().GetBool("archive-on-insert")
+	failOnStatus, _ := cmd.Flags().GetIntSlice("fail-on-status")
+	archiveLimit, _ := cmd.Flags().GetInt("archive-limit")
+	maxPageSize, _ := cmd.Flags().GetInt("max-page-size")
+	insertQuota, _ := cmd.Flags().GetInt("insert-quota")
+	archivalQuota, _ := cmd.Flags().GetInt("archival-quota")
+	archiveMaxAge, _ := cmd.Flags().GetInt("archive-max-age")
+	archiveMaxSize, _ := cmd.Flags().GetInt64("archive-max-size")
+	pruneInterval, _ := cmd.Flags().GetDuration("prune-interval")
+	snapshotInterval, _ := cmd.Flags().GetDuration("snapshot-interval")
+	linkCheckInterval, _ := cmd.Flags().GetDuration("link-check-interval")
+	rearchiveSchedule, _ := cmd.Flags().GetString("rearchive-schedule")
+	rearchiveTags, _ := cmd.Flags().GetStringSlice("rearchive-tag")
+	readOnly, _ := cmd.Flags().GetBool("read-only")
+	tlsCert, _ := cmd.Flags().GetString("tls-cert")
+	tlsKey, _ := cmd.Flags().GetString("tls-key")
+	acmeDomains, _ := cmd.Flags().GetStringSlice("acme-domain")
+	acmeEmail, _ := cmd.Flags().GetString("acme-email")
+	acmeCacheDir, _ := cmd.Flags().GetString("acme-cache")
+	redirectAddress, _ := cmd.Flags().GetString("redirect-http")
+	ldapURL, _ := cmd.Flags().GetString("ldap-url")
+	ldapBindDN, _ := cmd.Flags().GetString("ldap-bind-dn")
+	ldapBindPassword, _ := cmd.Flags().GetString("ldap-bind-password")
+	ldapBaseDN, _ := cmd.Flags().GetString("ldap-base-dn")
+	ldapUserFilter, _ := cmd.Flags().GetString("ldap-user-filter")
+	ldapOwnerGroup, _ := cmd.Flags().GetString("ldap-owner-group")
+	oidcIssuer, _ := cmd.Flags().GetString("oidc-issuer")
+	oidcClientID, _ := cmd.Flags().GetString("oidc-client-id")
+	oidcClientSecret, _ := cmd.Flags().GetString("oidc-client-secret")
+	oidcRedirectURL, _ := cmd.Flags().GetString("oidc-redirect-url")
+	oidcUsernameClaim, _ := cmd.Flags().GetString("oidc-username-claim")
+	loginMaxFailures, _ := cmd.Flags().GetInt("login-max-failures")
+	loginLockout, _ := cmd.Flags().GetDuration("login-lockout")
+	webhookURLs, _ := cmd.Flags().GetStringSlice("webhook-url")
+	webhookSecret, _ := cmd.Flags().GetString("webhook-secret")
+	shutdownTimeout, _ := cmd.Flags().GetDuration("shutdown-timeout")
 
 	// Validate root path
 	if rootPath == "" {
@@ -44,13 +128,178 @@ func serveHandler(cmd *cobra.Command, args []string) {
 		rootPath += "/"
 	}
 
+	// Validate status codes
+	for _, code := range failOnStatus {
+		if code < 100 || code > 599 {
+			logrus.Fatalf("--fail-on-status has invalid HTTP status code %d\n", code)
+		}
+	}
+
+	// Validate archive limit
+	if archiveLimit < 1 {
+		logrus.Fatalln("--archive-limit must be at least 1")
+	}
+
+	// Validate page size
+	if maxPageSize < 0 {
+		logrus.Fatalln("--max-page-size must not be negative")
+	}
+
+	// Validate quota
+	if insertQuota < 0 || archivalQuota < 0 {
+		logrus.Fatalln("--insert-quota and --archival-quota must not be negative")
+	}
+
+	// Validate pruning options
+	if archiveMaxAge < 0 || archiveMaxSize < 0 {
+		logrus.Fatalln("--archive-max-age and --archive-max-size must not be negative")
+	}
+
+	if pruneInterval <= 0 {
+		logrus.Fatalln("--prune-interval must be positive")
+	}
+
+	if snapshotInterval < 0 {
+		logrus.Fatalln("--snapshot-interval must not be negative")
+	}
+
+	if linkCheckInterval < 0 {
+		logrus.Fatalln("--link-check-interval must not be negative")
+	}
+
+	// Validate re-archiving schedule
+	rearchiveConfig := webserver.RearchiveConfig{Tags: rearchiveTags}
+	if rearchiveSchedule != "" {
+		schedule, err := core.ParseCronSchedule(rearchiveSchedule)
+		if err != nil {
+			logrus.Fatalf("--rearchive-schedule is not valid: %v\n", err)
+		}
+
+		rearchiveConfig.Schedule = &schedule
+	} else if len(rearchiveTags) > 0 {
+		logrus.Fatalln("--rearchive-tag requires --rearchive-schedule")
+	}
+
+	// Validate TLS options
+	if (tlsCert == "") != (tlsKey == "") {
+		logrus.Fatalln("Both --tls-cert and --tls-key must be specified")
+	}
+
+	if tlsCert != "" && len(acmeDomains) > 0 {
+		logrus.Fatalln("--tls-cert can't be used together with --acme-domain")
+	}
+
+	if redirectAddress != "" && tlsCert == "" && len(acmeDomains) == 0 {
+		logrus.Fatalln("--redirect-http requires HTTPS to be enabled")
+	}
+
+	if len(acmeDomains) > 0 && acmeCacheDir == "" {
+		acmeCacheDir = fp.Join(dataDir, "autocert")
+	}
+
+	// Validate LDAP options
+	if ldapURL != "" && ldapBaseDN == "" {
+		logrus.Fatalln("--ldap-url requires --ldap-base-dn")
+	}
+
+	if ldapURL != "" && !strings.Contains(ldapUserFilter, "%s") {
+		logrus.Fatalln("--ldap-user-filter must contain placeholder for the username")
+	}
+
+	// Validate login lockout
+	if loginMaxFailures < 0 || loginLockout < 0 {
+		logrus.Fatalln("--login-max-failures and --login-lockout must not be negative")
+	}
+
+	if loginMaxFailures > 0 && loginLockout == 0 {
+		logrus.Fatalln("--login-lockout must be positive when --login-max-failures is used")
+	}
+
+	// Validate OpenID Connect options
+	if oidcIssuer != "" && (oidcClientID == "" || oidcRedirectURL == "") {
+		logrus.Fatalln("--oidc-issuer requires --oidc-client-id and --oidc-redirect-url")
+	}
+
+	if oidcIssuer != "" && oidcUsernameClaim == "" {
+		logrus.Fatalln("--oidc-username-claim must not be empty")
+	}
+
+	// Validate webhooks
+	for _, webhookURL := range webhookURLs {
+		parsedURL, err := url.Parse(webhookURL)
+		if err != nil || (parsedURL.Scheme != "http" && parsedURL.Scheme != "https") || parsedURL.Host == "" {
+			logrus.Fatalf("--webhook-url %q is not a valid http or https URL\n", webhookURL)
+		}
+	}
+
+	// Validate shutdown timeout
+	if shutdownTimeout <= 0 {
+		logrus.Fatalln("--shutdown-timeout must be positive")
+	}
+
 	// Start server
 	serverConfig := webserver.Config{
-		DB:            db,
-		DataDir:       dataDir,
-		ServerAddress: address,
-		ServerPort:    port,
-		RootPath:      rootPath,
+		DB:                db,
+		DataDir:           dataDir,
+		ServerAddress:     address,
+		ServerPort:        port,
+		RootPath:          rootPath,
+		MaxBodySize:       maxBodySize,
+		MaxUploadSize:     maxUploadSize,
+		StrictJSON:        strictJSON,
+		StrictProcess:     strictProcess,
+		ArchiveOnInsert:   archiveOnInsert,
+		Concurrency:       concurrency,
+		ArchiveLimit:      archiveLimit,
+		MaxResources:      maxResources,
+		MaxSnapshots:      maxSnapshots,
+		MaxPageSize:       maxPageSize,
+		ArchivalPolicy:    archivalPolicy,
+		RenderPolicy:      renderPolicy,
+		KeptQueryParams:   keptQueryParams,
+		FailOnStatus:      failOnStatus,
+		InsertQuota:       insertQuota,
+		ArchivalQuota:     archivalQuota,
+		ArchiveMaxAge:     time.Duration(archiveMaxAge) * 24 * time.Hour,
+		ArchiveMaxSize:    archiveMaxSize << 20,
+		PruneInterval:     pruneInterval,
+		SnapshotInterval:  snapshotInterval,
+		LinkCheckInterval: linkCheckInterval,
+		Rearchive:         rearchiveConfig,
+		ReadOnly:          readOnly,
+		TLSCertFile:       tlsCert,
+		TLSKeyFile:        tlsKey,
+		ACMEDomains:       acmeDomains,
+		ACMEEmail:         acmeEmail,
+		ACMECacheDir:      acmeCacheDir,
+		RedirectHTTP:      redirectAddress,
+		LDAP: webserver.LDAPConfig{
+			URL:          ldapURL,
+			BindDN:       ldapBindDN,
+			BindPassword: ldapBindPassword,
+			BaseDN:       ldapBaseDN,
+			UserFilter:   ldapUserFilter,
+			OwnerGroup:   ldapOwnerGroup,
+		},
+		LoginMaxFailures: loginMaxFailures,
+		LoginLockout:     loginLockout,
+		OIDC: webserver.OIDCConfig{
+			Issuer:        oidcIssuer,
+			ClientID:      oidcClientID,
+			ClientSecret:  oidcClientSecret,
+			RedirectURL:   oidcRedirectURL,
+			UsernameClaim: oidcUsernameClaim,
+		},
+		Webhooks: webserver.WebhookConfig{
+			URLs:   webhookURLs,
+			Secret: webhookSecret,
+		},
+		ShutdownTimeout: shutdownTimeout,
+		Build: webserver.BuildInfo{
+			Version:   version,
+			Commit:    commit,
+			BuildDate: buildDate,
+		},
 	}
 
 	err := webserver.ServeApp(serverConfig)
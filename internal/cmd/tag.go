@@ -0,0 +1,90 @@
+package cmd
+
+import (
+	"fmt"
+	"os"
+	"strings"
+
+	"shiori/internal/core"
+	"shiori/internal/database"
+	"github.com/spf13/cobra"
+)
+
+func tagCmd() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "tag",
+		Short: "Manage the tags of bookmarks",
+	}
+
+	cmd.AddCommand(tagDeleteCmd())
+
+	return cmd
+}
+
+func tagDeleteCmd() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "delete [tags]",
+		Short: "Delete tags from every bookmark",
+		Long: "Delete tags by their name (e.g. dev/go). " +
+			"The tags are removed from every bookmark that uses them, " +
+			"while the tags nested in them are kept at the top level.",
+		Aliases: []string{"rm"},
+		Args:    cobra.MinimumNArgs(1),
+		Run:     tagDeleteHandler,
+	}
+
+	cmd.Flags().Bool("delete-orphans", false, "Delete the bookmarks that left without any tag as well")
+
+	return cmd
+}
+
+func tagDeleteHandler(cmd *cobra.Command, args []string) {
+	// Parse flags
+	deleteOrphans, _ := cmd.Flags().GetBool("delete-orphans")
+
+	// Find the tags by their name
+	tags, err := db.GetTags(database.GetTagsOptions{WithUnused: true})
+	if err != nil {
+		cError.Printf("Failed to get tags: %v\n", err)
+		os.Exit(1)
+	}
+
+	tagIDs := map[string]int{}
+	for _, tag := range tags {
+		tagIDs[tag.Name] = tag.ID
+	}
+
+	// Delete the tags, and their orphans if requested
+	for _, arg := range args {
+		name := strings.Join(strings.Fields(strings.ToLower(arg)), " ")
+		id, exist := tagIDs[name]
+		if !exist {
+			cError.Printf("Tag %q not found\n", arg)
+			os.Exit(1)
+		}
+
+		orphanIDs, err := db.DeleteTag(id)
+		if err != nil {
+			cError.Printf("Failed to delete tag %q: %v\n", name, err)
+			os.Exit(1)
+		}
+
+		fmt.Printf("Tag %q has been deleted, %d bookmark(s) left without tag\n", name, len(orphanIDs))
+		if !deleteOrphans || len(orphanIDs) == 0 {
+			continue
+		}
+
+		nDeleted, err := db.DeleteBookmarks(orphanIDs...)
+		if err != nil {
+			cError.Printf("Failed to delete bookmarks: %v\n", err)
+			os.Exit(1)
+		}
+
+		// Delete thumbnail image and archives from local disk
+		for _, id := range orphanIDs {
+			core.RemoveBookmarkArtifacts(dataDir, id)
+		}
+
+		fmt.Printf("%d bookmark(s) have been deleted\n", nDeleted)
+	}
+}
@@ -36,6 +36,8 @@ func updateCmd() *cobra.Command {
 	cmd.Flags().Bool("keep-metadata", false, "Keep existing metadata. Useful when only want to update bookmark's content")
 	cmd.Flags().BoolP("no-archival", "a", false, "Update bookmark without updating offline archive")
 	cmd.Flags().Bool("log-archival", false, "Log the archival process")
+	cmd.Flags().Bool("pdf", false, "Save the page as PDF as well, which requires headless browser support")
+	cmd.Flags().String("archive-format", core.ArchiveFormatWARC, "Format of offline archive, either warc or singlefile")
 
 	return cmd
 }
@@ -50,8 +52,15 @@ func updateHandler(cmd *cobra.Command, args []string) {
 	skipConfirm, _ := cmd.Flags().GetBool("yes")
 	noArchival, _ := cmd.Flags().GetBool("no-archival")
 	logArchival, _ := cmd.Flags().GetBool("log-archival")
+	createPDF, _ := cmd.Flags().GetBool("pdf")
+	archiveFormat, _ := cmd.Flags().GetString("archive-format")
 	keepMetadata := cmd.Flags().Changed("keep-metadata")
 
+	if err := core.CheckArchiveFormat(archiveFormat); err != nil {
+		cError.Println(err)
+		os.Exit(1)
+	}
+
 	// If no arguments (i.e all bookmarks going to be updated), confirm to user
 	if len(args) == 0 && !skipConfirm {
 		confirmUpdate := ""
@@ -72,12 +81,12 @@ func updateHandler(cmd *cobra.Command, args []string) {
 	}
 
 	// Clean up new parameter from flags
-	title = validateTitle(title, "")
+	title = core.CleanTitle(title, "")
 	excerpt = normalizeSpace(excerpt)
 
 	if cmd.Flags().Changed("url") {
 		// Clean up bookmark URL
-		url, err = core.RemoveUTMParams(url)
+		url, err = core.RemoveUTMParams(url, keptQueryParams)
 		if err != nil {
 			panic(fmt.Errorf("failed to clean URL: %v", err))
 		}
@@ -130,7 +139,7 @@ func updateHandler(cmd *cobra.Command, args []string) {
 		chDone := make(chan struct{})
 		chProblem := make(chan int, 10)
 		chMessage := make(chan interface{}, 10)
-		semaphore := make(chan struct{}, 10)
+		semaphore := make(chan struct{}, concurrency)
 
 		cInfo.Println("Downloading article(s)...")
 
@@ -139,6 +148,8 @@ func updateHandler(cmd *cobra.Command, args []string) {
 
 			// Mark whether book will be archived
 			book.CreateArchive = !noArchival
+			book.CreatePDF = createPDF
+			book.ArchiveFormat = archiveFormat
 
 			// If used, use submitted URL
 			if url != "" {
@@ -156,21 +167,28 @@ func updateHandler(cmd *cobra.Command, args []string) {
 				}()
 
 				// Download data from internet
-				content, contentType, err := core.DownloadBookmark(book.URL)
+				content, contentType, statusCode, waybackURL, err := core.FetchBookmark(book.URL, renderPolicy)
 				if err != nil {
 					chProblem <- book.ID
 					chMessage <- fmt.Errorf("Failed to download %s: %v", book.URL, err)
 					return
 				}
 
+				book.LastStatusCode = statusCode
+				book.WaybackURL = waybackURL
+
 				request := core.ProcessRequest{
-					DataDir:     dataDir,
-					Bookmark:    book,
-					Content:     content,
-					ContentType: contentType,
-					KeepTitle:   keepMetadata,
-					KeepExcerpt: keepMetadata,
-					LogArchival: logArchival,
+					DataDir:        dataDir,
+					Bookmark:       book,
+					Content:        content,
+					ContentType:    contentType,
+					KeepTitle:      keepMetadata,
+					KeepExcerpt:    keepMetadata,
+					LogArchival:    logArchival,
+					MaxResources:   maxResources,
+					MaxSnapshots:   maxSnapshots,
+					RenderPolicy:   renderPolicy,
+					ArchivalPolicy: archivalPolicy,
 				}
 
 				book, _, err = core.ProcessBookmark(request)
@@ -253,7 +271,7 @@ func updateHandler(cmd *cobra.Command, args []string) {
 		}
 
 		// Make sure title is valid and not empty
-		book.Title = validateTitle(book.Title, book.URL)
+		core.EnsureTitle(&book)
 
 		// Generate new tags
 		tmpAddedTags := make(map[string]struct{})
@@ -9,10 +9,12 @@ import (
 	"runtime"
 	"strconv"
 	"strings"
-	"unicode/utf8"
+	"time"
 
 	"github.com/fatih/color"
+	"shiori/internal/core"
 	"shiori/internal/model"
+	"github.com/sirupsen/logrus"
 	"golang.org/x/crypto/ssh/terminal"
 )
 
@@ -37,6 +39,73 @@ func normalizeSpace(str string) string {
 	return strings.Join(strings.Fields(str), " ")
 }
 
+// parseUnixTime converts Unix epoch in seconds, e.g. from ADD_DATE attribute,
+// into UTC "2006-01-02 15:04:05". Returns empty string if it's not valid.
+func parseUnixTime(s string) string {
+	epoch, err := strconv.ParseInt(strings.TrimSpace(s), 10, 64)
+	if err != nil || epoch <= 0 {
+		return ""
+	}
+
+	return time.Unix(epoch, 0).UTC().Format("2006-01-02 15:04:05")
+}
+
+// parseKeptQueryParams converts list of "domain=param" into the
+// query parameters that must be kept for each domain.
+func parseKeptQueryParams(pairs []string) (core.KeptQueryParams, error) {
+	kept := core.KeptQueryParams{}
+	for _, pair := range pairs {
+		parts := strings.SplitN(pair, "=", 2)
+		if len(parts) != 2 {
+			return nil, fmt.Errorf("%q is not in domain=param format", pair)
+		}
+
+		domain := strings.ToLower(strings.TrimSpace(parts[0]))
+		param := strings.TrimSpace(parts[1])
+		if domain == "" || param == "" {
+			return nil, fmt.Errorf("%q is not in domain=param format", pair)
+		}
+
+		kept[domain] = append(kept[domain], param)
+	}
+
+	return kept, nil
+}
+
+// newLogFormatter returns the formatter of log, either "text" for reading
+// in terminal or "json" for ingesting in log aggregator like Loki or ELK.
+func newLogFormatter(format string) (logrus.Formatter, error) {
+	switch strings.ToLower(strings.TrimSpace(format)) {
+	case "text", "":
+		return &logrus.TextFormatter{FullTimestamp: true}, nil
+	case "json":
+		return &logrus.JSONFormatter{
+			TimestampFormat: time.RFC3339,
+			FieldMap: logrus.FieldMap{
+				logrus.FieldKeyTime: "time",
+				logrus.FieldKeyMsg:  "message",
+			},
+		}, nil
+	default:
+		return nil, fmt.Errorf("%q is neither text nor json", format)
+	}
+}
+
+// parseTimeFlag parses time from command flag, which is either
+// a date in UTC (e.g. 2023-03-01) or formatted as RFC3339.
+func parseTimeFlag(s string) (time.Time, error) {
+	if t, err := time.Parse("2006-01-02", s); err == nil {
+		return t, nil
+	}
+
+	t, err := time.Parse(time.RFC3339, s)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("%q is neither date nor RFC3339", s)
+	}
+
+	return t, nil
+}
+
 func isURLValid(s string) bool {
 	tmp, err := nurl.Parse(s)
 	return err == nil && tmp.Scheme != "" && tmp.Hostname() != ""
@@ -133,36 +202,3 @@ func getTerminalWidth() int {
 	width, _, _ := terminal.GetSize(int(os.Stdin.Fd()))
 	return width
 }
-
-func validateTitle(title, fallback string) string {
-	// Normalize spaces before we begin
-	title = normalizeSpace(title)
-	title = strings.TrimSpace(title)
-
-	// If at this point title already empty, just uses fallback
-	if title == "" {
-		return fallback
-	}
-
-	// Check if it's already valid UTF-8 string
-	if valid := utf8.ValidString(title); valid {
-		return title
-	}
-
-	// Remove invalid runes to get the valid UTF-8 title
-	fixUtf := func(r rune) rune {
-		if r == utf8.RuneError {
-			return -1
-		}
-		return r
-	}
-	validUtf := strings.Map(fixUtf, title)
-
-	// If it's empty use fallback string
-	validUtf = strings.TrimSpace(validUtf)
-	if validUtf == "" {
-		return fallback
-	}
-
-	return validUtf
-}
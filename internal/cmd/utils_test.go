@@ -3,6 +3,9 @@ package cmd
 import (
 	"reflect"
 	"testing"
+
+	"shiori/internal/core"
+	"github.com/sirupsen/logrus"
 )
 
 func Test_normalizeSpace(t *testing.T) {
@@ -143,3 +146,80 @@ func Test_parseStrIndices(t *testing.T) {
 		})
 	}
 }
+
+func Test_parseUnixTime(t *testing.T) {
+	tests := []struct {
+		name string
+		args string
+		want string
+	}{
+		{"valid epoch", "1577934245", "2020-01-02 03:04:05"},
+		{"surrounding spaces", " 1577934245 ", "2020-01-02 03:04:05"},
+		{"empty", "", ""},
+		{"zero", "0", ""},
+		{"not a number", "yesterday", ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := parseUnixTime(tt.args); got != tt.want {
+				t.Errorf("parseUnixTime() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func Test_parseKeptQueryParams(t *testing.T) {
+	tests := []struct {
+		name    string
+		pairs   []string
+		want    core.KeptQueryParams
+		wantErr bool
+	}{
+		{"empty", []string{}, core.KeptQueryParams{}, false},
+		{"multiple params", []string{"Example.com=utm_id", " example.com = v ", "video.test=t"},
+			core.KeptQueryParams{"example.com": {"utm_id", "v"}, "video.test": {"t"}}, false},
+		{"missing separator", []string{"example.com"}, nil, true},
+		{"missing param", []string{"example.com="}, nil, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := parseKeptQueryParams(tt.pairs)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("parseKeptQueryParams() error = %v, wantErr %v", err, tt.wantErr)
+				return
+			}
+
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("parseKeptQueryParams() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func Test_newLogFormatter(t *testing.T) {
+	tests := []struct {
+		format   string
+		wantJSON bool
+		wantErr  bool
+	}{
+		{"text", false, false},
+		{"", false, false},
+		{"JSON", true, false},
+		{"xml", false, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.format, func(t *testing.T) {
+			got, err := newLogFormatter(tt.format)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("newLogFormatter() error = %v, wantErr %v", err, tt.wantErr)
+			}
+
+			if _, isJSON := got.(*logrus.JSONFormatter); !tt.wantErr && isJSON != tt.wantJSON {
+				t.Errorf("newLogFormatter() = %T, want JSON %v", got, tt.wantJSON)
+			}
+		})
+	}
+}
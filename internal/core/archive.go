@@ -0,0 +1,71 @@
+package core
+
+import (
+	"os"
+	fp "path/filepath"
+	"sort"
+	"strconv"
+
+	"go.etcd.io/bbolt"
+)
+
+// ArchiveResource is a resource that stored inside an archive.
+type ArchiveResource struct {
+	// Name is the archival name of the resource, which derived from its
+	// original URL. The root document is named "archive-root".
+	Name        string `json:"name"`
+	ContentType string `json:"contentType"`
+
+	// Size is the stored size in bytes, which is gzip compressed.
+	Size int `json:"size"`
+}
+
+// ListArchiveResources lists all resources that stored in the archive
+// at archivePath, sorted by their name. The archive is opened read only,
+// so it's safe to use while the same archive is opened elsewhere.
+func ListArchiveResources(archivePath string) ([]ArchiveResource, error) {
+	// Make sure archive exists, otherwise bbolt will create it
+	if _, err := os.Stat(archivePath); err != nil {
+		return nil, err
+	}
+
+	db, err := bbolt.Open(archivePath, os.ModePerm, &bbolt.Options{ReadOnly: true})
+	if err != nil {
+		return nil, err
+	}
+	defer db.Close()
+
+	resources := []ArchiveResource{}
+	err = db.View(func(tx *bbolt.Tx) error {
+		return tx.ForEach(func(name []byte, bucket *bbolt.Bucket) error {
+			resources = append(resources, ArchiveResource{
+				Name:        string(name),
+				ContentType: string(bucket.Get([]byte("type"))),
+				Size:        len(bucket.Get([]byte("content"))),
+			})
+			return nil
+		})
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	sort.Slice(resources, func(i, j int) bool {
+		return resources[i].Name < resources[j].Name
+	})
+
+	return resources, nil
+}
+
+// RemoveBookmarkArtifacts removes every file that stored in data dir for
+// the bookmark, i.e. its thumbnail, archives, screenshot, PDF and snapshots.
+// Missing files are fine, since the bookmark might not have all of them.
+func RemoveBookmarkArtifacts(dataDir string, id int) {
+	strID := strconv.Itoa(id)
+	os.Remove(fp.Join(dataDir, "thumb", strID))
+	os.Remove(fp.Join(dataDir, "archive", strID))
+	os.Remove(ScreenshotPath(dataDir, id))
+	os.Remove(PDFPath(dataDir, id))
+	os.Remove(SingleFilePath(dataDir, id))
+	os.RemoveAll(SnapshotDir(dataDir, id))
+}
@@ -0,0 +1,111 @@
+package core
+
+import (
+	"io/ioutil"
+	"os"
+	fp "path/filepath"
+	"reflect"
+	"strconv"
+	"testing"
+
+	"go.etcd.io/bbolt"
+)
+
+func TestListArchiveResources(t *testing.T) {
+	tmpDir, err := ioutil.TempDir("", "shiori-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	// Create archive with the same layout as warc package
+	archivePath := fp.Join(tmpDir, "1")
+	db, err := bbolt.Open(archivePath, os.ModePerm, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = db.Update(func(tx *bbolt.Tx) error {
+		resources := map[string][2]string{
+			"archive-root":               {"text/html", "<html></html>"},
+			"https-example.com-logo.png": {"image/png", "png"},
+		}
+
+		for name, resource := range resources {
+			bucket, err := tx.CreateBucket([]byte(name))
+			if err != nil {
+				return err
+			}
+			bucket.Put([]byte("type"), []byte(resource[0]))
+			bucket.Put([]byte("content"), []byte(resource[1]))
+		}
+		return nil
+	})
+	db.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	got, err := ListArchiveResources(archivePath)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := []ArchiveResource{
+		{Name: "archive-root", ContentType: "text/html", Size: 13},
+		{Name: "https-example.com-logo.png", ContentType: "image/png", Size: 3},
+	}
+
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("ListArchiveResources() = %+v, want %+v", got, want)
+	}
+
+	// Missing archive must not be created
+	_, err = ListArchiveResources(fp.Join(tmpDir, "2"))
+	if !os.IsNotExist(err) {
+		t.Errorf("ListArchiveResources() error = %v, want not exist error", err)
+	}
+}
+
+func TestRemoveBookmarkArtifacts(t *testing.T) {
+	dataDir, err := ioutil.TempDir("", "shiori-artifacts")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dataDir)
+
+	artifacts := func(id int) []string {
+		return []string{
+			fp.Join(dataDir, "thumb", strconv.Itoa(id)),
+			fp.Join(dataDir, "archive", strconv.Itoa(id)),
+			ScreenshotPath(dataDir, id),
+			PDFPath(dataDir, id),
+			SingleFilePath(dataDir, id),
+			fp.Join(SnapshotDir(dataDir, id), "20230101-000000"),
+		}
+	}
+
+	for _, id := range []int{1, 2} {
+		for _, path := range artifacts(id) {
+			os.MkdirAll(fp.Dir(path), os.ModePerm)
+			if err := ioutil.WriteFile(path, []byte("artifact"), 0644); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+
+	RemoveBookmarkArtifacts(dataDir, 1)
+
+	for _, path := range append(artifacts(1), SnapshotDir(dataDir, 1)) {
+		if _, err := os.Stat(path); !os.IsNotExist(err) {
+			t.Errorf("%s still exists after RemoveBookmarkArtifacts()", path)
+		}
+	}
+
+	// Files of other bookmark are kept
+	for _, path := range artifacts(2) {
+		if _, err := os.Stat(path); err != nil {
+			t.Errorf("%s of other bookmark is removed: %v", path, err)
+		}
+	}
+}
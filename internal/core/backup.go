@@ -0,0 +1,255 @@
+package core
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path"
+	fp "path/filepath"
+	"strings"
+)
+
+// Names of the files in backup, besides the directories of data dir.
+const (
+	backupManifestName = "manifest.json"
+	backupDatabaseName = "shiori.db"
+)
+
+// backupDirs is list of directories in data dir that kept in backup, i.e.
+// the thumbnails, archives, screenshots, snapshots, PDFs and single-file
+// archives of bookmarks.
+var backupDirs = []string{"thumb", "archive", "screenshot", "snapshot", "pdf", "singlefile"}
+
+// BackupManifest describes the backup, which is kept as its first file.
+type BackupManifest struct {
+	Version  string `json:"version"`  // version of Shiori that created it
+	Created  string `json:"created"`  // RFC3339 time when it's created
+	Database bool   `json:"database"` // whether SQLite database is included
+}
+
+// WriteBackup writes backup of data dir into w as gzipped tar archive. It
+// contains the manifest, the SQLite database at dbPath unless it's empty,
+// and the files of bookmarks in data dir. The database must be a copy that
+// isn't in use, since it's read as it is.
+func WriteBackup(w io.Writer, dataDir string, dbPath string, manifest BackupManifest) error {
+	gzipWriter := gzip.NewWriter(w)
+	tarWriter := tar.NewWriter(gzipWriter)
+
+	// Write manifest first, so it's known before the rest is read
+	manifest.Database = dbPath != ""
+	manifestJSON, err := json.MarshalIndent(&manifest, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	err = tarWriter.WriteHeader(&tar.Header{
+		Name: backupManifestName,
+		Mode: 0644,
+		Size: int64(len(manifestJSON)),
+	})
+	if err != nil {
+		return err
+	}
+
+	if _, err = tarWriter.Write(manifestJSON); err != nil {
+		return err
+	}
+
+	// Write database and the files of bookmarks
+	if dbPath != "" {
+		if err = writeBackupFile(tarWriter, dbPath, backupDatabaseName); err != nil {
+			return err
+		}
+	}
+
+	for _, dir := range backupDirs {
+		err = fp.Walk(fp.Join(dataDir, dir), func(srcPath string, info os.FileInfo, err error) error {
+			if os.IsNotExist(err) {
+				return nil
+			}
+			if err != nil || !info.Mode().IsRegular() {
+				return err
+			}
+
+			relPath, err := fp.Rel(dataDir, srcPath)
+			if err != nil {
+				return err
+			}
+
+			return writeBackupFile(tarWriter, srcPath, fp.ToSlash(relPath))
+		})
+		if err != nil {
+			return err
+		}
+	}
+
+	if err = tarWriter.Close(); err != nil {
+		return err
+	}
+
+	return gzipWriter.Close()
+}
+
+// writeBackupFile writes the file at srcPath into backup with the name.
+func writeBackupFile(tarWriter *tar.Writer, srcPath string, name string) error {
+	srcFile, err := os.Open(srcPath)
+	if err != nil {
+		return err
+	}
+	defer srcFile.Close()
+
+	info, err := srcFile.Stat()
+	if err != nil {
+		return err
+	}
+
+	header, err := tar.FileInfoHeader(info, "")
+	if err != nil {
+		return err
+	}
+
+	header.Name = name
+	if err = tarWriter.WriteHeader(header); err != nil {
+		return err
+	}
+
+	_, err = io.Copy(tarWriter, srcFile)
+	return err
+}
+
+// RestoreBackup restores backup that written by WriteBackup into data dir,
+// replacing its database and the files of bookmarks. The backup is extracted
+// into temporary directory first, so data dir is left as it is when the
+// backup is invalid. The database must not be in use while it's restored.
+func RestoreBackup(r io.Reader, dataDir string) (BackupManifest, error) {
+	var manifest BackupManifest
+
+	gzipReader, err := gzip.NewReader(r)
+	if err != nil {
+		return manifest, fmt.Errorf("backup is not gzipped tar archive: %v", err)
+	}
+	defer gzipReader.Close()
+
+	tmpDir, err := ioutil.TempDir(dataDir, ".restore-")
+	if err != nil {
+		return manifest, err
+	}
+	defer os.RemoveAll(tmpDir)
+
+	// Extract the backup, making sure its files never escape the directory
+	hasManifest := false
+	tarReader := tar.NewReader(gzipReader)
+	for {
+		header, err := tarReader.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return manifest, fmt.Errorf("failed to read backup: %v", err)
+		}
+
+		name := path.Clean(header.Name)
+		if !validBackupName(name) {
+			return manifest, fmt.Errorf("backup has unexpected file %q", header.Name)
+		}
+
+		switch header.Typeflag {
+		case tar.TypeDir:
+			continue
+		case tar.TypeReg, tar.TypeRegA:
+		default:
+			return manifest, fmt.Errorf("backup has unexpected file %q", header.Name)
+		}
+
+		if name == backupManifestName {
+			if err = json.NewDecoder(tarReader).Decode(&manifest); err != nil {
+				return manifest, fmt.Errorf("failed to read backup manifest: %v", err)
+			}
+
+			hasManifest = true
+			continue
+		}
+
+		dstPath := fp.Join(tmpDir, fp.FromSlash(name))
+		if err = extractBackupFile(tarReader, dstPath); err != nil {
+			return manifest, err
+		}
+	}
+
+	if !hasManifest {
+		return manifest, fmt.Errorf("backup doesn't have %s", backupManifestName)
+	}
+
+	tmpDBPath := fp.Join(tmpDir, backupDatabaseName)
+	if _, err = os.Stat(tmpDBPath); manifest.Database && err != nil {
+		return manifest, fmt.Errorf("backup doesn't have %s", backupDatabaseName)
+	}
+
+	// Replace the database, together with the journal of the old one
+	if manifest.Database {
+		dbPath := fp.Join(dataDir, backupDatabaseName)
+		for _, suffix := range []string{"", "-journal", "-wal", "-shm"} {
+			if err = os.Remove(dbPath + suffix); err != nil && !os.IsNotExist(err) {
+				return manifest, err
+			}
+		}
+
+		if err = os.Rename(tmpDBPath, dbPath); err != nil {
+			return manifest, fmt.Errorf("failed to restore database: %v", err)
+		}
+	}
+
+	// Replace the files of bookmarks
+	for _, dir := range backupDirs {
+		dstDir := fp.Join(dataDir, dir)
+		if err = os.RemoveAll(dstDir); err != nil {
+			return manifest, err
+		}
+
+		err = os.Rename(fp.Join(tmpDir, dir), dstDir)
+		if err != nil && !os.IsNotExist(err) {
+			return manifest, err
+		}
+	}
+
+	return manifest, nil
+}
+
+// validBackupName checks if the cleaned name of file in backup is either its
+// manifest, its database or inside one of backupDirs.
+func validBackupName(name string) bool {
+	if name == backupManifestName || name == backupDatabaseName {
+		return true
+	}
+
+	for _, dir := range backupDirs {
+		if strings.HasPrefix(name, dir+"/") {
+			return true
+		}
+	}
+
+	return false
+}
+
+// extractBackupFile writes the content of file in backup into dstPath.
+func extractBackupFile(r io.Reader, dstPath string) error {
+	if err := os.MkdirAll(fp.Dir(dstPath), os.ModePerm); err != nil {
+		return err
+	}
+
+	dstFile, err := os.Create(dstPath)
+	if err != nil {
+		return err
+	}
+
+	if _, err = io.Copy(dstFile, r); err != nil {
+		dstFile.Close()
+		return fmt.Errorf("failed to extract %s: %v", dstPath, err)
+	}
+
+	return dstFile.Close()
+}
@@ -0,0 +1,135 @@
+package core
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"io/ioutil"
+	"os"
+	fp "path/filepath"
+	"testing"
+)
+
+func TestBackup(t *testing.T) {
+	srcDir, err := ioutil.TempDir("", "shiori-backup-src-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(srcDir)
+
+	files := map[string]string{
+		"shiori.db":           "database copy",
+		"thumb/1":             "thumbnail",
+		"archive/1":           "archive",
+		"snapshot/1/20200101": "snapshot",
+	}
+
+	for name, content := range files {
+		path := fp.Join(srcDir, fp.FromSlash(name))
+		os.MkdirAll(fp.Dir(path), os.ModePerm)
+		if err := ioutil.WriteFile(path, []byte(content), os.ModePerm); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	buf := &bytes.Buffer{}
+	err = WriteBackup(buf, srcDir, fp.Join(srcDir, "shiori.db"), BackupManifest{Version: "1.0.0"})
+	if err != nil {
+		t.Fatalf("WriteBackup() error = %v", err)
+	}
+
+	// Restore into data dir that has its own data, which is replaced
+	dstDir, err := ioutil.TempDir("", "shiori-backup-dst-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dstDir)
+
+	os.MkdirAll(fp.Join(dstDir, "thumb"), os.ModePerm)
+	ioutil.WriteFile(fp.Join(dstDir, "thumb", "2"), []byte("old"), os.ModePerm)
+	ioutil.WriteFile(fp.Join(dstDir, "shiori.db"), []byte("old"), os.ModePerm)
+	ioutil.WriteFile(fp.Join(dstDir, "shiori.db-wal"), []byte("old"), os.ModePerm)
+
+	manifest, err := RestoreBackup(buf, dstDir)
+	if err != nil {
+		t.Fatalf("RestoreBackup() error = %v", err)
+	}
+
+	if manifest.Version != "1.0.0" || !manifest.Database {
+		t.Errorf("RestoreBackup() manifest = %+v", manifest)
+	}
+
+	for name, content := range files {
+		got, err := ioutil.ReadFile(fp.Join(dstDir, fp.FromSlash(name)))
+		if err != nil || string(got) != content {
+			t.Errorf("restored %s = %q, %v, want %q", name, got, err, content)
+		}
+	}
+
+	for _, name := range []string{"thumb/2", "shiori.db-wal"} {
+		if _, err := os.Stat(fp.Join(dstDir, fp.FromSlash(name))); !os.IsNotExist(err) {
+			t.Errorf("old %s is kept after restore", name)
+		}
+	}
+
+	// No temporary directory is left behind
+	if entries, _ := ioutil.ReadDir(dstDir); len(entries) != 4 {
+		t.Errorf("data dir has %d entries after restore, want 4", len(entries))
+	}
+}
+
+func TestRestoreBackupInvalid(t *testing.T) {
+	newBackup := func(names ...string) *bytes.Buffer {
+		buf := &bytes.Buffer{}
+		gzipWriter := gzip.NewWriter(buf)
+		tarWriter := tar.NewWriter(gzipWriter)
+		for _, name := range names {
+			content := []byte("content")
+			if name == "manifest.json" {
+				content = []byte(`{"database":true}`)
+			}
+
+			tarWriter.WriteHeader(&tar.Header{Name: name, Mode: 0644, Size: int64(len(content))})
+			tarWriter.Write(content)
+		}
+		tarWriter.Close()
+		gzipWriter.Close()
+		return buf
+	}
+
+	tests := []struct {
+		name   string
+		backup *bytes.Buffer
+	}{
+		{"not gzipped", bytes.NewBufferString("not a backup")},
+		{"without manifest", newBackup("shiori.db", "thumb/1")},
+		{"without database", newBackup("manifest.json", "thumb/1")},
+		{"outside data dir", newBackup("manifest.json", "shiori.db", "thumb/../../evil")},
+		{"unknown file", newBackup("manifest.json", "shiori.db", "config.json")},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dstDir, err := ioutil.TempDir("", "shiori-backup-dst-")
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer os.RemoveAll(dstDir)
+
+			ioutil.WriteFile(fp.Join(dstDir, "shiori.db"), []byte("old"), os.ModePerm)
+
+			if _, err := RestoreBackup(tt.backup, dstDir); err == nil {
+				t.Errorf("RestoreBackup() doesn't return error")
+			}
+
+			// Data dir is left as it is
+			if got, _ := ioutil.ReadFile(fp.Join(dstDir, "shiori.db")); string(got) != "old" {
+				t.Errorf("database is replaced by invalid backup")
+			}
+
+			if entries, _ := ioutil.ReadDir(dstDir); len(entries) != 1 {
+				t.Errorf("data dir has %d entries after failed restore, want 1", len(entries))
+			}
+		})
+	}
+}
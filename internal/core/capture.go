@@ -0,0 +1,248 @@
+package core
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"encoding/base64"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"mime"
+	"mime/multipart"
+	"net/http"
+	"net/mail"
+	"net/textproto"
+	nurl "net/url"
+	"strconv"
+	"strings"
+)
+
+// maxCaptureRecord is max size of a record in captured file, so a broken
+// length won't exhaust the memory.
+const maxCaptureRecord = 100 << 20
+
+// Capture is a page that captured by other tool, e.g. as WARC or MHTML
+// file, together with the sub-resources that captured along with it.
+type Capture struct {
+	URL         string
+	ContentType string
+	Content     []byte
+
+	// Resources is the captured sub-resources by their URL.
+	Resources map[string]CaptureResource
+}
+
+// CaptureResource is a sub-resource in captured page.
+type CaptureResource struct {
+	ContentType string
+	Content     []byte
+}
+
+// add adds the resource to the capture, and returns its URL without
+// fragment. Resource without valid URL is skipped.
+func (c *Capture) add(rawURL string, contentType string, content []byte) string {
+	url, err := nurl.Parse(strings.TrimSpace(rawURL))
+	if err != nil || url.Scheme == "" {
+		return ""
+	}
+	url.Fragment = ""
+
+	c.Resources[url.String()] = CaptureResource{
+		ContentType: contentType,
+		Content:     content,
+	}
+
+	return url.String()
+}
+
+// setPage sets the resource as the captured page.
+func (c *Capture) setPage(url string, contentType string, content []byte) {
+	c.URL = url
+	c.ContentType = contentType
+	c.Content = content
+}
+
+// find returns the captured sub-resource at the URL.
+func (c *Capture) find(url *nurl.URL) (CaptureResource, bool) {
+	withoutFragment := *url
+	withoutFragment.Fragment = ""
+
+	resource, exist := c.Resources[withoutFragment.String()]
+	return resource, exist
+}
+
+// ParseCapture parses captured page in WARC file, which might be gzipped,
+// or in MHTML file. The format is detected from the content.
+func ParseCapture(r io.Reader) (*Capture, error) {
+	br := bufio.NewReader(r)
+	if magic, _ := br.Peek(2); bytes.Equal(magic, []byte{0x1f, 0x8b}) {
+		gzipReader, err := gzip.NewReader(br)
+		if err != nil {
+			return nil, err
+		}
+		defer gzipReader.Close()
+		br = bufio.NewReader(gzipReader)
+	}
+
+	var capture *Capture
+	var err error
+	if magic, _ := br.Peek(5); string(magic) == "WARC/" {
+		capture, err = parseWARC(br)
+	} else {
+		capture, err = parseMHTML(br)
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	if capture.URL == "" {
+		return nil, fmt.Errorf("captured file doesn't have any HTML page")
+	}
+
+	return capture, nil
+}
+
+// parseWARC parses the records in WARC file. Only the successful responses
+// and the resources are kept, while the other records are skipped. The
+// first HTML page in it is the captured page.
+func parseWARC(r *bufio.Reader) (*Capture, error) {
+	capture := &Capture{Resources: map[string]CaptureResource{}}
+	for {
+		// Records are separated by blank lines
+		line, err := r.ReadString('\n')
+		if err == io.EOF && strings.TrimSpace(line) == "" {
+			break
+		} else if err != nil && err != io.EOF {
+			return nil, err
+		}
+
+		line = strings.TrimSpace(line)
+		if line == "" {
+			continue
+		}
+
+		if !strings.HasPrefix(line, "WARC/") {
+			return nil, fmt.Errorf("invalid WARC record %q", line)
+		}
+
+		header, err := textproto.NewReader(r).ReadMIMEHeader()
+		if err != nil {
+			return nil, fmt.Errorf("invalid WARC header: %v", err)
+		}
+
+		length, err := strconv.ParseInt(header.Get("Content-Length"), 10, 64)
+		if err != nil || length < 0 || length > maxCaptureRecord {
+			return nil, fmt.Errorf("invalid length of WARC record: %q", header.Get("Content-Length"))
+		}
+
+		block := make([]byte, length)
+		if _, err = io.ReadFull(r, block); err != nil {
+			return nil, fmt.Errorf("WARC record is truncated: %v", err)
+		}
+
+		var contentType string
+		var content []byte
+
+		switch header.Get("WARC-Type") {
+		case "resource":
+			contentType, content = header.Get("Content-Type"), block
+		case "response":
+			resp, err := http.ReadResponse(bufio.NewReader(bytes.NewReader(block)), nil)
+			if err != nil || resp.StatusCode != http.StatusOK {
+				continue
+			}
+
+			content, err = readCapturedBody(resp)
+			if err != nil {
+				continue
+			}
+			contentType = resp.Header.Get("Content-Type")
+		default:
+			continue
+		}
+
+		url := capture.add(strings.Trim(header.Get("WARC-Target-URI"), "<>"), contentType, content)
+		if url != "" && capture.URL == "" && strings.Contains(contentType, "text/html") {
+			capture.setPage(url, contentType, content)
+		}
+	}
+
+	return capture, nil
+}
+
+// readCapturedBody reads body of the captured response, which is
+// decompressed if it's captured as it's sent by the server.
+func readCapturedBody(resp *http.Response) ([]byte, error) {
+	defer resp.Body.Close()
+
+	body := io.Reader(resp.Body)
+	if strings.EqualFold(resp.Header.Get("Content-Encoding"), "gzip") {
+		gzipReader, err := gzip.NewReader(body)
+		if err != nil {
+			return nil, err
+		}
+		defer gzipReader.Close()
+		body = gzipReader
+	}
+
+	return ioutil.ReadAll(io.LimitReader(body, maxCaptureRecord))
+}
+
+// parseMHTML parses the parts of MHTML file. The page is the part that
+// referred by `start` parameter, or the first part when there is none.
+// Part might be referred by its Content-ID as well as its URL.
+func parseMHTML(r io.Reader) (*Capture, error) {
+	msg, err := mail.ReadMessage(r)
+	if err != nil {
+		return nil, fmt.Errorf("invalid MHTML file: %v", err)
+	}
+
+	mediaType, params, err := mime.ParseMediaType(msg.Header.Get("Content-Type"))
+	if err != nil || mediaType != "multipart/related" || params["boundary"] == "" {
+		return nil, fmt.Errorf("MHTML file must be multipart/related")
+	}
+
+	capture := &Capture{Resources: map[string]CaptureResource{}}
+	reader := multipart.NewReader(msg.Body, params["boundary"])
+	for first := true; ; first = false {
+		part, err := reader.NextPart()
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			return nil, fmt.Errorf("invalid MHTML part: %v", err)
+		}
+
+		// Quoted-printable is decoded by the reader, but not base64
+		body := io.Reader(part)
+		if strings.EqualFold(part.Header.Get("Content-Transfer-Encoding"), "base64") {
+			body = base64.NewDecoder(base64.StdEncoding, part)
+		}
+
+		content, err := ioutil.ReadAll(io.LimitReader(body, maxCaptureRecord))
+		if err != nil {
+			return nil, fmt.Errorf("invalid MHTML part: %v", err)
+		}
+
+		contentType := part.Header.Get("Content-Type")
+		contentID := part.Header.Get("Content-ID")
+		isPage := first && params["start"] == "" || contentID != "" && contentID == params["start"]
+
+		url := part.Header.Get("Content-Location")
+		if url == "" && isPage {
+			url = msg.Header.Get("Snapshot-Content-Location")
+		}
+
+		url = capture.add(url, contentType, content)
+		if contentID != "" {
+			capture.add("cid:"+strings.Trim(contentID, "<>"), contentType, content)
+		}
+
+		if isPage && url != "" {
+			capture.setPage(url, contentType, content)
+		}
+	}
+
+	return capture, nil
+}
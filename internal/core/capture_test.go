@@ -0,0 +1,88 @@
+package core
+
+import (
+	"bytes"
+	"compress/gzip"
+	"fmt"
+	"strings"
+	"testing"
+)
+
+// warcRecord creates WARC record of the type with the block.
+func warcRecord(recordType, url, contentType, block string) string {
+	return fmt.Sprintf("WARC/1.0\r\nWARC-Type: %s\r\nWARC-Target-URI: %s\r\n"+
+		"Content-Type: %s\r\nContent-Length: %d\r\n\r\n%s\r\n\r\n",
+		recordType, url, contentType, len(block), block)
+}
+
+func TestParseCapture(t *testing.T) {
+	page := "<html><head><title>Captured</title></head><body><img src=\"dot.png\"></body></html>"
+
+	warc := warcRecord("warcinfo", "", "application/warc-fields", "software: test\r\n") +
+		warcRecord("request", "https://example.com/page", "application/http; msgtype=request",
+			"GET /page HTTP/1.1\r\nHost: example.com\r\n\r\n") +
+		warcRecord("response", "https://example.com/missing", "application/http; msgtype=response",
+			"HTTP/1.1 404 Not Found\r\nContent-Type: text/html\r\nContent-Length: 9\r\n\r\nnot found") +
+		warcRecord("response", "https://example.com/page", "application/http; msgtype=response",
+			fmt.Sprintf("HTTP/1.1 200 OK\r\nContent-Type: text/html\r\nContent-Length: %d\r\n\r\n%s", len(page), page)) +
+		warcRecord("resource", "https://example.com/dot.png", "image/png", "png")
+
+	gzipped := bytes.NewBuffer(nil)
+	gzipWriter := gzip.NewWriter(gzipped)
+	gzipWriter.Write([]byte(warc))
+	gzipWriter.Close()
+
+	mhtml := "From: <Saved by Blink>\r\n" +
+		"Snapshot-Content-Location: https://example.com/page\r\n" +
+		"MIME-Version: 1.0\r\n" +
+		"Content-Type: multipart/related; type=\"text/html\"; boundary=\"----boundary\"\r\n\r\n" +
+		"------boundary\r\n" +
+		"Content-Type: text/html\r\n" +
+		"Content-Transfer-Encoding: quoted-printable\r\n" +
+		"Content-Location: https://example.com/page\r\n\r\n" +
+		strings.Replace(page, "=", "=3D", -1) + "\r\n" +
+		"------boundary\r\n" +
+		"Content-Type: image/png\r\n" +
+		"Content-Transfer-Encoding: base64\r\n" +
+		"Content-Location: https://example.com/dot.png\r\n\r\n" +
+		"cG5n\r\n" +
+		"------boundary--\r\n"
+
+	tests := []struct {
+		name    string
+		content []byte
+		wantErr bool
+	}{
+		{"warc", []byte(warc), false},
+		{"gzipped warc", gzipped.Bytes(), false},
+		{"mhtml", []byte(mhtml), false},
+		{"no page", []byte(warcRecord("resource", "https://example.com/dot.png", "image/png", "png")), true},
+		{"not captured", []byte("<html><body>page</body></html>"), true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			capture, err := ParseCapture(bytes.NewReader(tt.content))
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("ParseCapture() error = %v, wantErr %v", err, tt.wantErr)
+			}
+
+			if tt.wantErr {
+				return
+			}
+
+			if capture.URL != "https://example.com/page" || string(capture.Content) != page {
+				t.Errorf("ParseCapture() page = %q, %q", capture.URL, capture.Content)
+			}
+
+			if len(capture.Resources) != 2 {
+				t.Errorf("ParseCapture() resources = %d, want 2", len(capture.Resources))
+			}
+
+			img := capture.Resources["https://example.com/dot.png"]
+			if img.ContentType != "image/png" || string(img.Content) != "png" {
+				t.Errorf("ParseCapture() image = %q, %q", img.ContentType, img.Content)
+			}
+		})
+	}
+}
@@ -0,0 +1,176 @@
+package core
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// cronShortcuts is the shortcuts of common cron expressions.
+var cronShortcuts = map[string]string{
+	"@hourly":   "0 * * * *",
+	"@daily":    "0 0 * * *",
+	"@midnight": "0 0 * * *",
+	"@weekly":   "0 0 * * 0",
+	"@monthly":  "0 0 1 * *",
+	"@yearly":   "0 0 1 1 *",
+	"@annually": "0 0 1 1 *",
+}
+
+// CronSchedule is a schedule in cron expression, whose five fields are the
+// minute, hour, day of month, month and day of week, e.g. `30 3 * * 1-5` is
+// 03:30 on weekdays. Each field is `*`, a number, a range like `1-5`, or a
+// list of them separated by comma, and may have step like `*/15`. Day of
+// week is from 0 to 7, where both 0 and 7 are Sunday. Like in cron, when
+// both day of month and day of week are restricted, either of them matches.
+// Shortcuts like `@daily` and `@weekly` are accepted as well.
+type CronSchedule struct {
+	expr   string
+	minute uint64
+	hour   uint64
+	dom    uint64
+	month  uint64
+	dow    uint64
+
+	// anyDay is true when either day of month or day of week is `*`,
+	// so the day must match both of them instead of either.
+	anyDay bool
+}
+
+// ParseCronSchedule parses the cron expression.
+func ParseCronSchedule(expr string) (CronSchedule, error) {
+	schedule := CronSchedule{expr: strings.TrimSpace(expr)}
+
+	fields := strings.Fields(schedule.expr)
+	if len(fields) == 1 {
+		if shortcut, exist := cronShortcuts[strings.ToLower(fields[0])]; exist {
+			fields = strings.Fields(shortcut)
+		}
+	}
+
+	if len(fields) != 5 {
+		return schedule, fmt.Errorf("cron expression %q must have 5 fields", expr)
+	}
+
+	var err error
+	ranges := []struct {
+		name     string
+		field    *uint64
+		min, max int
+	}{
+		{"minute", &schedule.minute, 0, 59},
+		{"hour", &schedule.hour, 0, 23},
+		{"day of month", &schedule.dom, 1, 31},
+		{"month", &schedule.month, 1, 12},
+		{"day of week", &schedule.dow, 0, 7},
+	}
+
+	for i, r := range ranges {
+		*r.field, err = parseCronField(fields[i], r.min, r.max)
+		if err != nil {
+			return schedule, fmt.Errorf("invalid %s in cron expression %q: %v", r.name, expr, err)
+		}
+	}
+
+	// Sunday is either 0 or 7
+	if schedule.dow&(1<<7) != 0 {
+		schedule.dow |= 1
+	}
+
+	schedule.anyDay = strings.HasPrefix(fields[2], "*") || strings.HasPrefix(fields[4], "*")
+	return schedule, nil
+}
+
+// parseCronField parses field of cron expression into bit set of its values.
+func parseCronField(field string, min, max int) (uint64, error) {
+	var bits uint64
+	for _, part := range strings.Split(field, ",") {
+		// Split the step, which is 1 by default
+		step := 1
+		if i := strings.Index(part, "/"); i >= 0 {
+			var err error
+			step, err = strconv.Atoi(part[i+1:])
+			if err != nil || step < 1 {
+				return 0, fmt.Errorf("step %q is not positive number", part[i+1:])
+			}
+			part = part[:i]
+		}
+
+		// Find the range of values
+		start, end := min, max
+		switch {
+		case part == "*":
+		case strings.Contains(part, "-"):
+			i := strings.Index(part, "-")
+			var err1, err2 error
+			start, err1 = strconv.Atoi(part[:i])
+			end, err2 = strconv.Atoi(part[i+1:])
+			if err1 != nil || err2 != nil {
+				return 0, fmt.Errorf("range %q is not valid", part)
+			}
+		default:
+			var err error
+			start, err = strconv.Atoi(part)
+			if err != nil {
+				return 0, fmt.Errorf("value %q is not a number", part)
+			}
+
+			// Value with step, e.g. `5/15`, runs until the max value
+			if step == 1 {
+				end = start
+			}
+		}
+
+		if start < min || end > max || start > end {
+			return 0, fmt.Errorf("%q is out of range %d-%d", part, min, max)
+		}
+
+		for value := start; value <= end; value += step {
+			bits |= 1 << uint(value)
+		}
+	}
+
+	return bits, nil
+}
+
+// String returns the cron expression of the schedule.
+func (s CronSchedule) String() string {
+	return s.expr
+}
+
+// matchDay checks if the day of t matches the schedule.
+func (s CronSchedule) matchDay(t time.Time) bool {
+	domMatch := s.dom&(1<<uint(t.Day())) != 0
+	dowMatch := s.dow&(1<<uint(t.Weekday())) != 0
+	if s.anyDay {
+		return domMatch && dowMatch
+	}
+
+	return domMatch || dowMatch
+}
+
+// Next returns the first time after t that matches the schedule, in the
+// location of t. It returns zero time if there is none within five years,
+// e.g. for the 30th of February.
+func (s CronSchedule) Next(t time.Time) time.Time {
+	t = t.Truncate(time.Minute).Add(time.Minute)
+	limit := t.AddDate(5, 0, 0)
+
+	for t.Before(limit) {
+		switch {
+		case s.month&(1<<uint(t.Month())) == 0:
+			t = time.Date(t.Year(), t.Month()+1, 1, 0, 0, 0, 0, t.Location())
+		case !s.matchDay(t):
+			t = time.Date(t.Year(), t.Month(), t.Day()+1, 0, 0, 0, 0, t.Location())
+		case s.hour&(1<<uint(t.Hour())) == 0:
+			t = time.Date(t.Year(), t.Month(), t.Day(), t.Hour()+1, 0, 0, 0, t.Location())
+		case s.minute&(1<<uint(t.Minute())) == 0:
+			t = t.Add(time.Minute)
+		default:
+			return t
+		}
+	}
+
+	return time.Time{}
+}
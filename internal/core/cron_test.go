@@ -0,0 +1,69 @@
+package core
+
+import (
+	"testing"
+	"time"
+)
+
+func TestCronSchedule(t *testing.T) {
+	// 2020-01-01 is Wednesday
+	from := time.Date(2020, 1, 1, 10, 30, 45, 0, time.UTC)
+
+	tests := []struct {
+		expr string
+		want string
+	}{
+		{"* * * * *", "2020-01-01 10:31"},
+		{"*/15 * * * *", "2020-01-01 10:45"},
+		{"0 3 * * *", "2020-01-02 03:00"},
+		{"@daily", "2020-01-02 00:00"},
+		{"@hourly", "2020-01-01 11:00"},
+		{"30 10 * * *", "2020-01-02 10:30"},
+		{"0 9-17/4 * * *", "2020-01-01 13:00"},
+		{"0 0 * * 0", "2020-01-05 00:00"},
+		{"0 0 * * 7", "2020-01-05 00:00"},
+		{"0 0 * * 1-5", "2020-01-02 00:00"},
+		{"0 0 15 * *", "2020-01-15 00:00"},
+		{"0 0 31 * *", "2020-01-31 00:00"},
+		{"0 0 29 2 *", "2020-02-29 00:00"},
+		{"0 0 1,15 * 6", "2020-01-04 00:00"},
+		{"0 0 1 6 *", "2020-06-01 00:00"},
+		{"0 0 30 2 *", "0001-01-01 00:00"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.expr, func(t *testing.T) {
+			schedule, err := ParseCronSchedule(tt.expr)
+			if err != nil {
+				t.Fatalf("ParseCronSchedule() error = %v", err)
+			}
+
+			if got := schedule.Next(from).Format("2006-01-02 15:04"); got != tt.want {
+				t.Errorf("Next() = %s, want %s", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestParseCronScheduleInvalid(t *testing.T) {
+	tests := []string{
+		"",
+		"* * * *",
+		"* * * * * *",
+		"60 * * * *",
+		"* 24 * * *",
+		"* * 0 * *",
+		"* * * 13 *",
+		"* * * * 8",
+		"5-1 * * * *",
+		"*/0 * * * *",
+		"a * * * *",
+		"@often",
+	}
+
+	for _, expr := range tests {
+		if _, err := ParseCronSchedule(expr); err == nil {
+			t.Errorf("ParseCronSchedule(%q) doesn't return error", expr)
+		}
+	}
+}
@@ -1,31 +1,130 @@
 package core
 
 import (
+	"fmt"
 	"io"
+	"io/ioutil"
 	"net/http"
+	"strings"
 	"time"
+
+	"github.com/sirupsen/logrus"
 )
 
 var httpClient = &http.Client{Timeout: time.Minute}
 
+// linkCheckClient is the client for checking links, which gives up earlier
+// than httpClient since the page isn't downloaded.
+var linkCheckClient = &http.Client{Timeout: 30 * time.Second}
+
 // DownloadBookmark downloads bookmarked page from specified URL.
-// Return response body, make sure to close it later.
-func DownloadBookmark(url string) (io.ReadCloser, string, error) {
+// Return response body, make sure to close it later. The body is returned
+// whatever the status code is, so it's up to caller to check it.
+func DownloadBookmark(url string) (io.ReadCloser, string, int, error) {
 	// Prepare download request
 	req, err := http.NewRequest("GET", url, nil)
 	if err != nil {
-		return nil, "", err
+		return nil, "", 0, err
 	}
 
 	// Send download request
+	start := time.Now()
+	entry := logrus.WithField("url", url)
+
 	req.Header.Set("User-Agent", userAgent)
 	resp, err := httpClient.Do(req)
 	if err != nil {
-		return nil, "", err
+		entry.WithError(err).Debug("failed to download page")
+		return nil, "", 0, err
 	}
 
 	// Get content type
 	contentType := resp.Header.Get("Content-Type")
+	entry.WithFields(logrus.Fields{
+		"status":       resp.StatusCode,
+		"content_type": contentType,
+		"duration_ms":  int64(time.Since(start) / time.Millisecond),
+	}).Debug("downloaded page")
+
+	return resp.Body, contentType, resp.StatusCode, nil
+}
+
+// CheckLink checks whether the URL is still reachable, and returns the HTTP
+// status code it responds with. It sends HEAD request first, then falls back
+// to GET when that fails, since some servers reject HEAD request. Only the
+// headers are read, so the page itself is never downloaded.
+func CheckLink(url string) (int, error) {
+	statusCode, err := checkLink("HEAD", url)
+	if err == nil && statusCode < 400 {
+		return statusCode, nil
+	}
+
+	return checkLink("GET", url)
+}
+
+// checkLink sends request with the method to URL, and returns its status code.
+func checkLink(method string, url string) (int, error) {
+	req, err := http.NewRequest(method, url, nil)
+	if err != nil {
+		return 0, err
+	}
+
+	req.Header.Set("User-Agent", userAgent)
+	resp, err := linkCheckClient.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	resp.Body.Close()
+
+	return resp.StatusCode, nil
+}
+
+// FetchBookmark is like DownloadBookmark, except the page is rendered in a
+// headless browser first when the policy matches its URL. If the rendering
+// failed, it falls back to download the page as it is. The status code of
+// rendered page is unknown, so it's returned as zero.
+//
+// When the policy has WaybackFallback and the page can't be downloaded or is
+// not found, the closest snapshot in Wayback Machine is downloaded instead,
+// and its URL is returned. The status code is still the one of the page.
+func FetchBookmark(url string, policy RenderPolicy) (io.ReadCloser, string, int, string, error) {
+	if RenderingSupported() && policy.Matches(url) {
+		html, err := renderPage(url, policy.Timeout)
+		if err == nil {
+			return ioutil.NopCloser(strings.NewReader(html)), "text/html; charset=utf-8", 0, "", nil
+		}
+
+		logrus.WithError(err).WithField("url", url).Warn("failed to render page, downloading it instead")
+	}
+
+	content, contentType, statusCode, err := DownloadBookmark(url)
+	if !policy.WaybackFallback || !pageGone(statusCode, err) {
+		return content, contentType, statusCode, "", err
+	}
+
+	// Keep the original result, unless the snapshot is found
+	entry := logrus.WithField("url", url)
+	snapshotURL, snapshotErr := FindWaybackSnapshot(url)
+	if snapshotErr != nil {
+		entry.WithError(snapshotErr).Debug("failed to find wayback snapshot")
+		return content, contentType, statusCode, "", err
+	}
+
+	snapshot, snapshotType, snapshotStatus, snapshotErr := DownloadBookmark(snapshotURL)
+	if snapshotErr == nil && snapshotStatus != http.StatusOK {
+		snapshot.Close()
+		snapshotErr = fmt.Errorf("snapshot responded with status %d", snapshotStatus)
+	}
+
+	if snapshotErr != nil {
+		entry.WithError(snapshotErr).Warn("failed to download wayback snapshot")
+		return content, contentType, statusCode, "", err
+	}
+
+	if content != nil {
+		content.Close()
+	}
 
-	return resp.Body, contentType, nil
+	entry.WithField("snapshot", snapshotURL).Info("page is gone, using wayback snapshot instead")
+	return snapshot, snapshotType, statusCode, snapshotURL, nil
 }
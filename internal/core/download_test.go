@@ -0,0 +1,44 @@
+package core
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestCheckLink(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch {
+		case r.URL.Path == "/no-head" && r.Method == "HEAD":
+			w.WriteHeader(http.StatusMethodNotAllowed)
+		case r.URL.Path == "/missing":
+			http.NotFound(w, r)
+		}
+	}))
+	defer srv.Close()
+
+	tests := []struct {
+		name    string
+		url     string
+		want    int
+		wantErr bool
+	}{
+		{"reachable", srv.URL + "/page", http.StatusOK, false},
+		{"HEAD rejected", srv.URL + "/no-head", http.StatusOK, false},
+		{"not found", srv.URL + "/missing", http.StatusNotFound, false},
+		{"unreachable", "http://127.0.0.1:1/", 0, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := CheckLink(tt.url)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("CheckLink() error = %v, wantErr %v", err, tt.wantErr)
+			}
+
+			if got != tt.want {
+				t.Errorf("CheckLink() = %d, want %d", got, tt.want)
+			}
+		})
+	}
+}
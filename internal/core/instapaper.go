@@ -0,0 +1,97 @@
+package core
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/csv"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+)
+
+// InstapaperBookmark is a bookmark read from CSV file exported by Instapaper.
+type InstapaperBookmark struct {
+	URL       string
+	Title     string
+	Selection string // text that selected when it's saved, if any
+	Folder    string // name of its folder, empty for the built-in ones
+	Read      bool   // whether it's in the "Archive" folder
+	Starred   bool   // whether it's in the "Starred" folder
+	AddDate   int64  // Unix epoch in seconds, zero if it's not specified
+}
+
+// ParseInstapaperBookmarks reads bookmarks from CSV file exported by
+// Instapaper, whose columns are named in its first row, i.e. URL, Title,
+// Selection, Folder and Timestamp. The built-in folders "Unread", "Archive"
+// and "Starred" are read as the status of bookmark, while the other folders
+// are kept as they are. The fn is called for each bookmark in order, and
+// the parsing stops once it returns an error, which is then returned as it is.
+func ParseInstapaperBookmarks(r io.Reader, fn func(InstapaperBookmark) error) error {
+	br := bufio.NewReader(r)
+	if bom, _ := br.Peek(3); bytes.Equal(bom, []byte{0xEF, 0xBB, 0xBF}) {
+		br.Discard(3)
+	}
+
+	reader := csv.NewReader(br)
+	reader.FieldsPerRecord = -1
+
+	header, err := reader.Read()
+	if err == io.EOF {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("failed to read CSV header: %v", err)
+	}
+
+	columns := map[string]int{}
+	for i, name := range header {
+		columns[strings.ToLower(strings.TrimSpace(name))] = i
+	}
+
+	if _, exist := columns["url"]; !exist {
+		return fmt.Errorf("CSV file doesn't have URL column")
+	}
+
+	for {
+		record, err := reader.Read()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+
+		field := func(name string) string {
+			if i, exist := columns[name]; exist && i < len(record) {
+				return strings.TrimSpace(record[i])
+			}
+			return ""
+		}
+
+		book := InstapaperBookmark{
+			URL:       field("url"),
+			Title:     strings.Join(strings.Fields(field("title")), " "),
+			Selection: field("selection"),
+		}
+		book.AddDate, _ = strconv.ParseInt(field("timestamp"), 10, 64)
+
+		switch folder := strings.Join(strings.Fields(field("folder")), " "); strings.ToLower(folder) {
+		case "", "unread":
+		case "archive":
+			book.Read = true
+		case "starred":
+			book.Starred = true
+		default:
+			book.Folder = folder
+		}
+
+		if book.URL == "" {
+			continue
+		}
+
+		if err := fn(book); err != nil {
+			return err
+		}
+	}
+}
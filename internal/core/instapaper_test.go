@@ -0,0 +1,57 @@
+package core
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestParseInstapaperBookmarks(t *testing.T) {
+	tests := []struct {
+		name    string
+		file    string
+		want    []InstapaperBookmark
+		wantErr bool
+	}{{
+		name: "bookmarks",
+		file: "\xEF\xBB\xBFURL,Title,Selection,Folder,Timestamp\n" +
+			"https://example.com/unread,Unread item,,Unread,1500000000\n" +
+			"https://example.com/read,\"Read, item\",Selected text,Archive,\n" +
+			"https://example.com/starred,Starred item,,Starred,1500000000\n" +
+			"https://example.com/folder,Folder item,,  Go   Web ,1500000000\n" +
+			",Without URL,,Unread,1500000000\n",
+		want: []InstapaperBookmark{
+			{URL: "https://example.com/unread", Title: "Unread item", AddDate: 1500000000},
+			{URL: "https://example.com/read", Title: "Read, item", Selection: "Selected text", Read: true},
+			{URL: "https://example.com/starred", Title: "Starred item", Starred: true, AddDate: 1500000000},
+			{URL: "https://example.com/folder", Title: "Folder item", Folder: "Go Web", AddDate: 1500000000},
+		},
+	}, {
+		name: "empty",
+		file: "",
+		want: []InstapaperBookmark{},
+	}, {
+		name:    "without URL column",
+		file:    "Title,Link\nA,https://example.com\n",
+		want:    []InstapaperBookmark{},
+		wantErr: true,
+	}}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := []InstapaperBookmark{}
+			err := ParseInstapaperBookmarks(strings.NewReader(tt.file), func(book InstapaperBookmark) error {
+				got = append(got, book)
+				return nil
+			})
+
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("ParseInstapaperBookmarks() error = %v, wantErr %v", err, tt.wantErr)
+			}
+
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("ParseInstapaperBookmarks() =\n%+v\nwant\n%+v", got, tt.want)
+			}
+		})
+	}
+}
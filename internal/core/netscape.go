@@ -0,0 +1,182 @@
+package core
+
+import (
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"time"
+
+	"shiori/internal/model"
+	"golang.org/x/net/html"
+)
+
+// NetscapeBookmark is a bookmark read from file in Netscape Bookmark format.
+type NetscapeBookmark struct {
+	URL     string
+	Title   string
+	Tags    []string
+	Folder  string   // name of the innermost folder that contains the bookmark
+	Folders []string // names of the folders that contain the bookmark, outermost first
+	AddDate int64    // Unix epoch in seconds, zero if it's not specified
+}
+
+// ParseNetscapeBookmarks reads bookmarks in Netscape Bookmark format from r.
+// The file is tokenized as it's read, so even a huge file is never loaded
+// into memory at once. The fn is called for each bookmark in order, and the
+// parsing stops once it returns an error, which is then returned as it is.
+func ParseNetscapeBookmarks(r io.Reader, fn func(NetscapeBookmark) error) error {
+	tokenizer := html.NewTokenizer(r)
+
+	// Folder is declared by H3, followed by DL that contains its bookmarks
+	folders := []string{}
+	folderName := ""
+	inFolderName := false
+
+	var book *NetscapeBookmark
+	for {
+		tokenType := tokenizer.Next()
+		switch tokenType {
+		case html.ErrorToken:
+			if err := tokenizer.Err(); err != io.EOF {
+				return err
+			}
+			return nil
+
+		case html.TextToken:
+			if book != nil {
+				book.Title += string(tokenizer.Text())
+			} else if inFolderName {
+				folderName += string(tokenizer.Text())
+			}
+
+		case html.StartTagToken, html.EndTagToken:
+			tagName, hasAttr := tokenizer.TagName()
+			isStart := tokenType == html.StartTagToken
+
+			switch string(tagName) {
+			case "h3":
+				inFolderName = isStart
+				if isStart {
+					folderName = ""
+				}
+
+			case "dl":
+				if isStart {
+					folders = append(folders, strings.Join(strings.Fields(folderName), " "))
+					folderName = ""
+				} else if len(folders) > 0 {
+					folders = folders[:len(folders)-1]
+				}
+
+			case "a":
+				if !isStart {
+					if book != nil && book.URL != "" {
+						book.Title = strings.Join(strings.Fields(book.Title), " ")
+						if err := fn(*book); err != nil {
+							return err
+						}
+					}
+
+					book = nil
+					continue
+				}
+
+				book = &NetscapeBookmark{}
+				if len(folders) > 0 {
+					book.Folder = folders[len(folders)-1]
+				}
+
+				for _, folder := range folders {
+					if folder != "" {
+						book.Folders = append(book.Folders, folder)
+					}
+				}
+
+				for hasAttr {
+					var key, val []byte
+					key, val, hasAttr = tokenizer.TagAttr()
+
+					switch string(key) {
+					case "href":
+						book.URL = strings.TrimSpace(string(val))
+					case "add_date":
+						book.AddDate, _ = strconv.ParseInt(strings.TrimSpace(string(val)), 10, 64)
+					case "tags":
+						for _, tag := range strings.Split(string(val), ",") {
+							tag = strings.Join(strings.Fields(tag), " ")
+							if tag != "" {
+								book.Tags = append(book.Tags, tag)
+							}
+						}
+					}
+				}
+			}
+		}
+	}
+}
+
+// NetscapeWriter writes bookmarks in Netscape Bookmark format, which every
+// browser is able to import. The bookmarks are written one by one, so the
+// whole export is never kept in memory.
+type NetscapeWriter struct {
+	w io.Writer
+}
+
+// NewNetscapeWriter writes the header of Netscape Bookmark file into w, and
+// returns the writer for its bookmarks. The file is completed by Close.
+func NewNetscapeWriter(w io.Writer) (*NetscapeWriter, error) {
+	_, err := io.WriteString(w, "<!DOCTYPE NETSCAPE-Bookmark-file-1>\n"+
+		"<!-- This is an automatically generated file.\n"+
+		"     It will be read and overwritten.\n"+
+		"     DO NOT EDIT! -->\n"+
+		`<META HTTP-EQUIV="Content-Type" CONTENT="text/html; charset=UTF-8">`+"\n"+
+		"<TITLE>Bookmarks</TITLE>\n"+
+		"<H1>Bookmarks</H1>\n"+
+		"<DL><p>\n")
+	if err != nil {
+		return nil, err
+	}
+
+	return &NetscapeWriter{w: w}, nil
+}
+
+// Write writes the bookmark, with its tags as TAGS attribute and its excerpt
+// as description. Unknown modified time is treated as now, while unknown
+// created time is treated as the modified time.
+func (nw *NetscapeWriter) Write(book model.Bookmark) error {
+	modifiedTime, err := time.Parse("2006-01-02 15:04:05", book.Modified)
+	if err != nil {
+		modifiedTime = time.Now()
+	}
+
+	createdTime, err := time.Parse("2006-01-02 15:04:05", book.Created)
+	if err != nil {
+		createdTime = modifiedTime
+	}
+
+	tagNames := make([]string, len(book.Tags))
+	for i, tag := range book.Tags {
+		tagNames[i] = tag.Name
+	}
+
+	line := fmt.Sprintf(`    <DT><A HREF="%s" ADD_DATE="%d" LAST_MODIFIED="%d" TAGS="%s">%s</A>`+"\n",
+		html.EscapeString(book.URL),
+		createdTime.Unix(),
+		modifiedTime.Unix(),
+		html.EscapeString(strings.Join(tagNames, ",")),
+		html.EscapeString(CleanTitle(book.Title, book.URL)))
+
+	if excerpt := strings.TrimSpace(book.Excerpt); excerpt != "" {
+		line += "    <DD>" + html.EscapeString(excerpt) + "\n"
+	}
+
+	_, err = io.WriteString(nw.w, line)
+	return err
+}
+
+// Close completes the file. It doesn't close the underlying writer.
+func (nw *NetscapeWriter) Close() error {
+	_, err := io.WriteString(nw.w, "</DL><p>\n")
+	return err
+}
@@ -0,0 +1,126 @@
+package core
+
+import (
+	"bytes"
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+
+	"shiori/internal/model"
+)
+
+const netscapeFile = `<!DOCTYPE NETSCAPE-Bookmark-file-1>
+<META HTTP-EQUIV="Content-Type" CONTENT="text/html; charset=UTF-8">
+<TITLE>Bookmarks</TITLE>
+<H1>Bookmarks</H1>
+<DL><p>
+	<DT><A HREF="https://example.com/top" ADD_DATE="1500000000">Top &amp; level</A>
+	<DT><H3>Go  Lang</H3>
+	<DL><p>
+		<DT><A HREF="https://golang.org" TAGS="go, lang ,">The Go
+			Programming Language</A>
+		<DT><H3>Nested</H3>
+		<DL><p>
+			<DT><A HREF="https://example.com/nested" ADD_DATE="invalid"></A>
+		</DL><p>
+		<DT><A HREF="https://example.com/after">After nested</A>
+	</DL><p>
+	<DT><A>Without URL</A>
+	<DT><A HREF="https://example.com/last">Last</A>
+</DL><p>`
+
+func TestParseNetscapeBookmarks(t *testing.T) {
+	want := []NetscapeBookmark{
+		{URL: "https://example.com/top", Title: "Top & level", AddDate: 1500000000},
+		{URL: "https://golang.org", Title: "The Go Programming Language", Tags: []string{"go", "lang"},
+			Folder: "Go Lang", Folders: []string{"Go Lang"}},
+		{URL: "https://example.com/nested", Folder: "Nested", Folders: []string{"Go Lang", "Nested"}},
+		{URL: "https://example.com/after", Title: "After nested", Folder: "Go Lang", Folders: []string{"Go Lang"}},
+		{URL: "https://example.com/last", Title: "Last"},
+	}
+
+	got := []NetscapeBookmark{}
+	err := ParseNetscapeBookmarks(strings.NewReader(netscapeFile), func(book NetscapeBookmark) error {
+		got = append(got, book)
+		return nil
+	})
+
+	if err != nil {
+		t.Fatalf("ParseNetscapeBookmarks() error = %v", err)
+	}
+
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("ParseNetscapeBookmarks() =\n%+v\nwant\n%+v", got, want)
+	}
+}
+
+func TestParseNetscapeBookmarksStop(t *testing.T) {
+	errStop := errors.New("stop")
+
+	nParsed := 0
+	err := ParseNetscapeBookmarks(strings.NewReader(netscapeFile), func(book NetscapeBookmark) error {
+		nParsed++
+		if nParsed == 2 {
+			return errStop
+		}
+		return nil
+	})
+
+	if err != errStop {
+		t.Errorf("ParseNetscapeBookmarks() error = %v, want %v", err, errStop)
+	}
+
+	if nParsed != 2 {
+		t.Errorf("ParseNetscapeBookmarks() parsed %d bookmarks, want 2", nParsed)
+	}
+}
+
+func TestNetscapeWriter(t *testing.T) {
+	bookmarks := []model.Bookmark{{
+		URL:      "https://example.com/?a=1&b=2",
+		Title:    `Title with <html> & "quotes"`,
+		Excerpt:  "Some excerpt",
+		Created:  "2017-07-14 02:40:00",
+		Modified: "2017-07-15 02:40:00",
+		Tags:     []model.Tag{{Name: "go"}, {Name: "web dev"}},
+	}, {
+		URL:      "https://example.com/untitled",
+		Modified: "2017-07-15 02:40:00",
+	}}
+
+	buf := &bytes.Buffer{}
+	writer, err := NewNetscapeWriter(buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, book := range bookmarks {
+		if err := writer.Write(book); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if err := writer.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	// The written file is read back as it is
+	got := []NetscapeBookmark{}
+	err = ParseNetscapeBookmarks(buf, func(book NetscapeBookmark) error {
+		got = append(got, book)
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := []NetscapeBookmark{
+		{URL: "https://example.com/?a=1&b=2", Title: `Title with <html> & "quotes"`, Tags: []string{"go", "web dev"}, AddDate: 1500000000},
+		{URL: "https://example.com/untitled", Title: "https://example.com/untitled", AddDate: 1500086400},
+	}
+
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("written bookmarks =\n%+v\nwant\n%+v", got, want)
+	}
+}
@@ -0,0 +1,123 @@
+package core
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"strings"
+	"time"
+
+	"shiori/internal/model"
+)
+
+// PinboardBookmark is a bookmark in the JSON format of Pinboard's export,
+// which is read when importing from Pinboard and written when exporting
+// for it. Its fields are kept as they are in the file.
+type PinboardBookmark struct {
+	Href        string `json:"href"`
+	Description string `json:"description"` // title of the bookmark
+	Extended    string `json:"extended"`    // notes of the bookmark
+	Time        string `json:"time"`        // RFC3339 time when it's added
+	Shared      string `json:"shared"`      // "yes" when it's public
+	ToRead      string `json:"toread"`      // "yes" when it's unread
+	Tags        string `json:"tags"`        // separated by space
+}
+
+// TagNames returns the tags of the bookmark.
+func (book PinboardBookmark) TagNames() []string {
+	return strings.Fields(book.Tags)
+}
+
+// AddDate returns the time when the bookmark is added as Unix epoch in
+// seconds, or zero if it's not specified or invalid.
+func (book PinboardBookmark) AddDate() int64 {
+	t, err := time.Parse(time.RFC3339, strings.TrimSpace(book.Time))
+	if err != nil {
+		return 0
+	}
+
+	return t.Unix()
+}
+
+// IsShared returns whether the bookmark is public.
+func (book PinboardBookmark) IsShared() bool {
+	return strings.EqualFold(strings.TrimSpace(book.Shared), "yes")
+}
+
+// IsToRead returns whether the bookmark is still unread.
+func (book PinboardBookmark) IsToRead() bool {
+	return strings.EqualFold(strings.TrimSpace(book.ToRead), "yes")
+}
+
+// NewPinboardBookmark converts the bookmark into Pinboard's format. Since
+// Pinboard separates tags by space, whitespace in tag name is replaced
+// with underscore.
+func NewPinboardBookmark(book model.Bookmark) PinboardBookmark {
+	tagNames := make([]string, len(book.Tags))
+	for i, tag := range book.Tags {
+		tagNames[i] = strings.Join(strings.Fields(tag.Name), "_")
+	}
+
+	result := PinboardBookmark{
+		Href:        book.URL,
+		Description: book.Title,
+		Extended:    book.Excerpt,
+		Shared:      "no",
+		ToRead:      "yes",
+		Tags:        strings.Join(tagNames, " "),
+	}
+
+	if created, err := time.Parse("2006-01-02 15:04:05", book.Created); err == nil {
+		result.Time = created.UTC().Format(time.RFC3339)
+	}
+
+	if book.Public == 1 {
+		result.Shared = "yes"
+	}
+
+	if book.Read {
+		result.ToRead = "no"
+	}
+
+	return result
+}
+
+// ParsePinboardBookmarks reads bookmarks from file exported by Pinboard in
+// JSON format, which is an array of bookmarks. The array is decoded item by
+// item, so even a huge file is never loaded into memory at once. The fn is
+// called for each bookmark in order, and the parsing stops once it returns
+// an error, which is then returned as it is.
+func ParsePinboardBookmarks(r io.Reader, fn func(PinboardBookmark) error) error {
+	decoder := json.NewDecoder(r)
+
+	token, err := decoder.Token()
+	if err == io.EOF {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	if delim, ok := token.(json.Delim); !ok || delim != '[' {
+		return fmt.Errorf("JSON file isn't an array of bookmarks")
+	}
+
+	for decoder.More() {
+		var book PinboardBookmark
+		if err := decoder.Decode(&book); err != nil {
+			return err
+		}
+
+		book.Href = strings.TrimSpace(book.Href)
+		if book.Href == "" {
+			continue
+		}
+
+		if err := fn(book); err != nil {
+			return err
+		}
+	}
+
+	_, err = decoder.Token()
+	return err
+}
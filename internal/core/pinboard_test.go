@@ -0,0 +1,114 @@
+package core
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+
+	"shiori/internal/model"
+)
+
+func TestParsePinboardBookmarks(t *testing.T) {
+	tests := []struct {
+		name    string
+		file    string
+		want    []PinboardBookmark
+		wantErr bool
+	}{{
+		name: "bookmarks",
+		file: `[
+			{"href":"https://example.com/unread","description":"Unread item","extended":"Notes","meta":"abc","hash":"def",
+			 "time":"2017-07-14T02:40:00Z","shared":"yes","toread":"yes","tags":"go  web"},
+			{"href":" ","description":"Without URL"},
+			{"href":"https://example.com/read","description":"Read item","time":"invalid","shared":"no","toread":"no","tags":""}
+		]`,
+		want: []PinboardBookmark{
+			{Href: "https://example.com/unread", Description: "Unread item", Extended: "Notes", Time: "2017-07-14T02:40:00Z", Shared: "yes", ToRead: "yes", Tags: "go  web"},
+			{Href: "https://example.com/read", Description: "Read item", Time: "invalid", Shared: "no", ToRead: "no"},
+		},
+	}, {
+		name: "empty",
+		file: " \n",
+		want: []PinboardBookmark{},
+	}, {
+		name:    "not array",
+		file:    `{"href":"https://example.com"}`,
+		want:    []PinboardBookmark{},
+		wantErr: true,
+	}, {
+		name:    "truncated",
+		file:    `[{"href":"https://example.com"}`,
+		want:    []PinboardBookmark{{Href: "https://example.com"}},
+		wantErr: true,
+	}}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := []PinboardBookmark{}
+			err := ParsePinboardBookmarks(strings.NewReader(tt.file), func(book PinboardBookmark) error {
+				got = append(got, book)
+				return nil
+			})
+
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("ParsePinboardBookmarks() error = %v, wantErr %v", err, tt.wantErr)
+			}
+
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("ParsePinboardBookmarks() =\n%+v\nwant\n%+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestPinboardBookmark(t *testing.T) {
+	book := PinboardBookmark{Time: "2017-07-14T02:40:00Z", Shared: "yes", ToRead: "no", Tags: " go  web "}
+
+	if got := book.TagNames(); !reflect.DeepEqual(got, []string{"go", "web"}) {
+		t.Errorf("TagNames() = %v", got)
+	}
+
+	if got := book.AddDate(); got != 1500000000 {
+		t.Errorf("AddDate() = %d, want 1500000000", got)
+	}
+
+	if !book.IsShared() || book.IsToRead() {
+		t.Errorf("IsShared() = %v, IsToRead() = %v", book.IsShared(), book.IsToRead())
+	}
+
+	if got := (PinboardBookmark{Time: "invalid"}).AddDate(); got != 0 {
+		t.Errorf("AddDate() of invalid time = %d, want 0", got)
+	}
+}
+
+func TestNewPinboardBookmark(t *testing.T) {
+	tests := []struct {
+		name string
+		book model.Bookmark
+		want PinboardBookmark
+	}{{
+		name: "public read",
+		book: model.Bookmark{
+			URL:     "https://example.com",
+			Title:   "Example",
+			Excerpt: "Notes",
+			Created: "2017-07-14 02:40:00",
+			Public:  1,
+			Read:    true,
+			Tags:    []model.Tag{{Name: "go"}, {Name: "web dev"}},
+		},
+		want: PinboardBookmark{Href: "https://example.com", Description: "Example", Extended: "Notes", Time: "2017-07-14T02:40:00Z", Shared: "yes", ToRead: "no", Tags: "go web_dev"},
+	}, {
+		name: "private unread",
+		book: model.Bookmark{URL: "https://example.com", Title: "Example"},
+		want: PinboardBookmark{Href: "https://example.com", Description: "Example", Shared: "no", ToRead: "yes"},
+	}}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := NewPinboardBookmark(tt.book); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("NewPinboardBookmark() =\n%+v\nwant\n%+v", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,205 @@
+package core
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/csv"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+
+	"golang.org/x/net/html"
+)
+
+// PocketBookmark is a bookmark read from file exported by Pocket.
+type PocketBookmark struct {
+	URL      string
+	Title    string
+	Tags     []string
+	Read     bool  // whether it's in the archive of read items
+	Favorite bool  // whether it's marked as favorite
+	AddDate  int64 // Unix epoch in seconds, zero if it's not specified
+}
+
+// ParsePocketBookmarks reads bookmarks from file exported by Pocket, which is
+// either the HTML file with lists of unread and read items, or the CSV file
+// with a row for each item. The format is detected from the content. The fn
+// is called for each bookmark in order, and the parsing stops once it returns
+// an error, which is then returned as it is.
+func ParsePocketBookmarks(r io.Reader, fn func(PocketBookmark) error) error {
+	br := bufio.NewReader(r)
+	if bom, _ := br.Peek(3); bytes.Equal(bom, []byte{0xEF, 0xBB, 0xBF}) {
+		br.Discard(3)
+	}
+
+	// HTML starts with a tag, while CSV starts with its header
+	for {
+		b, err := br.ReadByte()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+
+		switch b {
+		case ' ', '\t', '\r', '\n':
+			continue
+		case '<':
+			br.UnreadByte()
+			return parsePocketHTML(br, fn)
+		default:
+			br.UnreadByte()
+			return parsePocketCSV(br, fn)
+		}
+	}
+}
+
+// parsePocketHTML reads the HTML file exported by Pocket, where the items
+// are listed under the heading of their state, "Unread" or "Read Archive".
+func parsePocketHTML(r io.Reader, fn func(PocketBookmark) error) error {
+	tokenizer := html.NewTokenizer(r)
+
+	heading := ""
+	inHeading := false
+	read := false
+
+	var book *PocketBookmark
+	for {
+		tokenType := tokenizer.Next()
+		switch tokenType {
+		case html.ErrorToken:
+			if err := tokenizer.Err(); err != io.EOF {
+				return err
+			}
+			return nil
+
+		case html.TextToken:
+			if book != nil {
+				book.Title += string(tokenizer.Text())
+			} else if inHeading {
+				heading += string(tokenizer.Text())
+			}
+
+		case html.StartTagToken, html.EndTagToken:
+			tagName, hasAttr := tokenizer.TagName()
+			isStart := tokenType == html.StartTagToken
+
+			switch string(tagName) {
+			case "h1":
+				inHeading = isStart
+				if isStart {
+					heading = ""
+				} else {
+					read = strings.Contains(strings.ToLower(heading), "read archive")
+				}
+
+			case "a":
+				if !isStart {
+					if book != nil && book.URL != "" {
+						book.Title = strings.Join(strings.Fields(book.Title), " ")
+						if err := fn(*book); err != nil {
+							return err
+						}
+					}
+
+					book = nil
+					continue
+				}
+
+				book = &PocketBookmark{Read: read}
+				for hasAttr {
+					var key, val []byte
+					key, val, hasAttr = tokenizer.TagAttr()
+
+					switch string(key) {
+					case "href":
+						book.URL = strings.TrimSpace(string(val))
+					case "time_added":
+						book.AddDate, _ = strconv.ParseInt(strings.TrimSpace(string(val)), 10, 64)
+					case "tags":
+						book.Tags = splitPocketTags(string(val), ",")
+					case "favorite":
+						book.Favorite = parsePocketFlag(string(val))
+					}
+				}
+			}
+		}
+	}
+}
+
+// parsePocketCSV reads the CSV file exported by Pocket, whose columns are
+// named in its first row. The item is read when its status is "archive",
+// while its tags are separated by "|".
+func parsePocketCSV(r io.Reader, fn func(PocketBookmark) error) error {
+	reader := csv.NewReader(r)
+	reader.FieldsPerRecord = -1
+
+	header, err := reader.Read()
+	if err != nil {
+		return fmt.Errorf("failed to read CSV header: %v", err)
+	}
+
+	columns := map[string]int{}
+	for i, name := range header {
+		columns[strings.ToLower(strings.TrimSpace(name))] = i
+	}
+
+	if _, exist := columns["url"]; !exist {
+		return fmt.Errorf("CSV file doesn't have url column")
+	}
+
+	for {
+		record, err := reader.Read()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+
+		field := func(name string) string {
+			if i, exist := columns[name]; exist && i < len(record) {
+				return strings.TrimSpace(record[i])
+			}
+			return ""
+		}
+
+		book := PocketBookmark{
+			URL:      field("url"),
+			Title:    strings.Join(strings.Fields(field("title")), " "),
+			Tags:     splitPocketTags(field("tags"), "|"),
+			Read:     strings.EqualFold(field("status"), "archive"),
+			Favorite: parsePocketFlag(field("favorite")),
+		}
+		book.AddDate, _ = strconv.ParseInt(field("time_added"), 10, 64)
+
+		if book.URL == "" {
+			continue
+		}
+
+		if err := fn(book); err != nil {
+			return err
+		}
+	}
+}
+
+// splitPocketTags splits the tags of Pocket item by the separator.
+func splitPocketTags(s string, sep string) []string {
+	var tags []string
+	for _, tag := range strings.Split(s, sep) {
+		tag = strings.Join(strings.Fields(tag), " ")
+		if tag != "" {
+			tags = append(tags, tag)
+		}
+	}
+
+	return tags
+}
+
+// parsePocketFlag reads boolean field of Pocket item, e.g. "1" or "true".
+func parsePocketFlag(s string) bool {
+	flag, _ := strconv.ParseBool(strings.TrimSpace(s))
+	return flag
+}
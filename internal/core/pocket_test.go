@@ -0,0 +1,77 @@
+package core
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestParsePocketBookmarks(t *testing.T) {
+	tests := []struct {
+		name string
+		file string
+		want []PocketBookmark
+	}{{
+		name: "html",
+		file: `<!DOCTYPE html>
+<html><head><title>Pocket Export</title></head>
+<body>
+<h1>Unread</h1>
+<ul>
+	<li><a href="https://example.com/unread" time_added="1500000000" tags="go,web">Unread
+		item</a></li>
+	<li><a>Without URL</a></li>
+</ul>
+<h1>Read Archive</h1>
+<ul>
+	<li><a href="https://example.com/read" time_added="invalid" tags="" favorite="1">Read item</a></li>
+</ul>
+</body></html>`,
+		want: []PocketBookmark{
+			{URL: "https://example.com/unread", Title: "Unread item", Tags: []string{"go", "web"}, AddDate: 1500000000},
+			{URL: "https://example.com/read", Title: "Read item", Read: true, Favorite: true},
+		},
+	}, {
+		name: "csv",
+		file: "\xEF\xBB\xBFtitle,url,time_added,tags,status,favorite\n" +
+			"Unread item,https://example.com/unread,1500000000,go|web ,unread,0\n" +
+			"\"Read, item\",https://example.com/read,,,archive,1\n" +
+			"Without URL,,1500000000,,unread,0\n",
+		want: []PocketBookmark{
+			{URL: "https://example.com/unread", Title: "Unread item", Tags: []string{"go", "web"}, AddDate: 1500000000},
+			{URL: "https://example.com/read", Title: "Read, item", Read: true, Favorite: true},
+		},
+	}, {
+		name: "empty",
+		file: " \n",
+		want: []PocketBookmark{},
+	}}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := []PocketBookmark{}
+			err := ParsePocketBookmarks(strings.NewReader(tt.file), func(book PocketBookmark) error {
+				got = append(got, book)
+				return nil
+			})
+
+			if err != nil {
+				t.Fatalf("ParsePocketBookmarks() error = %v", err)
+			}
+
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("ParsePocketBookmarks() =\n%+v\nwant\n%+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestParsePocketBookmarksInvalidCSV(t *testing.T) {
+	err := ParsePocketBookmarks(strings.NewReader("title,link\nA,https://example.com\n"), func(PocketBookmark) error {
+		return nil
+	})
+
+	if err == nil {
+		t.Errorf("ParsePocketBookmarks() of CSV without url column doesn't return error")
+	}
+}
@@ -0,0 +1,54 @@
+package core
+
+import (
+	nurl "net/url"
+	"strings"
+)
+
+// ArchivalPolicy decides which bookmarks may be archived based on their host.
+// A domain matches its own host and all of its subdomains.
+type ArchivalPolicy struct {
+	// AllowedDomains is list of domains that may be archived.
+	// If empty, all domains are allowed unless blocked.
+	AllowedDomains []string
+
+	// BlockedDomains is list of domains that never archived.
+	// It takes precedence over AllowedDomains.
+	BlockedDomains []string
+}
+
+// Allows checks if bookmark with the specified URL may be archived.
+func (p ArchivalPolicy) Allows(url string) bool {
+	if len(p.AllowedDomains) == 0 && len(p.BlockedDomains) == 0 {
+		return true
+	}
+
+	parsedURL, err := nurl.Parse(url)
+	if err != nil {
+		return false
+	}
+
+	host := normalizeHostname(parsedURL.Hostname())
+	if matchDomains(host, p.BlockedDomains) {
+		return false
+	}
+
+	return len(p.AllowedDomains) == 0 || matchDomains(host, p.AllowedDomains)
+}
+
+// matchDomains checks if host is one of the domains or their subdomain.
+// The host must be normalized, while the domain may be written in Unicode.
+func matchDomains(host string, domains []string) bool {
+	for _, domain := range domains {
+		domain = normalizeHostname(strings.Trim(domain, ". "))
+		if domain == "" {
+			continue
+		}
+
+		if host == domain || strings.HasSuffix(host, "."+domain) {
+			return true
+		}
+	}
+
+	return false
+}
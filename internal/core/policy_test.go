@@ -0,0 +1,35 @@
+package core
+
+import "testing"
+
+func TestArchivalPolicy_Allows(t *testing.T) {
+	tests := []struct {
+		name   string
+		policy ArchivalPolicy
+		url    string
+		want   bool
+	}{
+		{"empty policy", ArchivalPolicy{}, "https://example.com/article", true},
+		{"allowed domain", ArchivalPolicy{AllowedDomains: []string{"example.com"}}, "https://example.com/a", true},
+		{"allowed subdomain", ArchivalPolicy{AllowedDomains: []string{"example.com"}}, "https://news.Example.com/a", true},
+		{"not allowed domain", ArchivalPolicy{AllowedDomains: []string{"example.com"}}, "https://other.org/a", false},
+		{"suffix is not subdomain", ArchivalPolicy{AllowedDomains: []string{"example.com"}}, "https://badexample.com/a", false},
+		{"blocked domain", ArchivalPolicy{BlockedDomains: []string{"example.com"}}, "https://example.com/a", false},
+		{"not blocked domain", ArchivalPolicy{BlockedDomains: []string{"example.com"}}, "https://other.org/a", true},
+		{"block wins over allow", ArchivalPolicy{
+			AllowedDomains: []string{"example.com"},
+			BlockedDomains: []string{"ads.example.com"},
+		}, "https://ads.example.com/a", false},
+		{"unicode domain", ArchivalPolicy{AllowedDomains: []string{"bücher.example"}}, "https://xn--bcher-kva.example/a", true},
+		{"punycode domain", ArchivalPolicy{BlockedDomains: []string{"xn--bcher-kva.example"}}, "https://shop.bücher.example/a", false},
+		{"IPv6 literal", ArchivalPolicy{BlockedDomains: []string{"::1"}}, "http://[::1]:8080/a", false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.policy.Allows(tt.url); got != tt.want {
+				t.Errorf("ArchivalPolicy.Allows() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
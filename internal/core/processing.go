@@ -2,23 +2,30 @@ package core
 
 import (
 	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
 	"fmt"
 	"image"
 	"image/color"
 	"image/draw"
 	"image/jpeg"
 	"io"
+	"io/ioutil"
 	"math"
+	"mime"
 	"os"
 	"path"
 	fp "path/filepath"
 	"strconv"
 	"strings"
+	"unicode/utf8"
 
 	"github.com/disintegration/imaging"
 	"github.com/go-shiori/go-readability"
 	"shiori/internal/model"
 	"github.com/go-shiori/warc"
+	"golang.org/x/net/html/charset"
 
 	// Add support for png
 	_ "image/png"
@@ -33,46 +40,94 @@ type ProcessRequest struct {
 	KeepTitle   bool
 	KeepExcerpt bool
 	LogArchival bool
+
+	// MaxResources is max number of sub-resources that archived
+	// for a bookmark. Zero means there is no limit.
+	MaxResources int
+
+	// ArchivalPolicy decides whether the bookmark may be archived.
+	ArchivalPolicy ArchivalPolicy
+
+	// SkipUnchanged stops the processing with ErrUnchanged when the content
+	// has the same hash as the bookmark's, and it doesn't need to be archived.
+	SkipUnchanged bool
+
+	// MaxSnapshots is max number of past archives that kept for bookmark
+	// with versioned archive. Zero means there is no limit.
+	MaxSnapshots int
+
+	// RenderPolicy decides whether screenshot of the page is captured
+	// when the bookmark is archived.
+	RenderPolicy RenderPolicy
+
+	// Capture is the page that captured by other tool, whose captured
+	// sub-resources are used for its single-file archive.
+	Capture *Capture
 }
 
+// ErrUnchanged is returned by ProcessBookmark when the content hasn't changed
+// since the bookmark was processed, and SkipUnchanged is requested.
+var ErrUnchanged = errors.New("content is unchanged")
+
 // ProcessBookmark process the bookmark and archive it if needed.
 // Return three values, the bookmark itself, is error fatal, and error value.
 func ProcessBookmark(req ProcessRequest) (model.Bookmark, bool, error) {
 	book := req.Bookmark
 	contentType := req.ContentType
 
+	// Page that downloaded from Wayback Machine is resolved against its
+	// snapshot, so its sub-resources are downloaded from the archive too
+	pageURL := book.URL
+	if book.WaybackURL != "" {
+		pageURL = book.WaybackURL
+	}
+
 	// Make sure bookmark ID is defined
 	if book.ID == 0 {
 		return book, true, fmt.Errorf("bookmark ID is not valid")
 	}
 
-	// Split bookmark content so it can be processed several times
-	archivalInput := bytes.NewBuffer(nil)
-	readabilityInput := bytes.NewBuffer(nil)
-	readabilityCheckInput := bytes.NewBuffer(nil)
+	if err := CheckArchiveFormat(book.ArchiveFormat); err != nil {
+		return book, true, err
+	}
 
-	var multiWriter io.Writer
-	if !strings.Contains(contentType, "text/html") {
-		multiWriter = io.MultiWriter(archivalInput)
-	} else {
-		multiWriter = io.MultiWriter(archivalInput, readabilityInput, readabilityCheckInput)
+	// Save the media type without its parameters, e.g. charset
+	if mediaType, _, err := mime.ParseMediaType(contentType); err == nil {
+		book.ContentType = mediaType
 	}
 
-	_, err := io.Copy(multiWriter, req.Content)
+	// Read bookmark content so it can be processed several times
+	archivalInput := bytes.NewBuffer(nil)
+	_, err := io.Copy(archivalInput, req.Content)
 	if err != nil {
 		return book, false, fmt.Errorf("failed to process article: %v", err)
 	}
 
+	// Hash the content, so later refresh can tell whether it's changed
+	contentHash := ContentHash(archivalInput.Bytes())
+
 	// If this is HTML, parse for readable content
 	var imageURLs []string
 	if strings.Contains(contentType, "text/html") {
-		isReadable := readability.IsReadable(readabilityCheckInput)
+		// Readability expects UTF-8, so page in other charset is converted first.
+		// Archive keeps the original content, which browser is able to decode.
+		readabilityContent, err := convertToUTF8(archivalInput.Bytes(), contentType)
+		if err != nil {
+			return book, false, fmt.Errorf("failed to decode article: %v", err)
+		}
 
-		article, err := readability.FromReader(readabilityInput, book.URL)
+		isReadable := readability.IsReadable(bytes.NewReader(readabilityContent))
+
+		article, err := readability.FromReader(bytes.NewReader(readabilityContent), pageURL)
 		if err != nil {
 			return book, false, fmt.Errorf("failed to parse article: %v", err)
 		}
 
+		// Only the readable text is hashed, so cosmetic change in markup
+		// doesn't count as change of content.
+		readableText := strings.Join(strings.Fields(article.TextContent), " ")
+		contentHash = ContentHash([]byte(readableText))
+
 		book.Author = article.Byline
 		book.Content = article.TextContent
 		book.HTML = article.Content
@@ -86,11 +141,6 @@ func ProcessBookmark(req ProcessRequest) (model.Bookmark, bool, error) {
 			book.Excerpt = article.Excerpt
 		}
 
-		// Sometimes article doesn't have any title, so make sure it is not empty
-		if book.Title == "" {
-			book.Title = book.URL
-		}
-
 		// Get image URL
 		if article.Image != "" {
 			imageURLs = append(imageURLs, article.Image)
@@ -107,8 +157,39 @@ func ProcessBookmark(req ProcessRequest) (model.Bookmark, bool, error) {
 		book.HasContent = book.Content != ""
 	}
 
-	// Save article image to local disk
+	// Sometimes article doesn't have any title, so make sure it is not empty
+	EnsureTitle(&book)
+
+	// Stop if the content is the same as before, unless it's not archived
+	// or printed as PDF yet
 	strID := strconv.Itoa(book.ID)
+	archivePath := fp.Join(req.DataDir, "archive", strID)
+	singleFilePath := SingleFilePath(req.DataDir, book.ID)
+	pdfPath := PDFPath(req.DataDir, book.ID)
+
+	// Single-file archive is only created from HTML page
+	if book.CreateArchive && book.ArchiveFormat == ArchiveFormatSingleFile &&
+		!strings.Contains(contentType, "text/html") {
+		book.ArchiveFormat = ArchiveFormatWARC
+		book.Warnings = append(book.Warnings,
+			"single-file archive is only created from HTML page, so WARC archive is created instead")
+	}
+
+	if req.SkipUnchanged && contentHash == req.Bookmark.ContentHash {
+		if book.ArchiveFormat == ArchiveFormatSingleFile {
+			archivePath = singleFilePath
+		}
+
+		_, errArchive := os.Stat(archivePath)
+		_, errPDF := os.Stat(pdfPath)
+		if (!book.CreateArchive || errArchive == nil) && (!book.CreatePDF || errPDF == nil) {
+			return req.Bookmark, false, ErrUnchanged
+		}
+	}
+
+	book.ContentHash = contentHash
+
+	// Save article image to local disk
 	imgPath := fp.Join(req.DataDir, "thumb", strID)
 
 	for _, imageURL := range imageURLs {
@@ -119,14 +200,89 @@ func ProcessBookmark(req ProcessRequest) (model.Bookmark, bool, error) {
 		}
 	}
 
-	// If needed, create offline archive as well
-	if book.CreateArchive {
-		archivePath := fp.Join(req.DataDir, "archive", fmt.Sprintf("%d", book.ID))
+	// Make sure archival is allowed for this bookmark
+	if book.CreateArchive && !req.ArchivalPolicy.Allows(book.URL) {
+		book.CreateArchive = false
+		book.Warnings = append(book.Warnings,
+			"archive is skipped, since its domain is not allowed by archival policy")
+	}
+
+	if book.CreatePDF && !req.ArchivalPolicy.Allows(book.URL) {
+		book.CreatePDF = false
+		book.Warnings = append(book.Warnings,
+			"PDF is skipped, since its domain is not allowed by archival policy")
+	}
+
+	// If requested, keep the page printed as PDF. Document that already PDF
+	// is kept as it is. Just like screenshot, failing to print it is not fatal.
+	if book.CreatePDF {
+		switch {
+		case book.ContentType == "application/pdf":
+			err = writePDF(archivalInput.Bytes(), pdfPath)
+		case strings.Contains(contentType, "text/html"):
+			err = printPDF(pageURL, pdfPath, req.RenderPolicy)
+		default:
+			err = fmt.Errorf("can't print %s document", book.ContentType)
+		}
+
+		if err != nil {
+			book.Warnings = append(book.Warnings, fmt.Sprintf("failed to save PDF: %v", err))
+		} else {
+			book.HasPDF = true
+		}
+	}
+
+	// Archival consumes the page, so keep it for the screenshot
+	archivedPage := archivalInput.Bytes()
+
+	// Single-file archive is standalone page, so it's created instead of WARC
+	if book.CreateArchive && book.ArchiveFormat == ArchiveFormatSingleFile {
+		page, err := convertToUTF8(archivalInput.Bytes(), contentType)
+		if err != nil {
+			return book, false, fmt.Errorf("failed to create single-file archive: %v", err)
+		}
+
+		nSkipped, err := createSingleFile(page, pageURL, req.MaxResources, req.Capture, singleFilePath)
+		if err != nil {
+			return book, false, fmt.Errorf("failed to create single-file archive: %v", err)
+		}
+
+		if nSkipped > 0 {
+			book.Warnings = append(book.Warnings, fmt.Sprintf(
+				"archive is partial, %d sub-resources skipped after limit of %d",
+				nSkipped, req.MaxResources))
+		}
+
+		book.HasSingleFile = true
+	}
+
+	// If needed, create offline archive as well. For versioned archive,
+	// the current archive is kept as snapshot instead of being removed.
+	if book.CreateArchive && book.ArchiveFormat != ArchiveFormatSingleFile {
+		if book.VersionedArchive {
+			err = snapshotArchive(archivePath, SnapshotDir(req.DataDir, book.ID), req.MaxSnapshots)
+			if err != nil {
+				return book, false, fmt.Errorf("failed to keep snapshot of archive: %v", err)
+			}
+		}
+
 		os.Remove(archivePath)
 
+		// Limit the sub-resources, so huge page won't stall the archival
+		var archivalReader io.Reader = archivalInput
+		if req.MaxResources > 0 && strings.Contains(contentType, "text/html") {
+			html, nRemoved, err := limitResources(archivalInput.Bytes(), pageURL, req.MaxResources)
+			if err == nil && nRemoved > 0 {
+				archivalReader = bytes.NewReader(html)
+				book.Warnings = append(book.Warnings, fmt.Sprintf(
+					"archive is partial, %d sub-resources skipped after limit of %d",
+					nRemoved, req.MaxResources))
+			}
+		}
+
 		archivalRequest := warc.ArchivalRequest{
-			URL:         book.URL,
-			Reader:      archivalInput,
+			URL:         pageURL,
+			Reader:      archivalReader,
 			ContentType: contentType,
 			UserAgent:   userAgent,
 			LogEnabled:  req.LogArchival,
@@ -140,9 +296,46 @@ func ProcessBookmark(req ProcessRequest) (model.Bookmark, bool, error) {
 		book.HasArchive = true
 	}
 
+	// Screenshot is nice to have, so failing to capture it is not fatal.
+	// It's captured from the archived page, which is standalone when it's
+	// single-file archive, rather than the page at its URL.
+	if book.CreateArchive && req.RenderPolicy.ScreenshotWidth > 0 && strings.Contains(contentType, "text/html") {
+		page, err := convertToUTF8(archivedPage, contentType)
+		if book.HasSingleFile {
+			page, err = ioutil.ReadFile(singleFilePath)
+		}
+
+		if err == nil {
+			err = captureScreenshot(page, pageURL, ScreenshotPath(req.DataDir, book.ID), req.RenderPolicy)
+		}
+
+		if err != nil {
+			book.Warnings = append(book.Warnings, fmt.Sprintf("failed to capture screenshot: %v", err))
+		}
+	}
+
 	return book, false, nil
 }
 
+// ContentHash returns SHA-256 hash of the bookmark's content, which is used
+// to check whether the content has changed, or to find identical articles.
+func ContentHash(content []byte) string {
+	sum := sha256.Sum256(content)
+	return hex.EncodeToString(sum[:])
+}
+
+// convertToUTF8 converts HTML content into UTF-8. The charset is detected
+// from BOM, charset in content type, then `<meta>` tag in the document.
+// Content that already valid as UTF-8 is returned as it is.
+func convertToUTF8(content []byte, contentType string) ([]byte, error) {
+	if utf8.Valid(content) {
+		return content, nil
+	}
+
+	encoding, _, _ := charset.DetermineEncoding(content, contentType)
+	return encoding.NewDecoder().Bytes(content)
+}
+
 func downloadBookImage(url, dstPath string) error {
 	// Fetch data from URL
 	resp, err := httpClient.Get(url)
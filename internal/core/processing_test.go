@@ -0,0 +1,238 @@
+package core
+
+import (
+	"io/ioutil"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"shiori/internal/model"
+	"golang.org/x/text/encoding/japanese"
+)
+
+func Test_convertToUTF8(t *testing.T) {
+	tests := []struct {
+		name        string
+		content     string
+		contentType string
+		wantTitle   string
+	}{
+		{
+			name:        "shift-jis from content type",
+			content:     "<html><head><title>\x93\xfa\x96\x7b\x8c\xea</title></head></html>",
+			contentType: "text/html; charset=Shift_JIS",
+			wantTitle:   "日本語",
+		},
+		{
+			name:        "shift-jis from meta tag",
+			content:     `<html><head><meta charset="shift_jis"><title>` + "\x93\xfa\x96\x7b\x8c\xea</title></head></html>",
+			contentType: "text/html",
+			wantTitle:   "日本語",
+		},
+		{
+			name:        "windows-1252 from meta http-equiv",
+			content:     `<html><head><meta http-equiv="Content-Type" content="text/html; charset=windows-1252"><title>Caf` + "\xe9</title></head></html>",
+			contentType: "text/html",
+			wantTitle:   "Café",
+		},
+		{
+			name:        "utf-8 is kept as it is",
+			content:     "<html><head><title>日本語</title></head></html>",
+			contentType: "text/html; charset=Shift_JIS",
+			wantTitle:   "日本語",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := convertToUTF8([]byte(tt.content), tt.contentType)
+			if err != nil {
+				t.Fatalf("convertToUTF8() error = %v", err)
+			}
+
+			if !strings.Contains(string(got), "<title>"+tt.wantTitle+"</title>") {
+				t.Errorf("convertToUTF8() = %q, want title %q", got, tt.wantTitle)
+			}
+		})
+	}
+}
+
+func TestProcessBookmarkShiftJIS(t *testing.T) {
+	dataDir, err := ioutil.TempDir("", "shiori-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dataDir)
+
+	paragraph := strings.Repeat("日本語で書かれた記事の本文です。", 20)
+	page, err := japanese.ShiftJIS.NewEncoder().String(`<html><head>
+		<meta charset="shift_jis"><title>日本語の記事</title>
+		</head><body><article><h1>日本語の記事</h1><p>` + paragraph + `</p></article></body></html>`)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name        string
+		contentType string
+	}{
+		{"charset in content type", "text/html; charset=Shift_JIS"},
+		{"charset in meta tag", "text/html"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := ProcessRequest{
+				DataDir:     dataDir,
+				Bookmark:    model.Bookmark{ID: 1, URL: "https://example.com/article"},
+				Content:     strings.NewReader(page),
+				ContentType: tt.contentType,
+			}
+
+			book, _, err := ProcessBookmark(req)
+			if err != nil {
+				t.Fatalf("ProcessBookmark() error = %v", err)
+			}
+
+			if book.Title != "日本語の記事" {
+				t.Errorf("ProcessBookmark() title = %q, want %q", book.Title, "日本語の記事")
+			}
+
+			if !strings.Contains(book.Content, "日本語で書かれた記事の本文です。") {
+				t.Errorf("ProcessBookmark() content = %q, want decoded paragraph", book.Content)
+			}
+		})
+	}
+}
+
+func TestProcessBookmarkSkipUnchanged(t *testing.T) {
+	dataDir, err := ioutil.TempDir("", "shiori-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dataDir)
+
+	textHash := ContentHash([]byte("Hello world"))
+
+	tests := []struct {
+		name        string
+		content     string
+		contentType string
+		oldHash     string
+		wantErr     error
+	}{
+		{"same text", "Hello world", "text/plain", textHash, ErrUnchanged},
+		{"changed text", "Hello there", "text/plain", textHash, nil},
+		{"never hashed", "Hello world", "text/plain", "", nil},
+		{
+			name:        "cosmetic change of markup",
+			content:     `<html><body><p class="new">Hello  world</p></body></html>`,
+			contentType: "text/html",
+			oldHash:     hashOfHTML(t, dataDir, "<html><body><p>Hello world</p></body></html>"),
+			wantErr:     ErrUnchanged,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := ProcessRequest{
+				DataDir:       dataDir,
+				Bookmark:      model.Bookmark{ID: 1, URL: "https://example.com", ContentHash: tt.oldHash},
+				Content:       strings.NewReader(tt.content),
+				ContentType:   tt.contentType,
+				SkipUnchanged: true,
+			}
+
+			book, _, err := ProcessBookmark(req)
+			if err != tt.wantErr {
+				t.Fatalf("ProcessBookmark() error = %v, want %v", err, tt.wantErr)
+			}
+
+			if err == nil && (book.ContentHash == "" || book.ContentHash == tt.oldHash) {
+				t.Errorf("ProcessBookmark() content hash = %q, want new hash", book.ContentHash)
+			}
+		})
+	}
+}
+
+func TestProcessBookmarkPDF(t *testing.T) {
+	dataDir, err := ioutil.TempDir("", "shiori-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dataDir)
+
+	// Pretend the page is printed by headless browser
+	oldPrintPage := printPage
+	printPage = func(url string, timeout time.Duration) ([]byte, error) {
+		return []byte("%PDF-1.4 printed " + url), nil
+	}
+	defer func() { printPage = oldPrintPage }()
+
+	tests := []struct {
+		name        string
+		content     string
+		contentType string
+		policy      ArchivalPolicy
+		wantPDF     string
+	}{
+		{"html page", "<html><body><p>Receipt</p></body></html>", "text/html",
+			ArchivalPolicy{}, "%PDF-1.4 printed https://example.com/receipt"},
+		{"pdf document", "%PDF-1.4 original", "application/pdf",
+			ArchivalPolicy{}, "%PDF-1.4 original"},
+		{"plain text", "Receipt", "text/plain", ArchivalPolicy{}, ""},
+		{"blocked domain", "<html><body><p>Receipt</p></body></html>", "text/html",
+			ArchivalPolicy{BlockedDomains: []string{"example.com"}}, ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			pdfPath := PDFPath(dataDir, 1)
+			os.Remove(pdfPath)
+
+			book, _, err := ProcessBookmark(ProcessRequest{
+				DataDir:        dataDir,
+				Bookmark:       model.Bookmark{ID: 1, URL: "https://example.com/receipt", CreatePDF: true},
+				Content:        strings.NewReader(tt.content),
+				ContentType:    tt.contentType,
+				ArchivalPolicy: tt.policy,
+			})
+			if err != nil {
+				t.Fatalf("ProcessBookmark() error = %v", err)
+			}
+
+			// PDF that can't be saved is only warned about
+			if book.HasPDF != (tt.wantPDF != "") || book.HasPDF == (len(book.Warnings) > 0) {
+				t.Fatalf("ProcessBookmark() hasPDF = %v, warnings = %v", book.HasPDF, book.Warnings)
+			}
+
+			got, err := ioutil.ReadFile(pdfPath)
+			if tt.wantPDF == "" {
+				if err == nil {
+					t.Errorf("PDF = %q, want none", got)
+				}
+				return
+			}
+
+			if err != nil || string(got) != tt.wantPDF {
+				t.Errorf("PDF = %q, %v, want %q", got, err, tt.wantPDF)
+			}
+		})
+	}
+}
+
+// hashOfHTML returns the content hash of HTML page after it's processed.
+func hashOfHTML(t *testing.T, dataDir string, html string) string {
+	book, _, err := ProcessBookmark(ProcessRequest{
+		DataDir:     dataDir,
+		Bookmark:    model.Bookmark{ID: 1, URL: "https://example.com"},
+		Content:     strings.NewReader(html),
+		ContentType: "text/html",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return book.ContentHash
+}
@@ -0,0 +1,102 @@
+package core
+
+import (
+	"io/ioutil"
+	"os"
+	fp "path/filepath"
+	"sort"
+	"strconv"
+	"time"
+)
+
+// PruneRequest is the policy for pruning archives.
+type PruneRequest struct {
+	DataDir string
+
+	// MaxAge is the max age of archive before it's removed.
+	// Zero means there is no age limit.
+	MaxAge time.Duration
+
+	// MaxSize is the max total size of archives in bytes. When exceeded,
+	// the oldest archives are removed. Zero means there is no size limit.
+	MaxSize int64
+
+	// BeforeRemove is called before an archive is removed,
+	// e.g. to close the archive if it's still opened.
+	BeforeRemove func(id int)
+}
+
+// PrunedArchive is the archive that removed while pruning.
+type PrunedArchive struct {
+	ID      int
+	Size    int64
+	ModTime time.Time
+	Reason  string
+}
+
+// PruneArchives removes archive files based on the age and size policy.
+// The bookmarks are kept, they just won't have an archive anymore.
+func PruneArchives(req PruneRequest) ([]PrunedArchive, error) {
+	// Fetch list of archives
+	archiveDir := fp.Join(req.DataDir, "archive")
+	fileInfos, err := ioutil.ReadDir(archiveDir)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+
+	archives := []PrunedArchive{}
+	for _, info := range fileInfos {
+		id, err := strconv.Atoi(info.Name())
+		if err != nil || info.IsDir() {
+			continue
+		}
+
+		archives = append(archives, PrunedArchive{
+			ID:      id,
+			Size:    info.Size(),
+			ModTime: info.ModTime(),
+		})
+	}
+
+	// Sort from the oldest, so it will be removed first
+	sort.Slice(archives, func(i, j int) bool {
+		return archives[i].ModTime.Before(archives[j].ModTime)
+	})
+
+	var totalSize int64
+	for _, archive := range archives {
+		totalSize += archive.Size
+	}
+
+	// Remove archives that exceed the policy
+	pruned := []PrunedArchive{}
+	minModTime := time.Now().Add(-req.MaxAge)
+
+	for _, archive := range archives {
+		switch {
+		case req.MaxAge > 0 && archive.ModTime.Before(minModTime):
+			archive.Reason = "older than max age"
+		case req.MaxSize > 0 && totalSize > req.MaxSize:
+			archive.Reason = "total size exceeds max size"
+		default:
+			continue
+		}
+
+		if req.BeforeRemove != nil {
+			req.BeforeRemove(archive.ID)
+		}
+
+		err = os.Remove(fp.Join(archiveDir, strconv.Itoa(archive.ID)))
+		if err != nil && !os.IsNotExist(err) {
+			return pruned, err
+		}
+
+		totalSize -= archive.Size
+		pruned = append(pruned, archive)
+	}
+
+	return pruned, nil
+}
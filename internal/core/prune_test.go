@@ -0,0 +1,69 @@
+package core
+
+import (
+	"io/ioutil"
+	"os"
+	fp "path/filepath"
+	"reflect"
+	"strconv"
+	"testing"
+	"time"
+)
+
+func TestPruneArchives(t *testing.T) {
+	tests := []struct {
+		name       string
+		maxAge     time.Duration
+		maxSize    int64
+		wantPruned []int
+	}{
+		{"no policy", 0, 0, []int{}},
+		{"by age", 36 * time.Hour, 0, []int{1, 2}},
+		{"by size", 0, 250, []int{1, 2}},
+		{"by age and size", 60 * time.Hour, 150, []int{1, 2, 3}},
+		{"everything within limit", 100 * time.Hour, 1000, []int{}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dataDir, err := ioutil.TempDir("", "shiori-test")
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer os.RemoveAll(dataDir)
+
+			// Create 4 archives of 100 bytes, aged 3, 2, 1 and 0 days
+			archiveDir := fp.Join(dataDir, "archive")
+			os.MkdirAll(archiveDir, os.ModePerm)
+
+			for id := 1; id <= 4; id++ {
+				archivePath := fp.Join(archiveDir, strconv.Itoa(id))
+				ioutil.WriteFile(archivePath, make([]byte, 100), os.ModePerm)
+
+				modTime := time.Now().Add(-time.Duration(4-id) * 24 * time.Hour)
+				os.Chtimes(archivePath, modTime, modTime)
+			}
+
+			pruned, err := PruneArchives(PruneRequest{
+				DataDir: dataDir,
+				MaxAge:  tt.maxAge,
+				MaxSize: tt.maxSize,
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			prunedIDs := []int{}
+			for _, archive := range pruned {
+				prunedIDs = append(prunedIDs, archive.ID)
+				if _, err := os.Stat(fp.Join(archiveDir, strconv.Itoa(archive.ID))); !os.IsNotExist(err) {
+					t.Errorf("archive %d is pruned but still exists", archive.ID)
+				}
+			}
+
+			if !reflect.DeepEqual(prunedIDs, tt.wantPruned) {
+				t.Errorf("PruneArchives() pruned %v, want %v", prunedIDs, tt.wantPruned)
+			}
+		})
+	}
+}
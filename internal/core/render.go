@@ -0,0 +1,190 @@
+package core
+
+import (
+	"bytes"
+	"fmt"
+	"html"
+	"io/ioutil"
+	"net"
+	"net/http"
+	nurl "net/url"
+	"os"
+	fp "path/filepath"
+	"strconv"
+	"time"
+
+	"github.com/PuerkitoBio/goquery"
+)
+
+// defaultRenderTimeout is used when RenderPolicy doesn't specify its timeout.
+const defaultRenderTimeout = 30 * time.Second
+
+// RenderPolicy decides which bookmarks are rendered in a headless browser
+// before their content is processed. It's needed by sites that build their
+// page using JavaScript, which otherwise archived as an empty shell.
+type RenderPolicy struct {
+	// All renders every bookmark, regardless of its domain.
+	All bool
+
+	// Domains is list of domains that rendered. A domain matches
+	// its own host and all of its subdomains.
+	Domains []string
+
+	// Timeout is max duration for rendering a page.
+	Timeout time.Duration
+
+	// ScreenshotWidth is the viewport width of full-page screenshot that
+	// captured when a bookmark is archived. Zero disables the screenshot.
+	ScreenshotWidth int
+
+	// WaybackFallback downloads the closest snapshot in Wayback Machine
+	// instead of the page, when the page can't be downloaded or is gone.
+	WaybackFallback bool
+}
+
+// renderPage renders the page in a headless browser and returns its DOM
+// as HTML. It's only available when shiori is built with `headless` tag.
+var renderPage func(url string, timeout time.Duration) (string, error)
+
+// capturePage captures the full height of the page at url in a headless
+// browser with the specified viewport width, and returns it as PNG image.
+// Just like renderPage, it's only available when shiori is built with
+// `headless` tag.
+var capturePage func(url string, width int, timeout time.Duration) ([]byte, error)
+
+// printPage prints the page in a headless browser and returns it as PDF.
+// Just like renderPage, it's only available when shiori is built with
+// `headless` tag.
+var printPage func(url string, timeout time.Duration) ([]byte, error)
+
+// RenderingSupported checks if shiori is built with headless browser support.
+func RenderingSupported() bool {
+	return renderPage != nil
+}
+
+// ScreenshotPath returns the path where the screenshot of the archived
+// page of bookmark with the specified ID is stored.
+func ScreenshotPath(dataDir string, id int) string {
+	return fp.Join(dataDir, "screenshot", strconv.Itoa(id))
+}
+
+// captureScreenshot saves the screenshot of the archived page into dstPath.
+// The page is served to the headless browser by a local server instead of
+// being loaded from its URL, so the screenshot shows what's archived, e.g.
+// the content submitted by client, rather than the live page. Its relative
+// URLs are still resolved against pageURL, while its scripts never run.
+func captureScreenshot(page []byte, pageURL, dstPath string, policy RenderPolicy) error {
+	if capturePage == nil {
+		return fmt.Errorf("shiori is built without headless browser support")
+	}
+
+	page, err := withBaseURL(page, pageURL)
+	if err != nil {
+		return err
+	}
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		return err
+	}
+
+	srv := &http.Server{Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		w.Header().Set("Content-Security-Policy", "sandbox")
+		w.Write(page)
+	})}
+	go srv.Serve(listener)
+	defer srv.Close()
+
+	img, err := capturePage("http://"+listener.Addr().String()+"/", policy.ScreenshotWidth, policy.Timeout)
+	if err != nil {
+		return err
+	}
+
+	err = os.MkdirAll(fp.Dir(dstPath), os.ModePerm)
+	if err != nil {
+		return err
+	}
+
+	return ioutil.WriteFile(dstPath, img, 0644)
+}
+
+// withBaseURL sets the base URL of HTML page, so its relative URLs still
+// work when it's served from elsewhere. Base URL that specified by the page
+// itself is kept, as long as it's valid.
+func withBaseURL(page []byte, pageURL string) ([]byte, error) {
+	doc, err := goquery.NewDocumentFromReader(bytes.NewReader(page))
+	if err != nil {
+		return nil, err
+	}
+
+	baseURL, err := nurl.Parse(pageURL)
+	if err != nil {
+		return nil, err
+	}
+
+	if href, exist := doc.Find("base[href]").First().Attr("href"); exist {
+		if url, err := baseURL.Parse(href); err == nil {
+			baseURL = url
+		}
+	}
+
+	doc.Find("base").Remove()
+	doc.Find("head").PrependHtml(`<base href="` + html.EscapeString(baseURL.String()) + `">`)
+
+	result, err := goquery.OuterHtml(doc.Selection)
+	if err != nil {
+		return nil, err
+	}
+
+	return []byte(result), nil
+}
+
+// PDFPath returns the path where the PDF snapshot of bookmark
+// with the specified ID is stored.
+func PDFPath(dataDir string, id int) string {
+	return fp.Join(dataDir, "pdf", strconv.Itoa(id))
+}
+
+// printPDF saves the page at url printed as PDF into dstPath.
+func printPDF(url, dstPath string, policy RenderPolicy) error {
+	if printPage == nil {
+		return fmt.Errorf("shiori is built without headless browser support")
+	}
+
+	pdf, err := printPage(url, policy.Timeout)
+	if err != nil {
+		return err
+	}
+
+	return writePDF(pdf, dstPath)
+}
+
+// writePDF saves the PDF into dstPath.
+func writePDF(pdf []byte, dstPath string) error {
+	err := os.MkdirAll(fp.Dir(dstPath), os.ModePerm)
+	if err != nil {
+		return err
+	}
+
+	return ioutil.WriteFile(dstPath, pdf, 0644)
+}
+
+// Matches checks if bookmark with the specified URL should be rendered.
+func (p RenderPolicy) Matches(url string) bool {
+	if p.All {
+		return true
+	}
+
+	if len(p.Domains) == 0 {
+		return false
+	}
+
+	parsedURL, err := nurl.Parse(url)
+	if err != nil {
+		return false
+	}
+
+	host := normalizeHostname(parsedURL.Hostname())
+	return matchDomains(host, p.Domains)
+}
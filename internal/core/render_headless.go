@@ -0,0 +1,130 @@
+// +build headless
+
+package core
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"time"
+
+	"github.com/chromedp/cdproto/emulation"
+	"github.com/chromedp/cdproto/page"
+	"github.com/chromedp/chromedp"
+)
+
+func init() {
+	renderPage = renderWithChrome
+	capturePage = captureWithChrome
+	printPage = printWithChrome
+}
+
+// newChromeContext starts headless Chrome, which must be installed on the
+// system. The returned function must be called to stop the browser.
+func newChromeContext(timeout time.Duration) (context.Context, func()) {
+	if timeout <= 0 {
+		timeout = defaultRenderTimeout
+	}
+
+	opts := append(chromedp.DefaultExecAllocatorOptions[:], chromedp.UserAgent(userAgent))
+	allocCtx, cancelAlloc := chromedp.NewExecAllocator(context.Background(), opts...)
+	ctx, cancel := chromedp.NewContext(allocCtx)
+	ctx, cancelTimeout := context.WithTimeout(ctx, timeout)
+
+	return ctx, func() {
+		cancelTimeout()
+		cancel()
+		cancelAlloc()
+	}
+}
+
+// renderWithChrome renders page using headless Chrome, which must be
+// installed on the system.
+func renderWithChrome(url string, timeout time.Duration) (string, error) {
+	ctx, cancel := newChromeContext(timeout)
+	defer cancel()
+
+	// Only HTML document is rendered, since browser shows
+	// other files (e.g. PDF or image) in its own viewer.
+	var contentType, html string
+	err := chromedp.Run(ctx,
+		chromedp.Navigate(url),
+		chromedp.Evaluate(`document.contentType`, &contentType),
+	)
+	if err != nil {
+		return "", err
+	}
+
+	if contentType != "text/html" {
+		return "", fmt.Errorf("can't render %s document", contentType)
+	}
+
+	err = chromedp.Run(ctx, chromedp.OuterHTML("html", &html, chromedp.ByQuery))
+	if err != nil {
+		return "", err
+	}
+
+	return html, nil
+}
+
+// captureWithChrome captures the full height of page using headless Chrome.
+// The viewport is resized to the page's height, so the whole page is drawn
+// the way it's seen by scrolling through it.
+func captureWithChrome(url string, width int, timeout time.Duration) ([]byte, error) {
+	ctx, cancel := newChromeContext(timeout)
+	defer cancel()
+
+	var img []byte
+	err := chromedp.Run(ctx,
+		chromedp.EmulateViewport(int64(width), 800),
+		chromedp.Navigate(url),
+		chromedp.ActionFunc(func(ctx context.Context) error {
+			_, _, contentSize, err := page.GetLayoutMetrics().Do(ctx)
+			if err != nil {
+				return err
+			}
+
+			height := int64(math.Ceil(contentSize.Height))
+			err = emulation.SetDeviceMetricsOverride(int64(width), height, 1, false).Do(ctx)
+			if err != nil {
+				return err
+			}
+
+			img, err = page.CaptureScreenshot().
+				WithFormat(page.CaptureScreenshotFormatPng).
+				WithClip(&page.Viewport{
+					Width:  float64(width),
+					Height: float64(height),
+					Scale:  1,
+				}).Do(ctx)
+			return err
+		}),
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	return img, nil
+}
+
+// printWithChrome prints page as PDF using headless Chrome, the same way
+// it's printed by the browser, with its background graphics.
+func printWithChrome(url string, timeout time.Duration) ([]byte, error) {
+	ctx, cancel := newChromeContext(timeout)
+	defer cancel()
+
+	var pdf []byte
+	err := chromedp.Run(ctx,
+		chromedp.Navigate(url),
+		chromedp.ActionFunc(func(ctx context.Context) error {
+			var err error
+			pdf, _, err = page.PrintToPDF().WithPrintBackground(true).Do(ctx)
+			return err
+		}),
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	return pdf, nil
+}
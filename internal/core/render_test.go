@@ -0,0 +1,125 @@
+package core
+
+import (
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestRenderPolicy_Matches(t *testing.T) {
+	tests := []struct {
+		name   string
+		policy RenderPolicy
+		url    string
+		want   bool
+	}{
+		{"empty policy", RenderPolicy{}, "https://example.com/a", false},
+		{"render all", RenderPolicy{All: true}, "https://example.com/a", true},
+		{"listed domain", RenderPolicy{Domains: []string{"example.com"}}, "https://example.com/a", true},
+		{"listed subdomain", RenderPolicy{Domains: []string{"example.com"}}, "https://app.Example.com/a", true},
+		{"unlisted domain", RenderPolicy{Domains: []string{"example.com"}}, "https://other.org/a", false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.policy.Matches(tt.url); got != tt.want {
+				t.Errorf("RenderPolicy.Matches() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestFetchBookmark(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/html")
+		w.Write([]byte("<html><body>raw</body></html>"))
+	}))
+	defer srv.Close()
+
+	oldRenderPage := renderPage
+	defer func() { renderPage = oldRenderPage }()
+
+	tests := []struct {
+		name       string
+		renderPage func(string, time.Duration) (string, error)
+		policy     RenderPolicy
+		want       string
+	}{
+		{"rendering not supported", nil, RenderPolicy{All: true}, "<html><body>raw</body></html>"},
+		{"rendering succeed", func(string, time.Duration) (string, error) {
+			return "<html><body>rendered</body></html>", nil
+		}, RenderPolicy{All: true}, "<html><body>rendered</body></html>"},
+		{"rendering failed", func(string, time.Duration) (string, error) {
+			return "", errors.New("browser not found")
+		}, RenderPolicy{All: true}, "<html><body>raw</body></html>"},
+		{"domain not rendered", func(string, time.Duration) (string, error) {
+			return "<html><body>rendered</body></html>", nil
+		}, RenderPolicy{Domains: []string{"example.com"}}, "<html><body>raw</body></html>"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			renderPage = tt.renderPage
+
+			content, _, _, _, err := FetchBookmark(srv.URL, tt.policy)
+			if err != nil {
+				t.Fatalf("FetchBookmark() error = %v", err)
+			}
+			defer content.Close()
+
+			got, err := ioutil.ReadAll(content)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if string(got) != tt.want {
+				t.Errorf("FetchBookmark() content = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func Test_captureScreenshot(t *testing.T) {
+	oldCapturePage := capturePage
+	defer func() { capturePage = oldCapturePage }()
+
+	// The archived page is captured, rather than the page at its URL
+	var served string
+	capturePage = func(url string, width int, timeout time.Duration) ([]byte, error) {
+		resp, err := http.Get(url)
+		if err != nil {
+			return nil, err
+		}
+		defer resp.Body.Close()
+
+		body, err := ioutil.ReadAll(resp.Body)
+		served = string(body)
+		return []byte("screenshot"), err
+	}
+
+	dir, err := ioutil.TempDir("", "shiori-screenshot")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	page := []byte(`<html><head><base href="/articles/"></head><body>submitted</body></html>`)
+	dstPath := ScreenshotPath(dir, 1)
+	err = captureScreenshot(page, "https://example.com/page", dstPath, RenderPolicy{ScreenshotWidth: 800})
+	if err != nil {
+		t.Fatalf("captureScreenshot() error = %v", err)
+	}
+
+	if !strings.Contains(served, "submitted") || !strings.Contains(served, `<base href="https://example.com/articles/"/>`) {
+		t.Errorf("served page = %s, want the archived page with its base URL", served)
+	}
+
+	if img, _ := ioutil.ReadFile(dstPath); string(img) != "screenshot" {
+		t.Errorf("saved screenshot = %q", img)
+	}
+}
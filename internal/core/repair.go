@@ -0,0 +1,56 @@
+package core
+
+import (
+	"io/ioutil"
+	"os"
+	fp "path/filepath"
+	"strconv"
+)
+
+// RemoveOrphanFiles removes thumbnail and archive files that don't belong to
+// any bookmark in ids. File which name is not a bookmark ID is left untouched.
+// Returns the number of removed thumbnails and archives.
+func RemoveOrphanFiles(dataDir string, ids map[int]struct{}, beforeRemove func(id int)) (nThumbs int, nArchives int, err error) {
+	nThumbs, err = removeOrphanFiles(fp.Join(dataDir, "thumb"), ids, nil)
+	if err != nil {
+		return
+	}
+
+	nArchives, err = removeOrphanFiles(fp.Join(dataDir, "archive"), ids, beforeRemove)
+	return
+}
+
+func removeOrphanFiles(dir string, ids map[int]struct{}, beforeRemove func(id int)) (int, error) {
+	fileInfos, err := ioutil.ReadDir(dir)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return 0, nil
+		}
+		return 0, err
+	}
+
+	nRemoved := 0
+	for _, info := range fileInfos {
+		id, err := strconv.Atoi(info.Name())
+		if err != nil || info.IsDir() {
+			continue
+		}
+
+		if _, exist := ids[id]; exist {
+			continue
+		}
+
+		if beforeRemove != nil {
+			beforeRemove(id)
+		}
+
+		err = os.Remove(fp.Join(dir, info.Name()))
+		if err != nil && !os.IsNotExist(err) {
+			return nRemoved, err
+		}
+
+		nRemoved++
+	}
+
+	return nRemoved, nil
+}
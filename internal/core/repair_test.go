@@ -0,0 +1,61 @@
+package core
+
+import (
+	"io/ioutil"
+	"os"
+	fp "path/filepath"
+	"testing"
+)
+
+func TestRemoveOrphanFiles(t *testing.T) {
+	dataDir, err := ioutil.TempDir("", "shiori-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dataDir)
+
+	// Bookmark 1 and 2 exist, while 3 has been deleted
+	files := []string{
+		"thumb/1", "thumb/3", "thumb/notes.txt",
+		"archive/2", "archive/3",
+	}
+
+	for _, file := range files {
+		filePath := fp.Join(dataDir, file)
+		os.MkdirAll(fp.Dir(filePath), os.ModePerm)
+		if err := ioutil.WriteFile(filePath, []byte("data"), os.ModePerm); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	ids := map[int]struct{}{1: {}, 2: {}}
+	closedArchives := []int{}
+	beforeRemove := func(id int) { closedArchives = append(closedArchives, id) }
+
+	nThumbs, nArchives, err := RemoveOrphanFiles(dataDir, ids, beforeRemove)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if nThumbs != 1 || nArchives != 1 {
+		t.Errorf("RemoveOrphanFiles() = %d, %d, want 1, 1", nThumbs, nArchives)
+	}
+
+	if len(closedArchives) != 1 || closedArchives[0] != 3 {
+		t.Errorf("beforeRemove called for %v, want [3]", closedArchives)
+	}
+
+	for _, file := range files {
+		_, err := os.Stat(fp.Join(dataDir, file))
+		wantExist := file != "thumb/3" && file != "archive/3"
+		if exist := err == nil; exist != wantExist {
+			t.Errorf("%s exist = %v, want %v", file, exist, wantExist)
+		}
+	}
+
+	// Running it again should not remove anything
+	nThumbs, nArchives, err = RemoveOrphanFiles(dataDir, ids, beforeRemove)
+	if err != nil || nThumbs != 0 || nArchives != 0 {
+		t.Errorf("second RemoveOrphanFiles() = %d, %d, %v, want 0, 0, nil", nThumbs, nArchives, err)
+	}
+}
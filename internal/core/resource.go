@@ -0,0 +1,204 @@
+package core
+
+import (
+	"bytes"
+	nurl "net/url"
+	"strings"
+
+	"github.com/PuerkitoBio/goquery"
+)
+
+// resourceAttrs is map of HTML element and its attributes
+// that refer to sub-resources which fetched by archiver.
+var resourceAttrs = map[string][]string{
+	"img":    {"src", "srcset"},
+	"source": {"src", "srcset"},
+	"script": {"src"},
+	"link":   {"href"},
+	"iframe": {"src"},
+	"frame":  {"src"},
+	"embed":  {"src"},
+	"video":  {"src", "poster"},
+	"audio":  {"src"},
+	"object": {"data"},
+}
+
+// limitResources removes references to sub-resources in HTML page once
+// the limit is reached, so the archiver won't fetch them. Only distinct
+// sub-resources that can be fetched are counted, so the same URL referred
+// several times or URL that is unreachable doesn't use up the limit.
+// Returns the new HTML and the number of removed sub-resources.
+// Sub-resources that referred from inside stylesheet are not limited,
+// since they are not in the page.
+func limitResources(html []byte, pageURL string, limit int) ([]byte, int, error) {
+	doc, err := goquery.NewDocumentFromReader(bytes.NewReader(html))
+	if err != nil {
+		return nil, 0, err
+	}
+
+	baseURL, err := nurl.Parse(pageURL)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	// Checking the sub-resources is costly, so it's only done when the
+	// page has more of them than the limit
+	nodes := resourceNodes(doc)
+	urls := map[string]struct{}{}
+	eachResourceURL(nodes, baseURL, func(url string) bool {
+		urls[url] = struct{}{}
+		return true
+	})
+
+	if len(urls) <= limit {
+		return html, 0, nil
+	}
+
+	budget := newResourceBudget(limit, isReachable)
+	eachResourceURL(nodes, baseURL, budget.allow)
+
+	if budget.nRemoved == 0 {
+		return html, 0, nil
+	}
+
+	newHTML, err := goquery.OuterHtml(doc.Selection)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	return []byte(newHTML), budget.nRemoved, nil
+}
+
+// resourceNodes returns the nodes that refer to sub-resources which
+// fetched by archiver.
+func resourceNodes(doc *goquery.Document) *goquery.Selection {
+	return doc.Find("img, source, script, link, iframe, frame, embed, video, audio, object").
+		FilterFunction(func(_ int, node *goquery.Selection) bool {
+			// Archiver only fetches link to stylesheet and icon
+			if goquery.NodeName(node) != "link" {
+				return true
+			}
+
+			rel, _ := node.Attr("rel")
+			rel = strings.ToLower(rel)
+			return strings.Contains(rel, "stylesheet") || strings.Contains(rel, "icon")
+		})
+}
+
+// eachResourceURL calls keep for absolute URL of each fetchable
+// sub-resource in the nodes. URL that keep returns false for is removed
+// from its attribute, and the attribute is removed once it's empty.
+func eachResourceURL(nodes *goquery.Selection, baseURL *nurl.URL, keep func(url string) bool) {
+	nodes.Each(func(_ int, node *goquery.Selection) {
+		for _, attrName := range resourceAttrs[goquery.NodeName(node)] {
+			attrValue, exist := node.Attr(attrName)
+			if !exist {
+				continue
+			}
+
+			kept := []string{}
+			nRemoved := 0
+			for _, candidate := range resourceCandidates(attrName, attrValue) {
+				url := candidate
+				if parts := strings.Fields(candidate); len(parts) > 0 {
+					url = parts[0]
+				}
+
+				if isFetchable(baseURL, url) {
+					absURL, _ := baseURL.Parse(strings.TrimSpace(url))
+					if !keep(absURL.String()) {
+						nRemoved++
+						continue
+					}
+				}
+
+				kept = append(kept, candidate)
+			}
+
+			switch {
+			case nRemoved == 0:
+			case len(kept) == 0:
+				node.RemoveAttr(attrName)
+			default:
+				node.SetAttr(attrName, strings.Join(kept, ","))
+			}
+		}
+	})
+}
+
+// resourceBudget decides which sub-resources are archived once the page
+// has more sub-resources than the limit. Each URL is decided once, and only
+// the reachable ones use up the limit.
+type resourceBudget struct {
+	limit     int
+	nUsed     int
+	nRemoved  int
+	decisions map[string]bool
+	reachable func(url string) bool
+}
+
+func newResourceBudget(limit int, reachable func(url string) bool) *resourceBudget {
+	return &resourceBudget{
+		limit:     limit,
+		decisions: map[string]bool{},
+		reachable: reachable,
+	}
+}
+
+// allow checks if the sub-resource at URL should be archived. Unreachable
+// sub-resource is kept as it is, since the archiver will skip it anyway.
+func (b *resourceBudget) allow(url string) bool {
+	if allowed, decided := b.decisions[url]; decided {
+		return allowed
+	}
+
+	allowed := true
+	switch {
+	case b.nUsed >= b.limit:
+		allowed = false
+		b.nRemoved++
+	case b.reachable(url):
+		b.nUsed++
+	}
+
+	b.decisions[url] = allowed
+	return allowed
+}
+
+// isReachable checks if the sub-resource at URL can be fetched.
+func isReachable(url string) bool {
+	statusCode, err := CheckLink(url)
+	return err == nil && statusCode < 400
+}
+
+// resourceCandidates returns list of candidates inside the attribute
+// value. Candidate in srcset might contain its descriptor after the URL.
+func resourceCandidates(attrName, attrValue string) []string {
+	if attrName != "srcset" {
+		return []string{attrValue}
+	}
+
+	candidates := []string{}
+	for _, candidate := range strings.Split(attrValue, ",") {
+		if strings.TrimSpace(candidate) != "" {
+			candidates = append(candidates, candidate)
+		}
+	}
+
+	return candidates
+}
+
+// isFetchable checks if the URL will be fetched through network.
+func isFetchable(baseURL *nurl.URL, rawURL string) bool {
+	rawURL = strings.TrimSpace(rawURL)
+	if rawURL == "" || strings.HasPrefix(rawURL, "#") {
+		return false
+	}
+
+	url, err := baseURL.Parse(rawURL)
+	if err != nil {
+		return false
+	}
+
+	return url.Scheme == "http" || url.Scheme == "https"
+}
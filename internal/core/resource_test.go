@@ -0,0 +1,62 @@
+package core
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+)
+
+func Test_resourceBudget(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/missing.png" {
+			http.NotFound(w, r)
+		}
+	}))
+	defer srv.Close()
+
+	// Duplicates and unreachable sub-resources don't use up the limit
+	urls := []string{
+		srv.URL + "/a.png",
+		srv.URL + "/a.png",
+		srv.URL + "/missing.png",
+		srv.URL + "/b.png",
+		srv.URL + "/c.png",
+		srv.URL + "/a.png",
+		srv.URL + "/c.png",
+	}
+
+	budget := newResourceBudget(2, isReachable)
+	got := []bool{}
+	for _, url := range urls {
+		got = append(got, budget.allow(url))
+	}
+
+	want := []bool{true, true, true, true, false, true, false}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("allow() = %v, want %v", got, want)
+	}
+
+	if budget.nRemoved != 1 {
+		t.Errorf("removed %d sub-resources, want 1", budget.nRemoved)
+	}
+}
+
+func Test_resourceCandidates(t *testing.T) {
+	tests := []struct {
+		attrName  string
+		attrValue string
+		want      []string
+	}{
+		{"src", "a.png", []string{"a.png"}},
+		{"srcset", "a.png 1x, b.png 2x", []string{"a.png 1x", " b.png 2x"}},
+		{"srcset", "a.png, ", []string{"a.png"}},
+	}
+
+	for _, tt := range tests {
+		got := resourceCandidates(tt.attrName, tt.attrValue)
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("resourceCandidates(%q, %q) = %q, want %q", tt.attrName, tt.attrValue, got, tt.want)
+		}
+	}
+}
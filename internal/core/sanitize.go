@@ -0,0 +1,307 @@
+package core
+
+import (
+	"bytes"
+	"io"
+	"strings"
+
+	"golang.org/x/net/html"
+)
+
+// removedElements is elements that removed together with their content,
+// since they might run code, load another document or change the page.
+var removedElements = stringSet(
+	"script", "iframe", "frame", "frameset", "object", "embed", "applet",
+	"base", "template", "portal",
+	// SVG elements that might change attributes of other elements, e.g.
+	// set `href` of a link into `javascript:` URL, or embed HTML
+	"animate", "animatecolor", "animatemotion", "animatetransform", "set",
+	"discard", "foreignobject", "handler", "listener",
+)
+
+// allowedElements is elements that kept in sanitized HTML. Element that is
+// neither allowed nor removed is unwrapped, i.e. only its content is kept.
+var allowedElements = stringSet(
+	// Document
+	"html", "head", "body", "title", "meta", "link", "style",
+	// Sections and text
+	"address", "article", "aside", "footer", "header", "hgroup", "main", "nav", "section",
+	"h1", "h2", "h3", "h4", "h5", "h6", "p", "div", "span", "br", "hr", "wbr", "pre", "blockquote",
+	"a", "abbr", "b", "bdi", "bdo", "big", "center", "cite", "code", "data", "del", "dfn", "em",
+	"font", "i", "ins", "kbd", "mark", "q", "rp", "rt", "ruby", "s", "samp", "small", "strike",
+	"strong", "sub", "sup", "time", "tt", "u", "var",
+	"dl", "dt", "dd", "ol", "ul", "li", "details", "summary", "figure", "figcaption",
+	// Tables
+	"table", "caption", "colgroup", "col", "thead", "tbody", "tfoot", "tr", "th", "td",
+	// Media
+	"img", "picture", "source", "video", "audio", "track", "map", "area",
+	// Inert form controls, the forms themselves are unwrapped
+	"fieldset", "legend", "label", "input", "button", "select", "optgroup", "option", "textarea",
+	// SVG graphics
+	"svg", "g", "defs", "symbol", "use", "desc", "path", "circle", "ellipse", "line", "polyline",
+	"polygon", "rect", "text", "tspan", "textpath", "lineargradient", "radialgradient", "stop",
+	"clippath", "mask", "pattern", "marker", "image", "filter", "feblend", "fecolormatrix",
+	"fecomposite", "feflood", "fegaussianblur", "femerge", "femergenode", "feoffset",
+)
+
+// allowedAttributes is attributes that kept in sanitized HTML, besides
+// `data-*` and `aria-*` attributes. URL attributes are kept as long as
+// their URL is safe.
+var allowedAttributes = stringSet(
+	// Global
+	"id", "class", "style", "title", "lang", "dir", "hidden", "role", "tabindex", "translate",
+	"align", "valign", "bgcolor", "color", "width", "height", "border", "nowrap", "clear",
+	// Links and media
+	"alt", "rel", "target", "hreflang", "type", "media", "sizes", "loading", "decoding",
+	"controls", "loop", "muted", "playsinline", "preload", "kind", "srclang", "label", "default",
+	"usemap", "shape", "coords", "download", "charset", "name", "content", "property", "itemprop",
+	"http-equiv", "datetime", "open", "face", "size",
+	// Lists and tables
+	"start", "reversed", "value", "colspan", "rowspan", "headers", "scope", "span", "summary",
+	"cellpadding", "cellspacing",
+	// Form controls
+	"checked", "disabled", "readonly", "selected", "multiple", "placeholder", "maxlength",
+	"rows", "cols", "for",
+	// SVG
+	"xmlns", "xmlns:xlink", "xml:space", "version", "viewbox", "preserveaspectratio", "d",
+	"fill", "fill-opacity", "fill-rule", "stroke", "stroke-width", "stroke-linecap",
+	"stroke-linejoin", "stroke-dasharray", "stroke-opacity", "opacity", "transform", "visibility",
+	"display", "x", "y", "x1", "y1", "x2", "y2", "cx", "cy", "r", "rx", "ry", "fx", "fy", "dx", "dy",
+	"points", "offset", "stop-color", "stop-opacity", "gradientunits", "gradienttransform",
+	"spreadmethod", "clip-path", "clip-rule", "clippathunits", "mask", "maskunits",
+	"patternunits", "patterncontentunits", "patterntransform", "markerwidth", "markerheight",
+	"refx", "refy", "orient", "filter", "in", "in2", "result", "stddeviation", "mode",
+	"font-family", "font-size", "font-weight", "text-anchor", "dominant-baseline",
+	"startoffset", "textlength",
+)
+
+// urlAttributes is attributes that contain URL or list of URLs, which are
+// only kept when every URL in them uses safe scheme.
+var urlAttributes = stringSet(
+	"href", "src", "srcset", "poster", "cite", "background", "xlink:href",
+)
+
+// safeURLSchemes is schemes of URL that can't run code when it's opened.
+// Data URI is only safe for images, media and fonts.
+var safeURLSchemes = stringSet("http", "https", "ftp", "mailto", "tel", "cid")
+
+// safeMetaHTTPEquiv is pragmas of meta element that kept in sanitized HTML,
+// since others might e.g. redirect or set cookies.
+var safeMetaHTTPEquiv = stringSet("content-type", "content-language", "default-style", "x-ua-compatible")
+
+// safeLinkRels is link types that kept in sanitized HTML, since others
+// might load another document or script.
+var safeLinkRels = stringSet(
+	"stylesheet", "icon", "shortcut", "apple-touch-icon", "apple-touch-icon-precomposed",
+	"mask-icon", "canonical", "alternate", "author", "license", "help", "prev", "next",
+)
+
+// stringSet creates set of the strings.
+func stringSet(values ...string) map[string]struct{} {
+	set := make(map[string]struct{}, len(values))
+	for _, value := range values {
+		set[value] = struct{}{}
+	}
+	return set
+}
+
+// SanitizeHTML removes the parts of HTML document that might run code when
+// it's displayed. Only the known safe elements and attributes are kept, while
+// the rest are removed together with their content when they're dangerous,
+// e.g. scripts, embedded frames and SVG animations, or unwrapped otherwise.
+// URLs with scheme that runs code, e.g. `javascript:`, are removed as well.
+func SanitizeHTML(r io.Reader) ([]byte, error) {
+	// Scripts never run in sanitized page, so content of <noscript> is
+	// parsed as HTML, which is sanitized and shown like any other content.
+	doc, err := html.ParseWithOptions(r, html.ParseOptionEnableScripting(false))
+	if err != nil {
+		return nil, err
+	}
+
+	sanitizeNode(doc)
+
+	buffer := bytes.NewBuffer(nil)
+	if err = html.Render(buffer, doc); err != nil {
+		return nil, err
+	}
+
+	return buffer.Bytes(), nil
+}
+
+// sanitizeNode sanitizes the children and attributes of node.
+func sanitizeNode(node *html.Node) {
+	for child := node.FirstChild; child != nil; {
+		next := child.NextSibling
+
+		switch {
+		case child.Type == html.CommentNode:
+			node.RemoveChild(child)
+		case child.Type != html.ElementNode:
+		case isRemovedElement(child):
+			node.RemoveChild(child)
+		case !isAllowedElement(child):
+			// Sanitize the content first, so it's not visited again
+			sanitizeNode(child)
+			for grandChild := child.FirstChild; grandChild != nil; grandChild = child.FirstChild {
+				child.RemoveChild(grandChild)
+				node.InsertBefore(grandChild, child)
+			}
+			node.RemoveChild(child)
+		default:
+			sanitizeNode(child)
+		}
+
+		child = next
+	}
+
+	attrs := node.Attr[:0]
+	for _, attr := range node.Attr {
+		if isAllowedAttribute(attr) {
+			attrs = append(attrs, attr)
+		}
+	}
+	node.Attr = attrs
+}
+
+// isRemovedElement checks if element must be removed with its content.
+func isRemovedElement(node *html.Node) bool {
+	name := strings.ToLower(node.Data)
+	if _, removed := removedElements[name]; removed {
+		return true
+	}
+
+	switch name {
+	case "style":
+		// Content of <style> is written as it is, which might be parsed
+		// as markup within SVG, so only the one in HTML is kept
+		return node.Namespace != "" || isUnsafeCSS(nodeText(node))
+	case "meta":
+		httpEquiv, exist := attrValue(node, "http-equiv")
+		_, safe := safeMetaHTTPEquiv[strings.ToLower(strings.TrimSpace(httpEquiv))]
+		return exist && !safe
+	case "link":
+		rel, _ := attrValue(node, "rel")
+		for _, linkType := range strings.Fields(strings.ToLower(rel)) {
+			if _, safe := safeLinkRels[linkType]; !safe {
+				return true
+			}
+		}
+	}
+
+	return false
+}
+
+// isAllowedElement checks if element is kept in sanitized HTML.
+func isAllowedElement(node *html.Node) bool {
+	_, allowed := allowedElements[strings.ToLower(node.Data)]
+	return allowed
+}
+
+// isAllowedAttribute checks if attribute is kept in sanitized HTML.
+func isAllowedAttribute(attr html.Attribute) bool {
+	key := strings.ToLower(attr.Key)
+	if attr.Namespace != "" {
+		key = strings.ToLower(attr.Namespace) + ":" + key
+	}
+
+	if _, isURL := urlAttributes[key]; isURL {
+		return isSafeURLList(key, attr.Val)
+	}
+
+	if key == "style" {
+		return !isUnsafeCSS(attr.Val)
+	}
+
+	if strings.HasPrefix(key, "data-") || strings.HasPrefix(key, "aria-") {
+		return true
+	}
+
+	_, allowed := allowedAttributes[key]
+	return allowed
+}
+
+// isSafeURLList checks if every URL in the attribute uses safe scheme.
+// Attribute `srcset` is list of URLs, each followed by its descriptor.
+func isSafeURLList(key string, value string) bool {
+	if key != "srcset" {
+		return isSafeURL(value)
+	}
+
+	for _, candidate := range strings.Split(value, ",") {
+		if fields := strings.Fields(candidate); len(fields) > 0 && !isSafeURL(fields[0]) {
+			return false
+		}
+	}
+
+	return true
+}
+
+// isSafeURL checks if the URL uses safe scheme, or none at all, i.e. it's
+// a relative URL.
+func isSafeURL(rawURL string) bool {
+	// Browser ignores whitespace and control characters in the scheme.
+	// The value has been unescaped by the parser, so `&#106;` is `j` here.
+	url := strings.ToLower(removeControlChars(rawURL))
+
+	colon := strings.Index(url, ":")
+	if colon < 0 || strings.ContainsAny(url[:colon], "/?#") {
+		return true
+	}
+
+	scheme := url[:colon]
+	if scheme == "data" {
+		mediaType := url[colon+1:]
+		return strings.HasPrefix(mediaType, "image/") ||
+			strings.HasPrefix(mediaType, "audio/") ||
+			strings.HasPrefix(mediaType, "video/") ||
+			strings.HasPrefix(mediaType, "font/") ||
+			strings.HasPrefix(mediaType, "text/css")
+	}
+
+	_, safe := safeURLSchemes[scheme]
+	return safe
+}
+
+// isUnsafeCSS checks if the stylesheet might run code in old browsers,
+// or load URL with unsafe scheme.
+func isUnsafeCSS(css string) bool {
+	css = strings.ToLower(removeControlChars(css))
+	return strings.Contains(css, "javascript:") ||
+		strings.Contains(css, "vbscript:") ||
+		strings.Contains(css, "expression(") ||
+		strings.Contains(css, "-moz-binding") ||
+		strings.Contains(css, "behavior:")
+}
+
+// removeControlChars removes whitespace and control characters from text.
+func removeControlChars(text string) string {
+	return strings.Map(func(r rune) rune {
+		if r <= ' ' {
+			return -1
+		}
+		return r
+	}, text)
+}
+
+// nodeText returns the text in node and its descendants.
+func nodeText(node *html.Node) string {
+	if node.Type == html.TextNode {
+		return node.Data
+	}
+
+	text := ""
+	for child := node.FirstChild; child != nil; child = child.NextSibling {
+		text += nodeText(child)
+	}
+	return text
+}
+
+// attrValue returns value of the attribute of node.
+func attrValue(node *html.Node, key string) (string, bool) {
+	for _, attr := range node.Attr {
+		if strings.EqualFold(attr.Key, key) {
+			return attr.Val, true
+		}
+	}
+	return "", false
+}
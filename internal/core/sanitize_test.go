@@ -0,0 +1,72 @@
+package core
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestSanitizeHTML(t *testing.T) {
+	tests := []struct {
+		name     string
+		html     string
+		want     []string
+		unwanted []string
+	}{
+		{"script", `<p>Text</p><script>alert(1)</script>`,
+			[]string{"<p>Text</p>"}, []string{"<script", "alert"}},
+		{"event handler", `<img src="a.png" onerror="alert(1)" ONLOAD="alert(2)">`,
+			[]string{`src="a.png"`}, []string{"onerror", "onload", "alert"}},
+		{"javascript URL", `<a href=" java&#10;script:alert(1)">Link</a><a href="https://example.com">Safe</a>`,
+			[]string{`href="https://example.com"`, "Link"}, []string{"alert"}},
+		{"embedded document", `<iframe src="https://example.com"></iframe><object data="x.swf"></object><p>Kept</p>`,
+			[]string{"<p>Kept</p>"}, []string{"iframe", "object"}},
+		{"meta refresh", `<head><meta http-equiv="Refresh" content="0; url=javascript:alert(1)"><meta charset="utf-8"></head>`,
+			[]string{`charset="utf-8"`}, []string{"refresh"}},
+		{"svg link", `<svg><a xlink:href="javascript:alert(1)"><text>SVG</text></a></svg>`,
+			[]string{"SVG"}, []string{"alert"}},
+		{"style is kept", `<p style="color: red">Red</p>`,
+			[]string{`style="color: red"`}, nil},
+		{"svg animation", `<svg><a href="#"><animate attributeName="href" values="javascript:alert(1)"/><set attributeName="href" to="javascript:alert(2)"/><text>SVG</text></a></svg>`,
+			[]string{"SVG"}, []string{"animate", "<set", "alert"}},
+		{"svg foreign object", `<svg><foreignObject><p>HTML in SVG</p></foreignObject></svg>`,
+			[]string{"<svg>"}, []string{"foreignobject", "html in svg"}},
+		{"style in svg", `<svg><style><img src=x onerror=alert(1)></style></svg>`,
+			nil, []string{"onerror", "alert"}},
+		{"unknown element is unwrapped", `<custom-card onclick="alert(1)"><p>Card</p></custom-card>`,
+			[]string{"<p>Card</p>"}, []string{"custom-card", "onclick"}},
+		{"form", `<form action="https://evil.example"><input name="q"><button formaction="javascript:alert(1)">Go</button></form>`,
+			[]string{`<input name="q"/>`, "Go"}, []string{"<form", "action", "alert"}},
+		{"unsafe link", `<head><link rel="import" href="x.html"><link rel="stylesheet" href="style.css"></head>`,
+			[]string{`href="style.css"`}, []string{"import"}},
+		{"unsafe style", `<p style="background: url(javascript:alert(1))">Text</p><style>p { width: expression(alert(1)) }</style>`,
+			[]string{"<p>Text</p>"}, []string{"alert"}},
+		{"data URI", `<img src="data:image/png;base64,AAAA"><a href="data:text/html;base64,PHNjcmlwdD4=">Link</a>`,
+			[]string{`src="data:image/png;base64,AAAA"`, "Link"}, []string{"text/html"}},
+		{"srcset", `<img srcset="a.png 1x, javascript:alert(1) 2x"><img srcset="b.png 1x, https://example.com/c.png 2x">`,
+			[]string{`srcset="b.png 1x, https://example.com/c.png 2x"`}, []string{"alert"}},
+		{"noscript content", `<noscript><img src="lazy.png" onload="alert(1)"></noscript>`,
+			[]string{`<img src="lazy.png"/>`}, []string{"noscript", "alert"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			result, err := SanitizeHTML(strings.NewReader(tt.html))
+			if err != nil {
+				t.Fatalf("SanitizeHTML() error = %v", err)
+			}
+
+			got := string(result)
+			for _, want := range tt.want {
+				if !strings.Contains(got, want) {
+					t.Errorf("SanitizeHTML() = %s, want it to contain %s", got, want)
+				}
+			}
+
+			for _, unwanted := range tt.unwanted {
+				if strings.Contains(strings.ToLower(got), unwanted) {
+					t.Errorf("SanitizeHTML() = %s, want it without %s", got, unwanted)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,333 @@
+package core
+
+import (
+	"bytes"
+	"encoding/base64"
+	"fmt"
+	"html"
+	"io"
+	"io/ioutil"
+	"mime"
+	"net/http"
+	nurl "net/url"
+	"os"
+	fp "path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/PuerkitoBio/goquery"
+)
+
+// Formats of offline archive. WARC keeps the page and its sub-resources
+// as they're downloaded, while single-file is a standalone HTML page with
+// its sub-resources inlined, which can be opened anywhere.
+const (
+	ArchiveFormatWARC       = "warc"
+	ArchiveFormatSingleFile = "singlefile"
+)
+
+// singleFileMaxResource is max size of sub-resource that inlined into
+// single-file archive. Bigger one is kept as link to its original URL.
+const singleFileMaxResource = 10 << 20
+
+// singleFileMaxDepth is how deep stylesheets imported by other stylesheets
+// are inlined.
+const singleFileMaxDepth = 3
+
+var (
+	cssURLPattern    = regexp.MustCompile(`(?i)url\(\s*(?:"([^"]*)"|'([^']*)'|([^)"'\s]*))\s*\)`)
+	cssImportPattern = regexp.MustCompile(`(?i)@import\s+(?:"([^"]*)"|'([^']*)')`)
+)
+
+// CheckArchiveFormat checks if the archive format is supported.
+// Empty format is WARC, which is the default.
+func CheckArchiveFormat(format string) error {
+	switch format {
+	case "", ArchiveFormatWARC, ArchiveFormatSingleFile:
+		return nil
+	default:
+		return fmt.Errorf("archive format %q is not supported", format)
+	}
+}
+
+// SingleFilePath returns the path where the single-file archive of
+// bookmark with the specified ID is stored.
+func SingleFilePath(dataDir string, id int) string {
+	return fp.Join(dataDir, "singlefile", strconv.Itoa(id))
+}
+
+// singleFile inlines the sub-resources of a page into the page itself.
+// When the page is captured by other tool, its captured sub-resources are
+// used instead of downloading them.
+type singleFile struct {
+	limit     int
+	nFetched  int
+	nSkipped  int
+	resources map[string]string
+	capture   *Capture
+}
+
+// createSingleFile creates single-file archive from HTML page, i.e. its
+// stylesheets, images and fonts are inlined as data URI, and other links
+// are made absolute. Scripts are removed, so the archive looks the same as
+// when it's archived. Once limit of sub-resources is reached, the rest is
+// kept as link to its original URL. The archive is saved into dstPath,
+// and the number of sub-resources that skipped after the limit is returned.
+func createSingleFile(page []byte, pageURL string, limit int, capture *Capture, dstPath string) (int, error) {
+	doc, err := goquery.NewDocumentFromReader(bytes.NewReader(page))
+	if err != nil {
+		return 0, err
+	}
+
+	baseURL, err := nurl.Parse(pageURL)
+	if err != nil {
+		return 0, err
+	}
+
+	// Page might specify its own base URL, which won't work once it's archived
+	if href, exist := doc.Find("base[href]").First().Attr("href"); exist {
+		if url, err := baseURL.Parse(href); err == nil {
+			baseURL = url
+		}
+	}
+	doc.Find("base, script").Remove()
+
+	// Content is converted into UTF-8 already, and the page's own security
+	// policy might block the inlined sub-resources
+	doc.Find("meta[charset]").Remove()
+	doc.Find("meta[http-equiv]").Each(func(_ int, node *goquery.Selection) {
+		httpEquiv, _ := node.Attr("http-equiv")
+		if strings.EqualFold(httpEquiv, "content-type") ||
+			strings.EqualFold(httpEquiv, "content-security-policy") {
+			node.Remove()
+		}
+	})
+	doc.Find("head").PrependHtml(`<meta charset="utf-8">`)
+
+	s := &singleFile{limit: limit, resources: map[string]string{}, capture: capture}
+
+	// Replace stylesheets with their content, while icons are inlined
+	doc.Find("link[href]").Each(func(_ int, node *goquery.Selection) {
+		rel, _ := node.Attr("rel")
+		rel = strings.ToLower(rel)
+		href, _ := node.Attr("href")
+
+		switch {
+		case strings.Contains(rel, "stylesheet"):
+			url, css, _, err := s.download(baseURL, href)
+			if err != nil {
+				break
+			}
+
+			style := "<style"
+			if media, exist := node.Attr("media"); exist {
+				style += ` media="` + html.EscapeString(media) + `"`
+			}
+			node.ReplaceWithHtml(style + ">" + s.inlineCSS(string(css), url, 1) + "</style>")
+		case strings.Contains(rel, "icon"):
+			node.SetAttr("href", s.inlineURL(baseURL, href, 0))
+		}
+	})
+
+	doc.Find("style").Each(func(_ int, node *goquery.Selection) {
+		node.SetText(s.inlineCSS(node.Text(), baseURL, 1))
+	})
+
+	doc.Find("[style]").Each(func(_ int, node *goquery.Selection) {
+		style, _ := node.Attr("style")
+		node.SetAttr("style", s.inlineCSS(style, baseURL, 1))
+	})
+
+	// Inline images. Media and frames are usually too big or can't be
+	// inlined at all, so they're kept as link like the other URLs.
+	doc.Find("img, picture source, video[poster], input[type=image]").Each(func(_ int, node *goquery.Selection) {
+		for _, attrName := range []string{"src", "srcset", "poster"} {
+			attrValue, exist := node.Attr(attrName)
+			if !exist {
+				continue
+			}
+
+			if attrName != "srcset" {
+				node.SetAttr(attrName, s.inlineURL(baseURL, attrValue, 0))
+				continue
+			}
+
+			srcSet := []string{}
+			for _, candidate := range strings.Split(attrValue, ",") {
+				parts := strings.Fields(candidate)
+				if len(parts) == 0 {
+					continue
+				}
+
+				parts[0] = s.inlineURL(baseURL, parts[0], 0)
+				srcSet = append(srcSet, strings.Join(parts, " "))
+			}
+			node.SetAttr(attrName, strings.Join(srcSet, ", "))
+		}
+	})
+
+	// Make the remaining links absolute, so they still work from anywhere
+	for _, attrName := range []string{"href", "src", "data", "action", "poster"} {
+		doc.Find("[" + attrName + "]").Each(func(_ int, node *goquery.Selection) {
+			attrValue, _ := node.Attr(attrName)
+			if !isFetchable(baseURL, attrValue) {
+				return
+			}
+
+			if url, err := baseURL.Parse(strings.TrimSpace(attrValue)); err == nil {
+				node.SetAttr(attrName, url.String())
+			}
+		})
+	}
+
+	rendered, err := goquery.OuterHtml(doc.Selection)
+	if err != nil {
+		return 0, err
+	}
+
+	// The archive might be opened anywhere, even outside of Shiori,
+	// so anything that might run code is removed from it
+	archive, err := SanitizeHTML(strings.NewReader(rendered))
+	if err != nil {
+		return 0, err
+	}
+
+	err = os.MkdirAll(fp.Dir(dstPath), os.ModePerm)
+	if err != nil {
+		return 0, err
+	}
+
+	err = ioutil.WriteFile(dstPath, archive, 0644)
+	if err != nil {
+		return 0, err
+	}
+
+	return s.nSkipped, nil
+}
+
+// inlineCSS inlines the sub-resources that referred by url() in the
+// stylesheet, including the imported stylesheets until max depth.
+func (s *singleFile) inlineCSS(css string, baseURL *nurl.URL, depth int) string {
+	css = cssImportPattern.ReplaceAllString(css, `@import url("$1$2")`)
+
+	return cssURLPattern.ReplaceAllStringFunc(css, func(match string) string {
+		groups := cssURLPattern.FindStringSubmatch(match)
+		rawURL := groups[1] + groups[2] + groups[3]
+		if !s.fetchable(baseURL, rawURL) {
+			return match
+		}
+
+		return `url("` + s.inlineURL(baseURL, rawURL, depth) + `")`
+	})
+}
+
+// inlineURL returns the sub-resource at rawURL as data URI. If it can't be
+// downloaded, its absolute URL is returned instead. Stylesheet is inlined
+// as well, until the max depth.
+func (s *singleFile) inlineURL(baseURL *nurl.URL, rawURL string, depth int) string {
+	if !s.fetchable(baseURL, rawURL) {
+		return rawURL
+	}
+
+	url, err := baseURL.Parse(strings.TrimSpace(rawURL))
+	if err != nil {
+		return rawURL
+	}
+
+	if dataURI, exist := s.resources[url.String()]; exist {
+		return dataURI
+	}
+
+	_, content, mediaType, err := s.download(url, "")
+	if err != nil {
+		return url.String()
+	}
+
+	if mediaType == "text/css" && depth > 0 && depth < singleFileMaxDepth {
+		content = []byte(s.inlineCSS(string(content), url, depth+1))
+	}
+
+	dataURI := "data:" + mediaType + ";base64," + base64.StdEncoding.EncodeToString(content)
+	s.resources[url.String()] = dataURI
+	return dataURI
+}
+
+// fetchable checks if the sub-resource at rawURL can be inlined, which
+// includes the sub-resource that captured by its Content-ID.
+func (s *singleFile) fetchable(baseURL *nurl.URL, rawURL string) bool {
+	if s.capture != nil && strings.HasPrefix(strings.ToLower(strings.TrimSpace(rawURL)), "cid:") {
+		return true
+	}
+
+	return isFetchable(baseURL, rawURL)
+}
+
+// download downloads the sub-resource at rawURL that resolved against
+// baseURL, unless the limit of sub-resources is reached. Returns the URL
+// of sub-resource, its content and its media type.
+func (s *singleFile) download(baseURL *nurl.URL, rawURL string) (*nurl.URL, []byte, string, error) {
+	url, err := baseURL.Parse(strings.TrimSpace(rawURL))
+	if err != nil {
+		return nil, nil, "", err
+	}
+
+	// Page that captured by other tool is archived as it's captured
+	if s.capture != nil {
+		resource, exist := s.capture.find(url)
+		if !exist {
+			return nil, nil, "", fmt.Errorf("%s is not captured", url)
+		}
+
+		mediaType, _, err := mime.ParseMediaType(resource.ContentType)
+		if err != nil {
+			mediaType = http.DetectContentType(resource.Content)
+		}
+
+		return url, resource.Content, mediaType, nil
+	}
+
+	if s.limit > 0 && s.nFetched >= s.limit {
+		s.nSkipped++
+		return nil, nil, "", fmt.Errorf("limit of sub-resources is reached")
+	}
+	s.nFetched++
+
+	req, err := http.NewRequest("GET", url.String(), nil)
+	if err != nil {
+		return nil, nil, "", err
+	}
+
+	req.Header.Set("User-Agent", userAgent)
+	resp, err := httpClient.Do(req)
+	if err != nil {
+		return nil, nil, "", err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, nil, "", fmt.Errorf("%s responded with status %d", url, resp.StatusCode)
+	}
+
+	content, err := ioutil.ReadAll(io.LimitReader(resp.Body, singleFileMaxResource+1))
+	if err != nil {
+		return nil, nil, "", err
+	}
+
+	if len(content) > singleFileMaxResource {
+		return nil, nil, "", fmt.Errorf("%s is too big to be inlined", url)
+	}
+
+	// Server might not tell the type, so guess it from the content
+	contentType := resp.Header.Get("Content-Type")
+	if contentType == "" {
+		contentType = http.DetectContentType(content)
+	}
+
+	mediaType, _, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		mediaType = "application/octet-stream"
+	}
+
+	return url, content, mediaType, nil
+}
@@ -0,0 +1,123 @@
+package core
+
+import (
+	"encoding/base64"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	nurl "net/url"
+	"os"
+	fp "path/filepath"
+	"strings"
+	"testing"
+)
+
+func Test_inlineCSS(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/img/dot.png":
+			w.Header().Set("Content-Type", "image/png")
+			fmt.Fprint(w, "png")
+		case "/css/imported.css":
+			w.Header().Set("Content-Type", "text/css")
+			fmt.Fprint(w, `body { background: url(../img/dot.png) }`)
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer srv.Close()
+
+	pngURI := "data:image/png;base64," + base64.StdEncoding.EncodeToString([]byte("png"))
+	importedURI := "data:text/css;base64," + base64.StdEncoding.EncodeToString(
+		[]byte(`body { background: url("`+pngURI+`") }`))
+
+	tests := []struct {
+		name  string
+		css   string
+		limit int
+		want  string
+	}{
+		{"unquoted url", `p { background: url(img/dot.png) }`, 0,
+			`p { background: url("` + pngURI + `") }`},
+		{"quoted url", `p { background: url( 'img/dot.png' ) }`, 0,
+			`p { background: url("` + pngURI + `") }`},
+		{"data uri", `p { background: url(data:image/png;base64,AAAA) }`, 0,
+			`p { background: url(data:image/png;base64,AAAA) }`},
+		{"import", `@import "css/imported.css";`, 0,
+			`@import url("` + importedURI + `");`},
+		{"missing", `p { background: url(img/missing.png) }`, 0,
+			`p { background: url("` + srv.URL + `/img/missing.png") }`},
+		{"after limit", `p { background: url(img/dot.png) } a { background: url(/img/dot.png?v=2) }`, 1,
+			`p { background: url("` + pngURI + `") } a { background: url("` + srv.URL + `/img/dot.png?v=2") }`},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			baseURL, _ := nurl.Parse(srv.URL + "/page")
+			s := &singleFile{limit: tt.limit, resources: map[string]string{}}
+
+			if got := s.inlineCSS(tt.css, baseURL, 1); got != tt.want {
+				t.Errorf("inlineCSS() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func Test_createSingleFile(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "image/png")
+		fmt.Fprint(w, "png")
+	}))
+	defer srv.Close()
+
+	tmpDir, err := ioutil.TempDir("", "shiori-singlefile")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	page := `<html><head><title>Page</title><script>alert(1)</script></head>
+<body onload="alert(2)"><img src="dot.png" onerror="alert(3)">
+<a href="javascript:alert(4)">Link</a><iframe src="/frame"></iframe></body></html>`
+
+	dstPath := fp.Join(tmpDir, "1")
+	if _, err = createSingleFile([]byte(page), srv.URL+"/page", 0, nil, dstPath); err != nil {
+		t.Fatalf("createSingleFile() error = %v", err)
+	}
+
+	archive, err := ioutil.ReadFile(dstPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Sub-resources are inlined, while anything that runs code is removed
+	pngURI := "data:image/png;base64," + base64.StdEncoding.EncodeToString([]byte("png"))
+	if !strings.Contains(string(archive), `<img src="`+pngURI+`"/>`) {
+		t.Errorf("archive = %s, want the inlined image", archive)
+	}
+
+	for _, unwanted := range []string{"alert", "iframe", "onload", "onerror"} {
+		if strings.Contains(string(archive), unwanted) {
+			t.Errorf("archive = %s, want no %s", archive, unwanted)
+		}
+	}
+}
+
+func TestCheckArchiveFormat(t *testing.T) {
+	tests := []struct {
+		format  string
+		wantErr bool
+	}{
+		{"", false},
+		{ArchiveFormatWARC, false},
+		{ArchiveFormatSingleFile, false},
+		{"mhtml", true},
+	}
+
+	for _, tt := range tests {
+		if err := CheckArchiveFormat(tt.format); (err != nil) != tt.wantErr {
+			t.Errorf("CheckArchiveFormat(%q) error = %v, wantErr %v", tt.format, err, tt.wantErr)
+		}
+	}
+}
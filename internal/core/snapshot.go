@@ -0,0 +1,124 @@
+package core
+
+import (
+	"io/ioutil"
+	"os"
+	fp "path/filepath"
+	"sort"
+	"strconv"
+	"time"
+)
+
+// snapshotTimeFormat is the format of snapshot's name, which is
+// the UTC time when the snapshot's archive was created.
+const snapshotTimeFormat = "20060102150405"
+
+// ArchiveSnapshot is a past version of bookmark's archive, which is kept
+// when the archive is replaced for bookmark with versioned archive.
+type ArchiveSnapshot struct {
+	Name    string    `json:"name"`
+	Created time.Time `json:"created"`
+	Size    int64     `json:"size"`
+}
+
+// SnapshotDir returns the directory where the snapshots of
+// the archive of bookmark with the specified ID are stored.
+func SnapshotDir(dataDir string, id int) string {
+	return fp.Join(dataDir, "snapshot", strconv.Itoa(id))
+}
+
+// ValidSnapshotName checks if name is a valid name of snapshot,
+// so it's safe to be used as part of file path.
+func ValidSnapshotName(name string) bool {
+	_, err := time.Parse(snapshotTimeFormat, name)
+	return err == nil
+}
+
+// ListSnapshots lists the snapshots of bookmark's archive, newest first.
+// Bookmark that never had any snapshot has an empty list.
+func ListSnapshots(dataDir string, id int) ([]ArchiveSnapshot, error) {
+	infos, err := ioutil.ReadDir(SnapshotDir(dataDir, id))
+	if os.IsNotExist(err) {
+		return []ArchiveSnapshot{}, nil
+	} else if err != nil {
+		return nil, err
+	}
+
+	snapshots := []ArchiveSnapshot{}
+	for _, info := range infos {
+		created, err := time.Parse(snapshotTimeFormat, info.Name())
+		if err != nil || info.IsDir() {
+			continue
+		}
+
+		snapshots = append(snapshots, ArchiveSnapshot{
+			Name:    info.Name(),
+			Created: created,
+			Size:    info.Size(),
+		})
+	}
+
+	sort.Slice(snapshots, func(i, j int) bool {
+		return snapshots[i].Name > snapshots[j].Name
+	})
+
+	return snapshots, nil
+}
+
+// snapshotArchive moves the archive at archivePath into snapshotDir, so it's
+// kept once the archive is replaced. Then the oldest snapshots are removed,
+// so at most maxSnapshots are kept. Zero maxSnapshots means no limit.
+func snapshotArchive(archivePath, snapshotDir string, maxSnapshots int) error {
+	info, err := os.Stat(archivePath)
+	if os.IsNotExist(err) {
+		return nil
+	} else if err != nil {
+		return err
+	}
+
+	err = os.MkdirAll(snapshotDir, os.ModePerm)
+	if err != nil {
+		return err
+	}
+
+	name := info.ModTime().UTC().Format(snapshotTimeFormat)
+	err = os.Rename(archivePath, fp.Join(snapshotDir, name))
+	if err != nil {
+		return err
+	}
+
+	return pruneSnapshots(snapshotDir, maxSnapshots)
+}
+
+// pruneSnapshots removes the oldest snapshots in snapshotDir,
+// so at most maxSnapshots are kept. Zero maxSnapshots means no limit.
+func pruneSnapshots(snapshotDir string, maxSnapshots int) error {
+	if maxSnapshots <= 0 {
+		return nil
+	}
+
+	names := []string{}
+	infos, err := ioutil.ReadDir(snapshotDir)
+	if err != nil {
+		return err
+	}
+
+	for _, info := range infos {
+		if !info.IsDir() && ValidSnapshotName(info.Name()) {
+			names = append(names, info.Name())
+		}
+	}
+
+	// The names are time, so they're sorted from the oldest
+	sort.Strings(names)
+	for len(names) > maxSnapshots {
+		err = os.Remove(fp.Join(snapshotDir, names[0]))
+		if err != nil {
+			return err
+		}
+
+		names = names[1:]
+	}
+
+	return nil
+}
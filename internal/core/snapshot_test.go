@@ -0,0 +1,105 @@
+package core
+
+import (
+	"io/ioutil"
+	"os"
+	fp "path/filepath"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestValidSnapshotName(t *testing.T) {
+	tests := []struct {
+		name string
+		want bool
+	}{
+		{"20200102030405", true},
+		{"20201302030405", false},
+		{"2020010203040", false},
+		{"../../archive/1", false},
+		{"", false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := ValidSnapshotName(tt.name); got != tt.want {
+				t.Errorf("ValidSnapshotName(%q) = %v, want %v", tt.name, got, tt.want)
+			}
+		})
+	}
+}
+
+func Test_snapshotArchive(t *testing.T) {
+	tmpDir, err := ioutil.TempDir("", "shiori-snapshot")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	archivePath := fp.Join(tmpDir, "archive", "1")
+	snapshotDir := SnapshotDir(tmpDir, 1)
+	os.MkdirAll(fp.Dir(archivePath), os.ModePerm)
+
+	// Replace the archive four times, while only three snapshots are kept
+	start := time.Date(2020, 1, 2, 3, 4, 5, 0, time.UTC)
+	for i := 0; i < 4; i++ {
+		if err := ioutil.WriteFile(archivePath, []byte{byte(i)}, os.ModePerm); err != nil {
+			t.Fatal(err)
+		}
+
+		modTime := start.Add(time.Duration(i) * time.Hour)
+		if err := os.Chtimes(archivePath, modTime, modTime); err != nil {
+			t.Fatal(err)
+		}
+
+		if err := snapshotArchive(archivePath, snapshotDir, 3); err != nil {
+			t.Fatalf("snapshotArchive() error = %v", err)
+		}
+
+		if _, err := os.Stat(archivePath); !os.IsNotExist(err) {
+			t.Fatalf("archive is not moved into snapshot")
+		}
+	}
+
+	// Missing archive is not an error
+	if err := snapshotArchive(archivePath, snapshotDir, 3); err != nil {
+		t.Fatalf("snapshotArchive() without archive error = %v", err)
+	}
+
+	snapshots, err := ListSnapshots(tmpDir, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	names := []string{}
+	for _, snapshot := range snapshots {
+		names = append(names, snapshot.Name)
+	}
+
+	want := []string{"20200102060405", "20200102050405", "20200102040405"}
+	if !reflect.DeepEqual(names, want) {
+		t.Errorf("ListSnapshots() = %v, want %v", names, want)
+	}
+
+	if len(snapshots) > 0 && !snapshots[0].Created.Equal(start.Add(3*time.Hour)) {
+		t.Errorf("ListSnapshots() created = %v, want %v", snapshots[0].Created, start.Add(3*time.Hour))
+	}
+}
+
+func TestListSnapshotsWithoutSnapshot(t *testing.T) {
+	tmpDir, err := ioutil.TempDir("", "shiori-snapshot")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	snapshots, err := ListSnapshots(tmpDir, 1)
+	if err != nil {
+		t.Fatalf("ListSnapshots() error = %v", err)
+	}
+
+	if snapshots == nil || len(snapshots) != 0 {
+		t.Errorf("ListSnapshots() = %#v, want empty list", snapshots)
+	}
+}
@@ -0,0 +1,36 @@
+package core
+
+import (
+	"strings"
+	"unicode/utf8"
+
+	"shiori/internal/model"
+)
+
+// CleanTitle normalizes the whitespace in title and removes its invalid
+// UTF-8 runes. If nothing is left, fallback is returned instead.
+func CleanTitle(title, fallback string) string {
+	// Remove invalid runes to get the valid UTF-8 title
+	if !utf8.ValidString(title) {
+		title = strings.Map(func(r rune) rune {
+			if r == utf8.RuneError {
+				return -1
+			}
+			return r
+		}, title)
+	}
+
+	title = strings.Join(strings.Fields(title), " ")
+	if title == "" {
+		return fallback
+	}
+
+	return title
+}
+
+// EnsureTitle cleans up the title of bookmark before it's saved. Bookmark
+// without any title, e.g. a page that failed to be downloaded or a file
+// that doesn't have one, uses its URL as title.
+func EnsureTitle(book *model.Bookmark) {
+	book.Title = CleanTitle(book.Title, book.URL)
+}
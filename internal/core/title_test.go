@@ -0,0 +1,40 @@
+package core
+
+import (
+	"testing"
+
+	"shiori/internal/model"
+)
+
+func TestCleanTitle(t *testing.T) {
+	tests := []struct {
+		name     string
+		title    string
+		fallback string
+		want     string
+	}{
+		{"valid title", "Go Programming", "https://golang.org", "Go Programming"},
+		{"extra whitespace", "  Go \n\t Programming  ", "", "Go Programming"},
+		{"invalid UTF-8", "Go \xff\xfeProgramming", "", "Go Programming"},
+		{"empty title", "", "https://golang.org", "https://golang.org"},
+		{"only whitespace", " \n\t ", "https://golang.org", "https://golang.org"},
+		{"only invalid runes", "\xff\xfe", "https://golang.org", "https://golang.org"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := CleanTitle(tt.title, tt.fallback); got != tt.want {
+				t.Errorf("CleanTitle() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestEnsureTitle(t *testing.T) {
+	book := model.Bookmark{URL: "https://example.com/file.pdf", Title: "  "}
+	EnsureTitle(&book)
+
+	if book.Title != book.URL {
+		t.Errorf("EnsureTitle() title = %q, want %q", book.Title, book.URL)
+	}
+}
@@ -4,19 +4,55 @@ import (
 	"fmt"
 	nurl "net/url"
 	"strings"
+
+	"golang.org/x/net/idna"
 )
 
-// RemoveUTMParams removes the UTM parameters from URL.
-func RemoveUTMParams(url string) (string, error) {
+// KeptQueryParams is list of query parameters that never removed from URL,
+// keyed by domain. A domain matches its own host and all of its subdomains.
+type KeptQueryParams map[string][]string
+
+// params returns the query parameters that must be kept for the host.
+func (k KeptQueryParams) params(host string) map[string]struct{} {
+	params := make(map[string]struct{})
+	for domain, names := range k {
+		if !matchDomains(host, []string{domain}) {
+			continue
+		}
+
+		for _, name := range names {
+			params[name] = struct{}{}
+		}
+	}
+
+	return params
+}
+
+// RemoveUTMParams removes the UTM parameters from URL, except the
+// parameters that kept for its domain.
+//
+// The host is normalized as well, so the same site is always saved the same
+// way: it's lower cased and internationalized domain name is stored as its
+// punycode. IPv6 literal keeps its brackets and zone. The user info is kept
+// as it is, since it might be needed to fetch self-hosted page.
+func RemoveUTMParams(url string, kept KeptQueryParams) (string, error) {
 	// Parse string URL
 	tmp, err := nurl.Parse(url)
 	if err != nil || tmp.Scheme == "" || tmp.Hostname() == "" {
 		return url, fmt.Errorf("URL is not valid")
 	}
 
+	hostname := normalizeHostname(tmp.Hostname())
+	tmp.Host = joinHostPort(hostname, tmp.Port())
+
 	// Remove UTM queries
+	keptParams := kept.params(hostname)
 	queries := tmp.Query()
 	for key := range queries {
+		if _, isKept := keptParams[key]; isKept {
+			continue
+		}
+
 		if strings.HasPrefix(key, "utm_") {
 			queries.Del(key)
 		}
@@ -26,3 +62,98 @@ func RemoveUTMParams(url string) (string, error) {
 	tmp.RawQuery = queries.Encode()
 	return tmp.String(), nil
 }
+
+// defaultPorts is the port that implied by scheme of URL.
+var defaultPorts = map[string]string{"http": "80", "https": "443", "ftp": "21"}
+
+// CanonicalURL returns the form of URL that used to tell whether two URLs
+// point to the same page. Its scheme and host are lower cased, while its
+// fragment, default port and trailing slash of its path are removed.
+func CanonicalURL(url string) (string, error) {
+	tmp, err := nurl.Parse(url)
+	if err != nil || tmp.Scheme == "" || tmp.Hostname() == "" {
+		return url, fmt.Errorf("URL is not valid")
+	}
+
+	tmp.Scheme = strings.ToLower(tmp.Scheme)
+	port := tmp.Port()
+	if port == defaultPorts[tmp.Scheme] {
+		port = ""
+	}
+
+	tmp.Host = joinHostPort(normalizeHostname(tmp.Hostname()), port)
+	tmp.Path = strings.TrimRight(tmp.Path, "/")
+	tmp.RawPath = strings.TrimRight(tmp.RawPath, "/")
+	tmp.Fragment = ""
+	return tmp.String(), nil
+}
+
+// EquivalentURLs returns the forms of URL that share its canonical URL, i.e.
+// with or without trailing slash and default port, so the saved URL can be
+// looked up whichever form it's saved in. The URL itself comes first.
+func EquivalentURLs(url string) []string {
+	urls := []string{url}
+	canonical, err := CanonicalURL(url)
+	if err != nil {
+		return urls
+	}
+
+	tmp, _ := nurl.Parse(canonical)
+	hostname := tmp.Hostname()
+	ports := []string{""}
+	if port, ok := defaultPorts[tmp.Scheme]; ok {
+		ports = append(ports, port)
+	}
+
+	path, rawPath := tmp.Path, tmp.RawPath
+	for _, slash := range []string{"", "/"} {
+		for _, port := range ports {
+			tmp.Host = joinHostPort(hostname, port)
+			tmp.Path = path + slash
+			if rawPath != "" {
+				tmp.RawPath = rawPath + slash
+			}
+
+			if variant := tmp.String(); variant != url {
+				urls = append(urls, variant)
+			}
+		}
+	}
+
+	return urls
+}
+
+// normalizeHostname lower cases the hostname and converts internationalized
+// domain name into punycode. Hostname that can't be converted is only lower
+// cased. Zone of IPv6 literal is case sensitive, so it's left as it is.
+func normalizeHostname(hostname string) string {
+	if strings.Contains(hostname, ":") {
+		zone := ""
+		if idx := strings.Index(hostname, "%"); idx >= 0 {
+			hostname, zone = hostname[:idx], hostname[idx:]
+		}
+
+		return strings.ToLower(hostname) + zone
+	}
+
+	hostname = strings.ToLower(hostname)
+	if ascii, err := idna.Punycode.ToASCII(hostname); err == nil {
+		return ascii
+	}
+
+	return hostname
+}
+
+// joinHostPort combines hostname and port into URL host.
+// Unlike net.JoinHostPort, the port is omitted when it's empty.
+func joinHostPort(hostname, port string) string {
+	if strings.Contains(hostname, ":") {
+		hostname = "[" + hostname + "]"
+	}
+
+	if port == "" {
+		return hostname
+	}
+
+	return hostname + ":" + port
+}
@@ -0,0 +1,143 @@
+package core
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"strings"
+	"time"
+)
+
+// WallabagEntry is an entry read from the JSON file exported by Wallabag.
+type WallabagEntry struct {
+	URL       string
+	Title     string
+	Content   string // HTML of the article as it's saved by Wallabag
+	Tags      []string
+	Archived  bool  // whether it's been read
+	Starred   bool  // whether it's marked as starred
+	Public    bool  // whether it's shared publicly
+	CreatedAt int64 // Unix epoch in seconds, zero if it's not specified
+}
+
+// wallabagTimeLayouts are the layouts of time in Wallabag's export, which
+// depend on its version.
+var wallabagTimeLayouts = []string{
+	"2006-01-02T15:04:05-0700",
+	time.RFC3339,
+}
+
+// wallabagFlag is boolean field of Wallabag entry, which is either JSON
+// boolean or number, depending on Wallabag's version.
+type wallabagFlag bool
+
+// UnmarshalJSON decodes the flag from boolean, number or string.
+func (flag *wallabagFlag) UnmarshalJSON(data []byte) error {
+	s := strings.Trim(string(data), `"`)
+	*flag = wallabagFlag(s == "true" || s == "1")
+	return nil
+}
+
+// wallabagTag is tag of Wallabag entry, which is either its label, or
+// object with the label as it's returned by Wallabag's API.
+type wallabagTag string
+
+// UnmarshalJSON decodes the tag from string or object.
+func (tag *wallabagTag) UnmarshalJSON(data []byte) error {
+	var label string
+	if err := json.Unmarshal(data, &label); err == nil {
+		*tag = wallabagTag(label)
+		return nil
+	}
+
+	var object struct {
+		Label string `json:"label"`
+	}
+	if err := json.Unmarshal(data, &object); err != nil {
+		return err
+	}
+
+	*tag = wallabagTag(object.Label)
+	return nil
+}
+
+// UnmarshalJSON decodes the entry from its fields in Wallabag's export.
+func (entry *WallabagEntry) UnmarshalJSON(data []byte) error {
+	var raw struct {
+		URL        string        `json:"url"`
+		Title      string        `json:"title"`
+		Content    string        `json:"content"`
+		Tags       []wallabagTag `json:"tags"`
+		IsArchived wallabagFlag  `json:"is_archived"`
+		IsStarred  wallabagFlag  `json:"is_starred"`
+		IsPublic   wallabagFlag  `json:"is_public"`
+		CreatedAt  string        `json:"created_at"`
+	}
+
+	if err := json.Unmarshal(data, &raw); err != nil {
+		return err
+	}
+
+	*entry = WallabagEntry{
+		URL:      strings.TrimSpace(raw.URL),
+		Title:    strings.Join(strings.Fields(raw.Title), " "),
+		Content:  raw.Content,
+		Archived: bool(raw.IsArchived),
+		Starred:  bool(raw.IsStarred),
+		Public:   bool(raw.IsPublic),
+	}
+
+	for _, tag := range raw.Tags {
+		if name := strings.Join(strings.Fields(string(tag)), " "); name != "" {
+			entry.Tags = append(entry.Tags, name)
+		}
+	}
+
+	for _, layout := range wallabagTimeLayouts {
+		if t, err := time.Parse(layout, strings.TrimSpace(raw.CreatedAt)); err == nil {
+			entry.CreatedAt = t.Unix()
+			break
+		}
+	}
+
+	return nil
+}
+
+// ParseWallabagEntries reads entries from file exported by Wallabag in JSON
+// format, which is an array of entries. The array is decoded entry by entry,
+// so the content of every entry is never loaded into memory at once. The fn
+// is called for each entry in order, and the parsing stops once it returns
+// an error, which is then returned as it is.
+func ParseWallabagEntries(r io.Reader, fn func(WallabagEntry) error) error {
+	decoder := json.NewDecoder(r)
+
+	token, err := decoder.Token()
+	if err == io.EOF {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	if delim, ok := token.(json.Delim); !ok || delim != '[' {
+		return fmt.Errorf("JSON file isn't an array of entries")
+	}
+
+	for decoder.More() {
+		var entry WallabagEntry
+		if err := decoder.Decode(&entry); err != nil {
+			return err
+		}
+
+		if entry.URL == "" {
+			continue
+		}
+
+		if err := fn(entry); err != nil {
+			return err
+		}
+	}
+
+	_, err = decoder.Token()
+	return err
+}
@@ -0,0 +1,64 @@
+package core
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestParseWallabagEntries(t *testing.T) {
+	tests := []struct {
+		name    string
+		file    string
+		want    []WallabagEntry
+		wantErr bool
+	}{{
+		name: "entries",
+		file: `[
+			{"is_archived":1,"is_starred":0,"tags":["go"," web  dev "],"is_public":false,"id":1,
+			 "title":"Read\n item","url":"https://example.com/read","content":"<p>Article</p>",
+			 "created_at":"2017-07-14T04:40:00+0200","reading_time":1,"annotations":[]},
+			{"is_archived":false,"is_starred":true,"tags":[{"id":1,"label":"go","slug":"go"}],"is_public":true,
+			 "title":"Unread","url":"https://example.com/unread","created_at":"2017-07-14T02:40:00Z"},
+			{"title":"Without URL","url":""},
+			{"title":"Invalid time","url":"https://example.com/invalid","created_at":"yesterday","tags":[]}
+		]`,
+		want: []WallabagEntry{
+			{URL: "https://example.com/read", Title: "Read item", Content: "<p>Article</p>", Tags: []string{"go", "web dev"}, Archived: true, CreatedAt: 1500000000},
+			{URL: "https://example.com/unread", Title: "Unread", Tags: []string{"go"}, Starred: true, Public: true, CreatedAt: 1500000000},
+			{URL: "https://example.com/invalid", Title: "Invalid time"},
+		},
+	}, {
+		name: "empty",
+		file: " \n",
+		want: []WallabagEntry{},
+	}, {
+		name:    "not array",
+		file:    `{"url":"https://example.com"}`,
+		want:    []WallabagEntry{},
+		wantErr: true,
+	}, {
+		name:    "invalid tags",
+		file:    `[{"url":"https://example.com","tags":[1]}]`,
+		want:    []WallabagEntry{},
+		wantErr: true,
+	}}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := []WallabagEntry{}
+			err := ParseWallabagEntries(strings.NewReader(tt.file), func(entry WallabagEntry) error {
+				got = append(got, entry)
+				return nil
+			})
+
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("ParseWallabagEntries() error = %v, wantErr %v", err, tt.wantErr)
+			}
+
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("ParseWallabagEntries() =\n%+v\nwant\n%+v", got, tt.want)
+			}
+		})
+	}
+}
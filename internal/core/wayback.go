@@ -0,0 +1,81 @@
+package core
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	nurl "net/url"
+	"strings"
+)
+
+// waybackAPI is the availability API of Wayback Machine in Internet Archive,
+// which tells the closest snapshot of an URL.
+var waybackAPI = "https://archive.org/wayback/available"
+
+// ErrNoWaybackSnapshot is returned when Wayback Machine has no snapshot of the URL.
+var ErrNoWaybackSnapshot = errors.New("wayback machine has no snapshot of the url")
+
+// FindWaybackSnapshot asks Wayback Machine for the closest snapshot of the
+// URL, and returns the URL of that snapshot. The snapshot is served without
+// the toolbar of Wayback Machine, while its sub-resources are still served
+// from the archive, so it can be archived like the original page.
+func FindWaybackSnapshot(url string) (string, error) {
+	req, err := http.NewRequest("GET", waybackAPI+"?url="+nurl.QueryEscape(url), nil)
+	if err != nil {
+		return "", err
+	}
+
+	req.Header.Set("User-Agent", userAgent)
+	resp, err := linkCheckClient.Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("wayback machine responded with status %d", resp.StatusCode)
+	}
+
+	var result struct {
+		ArchivedSnapshots struct {
+			Closest struct {
+				Available bool   `json:"available"`
+				URL       string `json:"url"`
+				Timestamp string `json:"timestamp"`
+				Status    string `json:"status"`
+			} `json:"closest"`
+		} `json:"archived_snapshots"`
+	}
+
+	if err = json.NewDecoder(io.LimitReader(resp.Body, 1<<20)).Decode(&result); err != nil {
+		return "", fmt.Errorf("failed to parse wayback machine response: %v", err)
+	}
+
+	// Only the snapshot of page that existed is useful
+	closest := result.ArchivedSnapshots.Closest
+	if !closest.Available || closest.URL == "" || !strings.HasPrefix(closest.Status, "2") {
+		return "", ErrNoWaybackSnapshot
+	}
+
+	// Wayback Machine may return plain HTTP URL, while its `if_` flag
+	// serves the snapshot without the toolbar
+	snapshotURL := closest.URL
+	if strings.HasPrefix(snapshotURL, "http://web.archive.org/") {
+		snapshotURL = "https://" + strings.TrimPrefix(snapshotURL, "http://")
+	}
+
+	if closest.Timestamp != "" {
+		snapshotURL = strings.Replace(snapshotURL,
+			"/web/"+closest.Timestamp+"/", "/web/"+closest.Timestamp+"if_/", 1)
+	}
+
+	return snapshotURL, nil
+}
+
+// pageGone checks if the result of downloading the page means it's gone,
+// i.e. it can't be downloaded at all, or it's not found.
+func pageGone(statusCode int, err error) bool {
+	return err != nil || statusCode == http.StatusNotFound || statusCode == http.StatusGone
+}
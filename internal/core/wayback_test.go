@@ -0,0 +1,125 @@
+package core
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// newWaybackServer creates test server that serves the availability API and
+// snapshots of Wayback Machine, together with the pages. Page whose path ends
+// with `/gone` is not found, but it has snapshot. Its API is used until the
+// cleanup.
+func newWaybackServer() (*httptest.Server, func()) {
+	var srv *httptest.Server
+	srv = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch {
+		case r.URL.Path == "/available":
+			url := r.URL.Query().Get("url")
+			w.Header().Set("Content-Type", "application/json")
+			switch {
+			case strings.HasSuffix(url, "/gone"):
+				fmt.Fprintf(w, `{"archived_snapshots": {"closest": {"available": true,
+					"url": "%s/web/20200101000000/%s", "timestamp": "20200101000000", "status": "200"}}}`, srv.URL, url)
+			case strings.HasSuffix(url, "/redirected"):
+				fmt.Fprintf(w, `{"archived_snapshots": {"closest": {"available": true,
+					"url": "http://web.archive.org/web/20200101000000/%s", "timestamp": "20200101000000", "status": "301"}}}`, url)
+			default:
+				fmt.Fprint(w, `{"archived_snapshots": {}}`)
+			}
+		case strings.HasPrefix(r.URL.Path, "/web/20200101000000if_/"):
+			w.Header().Set("Content-Type", "text/html")
+			fmt.Fprint(w, "<html><body>snapshot</body></html>")
+		case r.URL.Path == "/page":
+			w.Header().Set("Content-Type", "text/html")
+			fmt.Fprint(w, "<html><body>page</body></html>")
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+
+	oldAPI := waybackAPI
+	waybackAPI = srv.URL + "/available"
+
+	return srv, func() {
+		waybackAPI = oldAPI
+		srv.Close()
+	}
+}
+
+func TestFindWaybackSnapshot(t *testing.T) {
+	srv, cleanup := newWaybackServer()
+	defer cleanup()
+
+	tests := []struct {
+		name    string
+		url     string
+		want    string
+		wantErr error
+	}{
+		{"snapshot found", "https://example.com/gone", srv.URL + "/web/20200101000000if_/https://example.com/gone", nil},
+		{"snapshot of redirect", "https://example.com/redirected", "", ErrNoWaybackSnapshot},
+		{"no snapshot", "https://example.com/never-archived", "", ErrNoWaybackSnapshot},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := FindWaybackSnapshot(tt.url)
+			if err != tt.wantErr {
+				t.Fatalf("FindWaybackSnapshot() error = %v, want %v", err, tt.wantErr)
+			}
+
+			if got != tt.want {
+				t.Errorf("FindWaybackSnapshot() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestFetchBookmarkWayback(t *testing.T) {
+	srv, cleanup := newWaybackServer()
+	defer cleanup()
+
+	tests := []struct {
+		name        string
+		url         string
+		policy      RenderPolicy
+		wantStatus  int
+		wantWayback string
+		want        string
+	}{
+		{"page exists", srv.URL + "/page", RenderPolicy{WaybackFallback: true},
+			http.StatusOK, "", "<html><body>page</body></html>"},
+		{"page gone", srv.URL + "/gone", RenderPolicy{WaybackFallback: true},
+			http.StatusNotFound, srv.URL + "/web/20200101000000if_/" + srv.URL + "/gone", "<html><body>snapshot</body></html>"},
+		{"page unreachable", "http://127.0.0.1:1/gone", RenderPolicy{WaybackFallback: true},
+			0, srv.URL + "/web/20200101000000if_/http://127.0.0.1:1/gone", "<html><body>snapshot</body></html>"},
+		{"fallback disabled", srv.URL + "/gone", RenderPolicy{},
+			http.StatusNotFound, "", "404 page not found\n"},
+		{"no snapshot", srv.URL + "/never-archived", RenderPolicy{WaybackFallback: true},
+			http.StatusNotFound, "", "404 page not found\n"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			content, _, statusCode, waybackURL, err := FetchBookmark(tt.url, tt.policy)
+			if err != nil {
+				t.Fatalf("FetchBookmark() error = %v", err)
+			}
+			defer content.Close()
+
+			got, err := ioutil.ReadAll(content)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if statusCode != tt.wantStatus || waybackURL != tt.wantWayback || string(got) != tt.want {
+				t.Errorf("FetchBookmark() = %d, %q, %q, want %d, %q, %q",
+					statusCode, waybackURL, got, tt.wantStatus, tt.wantWayback, tt.want)
+			}
+		})
+	}
+}
@@ -1,33 +1,207 @@
 package database
 
 import (
+	"context"
 	"database/sql"
+	"encoding/json"
+	"errors"
+	"html"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+	"unicode"
 
 	"shiori/internal/model"
+	"github.com/jmoiron/sqlx"
 )
 
+var (
+	rxHTMLTag      = regexp.MustCompile(`(?s)<!--.*?-->|</?[a-zA-Z][^>]*>`)
+	rxHTMLBlockTag = regexp.MustCompile(`(?i)^</?(br|p|div|li|h[1-6]|tr|td|th)\b`)
+)
+
+// ErrRegexTimeout is returned when bookmarks filtered by regular expression
+// can't be fetched within regexTimeout.
+var ErrRegexTimeout = errors.New("regular expression took too long to evaluate")
+
+// ErrVersionConflict is returned by SaveBookmarks when the saved bookmark
+// has been modified since its version was read.
+var ErrVersionConflict = errors.New("bookmark has been modified since it was read")
+
+// ErrBackupUnsupported is returned by Backup when the database is managed by
+// its own server, whose dump tool should be used to back it up instead.
+var ErrBackupUnsupported = errors.New("backup is only supported for SQLite database")
+
+// regexTimeout is max duration of query that filters bookmarks by regular
+// expression, since some databases might take forever on a pathological one.
+var regexTimeout = 10 * time.Second
+
+// lastRegex keeps the last compiled regular expression, since the
+// database evaluates the same pattern for every bookmark.
+var lastRegex struct {
+	sync.Mutex
+	pattern string
+	rx      *regexp.Regexp
+}
+
+// compileRegex compiles the pattern as case insensitive regular expression.
+func compileRegex(pattern string) (*regexp.Regexp, error) {
+	lastRegex.Lock()
+	defer lastRegex.Unlock()
+
+	if lastRegex.rx != nil && lastRegex.pattern == pattern {
+		return lastRegex.rx, nil
+	}
+
+	rx, err := regexp.Compile("(?i)" + pattern)
+	if err != nil {
+		return nil, err
+	}
+
+	lastRegex.pattern, lastRegex.rx = pattern, rx
+	return rx, nil
+}
+
+// queryContext returns the context for fetching bookmarks with the options.
+// The returned function must be called once the query finished.
+func queryContext(opts GetBookmarksOptions) (context.Context, context.CancelFunc) {
+	if opts.Regex == "" {
+		return context.WithCancel(context.Background())
+	}
+
+	return context.WithTimeout(context.Background(), regexTimeout)
+}
+
 // OrderMethod is the order method for getting bookmarks
 type OrderMethod int
 
 const (
 	// DefaultOrder is oldest to newest.
 	DefaultOrder OrderMethod = iota
-	// ByLastAdded is from newest addition to the oldest.
+	// ByLastAdded is from newest addition to the oldest, using
+	// the created time so imported bookmarks keep their chronology.
 	ByLastAdded
 	// ByLastModified is from latest modified to the oldest.
 	ByLastModified
+	// ByFirstModified is from earliest modified to the latest,
+	// with ties broken by ID. Used by sync clients to advance their cursor.
+	ByFirstModified
+	// ByManualOrder is by the position set by user, from the lowest. Bookmarks
+	// without position are put last, from newest addition to the oldest.
+	ByManualOrder
+	// ByFirstAdded is from oldest addition to the newest.
+	ByFirstAdded
+	// ByTitle is alphabetical order of title, ignoring its case.
+	ByTitle
+	// ByDomain is alphabetical order of URL host, then by title,
+	// so bookmarks from the same site are grouped together.
+	ByDomain
 )
 
 // GetBookmarksOptions is options for fetching bookmarks from database.
 type GetBookmarksOptions struct {
-	IDs          []int
-	Tags         []string
-	ExcludedTags []string
-	Keyword      string
-	WithContent  bool
-	OrderMethod  OrderMethod
-	Limit        int
-	Offset       int
+	IDs           []int
+	URLs          []string // bookmarks with any of the exact URLs
+	Tags          []string // bookmarks with all of the tags
+	AnyTags       []string // bookmarks with any of the tags
+	ExcludedTags  []string
+	ExcludedURLs  []string // substrings of URL host to exclude
+	Keyword       string   // terms separated by whitespace, phrase in double quotes
+	SearchFields  []string // fields searched by Keyword, empty means all of AllSearchFields
+	Regex         string   // case insensitive regular expression matched against title or excerpt
+	TitleTerms    []string
+	URLTerms      []string
+	UpdatedSince  string            // UTC time in "2006-01-02 15:04:05" format, inclusive
+	UpdatedBefore string            // UTC time in "2006-01-02 15:04:05" format, exclusive
+	CreatedSince  string            // UTC time in "2006-01-02 15:04:05" format, inclusive
+	CreatedBefore string            // UTC time in "2006-01-02 15:04:05" format, exclusive
+	ContentType   string            // media type, e.g. "application/pdf", or "image/*" for any image
+	Metadata      map[string]string // metadata key and its value, empty value matches any value
+	Versioned     bool              // only bookmarks with versioned archive
+	Untagged      bool              // only bookmarks without any tag
+	PublicOnly    bool              // only public bookmarks
+	Read          *bool             // only read or unread bookmarks, nil means both
+	Starred       bool              // only starred bookmarks
+	Broken        bool              // only bookmarks whose URL was found broken by link checker
+	CollectionIDs []int             // only bookmarks in any of the collections
+	OwnerID       int               // only bookmarks owned by the account, zero means any
+	WithContent   bool
+	OrderMethod   OrderMethod
+	Limit         int
+	Offset        int
+}
+
+// AllSearchFields is the fields of bookmark that unscoped keyword terms
+// might be searched in. By default every one of them is searched.
+var AllSearchFields = []string{"url", "title", "excerpt", "content"}
+
+// searchedFields returns the set of fields that Keyword is searched in.
+func searchedFields(opts GetBookmarksOptions) map[string]bool {
+	fields := opts.SearchFields
+	if len(fields) == 0 {
+		fields = AllSearchFields
+	}
+
+	result := map[string]bool{}
+	for _, field := range fields {
+		result[field] = true
+	}
+
+	return result
+}
+
+// TagOrderMethod is the order method for getting tags.
+type TagOrderMethod int
+
+const (
+	// TagsByName is alphabetical order of tag's name.
+	TagsByName TagOrderMethod = iota
+	// TagsByLastUsed is from the most recently used tag, using the
+	// created time of its bookmarks. Unused tags are ordered last.
+	TagsByLastUsed
+)
+
+// GetTagsOptions is options for fetching tags from database.
+type GetTagsOptions struct {
+	WithUnused  bool // include tags that not used by any bookmark
+	OrderMethod TagOrderMethod
+	OwnerID     int // only count bookmarks owned by the account, zero means any
+}
+
+// tagsQuery creates query for fetching tags based on submitted options.
+// The query is the same in every database, except the grouped columns.
+// It uses `?` as placeholder, which must be rebound for the database.
+func tagsQuery(opts GetTagsOptions, groupBy string) (string, []interface{}) {
+	args := []interface{}{}
+	query := `SELECT t.id, t.name, t.default_public, t.parent_id,
+		t.color, t.description, t.icon,
+		COUNT(b.id) n_bookmarks, MAX(b.created) last_used
+		FROM tag t
+		LEFT JOIN bookmark_tag bt ON bt.tag_id = t.id
+		LEFT JOIN bookmark b ON b.id = bt.bookmark_id`
+
+	if opts.OwnerID != 0 {
+		query += ` AND b.owner_id = ?`
+		args = append(args, opts.OwnerID)
+	}
+
+	query += ` GROUP BY ` + groupBy
+
+	if !opts.WithUnused {
+		query += ` HAVING COUNT(b.id) > 0`
+	}
+
+	switch opts.OrderMethod {
+	case TagsByLastUsed:
+		query += ` ORDER BY last_used IS NULL, last_used DESC, t.name`
+	default:
+		query += ` ORDER BY t.name`
+	}
+
+	return query, args
 }
 
 // GetAccountsOptions is options for fetching accounts from database.
@@ -39,8 +213,14 @@ type GetAccountsOptions struct {
 // DB is interface for accessing and manipulating data in database.
 type DB interface {
 	// SaveBookmarks saves bookmarks data to database.
+	// The version of each bookmark is increased on every save.
+	// New bookmark without ID gets its ID from database.
 	SaveBookmarks(bookmarks ...model.Bookmark) ([]model.Bookmark, error)
 
+	// SaveBookmarksWithoutContent saves existing bookmarks to database like
+	// SaveBookmarks, except their content is kept as it is.
+	SaveBookmarksWithoutContent(bookmarks ...model.Bookmark) ([]model.Bookmark, error)
+
 	// GetBookmarks fetch list of bookmarks based on submitted options.
 	GetBookmarks(opts GetBookmarksOptions) ([]model.Bookmark, error)
 
@@ -48,7 +228,16 @@ type DB interface {
 	GetBookmarksCount(opts GetBookmarksOptions) (int, error)
 
 	// DeleteBookmarks removes all record with matching ids from database.
-	DeleteBookmarks(ids ...int) error
+	// Returns the number of bookmarks that actually deleted.
+	DeleteBookmarks(ids ...int) (int, error)
+
+	// GetTombstones fetch list of deleted bookmarks since the specified time.
+	// Only the bookmarks owned by the account are listed, unless it's zero.
+	GetTombstones(since string, ownerID int) ([]model.Tombstone, error)
+
+	// RebuildSearchIndex makes sure the search index matches the bookmarks.
+	// Returns the number of index records that corrected.
+	RebuildSearchIndex() (int, error)
 
 	// GetBookmark fetchs bookmark based on its ID or URL.
 	GetBookmark(id int, url string) (model.Bookmark, bool)
@@ -62,17 +251,380 @@ type DB interface {
 	// GetAccount fetch account with matching username.
 	GetAccount(username string) (model.Account, bool)
 
+	// GetAccountByIdentity fetch account that linked to the user of
+	// OpenID Connect provider with matching issuer and subject.
+	GetAccountByIdentity(issuer, subject string) (model.Account, bool)
+
+	// UpdateAccountRole changes the role of account with matching
+	// username, while its password is kept.
+	UpdateAccountRole(username string, role string) error
+
 	// DeleteAccounts removes all record with matching usernames
 	DeleteAccounts(usernames ...string) error
 
-	// GetTags fetch list of tags and its frequency from database.
-	GetTags() ([]model.Tag, error)
+	// SaveAPIToken saves new API token in database. It returns the token
+	// together with its ID.
+	SaveAPIToken(token model.APIToken) (model.APIToken, error)
+
+	// GetAPITokens fetch list of API tokens owned by the account.
+	GetAPITokens(accountID int) ([]model.APIToken, error)
+
+	// GetAPITokenAccount fetch API token with matching hash together with
+	// the account that owns it. Returns whether it's exist or not.
+	GetAPITokenAccount(hash string) (model.APIToken, model.Account, bool)
+
+	// DeleteAPIToken revokes API token owned by the account.
+	// Returns whether the token actually deleted.
+	DeleteAPIToken(accountID int, id int) (bool, error)
+
+	// SaveShareLink saves new share link of bookmark in database. It returns
+	// the link together with its ID.
+	SaveShareLink(link model.ShareLink) (model.ShareLink, error)
+
+	// GetShareLinks fetch list of share links of the bookmark.
+	GetShareLinks(bookmarkID int) ([]model.ShareLink, error)
+
+	// GetSharedBookmark fetch bookmark, with its content, that shared by the
+	// link with matching hash. Returns whether the link is exist or not.
+	GetSharedBookmark(hash string) (model.Bookmark, bool)
+
+	// DeleteShareLink revokes share link of the bookmark.
+	// Returns whether the link actually deleted.
+	DeleteShareLink(bookmarkID int, id int) (bool, error)
+
+	// SaveCollection saves new or updated collection in database. It returns
+	// the collection together with its ID.
+	SaveCollection(collection model.Collection) (model.Collection, error)
+
+	// GetCollections fetch list of collections owned by the account, with the
+	// number of bookmarks directly inside each of them. Zero ownerID means any.
+	GetCollections(ownerID int) ([]model.Collection, error)
+
+	// DeleteCollection removes the collection. Its bookmarks and collections
+	// are moved to its parent, so nothing inside it is lost.
+	DeleteCollection(id int) error
+
+	// SetBookmarksCollection moves bookmarks into the collection, or out of any
+	// collection when collectionID is zero. Returns the number of moved bookmarks.
+	SetBookmarksCollection(ids []int, collectionID int) (int, error)
+
+	// SaveSession saves new login session in database, after removing the
+	// account's sessions that already expired. It returns the session
+	// together with its ID.
+	SaveSession(session model.Session) (model.Session, error)
+
+	// GetSessions fetch list of unexpired sessions of the account.
+	GetSessions(accountID int) ([]model.Session, error)
+
+	// GetSessionAccount fetch unexpired session with matching ID together
+	// with its account. Returns whether the session is exist or not.
+	GetSessionAccount(id int) (model.Session, model.Account, bool)
+
+	// DeleteSession revokes session of the account.
+	// Returns whether the session actually deleted.
+	DeleteSession(accountID int, id int) (bool, error)
+
+	// GetTags fetch list of tags, its frequency and the last time it's used.
+	GetTags(opts GetTagsOptions) ([]model.Tag, error)
+
+	// GetRelatedTags fetch list of tags that used together with the specified
+	// tag, ordered by how many bookmarks they share. Non zero ownerID only
+	// counts the bookmarks owned by that account.
+	GetRelatedTags(id int, ownerID int, limit int) ([]model.Tag, error)
 
 	// RenameTag change the name of a tag.
 	RenameTag(id int, newName string) error
 
+	// RenameTags change the name of several tags, keyed by their ID.
+	// Tags that end up with the same name are merged into one.
+	RenameTags(renames map[int]string) error
+
+	// DeleteTag removes the tag from database and from every bookmark.
+	// Returns the IDs of the bookmarks that no longer have any tag.
+	DeleteTag(id int) ([]int, error)
+
+	// SetBookmarksOrder sets the position of bookmarks following their order
+	// in ids, starting from 1. Position of other bookmarks is left as it is.
+	SetBookmarksOrder(ids []int) error
+
+	// SetBookmarksRead marks bookmarks as read or unread, and returns
+	// the number of bookmarks whose status is changed.
+	SetBookmarksRead(ids []int, read bool) (int, error)
+
+	// SetBookmarkStarred stars or unstars the bookmark.
+	SetBookmarkStarred(id int, starred bool) error
+
+	// SetBookmarkLinkStatus records the HTTP status code that returned when
+	// the bookmark URL is checked, or zero when it couldn't be reached.
+	SetBookmarkLinkStatus(id int, status int) error
+
+	// SetTagDefaultPublic sets the visibility of bookmarks that the tag is
+	// added to. Nil removes it, so adding the tag won't change visibility.
+	SetTagDefaultPublic(id int, public *int) error
+
+	// SetTagMetadata sets the color, description and icon of the tag.
+	SetTagMetadata(tag model.Tag) error
+
+	// GetTagDefaultPublic fetch the default visibility of tags that have it,
+	// keyed by the tag name.
+	GetTagDefaultPublic() (map[string]int, error)
+
 	// CreateNewID creates new id for specified table.
 	CreateNewID(table string) (int, error)
+
+	// SchemaVersion returns the version of database schema, together
+	// with the version that required by this binary.
+	SchemaVersion() (SchemaVersion, error)
+
+	// Backup writes consistent copy of the whole database into new file at
+	// dstPath, while the database is still in use. Returns
+	// ErrBackupUnsupported if the database isn't a file.
+	Backup(dstPath string) error
+}
+
+// accountRole returns the role of account that will be saved, and whether
+// it's the owner. Account without role, e.g. from older client, is either
+// owner or viewer following its Owner field.
+func accountRole(account model.Account) (string, bool) {
+	role := account.Role
+	if role == "" {
+		role = model.RoleViewer
+		if account.Owner {
+			role = model.RoleOwner
+		}
+	}
+
+	return role, role == model.RoleOwner
+}
+
+// renamingTagName is the temporary name of a tag while several tags are
+// renamed, so the unique constraint is kept until its final name is set.
+func renamingTagName(id int) string {
+	return "\x1frenaming-" + strconv.Itoa(id)
+}
+
+// contentTypePattern converts content type filter into pattern for LIKE,
+// where wildcard subtype like "image/*" matches any image.
+func contentTypePattern(contentType string) string {
+	contentType = strings.ToLower(strings.TrimSpace(contentType))
+	if strings.HasSuffix(contentType, "/*") {
+		return strings.TrimSuffix(contentType, "*") + "%"
+	}
+
+	return contentType
+}
+
+// metadataPattern creates pattern for LIKE with `!` as escape character, which
+// matches metadata JSON that has the key with the value, or with any value if
+// it's empty. Since metadata is stored as compact JSON where quote inside of
+// a string is escaped, the pattern never matches the content of other value.
+func metadataPattern(key, value string) string {
+	jsonKey, _ := json.Marshal(key)
+	pattern := string(jsonKey) + ":"
+	if value != "" {
+		jsonValue, _ := json.Marshal(value)
+		pattern += string(jsonValue)
+	}
+
+	return "%" + likeEscaper.Replace(pattern) + "%"
+}
+
+// likeEscaper escapes pattern for LIKE with `!` as escape character.
+var likeEscaper = strings.NewReplacer("!", "!!", "%", "!%", "_", "!_")
+
+// tagDescendantsPattern creates pattern for LIKE with `!` as escape character,
+// which matches the names of every tag nested in the hierarchical tag, e.g.
+// "dev/go" and "dev/go/web" for "dev".
+func tagDescendantsPattern(name string) string {
+	return likeEscaper.Replace(name) + "/%"
+}
+
+// linkTagParents links hierarchical tags to their parent, which is the tag
+// whose name is the path before the last slash, e.g. "dev/go" for "dev/go/web".
+// The parents that don't exist yet are created, together with their own
+// parents. Tag whose name has no slash is unlinked from any parent.
+func linkTagParents(tx *sqlx.Tx, names ...string) {
+	getTag := tx.Rebind(`SELECT id FROM tag WHERE name = ?`)
+	insertTag := tx.Rebind(`INSERT INTO tag (name) VALUES (?)`)
+	updateParent := tx.Rebind(`UPDATE tag SET parent_id = ? WHERE id = ? AND parent_id <> ?`)
+
+	for _, name := range names {
+		parentID := 0
+		parts := strings.Split(name, "/")
+		for i := range parts {
+			path := strings.Join(parts[:i+1], "/")
+
+			var id int
+			err := tx.Get(&id, getTag, path)
+			if err == sql.ErrNoRows {
+				tx.MustExec(insertTag, path)
+				err = tx.Get(&id, getTag, path)
+			}
+			checkError(err)
+
+			tx.MustExec(updateParent, parentID, id, parentID)
+			parentID = id
+		}
+	}
+}
+
+// relinkTagParents links every tag to its parent again,
+// e.g. after tags are renamed.
+func relinkTagParents(tx *sqlx.Tx) {
+	names := []string{}
+	err := tx.Select(&names, `SELECT name FROM tag ORDER BY name`)
+	checkError(err)
+
+	linkTagParents(tx, names...)
+}
+
+// relinkRenamedTags links every tag to its parent again after the tags with
+// the specified names are renamed. Only the parents of the renamed tags are
+// created when missing, other tags whose parent no longer exists (e.g. it
+// was deleted) are kept at the top level.
+func relinkRenamedTags(tx *sqlx.Tx, renamed ...string) {
+	linkTagParents(tx, renamed...)
+
+	tags := []model.Tag{}
+	err := tx.Select(&tags, `SELECT id, name FROM tag`)
+	checkError(err)
+
+	tagIDs := make(map[string]int, len(tags))
+	for _, tag := range tags {
+		tagIDs[tag.Name] = tag.ID
+	}
+
+	updateParent := tx.Rebind(`UPDATE tag SET parent_id = ? WHERE id = ? AND parent_id <> ?`)
+	for _, tag := range tags {
+		parentID := 0
+		if i := strings.LastIndex(tag.Name, "/"); i >= 0 {
+			parentID = tagIDs[tag.Name[:i]]
+		}
+
+		tx.MustExec(updateParent, parentID, tag.ID, parentID)
+	}
+}
+
+// sortedKeys returns the keys of map in ascending order.
+func sortedKeys(m map[string]string) []string {
+	keys := make([]string, 0, len(m))
+	for key := range m {
+		keys = append(keys, key)
+	}
+
+	sort.Strings(keys)
+	return keys
+}
+
+// normalizeTime converts timestamp in either "2006-01-02 15:04:05" or RFC3339
+// format into UTC "2006-01-02 15:04:05". Returns fallback if it's not valid.
+func normalizeTime(s string, fallback string) string {
+	t, err := time.Parse("2006-01-02 15:04:05", s)
+	if err != nil {
+		t, err = time.Parse(time.RFC3339, s)
+	}
+
+	if err != nil {
+		return fallback
+	}
+
+	return t.UTC().Format("2006-01-02 15:04:05")
+}
+
+// normalizeText converts s into plain text by removing HTML tags,
+// decoding HTML entities and collapsing the whitespaces. Block tags
+// are replaced with space, so words around it are not joined.
+func normalizeText(s string) string {
+	s = rxHTMLTag.ReplaceAllStringFunc(s, func(tag string) string {
+		if rxHTMLBlockTag.MatchString(tag) {
+			return " "
+		}
+		return ""
+	})
+
+	s = html.UnescapeString(s)
+	return strings.Join(strings.Fields(s), " ")
+}
+
+// ParseKeyword parses search keyword which might contain terms that scoped
+// to a specific field, then puts them into opts. The grammar is:
+//
+//	keyword = term { whitespace term }
+//	term    = [ field ":" ] value
+//	field   = "title" | "url" | "tag"
+//	value   = word | '"' phrase '"'
+//
+// Scoped terms are put into TitleTerms, URLTerms and Tags respectively,
+// and combined with the existing values. The unscoped terms are kept in
+// Keyword and searched in every field, so plain keyword works as usual.
+func ParseKeyword(keyword string, opts *GetBookmarksOptions) {
+	unscopedTerms := []string{}
+	for _, term := range splitKeyword(keyword) {
+		field, value := "", term
+		if idx := strings.Index(term, ":"); idx > 0 {
+			field = strings.ToLower(term[:idx])
+			value = strings.TrimSpace(term[idx+1:])
+		}
+
+		switch field {
+		case "title":
+			if value != "" {
+				opts.TitleTerms = append(opts.TitleTerms, value)
+			}
+		case "url":
+			if value != "" {
+				opts.URLTerms = append(opts.URLTerms, value)
+			}
+		case "tag":
+			if value != "" {
+				opts.Tags = append(opts.Tags, strings.ToLower(value))
+			}
+		default:
+			// Unknown field is part of the term, e.g. "https://..."
+			if strings.ContainsAny(term, " \t") {
+				term = `"` + term + `"`
+			}
+			unscopedTerms = append(unscopedTerms, term)
+		}
+	}
+
+	opts.Keyword = strings.Join(unscopedTerms, " ")
+}
+
+// splitKeyword splits search keyword into terms separated by whitespace.
+// Phrase that wrapped in double quotes is kept as a single term. If the
+// closing quote is missing, the phrase runs until the end of keyword.
+// Phrase that directly follows a colon is kept together with the word
+// before it, e.g. `title:"web server"` becomes a single term.
+func splitKeyword(keyword string) []string {
+	terms := []string{}
+	inQuote := false
+	buffer := []rune{}
+
+	addTerm := func() {
+		term := strings.Join(strings.Fields(string(buffer)), " ")
+		if term != "" {
+			terms = append(terms, term)
+		}
+		buffer = buffer[:0]
+	}
+
+	for _, r := range keyword {
+		switch {
+		case r == '"' && !inQuote && len(buffer) > 0 && buffer[len(buffer)-1] == ':':
+			inQuote = true
+		case r == '"':
+			addTerm()
+			inQuote = !inQuote
+		case unicode.IsSpace(r) && !inQuote:
+			addTerm()
+		default:
+			buffer = append(buffer, r)
+		}
+	}
+
+	addTerm()
+	return terms
 }
 
 func checkError(err error) {
@@ -80,3 +632,14 @@ func checkError(err error) {
 		panic(err)
 	}
 }
+
+// checkVersionUpdated makes sure the bookmark is updated by the statement
+// that only matches its read version, otherwise it has been modified since.
+func checkVersionUpdated(res sql.Result) {
+	nUpdated, err := res.RowsAffected()
+	checkError(err)
+
+	if nUpdated == 0 {
+		panic(ErrVersionConflict)
+	}
+}
@@ -0,0 +1,117 @@
+package database
+
+import (
+	"reflect"
+	"testing"
+)
+
+func Test_normalizeText(t *testing.T) {
+	tests := []struct {
+		name string
+		text string
+		want string
+	}{
+		{"plain text", "Simple title", "Simple title"},
+		{"entities", "Tom &amp; Jerry &quot;Show&quot; &#39;99", `Tom & Jerry "Show" '99`},
+		{"inline tags", "<b>Bold</b> and <i>italic</i>", "Bold and italic"},
+		{"inline tag inside word", "Go<b>lang</b>", "Golang"},
+		{"block tags", "First<br>Second<p>Third</p>", "First Second Third"},
+		{"newlines and tabs", "  Multi\n\tline\r\n  title ", "Multi line title"},
+		{"comment", "Title<!-- hidden\ncomment -->", "Title"},
+		{"escaped tag stays as text", "&lt;b&gt;not bold&lt;/b&gt;", "<b>not bold</b>"},
+		{"less than sign", "1 < 2 > 0", "1 < 2 > 0"},
+		{"only tags", "<b></b>", ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := normalizeText(tt.text); got != tt.want {
+				t.Errorf("normalizeText() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func Test_splitKeyword(t *testing.T) {
+	tests := []struct {
+		name    string
+		keyword string
+		want    []string
+	}{
+		{"empty keyword", "", []string{}},
+		{"only whitespace", "   \t ", []string{}},
+		{"single word", "golang", []string{"golang"}},
+		{"multiple words", "golang  web\tserver", []string{"golang", "web", "server"}},
+		{"quoted phrase", `"web server" golang`, []string{"web server", "golang"}},
+		{"phrase with extra whitespace", `"  web   server "`, []string{"web server"}},
+		{"phrase adjacent to word", `go"web server"`, []string{"go", "web server"}},
+		{"unclosed quote", `golang "web server`, []string{"golang", "web server"}},
+		{"empty quotes", `"" golang`, []string{"golang"}},
+		{"phrase after colon", `title:"web server" golang`, []string{"title:web server", "golang"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := splitKeyword(tt.keyword); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("splitKeyword() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestParseKeyword(t *testing.T) {
+	tests := []struct {
+		name    string
+		keyword string
+		tags    []string
+		want    GetBookmarksOptions
+	}{
+		{"plain keyword", "golang web", nil,
+			GetBookmarksOptions{Keyword: "golang web"}},
+		{"scoped terms", `title:"web server" url:github TAG:Go golang`, nil,
+			GetBookmarksOptions{
+				Keyword:    "golang",
+				TitleTerms: []string{"web server"},
+				URLTerms:   []string{"github"},
+				Tags:       []string{"go"},
+			}},
+		{"combined with existing tags", "tag:go", []string{"web"},
+			GetBookmarksOptions{Tags: []string{"web", "go"}}},
+		{"empty scoped value", "title: golang", nil,
+			GetBookmarksOptions{Keyword: "golang"}},
+		{"unknown field", `https://example.com "web server"`, nil,
+			GetBookmarksOptions{Keyword: `https://example.com "web server"`}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := GetBookmarksOptions{Tags: tt.tags}
+			ParseKeyword(tt.keyword, &got)
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("ParseKeyword() = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func Test_normalizeTime(t *testing.T) {
+	tests := []struct {
+		name string
+		s    string
+		want string
+	}{
+		{"database format", "2020-01-02 03:04:05", "2020-01-02 03:04:05"},
+		{"RFC3339 in UTC", "2020-01-02T03:04:05Z", "2020-01-02 03:04:05"},
+		{"RFC3339 with offset", "2020-01-02T10:04:05+07:00", "2020-01-02 03:04:05"},
+		{"empty", "", "fallback"},
+		{"invalid", "yesterday", "fallback"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := normalizeTime(tt.s, "fallback"); got != tt.want {
+				t.Errorf("normalizeTime() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
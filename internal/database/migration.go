@@ -0,0 +1,120 @@
+package database
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+	"github.com/sirupsen/logrus"
+)
+
+// migration is a single step that changes the database schema.
+type migration struct {
+	version     int
+	description string
+	migrate     func(tx *sqlx.Tx) error
+}
+
+// SchemaVersion is the version of database schema, i.e.
+// the version of the latest migration applied to it.
+type SchemaVersion struct {
+	Current int `json:"current"` // version that applied to database
+	Target  int `json:"target"`  // version that required by this binary
+}
+
+// runMigrations applies the migrations that newer than the current version of
+// database schema. Each migration runs in its own transaction, together with
+// the record of its version, so a failed migration is never half applied and
+// the next startup continues from the last successful one. The exception is
+// MySQL, which commits schema changes implicitly, so its failed migration
+// might be half applied. That's why each of its schema changes is skipped
+// when it already exists, so the whole migration can be applied again.
+func runMigrations(db *sqlx.DB, migrations []migration) error {
+	version, err := getSchemaVersion(db, migrations)
+	if err != nil {
+		return err
+	}
+
+	if version.Current > version.Target {
+		return fmt.Errorf("database schema version %d is newer than version %d supported by this binary",
+			version.Current, version.Target)
+	}
+
+	for _, m := range migrations {
+		if m.version <= version.Current {
+			continue
+		}
+
+		entry := logrus.WithFields(logrus.Fields{
+			"version":     m.version,
+			"description": m.description,
+		})
+
+		if err := applyMigration(db, m); err != nil {
+			entry.WithError(err).Error("failed to apply migration")
+			return fmt.Errorf("failed to apply migration %d (%s): %v", m.version, m.description, err)
+		}
+
+		entry.Info("applied migration")
+	}
+
+	return nil
+}
+
+// applyMigration runs a single migration and records its version.
+func applyMigration(db *sqlx.DB, m migration) (err error) {
+	tx, err := db.Beginx()
+	if err != nil {
+		return err
+	}
+
+	// Make sure to rollback if panic ever happened
+	defer func() {
+		if r := recover(); r != nil {
+			tx.Rollback()
+
+			if panicErr, ok := r.(error); ok {
+				err = panicErr
+			} else {
+				err = fmt.Errorf("%v", r)
+			}
+		}
+	}()
+
+	if err = m.migrate(tx); err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	applied := time.Now().UTC().Format("2006-01-02 15:04:05")
+	tx.MustExec(tx.Rebind(`INSERT INTO schema_migration
+		(version, description, applied) VALUES (?, ?, ?)`),
+		m.version, m.description, applied)
+
+	return tx.Commit()
+}
+
+// getSchemaVersion returns the version of database schema. The table that
+// records the applied migrations is created first if it doesn't exist yet.
+func getSchemaVersion(db *sqlx.DB, migrations []migration) (SchemaVersion, error) {
+	_, err := db.Exec(`CREATE TABLE IF NOT EXISTS schema_migration(
+		version     INT          NOT NULL,
+		description VARCHAR(250) NOT NULL,
+		applied     VARCHAR(20)  NOT NULL,
+		PRIMARY KEY(version))`)
+	if err != nil {
+		return SchemaVersion{}, fmt.Errorf("failed to create migration table: %v", err)
+	}
+
+	version := SchemaVersion{}
+	err = db.Get(&version.Current, `SELECT COALESCE(MAX(version), 0) FROM schema_migration`)
+	if err != nil {
+		return SchemaVersion{}, fmt.Errorf("failed to get schema version: %v", err)
+	}
+
+	if len(migrations) > 0 {
+		version.Target = migrations[len(migrations)-1].version
+	}
+
+	return version, nil
+}
@@ -0,0 +1,159 @@
+package database
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	fp "path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/jmoiron/sqlx"
+)
+
+func Test_runMigrations(t *testing.T) {
+	tmpDir, err := ioutil.TempDir("", "shiori-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	db := sqlx.MustConnect(sqliteDriverName, fp.Join(tmpDir, "migration.db"))
+	defer db.Close()
+
+	nApplied := 0
+	migrations := []migration{
+		{1, "create table", func(tx *sqlx.Tx) error {
+			nApplied++
+			_, err := tx.Exec(`CREATE TABLE item(id INTEGER NOT NULL)`)
+			return err
+		}},
+	}
+
+	// Applied migration is never applied again
+	for i := 0; i < 2; i++ {
+		if err := runMigrations(db, migrations); err != nil {
+			t.Fatalf("runMigrations() error = %v", err)
+		}
+	}
+
+	if nApplied != 1 {
+		t.Errorf("migration applied %d times, want once", nApplied)
+	}
+
+	// Failed migration is rolled back, and stops the later ones
+	migrations = append(migrations,
+		migration{2, "broken", func(tx *sqlx.Tx) error {
+			tx.MustExec(`ALTER TABLE item ADD COLUMN name TEXT`)
+			return fmt.Errorf("something went wrong")
+		}},
+		migration{3, "never applied", func(tx *sqlx.Tx) error {
+			nApplied++
+			return nil
+		}})
+
+	err = runMigrations(db, migrations)
+	if err == nil || !strings.Contains(err.Error(), "migration 2 (broken)") {
+		t.Fatalf("runMigrations() error = %v, want error of migration 2", err)
+	}
+
+	if _, err := db.Exec(`SELECT name FROM item`); err == nil {
+		t.Errorf("failed migration is not rolled back")
+	}
+
+	version, err := getSchemaVersion(db, migrations)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if version != (SchemaVersion{Current: 1, Target: 3}) || nApplied != 1 {
+		t.Errorf("schema version = %+v after failed migration, want current 1 and target 3", version)
+	}
+
+	// Database that migrated by newer binary is rejected
+	err = runMigrations(db, migrations[:0])
+	if err == nil || !strings.Contains(err.Error(), "newer") {
+		t.Errorf("runMigrations() with older binary error = %v, want error", err)
+	}
+}
+
+func TestOpenSQLiteDatabaseUntracked(t *testing.T) {
+	tmpDir, err := ioutil.TempDir("", "shiori-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	// Create database from before the migrations were tracked,
+	// which lacks most of the columns in bookmark table
+	dbPath := fp.Join(tmpDir, "shiori.db")
+	oldDB := sqlx.MustConnect(sqliteDriverName, dbPath)
+	oldDB.MustExec(`CREATE TABLE bookmark(
+		id       INTEGER NOT NULL,
+		url      TEXT    NOT NULL,
+		title    TEXT    NOT NULL,
+		excerpt  TEXT    NOT NULL DEFAULT "",
+		author   TEXT    NOT NULL DEFAULT "",
+		modified TEXT    NOT NULL DEFAULT CURRENT_TIMESTAMP,
+		CONSTRAINT bookmark_PK PRIMARY KEY(id),
+		CONSTRAINT bookmark_url_UNIQUE UNIQUE(url))`)
+	oldDB.MustExec(`INSERT INTO bookmark (id, url, title, modified)
+		VALUES (1, 'https://example.com', 'Example', '2019-01-01 00:00:00')`)
+	oldDB.Close()
+
+	db, err := OpenSQLiteDatabase(dbPath)
+	if err != nil {
+		t.Fatalf("OpenSQLiteDatabase() error = %v", err)
+	}
+	defer db.Close()
+
+	version, err := db.SchemaVersion()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := SchemaVersion{Current: len(sqliteMigrations), Target: len(sqliteMigrations)}
+	if version != want {
+		t.Errorf("SchemaVersion() = %+v, want %+v", version, want)
+	}
+
+	book, exist := db.GetBookmark(1, "")
+	if !exist || book.Created != "2019-01-01 00:00:00" {
+		t.Errorf("GetBookmark() = %+v, want bookmark created at its modified time", book)
+	}
+}
+
+func Test_sqliteAccountRole(t *testing.T) {
+	tmpDir, err := ioutil.TempDir("", "shiori-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	db := sqlx.MustConnect(sqliteDriverName, fp.Join(tmpDir, "role.db"))
+	defer db.Close()
+
+	// Accounts are created before the roles exist
+	if err := runMigrations(db, sqliteMigrations[:3]); err != nil {
+		t.Fatalf("runMigrations() error = %v", err)
+	}
+	db.MustExec(`INSERT INTO account (username, password, owner) VALUES
+		('admin', 'secret', 1), ('writer', 'secret', 0)`)
+
+	if err := runMigrations(db, sqliteMigrations[:4]); err != nil {
+		t.Fatalf("runMigrations() error = %v", err)
+	}
+
+	tests := map[string]string{
+		"admin":  "owner",
+		"writer": "editor",
+	}
+
+	for username, wantRole := range tests {
+		var role string
+		db.Get(&role, `SELECT role FROM account WHERE username = ?`, username)
+		if role != wantRole {
+			t.Errorf("role of %s = %q, want %q", username, role, wantRole)
+		}
+	}
+}
@@ -1,8 +1,10 @@
 package database
 
 import (
+	"context"
 	"database/sql"
 	"fmt"
+	"sort"
 	"strings"
 	"time"
 
@@ -17,29 +19,33 @@ type MySQLDatabase struct {
 	sqlx.DB
 }
 
-// OpenMySQLDatabase creates and opens connection to a MySQL Database.
-func OpenMySQLDatabase(connString string) (mysqlDB *MySQLDatabase, err error) {
-	// Open database and start transaction
-	db := sqlx.MustConnect("mysql", connString)
-	db.SetMaxOpenConns(100)
-	db.SetConnMaxLifetime(time.Second) // in case mysql client has longer timeout (driver issue #674)
-
-	tx, err := db.Beginx()
-	if err != nil {
-		return nil, err
-	}
-
-	// Make sure to rollback if panic ever happened
-	defer func() {
-		if r := recover(); r != nil {
-			panicErr, _ := r.(error)
-			tx.Rollback()
-
-			mysqlDB = nil
-			err = panicErr
-		}
-	}()
+// mysqlMigrations is the list of migrations for MySQL database, ordered by
+// their version. Released migration must never be changed, so any change
+// to the schema must be added as a new migration.
+var mysqlMigrations = []migration{
+	{1, "create initial schema", mysqlInitialSchema},
+	{2, "create api token table", mysqlAPITokenSchema},
+	{3, "create session table", mysqlSessionSchema},
+	{4, "add account role", mysqlAccountRole},
+	{5, "add bookmark owner", mysqlBookmarkOwner},
+	{6, "create share link table", mysqlShareLinkSchema},
+	{7, "add bookmark read status", mysqlBookmarkRead},
+	{8, "add starred bookmark", mysqlBookmarkStarred},
+	{9, "create collection table", mysqlCollectionSchema},
+	{10, "add tag parent", mysqlTagParent},
+	{11, "add tag metadata", mysqlTagMetadata},
+	{12, "add bookmark link status", mysqlBookmarkLinkStatus},
+	{13, "add bookmark wayback url", mysqlBookmarkWaybackURL},
+	{14, "add api token scope", mysqlAPITokenScope},
+	{15, "add account provider", mysqlAccountProvider},
+	{16, "add account identity", mysqlAccountIdentity},
+	{17, "add tombstone owner", mysqlTombstoneOwner},
+	{18, "make bookmark url unique per owner", mysqlBookmarkURLPerOwner},
+}
 
+// mysqlInitialSchema creates the initial schema. It also upgrades database
+// that created before the migrations were tracked, so it must be idempotent.
+func mysqlInitialSchema(tx *sqlx.Tx) error {
 	// Create tables
 	tx.MustExec(`CREATE TABLE IF NOT EXISTS account(
 		id       INT(11)      NOT NULL AUTO_INCREMENT,
@@ -51,23 +57,32 @@ func OpenMySQLDatabase(connString string) (mysqlDB *MySQLDatabase, err error) {
 		CHARACTER SET utf8mb4`)
 
 	tx.MustExec(`CREATE TABLE IF NOT EXISTS bookmark(
-		id       INT(11)    NOT NULL AUTO_INCREMENT,
-		url      TEXT       NOT NULL,
-		title    TEXT       NOT NULL,
-		excerpt  TEXT       NOT NULL DEFAULT (''),
-		author   TEXT       NOT NULL DEFAULT (''),
-		public   BOOLEAN    NOT NULL DEFAULT 0,
-		content  MEDIUMTEXT NOT NULL DEFAULT (''),
-		html     MEDIUMTEXT NOT NULL DEFAULT (''),
-		modified TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP ON UPDATE CURRENT_TIMESTAMP,
+		id           INT(11)      NOT NULL AUTO_INCREMENT,
+		url          TEXT         NOT NULL,
+		title        TEXT         NOT NULL,
+		excerpt      TEXT         NOT NULL DEFAULT (''),
+		author       TEXT         NOT NULL DEFAULT (''),
+		public       BOOLEAN      NOT NULL DEFAULT 0,
+		content      MEDIUMTEXT   NOT NULL DEFAULT (''),
+		html         MEDIUMTEXT   NOT NULL DEFAULT (''),
+		content_type VARCHAR(255) NOT NULL DEFAULT '',
+		content_hash VARCHAR(64)  NOT NULL DEFAULT '',
+		sort_order   INT(11)      NULL,
+		metadata     TEXT         NOT NULL DEFAULT ('{}'),
+		last_status  INT(11)      NOT NULL DEFAULT 0,
+		versioned    BOOLEAN      NOT NULL DEFAULT 0,
+		created      TIMESTAMP    NOT NULL DEFAULT CURRENT_TIMESTAMP,
+		modified     TIMESTAMP    NOT NULL DEFAULT CURRENT_TIMESTAMP ON UPDATE CURRENT_TIMESTAMP,
+		version      INT(11)      NOT NULL DEFAULT 1,
 		PRIMARY KEY(id),
 		UNIQUE KEY bookmark_url_UNIQUE (url(255)),
 		FULLTEXT (title, excerpt, content))
 		CHARACTER SET utf8mb4`)
 
 	tx.MustExec(`CREATE TABLE IF NOT EXISTS tag(
-		id   INT(11)      NOT NULL AUTO_INCREMENT,
-		name VARCHAR(250) NOT NULL,
+		id             INT(11)      NOT NULL AUTO_INCREMENT,
+		name           VARCHAR(250) NOT NULL,
+		default_public BOOLEAN      NULL,
 		PRIMARY KEY (id),
 		UNIQUE KEY tag_name_UNIQUE (name))
 		CHARACTER SET utf8mb4`)
@@ -82,16 +97,277 @@ func OpenMySQLDatabase(connString string) (mysqlDB *MySQLDatabase, err error) {
 		CONSTRAINT bookmark_tag_tag_id_FK FOREIGN KEY (tag_id) REFERENCES tag (id))
 		CHARACTER SET utf8mb4`)
 
-	err = tx.Commit()
+	tx.MustExec(`CREATE TABLE IF NOT EXISTS bookmark_tombstone(
+		id      INT(11)   NOT NULL,
+		url     TEXT      NOT NULL,
+		deleted TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+		PRIMARY KEY(id))
+		CHARACTER SET utf8mb4`)
+
+	// Alter table if needed
+	tx.Exec(`ALTER TABLE bookmark ADD COLUMN content_type VARCHAR(255) NOT NULL DEFAULT ''`)
+	tx.Exec(`ALTER TABLE bookmark ADD COLUMN version INT(11) NOT NULL DEFAULT 1`)
+	tx.Exec(`ALTER TABLE bookmark ADD COLUMN content_hash VARCHAR(64) NOT NULL DEFAULT ''`)
+	tx.Exec(`ALTER TABLE bookmark ADD COLUMN sort_order INT(11) NULL`)
+	tx.Exec(`ALTER TABLE bookmark ADD COLUMN metadata TEXT NOT NULL DEFAULT ('{}')`)
+	tx.Exec(`ALTER TABLE bookmark ADD COLUMN last_status INT(11) NOT NULL DEFAULT 0`)
+	tx.Exec(`ALTER TABLE bookmark ADD COLUMN versioned BOOLEAN NOT NULL DEFAULT 0`)
+	tx.Exec(`ALTER TABLE tag ADD COLUMN default_public BOOLEAN NULL`)
+
+	// Existing bookmarks don't have created time,
+	// so use their modified time. Modified is assigned to itself,
+	// otherwise MySQL updates it to current time.
+	if _, err := tx.Exec(`ALTER TABLE bookmark ADD COLUMN created TIMESTAMP NULL`); err == nil {
+		tx.MustExec(`UPDATE bookmark SET created = modified, modified = modified`)
+		tx.MustExec(`ALTER TABLE bookmark
+			MODIFY created TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP`)
+	}
+
+	return nil
+}
+
+// mysqlAPITokenSchema creates the table of API tokens.
+func mysqlAPITokenSchema(tx *sqlx.Tx) error {
+	tx.MustExec(`CREATE TABLE IF NOT EXISTS api_token(
+		id         INT(11)      NOT NULL AUTO_INCREMENT,
+		account_id INT(11)      NOT NULL,
+		name       VARCHAR(250) NOT NULL,
+		token_hash VARCHAR(64)  NOT NULL,
+		created    TIMESTAMP    NOT NULL DEFAULT CURRENT_TIMESTAMP,
+		PRIMARY KEY (id),
+		UNIQUE KEY api_token_hash_UNIQUE (token_hash),
+		KEY api_token_account_id_FK (account_id),
+		CONSTRAINT api_token_account_id_FK FOREIGN KEY (account_id) REFERENCES account (id))
+		CHARACTER SET utf8mb4`)
+
+	return nil
+}
+
+// mysqlSessionSchema creates the table of login sessions.
+func mysqlSessionSchema(tx *sqlx.Tx) error {
+	tx.MustExec(`CREATE TABLE IF NOT EXISTS account_session(
+		id         INT(11)     NOT NULL AUTO_INCREMENT,
+		account_id INT(11)     NOT NULL,
+		token_hash VARCHAR(64) NOT NULL,
+		created    TIMESTAMP   NOT NULL DEFAULT CURRENT_TIMESTAMP,
+		expires    TIMESTAMP   NOT NULL,
+		PRIMARY KEY (id),
+		KEY account_session_account_id_FK (account_id),
+		CONSTRAINT account_session_account_id_FK FOREIGN KEY (account_id) REFERENCES account (id))
+		CHARACTER SET utf8mb4`)
+
+	return nil
+}
+
+// mysqlAccountRole adds role to accounts. Owners keep owning,
+// while the others become editors since they could edit before.
+func mysqlAccountRole(tx *sqlx.Tx) error {
+	mysqlAddColumn(tx, "account", "role", "VARCHAR(20) NOT NULL DEFAULT 'viewer'")
+	tx.MustExec(`UPDATE account SET role = 'owner' WHERE owner = 1`)
+	tx.MustExec(`UPDATE account SET role = 'editor' WHERE owner = 0`)
+
+	return nil
+}
+
+// mysqlBookmarkOwner adds owner to bookmarks. The existing bookmarks
+// are given to the first owner account, if there is any.
+func mysqlBookmarkOwner(tx *sqlx.Tx) error {
+	mysqlAddColumn(tx, "bookmark", "owner_id", "INT(11) NOT NULL DEFAULT 0")
+	mysqlCreateIndex(tx, "bookmark", "bookmark_owner_id_IDX", "owner_id")
+	tx.MustExec(`UPDATE bookmark, (SELECT MIN(id) id FROM account WHERE role = 'owner') first_owner
+		SET bookmark.owner_id = first_owner.id WHERE first_owner.id IS NOT NULL`)
+
+	return nil
+}
+
+// mysqlShareLinkSchema creates the table of bookmark share links.
+func mysqlShareLinkSchema(tx *sqlx.Tx) error {
+	tx.MustExec(`CREATE TABLE IF NOT EXISTS bookmark_share(
+		id          INT(11)     NOT NULL AUTO_INCREMENT,
+		bookmark_id INT(11)     NOT NULL,
+		token_hash  VARCHAR(64) NOT NULL,
+		created     TIMESTAMP   NOT NULL DEFAULT CURRENT_TIMESTAMP,
+		PRIMARY KEY (id),
+		UNIQUE KEY bookmark_share_hash_UNIQUE (token_hash),
+		KEY bookmark_share_bookmark_id_FK (bookmark_id),
+		CONSTRAINT bookmark_share_bookmark_id_FK FOREIGN KEY (bookmark_id) REFERENCES bookmark (id))
+		CHARACTER SET utf8mb4`)
+
+	return nil
+}
+
+// mysqlBookmarkRead adds read status to bookmarks. The existing
+// bookmarks are unread, since it's unknown whether they've been read.
+func mysqlBookmarkRead(tx *sqlx.Tx) error {
+	mysqlAddColumn(tx, "bookmark", "is_read", "BOOLEAN NOT NULL DEFAULT 0")
+	mysqlAddColumn(tx, "bookmark", "read_at", "TIMESTAMP NULL")
+
+	return nil
+}
+
+// mysqlBookmarkStarred adds starred flag to bookmarks.
+func mysqlBookmarkStarred(tx *sqlx.Tx) error {
+	mysqlAddColumn(tx, "bookmark", "starred", "BOOLEAN NOT NULL DEFAULT 0")
+
+	return nil
+}
+
+// mysqlCollectionSchema creates the table of collections,
+// and lets bookmarks be put in one of them.
+func mysqlCollectionSchema(tx *sqlx.Tx) error {
+	tx.MustExec(`CREATE TABLE IF NOT EXISTS collection(
+		id        INT(11)      NOT NULL AUTO_INCREMENT,
+		name      VARCHAR(250) NOT NULL,
+		parent_id INT(11)      NOT NULL DEFAULT 0,
+		owner_id  INT(11)      NOT NULL DEFAULT 0,
+		PRIMARY KEY (id))
+		CHARACTER SET utf8mb4`)
+	mysqlAddColumn(tx, "bookmark", "collection_id", "INT(11) NOT NULL DEFAULT 0")
+	mysqlCreateIndex(tx, "bookmark", "bookmark_collection_id_IDX", "collection_id")
+
+	return nil
+}
+
+// mysqlTagParent links hierarchical tags to their parent, which is decided
+// by their name, e.g. "dev/go" is the parent of "dev/go/web".
+func mysqlTagParent(tx *sqlx.Tx) error {
+	mysqlAddColumn(tx, "tag", "parent_id", "INT(11) NOT NULL DEFAULT 0")
+	relinkTagParents(tx)
+
+	return nil
+}
+
+// mysqlTagMetadata adds color, description and icon to tags,
+// which are only used by clients to show the tags.
+func mysqlTagMetadata(tx *sqlx.Tx) error {
+	mysqlAddColumn(tx, "tag", "color", "VARCHAR(20) NOT NULL DEFAULT ''")
+	mysqlAddColumn(tx, "tag", "description", "VARCHAR(1000) NOT NULL DEFAULT ''")
+	mysqlAddColumn(tx, "tag", "icon", "VARCHAR(100) NOT NULL DEFAULT ''")
+
+	return nil
+}
+
+// mysqlBookmarkLinkStatus adds the result of checking bookmark URL by the
+// link checker. The existing bookmarks have never been checked.
+func mysqlBookmarkLinkStatus(tx *sqlx.Tx) error {
+	mysqlAddColumn(tx, "bookmark", "link_status", "INT NOT NULL DEFAULT 0")
+	mysqlAddColumn(tx, "bookmark", "link_checked", "TIMESTAMP NULL")
+
+	return nil
+}
+
+// mysqlBookmarkWaybackURL adds the snapshot in Wayback Machine that bookmark
+// is archived from. The existing bookmarks are archived from their page.
+func mysqlBookmarkWaybackURL(tx *sqlx.Tx) error {
+	mysqlAddColumn(tx, "bookmark", "wayback_url", "VARCHAR(500) NOT NULL DEFAULT ''")
+
+	return nil
+}
+
+// mysqlAPITokenScope adds scope of API token. Existing tokens keep full access.
+func mysqlAPITokenScope(tx *sqlx.Tx) error {
+	mysqlAddColumn(tx, "api_token", "scope", "VARCHAR(20) NOT NULL DEFAULT 'full'")
+
+	return nil
+}
+
+// mysqlAccountProvider adds where account is authenticated. Existing accounts
+// are local ones, even those that created for LDAP users, since they can't
+// be told apart anymore.
+func mysqlAccountProvider(tx *sqlx.Tx) error {
+	mysqlAddColumn(tx, "account", "provider", "VARCHAR(50) NOT NULL DEFAULT ''")
+
+	return nil
+}
+
+// mysqlAccountIdentity adds the user of OpenID Connect provider that account
+// is linked to.
+func mysqlAccountIdentity(tx *sqlx.Tx) error {
+	mysqlAddColumn(tx, "account", "issuer", "VARCHAR(250) NOT NULL DEFAULT ''")
+	mysqlAddColumn(tx, "account", "subject", "VARCHAR(250) NOT NULL DEFAULT ''")
+
+	return nil
+}
+
+// mysqlTombstoneOwner adds owner of deleted bookmarks. The existing
+// tombstones are given to the first owner account, like their bookmarks.
+func mysqlTombstoneOwner(tx *sqlx.Tx) error {
+	mysqlAddColumn(tx, "bookmark_tombstone", "owner_id", "INT(11) NOT NULL DEFAULT 0")
+	tx.MustExec(`UPDATE bookmark_tombstone, (SELECT MIN(id) id FROM account WHERE role = 'owner') first_owner
+		SET bookmark_tombstone.owner_id = first_owner.id WHERE first_owner.id IS NOT NULL`)
+
+	return nil
+}
+
+// mysqlBookmarkURLPerOwner makes bookmark URL unique for each owner instead
+// of all accounts, so every account may bookmark the same page.
+func mysqlBookmarkURLPerOwner(tx *sqlx.Tx) error {
+	tx.MustExec(`ALTER TABLE bookmark DROP INDEX bookmark_url_UNIQUE,
+		ADD UNIQUE KEY bookmark_url_UNIQUE (owner_id, url(255))`)
+
+	return nil
+}
+
+// mysqlAddColumn adds column to the table, unless it already exists. MySQL
+// commits schema change implicitly, so a migration that failed halfway might
+// already add some of its columns when it's applied again.
+func mysqlAddColumn(tx *sqlx.Tx, table, column, definition string) {
+	var nColumns int
+	err := tx.Get(&nColumns, `SELECT COUNT(*) FROM information_schema.columns
+		WHERE table_schema = DATABASE() AND table_name = ? AND column_name = ?`, table, column)
+	checkError(err)
+
+	if nColumns == 0 {
+		tx.MustExec(fmt.Sprintf("ALTER TABLE %s ADD COLUMN %s %s", table, column, definition))
+	}
+}
+
+// mysqlCreateIndex creates index on the column of table, unless it already
+// exists, for the same reason as mysqlAddColumn.
+func mysqlCreateIndex(tx *sqlx.Tx, table, index, column string) {
+	var nIndexes int
+	err := tx.Get(&nIndexes, `SELECT COUNT(*) FROM information_schema.statistics
+		WHERE table_schema = DATABASE() AND table_name = ? AND index_name = ?`, table, index)
 	checkError(err)
 
+	if nIndexes == 0 {
+		tx.MustExec(fmt.Sprintf("CREATE INDEX %s ON %s (%s)", index, table, column))
+	}
+}
+
+// OpenMySQLDatabase creates and opens connection to a MySQL Database.
+func OpenMySQLDatabase(connString string) (mysqlDB *MySQLDatabase, err error) {
+	// Open database
+	db := sqlx.MustConnect("mysql", connString)
+	db.SetMaxOpenConns(100)
+	db.SetConnMaxLifetime(time.Second) // in case mysql client has longer timeout (driver issue #674)
+
+	// Apply the migrations that database doesn't have yet
+	if err := runMigrations(db, mysqlMigrations); err != nil {
+		db.Close()
+		return nil, err
+	}
+
 	mysqlDB = &MySQLDatabase{*db}
-	return mysqlDB, err
+	return mysqlDB, nil
 }
 
 // SaveBookmarks saves new or updated bookmarks to database.
 // Returns the saved ID and error message if any happened.
-func (db *MySQLDatabase) SaveBookmarks(bookmarks ...model.Bookmark) (result []model.Bookmark, err error) {
+func (db *MySQLDatabase) SaveBookmarks(bookmarks ...model.Bookmark) ([]model.Bookmark, error) {
+	return db.saveBookmarks(true, bookmarks...)
+}
+
+// SaveBookmarksWithoutContent saves updated bookmarks to database, while their
+// content is kept as it is, so they can be fetched without their content.
+func (db *MySQLDatabase) SaveBookmarksWithoutContent(bookmarks ...model.Bookmark) ([]model.Bookmark, error) {
+	return db.saveBookmarks(false, bookmarks...)
+}
+
+// saveBookmarks saves bookmarks in a single transaction, together with their
+// content unless withContent is false, in which case the bookmarks must
+// already exist in database.
+func (db *MySQLDatabase) saveBookmarks(withContent bool, bookmarks ...model.Bookmark) (result []model.Bookmark, err error) {
 	// Prepare transaction
 	tx, err := db.Beginx()
 	if err != nil {
@@ -111,17 +387,35 @@ func (db *MySQLDatabase) SaveBookmarks(bookmarks ...model.Bookmark) (result []mo
 
 	// Prepare statement
 	stmtInsertBook, err := tx.Preparex(`INSERT INTO bookmark
-		(id, url, title, excerpt, author, public, content, html, modified)
-		VALUES(?, ?, ?, ?, ?, ?, ?, ?, ?)
+		(id, url, title, excerpt, author, public, content, html, content_type, content_hash, metadata, last_status, wayback_url, versioned, created, modified, version, owner_id, collection_id)
+		VALUES(?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
 		ON DUPLICATE KEY UPDATE
-		url      = VALUES(url),
-		title    = VALUES(title),
-		excerpt  = VALUES(excerpt),
-		author   = VALUES(author),
-		public   = VALUES(public),
-		content  = VALUES(content),
-		html     = VALUES(html),
-		modified = VALUES(modified)`)
+		url          = VALUES(url),
+		title        = VALUES(title),
+		excerpt      = VALUES(excerpt),
+		author       = VALUES(author),
+		public       = VALUES(public),
+		content      = VALUES(content),
+		html         = VALUES(html),
+		content_type = VALUES(content_type),
+		content_hash = VALUES(content_hash),
+		metadata     = VALUES(metadata),
+		last_status  = VALUES(last_status),
+		wayback_url  = VALUES(wayback_url),
+		versioned    = VALUES(versioned),
+		modified     = VALUES(modified),
+		version      = VALUES(version)`)
+	checkError(err)
+
+	stmtInsertNewBook, err := tx.Preparex(`INSERT INTO bookmark
+		(url, title, excerpt, author, public, content, html, content_type, content_hash, metadata, last_status, wayback_url, versioned, created, modified, version, owner_id, collection_id)
+		VALUES(?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`)
+	checkError(err)
+
+	stmtUpdateBook, err := tx.Preparex(`UPDATE bookmark SET
+		url = ?, title = ?, excerpt = ?, author = ?, public = ?, content = COALESCE(?, content), html = COALESCE(?, html),
+		content_type = ?, content_hash = ?, metadata = ?, last_status = ?, wayback_url = ?, versioned = ?, modified = ?, version = ?
+		WHERE id = ? AND version = ?`)
 	checkError(err)
 
 	stmtGetTag, err := tx.Preparex(`SELECT id FROM tag WHERE name = ?`)
@@ -138,14 +432,19 @@ func (db *MySQLDatabase) SaveBookmarks(bookmarks ...model.Bookmark) (result []mo
 		WHERE bookmark_id = ? AND tag_id = ?`)
 	checkError(err)
 
+	stmtDeleteTombstone, err := tx.Preparex(`DELETE FROM bookmark_tombstone WHERE id = ?`)
+	checkError(err)
+
 	// Prepare modified time
 	modifiedTime := time.Now().UTC().Format("2006-01-02 15:04:05")
 
 	// Execute statements
 	result = []model.Bookmark{}
 	for _, book := range bookmarks {
-		// Check ID, URL and title
-		if book.ID == 0 {
+		// Check ID, URL and title. New bookmark may be saved without ID,
+		// in which case its ID is assigned by database once it's inserted,
+		// so it never collides with bookmark that saved concurrently.
+		if book.ID == 0 && book.Version > 0 {
 			panic(fmt.Errorf("ID must not be empty"))
 		}
 
@@ -153,17 +452,56 @@ func (db *MySQLDatabase) SaveBookmarks(bookmarks ...model.Bookmark) (result []mo
 			panic(fmt.Errorf("URL must not be empty"))
 		}
 
+		// Make sure title and excerpt are plain text
+		book.Title = normalizeText(book.Title)
+		book.Excerpt = normalizeText(book.Excerpt)
+
 		if book.Title == "" {
 			panic(fmt.Errorf("title must not be empty"))
 		}
 
-		// Set modified time
+		// Set modified time, and created time if it's not specified.
+		// Created time is only saved for new bookmark.
+		book.Created = normalizeTime(book.Created, modifiedTime)
 		book.Modified = modifiedTime
 
+		// Every save is a new version, so concurrent update can be detected.
+		// Bookmark that read from database is only saved if it still has
+		// the version it's read with.
+		book.Version++
+
+		// Content that isn't saved is kept as it is
+		var content, html interface{} = book.Content, book.HTML
+		if !withContent {
+			if book.Version == 1 {
+				panic(fmt.Errorf("new bookmark must be saved with its content"))
+			}
+
+			content, html = nil, nil
+		}
+
 		// Save bookmark
-		stmtInsertBook.MustExec(book.ID,
-			book.URL, book.Title, book.Excerpt, book.Author,
-			book.Public, book.Content, book.HTML, book.Modified)
+		if book.Version > 1 {
+			res := stmtUpdateBook.MustExec(
+				book.URL, book.Title, book.Excerpt, book.Author, book.Public, content, html,
+				book.ContentType, book.ContentHash, book.Metadata, book.LastStatusCode, book.WaybackURL, book.VersionedArchive, book.Modified, book.Version,
+				book.ID, book.Version-1)
+			checkVersionUpdated(res)
+		} else if book.ID == 0 {
+			res := stmtInsertNewBook.MustExec(
+				book.URL, book.Title, book.Excerpt, book.Author,
+				book.Public, book.Content, book.HTML, book.ContentType, book.ContentHash, book.Metadata, book.LastStatusCode, book.WaybackURL, book.VersionedArchive, book.Created, book.Modified, book.Version, book.OwnerID, book.CollectionID)
+			id, err := res.LastInsertId()
+			checkError(err)
+			book.ID = int(id)
+		} else {
+			stmtInsertBook.MustExec(book.ID,
+				book.URL, book.Title, book.Excerpt, book.Author,
+				book.Public, book.Content, book.HTML, book.ContentType, book.ContentHash, book.Metadata, book.LastStatusCode, book.WaybackURL, book.VersionedArchive, book.Created, book.Modified, book.Version, book.OwnerID, book.CollectionID)
+		}
+
+		// The ID might belong to a deleted bookmark, so remove its tombstone
+		stmtDeleteTombstone.MustExec(book.ID)
 
 		// Save book tags
 		newTags := []model.Tag{}
@@ -190,6 +528,11 @@ func (db *MySQLDatabase) SaveBookmarks(bookmarks ...model.Bookmark) (result []mo
 					checkError(err)
 
 					tag.ID = int(tagID64)
+
+					// Nested tag needs its parents as well
+					if strings.Contains(tagName, "/") {
+						linkTagParents(tx, tagName)
+					}
 				}
 
 				stmtInsertBookTag.Exec(tag.ID, book.ID)
@@ -219,7 +562,23 @@ func (db *MySQLDatabase) GetBookmarks(opts GetBookmarksOptions) ([]model.Bookmar
 		`excerpt`,
 		`author`,
 		`public`,
+		`content_type`,
+		`content_hash`,
+		`sort_order`,
+		`metadata`,
+		`last_status`,
+		`versioned`,
+		`created`,
 		`modified`,
+		`version`,
+		`owner_id`,
+		`is_read`,
+		`read_at`,
+		`starred`,
+		`collection_id`,
+		`link_status`,
+		`link_checked`,
+		`wayback_url`,
 		`content <> "" has_content`}
 
 	if opts.WithContent {
@@ -230,82 +589,25 @@ func (db *MySQLDatabase) GetBookmarks(opts GetBookmarksOptions) ([]model.Bookmar
 		FROM bookmark WHERE 1`
 
 	// Add where clause
-	args := []interface{}{}
-
-	// Add where clause for IDs
-	if len(opts.IDs) > 0 {
-		query += ` AND id IN (?)`
-		args = append(args, opts.IDs)
-	}
-
-	// Add where clause for search keyword
-	if opts.Keyword != "" {
-		query += ` AND (
-			url LIKE ? OR
-			MATCH(title, excerpt, content) AGAINST (? IN BOOLEAN MODE)
-		)`
-
-		args = append(args, "%"+opts.Keyword+"%", opts.Keyword)
-	}
-
-	// Add where clause for tags.
-	// First we check for * in excluded and included tags,
-	// which means all tags will be excluded and included, respectively.
-	excludeAllTags := false
-	for _, excludedTag := range opts.ExcludedTags {
-		if excludedTag == "*" {
-			excludeAllTags = true
-			opts.ExcludedTags = []string{}
-			break
-		}
-	}
-
-	includeAllTags := false
-	for _, includedTag := range opts.Tags {
-		if includedTag == "*" {
-			includeAllTags = true
-			opts.Tags = []string{}
-			break
-		}
-	}
-
-	// If all tags excluded, we will only show bookmark without tags.
-	// In other hand, if all tags included, we will only show bookmark with tags.
-	if excludeAllTags {
-		query += ` AND id NOT IN (SELECT DISTINCT bookmark_id FROM bookmark_tag)`
-	} else if includeAllTags {
-		query += ` AND id IN (SELECT DISTINCT bookmark_id FROM bookmark_tag)`
-	}
-
-	// Now we only need to find the normal tags
-	if len(opts.Tags) > 0 {
-		query += ` AND id IN (
-			SELECT bt.bookmark_id
-			FROM bookmark_tag bt
-			LEFT JOIN tag t ON bt.tag_id = t.id
-			WHERE t.name IN(?)
-			GROUP BY bt.bookmark_id
-			HAVING COUNT(bt.bookmark_id) = ?)`
-
-		args = append(args, opts.Tags, len(opts.Tags))
-	}
-
-	if len(opts.ExcludedTags) > 0 {
-		query += ` AND id NOT IN (
-			SELECT DISTINCT bt.bookmark_id
-			FROM bookmark_tag bt
-			LEFT JOIN tag t ON bt.tag_id = t.id
-			WHERE t.name IN(?))`
-
-		args = append(args, opts.ExcludedTags)
-	}
+	whereClause, args := db.createWhereClause(opts)
+	query += whereClause
 
 	// Add order clause
 	switch opts.OrderMethod {
 	case ByLastAdded:
-		query += ` ORDER BY id DESC`
+		query += ` ORDER BY created DESC, id DESC`
 	case ByLastModified:
 		query += ` ORDER BY modified DESC`
+	case ByFirstModified:
+		query += ` ORDER BY modified, id`
+	case ByManualOrder:
+		query += ` ORDER BY sort_order IS NULL, sort_order, created DESC, id DESC`
+	case ByFirstAdded:
+		query += ` ORDER BY created, id`
+	case ByTitle:
+		query += ` ORDER BY title, id`
+	case ByDomain:
+		query += ` ORDER BY SUBSTRING_INDEX(SUBSTRING_INDEX(url, '://', -1), '/', 1), title, id`
 	default:
 		query += ` ORDER BY id`
 	}
@@ -322,8 +624,15 @@ func (db *MySQLDatabase) GetBookmarks(opts GetBookmarksOptions) ([]model.Bookmar
 	}
 
 	// Fetch bookmarks
+	ctx, cancel := queryContext(opts)
+	defer cancel()
+
 	bookmarks := []model.Bookmark{}
-	err = db.Select(&bookmarks, query, args...)
+	err = db.SelectContext(ctx, &bookmarks, query, args...)
+	if ctx.Err() == context.DeadlineExceeded {
+		return nil, ErrRegexTimeout
+	}
+
 	if err != nil && err != sql.ErrNoRows {
 		return nil, fmt.Errorf("failed to fetch data: %v", err)
 	}
@@ -358,6 +667,36 @@ func (db *MySQLDatabase) GetBookmarksCount(opts GetBookmarksOptions) (int, error
 	query := `SELECT COUNT(id) FROM bookmark WHERE 1`
 
 	// Add where clause
+	whereClause, args := db.createWhereClause(opts)
+	query += whereClause
+
+	// Expand query, because some of the args might be an array
+	query, args, err := sqlx.In(query, args...)
+	if err != nil {
+		return 0, fmt.Errorf("failed to expand query: %v", err)
+	}
+
+	// Fetch count
+	ctx, cancel := queryContext(opts)
+	defer cancel()
+
+	var nBookmarks int
+	err = db.GetContext(ctx, &nBookmarks, query, args...)
+	if ctx.Err() == context.DeadlineExceeded {
+		return 0, ErrRegexTimeout
+	}
+
+	if err != nil && err != sql.ErrNoRows {
+		return 0, fmt.Errorf("failed to fetch count: %v", err)
+	}
+
+	return nBookmarks, nil
+}
+
+// createWhereClause creates where clause and its arguments
+// for filtering bookmarks based on submitted options.
+func (db *MySQLDatabase) createWhereClause(opts GetBookmarksOptions) (string, []interface{}) {
+	query := ``
 	args := []interface{}{}
 
 	// Add where clause for IDs
@@ -366,16 +705,146 @@ func (db *MySQLDatabase) GetBookmarksCount(opts GetBookmarksOptions) (int, error
 		args = append(args, opts.IDs)
 	}
 
-	// Add where clause for search keyword
-	if opts.Keyword != "" {
-		query += ` AND (
-			url LIKE ? OR
-			MATCH(title, excerpt, content) AGAINST (? IN BOOLEAN MODE)
-		)`
+	if len(opts.URLs) > 0 {
+		query += ` AND url IN (?)`
+		args = append(args, opts.URLs)
+	}
+
+	// Add where clause for modified time
+	if opts.UpdatedSince != "" {
+		query += ` AND modified >= ?`
+		args = append(args, opts.UpdatedSince)
+	}
+
+	if opts.UpdatedBefore != "" {
+		query += ` AND modified < ?`
+		args = append(args, opts.UpdatedBefore)
+	}
+
+	// Add where clause for created time
+	if opts.CreatedSince != "" {
+		query += ` AND created >= ?`
+		args = append(args, opts.CreatedSince)
+	}
+
+	if opts.CreatedBefore != "" {
+		query += ` AND created < ?`
+		args = append(args, opts.CreatedBefore)
+	}
+
+	// Add where clause for search keyword.
+	// Each term must be found in one of the searched fields.
+	fields := searchedFields(opts)
+	useMatch := fields["title"] && fields["excerpt"] && fields["content"]
+	for _, term := range splitKeyword(opts.Keyword) {
+		conds := []string{}
+		if fields["url"] {
+			conds = append(conds, `url LIKE ?`)
+			args = append(args, "%"+term+"%")
+		}
+
+		// The FULLTEXT index only works when all of its columns are
+		// searched, so subset of them is searched using LIKE instead.
+		if useMatch {
+			matchTerm := term
+			if strings.ContainsAny(term, " \t\n") {
+				matchTerm = `"` + term + `"`
+			}
+
+			conds = append(conds, `MATCH(title, excerpt, content) AGAINST (? IN BOOLEAN MODE)`)
+			args = append(args, matchTerm)
+		} else {
+			for _, field := range []string{"title", "excerpt", "content"} {
+				if fields[field] {
+					conds = append(conds, field+` LIKE ?`)
+					args = append(args, "%"+term+"%")
+				}
+			}
+		}
+
+		query += ` AND (` + strings.Join(conds, " OR ") + `)`
+	}
+
+	// Add where clause for regular expression
+	if opts.Regex != "" {
+		query += ` AND (title RLIKE ? OR excerpt RLIKE ?)`
+		args = append(args, opts.Regex, opts.Regex)
+	}
+
+	// Add where clause for content type
+	if opts.ContentType != "" {
+		query += ` AND content_type LIKE ?`
+		args = append(args, contentTypePattern(opts.ContentType))
+	}
+
+	// Add where clause for versioned archive
+	if opts.Versioned {
+		query += ` AND versioned = 1`
+	}
+
+	// Add where clause for owner
+	if opts.OwnerID != 0 {
+		query += ` AND owner_id = ?`
+		args = append(args, opts.OwnerID)
+	}
+
+	// Add where clause for public bookmarks
+	if opts.PublicOnly {
+		query += ` AND public = 1`
+	}
+
+	// Add where clause for collections
+	if len(opts.CollectionIDs) > 0 {
+		query += ` AND collection_id IN (?)`
+		args = append(args, opts.CollectionIDs)
+	}
+
+	// Add where clause for starred bookmarks
+	if opts.Starred {
+		query += ` AND starred = 1`
+	}
+
+	// Add where clause for bookmarks whose URL is broken
+	if opts.Broken {
+		query += ` AND link_checked IS NOT NULL AND (link_status = 0 OR link_status >= 400)`
+	}
+
+	// Add where clause for read status
+	if opts.Read != nil {
+		query += ` AND is_read = ?`
+		args = append(args, *opts.Read)
+	}
+
+	// Add where clause for bookmarks without tags. Tag link whose
+	// tag no longer exists doesn't count, since it's never shown.
+	if opts.Untagged {
+		query += ` AND NOT EXISTS (
+			SELECT 1 FROM bookmark_tag bt
+			JOIN tag t ON t.id = bt.tag_id
+			WHERE bt.bookmark_id = bookmark.id)`
+	}
+
+	// Add where clause for metadata
+	for _, key := range sortedKeys(opts.Metadata) {
+		query += ` AND metadata LIKE BINARY ? ESCAPE '!'`
+		args = append(args, metadataPattern(key, opts.Metadata[key]))
+	}
+
+	// Add where clause for terms that scoped to title and URL
+	for _, term := range opts.TitleTerms {
+		query += ` AND title LIKE ?`
+		args = append(args, "%"+term+"%")
+	}
+
+	for _, term := range opts.URLTerms {
+		query += ` AND url LIKE ?`
+		args = append(args, "%"+term+"%")
+	}
 
-		args = append(args,
-			"%"+opts.Keyword+"%",
-			opts.Keyword)
+	// Add where clause for excluded URL host
+	for _, excludedURL := range opts.ExcludedURLs {
+		query += ` AND SUBSTRING_INDEX(SUBSTRING_INDEX(url, '://', -1), '/', 1) NOT LIKE ?`
+		args = append(args, "%"+excludedURL+"%")
 	}
 
 	// Add where clause for tags.
@@ -407,51 +876,53 @@ func (db *MySQLDatabase) GetBookmarksCount(opts GetBookmarksOptions) (int, error
 		query += ` AND id IN (SELECT DISTINCT bookmark_id FROM bookmark_tag)`
 	}
 
-	// Now we only need to find the normal tags
-	if len(opts.Tags) > 0 {
+	// Now we only need to find the normal tags. Since tags may be nested,
+	// each tag also matches the tags inside it, e.g. "dev" matches "dev/go".
+	for _, tag := range opts.Tags {
 		query += ` AND id IN (
 			SELECT bt.bookmark_id
 			FROM bookmark_tag bt
-			LEFT JOIN tag t ON bt.tag_id = t.id
-			WHERE t.name IN(?)
-			GROUP BY bt.bookmark_id
-			HAVING COUNT(bt.bookmark_id) = ?)`
+			JOIN tag t ON bt.tag_id = t.id
+			WHERE t.name = ? OR t.name LIKE ? ESCAPE '!')`
 
-		args = append(args, opts.Tags, len(opts.Tags))
+		args = append(args, tag, tagDescendantsPattern(tag))
 	}
 
-	if len(opts.ExcludedTags) > 0 {
+	for _, tag := range opts.ExcludedTags {
 		query += ` AND id NOT IN (
-			SELECT DISTINCT bt.bookmark_id
+			SELECT bt.bookmark_id
 			FROM bookmark_tag bt
-			LEFT JOIN tag t ON bt.tag_id = t.id
-			WHERE t.name IN(?))`
+			JOIN tag t ON bt.tag_id = t.id
+			WHERE t.name = ? OR t.name LIKE ? ESCAPE '!')`
 
-		args = append(args, opts.ExcludedTags)
+		args = append(args, tag, tagDescendantsPattern(tag))
 	}
 
-	// Expand query, because some of the args might be an array
-	query, args, err := sqlx.In(query, args...)
-	if err != nil {
-		return 0, fmt.Errorf("failed to expand query: %v", err)
-	}
+	// Unlike Tags, bookmark only needs any one of AnyTags
+	if len(opts.AnyTags) > 0 {
+		conds := []string{}
+		for _, tag := range opts.AnyTags {
+			conds = append(conds, `t.name = ? OR t.name LIKE ? ESCAPE '!'`)
+			args = append(args, tag, tagDescendantsPattern(tag))
+		}
 
-	// Fetch count
-	var nBookmarks int
-	err = db.Get(&nBookmarks, query, args...)
-	if err != nil && err != sql.ErrNoRows {
-		return 0, fmt.Errorf("failed to fetch count: %v", err)
+		query += ` AND id IN (
+			SELECT bt.bookmark_id
+			FROM bookmark_tag bt
+			JOIN tag t ON bt.tag_id = t.id
+			WHERE ` + strings.Join(conds, " OR ") + `)`
 	}
 
-	return nBookmarks, nil
+	return query, args
 }
 
 // DeleteBookmarks removes all record with matching ids from database.
-func (db *MySQLDatabase) DeleteBookmarks(ids ...int) (err error) {
+// Returns the number of bookmarks that actually deleted.
+func (db *MySQLDatabase) DeleteBookmarks(ids ...int) (nDeleted int, err error) {
 	// Begin transaction
 	tx, err := db.Beginx()
 	if err != nil {
-		return err
+		return 0, err
 	}
 
 	// Make sure to rollback if panic ever happened
@@ -460,6 +931,7 @@ func (db *MySQLDatabase) DeleteBookmarks(ids ...int) (err error) {
 			panicErr, _ := r.(error)
 			tx.Rollback()
 
+			nDeleted = 0
 			err = panicErr
 		}
 	}()
@@ -467,21 +939,39 @@ func (db *MySQLDatabase) DeleteBookmarks(ids ...int) (err error) {
 	// Prepare queries
 	delBookmark := `DELETE FROM bookmark`
 	delBookmarkTag := `DELETE FROM bookmark_tag`
+	delBookmarkShare := `DELETE FROM bookmark_share`
+	insTombstone := `REPLACE INTO bookmark_tombstone (id, url, owner_id, deleted)
+		SELECT id, url, owner_id, ? FROM bookmark`
+
+	// Prepare deleted time
+	deletedTime := time.Now().UTC().Format("2006-01-02 15:04:05")
 
 	// Delete bookmark(s)
 	if len(ids) == 0 {
+		tx.MustExec(insTombstone, deletedTime)
 		tx.MustExec(delBookmarkTag)
-		tx.MustExec(delBookmark)
+		tx.MustExec(delBookmarkShare)
+		res := tx.MustExec(delBookmark)
+		nRows, _ := res.RowsAffected()
+		nDeleted = int(nRows)
 	} else {
 		delBookmark += ` WHERE id = ?`
 		delBookmarkTag += ` WHERE bookmark_id = ?`
+		delBookmarkShare += ` WHERE bookmark_id = ?`
+		insTombstone += ` WHERE id = ?`
 
 		stmtDelBookmark, _ := tx.Preparex(delBookmark)
 		stmtDelBookmarkTag, _ := tx.Preparex(delBookmarkTag)
+		stmtDelBookmarkShare, _ := tx.Preparex(delBookmarkShare)
+		stmtInsTombstone, _ := tx.Preparex(insTombstone)
 
 		for _, id := range ids {
+			stmtInsTombstone.MustExec(deletedTime, id)
 			stmtDelBookmarkTag.MustExec(id)
-			stmtDelBookmark.MustExec(id)
+			stmtDelBookmarkShare.MustExec(id)
+			res := stmtDelBookmark.MustExec(id)
+			nRows, _ := res.RowsAffected()
+			nDeleted += int(nRows)
 		}
 	}
 
@@ -489,7 +979,40 @@ func (db *MySQLDatabase) DeleteBookmarks(ids ...int) (err error) {
 	err = tx.Commit()
 	checkError(err)
 
-	return err
+	return nDeleted, err
+}
+
+// GetTombstones fetch list of bookmarks that deleted since the specified time.
+// The tombstones are ordered from the earliest deletion to the latest.
+func (db *MySQLDatabase) GetTombstones(since string, ownerID int) ([]model.Tombstone, error) {
+	tombstones := []model.Tombstone{}
+	args := []interface{}{since}
+	query := `SELECT id, url, deleted FROM bookmark_tombstone WHERE deleted >= ?`
+
+	if ownerID != 0 {
+		query += ` AND owner_id = ?`
+		args = append(args, ownerID)
+	}
+
+	query += ` ORDER BY deleted, id`
+	err := db.Select(&tombstones, query, args...)
+	if err != nil && err != sql.ErrNoRows {
+		return nil, fmt.Errorf("failed to fetch tombstones: %v", err)
+	}
+
+	return tombstones, nil
+}
+
+// RebuildSearchIndex rebuilds the FULLTEXT index of bookmark table.
+// MySQL keeps the index in sync by itself, so the number of corrected
+// records is always zero.
+func (db *MySQLDatabase) RebuildSearchIndex() (int, error) {
+	_, err := db.Exec(`OPTIMIZE TABLE bookmark`)
+	if err != nil {
+		return 0, fmt.Errorf("failed to rebuild search index: %v", err)
+	}
+
+	return 0, nil
 }
 
 // GetBookmark fetchs bookmark based on its ID or URL.
@@ -498,7 +1021,7 @@ func (db *MySQLDatabase) GetBookmark(id int, url string) (model.Bookmark, bool)
 	args := []interface{}{id}
 	query := `SELECT
 		id, url, title, excerpt, author, public,
-		content, html, modified, content <> '' has_content
+		content, html, content_type, content_hash, sort_order, metadata, last_status, versioned, created, modified, version, owner_id, is_read, read_at, starred, collection_id, link_status, link_checked, wayback_url, content <> '' has_content
 		FROM bookmark WHERE id = ?`
 
 	if url != "" {
@@ -521,12 +1044,14 @@ func (db *MySQLDatabase) SaveAccount(account model.Account) (err error) {
 	}
 
 	// Insert account to database
+	role, owner := accountRole(account)
 	_, err = db.Exec(`INSERT INTO account
-		(username, password, owner) VALUES (?, ?, ?)
+		(username, password, owner, role, provider, issuer, subject) VALUES (?, ?, ?, ?, ?, ?, ?)
 		ON DUPLICATE KEY UPDATE
 		password = VALUES(password),
-		owner = VALUES(owner)`,
-		account.Username, hashedPassword, account.Owner)
+		owner = VALUES(owner),
+		role = VALUES(role)`,
+		account.Username, hashedPassword, owner, role, account.Provider, account.Issuer, account.Subject)
 
 	return err
 }
@@ -535,7 +1060,7 @@ func (db *MySQLDatabase) SaveAccount(account model.Account) (err error) {
 func (db *MySQLDatabase) GetAccounts(opts GetAccountsOptions) ([]model.Account, error) {
 	// Create query
 	args := []interface{}{}
-	query := `SELECT id, username, owner FROM account WHERE 1`
+	query := `SELECT id, username, owner, role, provider FROM account WHERE 1`
 
 	if opts.Keyword != "" {
 		query += " AND username LIKE ?"
@@ -563,12 +1088,33 @@ func (db *MySQLDatabase) GetAccounts(opts GetAccountsOptions) ([]model.Account,
 func (db *MySQLDatabase) GetAccount(username string) (model.Account, bool) {
 	account := model.Account{}
 	db.Get(&account, `SELECT
-		id, username, password, owner FROM account WHERE username = ?`,
+		id, username, password, owner, role, provider, issuer, subject FROM account WHERE username = ?`,
 		username)
 
 	return account, account.ID != 0
 }
 
+// GetAccountByIdentity fetch account that linked to the user of OpenID
+// Connect provider with matching issuer and subject.
+// Returns the account and boolean whether it's exist or not.
+func (db *MySQLDatabase) GetAccountByIdentity(issuer, subject string) (model.Account, bool) {
+	account := model.Account{}
+	db.Get(&account, `SELECT
+		id, username, password, owner, role, provider, issuer, subject FROM account
+		WHERE provider = ? AND issuer = ? AND subject = ?`,
+		model.AccountProviderOIDC, issuer, subject)
+
+	return account, account.ID != 0
+}
+
+// UpdateAccountRole changes the role of account with matching username,
+// while its password is kept.
+func (db *MySQLDatabase) UpdateAccountRole(username string, role string) error {
+	role, owner := accountRole(model.Account{Role: role})
+	_, err := db.Exec(`UPDATE account SET role = ?, owner = ? WHERE username = ?`, role, owner, username)
+	return err
+}
+
 // DeleteAccounts removes all record with matching usernames.
 func (db *MySQLDatabase) DeleteAccounts(usernames ...string) (err error) {
 	// Begin transaction
@@ -587,9 +1133,17 @@ func (db *MySQLDatabase) DeleteAccounts(usernames ...string) (err error) {
 		}
 	}()
 
-	// Delete account
+	// Delete account with its API tokens and sessions
+	stmtDeleteSessions, _ := tx.Preparex(`DELETE account_session FROM account_session
+		JOIN account ON account.id = account_session.account_id
+		WHERE account.username = ?`)
+	stmtDeleteTokens, _ := tx.Preparex(`DELETE api_token FROM api_token
+		JOIN account ON account.id = api_token.account_id
+		WHERE account.username = ?`)
 	stmtDelete, _ := tx.Preparex(`DELETE FROM account WHERE username = ?`)
 	for _, username := range usernames {
+		stmtDeleteSessions.MustExec(username)
+		stmtDeleteTokens.MustExec(username)
 		stmtDelete.MustExec(username)
 	}
 
@@ -600,15 +1154,347 @@ func (db *MySQLDatabase) DeleteAccounts(usernames ...string) (err error) {
 	return err
 }
 
-// GetTags fetch list of tags and their frequency.
-func (db *MySQLDatabase) GetTags() ([]model.Tag, error) {
+// SaveAPIToken saves new API token in database.
+// Returns the token together with its ID.
+func (db *MySQLDatabase) SaveAPIToken(token model.APIToken) (model.APIToken, error) {
+	token.Created = time.Now().UTC().Format("2006-01-02 15:04:05")
+	res, err := db.Exec(`INSERT INTO api_token
+		(account_id, name, token_hash, scope, created) VALUES (?, ?, ?, ?, ?)`,
+		token.AccountID, token.Name, token.Hash, token.Scope, token.Created)
+	if err != nil {
+		return model.APIToken{}, err
+	}
+
+	id, err := res.LastInsertId()
+	if err != nil {
+		return model.APIToken{}, err
+	}
+
+	token.ID = int(id)
+
+	return token, nil
+}
+
+// GetAPITokens fetch list of API tokens (without their hash) owned by the account.
+func (db *MySQLDatabase) GetAPITokens(accountID int) ([]model.APIToken, error) {
+	tokens := []model.APIToken{}
+	err := db.Select(&tokens, `SELECT id, account_id, name, scope, created
+		FROM api_token WHERE account_id = ? ORDER BY id`, accountID)
+	if err != nil && err != sql.ErrNoRows {
+		return nil, fmt.Errorf("failed to fetch API tokens: %v", err)
+	}
+
+	return tokens, nil
+}
+
+// GetAPITokenAccount fetch API token with matching hash together with the account
+// that owns it (without its password). Returns boolean whether it's exist or not.
+func (db *MySQLDatabase) GetAPITokenAccount(hash string) (model.APIToken, model.Account, bool) {
+	row := struct {
+		model.APIToken
+		Username string `db:"username"`
+		Owner    bool   `db:"owner"`
+		Role     string `db:"role"`
+	}{}
+
+	err := db.Get(&row, `SELECT t.id, t.account_id, t.name, t.token_hash, t.scope,
+		t.created, a.username, a.owner, a.role
+		FROM api_token t JOIN account a ON a.id = t.account_id
+		WHERE t.token_hash = ?`, hash)
+	if err != nil {
+		return model.APIToken{}, model.Account{}, false
+	}
+
+	account := model.Account{
+		ID:       row.AccountID,
+		Username: row.Username,
+		Owner:    row.Owner,
+		Role:     row.Role,
+	}
+
+	return row.APIToken, account, true
+}
+
+// DeleteAPIToken revokes API token owned by the account.
+// Returns whether the token actually deleted.
+func (db *MySQLDatabase) DeleteAPIToken(accountID int, id int) (bool, error) {
+	res, err := db.Exec(`DELETE FROM api_token
+		WHERE account_id = ? AND id = ?`, accountID, id)
+	if err != nil {
+		return false, err
+	}
+
+	nDeleted, err := res.RowsAffected()
+	return nDeleted > 0, err
+}
+
+// SaveShareLink saves new share link of bookmark in database.
+// Returns the link together with its ID.
+func (db *MySQLDatabase) SaveShareLink(link model.ShareLink) (model.ShareLink, error) {
+	link.Created = time.Now().UTC().Format("2006-01-02 15:04:05")
+	res, err := db.Exec(`INSERT INTO bookmark_share
+		(bookmark_id, token_hash, created) VALUES (?, ?, ?)`,
+		link.BookmarkID, link.Hash, link.Created)
+	if err != nil {
+		return model.ShareLink{}, err
+	}
+
+	id, err := res.LastInsertId()
+	if err != nil {
+		return model.ShareLink{}, err
+	}
+
+	link.ID = int(id)
+
+	return link, nil
+}
+
+// GetShareLinks fetch list of share links (without their hash) of the bookmark.
+func (db *MySQLDatabase) GetShareLinks(bookmarkID int) ([]model.ShareLink, error) {
+	links := []model.ShareLink{}
+	err := db.Select(&links, `SELECT id, bookmark_id, created
+		FROM bookmark_share WHERE bookmark_id = ? ORDER BY id`, bookmarkID)
+	if err != nil && err != sql.ErrNoRows {
+		return nil, fmt.Errorf("failed to fetch share links: %v", err)
+	}
+
+	return links, nil
+}
+
+// GetSharedBookmark fetch bookmark that shared by the link with matching hash.
+// Returns the bookmark and boolean whether the link is exist or not.
+func (db *MySQLDatabase) GetSharedBookmark(hash string) (model.Bookmark, bool) {
+	var bookmarkID int
+	db.Get(&bookmarkID, `SELECT bookmark_id FROM bookmark_share
+		WHERE token_hash = ?`, hash)
+	if bookmarkID == 0 {
+		return model.Bookmark{}, false
+	}
+
+	return db.GetBookmark(bookmarkID, "")
+}
+
+// DeleteShareLink revokes share link of the bookmark.
+// Returns whether the link actually deleted.
+func (db *MySQLDatabase) DeleteShareLink(bookmarkID int, id int) (bool, error) {
+	res, err := db.Exec(`DELETE FROM bookmark_share
+		WHERE bookmark_id = ? AND id = ?`, bookmarkID, id)
+	if err != nil {
+		return false, err
+	}
+
+	nDeleted, err := res.RowsAffected()
+	return nDeleted > 0, err
+}
+
+// SaveCollection saves new or updated collection in database.
+// Returns the collection together with its ID.
+func (db *MySQLDatabase) SaveCollection(collection model.Collection) (model.Collection, error) {
+	if collection.ID > 0 {
+		_, err := db.Exec(`UPDATE collection SET name = ?, parent_id = ? WHERE id = ?`,
+			collection.Name, collection.ParentID, collection.ID)
+		return collection, err
+	}
+
+	res, err := db.Exec(`INSERT INTO collection
+		(name, parent_id, owner_id) VALUES (?, ?, ?)`,
+		collection.Name, collection.ParentID, collection.OwnerID)
+	if err != nil {
+		return model.Collection{}, err
+	}
+
+	id, err := res.LastInsertId()
+	if err != nil {
+		return model.Collection{}, err
+	}
+
+	collection.ID = int(id)
+
+	return collection, nil
+}
+
+// GetCollections fetch list of collections owned by the account, with the
+// number of bookmarks directly inside each of them. Zero ownerID means any.
+func (db *MySQLDatabase) GetCollections(ownerID int) ([]model.Collection, error) {
+	args := []interface{}{}
+	query := `SELECT c.id, c.name, c.parent_id, c.owner_id, COUNT(b.id) n_bookmarks
+		FROM collection c
+		LEFT JOIN bookmark b ON b.collection_id = c.id`
+
+	if ownerID != 0 {
+		query += ` WHERE c.owner_id = ?`
+		args = append(args, ownerID)
+	}
+
+	query += ` GROUP BY c.id, c.name, c.parent_id, c.owner_id
+		ORDER BY LOWER(c.name), c.id`
+
+	collections := []model.Collection{}
+	err := db.Select(&collections, query, args...)
+	if err != nil && err != sql.ErrNoRows {
+		return nil, fmt.Errorf("failed to fetch collections: %v", err)
+	}
+
+	return collections, nil
+}
+
+// DeleteCollection removes the collection. Its bookmarks and collections
+// are moved to its parent, so nothing inside it is lost.
+func (db *MySQLDatabase) DeleteCollection(id int) (err error) {
+	// Begin transaction
+	tx, err := db.Beginx()
+	if err != nil {
+		return err
+	}
+
+	// Make sure to rollback if panic ever happened
+	defer func() {
+		if r := recover(); r != nil {
+			panicErr, _ := r.(error)
+			tx.Rollback()
+
+			err = panicErr
+		}
+	}()
+
+	var parentID int
+	err = tx.Get(&parentID, `SELECT parent_id FROM collection WHERE id = ?`, id)
+	checkError(err)
+
+	modifiedTime := time.Now().UTC().Format("2006-01-02 15:04:05")
+	tx.MustExec(`UPDATE bookmark SET collection_id = ?, modified = ?, version = version + 1 WHERE collection_id = ?`,
+		parentID, modifiedTime, id)
+	tx.MustExec(`UPDATE collection SET parent_id = ? WHERE parent_id = ?`, parentID, id)
+	tx.MustExec(`DELETE FROM collection WHERE id = ?`, id)
+
+	// Commit transaction
+	err = tx.Commit()
+	checkError(err)
+
+	return err
+}
+
+// SetBookmarksCollection moves bookmarks into the collection, or out of any
+// collection when collectionID is zero. Returns the number of moved bookmarks.
+func (db *MySQLDatabase) SetBookmarksCollection(ids []int, collectionID int) (int, error) {
+	if len(ids) == 0 {
+		return 0, nil
+	}
+
+	modifiedTime := time.Now().UTC().Format("2006-01-02 15:04:05")
+	query, args, err := sqlx.In(`UPDATE bookmark SET collection_id = ?, modified = ?, version = version + 1
+		WHERE collection_id <> ? AND id IN (?)`, collectionID, modifiedTime, collectionID, ids)
+	if err != nil {
+		return 0, fmt.Errorf("failed to expand query: %v", err)
+	}
+
+	res, err := db.Exec(query, args...)
+	if err != nil {
+		return 0, fmt.Errorf("failed to move bookmarks: %v", err)
+	}
+
+	nRows, _ := res.RowsAffected()
+	return int(nRows), nil
+}
+
+// SaveSession saves new login session in database, after removing the
+// account's sessions that already expired. Returns the session with its ID.
+func (db *MySQLDatabase) SaveSession(session model.Session) (model.Session, error) {
+	now := time.Now().UTC().Format("2006-01-02 15:04:05")
+	session.Created = now
+
+	tx, err := db.Beginx()
+	if err != nil {
+		return model.Session{}, err
+	}
+	defer tx.Rollback()
+
+	_, err = tx.Exec(`DELETE FROM account_session
+		WHERE account_id = ? AND expires <= ?`, session.AccountID, now)
+	if err != nil {
+		return model.Session{}, err
+	}
+
+	res, err := tx.Exec(`INSERT INTO account_session
+		(account_id, token_hash, created, expires) VALUES (?, ?, ?, ?)`,
+		session.AccountID, session.Hash, session.Created, session.Expires)
+	if err != nil {
+		return model.Session{}, err
+	}
+
+	id, err := res.LastInsertId()
+	if err != nil {
+		return model.Session{}, err
+	}
+
+	session.ID = int(id)
+
+	return session, tx.Commit()
+}
+
+// GetSessions fetch list of unexpired sessions (without their hash) of the account.
+func (db *MySQLDatabase) GetSessions(accountID int) ([]model.Session, error) {
+	now := time.Now().UTC().Format("2006-01-02 15:04:05")
+	sessions := []model.Session{}
+	err := db.Select(&sessions, `SELECT id, account_id, created, expires
+		FROM account_session WHERE account_id = ? AND expires > ?
+		ORDER BY id`, accountID, now)
+	if err != nil && err != sql.ErrNoRows {
+		return nil, fmt.Errorf("failed to fetch sessions: %v", err)
+	}
+
+	return sessions, nil
+}
+
+// GetSessionAccount fetch unexpired session with matching ID together with its
+// account (without its password). Returns boolean whether it's exist or not.
+func (db *MySQLDatabase) GetSessionAccount(id int) (model.Session, model.Account, bool) {
+	now := time.Now().UTC().Format("2006-01-02 15:04:05")
+	row := struct {
+		model.Session
+		Username string `db:"username"`
+		Owner    bool   `db:"owner"`
+		Role     string `db:"role"`
+	}{}
+
+	err := db.Get(&row, `SELECT s.id, s.account_id, s.token_hash, s.created,
+		s.expires, a.username, a.owner, a.role
+		FROM account_session s JOIN account a ON a.id = s.account_id
+		WHERE s.id = ? AND s.expires > ?`, id, now)
+	if err != nil {
+		return model.Session{}, model.Account{}, false
+	}
+
+	account := model.Account{
+		ID:       row.AccountID,
+		Username: row.Username,
+		Owner:    row.Owner,
+		Role:     row.Role,
+	}
+
+	return row.Session, account, true
+}
+
+// DeleteSession revokes session of the account.
+// Returns whether the session actually deleted.
+func (db *MySQLDatabase) DeleteSession(accountID int, id int) (bool, error) {
+	res, err := db.Exec(`DELETE FROM account_session
+		WHERE account_id = ? AND id = ?`, accountID, id)
+	if err != nil {
+		return false, err
+	}
+
+	nDeleted, err := res.RowsAffected()
+	return nDeleted > 0, err
+}
+
+// GetTags fetch list of tags, their frequency and the last time they are used.
+func (db *MySQLDatabase) GetTags(opts GetTagsOptions) ([]model.Tag, error) {
 	tags := []model.Tag{}
-	query := `SELECT bt.tag_id id, t.name, COUNT(bt.tag_id) n_bookmarks
-		FROM bookmark_tag bt
-		LEFT JOIN tag t ON bt.tag_id = t.id
-		GROUP BY bt.tag_id ORDER BY t.name`
+	query, args := tagsQuery(opts, `t.id, t.name, t.default_public, t.parent_id,
+		t.color, t.description, t.icon`)
 
-	err := db.Select(&tags, query)
+	err := db.Select(&tags, db.Rebind(query), args...)
 	if err != nil && err != sql.ErrNoRows {
 		return nil, fmt.Errorf("failed to fetch tags: %v", err)
 	}
@@ -616,12 +1502,313 @@ func (db *MySQLDatabase) GetTags() ([]model.Tag, error) {
 	return tags, nil
 }
 
+// GetRelatedTags fetch list of tags that used in the same bookmarks as the
+// specified tag. The number of shared bookmarks is put in NBookmarks, and
+// the tags are ordered from the most shared. When ownerID is not zero, only
+// the bookmarks owned by that account are counted.
+func (db *MySQLDatabase) GetRelatedTags(id int, ownerID int, limit int) ([]model.Tag, error) {
+	tags := []model.Tag{}
+	args := []interface{}{id}
+	query := `SELECT t.id, t.name, COUNT(bt2.bookmark_id) n_bookmarks
+		FROM bookmark_tag bt1
+		JOIN bookmark_tag bt2 ON bt2.bookmark_id = bt1.bookmark_id AND bt2.tag_id <> bt1.tag_id
+		JOIN tag t ON t.id = bt2.tag_id
+		JOIN bookmark b ON b.id = bt1.bookmark_id
+		WHERE bt1.tag_id = ?`
+
+	if ownerID != 0 {
+		query += ` AND b.owner_id = ?`
+		args = append(args, ownerID)
+	}
+
+	query += ` GROUP BY t.id, t.name
+		ORDER BY n_bookmarks DESC, t.name
+		LIMIT ?`
+	args = append(args, limit)
+	err := db.Select(&tags, query, args...)
+	if err != nil && err != sql.ErrNoRows {
+		return nil, fmt.Errorf("failed to fetch related tags: %v", err)
+	}
+
+	return tags, nil
+}
+
 // RenameTag change the name of a tag.
-func (db *MySQLDatabase) RenameTag(id int, newName string) error {
-	_, err := db.Exec(`UPDATE tag SET name = ? WHERE id = ?`, newName, id)
+// The bookmarks that use the tag are marked as modified as well.
+func (db *MySQLDatabase) RenameTag(id int, newName string) (err error) {
+	// Begin transaction
+	tx, err := db.Beginx()
+	if err != nil {
+		return err
+	}
+
+	// Make sure to rollback if panic ever happened
+	defer func() {
+		if r := recover(); r != nil {
+			panicErr, _ := r.(error)
+			tx.Rollback()
+
+			err = panicErr
+		}
+	}()
+
+	modifiedTime := time.Now().UTC().Format("2006-01-02 15:04:05")
+	tx.MustExec(`UPDATE tag SET name = ? WHERE id = ?`, newName, id)
+	tx.MustExec(`UPDATE bookmark SET modified = ?, version = version + 1
+		WHERE id IN (SELECT bookmark_id FROM bookmark_tag WHERE tag_id = ?)`,
+		modifiedTime, id)
+
+	// Hierarchy of tags follows their names, so link them again
+	relinkRenamedTags(tx, newName)
+
+	// Commit transaction
+	err = tx.Commit()
+	checkError(err)
+
+	return err
+}
+
+// RenameTags change the name of several tags at once. Tags that end up with
+// the same name, either with each other or with an existing tag, are merged.
+// The bookmarks that use the tags are marked as modified as well.
+func (db *MySQLDatabase) RenameTags(renames map[int]string) (err error) {
+	// Begin transaction
+	tx, err := db.Beginx()
+	if err != nil {
+		return err
+	}
+
+	// Make sure to rollback if panic ever happened
+	defer func() {
+		if r := recover(); r != nil {
+			panicErr, _ := r.(error)
+			tx.Rollback()
+
+			err = panicErr
+		}
+	}()
+
+	ids := make([]int, 0, len(renames))
+	for id := range renames {
+		ids = append(ids, id)
+	}
+	sort.Ints(ids)
+
+	// Move the tags out of the way first, so a tag may take the old name
+	// of other tag, e.g. when "a" is renamed to "b" while "b" is renamed to "c".
+	modifiedTime := time.Now().UTC().Format("2006-01-02 15:04:05")
+	for _, id := range ids {
+		tx.MustExec(`UPDATE tag SET name = ? WHERE id = ?`, renamingTagName(id), id)
+		tx.MustExec(`UPDATE bookmark SET modified = ?, version = version + 1
+			WHERE id IN (SELECT bookmark_id FROM bookmark_tag WHERE tag_id = ?)`,
+			modifiedTime, id)
+	}
+
+	// Rename each tag, or merge it if the new name already used
+	for _, id := range ids {
+		var existingID int
+		err = tx.Get(&existingID, `SELECT id FROM tag WHERE name = ?`, renames[id])
+		if err == sql.ErrNoRows {
+			tx.MustExec(`UPDATE tag SET name = ? WHERE id = ?`, renames[id], id)
+			continue
+		}
+		checkError(err)
+
+		tx.MustExec(`INSERT IGNORE INTO bookmark_tag (bookmark_id, tag_id)
+			SELECT bookmark_id, ? FROM bookmark_tag WHERE tag_id = ?`, existingID, id)
+		tx.MustExec(`DELETE FROM bookmark_tag WHERE tag_id = ?`, id)
+		tx.MustExec(`DELETE FROM tag WHERE id = ?`, id)
+	}
+
+	// Hierarchy of tags follows their names, so link them again
+	newNames := make([]string, 0, len(ids))
+	for _, id := range ids {
+		newNames = append(newNames, renames[id])
+	}
+	relinkRenamedTags(tx, newNames...)
+
+	// Commit transaction
+	err = tx.Commit()
+	checkError(err)
+
+	return err
+}
+
+// DeleteTag removes the tag from database and from every bookmark that uses
+// it, which are marked as modified. The tags nested in it are moved to the top
+// level. Returns the IDs of the bookmarks that no longer have any tag.
+func (db *MySQLDatabase) DeleteTag(id int) (orphanIDs []int, err error) {
+	// Begin transaction
+	tx, err := db.Beginx()
+	if err != nil {
+		return nil, err
+	}
+
+	// Make sure to rollback if panic ever happened
+	defer func() {
+		if r := recover(); r != nil {
+			panicErr, _ := r.(error)
+			tx.Rollback()
+
+			orphanIDs = nil
+			err = panicErr
+		}
+	}()
+
+	// Bookmarks whose only tag is this one become orphans
+	orphanIDs = []int{}
+	err = tx.Select(&orphanIDs, `SELECT bookmark_id FROM bookmark_tag
+		WHERE bookmark_id IN (SELECT bookmark_id FROM bookmark_tag WHERE tag_id = ?)
+		GROUP BY bookmark_id
+		HAVING COUNT(tag_id) = 1
+		ORDER BY bookmark_id`, id)
+	checkError(err)
+
+	modifiedTime := time.Now().UTC().Format("2006-01-02 15:04:05")
+	tx.MustExec(`UPDATE bookmark SET modified = ?, version = version + 1
+		WHERE id IN (SELECT bookmark_id FROM bookmark_tag WHERE tag_id = ?)`,
+		modifiedTime, id)
+	tx.MustExec(`DELETE FROM bookmark_tag WHERE tag_id = ?`, id)
+	tx.MustExec(`UPDATE tag SET parent_id = 0 WHERE parent_id = ?`, id)
+	tx.MustExec(`DELETE FROM tag WHERE id = ?`, id)
+
+	// Commit transaction
+	err = tx.Commit()
+	checkError(err)
+
+	return orphanIDs, err
+}
+
+// SetBookmarksOrder sets the position of bookmarks following their order
+// in ids, starting from 1. The bookmarks are marked as modified as well, and
+// get a new version like any other change.
+func (db *MySQLDatabase) SetBookmarksOrder(ids []int) (err error) {
+	// Begin transaction
+	tx, err := db.Beginx()
+	if err != nil {
+		return err
+	}
+
+	// Make sure to rollback if panic ever happened
+	defer func() {
+		if r := recover(); r != nil {
+			panicErr, _ := r.(error)
+			tx.Rollback()
+
+			err = panicErr
+		}
+	}()
+
+	modifiedTime := time.Now().UTC().Format("2006-01-02 15:04:05")
+	stmt, err := tx.Preparex(`UPDATE bookmark SET sort_order = ?, modified = ?, version = version + 1 WHERE id = ?`)
+	checkError(err)
+
+	for i, id := range ids {
+		stmt.MustExec(i+1, modifiedTime, id)
+	}
+
+	// Commit transaction
+	err = tx.Commit()
+	checkError(err)
+
 	return err
 }
 
+// SetBookmarksRead marks bookmarks as read or unread, and returns the number
+// of bookmarks whose status is changed. Bookmarks that already have the status
+// are left as they are, so read time of bookmarks that read before is kept.
+func (db *MySQLDatabase) SetBookmarksRead(ids []int, read bool) (int, error) {
+	if len(ids) == 0 {
+		return 0, nil
+	}
+
+	modifiedTime := time.Now().UTC().Format("2006-01-02 15:04:05")
+
+	var readAt *string
+	if read {
+		readAt = &modifiedTime
+	}
+
+	query, args, err := sqlx.In(`UPDATE bookmark SET is_read = ?, read_at = ?, modified = ?, version = version + 1
+		WHERE is_read <> ? AND id IN (?)`, read, readAt, modifiedTime, read, ids)
+	if err != nil {
+		return 0, fmt.Errorf("failed to expand query: %v", err)
+	}
+
+	res, err := db.Exec(query, args...)
+	if err != nil {
+		return 0, fmt.Errorf("failed to set read status: %v", err)
+	}
+
+	nRows, _ := res.RowsAffected()
+	return int(nRows), nil
+}
+
+// SetBookmarkStarred stars or unstars the bookmark.
+func (db *MySQLDatabase) SetBookmarkStarred(id int, starred bool) error {
+	modifiedTime := time.Now().UTC().Format("2006-01-02 15:04:05")
+	_, err := db.Exec(`UPDATE bookmark SET starred = ?, modified = ?, version = version + 1 WHERE id = ?`, starred, modifiedTime, id)
+	if err != nil {
+		return fmt.Errorf("failed to set starred bookmark: %v", err)
+	}
+
+	return nil
+}
+
+// SetBookmarkLinkStatus records the HTTP status code that returned when
+// the bookmark URL is checked, or zero when it couldn't be reached. The
+// modified time is kept, since the bookmark itself isn't changed.
+func (db *MySQLDatabase) SetBookmarkLinkStatus(id int, status int) error {
+	checkedTime := time.Now().UTC().Format("2006-01-02 15:04:05")
+	_, err := db.Exec(`UPDATE bookmark SET link_status = ?, link_checked = ? WHERE id = ?`, status, checkedTime, id)
+	if err != nil {
+		return fmt.Errorf("failed to set link status: %v", err)
+	}
+
+	return nil
+}
+
+// SetTagDefaultPublic sets the visibility given to bookmarks when
+// the tag is added to them. Nil removes the default visibility.
+func (db *MySQLDatabase) SetTagDefaultPublic(id int, public *int) error {
+	_, err := db.Exec(`UPDATE tag SET default_public = ? WHERE id = ?`, public, id)
+	if err != nil {
+		return fmt.Errorf("failed to set default visibility of tag: %v", err)
+	}
+
+	return nil
+}
+
+// SetTagMetadata sets the color, description and icon of the tag.
+func (db *MySQLDatabase) SetTagMetadata(tag model.Tag) error {
+	_, err := db.Exec(`UPDATE tag SET color = ?, description = ?, icon = ? WHERE id = ?`,
+		tag.Color, tag.Description, tag.Icon, tag.ID)
+	if err != nil {
+		return fmt.Errorf("failed to set metadata of tag: %v", err)
+	}
+
+	return nil
+}
+
+// GetTagDefaultPublic fetch the default visibility of tags that have it,
+// keyed by the tag name.
+func (db *MySQLDatabase) GetTagDefaultPublic() (map[string]int, error) {
+	tags := []model.Tag{}
+	query := `SELECT id, name, default_public FROM tag WHERE default_public IS NOT NULL`
+
+	err := db.Select(&tags, query)
+	if err != nil && err != sql.ErrNoRows {
+		return nil, fmt.Errorf("failed to fetch default visibility of tags: %v", err)
+	}
+
+	result := map[string]int{}
+	for _, tag := range tags {
+		result[tag.Name] = *tag.DefaultPublic
+	}
+
+	return result, nil
+}
+
 // CreateNewID creates new ID for specified table
 func (db *MySQLDatabase) CreateNewID(table string) (int, error) {
 	var tableID int
@@ -634,3 +1821,13 @@ func (db *MySQLDatabase) CreateNewID(table string) (int, error) {
 
 	return tableID, nil
 }
+
+// SchemaVersion returns the version of database schema.
+func (db *MySQLDatabase) SchemaVersion() (SchemaVersion, error) {
+	return getSchemaVersion(&db.DB, mysqlMigrations)
+}
+
+// Backup is not supported for MySQL, use mysqldump instead.
+func (db *MySQLDatabase) Backup(dstPath string) error {
+	return ErrBackupUnsupported
+}
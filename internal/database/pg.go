@@ -1,8 +1,10 @@
 package database
 
 import (
+	"context"
 	"database/sql"
 	"fmt"
+	"sort"
 	"strings"
 	"time"
 
@@ -17,28 +19,33 @@ type PGDatabase struct {
 	sqlx.DB
 }
 
-// OpenPGDatabase creates and opens connection to a PostgreSQL Database.
-func OpenPGDatabase(connString string) (pgDB *PGDatabase, err error) {
-	// Open database and start transaction
-	db := sqlx.MustConnect("postgres", connString)
-	db.SetMaxOpenConns(100)
-
-	tx, err := db.Beginx()
-	if err != nil {
-		return nil, err
-	}
-
-	// Make sure to rollback if panic ever happened
-	defer func() {
-		if r := recover(); r != nil {
-			panicErr, _ := r.(error)
-			tx.Rollback()
-
-			pgDB = nil
-			err = panicErr
-		}
-	}()
+// pgMigrations is the list of migrations for PostgreSQL database, ordered by
+// their version. Released migration must never be changed, so any change
+// to the schema must be added as a new migration.
+var pgMigrations = []migration{
+	{1, "create initial schema", pgInitialSchema},
+	{2, "create api token table", pgAPITokenSchema},
+	{3, "create session table", pgSessionSchema},
+	{4, "add account role", pgAccountRole},
+	{5, "add bookmark owner", pgBookmarkOwner},
+	{6, "create share link table", pgShareLinkSchema},
+	{7, "add bookmark read status", pgBookmarkRead},
+	{8, "add starred bookmark", pgBookmarkStarred},
+	{9, "create collection table", pgCollectionSchema},
+	{10, "add tag parent", pgTagParent},
+	{11, "add tag metadata", pgTagMetadata},
+	{12, "add bookmark link status", pgBookmarkLinkStatus},
+	{13, "add bookmark wayback url", pgBookmarkWaybackURL},
+	{14, "add api token scope", pgAPITokenScope},
+	{15, "add account provider", pgAccountProvider},
+	{16, "add account identity", pgAccountIdentity},
+	{17, "add tombstone owner", pgTombstoneOwner},
+	{18, "make bookmark url unique per owner", pgBookmarkURLPerOwner},
+}
 
+// pgInitialSchema creates the initial schema. It also upgrades database
+// that created before the migrations were tracked, so it must be idempotent.
+func pgInitialSchema(tx *sqlx.Tx) error {
 	// Create tables
 	tx.MustExec(`CREATE TABLE IF NOT EXISTS account(
 		id       SERIAL,
@@ -49,21 +56,30 @@ func OpenPGDatabase(connString string) (pgDB *PGDatabase, err error) {
 		CONSTRAINT account_username_UNIQUE UNIQUE (username))`)
 
 	tx.MustExec(`CREATE TABLE IF NOT EXISTS bookmark(
-		id       SERIAL,
-		url      TEXT       NOT NULL,
-		title    TEXT       NOT NULL,
-		excerpt  TEXT       NOT NULL DEFAULT '',
-		author   TEXT       NOT NULL DEFAULT '',
-		public   SMALLINT   NOT NULL DEFAULT 0,
-		content  TEXT       NOT NULL DEFAULT '',
-		html     TEXT       NOT NULL DEFAULT '',
-		modified TIMESTAMP(0) NOT NULL DEFAULT CURRENT_TIMESTAMP,
+		id           SERIAL,
+		url          TEXT         NOT NULL,
+		title        TEXT         NOT NULL,
+		excerpt      TEXT         NOT NULL DEFAULT '',
+		author       TEXT         NOT NULL DEFAULT '',
+		public       SMALLINT     NOT NULL DEFAULT 0,
+		content      TEXT         NOT NULL DEFAULT '',
+		html         TEXT         NOT NULL DEFAULT '',
+		content_type TEXT         NOT NULL DEFAULT '',
+		content_hash TEXT         NOT NULL DEFAULT '',
+		sort_order   INT,
+		metadata     TEXT         NOT NULL DEFAULT '{}',
+		last_status  INT          NOT NULL DEFAULT 0,
+		versioned    BOOLEAN      NOT NULL DEFAULT FALSE,
+		created      TIMESTAMP(0) NOT NULL DEFAULT CURRENT_TIMESTAMP,
+		modified     TIMESTAMP(0) NOT NULL DEFAULT CURRENT_TIMESTAMP,
+		version      INT          NOT NULL DEFAULT 1,
 		PRIMARY KEY(id),
 		CONSTRAINT bookmark_url_UNIQUE UNIQUE (url))`)
 
 	tx.MustExec(`CREATE TABLE IF NOT EXISTS tag(
-		id   SERIAL,
-		name VARCHAR(250) NOT NULL,
+		id             SERIAL,
+		name           VARCHAR(250) NOT NULL,
+		default_public SMALLINT,
 		PRIMARY KEY (id),
 		CONSTRAINT tag_name_UNIQUE UNIQUE (name))`)
 
@@ -74,20 +90,242 @@ func OpenPGDatabase(connString string) (pgDB *PGDatabase, err error) {
 		CONSTRAINT bookmark_tag_bookmark_id_FK FOREIGN KEY (bookmark_id) REFERENCES bookmark (id),
 		CONSTRAINT bookmark_tag_tag_id_FK FOREIGN KEY (tag_id) REFERENCES tag (id))`)
 
+	tx.MustExec(`CREATE TABLE IF NOT EXISTS bookmark_tombstone(
+		id      INT          NOT NULL,
+		url     TEXT         NOT NULL,
+		deleted TIMESTAMP(0) NOT NULL DEFAULT CURRENT_TIMESTAMP,
+		PRIMARY KEY(id))`)
+
+	// Alter table if needed. Existing bookmarks don't have
+	// created time, so use their modified time.
+	tx.MustExec(`ALTER TABLE bookmark ADD COLUMN IF NOT EXISTS content_type TEXT NOT NULL DEFAULT ''`)
+	tx.MustExec(`ALTER TABLE bookmark ADD COLUMN IF NOT EXISTS version INT NOT NULL DEFAULT 1`)
+	tx.MustExec(`ALTER TABLE bookmark ADD COLUMN IF NOT EXISTS content_hash TEXT NOT NULL DEFAULT ''`)
+	tx.MustExec(`ALTER TABLE bookmark ADD COLUMN IF NOT EXISTS sort_order INT`)
+	tx.MustExec(`ALTER TABLE bookmark ADD COLUMN IF NOT EXISTS metadata TEXT NOT NULL DEFAULT '{}'`)
+	tx.MustExec(`ALTER TABLE bookmark ADD COLUMN IF NOT EXISTS last_status INT NOT NULL DEFAULT 0`)
+	tx.MustExec(`ALTER TABLE bookmark ADD COLUMN IF NOT EXISTS versioned BOOLEAN NOT NULL DEFAULT FALSE`)
+	tx.MustExec(`ALTER TABLE tag ADD COLUMN IF NOT EXISTS default_public SMALLINT`)
+	tx.MustExec(`ALTER TABLE bookmark ADD COLUMN IF NOT EXISTS created TIMESTAMP(0)`)
+	tx.MustExec(`UPDATE bookmark SET created = modified WHERE created IS NULL`)
+	tx.MustExec(`ALTER TABLE bookmark
+		ALTER COLUMN created SET DEFAULT CURRENT_TIMESTAMP,
+		ALTER COLUMN created SET NOT NULL`)
+
 	// Create indices
 	tx.MustExec(`CREATE INDEX IF NOT EXISTS bookmark_tag_bookmark_id_FK ON bookmark_tag (bookmark_id)`)
 	tx.MustExec(`CREATE INDEX IF NOT EXISTS bookmark_tag_tag_id_FK ON bookmark_tag (tag_id)`)
 
-	err = tx.Commit()
-	checkError(err)
+	return nil
+}
+
+// pgAPITokenSchema creates the table of API tokens.
+func pgAPITokenSchema(tx *sqlx.Tx) error {
+	tx.MustExec(`CREATE TABLE IF NOT EXISTS api_token(
+		id         SERIAL,
+		account_id INT          NOT NULL,
+		name       VARCHAR(250) NOT NULL,
+		token_hash VARCHAR(64)  NOT NULL,
+		created    TIMESTAMP(0) NOT NULL DEFAULT CURRENT_TIMESTAMP,
+		PRIMARY KEY (id),
+		CONSTRAINT api_token_hash_UNIQUE UNIQUE (token_hash),
+		CONSTRAINT api_token_account_id_FK FOREIGN KEY (account_id) REFERENCES account (id))`)
+
+	return nil
+}
+
+// pgSessionSchema creates the table of login sessions.
+func pgSessionSchema(tx *sqlx.Tx) error {
+	tx.MustExec(`CREATE TABLE IF NOT EXISTS account_session(
+		id         SERIAL,
+		account_id INT          NOT NULL,
+		token_hash VARCHAR(64)  NOT NULL,
+		created    TIMESTAMP(0) NOT NULL DEFAULT CURRENT_TIMESTAMP,
+		expires    TIMESTAMP(0) NOT NULL,
+		PRIMARY KEY (id),
+		CONSTRAINT account_session_account_id_FK FOREIGN KEY (account_id) REFERENCES account (id))`)
+
+	return nil
+}
+
+// pgAccountRole adds role to accounts. Owners keep owning,
+// while the others become editors since they could edit before.
+func pgAccountRole(tx *sqlx.Tx) error {
+	tx.MustExec(`ALTER TABLE account ADD COLUMN role VARCHAR(20) NOT NULL DEFAULT 'viewer'`)
+	tx.MustExec(`UPDATE account SET role = 'owner' WHERE owner = TRUE`)
+	tx.MustExec(`UPDATE account SET role = 'editor' WHERE owner = FALSE`)
+
+	return nil
+}
+
+// pgBookmarkOwner adds owner to bookmarks. The existing bookmarks
+// are given to the first owner account, if there is any.
+func pgBookmarkOwner(tx *sqlx.Tx) error {
+	tx.MustExec(`ALTER TABLE bookmark ADD COLUMN owner_id INT NOT NULL DEFAULT 0`)
+	tx.MustExec(`CREATE INDEX bookmark_owner_id_IDX ON bookmark (owner_id)`)
+	tx.MustExec(`UPDATE bookmark SET owner_id = COALESCE(
+		(SELECT MIN(id) FROM account WHERE role = 'owner'), 0)`)
+
+	return nil
+}
+
+// pgShareLinkSchema creates the table of bookmark share links.
+func pgShareLinkSchema(tx *sqlx.Tx) error {
+	tx.MustExec(`CREATE TABLE IF NOT EXISTS bookmark_share(
+		id          SERIAL,
+		bookmark_id INT          NOT NULL,
+		token_hash  VARCHAR(64)  NOT NULL,
+		created     TIMESTAMP(0) NOT NULL DEFAULT CURRENT_TIMESTAMP,
+		PRIMARY KEY (id),
+		CONSTRAINT bookmark_share_hash_UNIQUE UNIQUE (token_hash),
+		CONSTRAINT bookmark_share_bookmark_id_FK FOREIGN KEY (bookmark_id) REFERENCES bookmark (id))`)
+
+	return nil
+}
+
+// pgBookmarkRead adds read status to bookmarks. The existing
+// bookmarks are unread, since it's unknown whether they've been read.
+func pgBookmarkRead(tx *sqlx.Tx) error {
+	tx.MustExec(`ALTER TABLE bookmark ADD COLUMN is_read BOOLEAN NOT NULL DEFAULT FALSE`)
+	tx.MustExec(`ALTER TABLE bookmark ADD COLUMN read_at TIMESTAMP(0)`)
+
+	return nil
+}
+
+// pgBookmarkStarred adds starred flag to bookmarks.
+func pgBookmarkStarred(tx *sqlx.Tx) error {
+	tx.MustExec(`ALTER TABLE bookmark ADD COLUMN starred BOOLEAN NOT NULL DEFAULT FALSE`)
+
+	return nil
+}
+
+// pgCollectionSchema creates the table of collections,
+// and lets bookmarks be put in one of them.
+func pgCollectionSchema(tx *sqlx.Tx) error {
+	tx.MustExec(`CREATE TABLE IF NOT EXISTS collection(
+		id        SERIAL,
+		name      VARCHAR(250) NOT NULL,
+		parent_id INT          NOT NULL DEFAULT 0,
+		owner_id  INT          NOT NULL DEFAULT 0,
+		PRIMARY KEY (id))`)
+	tx.MustExec(`ALTER TABLE bookmark ADD COLUMN collection_id INT NOT NULL DEFAULT 0`)
+	tx.MustExec(`CREATE INDEX bookmark_collection_id_IDX ON bookmark (collection_id)`)
+
+	return nil
+}
+
+// pgTagParent links hierarchical tags to their parent, which is decided
+// by their name, e.g. "dev/go" is the parent of "dev/go/web".
+func pgTagParent(tx *sqlx.Tx) error {
+	tx.MustExec(`ALTER TABLE tag ADD COLUMN parent_id INT NOT NULL DEFAULT 0`)
+	relinkTagParents(tx)
+
+	return nil
+}
+
+// pgTagMetadata adds color, description and icon to tags,
+// which are only used by clients to show the tags.
+func pgTagMetadata(tx *sqlx.Tx) error {
+	tx.MustExec(`ALTER TABLE tag ADD COLUMN color VARCHAR(20) NOT NULL DEFAULT ''`)
+	tx.MustExec(`ALTER TABLE tag ADD COLUMN description TEXT NOT NULL DEFAULT ''`)
+	tx.MustExec(`ALTER TABLE tag ADD COLUMN icon VARCHAR(100) NOT NULL DEFAULT ''`)
+
+	return nil
+}
+
+// pgBookmarkLinkStatus adds the result of checking bookmark URL by the
+// link checker. The existing bookmarks have never been checked.
+func pgBookmarkLinkStatus(tx *sqlx.Tx) error {
+	tx.MustExec(`ALTER TABLE bookmark ADD COLUMN link_status INT NOT NULL DEFAULT 0`)
+	tx.MustExec(`ALTER TABLE bookmark ADD COLUMN link_checked TIMESTAMP(0)`)
+
+	return nil
+}
+
+// pgBookmarkWaybackURL adds the snapshot in Wayback Machine that bookmark
+// is archived from. The existing bookmarks are archived from their page.
+func pgBookmarkWaybackURL(tx *sqlx.Tx) error {
+	tx.MustExec(`ALTER TABLE bookmark ADD COLUMN wayback_url TEXT NOT NULL DEFAULT ''`)
+
+	return nil
+}
+
+// pgAPITokenScope adds scope of API token. Existing tokens keep full access.
+func pgAPITokenScope(tx *sqlx.Tx) error {
+	tx.MustExec(`ALTER TABLE api_token ADD COLUMN scope TEXT NOT NULL DEFAULT 'full'`)
+
+	return nil
+}
+
+// pgAccountProvider adds where account is authenticated. Existing accounts
+// are local ones, even those that created for LDAP users, since they can't
+// be told apart anymore.
+func pgAccountProvider(tx *sqlx.Tx) error {
+	tx.MustExec(`ALTER TABLE account ADD COLUMN provider TEXT NOT NULL DEFAULT ''`)
+
+	return nil
+}
+
+// pgAccountIdentity adds the user of OpenID Connect provider that account
+// is linked to.
+func pgAccountIdentity(tx *sqlx.Tx) error {
+	tx.MustExec(`ALTER TABLE account ADD COLUMN issuer TEXT NOT NULL DEFAULT ''`)
+	tx.MustExec(`ALTER TABLE account ADD COLUMN subject TEXT NOT NULL DEFAULT ''`)
+
+	return nil
+}
+
+// pgTombstoneOwner adds owner of deleted bookmarks. The existing
+// tombstones are given to the first owner account, like their bookmarks.
+func pgTombstoneOwner(tx *sqlx.Tx) error {
+	tx.MustExec(`ALTER TABLE bookmark_tombstone ADD COLUMN owner_id INT NOT NULL DEFAULT 0`)
+	tx.MustExec(`UPDATE bookmark_tombstone SET owner_id = COALESCE(
+		(SELECT MIN(id) FROM account WHERE role = 'owner'), 0)`)
+
+	return nil
+}
+
+// pgBookmarkURLPerOwner makes bookmark URL unique for each owner instead
+// of all accounts, so every account may bookmark the same page.
+func pgBookmarkURLPerOwner(tx *sqlx.Tx) error {
+	tx.MustExec(`ALTER TABLE bookmark DROP CONSTRAINT bookmark_url_UNIQUE,
+		ADD CONSTRAINT bookmark_url_UNIQUE UNIQUE (owner_id, url)`)
+
+	return nil
+}
+
+// OpenPGDatabase creates and opens connection to a PostgreSQL Database.
+func OpenPGDatabase(connString string) (pgDB *PGDatabase, err error) {
+	// Open database
+	db := sqlx.MustConnect("postgres", connString)
+	db.SetMaxOpenConns(100)
+
+	// Apply the migrations that database doesn't have yet
+	if err := runMigrations(db, pgMigrations); err != nil {
+		db.Close()
+		return nil, err
+	}
 
 	pgDB = &PGDatabase{*db}
-	return pgDB, err
+	return pgDB, nil
 }
 
 // SaveBookmarks saves new or updated bookmarks to database.
 // Returns the saved ID and error message if any happened.
-func (db *PGDatabase) SaveBookmarks(bookmarks ...model.Bookmark) (result []model.Bookmark, err error) {
+func (db *PGDatabase) SaveBookmarks(bookmarks ...model.Bookmark) ([]model.Bookmark, error) {
+	return db.saveBookmarks(true, bookmarks...)
+}
+
+// SaveBookmarksWithoutContent saves updated bookmarks to database, while their
+// content is kept as it is, so they can be fetched without their content.
+func (db *PGDatabase) SaveBookmarksWithoutContent(bookmarks ...model.Bookmark) ([]model.Bookmark, error) {
+	return db.saveBookmarks(false, bookmarks...)
+}
+
+// saveBookmarks saves bookmarks in a single transaction, together with their
+// content unless withContent is false, in which case the bookmarks must
+// already exist in database.
+func (db *PGDatabase) saveBookmarks(withContent bool, bookmarks ...model.Bookmark) (result []model.Bookmark, err error) {
 	// Prepare transaction
 	tx, err := db.Beginx()
 	if err != nil {
@@ -107,17 +345,31 @@ func (db *PGDatabase) SaveBookmarks(bookmarks ...model.Bookmark) (result []model
 
 	// Prepare statement
 	stmtInsertBook, err := tx.Preparex(`INSERT INTO bookmark
-		(url, title, excerpt, author, public, content, html, modified)
-		VALUES($1, $2, $3, $4, $5, $6, $7, $8)
-		ON CONFLICT(url) DO UPDATE SET
-		url      = $1,
-		title    = $2,
-		excerpt  = $3,
-		author   = $4,
-		public   = $5,
-		content  = $6,
-		html     = $7,
-		modified = $8`)
+		(url, title, excerpt, author, public, content, html, modified, created, content_type, version, content_hash, metadata, last_status, versioned, owner_id, collection_id, wayback_url)
+		VALUES($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13, $14, $15, $16, $17, $18)
+		ON CONFLICT(owner_id, url) DO UPDATE SET
+		url          = $1,
+		title        = $2,
+		excerpt      = $3,
+		author       = $4,
+		public       = $5,
+		content      = $6,
+		html         = $7,
+		modified     = $8,
+		content_type = $10,
+		version      = $11,
+		content_hash = $12,
+		metadata     = $13,
+		last_status  = $14,
+		versioned    = $15,
+		wayback_url  = $18
+		RETURNING id`)
+	checkError(err)
+
+	stmtUpdateBook, err := tx.Preparex(`UPDATE bookmark SET
+		url = $1, title = $2, excerpt = $3, author = $4, public = $5, content = COALESCE($6, content), html = COALESCE($7, html),
+		content_type = $8, content_hash = $9, metadata = $10, last_status = $11, wayback_url = $12, versioned = $13, modified = $14, version = $15
+		WHERE id = $16 AND version = $17`)
 	checkError(err)
 
 	stmtGetTag, err := tx.Preparex(`SELECT id FROM tag WHERE name = $1`)
@@ -134,14 +386,19 @@ func (db *PGDatabase) SaveBookmarks(bookmarks ...model.Bookmark) (result []model
 		WHERE bookmark_id = $1 AND tag_id = $2`)
 	checkError(err)
 
+	stmtDeleteTombstone, err := tx.Preparex(`DELETE FROM bookmark_tombstone WHERE id = $1`)
+	checkError(err)
+
 	// Prepare modified time
 	modifiedTime := time.Now().UTC().Format("2006-01-02 15:04:05")
 
 	// Execute statements
 	result = []model.Bookmark{}
 	for _, book := range bookmarks {
-		// Check ID, URL and title
-		if book.ID == 0 {
+		// Check ID, URL and title. New bookmark may be saved without ID,
+		// in which case its ID is assigned by database once it's inserted,
+		// so it never collides with bookmark that saved concurrently.
+		if book.ID == 0 && book.Version > 0 {
 			panic(fmt.Errorf("ID must not be empty"))
 		}
 
@@ -149,17 +406,50 @@ func (db *PGDatabase) SaveBookmarks(bookmarks ...model.Bookmark) (result []model
 			panic(fmt.Errorf("URL must not be empty"))
 		}
 
+		// Make sure title and excerpt are plain text
+		book.Title = normalizeText(book.Title)
+		book.Excerpt = normalizeText(book.Excerpt)
+
 		if book.Title == "" {
 			panic(fmt.Errorf("title must not be empty"))
 		}
 
-		// Set modified time
+		// Set modified time, and created time if it's not specified.
+		// Created time is only saved for new bookmark.
+		book.Created = normalizeTime(book.Created, modifiedTime)
 		book.Modified = modifiedTime
 
+		// Every save is a new version, so concurrent update can be detected.
+		// Bookmark that read from database is only saved if it still has
+		// the version it's read with.
+		book.Version++
+
+		// Content that isn't saved is kept as it is
+		var content, html interface{} = book.Content, book.HTML
+		if !withContent {
+			if book.Version == 1 {
+				panic(fmt.Errorf("new bookmark must be saved with its content"))
+			}
+
+			content, html = nil, nil
+		}
+
 		// Save bookmark
-		stmtInsertBook.MustExec(
-			book.URL, book.Title, book.Excerpt, book.Author,
-			book.Public, book.Content, book.HTML, book.Modified)
+		if book.Version > 1 {
+			res := stmtUpdateBook.MustExec(
+				book.URL, book.Title, book.Excerpt, book.Author, book.Public, content, html,
+				book.ContentType, book.ContentHash, book.Metadata, book.LastStatusCode, book.WaybackURL, book.VersionedArchive, book.Modified, book.Version,
+				book.ID, book.Version-1)
+			checkVersionUpdated(res)
+		} else {
+			err = stmtInsertBook.Get(&book.ID,
+				book.URL, book.Title, book.Excerpt, book.Author,
+				book.Public, book.Content, book.HTML, book.Modified, book.Created, book.ContentType, book.Version, book.ContentHash, book.Metadata, book.LastStatusCode, book.VersionedArchive, book.OwnerID, book.CollectionID, book.WaybackURL)
+			checkError(err)
+		}
+
+		// The ID might belong to a deleted bookmark, so remove its tombstone
+		stmtDeleteTombstone.MustExec(book.ID)
 
 		// Save book tags
 		newTags := []model.Tag{}
@@ -186,6 +476,11 @@ func (db *PGDatabase) SaveBookmarks(bookmarks ...model.Bookmark) (result []model
 					checkError(err)
 
 					tag.ID = int(tagID64)
+
+					// Nested tag needs its parents as well
+					if strings.Contains(tagName, "/") {
+						linkTagParents(tx, tagName)
+					}
 				}
 
 				stmtInsertBookTag.Exec(tag.ID, book.ID)
@@ -215,7 +510,23 @@ func (db *PGDatabase) GetBookmarks(opts GetBookmarksOptions) ([]model.Bookmark,
 		`excerpt`,
 		`author`,
 		`public`,
+		`content_type`,
+		`content_hash`,
+		`sort_order`,
+		`metadata`,
+		`last_status`,
+		`versioned`,
+		`created`,
 		`modified`,
+		`version`,
+		`owner_id`,
+		`is_read`,
+		`read_at`,
+		`starred`,
+		`collection_id`,
+		`link_status`,
+		`link_checked`,
+		`wayback_url`,
 		`content <> '' has_content`}
 
 	if opts.WithContent {
@@ -226,84 +537,25 @@ func (db *PGDatabase) GetBookmarks(opts GetBookmarksOptions) ([]model.Bookmark,
 		FROM bookmark WHERE TRUE`
 
 	// Add where clause
-	arg := map[string]interface{}{}
-
-	// Add where clause for IDs
-	if len(opts.IDs) > 0 {
-		query += ` AND id IN (:ids)`
-		arg["ids"] = opts.IDs
-	}
-
-	// Add where clause for search keyword
-	if opts.Keyword != "" {
-		query += ` AND (
-			url LIKE :lkw OR 
-			MATCH(title, excerpt, content) AGAINST (:kw IN BOOLEAN MODE)
-		)`
-
-		arg["lkw"] = "%" + opts.Keyword + "%"
-		arg["kw"] = opts.Keyword
-	}
-
-	// Add where clause for tags.
-	// First we check for * in excluded and included tags,
-	// which means all tags will be excluded and included, respectively.
-	excludeAllTags := false
-	for _, excludedTag := range opts.ExcludedTags {
-		if excludedTag == "*" {
-			excludeAllTags = true
-			opts.ExcludedTags = []string{}
-			break
-		}
-	}
-
-	includeAllTags := false
-	for _, includedTag := range opts.Tags {
-		if includedTag == "*" {
-			includeAllTags = true
-			opts.Tags = []string{}
-			break
-		}
-	}
-
-	// If all tags excluded, we will only show bookmark without tags.
-	// In other hand, if all tags included, we will only show bookmark with tags.
-	if excludeAllTags {
-		query += ` AND id NOT IN (SELECT DISTINCT bookmark_id FROM bookmark_tag)`
-	} else if includeAllTags {
-		query += ` AND id IN (SELECT DISTINCT bookmark_id FROM bookmark_tag)`
-	}
-
-	// Now we only need to find the normal tags
-	if len(opts.Tags) > 0 {
-		query += ` AND id IN (
-			SELECT bt.bookmark_id
-			FROM bookmark_tag bt
-			LEFT JOIN tag t ON bt.tag_id = t.id
-			WHERE t.name IN(:tags)
-			GROUP BY bt.bookmark_id
-			HAVING COUNT(bt.bookmark_id) = :ltags)`
-
-		arg["tags"] = opts.Tags
-		arg["ltags"] = len(opts.Tags)
-	}
-
-	if len(opts.ExcludedTags) > 0 {
-		query += ` AND id NOT IN (
-			SELECT DISTINCT bt.bookmark_id
-			FROM bookmark_tag bt
-			LEFT JOIN tag t ON bt.tag_id = t.id
-			WHERE t.name IN(:extags))`
-
-		arg["extags"] = opts.ExcludedTags
-	}
+	whereClause, arg := db.createWhereClause(opts)
+	query += whereClause
 
 	// Add order clause
 	switch opts.OrderMethod {
 	case ByLastAdded:
-		query += ` ORDER BY id DESC`
+		query += ` ORDER BY created DESC, id DESC`
 	case ByLastModified:
 		query += ` ORDER BY modified DESC`
+	case ByFirstModified:
+		query += ` ORDER BY modified, id`
+	case ByManualOrder:
+		query += ` ORDER BY sort_order IS NULL, sort_order, created DESC, id DESC`
+	case ByFirstAdded:
+		query += ` ORDER BY created, id`
+	case ByTitle:
+		query += ` ORDER BY LOWER(title), id`
+	case ByDomain:
+		query += ` ORDER BY split_part(split_part(url, '://', 2), '/', 1), LOWER(title), id`
 	default:
 		query += ` ORDER BY id`
 	}
@@ -323,8 +575,15 @@ func (db *PGDatabase) GetBookmarks(opts GetBookmarksOptions) ([]model.Bookmark,
 	query = db.Rebind(query)
 
 	// Fetch bookmarks
+	ctx, cancel := queryContext(opts)
+	defer cancel()
+
 	bookmarks := []model.Bookmark{}
-	err = db.Select(&bookmarks, query, args...)
+	err = db.SelectContext(ctx, &bookmarks, query, args...)
+	if ctx.Err() == context.DeadlineExceeded {
+		return nil, ErrRegexTimeout
+	}
+
 	if err != nil && err != sql.ErrNoRows {
 		return nil, fmt.Errorf("failed to fetch data: %v", err)
 	}
@@ -358,6 +617,39 @@ func (db *PGDatabase) GetBookmarksCount(opts GetBookmarksOptions) (int, error) {
 	// Create initial query
 	query := `SELECT COUNT(id) FROM bookmark WHERE TRUE`
 
+	// Add where clause
+	whereClause, arg := db.createWhereClause(opts)
+	query += whereClause
+
+	// Expand query, because some of the args might be an array
+	query, args, err := sqlx.Named(query, arg)
+	query, args, err = sqlx.In(query, args...)
+	if err != nil {
+		return 0, fmt.Errorf("failed to expand query: %v", err)
+	}
+	query = db.Rebind(query)
+
+	// Fetch count
+	ctx, cancel := queryContext(opts)
+	defer cancel()
+
+	var nBookmarks int
+	err = db.GetContext(ctx, &nBookmarks, query, args...)
+	if ctx.Err() == context.DeadlineExceeded {
+		return 0, ErrRegexTimeout
+	}
+
+	if err != nil && err != sql.ErrNoRows {
+		return 0, fmt.Errorf("failed to fetch count: %v", err)
+	}
+
+	return nBookmarks, nil
+}
+
+// createWhereClause creates where clause and its named arguments
+// for filtering bookmarks based on submitted options.
+func (db *PGDatabase) createWhereClause(opts GetBookmarksOptions) (string, map[string]interface{}) {
+	query := ``
 	arg := map[string]interface{}{}
 
 	// Add where clause for IDs
@@ -366,15 +658,133 @@ func (db *PGDatabase) GetBookmarksCount(opts GetBookmarksOptions) (int, error) {
 		arg["ids"] = opts.IDs
 	}
 
-	// Add where clause for search keyword
-	if opts.Keyword != "" {
-		query += ` AND (
-			url LIKE :lurl OR 
-			MATCH(title, excerpt, content) AGAINST (:kw IN BOOLEAN MODE)
-		)`
+	if len(opts.URLs) > 0 {
+		query += ` AND url IN (:urls)`
+		arg["urls"] = opts.URLs
+	}
+
+	// Add where clause for modified time
+	if opts.UpdatedSince != "" {
+		query += ` AND modified >= :updated_since`
+		arg["updated_since"] = opts.UpdatedSince
+	}
+
+	if opts.UpdatedBefore != "" {
+		query += ` AND modified < :updated_before`
+		arg["updated_before"] = opts.UpdatedBefore
+	}
+
+	// Add where clause for created time
+	if opts.CreatedSince != "" {
+		query += ` AND created >= :created_since`
+		arg["created_since"] = opts.CreatedSince
+	}
+
+	if opts.CreatedBefore != "" {
+		query += ` AND created < :created_before`
+		arg["created_before"] = opts.CreatedBefore
+	}
+
+	// Add where clause for search keyword.
+	// Each term must be found in one of the searched fields.
+	fields := searchedFields(opts)
+	for i, term := range splitKeyword(opts.Keyword) {
+		argName := fmt.Sprintf("kw%d", i)
+		conds := []string{}
+		for _, field := range AllSearchFields {
+			if fields[field] {
+				conds = append(conds, field+` ILIKE :`+argName)
+			}
+		}
 
-		arg["lurl"] = "%" + opts.Keyword + "%"
-		arg["kw"] = opts.Keyword
+		query += ` AND (` + strings.Join(conds, " OR ") + `)`
+		arg[argName] = "%" + term + "%"
+	}
+
+	// Add where clause for regular expression
+	if opts.Regex != "" {
+		query += ` AND (title ~* :regex OR excerpt ~* :regex)`
+		arg["regex"] = opts.Regex
+	}
+
+	// Add where clause for content type
+	if opts.ContentType != "" {
+		query += ` AND content_type LIKE :content_type`
+		arg["content_type"] = contentTypePattern(opts.ContentType)
+	}
+
+	// Add where clause for versioned archive
+	if opts.Versioned {
+		query += ` AND versioned = TRUE`
+	}
+
+	// Add where clause for owner
+	if opts.OwnerID != 0 {
+		query += ` AND owner_id = :owner_id`
+		arg["owner_id"] = opts.OwnerID
+	}
+
+	// Add where clause for public bookmarks
+	if opts.PublicOnly {
+		query += ` AND public = 1`
+	}
+
+	// Add where clause for collections
+	if len(opts.CollectionIDs) > 0 {
+		query += ` AND collection_id IN (:collection_ids)`
+		arg["collection_ids"] = opts.CollectionIDs
+	}
+
+	// Add where clause for starred bookmarks
+	if opts.Starred {
+		query += ` AND starred = TRUE`
+	}
+
+	// Add where clause for bookmarks whose URL is broken
+	if opts.Broken {
+		query += ` AND link_checked IS NOT NULL AND (link_status = 0 OR link_status >= 400)`
+	}
+
+	// Add where clause for read status
+	if opts.Read != nil {
+		query += ` AND is_read = :is_read`
+		arg["is_read"] = *opts.Read
+	}
+
+	// Add where clause for bookmarks without tags. Tag link whose
+	// tag no longer exists doesn't count, since it's never shown.
+	if opts.Untagged {
+		query += ` AND NOT EXISTS (
+			SELECT 1 FROM bookmark_tag bt
+			JOIN tag t ON t.id = bt.tag_id
+			WHERE bt.bookmark_id = bookmark.id)`
+	}
+
+	// Add where clause for metadata
+	for i, key := range sortedKeys(opts.Metadata) {
+		argName := fmt.Sprintf("metadata%d", i)
+		query += ` AND metadata LIKE :` + argName + ` ESCAPE '!'`
+		arg[argName] = metadataPattern(key, opts.Metadata[key])
+	}
+
+	// Add where clause for terms that scoped to title and URL
+	for i, term := range opts.TitleTerms {
+		argName := fmt.Sprintf("title%d", i)
+		query += ` AND title ILIKE :` + argName
+		arg[argName] = "%" + term + "%"
+	}
+
+	for i, term := range opts.URLTerms {
+		argName := fmt.Sprintf("url%d", i)
+		query += ` AND url ILIKE :` + argName
+		arg[argName] = "%" + term + "%"
+	}
+
+	// Add where clause for excluded URL host
+	for i, excludedURL := range opts.ExcludedURLs {
+		argName := fmt.Sprintf("exurl%d", i)
+		query += ` AND split_part(split_part(url, '://', 2), '/', 1) NOT ILIKE :` + argName
+		arg[argName] = "%" + excludedURL + "%"
 	}
 
 	// Add where clause for tags.
@@ -406,54 +816,59 @@ func (db *PGDatabase) GetBookmarksCount(opts GetBookmarksOptions) (int, error) {
 		query += ` AND id IN (SELECT DISTINCT bookmark_id FROM bookmark_tag)`
 	}
 
-	// Now we only need to find the normal tags
-	if len(opts.Tags) > 0 {
+	// Now we only need to find the normal tags. Since tags may be nested,
+	// each tag also matches the tags inside it, e.g. "dev" matches "dev/go".
+	for i, tag := range opts.Tags {
+		argName := fmt.Sprintf("tag%d", i)
 		query += ` AND id IN (
 			SELECT bt.bookmark_id
 			FROM bookmark_tag bt
-			LEFT JOIN tag t ON bt.tag_id = t.id
-			WHERE t.name IN(:tags)
-			GROUP BY bt.bookmark_id
-			HAVING COUNT(bt.bookmark_id) = :ltags)`
+			JOIN tag t ON bt.tag_id = t.id
+			WHERE t.name = :` + argName + ` OR t.name LIKE :` + argName + `pattern ESCAPE '!')`
 
-		arg["tags"] = opts.Tags
-		arg["ltags"] = len(opts.Tags)
+		arg[argName] = tag
+		arg[argName+"pattern"] = tagDescendantsPattern(tag)
 	}
 
-	if len(opts.ExcludedTags) > 0 {
+	for i, tag := range opts.ExcludedTags {
+		argName := fmt.Sprintf("extag%d", i)
 		query += ` AND id NOT IN (
-			SELECT DISTINCT bt.bookmark_id
+			SELECT bt.bookmark_id
 			FROM bookmark_tag bt
-			LEFT JOIN tag t ON bt.tag_id = t.id
-			WHERE t.name IN(:etags))`
+			JOIN tag t ON bt.tag_id = t.id
+			WHERE t.name = :` + argName + ` OR t.name LIKE :` + argName + `pattern ESCAPE '!')`
 
-		arg["etags"] = opts.ExcludedTags
+		arg[argName] = tag
+		arg[argName+"pattern"] = tagDescendantsPattern(tag)
 	}
 
-	// Expand query, because some of the args might be an array
-	query, args, err := sqlx.Named(query, arg)
-	query, args, err = sqlx.In(query, args...)
-	if err != nil {
-		return 0, fmt.Errorf("failed to expand query: %v", err)
-	}
-	query = db.Rebind(query)
+	// Unlike Tags, bookmark only needs any one of AnyTags
+	if len(opts.AnyTags) > 0 {
+		conds := []string{}
+		for i, tag := range opts.AnyTags {
+			argName := fmt.Sprintf("anytag%d", i)
+			conds = append(conds, `t.name = :`+argName+` OR t.name LIKE :`+argName+`pattern ESCAPE '!'`)
+			arg[argName] = tag
+			arg[argName+"pattern"] = tagDescendantsPattern(tag)
+		}
 
-	// Fetch count
-	var nBookmarks int
-	err = db.Get(&nBookmarks, query, args...)
-	if err != nil && err != sql.ErrNoRows {
-		return 0, fmt.Errorf("failed to fetch count: %v", err)
+		query += ` AND id IN (
+			SELECT bt.bookmark_id
+			FROM bookmark_tag bt
+			JOIN tag t ON bt.tag_id = t.id
+			WHERE ` + strings.Join(conds, " OR ") + `)`
 	}
 
-	return nBookmarks, nil
+	return query, arg
 }
 
 // DeleteBookmarks removes all record with matching ids from database.
-func (db *PGDatabase) DeleteBookmarks(ids ...int) (err error) {
+// Returns the number of bookmarks that actually deleted.
+func (db *PGDatabase) DeleteBookmarks(ids ...int) (nDeleted int, err error) {
 	// Begin transaction
 	tx, err := db.Beginx()
 	if err != nil {
-		return err
+		return 0, err
 	}
 
 	// Make sure to rollback if panic ever happened
@@ -462,6 +877,7 @@ func (db *PGDatabase) DeleteBookmarks(ids ...int) (err error) {
 			panicErr, _ := r.(error)
 			tx.Rollback()
 
+			nDeleted = 0
 			err = panicErr
 		}
 	}()
@@ -469,21 +885,41 @@ func (db *PGDatabase) DeleteBookmarks(ids ...int) (err error) {
 	// Prepare queries
 	delBookmark := `DELETE FROM bookmark`
 	delBookmarkTag := `DELETE FROM bookmark_tag`
+	delBookmarkShare := `DELETE FROM bookmark_share`
+	insTombstone := `INSERT INTO bookmark_tombstone (id, url, owner_id, deleted)
+		SELECT id, url, owner_id, $1::TIMESTAMP FROM bookmark`
+	onTombstoneConflict := ` ON CONFLICT (id) DO UPDATE SET
+		url = EXCLUDED.url, owner_id = EXCLUDED.owner_id, deleted = EXCLUDED.deleted`
+
+	// Prepare deleted time
+	deletedTime := time.Now().UTC().Format("2006-01-02 15:04:05")
 
 	// Delete bookmark(s)
 	if len(ids) == 0 {
+		tx.MustExec(insTombstone+onTombstoneConflict, deletedTime)
 		tx.MustExec(delBookmarkTag)
-		tx.MustExec(delBookmark)
+		tx.MustExec(delBookmarkShare)
+		res := tx.MustExec(delBookmark)
+		nRows, _ := res.RowsAffected()
+		nDeleted = int(nRows)
 	} else {
 		delBookmark += ` WHERE id = $1`
 		delBookmarkTag += ` WHERE bookmark_id = $1`
+		delBookmarkShare += ` WHERE bookmark_id = $1`
+		insTombstone += ` WHERE id = $2` + onTombstoneConflict
 
 		stmtDelBookmark, _ := tx.Preparex(delBookmark)
 		stmtDelBookmarkTag, _ := tx.Preparex(delBookmarkTag)
+		stmtDelBookmarkShare, _ := tx.Preparex(delBookmarkShare)
+		stmtInsTombstone, _ := tx.Preparex(insTombstone)
 
 		for _, id := range ids {
+			stmtInsTombstone.MustExec(deletedTime, id)
 			stmtDelBookmarkTag.MustExec(id)
-			stmtDelBookmark.MustExec(id)
+			stmtDelBookmarkShare.MustExec(id)
+			res := stmtDelBookmark.MustExec(id)
+			nRows, _ := res.RowsAffected()
+			nDeleted += int(nRows)
 		}
 	}
 
@@ -491,7 +927,34 @@ func (db *PGDatabase) DeleteBookmarks(ids ...int) (err error) {
 	err = tx.Commit()
 	checkError(err)
 
-	return err
+	return nDeleted, err
+}
+
+// GetTombstones fetch list of bookmarks that deleted since the specified time.
+// The tombstones are ordered from the earliest deletion to the latest.
+func (db *PGDatabase) GetTombstones(since string, ownerID int) ([]model.Tombstone, error) {
+	tombstones := []model.Tombstone{}
+	args := []interface{}{since}
+	query := `SELECT id, url, deleted FROM bookmark_tombstone WHERE deleted >= $1`
+
+	if ownerID != 0 {
+		query += ` AND owner_id = $2`
+		args = append(args, ownerID)
+	}
+
+	query += ` ORDER BY deleted, id`
+	err := db.Select(&tombstones, query, args...)
+	if err != nil && err != sql.ErrNoRows {
+		return nil, fmt.Errorf("failed to fetch tombstones: %v", err)
+	}
+
+	return tombstones, nil
+}
+
+// RebuildSearchIndex does nothing since PostgreSQL search directly
+// on bookmark table, so there are no index that could be outdated.
+func (db *PGDatabase) RebuildSearchIndex() (int, error) {
+	return 0, nil
 }
 
 // GetBookmark fetchs bookmark based on its ID or URL.
@@ -500,7 +963,7 @@ func (db *PGDatabase) GetBookmark(id int, url string) (model.Bookmark, bool) {
 	args := []interface{}{id}
 	query := `SELECT
 		id, url, title, excerpt, author, public, 
-		content, html, modified, content <> '' has_content
+		content, html, content_type, content_hash, sort_order, metadata, last_status, versioned, created, modified, version, owner_id, is_read, read_at, starred, collection_id, link_status, link_checked, wayback_url, content <> '' has_content
 		FROM bookmark WHERE id = $1`
 
 	if url != "" {
@@ -523,12 +986,14 @@ func (db *PGDatabase) SaveAccount(account model.Account) (err error) {
 	}
 
 	// Insert account to database
+	role, owner := accountRole(account)
 	_, err = db.Exec(`INSERT INTO account
-		(username, password, owner) VALUES ($1, $2, $3)
+		(username, password, owner, role, provider, issuer, subject) VALUES ($1, $2, $3, $4, $5, $6, $7)
 		ON CONFLICT(username) DO UPDATE SET
 		password = $2,
-		owner = $3`,
-		account.Username, hashedPassword, account.Owner)
+		owner = $3,
+		role = $4`,
+		account.Username, hashedPassword, owner, role, account.Provider, account.Issuer, account.Subject)
 
 	return err
 }
@@ -537,7 +1002,7 @@ func (db *PGDatabase) SaveAccount(account model.Account) (err error) {
 func (db *PGDatabase) GetAccounts(opts GetAccountsOptions) ([]model.Account, error) {
 	// Create query
 	args := []interface{}{}
-	query := `SELECT id, username, owner FROM account WHERE TRUE`
+	query := `SELECT id, username, owner, role, provider FROM account WHERE TRUE`
 
 	if opts.Keyword != "" {
 		query += " AND username LIKE $1"
@@ -565,18 +1030,39 @@ func (db *PGDatabase) GetAccounts(opts GetAccountsOptions) ([]model.Account, err
 func (db *PGDatabase) GetAccount(username string) (model.Account, bool) {
 	account := model.Account{}
 	db.Get(&account, `SELECT 
-		id, username, password, owner FROM account WHERE username = $1`,
+		id, username, password, owner, role, provider, issuer, subject FROM account WHERE username = $1`,
 		username)
 
 	return account, account.ID != 0
 }
 
-// DeleteAccounts removes all record with matching usernames.
-func (db *PGDatabase) DeleteAccounts(usernames ...string) (err error) {
-	// Begin transaction
-	tx, err := db.Beginx()
-	if err != nil {
-		return err
+// GetAccountByIdentity fetch account that linked to the user of OpenID
+// Connect provider with matching issuer and subject.
+// Returns the account and boolean whether it's exist or not.
+func (db *PGDatabase) GetAccountByIdentity(issuer, subject string) (model.Account, bool) {
+	account := model.Account{}
+	db.Get(&account, `SELECT
+		id, username, password, owner, role, provider, issuer, subject FROM account
+		WHERE provider = $1 AND issuer = $2 AND subject = $3`,
+		model.AccountProviderOIDC, issuer, subject)
+
+	return account, account.ID != 0
+}
+
+// UpdateAccountRole changes the role of account with matching username,
+// while its password is kept.
+func (db *PGDatabase) UpdateAccountRole(username string, role string) error {
+	role, owner := accountRole(model.Account{Role: role})
+	_, err := db.Exec(`UPDATE account SET role = $1, owner = $2 WHERE username = $3`, role, owner, username)
+	return err
+}
+
+// DeleteAccounts removes all record with matching usernames.
+func (db *PGDatabase) DeleteAccounts(usernames ...string) (err error) {
+	// Begin transaction
+	tx, err := db.Beginx()
+	if err != nil {
+		return err
 	}
 
 	// Make sure to rollback if panic ever happened
@@ -589,9 +1075,15 @@ func (db *PGDatabase) DeleteAccounts(usernames ...string) (err error) {
 		}
 	}()
 
-	// Delete account
+	// Delete account with its API tokens and sessions
+	stmtDeleteSessions, _ := tx.Preparex(`DELETE FROM account_session
+		WHERE account_id IN (SELECT id FROM account WHERE username = $1)`)
+	stmtDeleteTokens, _ := tx.Preparex(`DELETE FROM api_token
+		WHERE account_id IN (SELECT id FROM account WHERE username = $1)`)
 	stmtDelete, _ := tx.Preparex(`DELETE FROM account WHERE username = $1`)
 	for _, username := range usernames {
+		stmtDeleteSessions.MustExec(username)
+		stmtDeleteTokens.MustExec(username)
 		stmtDelete.MustExec(username)
 	}
 
@@ -602,15 +1094,324 @@ func (db *PGDatabase) DeleteAccounts(usernames ...string) (err error) {
 	return err
 }
 
-// GetTags fetch list of tags and their frequency.
-func (db *PGDatabase) GetTags() ([]model.Tag, error) {
+// SaveAPIToken saves new API token in database.
+// Returns the token together with its ID.
+func (db *PGDatabase) SaveAPIToken(token model.APIToken) (model.APIToken, error) {
+	token.Created = time.Now().UTC().Format("2006-01-02 15:04:05")
+	err := db.Get(&token.ID, `INSERT INTO api_token
+		(account_id, name, token_hash, scope, created) VALUES ($1, $2, $3, $4, $5)
+		RETURNING id`,
+		token.AccountID, token.Name, token.Hash, token.Scope, token.Created)
+	if err != nil {
+		return model.APIToken{}, err
+	}
+
+	return token, nil
+}
+
+// GetAPITokens fetch list of API tokens (without their hash) owned by the account.
+func (db *PGDatabase) GetAPITokens(accountID int) ([]model.APIToken, error) {
+	tokens := []model.APIToken{}
+	err := db.Select(&tokens, `SELECT id, account_id, name, scope, created
+		FROM api_token WHERE account_id = $1 ORDER BY id`, accountID)
+	if err != nil && err != sql.ErrNoRows {
+		return nil, fmt.Errorf("failed to fetch API tokens: %v", err)
+	}
+
+	return tokens, nil
+}
+
+// GetAPITokenAccount fetch API token with matching hash together with the account
+// that owns it (without its password). Returns boolean whether it's exist or not.
+func (db *PGDatabase) GetAPITokenAccount(hash string) (model.APIToken, model.Account, bool) {
+	row := struct {
+		model.APIToken
+		Username string `db:"username"`
+		Owner    bool   `db:"owner"`
+		Role     string `db:"role"`
+	}{}
+
+	err := db.Get(&row, `SELECT t.id, t.account_id, t.name, t.token_hash, t.scope,
+		t.created, a.username, a.owner, a.role
+		FROM api_token t JOIN account a ON a.id = t.account_id
+		WHERE t.token_hash = $1`, hash)
+	if err != nil {
+		return model.APIToken{}, model.Account{}, false
+	}
+
+	account := model.Account{
+		ID:       row.AccountID,
+		Username: row.Username,
+		Owner:    row.Owner,
+		Role:     row.Role,
+	}
+
+	return row.APIToken, account, true
+}
+
+// DeleteAPIToken revokes API token owned by the account.
+// Returns whether the token actually deleted.
+func (db *PGDatabase) DeleteAPIToken(accountID int, id int) (bool, error) {
+	res, err := db.Exec(`DELETE FROM api_token
+		WHERE account_id = $1 AND id = $2`, accountID, id)
+	if err != nil {
+		return false, err
+	}
+
+	nDeleted, err := res.RowsAffected()
+	return nDeleted > 0, err
+}
+
+// SaveShareLink saves new share link of bookmark in database.
+// Returns the link together with its ID.
+func (db *PGDatabase) SaveShareLink(link model.ShareLink) (model.ShareLink, error) {
+	link.Created = time.Now().UTC().Format("2006-01-02 15:04:05")
+	err := db.Get(&link.ID, `INSERT INTO bookmark_share
+		(bookmark_id, token_hash, created) VALUES ($1, $2, $3)
+		RETURNING id`,
+		link.BookmarkID, link.Hash, link.Created)
+	if err != nil {
+		return model.ShareLink{}, err
+	}
+
+	return link, nil
+}
+
+// GetShareLinks fetch list of share links (without their hash) of the bookmark.
+func (db *PGDatabase) GetShareLinks(bookmarkID int) ([]model.ShareLink, error) {
+	links := []model.ShareLink{}
+	err := db.Select(&links, `SELECT id, bookmark_id, created
+		FROM bookmark_share WHERE bookmark_id = $1 ORDER BY id`, bookmarkID)
+	if err != nil && err != sql.ErrNoRows {
+		return nil, fmt.Errorf("failed to fetch share links: %v", err)
+	}
+
+	return links, nil
+}
+
+// GetSharedBookmark fetch bookmark that shared by the link with matching hash.
+// Returns the bookmark and boolean whether the link is exist or not.
+func (db *PGDatabase) GetSharedBookmark(hash string) (model.Bookmark, bool) {
+	var bookmarkID int
+	db.Get(&bookmarkID, `SELECT bookmark_id FROM bookmark_share
+		WHERE token_hash = $1`, hash)
+	if bookmarkID == 0 {
+		return model.Bookmark{}, false
+	}
+
+	return db.GetBookmark(bookmarkID, "")
+}
+
+// DeleteShareLink revokes share link of the bookmark.
+// Returns whether the link actually deleted.
+func (db *PGDatabase) DeleteShareLink(bookmarkID int, id int) (bool, error) {
+	res, err := db.Exec(`DELETE FROM bookmark_share
+		WHERE bookmark_id = $1 AND id = $2`, bookmarkID, id)
+	if err != nil {
+		return false, err
+	}
+
+	nDeleted, err := res.RowsAffected()
+	return nDeleted > 0, err
+}
+
+// SaveCollection saves new or updated collection in database.
+// Returns the collection together with its ID.
+func (db *PGDatabase) SaveCollection(collection model.Collection) (model.Collection, error) {
+	if collection.ID > 0 {
+		_, err := db.Exec(`UPDATE collection SET name = $1, parent_id = $2 WHERE id = $3`,
+			collection.Name, collection.ParentID, collection.ID)
+		return collection, err
+	}
+
+	err := db.Get(&collection.ID, `INSERT INTO collection
+		(name, parent_id, owner_id) VALUES ($1, $2, $3)
+		RETURNING id`,
+		collection.Name, collection.ParentID, collection.OwnerID)
+	if err != nil {
+		return model.Collection{}, err
+	}
+
+	return collection, nil
+}
+
+// GetCollections fetch list of collections owned by the account, with the
+// number of bookmarks directly inside each of them. Zero ownerID means any.
+func (db *PGDatabase) GetCollections(ownerID int) ([]model.Collection, error) {
+	args := []interface{}{}
+	query := `SELECT c.id, c.name, c.parent_id, c.owner_id, COUNT(b.id) n_bookmarks
+		FROM collection c
+		LEFT JOIN bookmark b ON b.collection_id = c.id`
+
+	if ownerID != 0 {
+		query += ` WHERE c.owner_id = $1`
+		args = append(args, ownerID)
+	}
+
+	query += ` GROUP BY c.id, c.name, c.parent_id, c.owner_id
+		ORDER BY LOWER(c.name), c.id`
+
+	collections := []model.Collection{}
+	err := db.Select(&collections, query, args...)
+	if err != nil && err != sql.ErrNoRows {
+		return nil, fmt.Errorf("failed to fetch collections: %v", err)
+	}
+
+	return collections, nil
+}
+
+// DeleteCollection removes the collection. Its bookmarks and collections
+// are moved to its parent, so nothing inside it is lost.
+func (db *PGDatabase) DeleteCollection(id int) (err error) {
+	// Begin transaction
+	tx, err := db.Beginx()
+	if err != nil {
+		return err
+	}
+
+	// Make sure to rollback if panic ever happened
+	defer func() {
+		if r := recover(); r != nil {
+			panicErr, _ := r.(error)
+			tx.Rollback()
+
+			err = panicErr
+		}
+	}()
+
+	var parentID int
+	err = tx.Get(&parentID, `SELECT parent_id FROM collection WHERE id = $1`, id)
+	checkError(err)
+
+	modifiedTime := time.Now().UTC().Format("2006-01-02 15:04:05")
+	tx.MustExec(`UPDATE bookmark SET collection_id = $1, modified = $2, version = version + 1 WHERE collection_id = $3`,
+		parentID, modifiedTime, id)
+	tx.MustExec(`UPDATE collection SET parent_id = $1 WHERE parent_id = $2`, parentID, id)
+	tx.MustExec(`DELETE FROM collection WHERE id = $1`, id)
+
+	// Commit transaction
+	err = tx.Commit()
+	checkError(err)
+
+	return err
+}
+
+// SetBookmarksCollection moves bookmarks into the collection, or out of any
+// collection when collectionID is zero. Returns the number of moved bookmarks.
+func (db *PGDatabase) SetBookmarksCollection(ids []int, collectionID int) (int, error) {
+	if len(ids) == 0 {
+		return 0, nil
+	}
+
+	modifiedTime := time.Now().UTC().Format("2006-01-02 15:04:05")
+	query, args, err := sqlx.In(`UPDATE bookmark SET collection_id = ?, modified = ?, version = version + 1
+		WHERE collection_id <> ? AND id IN (?)`, collectionID, modifiedTime, collectionID, ids)
+	if err != nil {
+		return 0, fmt.Errorf("failed to expand query: %v", err)
+	}
+	query = db.Rebind(query)
+
+	res, err := db.Exec(query, args...)
+	if err != nil {
+		return 0, fmt.Errorf("failed to move bookmarks: %v", err)
+	}
+
+	nRows, _ := res.RowsAffected()
+	return int(nRows), nil
+}
+
+// SaveSession saves new login session in database, after removing the
+// account's sessions that already expired. Returns the session with its ID.
+func (db *PGDatabase) SaveSession(session model.Session) (model.Session, error) {
+	now := time.Now().UTC().Format("2006-01-02 15:04:05")
+	session.Created = now
+
+	tx, err := db.Beginx()
+	if err != nil {
+		return model.Session{}, err
+	}
+	defer tx.Rollback()
+
+	_, err = tx.Exec(`DELETE FROM account_session
+		WHERE account_id = $1 AND expires <= $2`, session.AccountID, now)
+	if err != nil {
+		return model.Session{}, err
+	}
+
+	err = tx.Get(&session.ID, `INSERT INTO account_session
+		(account_id, token_hash, created, expires) VALUES ($1, $2, $3, $4)
+		RETURNING id`,
+		session.AccountID, session.Hash, session.Created, session.Expires)
+	if err != nil {
+		return model.Session{}, err
+	}
+
+	return session, tx.Commit()
+}
+
+// GetSessions fetch list of unexpired sessions (without their hash) of the account.
+func (db *PGDatabase) GetSessions(accountID int) ([]model.Session, error) {
+	now := time.Now().UTC().Format("2006-01-02 15:04:05")
+	sessions := []model.Session{}
+	err := db.Select(&sessions, `SELECT id, account_id, created, expires
+		FROM account_session WHERE account_id = $1 AND expires > $2
+		ORDER BY id`, accountID, now)
+	if err != nil && err != sql.ErrNoRows {
+		return nil, fmt.Errorf("failed to fetch sessions: %v", err)
+	}
+
+	return sessions, nil
+}
+
+// GetSessionAccount fetch unexpired session with matching ID together with its
+// account (without its password). Returns boolean whether it's exist or not.
+func (db *PGDatabase) GetSessionAccount(id int) (model.Session, model.Account, bool) {
+	now := time.Now().UTC().Format("2006-01-02 15:04:05")
+	row := struct {
+		model.Session
+		Username string `db:"username"`
+		Owner    bool   `db:"owner"`
+		Role     string `db:"role"`
+	}{}
+
+	err := db.Get(&row, `SELECT s.id, s.account_id, s.token_hash, s.created,
+		s.expires, a.username, a.owner, a.role
+		FROM account_session s JOIN account a ON a.id = s.account_id
+		WHERE s.id = $1 AND s.expires > $2`, id, now)
+	if err != nil {
+		return model.Session{}, model.Account{}, false
+	}
+
+	account := model.Account{
+		ID:       row.AccountID,
+		Username: row.Username,
+		Owner:    row.Owner,
+		Role:     row.Role,
+	}
+
+	return row.Session, account, true
+}
+
+// DeleteSession revokes session of the account.
+// Returns whether the session actually deleted.
+func (db *PGDatabase) DeleteSession(accountID int, id int) (bool, error) {
+	res, err := db.Exec(`DELETE FROM account_session
+		WHERE account_id = $1 AND id = $2`, accountID, id)
+	if err != nil {
+		return false, err
+	}
+
+	nDeleted, err := res.RowsAffected()
+	return nDeleted > 0, err
+}
+
+// GetTags fetch list of tags, their frequency and the last time they are used.
+func (db *PGDatabase) GetTags(opts GetTagsOptions) ([]model.Tag, error) {
 	tags := []model.Tag{}
-	query := `SELECT bt.tag_id id, t.name, COUNT(bt.tag_id) n_bookmarks 
-		FROM bookmark_tag bt 
-		LEFT JOIN tag t ON bt.tag_id = t.id
-		GROUP BY bt.tag_id, t.name ORDER BY t.name`
+	query, args := tagsQuery(opts, `t.id, t.name, t.default_public, t.parent_id,
+		t.color, t.description, t.icon`)
 
-	err := db.Select(&tags, query)
+	err := db.Select(&tags, db.Rebind(query), args...)
 	if err != nil && err != sql.ErrNoRows {
 		return nil, fmt.Errorf("failed to fetch tags: %v", err)
 	}
@@ -618,12 +1419,315 @@ func (db *PGDatabase) GetTags() ([]model.Tag, error) {
 	return tags, nil
 }
 
+// GetRelatedTags fetch list of tags that used in the same bookmarks as the
+// specified tag. The number of shared bookmarks is put in NBookmarks, and
+// the tags are ordered from the most shared. When ownerID is not zero, only
+// the bookmarks owned by that account are counted.
+func (db *PGDatabase) GetRelatedTags(id int, ownerID int, limit int) ([]model.Tag, error) {
+	tags := []model.Tag{}
+	args := []interface{}{id}
+	query := `SELECT t.id, t.name, COUNT(bt2.bookmark_id) n_bookmarks
+		FROM bookmark_tag bt1
+		JOIN bookmark_tag bt2 ON bt2.bookmark_id = bt1.bookmark_id AND bt2.tag_id <> bt1.tag_id
+		JOIN tag t ON t.id = bt2.tag_id
+		JOIN bookmark b ON b.id = bt1.bookmark_id
+		WHERE bt1.tag_id = ?`
+
+	if ownerID != 0 {
+		query += ` AND b.owner_id = ?`
+		args = append(args, ownerID)
+	}
+
+	query += ` GROUP BY t.id, t.name
+		ORDER BY n_bookmarks DESC, t.name
+		LIMIT ?`
+	args = append(args, limit)
+	err := db.Select(&tags, db.Rebind(query), args...)
+	if err != nil && err != sql.ErrNoRows {
+		return nil, fmt.Errorf("failed to fetch related tags: %v", err)
+	}
+
+	return tags, nil
+}
+
 // RenameTag change the name of a tag.
-func (db *PGDatabase) RenameTag(id int, newName string) error {
-	_, err := db.Exec(`UPDATE tag SET name = $1 WHERE id = $2`, newName, id)
+// The bookmarks that use the tag are marked as modified as well.
+func (db *PGDatabase) RenameTag(id int, newName string) (err error) {
+	// Begin transaction
+	tx, err := db.Beginx()
+	if err != nil {
+		return err
+	}
+
+	// Make sure to rollback if panic ever happened
+	defer func() {
+		if r := recover(); r != nil {
+			panicErr, _ := r.(error)
+			tx.Rollback()
+
+			err = panicErr
+		}
+	}()
+
+	modifiedTime := time.Now().UTC().Format("2006-01-02 15:04:05")
+	tx.MustExec(`UPDATE tag SET name = $1 WHERE id = $2`, newName, id)
+	tx.MustExec(`UPDATE bookmark SET modified = $1, version = version + 1
+		WHERE id IN (SELECT bookmark_id FROM bookmark_tag WHERE tag_id = $2)`,
+		modifiedTime, id)
+
+	// Hierarchy of tags follows their names, so link them again
+	relinkRenamedTags(tx, newName)
+
+	// Commit transaction
+	err = tx.Commit()
+	checkError(err)
+
+	return err
+}
+
+// RenameTags change the name of several tags at once. Tags that end up with
+// the same name, either with each other or with an existing tag, are merged.
+// The bookmarks that use the tags are marked as modified as well.
+func (db *PGDatabase) RenameTags(renames map[int]string) (err error) {
+	// Begin transaction
+	tx, err := db.Beginx()
+	if err != nil {
+		return err
+	}
+
+	// Make sure to rollback if panic ever happened
+	defer func() {
+		if r := recover(); r != nil {
+			panicErr, _ := r.(error)
+			tx.Rollback()
+
+			err = panicErr
+		}
+	}()
+
+	ids := make([]int, 0, len(renames))
+	for id := range renames {
+		ids = append(ids, id)
+	}
+	sort.Ints(ids)
+
+	// Move the tags out of the way first, so a tag may take the old name
+	// of other tag, e.g. when "a" is renamed to "b" while "b" is renamed to "c".
+	modifiedTime := time.Now().UTC().Format("2006-01-02 15:04:05")
+	for _, id := range ids {
+		tx.MustExec(`UPDATE tag SET name = $1 WHERE id = $2`, renamingTagName(id), id)
+		tx.MustExec(`UPDATE bookmark SET modified = $1, version = version + 1
+			WHERE id IN (SELECT bookmark_id FROM bookmark_tag WHERE tag_id = $2)`,
+			modifiedTime, id)
+	}
+
+	// Rename each tag, or merge it if the new name already used
+	for _, id := range ids {
+		var existingID int
+		err = tx.Get(&existingID, `SELECT id FROM tag WHERE name = $1`, renames[id])
+		if err == sql.ErrNoRows {
+			tx.MustExec(`UPDATE tag SET name = $1 WHERE id = $2`, renames[id], id)
+			continue
+		}
+		checkError(err)
+
+		tx.MustExec(`INSERT INTO bookmark_tag (bookmark_id, tag_id)
+			SELECT bookmark_id, $1 FROM bookmark_tag WHERE tag_id = $2
+			ON CONFLICT DO NOTHING`, existingID, id)
+		tx.MustExec(`DELETE FROM bookmark_tag WHERE tag_id = $1`, id)
+		tx.MustExec(`DELETE FROM tag WHERE id = $1`, id)
+	}
+
+	// Hierarchy of tags follows their names, so link them again
+	newNames := make([]string, 0, len(ids))
+	for _, id := range ids {
+		newNames = append(newNames, renames[id])
+	}
+	relinkRenamedTags(tx, newNames...)
+
+	// Commit transaction
+	err = tx.Commit()
+	checkError(err)
+
+	return err
+}
+
+// DeleteTag removes the tag from database and from every bookmark that uses
+// it, which are marked as modified. The tags nested in it are moved to the top
+// level. Returns the IDs of the bookmarks that no longer have any tag.
+func (db *PGDatabase) DeleteTag(id int) (orphanIDs []int, err error) {
+	// Begin transaction
+	tx, err := db.Beginx()
+	if err != nil {
+		return nil, err
+	}
+
+	// Make sure to rollback if panic ever happened
+	defer func() {
+		if r := recover(); r != nil {
+			panicErr, _ := r.(error)
+			tx.Rollback()
+
+			orphanIDs = nil
+			err = panicErr
+		}
+	}()
+
+	// Bookmarks whose only tag is this one become orphans
+	orphanIDs = []int{}
+	err = tx.Select(&orphanIDs, `SELECT bookmark_id FROM bookmark_tag
+		WHERE bookmark_id IN (SELECT bookmark_id FROM bookmark_tag WHERE tag_id = $1)
+		GROUP BY bookmark_id
+		HAVING COUNT(tag_id) = 1
+		ORDER BY bookmark_id`, id)
+	checkError(err)
+
+	modifiedTime := time.Now().UTC().Format("2006-01-02 15:04:05")
+	tx.MustExec(`UPDATE bookmark SET modified = $1, version = version + 1
+		WHERE id IN (SELECT bookmark_id FROM bookmark_tag WHERE tag_id = $2)`,
+		modifiedTime, id)
+	tx.MustExec(`DELETE FROM bookmark_tag WHERE tag_id = $1`, id)
+	tx.MustExec(`UPDATE tag SET parent_id = 0 WHERE parent_id = $1`, id)
+	tx.MustExec(`DELETE FROM tag WHERE id = $1`, id)
+
+	// Commit transaction
+	err = tx.Commit()
+	checkError(err)
+
+	return orphanIDs, err
+}
+
+// SetBookmarksOrder sets the position of bookmarks following their order
+// in ids, starting from 1. The bookmarks are marked as modified as well, and
+// get a new version like any other change.
+func (db *PGDatabase) SetBookmarksOrder(ids []int) (err error) {
+	// Begin transaction
+	tx, err := db.Beginx()
+	if err != nil {
+		return err
+	}
+
+	// Make sure to rollback if panic ever happened
+	defer func() {
+		if r := recover(); r != nil {
+			panicErr, _ := r.(error)
+			tx.Rollback()
+
+			err = panicErr
+		}
+	}()
+
+	modifiedTime := time.Now().UTC().Format("2006-01-02 15:04:05")
+	stmt, err := tx.Preparex(`UPDATE bookmark SET sort_order = $1, modified = $2, version = version + 1 WHERE id = $3`)
+	checkError(err)
+
+	for i, id := range ids {
+		stmt.MustExec(i+1, modifiedTime, id)
+	}
+
+	// Commit transaction
+	err = tx.Commit()
+	checkError(err)
+
 	return err
 }
 
+// SetBookmarksRead marks bookmarks as read or unread, and returns the number
+// of bookmarks whose status is changed. Bookmarks that already have the status
+// are left as they are, so read time of bookmarks that read before is kept.
+func (db *PGDatabase) SetBookmarksRead(ids []int, read bool) (int, error) {
+	if len(ids) == 0 {
+		return 0, nil
+	}
+
+	modifiedTime := time.Now().UTC().Format("2006-01-02 15:04:05")
+
+	var readAt *string
+	if read {
+		readAt = &modifiedTime
+	}
+
+	query, args, err := sqlx.In(`UPDATE bookmark SET is_read = ?, read_at = ?, modified = ?, version = version + 1
+		WHERE is_read <> ? AND id IN (?)`, read, readAt, modifiedTime, read, ids)
+	if err != nil {
+		return 0, fmt.Errorf("failed to expand query: %v", err)
+	}
+	query = db.Rebind(query)
+
+	res, err := db.Exec(query, args...)
+	if err != nil {
+		return 0, fmt.Errorf("failed to set read status: %v", err)
+	}
+
+	nRows, _ := res.RowsAffected()
+	return int(nRows), nil
+}
+
+// SetBookmarkStarred stars or unstars the bookmark.
+func (db *PGDatabase) SetBookmarkStarred(id int, starred bool) error {
+	modifiedTime := time.Now().UTC().Format("2006-01-02 15:04:05")
+	_, err := db.Exec(`UPDATE bookmark SET starred = $1, modified = $2, version = version + 1 WHERE id = $3`, starred, modifiedTime, id)
+	if err != nil {
+		return fmt.Errorf("failed to set starred bookmark: %v", err)
+	}
+
+	return nil
+}
+
+// SetBookmarkLinkStatus records the HTTP status code that returned when
+// the bookmark URL is checked, or zero when it couldn't be reached. The
+// modified time is kept, since the bookmark itself isn't changed.
+func (db *PGDatabase) SetBookmarkLinkStatus(id int, status int) error {
+	checkedTime := time.Now().UTC().Format("2006-01-02 15:04:05")
+	_, err := db.Exec(`UPDATE bookmark SET link_status = $1, link_checked = $2 WHERE id = $3`, status, checkedTime, id)
+	if err != nil {
+		return fmt.Errorf("failed to set link status: %v", err)
+	}
+
+	return nil
+}
+
+// SetTagDefaultPublic sets the visibility given to bookmarks when
+// the tag is added to them. Nil removes the default visibility.
+func (db *PGDatabase) SetTagDefaultPublic(id int, public *int) error {
+	_, err := db.Exec(`UPDATE tag SET default_public = $1 WHERE id = $2`, public, id)
+	if err != nil {
+		return fmt.Errorf("failed to set default visibility of tag: %v", err)
+	}
+
+	return nil
+}
+
+// SetTagMetadata sets the color, description and icon of the tag.
+func (db *PGDatabase) SetTagMetadata(tag model.Tag) error {
+	_, err := db.Exec(`UPDATE tag SET color = $1, description = $2, icon = $3 WHERE id = $4`,
+		tag.Color, tag.Description, tag.Icon, tag.ID)
+	if err != nil {
+		return fmt.Errorf("failed to set metadata of tag: %v", err)
+	}
+
+	return nil
+}
+
+// GetTagDefaultPublic fetch the default visibility of tags that have it,
+// keyed by the tag name.
+func (db *PGDatabase) GetTagDefaultPublic() (map[string]int, error) {
+	tags := []model.Tag{}
+	query := `SELECT id, name, default_public FROM tag WHERE default_public IS NOT NULL`
+
+	err := db.Select(&tags, query)
+	if err != nil && err != sql.ErrNoRows {
+		return nil, fmt.Errorf("failed to fetch default visibility of tags: %v", err)
+	}
+
+	result := map[string]int{}
+	for _, tag := range tags {
+		result[tag.Name] = *tag.DefaultPublic
+	}
+
+	return result, nil
+}
+
 // CreateNewID creates new ID for specified table
 func (db *PGDatabase) CreateNewID(table string) (int, error) {
 	var tableID int
@@ -636,3 +1740,13 @@ func (db *PGDatabase) CreateNewID(table string) (int, error) {
 
 	return tableID, nil
 }
+
+// SchemaVersion returns the version of database schema.
+func (db *PGDatabase) SchemaVersion() (SchemaVersion, error) {
+	return getSchemaVersion(&db.DB, pgMigrations)
+}
+
+// Backup is not supported for PostgreSQL, use pg_dump instead.
+func (db *PGDatabase) Backup(dstPath string) error {
+	return ErrBackupUnsupported
+}
@@ -1,13 +1,17 @@
 package database
 
 import (
+	"context"
 	"database/sql"
 	"fmt"
+	"os"
+	"sort"
 	"strings"
 	"time"
 
 	"shiori/internal/model"
 	"github.com/jmoiron/sqlx"
+	"github.com/mattn/go-sqlite3"
 	"golang.org/x/crypto/bcrypt"
 )
 
@@ -17,27 +21,56 @@ type SQLiteDatabase struct {
 	sqlx.DB
 }
 
-// OpenSQLiteDatabase creates and open connection to new SQLite3 database.
-func OpenSQLiteDatabase(databasePath string) (sqliteDB *SQLiteDatabase, err error) {
-	// Open database and start transaction
-	db := sqlx.MustConnect("sqlite3", databasePath)
+// sqliteDriverName is the name of SQLite3 driver that has REGEXP function,
+// since SQLite3 doesn't implement it by default.
+const sqliteDriverName = "sqlite3_shiori"
 
-	tx, err := db.Beginx()
+func init() {
+	sql.Register(sqliteDriverName, &sqlite3.SQLiteDriver{
+		ConnectHook: func(conn *sqlite3.SQLiteConn) error {
+			return conn.RegisterFunc("regexp", sqliteRegexp, true)
+		},
+	})
+}
+
+// sqliteRegexp is the implementation of REGEXP function in SQLite3,
+// which is used as `text REGEXP pattern`. Pattern is case insensitive.
+func sqliteRegexp(pattern, text string) (bool, error) {
+	rx, err := compileRegex(pattern)
 	if err != nil {
-		return nil, err
+		return false, err
 	}
 
-	// Make sure to rollback if panic ever happened
-	defer func() {
-		if r := recover(); r != nil {
-			panicErr, _ := r.(error)
-			tx.Rollback()
+	return rx.MatchString(text), nil
+}
 
-			sqliteDB = nil
-			err = panicErr
-		}
-	}()
+// sqliteMigrations is the list of migrations for SQLite3 database, ordered by
+// their version. Released migration must never be changed, so any change
+// to the schema must be added as a new migration.
+var sqliteMigrations = []migration{
+	{1, "create initial schema", sqliteInitialSchema},
+	{2, "create api token table", sqliteAPITokenSchema},
+	{3, "create session table", sqliteSessionSchema},
+	{4, "add account role", sqliteAccountRole},
+	{5, "add bookmark owner", sqliteBookmarkOwner},
+	{6, "create share link table", sqliteShareLinkSchema},
+	{7, "add bookmark read status", sqliteBookmarkRead},
+	{8, "add starred bookmark", sqliteBookmarkStarred},
+	{9, "create collection table", sqliteCollectionSchema},
+	{10, "add tag parent", sqliteTagParent},
+	{11, "add tag metadata", sqliteTagMetadata},
+	{12, "add bookmark link status", sqliteBookmarkLinkStatus},
+	{13, "add bookmark wayback url", sqliteBookmarkWaybackURL},
+	{14, "add api token scope", sqliteAPITokenScope},
+	{15, "add account provider", sqliteAccountProvider},
+	{16, "add account identity", sqliteAccountIdentity},
+	{17, "add tombstone owner", sqliteTombstoneOwner},
+	{18, "make bookmark url unique per owner", sqliteBookmarkURLPerOwner},
+}
 
+// sqliteInitialSchema creates the initial schema. It also upgrades database
+// that created before the migrations were tracked, so it must be idempotent.
+func sqliteInitialSchema(tx *sqlx.Tx) error {
 	// Create tables
 	tx.MustExec(`CREATE TABLE IF NOT EXISTS account(
 		id       INTEGER NOT NULL,
@@ -48,19 +81,28 @@ func OpenSQLiteDatabase(databasePath string) (sqliteDB *SQLiteDatabase, err erro
 		CONSTRAINT account_username_UNIQUE UNIQUE(username))`)
 
 	tx.MustExec(`CREATE TABLE IF NOT EXISTS bookmark(
-		id       INTEGER NOT NULL,
-		url      TEXT    NOT NULL,
-		title    TEXT    NOT NULL,
-		excerpt  TEXT    NOT NULL DEFAULT "",
-		author   TEXT    NOT NULL DEFAULT "",
-		public   INTEGER NOT NULL DEFAULT 0,
-		modified TEXT    NOT NULL DEFAULT CURRENT_TIMESTAMP,
+		id           INTEGER NOT NULL,
+		url          TEXT    NOT NULL,
+		title        TEXT    NOT NULL,
+		excerpt      TEXT    NOT NULL DEFAULT "",
+		author       TEXT    NOT NULL DEFAULT "",
+		public       INTEGER NOT NULL DEFAULT 0,
+		content_type TEXT    NOT NULL DEFAULT "",
+		content_hash TEXT    NOT NULL DEFAULT "",
+		sort_order   INTEGER,
+		metadata     TEXT    NOT NULL DEFAULT "{}",
+		last_status  INTEGER NOT NULL DEFAULT 0,
+		versioned    INTEGER NOT NULL DEFAULT 0,
+		created      TEXT    NOT NULL DEFAULT CURRENT_TIMESTAMP,
+		modified     TEXT    NOT NULL DEFAULT CURRENT_TIMESTAMP,
+		version      INTEGER NOT NULL DEFAULT 1,
 		CONSTRAINT bookmark_PK PRIMARY KEY(id),
 		CONSTRAINT bookmark_url_UNIQUE UNIQUE(url))`)
 
 	tx.MustExec(`CREATE TABLE IF NOT EXISTS tag(
-		id   INTEGER NOT NULL,
-		name TEXT    NOT NULL,
+		id             INTEGER NOT NULL,
+		name           TEXT    NOT NULL,
+		default_public INTEGER,
 		CONSTRAINT tag_PK PRIMARY KEY(id),
 		CONSTRAINT tag_name_UNIQUE UNIQUE(name))`)
 
@@ -71,22 +113,267 @@ func OpenSQLiteDatabase(databasePath string) (sqliteDB *SQLiteDatabase, err erro
 		CONSTRAINT bookmark_id_FK FOREIGN KEY(bookmark_id) REFERENCES bookmark(id),
 		CONSTRAINT tag_id_FK FOREIGN KEY(tag_id) REFERENCES tag(id))`)
 
+	tx.MustExec(`CREATE TABLE IF NOT EXISTS bookmark_tombstone(
+		id      INTEGER NOT NULL,
+		url     TEXT    NOT NULL,
+		deleted TEXT    NOT NULL DEFAULT CURRENT_TIMESTAMP,
+		CONSTRAINT bookmark_tombstone_PK PRIMARY KEY(id))`)
+
 	tx.MustExec(`CREATE VIRTUAL TABLE IF NOT EXISTS bookmark_content USING fts4(title, content, html)`)
 
 	// Alter table if needed
 	tx.Exec(`ALTER TABLE account ADD COLUMN owner INTEGER NOT NULL DEFAULT 0`)
 	tx.Exec(`ALTER TABLE bookmark ADD COLUMN public INTEGER NOT NULL DEFAULT 0`)
+	tx.Exec(`ALTER TABLE bookmark ADD COLUMN content_type TEXT NOT NULL DEFAULT ""`)
+	tx.Exec(`ALTER TABLE bookmark ADD COLUMN version INTEGER NOT NULL DEFAULT 1`)
+	tx.Exec(`ALTER TABLE bookmark ADD COLUMN content_hash TEXT NOT NULL DEFAULT ""`)
+	tx.Exec(`ALTER TABLE bookmark ADD COLUMN sort_order INTEGER`)
+	tx.Exec(`ALTER TABLE bookmark ADD COLUMN metadata TEXT NOT NULL DEFAULT "{}"`)
+	tx.Exec(`ALTER TABLE bookmark ADD COLUMN last_status INTEGER NOT NULL DEFAULT 0`)
+	tx.Exec(`ALTER TABLE bookmark ADD COLUMN versioned INTEGER NOT NULL DEFAULT 0`)
+	tx.Exec(`ALTER TABLE tag ADD COLUMN default_public INTEGER`)
+
+	// Existing bookmarks don't have created time, so use their modified time
+	if _, err := tx.Exec(`ALTER TABLE bookmark ADD COLUMN created TEXT NOT NULL DEFAULT ""`); err == nil {
+		tx.MustExec(`UPDATE bookmark SET created = modified`)
+	}
 
-	err = tx.Commit()
-	checkError(err)
+	return nil
+}
+
+// sqliteAPITokenSchema creates the table of API tokens.
+func sqliteAPITokenSchema(tx *sqlx.Tx) error {
+	tx.MustExec(`CREATE TABLE IF NOT EXISTS api_token(
+		id         INTEGER NOT NULL,
+		account_id INTEGER NOT NULL,
+		name       TEXT    NOT NULL,
+		token_hash TEXT    NOT NULL,
+		created    TEXT    NOT NULL DEFAULT CURRENT_TIMESTAMP,
+		CONSTRAINT api_token_PK PRIMARY KEY(id),
+		CONSTRAINT api_token_hash_UNIQUE UNIQUE(token_hash),
+		CONSTRAINT api_token_account_id_FK FOREIGN KEY(account_id) REFERENCES account(id))`)
+
+	return nil
+}
+
+// sqliteSessionSchema creates the table of login sessions.
+func sqliteSessionSchema(tx *sqlx.Tx) error {
+	// The ID is never reused, since it identifies the session in access token
+	tx.MustExec(`CREATE TABLE IF NOT EXISTS account_session(
+		id         INTEGER NOT NULL PRIMARY KEY AUTOINCREMENT,
+		account_id INTEGER NOT NULL,
+		token_hash TEXT    NOT NULL,
+		created    TEXT    NOT NULL DEFAULT CURRENT_TIMESTAMP,
+		expires    TEXT    NOT NULL,
+		CONSTRAINT account_session_account_id_FK FOREIGN KEY(account_id) REFERENCES account(id))`)
+
+	return nil
+}
+
+// sqliteAccountRole adds role to accounts. Owners keep owning,
+// while the others become editors since they could edit before.
+func sqliteAccountRole(tx *sqlx.Tx) error {
+	tx.MustExec(`ALTER TABLE account ADD COLUMN role TEXT NOT NULL DEFAULT 'viewer'`)
+	tx.MustExec(`UPDATE account SET role = 'owner' WHERE owner = 1`)
+	tx.MustExec(`UPDATE account SET role = 'editor' WHERE owner = 0`)
+
+	return nil
+}
+
+// sqliteBookmarkOwner adds owner to bookmarks. The existing bookmarks
+// are given to the first owner account, if there is any.
+func sqliteBookmarkOwner(tx *sqlx.Tx) error {
+	tx.MustExec(`ALTER TABLE bookmark ADD COLUMN owner_id INTEGER NOT NULL DEFAULT 0`)
+	tx.MustExec(`CREATE INDEX bookmark_owner_id_IDX ON bookmark (owner_id)`)
+	tx.MustExec(`UPDATE bookmark SET owner_id = COALESCE(
+		(SELECT MIN(id) FROM account WHERE role = 'owner'), 0)`)
+
+	return nil
+}
+
+// sqliteShareLinkSchema creates the table of bookmark share links.
+func sqliteShareLinkSchema(tx *sqlx.Tx) error {
+	tx.MustExec(`CREATE TABLE IF NOT EXISTS bookmark_share(
+		id          INTEGER NOT NULL,
+		bookmark_id INTEGER NOT NULL,
+		token_hash  TEXT    NOT NULL,
+		created     TEXT    NOT NULL DEFAULT CURRENT_TIMESTAMP,
+		CONSTRAINT bookmark_share_PK PRIMARY KEY(id),
+		CONSTRAINT bookmark_share_hash_UNIQUE UNIQUE(token_hash),
+		CONSTRAINT bookmark_share_bookmark_id_FK FOREIGN KEY(bookmark_id) REFERENCES bookmark(id))`)
+
+	return nil
+}
+
+// sqliteBookmarkRead adds read status to bookmarks. The existing
+// bookmarks are unread, since it's unknown whether they've been read.
+func sqliteBookmarkRead(tx *sqlx.Tx) error {
+	tx.MustExec(`ALTER TABLE bookmark ADD COLUMN is_read INTEGER NOT NULL DEFAULT 0`)
+	tx.MustExec(`ALTER TABLE bookmark ADD COLUMN read_at TEXT`)
+
+	return nil
+}
+
+// sqliteBookmarkStarred adds starred flag to bookmarks.
+func sqliteBookmarkStarred(tx *sqlx.Tx) error {
+	tx.MustExec(`ALTER TABLE bookmark ADD COLUMN starred INTEGER NOT NULL DEFAULT 0`)
+
+	return nil
+}
+
+// sqliteCollectionSchema creates the table of collections,
+// and lets bookmarks be put in one of them.
+func sqliteCollectionSchema(tx *sqlx.Tx) error {
+	tx.MustExec(`CREATE TABLE IF NOT EXISTS collection(
+		id        INTEGER NOT NULL,
+		name      TEXT    NOT NULL,
+		parent_id INTEGER NOT NULL DEFAULT 0,
+		owner_id  INTEGER NOT NULL DEFAULT 0,
+		CONSTRAINT collection_PK PRIMARY KEY(id))`)
+	tx.MustExec(`ALTER TABLE bookmark ADD COLUMN collection_id INTEGER NOT NULL DEFAULT 0`)
+	tx.MustExec(`CREATE INDEX bookmark_collection_id_IDX ON bookmark (collection_id)`)
+
+	return nil
+}
+
+// sqliteTagParent links hierarchical tags to their parent, which is decided
+// by their name, e.g. "dev/go" is the parent of "dev/go/web".
+func sqliteTagParent(tx *sqlx.Tx) error {
+	tx.MustExec(`ALTER TABLE tag ADD COLUMN parent_id INTEGER NOT NULL DEFAULT 0`)
+	relinkTagParents(tx)
+
+	return nil
+}
+
+// sqliteTagMetadata adds color, description and icon to tags,
+// which are only used by clients to show the tags.
+func sqliteTagMetadata(tx *sqlx.Tx) error {
+	tx.MustExec(`ALTER TABLE tag ADD COLUMN color TEXT NOT NULL DEFAULT ''`)
+	tx.MustExec(`ALTER TABLE tag ADD COLUMN description TEXT NOT NULL DEFAULT ''`)
+	tx.MustExec(`ALTER TABLE tag ADD COLUMN icon TEXT NOT NULL DEFAULT ''`)
+
+	return nil
+}
+
+// sqliteBookmarkLinkStatus adds the result of checking bookmark URL by the
+// link checker. The existing bookmarks have never been checked.
+func sqliteBookmarkLinkStatus(tx *sqlx.Tx) error {
+	tx.MustExec(`ALTER TABLE bookmark ADD COLUMN link_status INTEGER NOT NULL DEFAULT 0`)
+	tx.MustExec(`ALTER TABLE bookmark ADD COLUMN link_checked TEXT`)
+
+	return nil
+}
+
+// sqliteBookmarkWaybackURL adds the snapshot in Wayback Machine that bookmark
+// is archived from. The existing bookmarks are archived from their page.
+func sqliteBookmarkWaybackURL(tx *sqlx.Tx) error {
+	tx.MustExec(`ALTER TABLE bookmark ADD COLUMN wayback_url TEXT NOT NULL DEFAULT ''`)
+
+	return nil
+}
+
+// sqliteAPITokenScope adds scope of API token. Existing tokens keep full access.
+func sqliteAPITokenScope(tx *sqlx.Tx) error {
+	tx.MustExec(`ALTER TABLE api_token ADD COLUMN scope TEXT NOT NULL DEFAULT 'full'`)
+
+	return nil
+}
+
+// sqliteAccountProvider adds where account is authenticated. Existing accounts
+// are local ones, even those that created for LDAP users, since they can't
+// be told apart anymore.
+func sqliteAccountProvider(tx *sqlx.Tx) error {
+	tx.MustExec(`ALTER TABLE account ADD COLUMN provider TEXT NOT NULL DEFAULT ''`)
+
+	return nil
+}
+
+// sqliteAccountIdentity adds the user of OpenID Connect provider that account
+// is linked to.
+func sqliteAccountIdentity(tx *sqlx.Tx) error {
+	tx.MustExec(`ALTER TABLE account ADD COLUMN issuer TEXT NOT NULL DEFAULT ''`)
+	tx.MustExec(`ALTER TABLE account ADD COLUMN subject TEXT NOT NULL DEFAULT ''`)
+
+	return nil
+}
+
+// sqliteTombstoneOwner adds owner of deleted bookmarks. The existing
+// tombstones are given to the first owner account, like their bookmarks.
+func sqliteTombstoneOwner(tx *sqlx.Tx) error {
+	tx.MustExec(`ALTER TABLE bookmark_tombstone ADD COLUMN owner_id INTEGER NOT NULL DEFAULT 0`)
+	tx.MustExec(`UPDATE bookmark_tombstone SET owner_id = COALESCE(
+		(SELECT MIN(id) FROM account WHERE role = 'owner'), 0)`)
+
+	return nil
+}
+
+// sqliteBookmarkURLPerOwner makes bookmark URL unique for each owner instead
+// of all accounts, so every account may bookmark the same page. SQLite can't
+// alter constraint, so the table is recreated from its own schema with the
+// new constraint, then its rows and indexes are copied over.
+func sqliteBookmarkURLPerOwner(tx *sqlx.Tx) error {
+	var schema string
+	err := tx.Get(&schema, `SELECT sql FROM sqlite_master WHERE type = 'table' AND name = 'bookmark'`)
+	if err != nil {
+		return err
+	}
+
+	if !strings.Contains(schema, "UNIQUE(url)") {
+		return fmt.Errorf("bookmark table doesn't have unique url constraint")
+	}
+
+	var indexes []string
+	err = tx.Select(&indexes, `SELECT sql FROM sqlite_master
+		WHERE type = 'index' AND tbl_name = 'bookmark' AND sql IS NOT NULL`)
+	if err != nil {
+		return err
+	}
+
+	schema = strings.Replace(schema, "UNIQUE(url)", "UNIQUE(owner_id, url)", 1)
+	schema = strings.Replace(schema, "bookmark", "bookmark_new", 1)
+
+	tx.MustExec(schema)
+	tx.MustExec(`INSERT INTO bookmark_new SELECT * FROM bookmark`)
+	tx.MustExec(`DROP TABLE bookmark`)
+	tx.MustExec(`ALTER TABLE bookmark_new RENAME TO bookmark`)
+	for _, index := range indexes {
+		tx.MustExec(index)
+	}
+
+	return nil
+}
+
+// OpenSQLiteDatabase creates and open connection to new SQLite3 database.
+func OpenSQLiteDatabase(databasePath string) (sqliteDB *SQLiteDatabase, err error) {
+	// Open database
+	db := sqlx.MustConnect(sqliteDriverName, databasePath)
+
+	// Apply the migrations that database doesn't have yet
+	if err := runMigrations(db, sqliteMigrations); err != nil {
+		db.Close()
+		return nil, err
+	}
 
 	sqliteDB = &SQLiteDatabase{*db}
-	return sqliteDB, err
+	return sqliteDB, nil
 }
 
 // SaveBookmarks saves new or updated bookmarks to database.
 // Returns the saved ID and error message if any happened.
-func (db *SQLiteDatabase) SaveBookmarks(bookmarks ...model.Bookmark) (result []model.Bookmark, err error) {
+func (db *SQLiteDatabase) SaveBookmarks(bookmarks ...model.Bookmark) ([]model.Bookmark, error) {
+	return db.saveBookmarks(true, bookmarks...)
+}
+
+// SaveBookmarksWithoutContent saves updated bookmarks to database, while their
+// content is kept as it is, so they can be fetched without their content.
+func (db *SQLiteDatabase) SaveBookmarksWithoutContent(bookmarks ...model.Bookmark) ([]model.Bookmark, error) {
+	return db.saveBookmarks(false, bookmarks...)
+}
+
+// saveBookmarks saves bookmarks in a single transaction, together with their
+// content unless withContent is false, in which case the bookmarks must
+// already exist in database.
+func (db *SQLiteDatabase) saveBookmarks(withContent bool, bookmarks ...model.Bookmark) (result []model.Bookmark, err error) {
 	// Prepare transaction
 	tx, err := db.Beginx()
 	if err != nil {
@@ -106,18 +393,27 @@ func (db *SQLiteDatabase) SaveBookmarks(bookmarks ...model.Bookmark) (result []m
 
 	// Prepare statement
 	stmtInsertBook, _ := tx.Preparex(`INSERT INTO bookmark
-		(id, url, title, excerpt, author, public, modified)
-		VALUES(?, ?, ?, ?, ?, ?, ?)
+		(id, url, title, excerpt, author, public, content_type, content_hash, metadata, last_status, wayback_url, versioned, created, modified, version, owner_id, collection_id)
+		VALUES(?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
 		ON CONFLICT(id) DO UPDATE SET
 		url = ?, title = ?,	excerpt = ?, author = ?, 
-		public = ?, modified = ?`)
+		public = ?, content_type = ?, content_hash = ?, metadata = ?, last_status = ?, wayback_url = ?, versioned = ?, modified = ?, version = ?`)
+
+	stmtInsertNewBook, _ := tx.Preparex(`INSERT INTO bookmark
+		(url, title, excerpt, author, public, content_type, content_hash, metadata, last_status, wayback_url, versioned, created, modified, version, owner_id, collection_id)
+		VALUES(?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`)
+
+	stmtUpdateBook, _ := tx.Preparex(`UPDATE bookmark SET
+		url = ?, title = ?, excerpt = ?, author = ?,
+		public = ?, content_type = ?, content_hash = ?, metadata = ?, last_status = ?, wayback_url = ?, versioned = ?, modified = ?, version = ?
+		WHERE id = ? AND version = ?`)
 
 	stmtInsertBookContent, _ := tx.Preparex(`INSERT OR IGNORE INTO bookmark_content
 		(docid, title, content, html) 
 		VALUES (?, ?, ?, ?)`)
 
 	stmtUpdateBookContent, _ := tx.Preparex(`UPDATE bookmark_content SET
-		title = ?, content = ?, html = ? 
+		title = ?, content = COALESCE(?, content), html = COALESCE(?, html)
 		WHERE docid = ?`)
 
 	stmtGetTag, _ := tx.Preparex(`SELECT id FROM tag WHERE name = ?`)
@@ -130,14 +426,18 @@ func (db *SQLiteDatabase) SaveBookmarks(bookmarks ...model.Bookmark) (result []m
 	stmtDeleteBookTag, _ := tx.Preparex(`DELETE FROM bookmark_tag
 		WHERE bookmark_id = ? AND tag_id = ?`)
 
+	stmtDeleteTombstone, _ := tx.Preparex(`DELETE FROM bookmark_tombstone WHERE id = ?`)
+
 	// Prepare modified time
 	modifiedTime := time.Now().UTC().Format("2006-01-02 15:04:05")
 
 	// Execute statements
 	result = []model.Bookmark{}
 	for _, book := range bookmarks {
-		// Check ID, URL and title
-		if book.ID == 0 {
+		// Check ID, URL and title. New bookmark may be saved without ID,
+		// in which case its ID is assigned by database once it's inserted,
+		// so it never collides with bookmark that saved concurrently.
+		if book.ID == 0 && book.Version > 0 {
 			panic(fmt.Errorf("ID must not be empty"))
 		}
 
@@ -145,20 +445,59 @@ func (db *SQLiteDatabase) SaveBookmarks(bookmarks ...model.Bookmark) (result []m
 			panic(fmt.Errorf("URL must not be empty"))
 		}
 
+		// Make sure title and excerpt are plain text
+		book.Title = normalizeText(book.Title)
+		book.Excerpt = normalizeText(book.Excerpt)
+
 		if book.Title == "" {
 			panic(fmt.Errorf("title must not be empty"))
 		}
 
-		// Set modified time
+		// Set modified time, and created time if it's not specified.
+		// Created time is only saved for new bookmark.
+		book.Created = normalizeTime(book.Created, modifiedTime)
 		book.Modified = modifiedTime
 
+		// Every save is a new version, so concurrent update can be detected.
+		// Bookmark that read from database is only saved if it still has
+		// the version it's read with.
+		book.Version++
+
+		// Content that isn't saved is kept as it is
+		var content, html interface{} = book.Content, book.HTML
+		if !withContent {
+			if book.Version == 1 {
+				panic(fmt.Errorf("new bookmark must be saved with its content"))
+			}
+
+			content, html = nil, nil
+		}
+
 		// Save bookmark
-		stmtInsertBook.MustExec(book.ID,
-			book.URL, book.Title, book.Excerpt, book.Author, book.Public, book.Modified,
-			book.URL, book.Title, book.Excerpt, book.Author, book.Public, book.Modified)
+		if book.Version > 1 {
+			res := stmtUpdateBook.MustExec(
+				book.URL, book.Title, book.Excerpt, book.Author, book.Public, book.ContentType, book.ContentHash, book.Metadata, book.LastStatusCode, book.WaybackURL, book.VersionedArchive, book.Modified, book.Version,
+				book.ID, book.Version-1)
+			checkVersionUpdated(res)
+		} else if book.ID == 0 {
+			res := stmtInsertNewBook.MustExec(
+				book.URL, book.Title, book.Excerpt, book.Author, book.Public, book.ContentType, book.ContentHash, book.Metadata, book.LastStatusCode, book.WaybackURL, book.VersionedArchive, book.Created, book.Modified, book.Version, book.OwnerID, book.CollectionID)
+			id, err := res.LastInsertId()
+			checkError(err)
+			book.ID = int(id)
+		} else {
+			stmtInsertBook.MustExec(book.ID,
+				book.URL, book.Title, book.Excerpt, book.Author, book.Public, book.ContentType, book.ContentHash, book.Metadata, book.LastStatusCode, book.WaybackURL, book.VersionedArchive, book.Created, book.Modified, book.Version, book.OwnerID, book.CollectionID,
+				book.URL, book.Title, book.Excerpt, book.Author, book.Public, book.ContentType, book.ContentHash, book.Metadata, book.LastStatusCode, book.WaybackURL, book.VersionedArchive, book.Modified, book.Version)
+		}
+
+		stmtUpdateBookContent.MustExec(book.Title, content, html, book.ID)
+		if withContent {
+			stmtInsertBookContent.MustExec(book.ID, book.Title, book.Content, book.HTML)
+		}
 
-		stmtUpdateBookContent.MustExec(book.Title, book.Content, book.HTML, book.ID)
-		stmtInsertBookContent.MustExec(book.ID, book.Title, book.Content, book.HTML)
+		// The ID might belong to a deleted bookmark, so remove its tombstone
+		stmtDeleteTombstone.MustExec(book.ID)
 
 		// Save book tags
 		newTags := []model.Tag{}
@@ -185,6 +524,11 @@ func (db *SQLiteDatabase) SaveBookmarks(bookmarks ...model.Bookmark) (result []m
 					checkError(err)
 
 					tag.ID = int(tagID64)
+
+					// Nested tag needs its parents as well
+					if strings.Contains(tagName, "/") {
+						linkTagParents(tx, tagName)
+					}
 				}
 
 				stmtInsertBookTag.Exec(tag.ID, book.ID)
@@ -214,7 +558,23 @@ func (db *SQLiteDatabase) GetBookmarks(opts GetBookmarksOptions) ([]model.Bookma
 		`b.excerpt`,
 		`b.author`,
 		`b.public`,
+		`b.content_type`,
+		`b.content_hash`,
+		`b.sort_order`,
+		`b.metadata`,
+		`b.last_status`,
+		`b.versioned`,
+		`b.created`,
 		`b.modified`,
+		`b.version`,
+		`b.owner_id`,
+		`b.is_read`,
+		`b.read_at`,
+		`b.starred`,
+		`b.collection_id`,
+		`b.link_status`,
+		`b.link_checked`,
+		`b.wayback_url`,
 		`bc.content <> "" has_content`}
 
 	if opts.WithContent {
@@ -227,86 +587,28 @@ func (db *SQLiteDatabase) GetBookmarks(opts GetBookmarksOptions) ([]model.Bookma
 		WHERE 1`
 
 	// Add where clause
-	args := []interface{}{}
-
-	// Add where clause for IDs
-	if len(opts.IDs) > 0 {
-		query += ` AND b.id IN (?)`
-		args = append(args, opts.IDs)
-	}
-
-	// Add where clause for search keyword
-	if opts.Keyword != "" {
-		query += ` AND (b.url LIKE ? OR b.excerpt LIKE ? OR b.id IN (
-			SELECT docid id 
-			FROM bookmark_content 
-			WHERE title MATCH ? OR content MATCH ?))`
-
-		args = append(args,
-			"%"+opts.Keyword+"%",
-			"%"+opts.Keyword+"%",
-			opts.Keyword,
-			opts.Keyword)
-	}
-
-	// Add where clause for tags.
-	// First we check for * in excluded and included tags,
-	// which means all tags will be excluded and included, respectively.
-	excludeAllTags := false
-	for _, excludedTag := range opts.ExcludedTags {
-		if excludedTag == "*" {
-			excludeAllTags = true
-			opts.ExcludedTags = []string{}
-			break
-		}
-	}
-
-	includeAllTags := false
-	for _, includedTag := range opts.Tags {
-		if includedTag == "*" {
-			includeAllTags = true
-			opts.Tags = []string{}
-			break
-		}
-	}
-
-	// If all tags excluded, we will only show bookmark without tags.
-	// In other hand, if all tags included, we will only show bookmark with tags.
-	if excludeAllTags {
-		query += ` AND b.id NOT IN (SELECT DISTINCT bookmark_id FROM bookmark_tag)`
-	} else if includeAllTags {
-		query += ` AND b.id IN (SELECT DISTINCT bookmark_id FROM bookmark_tag)`
-	}
-
-	// Now we only need to find the normal tags
-	if len(opts.Tags) > 0 {
-		query += ` AND b.id IN (
-			SELECT bt.bookmark_id
-			FROM bookmark_tag bt
-			LEFT JOIN tag t ON bt.tag_id = t.id
-			WHERE t.name IN(?)
-			GROUP BY bt.bookmark_id
-			HAVING COUNT(bt.bookmark_id) = ?)`
-
-		args = append(args, opts.Tags, len(opts.Tags))
-	}
-
-	if len(opts.ExcludedTags) > 0 {
-		query += ` AND b.id NOT IN (
-			SELECT DISTINCT bt.bookmark_id
-			FROM bookmark_tag bt
-			LEFT JOIN tag t ON bt.tag_id = t.id
-			WHERE t.name IN(?))`
-
-		args = append(args, opts.ExcludedTags)
-	}
+	whereClause, args := db.createWhereClause(opts)
+	query += whereClause
 
 	// Add order clause
 	switch opts.OrderMethod {
 	case ByLastAdded:
-		query += ` ORDER BY b.id DESC`
+		query += ` ORDER BY b.created DESC, b.id DESC`
 	case ByLastModified:
 		query += ` ORDER BY b.modified DESC`
+	case ByFirstModified:
+		query += ` ORDER BY b.modified, b.id`
+	case ByManualOrder:
+		query += ` ORDER BY b.sort_order IS NULL, b.sort_order, b.created DESC, b.id DESC`
+	case ByFirstAdded:
+		query += ` ORDER BY b.created, b.id`
+	case ByTitle:
+		query += ` ORDER BY b.title COLLATE NOCASE, b.id`
+	case ByDomain:
+		query += ` ORDER BY substr(
+				substr(b.url, instr(b.url, '://') + 3), 1,
+				instr(substr(b.url, instr(b.url, '://') + 3) || '/', '/') - 1
+			), b.title COLLATE NOCASE, b.id`
 	default:
 		query += ` ORDER BY b.id`
 	}
@@ -323,8 +625,15 @@ func (db *SQLiteDatabase) GetBookmarks(opts GetBookmarksOptions) ([]model.Bookma
 	}
 
 	// Fetch bookmarks
+	ctx, cancel := queryContext(opts)
+	defer cancel()
+
 	bookmarks := []model.Bookmark{}
-	err = db.Select(&bookmarks, query, args...)
+	err = db.SelectContext(ctx, &bookmarks, query, args...)
+	if ctx.Err() == context.DeadlineExceeded {
+		return nil, ErrRegexTimeout
+	}
+
 	if err != nil && err != sql.ErrNoRows {
 		return nil, fmt.Errorf("failed to fetch data: %v", err)
 	}
@@ -362,6 +671,36 @@ func (db *SQLiteDatabase) GetBookmarksCount(opts GetBookmarksOptions) (int, erro
 		WHERE 1`
 
 	// Add where clause
+	whereClause, args := db.createWhereClause(opts)
+	query += whereClause
+
+	// Expand query, because some of the args might be an array
+	query, args, err := sqlx.In(query, args...)
+	if err != nil {
+		return 0, fmt.Errorf("failed to expand query: %v", err)
+	}
+
+	// Fetch count
+	ctx, cancel := queryContext(opts)
+	defer cancel()
+
+	var nBookmarks int
+	err = db.GetContext(ctx, &nBookmarks, query, args...)
+	if ctx.Err() == context.DeadlineExceeded {
+		return 0, ErrRegexTimeout
+	}
+
+	if err != nil && err != sql.ErrNoRows {
+		return 0, fmt.Errorf("failed to fetch count: %v", err)
+	}
+
+	return nBookmarks, nil
+}
+
+// createWhereClause creates where clause and its arguments
+// for filtering bookmarks based on submitted options.
+func (db *SQLiteDatabase) createWhereClause(opts GetBookmarksOptions) (string, []interface{}) {
+	query := ``
 	args := []interface{}{}
 
 	// Add where clause for IDs
@@ -370,18 +709,157 @@ func (db *SQLiteDatabase) GetBookmarksCount(opts GetBookmarksOptions) (int, erro
 		args = append(args, opts.IDs)
 	}
 
-	// Add where clause for search keyword
-	if opts.Keyword != "" {
-		query += ` AND (b.url LIKE ? OR b.excerpt LIKE ? OR b.id IN (
-			SELECT docid id 
-			FROM bookmark_content 
-			WHERE title MATCH ? OR content MATCH ?))`
+	if len(opts.URLs) > 0 {
+		query += ` AND b.url IN (?)`
+		args = append(args, opts.URLs)
+	}
+
+	// Add where clause for modified time
+	if opts.UpdatedSince != "" {
+		query += ` AND b.modified >= ?`
+		args = append(args, opts.UpdatedSince)
+	}
+
+	if opts.UpdatedBefore != "" {
+		query += ` AND b.modified < ?`
+		args = append(args, opts.UpdatedBefore)
+	}
+
+	// Add where clause for created time
+	if opts.CreatedSince != "" {
+		query += ` AND b.created >= ?`
+		args = append(args, opts.CreatedSince)
+	}
+
+	if opts.CreatedBefore != "" {
+		query += ` AND b.created < ?`
+		args = append(args, opts.CreatedBefore)
+	}
+
+	// Add where clause for search keyword.
+	// Each term must be found in one of the searched fields.
+	fields := searchedFields(opts)
+	for _, term := range splitKeyword(opts.Keyword) {
+		matchTerm := term
+		if strings.ContainsAny(term, " \t\n") {
+			matchTerm = `"` + term + `"`
+		}
+
+		conds := []string{}
+		if fields["url"] {
+			conds = append(conds, `b.url LIKE ?`)
+			args = append(args, "%"+term+"%")
+		}
+
+		if fields["excerpt"] {
+			conds = append(conds, `b.excerpt LIKE ?`)
+			args = append(args, "%"+term+"%")
+		}
+
+		// Title and content are searched using the full text index
+		matchConds := []string{}
+		for _, field := range []string{"title", "content"} {
+			if fields[field] {
+				matchConds = append(matchConds, field+` MATCH ?`)
+				args = append(args, matchTerm)
+			}
+		}
+
+		if len(matchConds) > 0 {
+			conds = append(conds, `b.id IN (
+				SELECT docid id 
+				FROM bookmark_content 
+				WHERE `+strings.Join(matchConds, " OR ")+`)`)
+		}
+
+		query += ` AND (` + strings.Join(conds, " OR ") + `)`
+	}
+
+	// Add where clause for regular expression
+	if opts.Regex != "" {
+		query += ` AND (b.title REGEXP ? OR b.excerpt REGEXP ?)`
+		args = append(args, opts.Regex, opts.Regex)
+	}
+
+	// Add where clause for content type
+	if opts.ContentType != "" {
+		query += ` AND b.content_type LIKE ?`
+		args = append(args, contentTypePattern(opts.ContentType))
+	}
+
+	// Add where clause for versioned archive
+	if opts.Versioned {
+		query += ` AND b.versioned = 1`
+	}
+
+	// Add where clause for owner
+	if opts.OwnerID != 0 {
+		query += ` AND b.owner_id = ?`
+		args = append(args, opts.OwnerID)
+	}
+
+	// Add where clause for public bookmarks
+	if opts.PublicOnly {
+		query += ` AND b.public = 1`
+	}
+
+	// Add where clause for collections
+	if len(opts.CollectionIDs) > 0 {
+		query += ` AND b.collection_id IN (?)`
+		args = append(args, opts.CollectionIDs)
+	}
+
+	// Add where clause for starred bookmarks
+	if opts.Starred {
+		query += ` AND b.starred = 1`
+	}
+
+	// Add where clause for bookmarks whose URL is broken
+	if opts.Broken {
+		query += ` AND b.link_checked IS NOT NULL AND (b.link_status = 0 OR b.link_status >= 400)`
+	}
+
+	// Add where clause for read status
+	if opts.Read != nil {
+		query += ` AND b.is_read = ?`
+		args = append(args, *opts.Read)
+	}
+
+	// Add where clause for bookmarks without tags. Tag link whose
+	// tag no longer exists doesn't count, since it's never shown.
+	if opts.Untagged {
+		query += ` AND NOT EXISTS (
+			SELECT 1 FROM bookmark_tag bt
+			JOIN tag t ON t.id = bt.tag_id
+			WHERE bt.bookmark_id = b.id)`
+	}
+
+	// Add where clause for metadata
+	for _, key := range sortedKeys(opts.Metadata) {
+		query += ` AND b.metadata LIKE ? ESCAPE '!'`
+		args = append(args, metadataPattern(key, opts.Metadata[key]))
+	}
 
-		args = append(args,
-			"%"+opts.Keyword+"%",
-			"%"+opts.Keyword+"%",
-			opts.Keyword,
-			opts.Keyword)
+	// Add where clause for terms that scoped to title and URL
+	for _, term := range opts.TitleTerms {
+		query += ` AND b.title LIKE ?`
+		args = append(args, "%"+term+"%")
+	}
+
+	for _, term := range opts.URLTerms {
+		query += ` AND b.url LIKE ?`
+		args = append(args, "%"+term+"%")
+	}
+
+	// Add where clause for excluded URL host.
+	// SQLite doesn't have function to split string, so the host is taken
+	// manually from text between "://" and the first slash after it.
+	for _, excludedURL := range opts.ExcludedURLs {
+		query += ` AND substr(
+			substr(b.url, instr(b.url, '://') + 3), 1,
+			instr(substr(b.url, instr(b.url, '://') + 3) || '/', '/') - 1
+		) NOT LIKE ?`
+		args = append(args, "%"+excludedURL+"%")
 	}
 
 	// Add where clause for tags.
@@ -413,51 +891,53 @@ func (db *SQLiteDatabase) GetBookmarksCount(opts GetBookmarksOptions) (int, erro
 		query += ` AND b.id IN (SELECT DISTINCT bookmark_id FROM bookmark_tag)`
 	}
 
-	// Now we only need to find the normal tags
-	if len(opts.Tags) > 0 {
+	// Now we only need to find the normal tags. Since tags may be nested,
+	// each tag also matches the tags inside it, e.g. "dev" matches "dev/go".
+	for _, tag := range opts.Tags {
 		query += ` AND b.id IN (
 			SELECT bt.bookmark_id
 			FROM bookmark_tag bt
-			LEFT JOIN tag t ON bt.tag_id = t.id
-			WHERE t.name IN(?)
-			GROUP BY bt.bookmark_id
-			HAVING COUNT(bt.bookmark_id) = ?)`
+			JOIN tag t ON bt.tag_id = t.id
+			WHERE t.name = ? OR t.name LIKE ? ESCAPE '!')`
 
-		args = append(args, opts.Tags, len(opts.Tags))
+		args = append(args, tag, tagDescendantsPattern(tag))
 	}
 
-	if len(opts.ExcludedTags) > 0 {
+	for _, tag := range opts.ExcludedTags {
 		query += ` AND b.id NOT IN (
-			SELECT DISTINCT bt.bookmark_id
+			SELECT bt.bookmark_id
 			FROM bookmark_tag bt
-			LEFT JOIN tag t ON bt.tag_id = t.id
-			WHERE t.name IN(?))`
+			JOIN tag t ON bt.tag_id = t.id
+			WHERE t.name = ? OR t.name LIKE ? ESCAPE '!')`
 
-		args = append(args, opts.ExcludedTags)
+		args = append(args, tag, tagDescendantsPattern(tag))
 	}
 
-	// Expand query, because some of the args might be an array
-	query, args, err := sqlx.In(query, args...)
-	if err != nil {
-		return 0, fmt.Errorf("failed to expand query: %v", err)
-	}
+	// Unlike Tags, bookmark only needs any one of AnyTags
+	if len(opts.AnyTags) > 0 {
+		conds := []string{}
+		for _, tag := range opts.AnyTags {
+			conds = append(conds, `t.name = ? OR t.name LIKE ? ESCAPE '!'`)
+			args = append(args, tag, tagDescendantsPattern(tag))
+		}
 
-	// Fetch count
-	var nBookmarks int
-	err = db.Get(&nBookmarks, query, args...)
-	if err != nil && err != sql.ErrNoRows {
-		return 0, fmt.Errorf("failed to fetch count: %v", err)
+		query += ` AND b.id IN (
+			SELECT bt.bookmark_id
+			FROM bookmark_tag bt
+			JOIN tag t ON bt.tag_id = t.id
+			WHERE ` + strings.Join(conds, " OR ") + `)`
 	}
 
-	return nBookmarks, nil
+	return query, args
 }
 
 // DeleteBookmarks removes all record with matching ids from database.
-func (db *SQLiteDatabase) DeleteBookmarks(ids ...int) (err error) {
+// Returns the number of bookmarks that actually deleted.
+func (db *SQLiteDatabase) DeleteBookmarks(ids ...int) (nDeleted int, err error) {
 	// Begin transaction
 	tx, err := db.Beginx()
 	if err != nil {
-		return err
+		return 0, err
 	}
 
 	// Make sure to rollback if panic ever happened
@@ -466,6 +946,7 @@ func (db *SQLiteDatabase) DeleteBookmarks(ids ...int) (err error) {
 			panicErr, _ := r.(error)
 			tx.Rollback()
 
+			nDeleted = 0
 			err = panicErr
 		}
 	}()
@@ -474,25 +955,43 @@ func (db *SQLiteDatabase) DeleteBookmarks(ids ...int) (err error) {
 	delBookmark := `DELETE FROM bookmark`
 	delBookmarkTag := `DELETE FROM bookmark_tag`
 	delBookmarkContent := `DELETE FROM bookmark_content`
+	delBookmarkShare := `DELETE FROM bookmark_share`
+	insTombstone := `INSERT OR REPLACE INTO bookmark_tombstone (id, url, owner_id, deleted)
+		SELECT id, url, owner_id, ? FROM bookmark`
+
+	// Prepare deleted time
+	deletedTime := time.Now().UTC().Format("2006-01-02 15:04:05")
 
 	// Delete bookmark(s)
 	if len(ids) == 0 {
+		tx.MustExec(insTombstone, deletedTime)
 		tx.MustExec(delBookmarkContent)
 		tx.MustExec(delBookmarkTag)
-		tx.MustExec(delBookmark)
+		tx.MustExec(delBookmarkShare)
+		res := tx.MustExec(delBookmark)
+		nRows, _ := res.RowsAffected()
+		nDeleted = int(nRows)
 	} else {
 		delBookmark += ` WHERE id = ?`
 		delBookmarkTag += ` WHERE bookmark_id = ?`
 		delBookmarkContent += ` WHERE docid = ?`
+		delBookmarkShare += ` WHERE bookmark_id = ?`
+		insTombstone += ` WHERE id = ?`
 
 		stmtDelBookmark, _ := tx.Preparex(delBookmark)
 		stmtDelBookmarkTag, _ := tx.Preparex(delBookmarkTag)
 		stmtDelBookmarkContent, _ := tx.Preparex(delBookmarkContent)
+		stmtDelBookmarkShare, _ := tx.Preparex(delBookmarkShare)
+		stmtInsTombstone, _ := tx.Preparex(insTombstone)
 
 		for _, id := range ids {
+			stmtInsTombstone.MustExec(deletedTime, id)
 			stmtDelBookmarkContent.MustExec(id)
 			stmtDelBookmarkTag.MustExec(id)
-			stmtDelBookmark.MustExec(id)
+			stmtDelBookmarkShare.MustExec(id)
+			res := stmtDelBookmark.MustExec(id)
+			nRows, _ := res.RowsAffected()
+			nDeleted += int(nRows)
 		}
 	}
 
@@ -500,21 +999,95 @@ func (db *SQLiteDatabase) DeleteBookmarks(ids ...int) (err error) {
 	err = tx.Commit()
 	checkError(err)
 
-	return err
+	return nDeleted, err
 }
 
-// GetBookmark fetchs bookmark based on its ID or URL.
-// Returns the bookmark and boolean whether it's exist or not.
-func (db *SQLiteDatabase) GetBookmark(id int, url string) (model.Bookmark, bool) {
-	args := []interface{}{id}
-	query := `SELECT
-		b.id, b.url, b.title, b.excerpt, b.author, b.public, b.modified,
-		bc.content, bc.html, bc.content <> "" has_content
-		FROM bookmark b
-		LEFT JOIN bookmark_content bc ON bc.docid = b.id
-		WHERE b.id = ?`
+// GetTombstones fetch list of bookmarks that deleted since the specified time.
+// The tombstones are ordered from the earliest deletion to the latest.
+func (db *SQLiteDatabase) GetTombstones(since string, ownerID int) ([]model.Tombstone, error) {
+	tombstones := []model.Tombstone{}
+	args := []interface{}{since}
+	query := `SELECT id, url, deleted FROM bookmark_tombstone WHERE deleted >= ?`
 
-	if url != "" {
+	if ownerID != 0 {
+		query += ` AND owner_id = ?`
+		args = append(args, ownerID)
+	}
+
+	query += ` ORDER BY deleted, id`
+	err := db.Select(&tombstones, query, args...)
+	if err != nil && err != sql.ErrNoRows {
+		return nil, fmt.Errorf("failed to fetch tombstones: %v", err)
+	}
+
+	return tombstones, nil
+}
+
+// RebuildSearchIndex makes sure the FTS table contains exactly one record for
+// each bookmark, with up to date title. Bookmark that lost its FTS record only
+// get its title indexed, since the content is stored in the same table.
+// Returns the number of index records that corrected.
+func (db *SQLiteDatabase) RebuildSearchIndex() (nCorrected int, err error) {
+	// Prepare transaction
+	tx, err := db.Beginx()
+	if err != nil {
+		return 0, err
+	}
+
+	// Make sure to rollback if panic ever happened
+	defer func() {
+		if r := recover(); r != nil {
+			panicErr, _ := r.(error)
+			tx.Rollback()
+
+			nCorrected = 0
+			err = panicErr
+		}
+	}()
+
+	queries := []string{
+		// Remove index of bookmarks that no longer exist
+		`DELETE FROM bookmark_content
+		WHERE docid NOT IN (SELECT id FROM bookmark)`,
+
+		// Index bookmarks that missing from index
+		`INSERT INTO bookmark_content (docid, title, content, html)
+		SELECT id, title, "", "" FROM bookmark
+		WHERE id NOT IN (SELECT docid FROM bookmark_content)`,
+
+		// Fix outdated title
+		`UPDATE bookmark_content
+		SET title = (SELECT b.title FROM bookmark b WHERE b.id = docid)
+		WHERE title <> (SELECT b.title FROM bookmark b WHERE b.id = docid)`,
+	}
+
+	for _, query := range queries {
+		res := tx.MustExec(query)
+		nRows, _ := res.RowsAffected()
+		nCorrected += int(nRows)
+	}
+
+	// Merge the index segments
+	tx.MustExec(`INSERT INTO bookmark_content(bookmark_content) VALUES("optimize")`)
+
+	err = tx.Commit()
+	checkError(err)
+
+	return nCorrected, err
+}
+
+// GetBookmark fetchs bookmark based on its ID or URL.
+// Returns the bookmark and boolean whether it's exist or not.
+func (db *SQLiteDatabase) GetBookmark(id int, url string) (model.Bookmark, bool) {
+	args := []interface{}{id}
+	query := `SELECT
+		b.id, b.url, b.title, b.excerpt, b.author, b.public, b.content_type, b.content_hash, b.sort_order, b.metadata, b.last_status, b.versioned, b.created, b.modified, b.version, b.owner_id, b.is_read, b.read_at, b.starred, b.collection_id, b.link_status, b.link_checked, b.wayback_url,
+		bc.content, bc.html, bc.content <> "" has_content
+		FROM bookmark b
+		LEFT JOIN bookmark_content bc ON bc.docid = b.id
+		WHERE b.id = ?`
+
+	if url != "" {
 		query += ` OR b.url = ?`
 		args = append(args, url)
 	}
@@ -534,12 +1107,13 @@ func (db *SQLiteDatabase) SaveAccount(account model.Account) (err error) {
 	}
 
 	// Insert account to database
+	role, owner := accountRole(account)
 	_, err = db.Exec(`INSERT INTO account
-		(username, password, owner) VALUES (?, ?, ?)
+		(username, password, owner, role, provider, issuer, subject) VALUES (?, ?, ?, ?, ?, ?, ?)
 		ON CONFLICT(username) DO UPDATE SET
-		password = ?, owner = ?`,
-		account.Username, hashedPassword, account.Owner,
-		hashedPassword, account.Owner)
+		password = ?, owner = ?, role = ?`,
+		account.Username, hashedPassword, owner, role, account.Provider, account.Issuer, account.Subject,
+		hashedPassword, owner, role)
 
 	return err
 }
@@ -548,7 +1122,7 @@ func (db *SQLiteDatabase) SaveAccount(account model.Account) (err error) {
 func (db *SQLiteDatabase) GetAccounts(opts GetAccountsOptions) ([]model.Account, error) {
 	// Create query
 	args := []interface{}{}
-	query := `SELECT id, username, owner FROM account WHERE 1`
+	query := `SELECT id, username, owner, role, provider FROM account WHERE 1`
 
 	if opts.Keyword != "" {
 		query += " AND username LIKE ?"
@@ -576,12 +1150,33 @@ func (db *SQLiteDatabase) GetAccounts(opts GetAccountsOptions) ([]model.Account,
 func (db *SQLiteDatabase) GetAccount(username string) (model.Account, bool) {
 	account := model.Account{}
 	db.Get(&account, `SELECT 
-		id, username, password, owner FROM account WHERE username = ?`,
+		id, username, password, owner, role, provider, issuer, subject FROM account WHERE username = ?`,
 		username)
 
 	return account, account.ID != 0
 }
 
+// GetAccountByIdentity fetch account that linked to the user of OpenID
+// Connect provider with matching issuer and subject.
+// Returns the account and boolean whether it's exist or not.
+func (db *SQLiteDatabase) GetAccountByIdentity(issuer, subject string) (model.Account, bool) {
+	account := model.Account{}
+	db.Get(&account, `SELECT
+		id, username, password, owner, role, provider, issuer, subject FROM account
+		WHERE provider = ? AND issuer = ? AND subject = ?`,
+		model.AccountProviderOIDC, issuer, subject)
+
+	return account, account.ID != 0
+}
+
+// UpdateAccountRole changes the role of account with matching username,
+// while its password is kept.
+func (db *SQLiteDatabase) UpdateAccountRole(username string, role string) error {
+	role, owner := accountRole(model.Account{Role: role})
+	_, err := db.Exec(`UPDATE account SET role = ?, owner = ? WHERE username = ?`, role, owner, username)
+	return err
+}
+
 // DeleteAccounts removes all record with matching usernames.
 func (db *SQLiteDatabase) DeleteAccounts(usernames ...string) (err error) {
 	// Begin transaction
@@ -600,9 +1195,15 @@ func (db *SQLiteDatabase) DeleteAccounts(usernames ...string) (err error) {
 		}
 	}()
 
-	// Delete account
+	// Delete account with its API tokens and sessions
+	stmtDeleteSessions, _ := tx.Preparex(`DELETE FROM account_session
+		WHERE account_id IN (SELECT id FROM account WHERE username = ?)`)
+	stmtDeleteTokens, _ := tx.Preparex(`DELETE FROM api_token
+		WHERE account_id IN (SELECT id FROM account WHERE username = ?)`)
 	stmtDelete, _ := tx.Preparex(`DELETE FROM account WHERE username = ?`)
 	for _, username := range usernames {
+		stmtDeleteSessions.MustExec(username)
+		stmtDeleteTokens.MustExec(username)
 		stmtDelete.MustExec(username)
 	}
 
@@ -613,15 +1214,346 @@ func (db *SQLiteDatabase) DeleteAccounts(usernames ...string) (err error) {
 	return err
 }
 
-// GetTags fetch list of tags and their frequency.
-func (db *SQLiteDatabase) GetTags() ([]model.Tag, error) {
+// SaveAPIToken saves new API token in database.
+// Returns the token together with its ID.
+func (db *SQLiteDatabase) SaveAPIToken(token model.APIToken) (model.APIToken, error) {
+	token.Created = time.Now().UTC().Format("2006-01-02 15:04:05")
+	res, err := db.Exec(`INSERT INTO api_token
+		(account_id, name, token_hash, scope, created) VALUES (?, ?, ?, ?, ?)`,
+		token.AccountID, token.Name, token.Hash, token.Scope, token.Created)
+	if err != nil {
+		return model.APIToken{}, err
+	}
+
+	id, err := res.LastInsertId()
+	if err != nil {
+		return model.APIToken{}, err
+	}
+
+	token.ID = int(id)
+
+	return token, nil
+}
+
+// GetAPITokens fetch list of API tokens (without their hash) owned by the account.
+func (db *SQLiteDatabase) GetAPITokens(accountID int) ([]model.APIToken, error) {
+	tokens := []model.APIToken{}
+	err := db.Select(&tokens, `SELECT id, account_id, name, scope, created
+		FROM api_token WHERE account_id = ? ORDER BY id`, accountID)
+	if err != nil && err != sql.ErrNoRows {
+		return nil, fmt.Errorf("failed to fetch API tokens: %v", err)
+	}
+
+	return tokens, nil
+}
+
+// GetAPITokenAccount fetch API token with matching hash together with the account
+// that owns it (without its password). Returns boolean whether it's exist or not.
+func (db *SQLiteDatabase) GetAPITokenAccount(hash string) (model.APIToken, model.Account, bool) {
+	row := struct {
+		model.APIToken
+		Username string `db:"username"`
+		Owner    bool   `db:"owner"`
+		Role     string `db:"role"`
+	}{}
+
+	err := db.Get(&row, `SELECT t.id, t.account_id, t.name, t.token_hash, t.scope,
+		t.created, a.username, a.owner, a.role
+		FROM api_token t JOIN account a ON a.id = t.account_id
+		WHERE t.token_hash = ?`, hash)
+	if err != nil {
+		return model.APIToken{}, model.Account{}, false
+	}
+
+	account := model.Account{
+		ID:       row.AccountID,
+		Username: row.Username,
+		Owner:    row.Owner,
+		Role:     row.Role,
+	}
+
+	return row.APIToken, account, true
+}
+
+// DeleteAPIToken revokes API token owned by the account.
+// Returns whether the token actually deleted.
+func (db *SQLiteDatabase) DeleteAPIToken(accountID int, id int) (bool, error) {
+	res, err := db.Exec(`DELETE FROM api_token
+		WHERE account_id = ? AND id = ?`, accountID, id)
+	if err != nil {
+		return false, err
+	}
+
+	nDeleted, err := res.RowsAffected()
+	return nDeleted > 0, err
+}
+
+// SaveShareLink saves new share link of bookmark in database.
+// Returns the link together with its ID.
+func (db *SQLiteDatabase) SaveShareLink(link model.ShareLink) (model.ShareLink, error) {
+	link.Created = time.Now().UTC().Format("2006-01-02 15:04:05")
+	res, err := db.Exec(`INSERT INTO bookmark_share
+		(bookmark_id, token_hash, created) VALUES (?, ?, ?)`,
+		link.BookmarkID, link.Hash, link.Created)
+	if err != nil {
+		return model.ShareLink{}, err
+	}
+
+	id, err := res.LastInsertId()
+	if err != nil {
+		return model.ShareLink{}, err
+	}
+
+	link.ID = int(id)
+
+	return link, nil
+}
+
+// GetShareLinks fetch list of share links (without their hash) of the bookmark.
+func (db *SQLiteDatabase) GetShareLinks(bookmarkID int) ([]model.ShareLink, error) {
+	links := []model.ShareLink{}
+	err := db.Select(&links, `SELECT id, bookmark_id, created
+		FROM bookmark_share WHERE bookmark_id = ? ORDER BY id`, bookmarkID)
+	if err != nil && err != sql.ErrNoRows {
+		return nil, fmt.Errorf("failed to fetch share links: %v", err)
+	}
+
+	return links, nil
+}
+
+// GetSharedBookmark fetch bookmark that shared by the link with matching hash.
+// Returns the bookmark and boolean whether the link is exist or not.
+func (db *SQLiteDatabase) GetSharedBookmark(hash string) (model.Bookmark, bool) {
+	var bookmarkID int
+	db.Get(&bookmarkID, `SELECT bookmark_id FROM bookmark_share
+		WHERE token_hash = ?`, hash)
+	if bookmarkID == 0 {
+		return model.Bookmark{}, false
+	}
+
+	return db.GetBookmark(bookmarkID, "")
+}
+
+// DeleteShareLink revokes share link of the bookmark.
+// Returns whether the link actually deleted.
+func (db *SQLiteDatabase) DeleteShareLink(bookmarkID int, id int) (bool, error) {
+	res, err := db.Exec(`DELETE FROM bookmark_share
+		WHERE bookmark_id = ? AND id = ?`, bookmarkID, id)
+	if err != nil {
+		return false, err
+	}
+
+	nDeleted, err := res.RowsAffected()
+	return nDeleted > 0, err
+}
+
+// SaveCollection saves new or updated collection in database.
+// Returns the collection together with its ID.
+func (db *SQLiteDatabase) SaveCollection(collection model.Collection) (model.Collection, error) {
+	if collection.ID > 0 {
+		_, err := db.Exec(`UPDATE collection SET name = ?, parent_id = ? WHERE id = ?`,
+			collection.Name, collection.ParentID, collection.ID)
+		return collection, err
+	}
+
+	res, err := db.Exec(`INSERT INTO collection
+		(name, parent_id, owner_id) VALUES (?, ?, ?)`,
+		collection.Name, collection.ParentID, collection.OwnerID)
+	if err != nil {
+		return model.Collection{}, err
+	}
+
+	id, err := res.LastInsertId()
+	if err != nil {
+		return model.Collection{}, err
+	}
+
+	collection.ID = int(id)
+
+	return collection, nil
+}
+
+// GetCollections fetch list of collections owned by the account, with the
+// number of bookmarks directly inside each of them. Zero ownerID means any.
+func (db *SQLiteDatabase) GetCollections(ownerID int) ([]model.Collection, error) {
+	args := []interface{}{}
+	query := `SELECT c.id, c.name, c.parent_id, c.owner_id, COUNT(b.id) n_bookmarks
+		FROM collection c
+		LEFT JOIN bookmark b ON b.collection_id = c.id`
+
+	if ownerID != 0 {
+		query += ` WHERE c.owner_id = ?`
+		args = append(args, ownerID)
+	}
+
+	query += ` GROUP BY c.id, c.name, c.parent_id, c.owner_id
+		ORDER BY LOWER(c.name), c.id`
+
+	collections := []model.Collection{}
+	err := db.Select(&collections, query, args...)
+	if err != nil && err != sql.ErrNoRows {
+		return nil, fmt.Errorf("failed to fetch collections: %v", err)
+	}
+
+	return collections, nil
+}
+
+// DeleteCollection removes the collection. Its bookmarks and collections
+// are moved to its parent, so nothing inside it is lost.
+func (db *SQLiteDatabase) DeleteCollection(id int) (err error) {
+	// Begin transaction
+	tx, err := db.Beginx()
+	if err != nil {
+		return err
+	}
+
+	// Make sure to rollback if panic ever happened
+	defer func() {
+		if r := recover(); r != nil {
+			panicErr, _ := r.(error)
+			tx.Rollback()
+
+			err = panicErr
+		}
+	}()
+
+	var parentID int
+	err = tx.Get(&parentID, `SELECT parent_id FROM collection WHERE id = ?`, id)
+	checkError(err)
+
+	modifiedTime := time.Now().UTC().Format("2006-01-02 15:04:05")
+	tx.MustExec(`UPDATE bookmark SET collection_id = ?, modified = ?, version = version + 1 WHERE collection_id = ?`,
+		parentID, modifiedTime, id)
+	tx.MustExec(`UPDATE collection SET parent_id = ? WHERE parent_id = ?`, parentID, id)
+	tx.MustExec(`DELETE FROM collection WHERE id = ?`, id)
+
+	// Commit transaction
+	err = tx.Commit()
+	checkError(err)
+
+	return err
+}
+
+// SetBookmarksCollection moves bookmarks into the collection, or out of any
+// collection when collectionID is zero. Returns the number of moved bookmarks.
+func (db *SQLiteDatabase) SetBookmarksCollection(ids []int, collectionID int) (int, error) {
+	if len(ids) == 0 {
+		return 0, nil
+	}
+
+	modifiedTime := time.Now().UTC().Format("2006-01-02 15:04:05")
+	query, args, err := sqlx.In(`UPDATE bookmark SET collection_id = ?, modified = ?, version = version + 1
+		WHERE collection_id <> ? AND id IN (?)`, collectionID, modifiedTime, collectionID, ids)
+	if err != nil {
+		return 0, fmt.Errorf("failed to expand query: %v", err)
+	}
+
+	res, err := db.Exec(query, args...)
+	if err != nil {
+		return 0, fmt.Errorf("failed to move bookmarks: %v", err)
+	}
+
+	nRows, _ := res.RowsAffected()
+	return int(nRows), nil
+}
+
+// SaveSession saves new login session in database, after removing the
+// account's sessions that already expired. Returns the session with its ID.
+func (db *SQLiteDatabase) SaveSession(session model.Session) (model.Session, error) {
+	now := time.Now().UTC().Format("2006-01-02 15:04:05")
+	session.Created = now
+
+	tx, err := db.Beginx()
+	if err != nil {
+		return model.Session{}, err
+	}
+	defer tx.Rollback()
+
+	_, err = tx.Exec(`DELETE FROM account_session
+		WHERE account_id = ? AND expires <= ?`, session.AccountID, now)
+	if err != nil {
+		return model.Session{}, err
+	}
+
+	res, err := tx.Exec(`INSERT INTO account_session
+		(account_id, token_hash, created, expires) VALUES (?, ?, ?, ?)`,
+		session.AccountID, session.Hash, session.Created, session.Expires)
+	if err != nil {
+		return model.Session{}, err
+	}
+
+	id, err := res.LastInsertId()
+	if err != nil {
+		return model.Session{}, err
+	}
+
+	session.ID = int(id)
+
+	return session, tx.Commit()
+}
+
+// GetSessions fetch list of unexpired sessions (without their hash) of the account.
+func (db *SQLiteDatabase) GetSessions(accountID int) ([]model.Session, error) {
+	now := time.Now().UTC().Format("2006-01-02 15:04:05")
+	sessions := []model.Session{}
+	err := db.Select(&sessions, `SELECT id, account_id, created, expires
+		FROM account_session WHERE account_id = ? AND expires > ?
+		ORDER BY id`, accountID, now)
+	if err != nil && err != sql.ErrNoRows {
+		return nil, fmt.Errorf("failed to fetch sessions: %v", err)
+	}
+
+	return sessions, nil
+}
+
+// GetSessionAccount fetch unexpired session with matching ID together with its
+// account (without its password). Returns boolean whether it's exist or not.
+func (db *SQLiteDatabase) GetSessionAccount(id int) (model.Session, model.Account, bool) {
+	now := time.Now().UTC().Format("2006-01-02 15:04:05")
+	row := struct {
+		model.Session
+		Username string `db:"username"`
+		Owner    bool   `db:"owner"`
+		Role     string `db:"role"`
+	}{}
+
+	err := db.Get(&row, `SELECT s.id, s.account_id, s.token_hash, s.created,
+		s.expires, a.username, a.owner, a.role
+		FROM account_session s JOIN account a ON a.id = s.account_id
+		WHERE s.id = ? AND s.expires > ?`, id, now)
+	if err != nil {
+		return model.Session{}, model.Account{}, false
+	}
+
+	account := model.Account{
+		ID:       row.AccountID,
+		Username: row.Username,
+		Owner:    row.Owner,
+		Role:     row.Role,
+	}
+
+	return row.Session, account, true
+}
+
+// DeleteSession revokes session of the account.
+// Returns whether the session actually deleted.
+func (db *SQLiteDatabase) DeleteSession(accountID int, id int) (bool, error) {
+	res, err := db.Exec(`DELETE FROM account_session
+		WHERE account_id = ? AND id = ?`, accountID, id)
+	if err != nil {
+		return false, err
+	}
+
+	nDeleted, err := res.RowsAffected()
+	return nDeleted > 0, err
+}
+
+// GetTags fetch list of tags, their frequency and the last time they are used.
+func (db *SQLiteDatabase) GetTags(opts GetTagsOptions) ([]model.Tag, error) {
 	tags := []model.Tag{}
-	query := `SELECT bt.tag_id id, t.name, COUNT(bt.tag_id) n_bookmarks 
-		FROM bookmark_tag bt 
-		LEFT JOIN tag t ON bt.tag_id = t.id
-		GROUP BY bt.tag_id ORDER BY t.name`
+	query, args := tagsQuery(opts, `t.id`)
 
-	err := db.Select(&tags, query)
+	err := db.Select(&tags, db.Rebind(query), args...)
 	if err != nil && err != sql.ErrNoRows {
 		return nil, fmt.Errorf("failed to fetch tags: %v", err)
 	}
@@ -629,12 +1561,313 @@ func (db *SQLiteDatabase) GetTags() ([]model.Tag, error) {
 	return tags, nil
 }
 
+// GetRelatedTags fetch list of tags that used in the same bookmarks as the
+// specified tag. The number of shared bookmarks is put in NBookmarks, and
+// the tags are ordered from the most shared. When ownerID is not zero, only
+// the bookmarks owned by that account are counted.
+func (db *SQLiteDatabase) GetRelatedTags(id int, ownerID int, limit int) ([]model.Tag, error) {
+	tags := []model.Tag{}
+	args := []interface{}{id}
+	query := `SELECT t.id, t.name, COUNT(bt2.bookmark_id) n_bookmarks
+		FROM bookmark_tag bt1
+		JOIN bookmark_tag bt2 ON bt2.bookmark_id = bt1.bookmark_id AND bt2.tag_id <> bt1.tag_id
+		JOIN tag t ON t.id = bt2.tag_id
+		JOIN bookmark b ON b.id = bt1.bookmark_id
+		WHERE bt1.tag_id = ?`
+
+	if ownerID != 0 {
+		query += ` AND b.owner_id = ?`
+		args = append(args, ownerID)
+	}
+
+	query += ` GROUP BY t.id, t.name
+		ORDER BY n_bookmarks DESC, t.name
+		LIMIT ?`
+	args = append(args, limit)
+	err := db.Select(&tags, query, args...)
+	if err != nil && err != sql.ErrNoRows {
+		return nil, fmt.Errorf("failed to fetch related tags: %v", err)
+	}
+
+	return tags, nil
+}
+
 // RenameTag change the name of a tag.
-func (db *SQLiteDatabase) RenameTag(id int, newName string) error {
-	_, err := db.Exec(`UPDATE tag SET name = ? WHERE id = ?`, newName, id)
+// The bookmarks that use the tag are marked as modified as well.
+func (db *SQLiteDatabase) RenameTag(id int, newName string) (err error) {
+	// Begin transaction
+	tx, err := db.Beginx()
+	if err != nil {
+		return err
+	}
+
+	// Make sure to rollback if panic ever happened
+	defer func() {
+		if r := recover(); r != nil {
+			panicErr, _ := r.(error)
+			tx.Rollback()
+
+			err = panicErr
+		}
+	}()
+
+	modifiedTime := time.Now().UTC().Format("2006-01-02 15:04:05")
+	tx.MustExec(`UPDATE tag SET name = ? WHERE id = ?`, newName, id)
+	tx.MustExec(`UPDATE bookmark SET modified = ?, version = version + 1
+		WHERE id IN (SELECT bookmark_id FROM bookmark_tag WHERE tag_id = ?)`,
+		modifiedTime, id)
+
+	// Hierarchy of tags follows their names, so link them again
+	relinkRenamedTags(tx, newName)
+
+	// Commit transaction
+	err = tx.Commit()
+	checkError(err)
+
+	return err
+}
+
+// RenameTags change the name of several tags at once. Tags that end up with
+// the same name, either with each other or with an existing tag, are merged.
+// The bookmarks that use the tags are marked as modified as well.
+func (db *SQLiteDatabase) RenameTags(renames map[int]string) (err error) {
+	// Begin transaction
+	tx, err := db.Beginx()
+	if err != nil {
+		return err
+	}
+
+	// Make sure to rollback if panic ever happened
+	defer func() {
+		if r := recover(); r != nil {
+			panicErr, _ := r.(error)
+			tx.Rollback()
+
+			err = panicErr
+		}
+	}()
+
+	ids := make([]int, 0, len(renames))
+	for id := range renames {
+		ids = append(ids, id)
+	}
+	sort.Ints(ids)
+
+	// Move the tags out of the way first, so a tag may take the old name
+	// of other tag, e.g. when "a" is renamed to "b" while "b" is renamed to "c".
+	modifiedTime := time.Now().UTC().Format("2006-01-02 15:04:05")
+	for _, id := range ids {
+		tx.MustExec(`UPDATE tag SET name = ? WHERE id = ?`, renamingTagName(id), id)
+		tx.MustExec(`UPDATE bookmark SET modified = ?, version = version + 1
+			WHERE id IN (SELECT bookmark_id FROM bookmark_tag WHERE tag_id = ?)`,
+			modifiedTime, id)
+	}
+
+	// Rename each tag, or merge it if the new name already used
+	for _, id := range ids {
+		var existingID int
+		err = tx.Get(&existingID, `SELECT id FROM tag WHERE name = ?`, renames[id])
+		if err == sql.ErrNoRows {
+			tx.MustExec(`UPDATE tag SET name = ? WHERE id = ?`, renames[id], id)
+			continue
+		}
+		checkError(err)
+
+		tx.MustExec(`INSERT OR IGNORE INTO bookmark_tag (bookmark_id, tag_id)
+			SELECT bookmark_id, ? FROM bookmark_tag WHERE tag_id = ?`, existingID, id)
+		tx.MustExec(`DELETE FROM bookmark_tag WHERE tag_id = ?`, id)
+		tx.MustExec(`DELETE FROM tag WHERE id = ?`, id)
+	}
+
+	// Hierarchy of tags follows their names, so link them again
+	newNames := make([]string, 0, len(ids))
+	for _, id := range ids {
+		newNames = append(newNames, renames[id])
+	}
+	relinkRenamedTags(tx, newNames...)
+
+	// Commit transaction
+	err = tx.Commit()
+	checkError(err)
+
+	return err
+}
+
+// DeleteTag removes the tag from database and from every bookmark that uses
+// it, which are marked as modified. The tags nested in it are moved to the top
+// level. Returns the IDs of the bookmarks that no longer have any tag.
+func (db *SQLiteDatabase) DeleteTag(id int) (orphanIDs []int, err error) {
+	// Begin transaction
+	tx, err := db.Beginx()
+	if err != nil {
+		return nil, err
+	}
+
+	// Make sure to rollback if panic ever happened
+	defer func() {
+		if r := recover(); r != nil {
+			panicErr, _ := r.(error)
+			tx.Rollback()
+
+			orphanIDs = nil
+			err = panicErr
+		}
+	}()
+
+	// Bookmarks whose only tag is this one become orphans
+	orphanIDs = []int{}
+	err = tx.Select(&orphanIDs, `SELECT bookmark_id FROM bookmark_tag
+		WHERE bookmark_id IN (SELECT bookmark_id FROM bookmark_tag WHERE tag_id = ?)
+		GROUP BY bookmark_id
+		HAVING COUNT(tag_id) = 1
+		ORDER BY bookmark_id`, id)
+	checkError(err)
+
+	modifiedTime := time.Now().UTC().Format("2006-01-02 15:04:05")
+	tx.MustExec(`UPDATE bookmark SET modified = ?, version = version + 1
+		WHERE id IN (SELECT bookmark_id FROM bookmark_tag WHERE tag_id = ?)`,
+		modifiedTime, id)
+	tx.MustExec(`DELETE FROM bookmark_tag WHERE tag_id = ?`, id)
+	tx.MustExec(`UPDATE tag SET parent_id = 0 WHERE parent_id = ?`, id)
+	tx.MustExec(`DELETE FROM tag WHERE id = ?`, id)
+
+	// Commit transaction
+	err = tx.Commit()
+	checkError(err)
+
+	return orphanIDs, err
+}
+
+// SetBookmarksOrder sets the position of bookmarks following their order
+// in ids, starting from 1. The bookmarks are marked as modified as well, and
+// get a new version like any other change.
+func (db *SQLiteDatabase) SetBookmarksOrder(ids []int) (err error) {
+	// Begin transaction
+	tx, err := db.Beginx()
+	if err != nil {
+		return err
+	}
+
+	// Make sure to rollback if panic ever happened
+	defer func() {
+		if r := recover(); r != nil {
+			panicErr, _ := r.(error)
+			tx.Rollback()
+
+			err = panicErr
+		}
+	}()
+
+	modifiedTime := time.Now().UTC().Format("2006-01-02 15:04:05")
+	stmt, err := tx.Preparex(`UPDATE bookmark SET sort_order = ?, modified = ?, version = version + 1 WHERE id = ?`)
+	checkError(err)
+
+	for i, id := range ids {
+		stmt.MustExec(i+1, modifiedTime, id)
+	}
+
+	// Commit transaction
+	err = tx.Commit()
+	checkError(err)
+
 	return err
 }
 
+// SetBookmarksRead marks bookmarks as read or unread, and returns the number
+// of bookmarks whose status is changed. Bookmarks that already have the status
+// are left as they are, so read time of bookmarks that read before is kept.
+func (db *SQLiteDatabase) SetBookmarksRead(ids []int, read bool) (int, error) {
+	if len(ids) == 0 {
+		return 0, nil
+	}
+
+	modifiedTime := time.Now().UTC().Format("2006-01-02 15:04:05")
+
+	var readAt *string
+	if read {
+		readAt = &modifiedTime
+	}
+
+	query, args, err := sqlx.In(`UPDATE bookmark SET is_read = ?, read_at = ?, modified = ?, version = version + 1
+		WHERE is_read <> ? AND id IN (?)`, read, readAt, modifiedTime, read, ids)
+	if err != nil {
+		return 0, fmt.Errorf("failed to expand query: %v", err)
+	}
+
+	res, err := db.Exec(query, args...)
+	if err != nil {
+		return 0, fmt.Errorf("failed to set read status: %v", err)
+	}
+
+	nRows, _ := res.RowsAffected()
+	return int(nRows), nil
+}
+
+// SetBookmarkStarred stars or unstars the bookmark.
+func (db *SQLiteDatabase) SetBookmarkStarred(id int, starred bool) error {
+	modifiedTime := time.Now().UTC().Format("2006-01-02 15:04:05")
+	_, err := db.Exec(`UPDATE bookmark SET starred = ?, modified = ?, version = version + 1 WHERE id = ?`, starred, modifiedTime, id)
+	if err != nil {
+		return fmt.Errorf("failed to set starred bookmark: %v", err)
+	}
+
+	return nil
+}
+
+// SetBookmarkLinkStatus records the HTTP status code that returned when
+// the bookmark URL is checked, or zero when it couldn't be reached. The
+// modified time is kept, since the bookmark itself isn't changed.
+func (db *SQLiteDatabase) SetBookmarkLinkStatus(id int, status int) error {
+	checkedTime := time.Now().UTC().Format("2006-01-02 15:04:05")
+	_, err := db.Exec(`UPDATE bookmark SET link_status = ?, link_checked = ? WHERE id = ?`, status, checkedTime, id)
+	if err != nil {
+		return fmt.Errorf("failed to set link status: %v", err)
+	}
+
+	return nil
+}
+
+// SetTagDefaultPublic sets the visibility given to bookmarks when
+// the tag is added to them. Nil removes the default visibility.
+func (db *SQLiteDatabase) SetTagDefaultPublic(id int, public *int) error {
+	_, err := db.Exec(`UPDATE tag SET default_public = ? WHERE id = ?`, public, id)
+	if err != nil {
+		return fmt.Errorf("failed to set default visibility of tag: %v", err)
+	}
+
+	return nil
+}
+
+// SetTagMetadata sets the color, description and icon of the tag.
+func (db *SQLiteDatabase) SetTagMetadata(tag model.Tag) error {
+	_, err := db.Exec(`UPDATE tag SET color = ?, description = ?, icon = ? WHERE id = ?`,
+		tag.Color, tag.Description, tag.Icon, tag.ID)
+	if err != nil {
+		return fmt.Errorf("failed to set metadata of tag: %v", err)
+	}
+
+	return nil
+}
+
+// GetTagDefaultPublic fetch the default visibility of tags that have it,
+// keyed by the tag name.
+func (db *SQLiteDatabase) GetTagDefaultPublic() (map[string]int, error) {
+	tags := []model.Tag{}
+	query := `SELECT id, name, default_public FROM tag WHERE default_public IS NOT NULL`
+
+	err := db.Select(&tags, query)
+	if err != nil && err != sql.ErrNoRows {
+		return nil, fmt.Errorf("failed to fetch default visibility of tags: %v", err)
+	}
+
+	result := map[string]int{}
+	for _, tag := range tags {
+		result[tag.Name] = *tag.DefaultPublic
+	}
+
+	return result, nil
+}
+
 // CreateNewID creates new ID for specified table
 func (db *SQLiteDatabase) CreateNewID(table string) (int, error) {
 	var tableID int
@@ -647,3 +1880,88 @@ func (db *SQLiteDatabase) CreateNewID(table string) (int, error) {
 
 	return tableID, nil
 }
+
+// SchemaVersion returns the version of database schema.
+func (db *SQLiteDatabase) SchemaVersion() (SchemaVersion, error) {
+	return getSchemaVersion(&db.DB, sqliteMigrations)
+}
+
+// Backup writes copy of the database into new file at dstPath. It's copied
+// by the online backup API in a single step, so it's consistent even when
+// the database is changed in the meantime. VACUUM INTO is not used since
+// it's not supported by the bundled SQLite.
+func (db *SQLiteDatabase) Backup(dstPath string) (err error) {
+	// The backup API needs the raw connections, so the database file is
+	// opened again by a connection that only used for the backup
+	var srcPath string
+	rows, err := db.Queryx(`PRAGMA database_list`)
+	if err != nil {
+		return err
+	}
+
+	for rows.Next() {
+		var seq int
+		var name, file string
+		if err = rows.Scan(&seq, &name, &file); err != nil {
+			rows.Close()
+			return err
+		}
+
+		if name == "main" {
+			srcPath = file
+		}
+	}
+	rows.Close()
+
+	if srcPath == "" {
+		return ErrBackupUnsupported
+	}
+
+	// Backup is never written over existing file
+	dstFile, err := os.OpenFile(dstPath, os.O_RDWR|os.O_CREATE|os.O_EXCL, 0600)
+	if err != nil {
+		return err
+	}
+	dstFile.Close()
+
+	driver := &sqlite3.SQLiteDriver{}
+	srcConn, err := driver.Open(srcPath)
+	if err != nil {
+		return err
+	}
+	defer srcConn.Close()
+
+	dstConn, err := driver.Open(dstPath)
+	if err != nil {
+		return err
+	}
+	defer dstConn.Close()
+
+	backup, err := dstConn.(*sqlite3.SQLiteConn).Backup("main", srcConn.(*sqlite3.SQLiteConn), "main")
+	if err != nil {
+		return err
+	}
+
+	// The step is retried while the database is locked by other writer
+	for retry := 0; ; retry++ {
+		done, err := backup.Step(-1)
+		if err == nil && !done && retry == sqliteBackupRetries {
+			err = fmt.Errorf("database is still locked after %d retries", retry)
+		}
+
+		if err != nil {
+			backup.Finish()
+			return err
+		}
+
+		if done {
+			return backup.Finish()
+		}
+
+		time.Sleep(100 * time.Millisecond)
+	}
+}
+
+// sqliteBackupRetries is how many times the backup is retried while the
+// database is locked, before it's given up.
+const sqliteBackupRetries = 50
@@ -0,0 +1,1771 @@
+package database
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	fp "path/filepath"
+	"reflect"
+	"sort"
+	"testing"
+	"time"
+
+	"shiori/internal/model"
+	_ "github.com/mattn/go-sqlite3"
+)
+
+// openTestSQLiteDatabase opens a new SQLite database in temporary directory.
+// The returned function must be called to remove the database.
+func openTestSQLiteDatabase(t *testing.T) (*SQLiteDatabase, func()) {
+	tmpDir, err := ioutil.TempDir("", "shiori-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	db, err := OpenSQLiteDatabase(fp.Join(tmpDir, "shiori.db"))
+	if err != nil {
+		os.RemoveAll(tmpDir)
+		t.Fatal(err)
+	}
+
+	return db, func() {
+		db.Close()
+		os.RemoveAll(tmpDir)
+	}
+}
+
+func TestSQLiteDatabase_updatedSince(t *testing.T) {
+	db, cleanup := openTestSQLiteDatabase(t)
+	defer cleanup()
+
+	// Save two bookmarks, then delete one of them
+	before := time.Now().UTC().Add(-time.Second).Format("2006-01-02 15:04:05")
+	_, err := db.SaveBookmarks(
+		model.Bookmark{ID: 1, URL: "https://example.com/1", Title: "One"},
+		model.Bookmark{ID: 2, URL: "https://example.com/2", Title: "Two"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err = db.DeleteBookmarks(2); err != nil {
+		t.Fatal(err)
+	}
+
+	bookmarks, err := db.GetBookmarks(GetBookmarksOptions{
+		UpdatedSince: before,
+		OrderMethod:  ByFirstModified,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(bookmarks) != 1 || bookmarks[0].ID != 1 {
+		t.Errorf("GetBookmarks() = %+v, want only bookmark 1", bookmarks)
+	}
+
+	tombstones, err := db.GetTombstones(before, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(tombstones) != 1 || tombstones[0].ID != 2 || tombstones[0].URL != "https://example.com/2" {
+		t.Errorf("GetTombstones() = %+v, want only bookmark 2", tombstones)
+	}
+
+	// Nothing changed after a future cursor
+	after := time.Now().UTC().Add(time.Hour).Format("2006-01-02 15:04:05")
+	bookmarks, _ = db.GetBookmarks(GetBookmarksOptions{UpdatedSince: after})
+	tombstones, _ = db.GetTombstones(after, 0)
+	if len(bookmarks) != 0 || len(tombstones) != 0 {
+		t.Errorf("got %d bookmarks and %d tombstones after future cursor, want none",
+			len(bookmarks), len(tombstones))
+	}
+
+	// Saving the ID again removes its tombstone
+	_, err = db.SaveBookmarks(model.Bookmark{ID: 2, URL: "https://example.com/2", Title: "Two"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tombstones, _ = db.GetTombstones(before, 0)
+	if len(tombstones) != 0 {
+		t.Errorf("GetTombstones() = %+v, want none after bookmark re-saved", tombstones)
+	}
+}
+
+func TestSQLiteDatabase_GetTombstonesOwner(t *testing.T) {
+	db, cleanup := openTestSQLiteDatabase(t)
+	defer cleanup()
+
+	before := time.Now().UTC().Add(-time.Second).Format("2006-01-02 15:04:05")
+	_, err := db.SaveBookmarks(
+		model.Bookmark{ID: 1, URL: "https://example.com/alice", Title: "Alice", OwnerID: 1},
+		model.Bookmark{ID: 2, URL: "https://example.com/bob", Title: "Bob", OwnerID: 2})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err = db.DeleteBookmarks(1, 2); err != nil {
+		t.Fatal(err)
+	}
+
+	// Each account only sees the tombstones of its own bookmarks
+	tests := []struct {
+		ownerID int
+		wantIDs []int
+	}{
+		{0, []int{1, 2}},
+		{1, []int{1}},
+		{2, []int{2}},
+		{3, []int{}},
+	}
+
+	for _, tt := range tests {
+		tombstones, err := db.GetTombstones(before, tt.ownerID)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		ids := []int{}
+		for _, tombstone := range tombstones {
+			ids = append(ids, tombstone.ID)
+		}
+
+		if !reflect.DeepEqual(ids, tt.wantIDs) {
+			t.Errorf("GetTombstones(owner %d) = %v, want %v", tt.ownerID, ids, tt.wantIDs)
+		}
+	}
+}
+
+func TestSQLiteDatabase_URLPerOwner(t *testing.T) {
+	db, cleanup := openTestSQLiteDatabase(t)
+	defer cleanup()
+
+	// Every account may bookmark the same page, but only once
+	_, err := db.SaveBookmarks(
+		model.Bookmark{ID: 1, URL: "https://example.com", Title: "Alice", OwnerID: 1},
+		model.Bookmark{ID: 2, URL: "https://example.com", Title: "Bob", OwnerID: 2})
+	if err != nil {
+		t.Fatalf("SaveBookmarks() of the same URL by other owners error = %v", err)
+	}
+
+	_, err = db.SaveBookmarks(model.Bookmark{ID: 3, URL: "https://example.com", Title: "Again", OwnerID: 1})
+	if err == nil {
+		t.Errorf("SaveBookmarks() of the same URL by the same owner succeeded, want error")
+	}
+
+	bookmarks, err := db.GetBookmarks(GetBookmarksOptions{URLs: []string{"https://example.com"}, OwnerID: 2})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(bookmarks) != 1 || bookmarks[0].ID != 2 {
+		t.Errorf("GetBookmarks() by URL and owner = %+v, want only bookmark 2", bookmarks)
+	}
+}
+
+func TestSQLiteDatabase_SaveBookmarksNormalizeText(t *testing.T) {
+	db, cleanup := openTestSQLiteDatabase(t)
+	defer cleanup()
+
+	_, err := db.SaveBookmarks(model.Bookmark{
+		ID:      1,
+		URL:     "https://example.com",
+		Title:   "<b>Tom &amp; Jerry</b>\n  Episode",
+		Excerpt: "First line<br>\n\tsecond &lt;line&gt;",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	book, _ := db.GetBookmark(1, "")
+	if book.Title != "Tom & Jerry Episode" {
+		t.Errorf("stored title = %q, want %q", book.Title, "Tom & Jerry Episode")
+	}
+
+	if book.Excerpt != "First line second <line>" {
+		t.Errorf("stored excerpt = %q, want %q", book.Excerpt, "First line second <line>")
+	}
+}
+
+func TestSQLiteDatabase_ExcludedURLs(t *testing.T) {
+	db, cleanup := openTestSQLiteDatabase(t)
+	defer cleanup()
+
+	_, err := db.SaveBookmarks(
+		model.Bookmark{ID: 1, URL: "https://news.example.com/article", Title: "One"},
+		model.Bookmark{ID: 2, URL: "https://blog.test/example.com", Title: "Two"},
+		model.Bookmark{ID: 3, URL: "http://noisy.org", Title: "Three"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name         string
+		excludedURLs []string
+		wantCount    int
+	}{
+		{"no exclusion", nil, 3},
+		{"host substring", []string{"example.com"}, 2},
+		{"host without path", []string{"NOISY"}, 2},
+		{"multiple hosts", []string{"example.com", "noisy.org"}, 1},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			opts := GetBookmarksOptions{ExcludedURLs: tt.excludedURLs}
+			nBookmarks, err := db.GetBookmarksCount(opts)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if nBookmarks != tt.wantCount {
+				t.Errorf("GetBookmarksCount() = %d, want %d", nBookmarks, tt.wantCount)
+			}
+		})
+	}
+}
+
+func TestSQLiteDatabase_ScopedKeyword(t *testing.T) {
+	db, cleanup := openTestSQLiteDatabase(t)
+	defer cleanup()
+
+	_, err := db.SaveBookmarks(
+		model.Bookmark{ID: 1, URL: "https://golang.org/doc", Title: "Web server in Go"},
+		model.Bookmark{ID: 2, URL: "https://example.com/web-server", Title: "Golang tutorial"},
+		model.Bookmark{ID: 3, URL: "https://example.com/rust", Title: "Rust web server"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name      string
+		keyword   string
+		wantCount int
+	}{
+		{"title only", "title:golang", 1},
+		{"url only", "url:golang", 1},
+		{"title phrase", `title:"web server"`, 2},
+		{"title and url", `title:"web server" url:example`, 1},
+		{"unscoped", "golang", 2},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			opts := GetBookmarksOptions{}
+			ParseKeyword(tt.keyword, &opts)
+			nBookmarks, err := db.GetBookmarksCount(opts)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if nBookmarks != tt.wantCount {
+				t.Errorf("GetBookmarksCount() = %d, want %d", nBookmarks, tt.wantCount)
+			}
+		})
+	}
+}
+
+func TestSQLiteDatabase_SearchFields(t *testing.T) {
+	db, cleanup := openTestSQLiteDatabase(t)
+	defer cleanup()
+
+	_, err := db.SaveBookmarks(
+		model.Bookmark{ID: 1, URL: "https://example.com/1", Title: "Golang tips"},
+		model.Bookmark{ID: 2, URL: "https://example.com/2", Title: "Tips", Excerpt: "About golang"},
+		model.Bookmark{ID: 3, URL: "https://example.com/3", Title: "Tricks", Content: "Written in golang"},
+		model.Bookmark{ID: 4, URL: "https://golang.org", Title: "Home"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name         string
+		searchFields []string
+		wantIDs      []int
+	}{
+		{"all fields", nil, []int{1, 2, 3, 4}},
+		{"title only", []string{"title"}, []int{1}},
+		{"title and excerpt", []string{"title", "excerpt"}, []int{1, 2}},
+		{"content only", []string{"content"}, []int{3}},
+		{"url only", []string{"url"}, []int{4}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			bookmarks, err := db.GetBookmarks(GetBookmarksOptions{
+				Keyword:      "golang",
+				SearchFields: tt.searchFields,
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			ids := []int{}
+			for _, book := range bookmarks {
+				ids = append(ids, book.ID)
+			}
+
+			if !reflect.DeepEqual(ids, tt.wantIDs) {
+				t.Errorf("GetBookmarks() IDs = %v, want %v", ids, tt.wantIDs)
+			}
+		})
+	}
+}
+
+func TestSQLiteDatabase_Untagged(t *testing.T) {
+	db, cleanup := openTestSQLiteDatabase(t)
+	defer cleanup()
+
+	_, err := db.SaveBookmarks(
+		model.Bookmark{ID: 1, URL: "https://example.com/1", Title: "Golang tips", Tags: []model.Tag{{Name: "go"}}},
+		model.Bookmark{ID: 2, URL: "https://example.com/2", Title: "Golang tricks"},
+		model.Bookmark{ID: 3, URL: "https://example.com/3", Title: "Rust tips"},
+		model.Bookmark{ID: 4, URL: "https://example.com/4", Title: "Old golang", Created: "2010-05-01 10:00:00"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name    string
+		opts    GetBookmarksOptions
+		wantIDs []int
+	}{
+		{"untagged", GetBookmarksOptions{Untagged: true}, []int{2, 3, 4}},
+		{"with keyword", GetBookmarksOptions{Untagged: true, Keyword: "golang"}, []int{2, 4}},
+		{"with created time", GetBookmarksOptions{Untagged: true, Keyword: "golang", CreatedSince: "2015-01-01 00:00:00"}, []int{2}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			bookmarks, err := db.GetBookmarks(tt.opts)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			ids := []int{}
+			for _, book := range bookmarks {
+				ids = append(ids, book.ID)
+			}
+
+			if !reflect.DeepEqual(ids, tt.wantIDs) {
+				t.Errorf("GetBookmarks() IDs = %v, want %v", ids, tt.wantIDs)
+			}
+
+			count, err := db.GetBookmarksCount(tt.opts)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if count != len(tt.wantIDs) {
+				t.Errorf("GetBookmarksCount() = %d, want %d", count, len(tt.wantIDs))
+			}
+		})
+	}
+}
+
+func TestSQLiteDatabase_PublicOnly(t *testing.T) {
+	db, cleanup := openTestSQLiteDatabase(t)
+	defer cleanup()
+
+	_, err := db.SaveBookmarks(
+		model.Bookmark{ID: 1, URL: "https://example.com/1", Title: "Public", Public: 1, Tags: []model.Tag{{Name: "go"}}},
+		model.Bookmark{ID: 2, URL: "https://example.com/2", Title: "Private", Tags: []model.Tag{{Name: "go"}}},
+		model.Bookmark{ID: 3, URL: "https://example.com/3", Title: "Public untagged", Public: 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name    string
+		opts    GetBookmarksOptions
+		wantIDs []int
+	}{
+		{"public", GetBookmarksOptions{PublicOnly: true}, []int{1, 3}},
+		{"public with tag", GetBookmarksOptions{PublicOnly: true, Tags: []string{"go"}}, []int{1}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			bookmarks, err := db.GetBookmarks(tt.opts)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			ids := []int{}
+			for _, book := range bookmarks {
+				ids = append(ids, book.ID)
+			}
+
+			if !reflect.DeepEqual(ids, tt.wantIDs) {
+				t.Errorf("GetBookmarks() IDs = %v, want %v", ids, tt.wantIDs)
+			}
+		})
+	}
+}
+
+func TestSQLiteDatabase_SetBookmarksRead(t *testing.T) {
+	db, cleanup := openTestSQLiteDatabase(t)
+	defer cleanup()
+
+	_, err := db.SaveBookmarks(
+		model.Bookmark{ID: 1, URL: "https://example.com/1", Title: "One"},
+		model.Bookmark{ID: 2, URL: "https://example.com/2", Title: "Two"},
+		model.Bookmark{ID: 3, URL: "https://example.com/3", Title: "Three"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Bookmarks that already read are left as they are
+	for _, ids := range [][]int{{1, 2}, {2, 3}} {
+		if _, err := db.SetBookmarksRead(ids, true); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	nChanged, err := db.SetBookmarksRead([]int{2, 3}, true)
+	if err != nil || nChanged != 0 {
+		t.Errorf("SetBookmarksRead() of read bookmarks = %d, %v, want 0", nChanged, err)
+	}
+
+	nChanged, err = db.SetBookmarksRead([]int{3}, false)
+	if err != nil || nChanged != 1 {
+		t.Errorf("SetBookmarksRead() = %d, %v, want 1", nChanged, err)
+	}
+
+	read, unread := true, false
+	tests := []struct {
+		name    string
+		opts    GetBookmarksOptions
+		wantIDs []int
+	}{
+		{"read", GetBookmarksOptions{Read: &read}, []int{1, 2}},
+		{"unread", GetBookmarksOptions{Read: &unread}, []int{3}},
+		{"both", GetBookmarksOptions{}, []int{1, 2, 3}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			bookmarks, err := db.GetBookmarks(tt.opts)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			ids := []int{}
+			for _, book := range bookmarks {
+				ids = append(ids, book.ID)
+			}
+
+			if !reflect.DeepEqual(ids, tt.wantIDs) {
+				t.Errorf("GetBookmarks() IDs = %v, want %v", ids, tt.wantIDs)
+			}
+		})
+	}
+
+	book, _ := db.GetBookmark(1, "")
+	if !book.Read || book.ReadAt == nil {
+		t.Errorf("GetBookmark() read = %v, want read with its time", book.Read)
+	}
+
+	book, _ = db.GetBookmark(3, "")
+	if book.Read || book.ReadAt != nil {
+		t.Errorf("GetBookmark() of unread bookmark read = %v, want unread without time", book.Read)
+	}
+}
+
+func TestSQLiteDatabase_SetBookmarkStarred(t *testing.T) {
+	db, cleanup := openTestSQLiteDatabase(t)
+	defer cleanup()
+
+	_, err := db.SaveBookmarks(
+		model.Bookmark{ID: 1, URL: "https://example.com/1", Title: "One", Tags: []model.Tag{{Name: "go"}}},
+		model.Bookmark{ID: 2, URL: "https://example.com/2", Title: "Two"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err = db.SetBookmarkStarred(2, true); err != nil {
+		t.Fatal(err)
+	}
+
+	bookmarks, err := db.GetBookmarks(GetBookmarksOptions{Starred: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(bookmarks) != 1 || bookmarks[0].ID != 2 || !bookmarks[0].Starred {
+		t.Errorf("GetBookmarks() of starred = %+v, want only bookmark 2", bookmarks)
+	}
+
+	// Saving bookmark again doesn't unstar it
+	_, err = db.SaveBookmarks(model.Bookmark{ID: 2, URL: "https://example.com/2", Title: "Second"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if book, _ := db.GetBookmark(2, ""); !book.Starred {
+		t.Errorf("GetBookmark() starred = false after saved, want true")
+	}
+
+	if err = db.SetBookmarkStarred(2, false); err != nil {
+		t.Fatal(err)
+	}
+
+	if nBookmarks, _ := db.GetBookmarksCount(GetBookmarksOptions{Starred: true}); nBookmarks != 0 {
+		t.Errorf("GetBookmarksCount() of starred = %d after unstarred, want 0", nBookmarks)
+	}
+}
+
+func TestSQLiteDatabase_StatusChangeVersion(t *testing.T) {
+	db, cleanup := openTestSQLiteDatabase(t)
+	defer cleanup()
+
+	collection, err := db.SaveCollection(model.Collection{Name: "Work"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = db.SaveBookmarks(model.Bookmark{ID: 1, URL: "https://example.com/1", Title: "One",
+		Tags: []model.Tag{{Name: "go"}, {Name: "web"}}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tagID := func(name string) int {
+		tags, _ := db.GetTags(GetTagsOptions{})
+		for _, tag := range tags {
+			if tag.Name == name {
+				return tag.ID
+			}
+		}
+		return 0
+	}
+
+	// Steps run in order, since each of them changes the same bookmark
+	steps := []struct {
+		name   string
+		change func() error
+	}{
+		{"read", func() error { _, err := db.SetBookmarksRead([]int{1}, true); return err }},
+		{"starred", func() error { return db.SetBookmarkStarred(1, true) }},
+		{"moved to collection", func() error { _, err := db.SetBookmarksCollection([]int{1}, collection.ID); return err }},
+		{"collection deleted", func() error { return db.DeleteCollection(collection.ID) }},
+		{"tag renamed", func() error { return db.RenameTag(tagID("go"), "golang") }},
+		{"tags renamed", func() error { return db.RenameTags(map[int]string{tagID("golang"): "go"}) }},
+		{"tag deleted", func() error { _, err := db.DeleteTag(tagID("web")); return err }},
+	}
+
+	for i, step := range steps {
+		if err := step.change(); err != nil {
+			t.Fatalf("%s: %v", step.name, err)
+		}
+
+		// Version starts from 1 once the bookmark is inserted
+		if book, _ := db.GetBookmark(1, ""); book.Version != i+2 {
+			t.Errorf("version after %s = %d, want %d", step.name, book.Version, i+2)
+		}
+	}
+}
+
+func TestSQLiteDatabase_SetBookmarkLinkStatus(t *testing.T) {
+	db, cleanup := openTestSQLiteDatabase(t)
+	defer cleanup()
+
+	_, err := db.SaveBookmarks(
+		model.Bookmark{ID: 1, URL: "https://example.com/1", Title: "Unchecked"},
+		model.Bookmark{ID: 2, URL: "https://example.com/2", Title: "Fine"},
+		model.Bookmark{ID: 3, URL: "https://example.com/3", Title: "Not found"},
+		model.Bookmark{ID: 4, URL: "https://example.com/4", Title: "Unreachable"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	before, _ := db.GetBookmark(3, "")
+	for id, status := range map[int]int{2: 200, 3: 404, 4: 0} {
+		if err = db.SetBookmarkLinkStatus(id, status); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	bookmarks, err := db.GetBookmarks(GetBookmarksOptions{Broken: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(bookmarks) != 2 || bookmarks[0].ID != 3 || bookmarks[1].ID != 4 {
+		t.Fatalf("GetBookmarks() of broken = %+v, want bookmarks 3 and 4", bookmarks)
+	}
+
+	if bookmarks[0].LinkStatus != 404 || bookmarks[0].LinkChecked == nil {
+		t.Errorf("link status = %d checked at %v, want 404 with checked time",
+			bookmarks[0].LinkStatus, bookmarks[0].LinkChecked)
+	}
+
+	// Checking the link doesn't modify the bookmark
+	if bookmarks[0].Modified != before.Modified {
+		t.Errorf("modified = %q after checked, want %q", bookmarks[0].Modified, before.Modified)
+	}
+
+	if book, _ := db.GetBookmark(1, ""); book.LinkChecked != nil {
+		t.Errorf("unchecked bookmark has checked time %q", *book.LinkChecked)
+	}
+}
+
+func TestSQLiteDatabase_WaybackURL(t *testing.T) {
+	db, cleanup := openTestSQLiteDatabase(t)
+	defer cleanup()
+
+	snapshotURL := "https://web.archive.org/web/20200101000000if_/https://example.com/1"
+	_, err := db.SaveBookmarks(model.Bookmark{ID: 1, URL: "https://example.com/1", Title: "Gone", WaybackURL: snapshotURL})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if book, _ := db.GetBookmark(1, ""); book.WaybackURL != snapshotURL {
+		t.Errorf("GetBookmark() wayback URL = %q, want %q", book.WaybackURL, snapshotURL)
+	}
+
+	// Archived again from the page itself
+	_, err = db.SaveBookmarks(model.Bookmark{ID: 1, URL: "https://example.com/1", Title: "Back"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	bookmarks, _ := db.GetBookmarks(GetBookmarksOptions{IDs: []int{1}})
+	if len(bookmarks) != 1 || bookmarks[0].WaybackURL != "" {
+		t.Errorf("GetBookmarks() = %+v, want bookmark without wayback URL", bookmarks)
+	}
+}
+
+func TestSQLiteDatabase_Collections(t *testing.T) {
+	db, cleanup := openTestSQLiteDatabase(t)
+	defer cleanup()
+
+	// Create collection nested in another one
+	parent, err := db.SaveCollection(model.Collection{Name: "Work", OwnerID: 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	child, err := db.SaveCollection(model.Collection{Name: "Projects", ParentID: parent.ID, OwnerID: 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = db.SaveBookmarks(
+		model.Bookmark{ID: 1, URL: "https://example.com/1", Title: "One", CollectionID: child.ID},
+		model.Bookmark{ID: 2, URL: "https://example.com/2", Title: "Two"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	nMoved, err := db.SetBookmarksCollection([]int{1, 2}, child.ID)
+	if err != nil || nMoved != 1 {
+		t.Errorf("SetBookmarksCollection() = %d, %v, want 1 moved", nMoved, err)
+	}
+
+	bookmarks, _ := db.GetBookmarks(GetBookmarksOptions{CollectionIDs: []int{child.ID}})
+	if len(bookmarks) != 2 || bookmarks[0].CollectionID != child.ID {
+		t.Errorf("GetBookmarks() in collection = %+v, want both bookmarks", bookmarks)
+	}
+
+	collections, err := db.GetCollections(1)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := []model.Collection{
+		{ID: child.ID, Name: "Projects", ParentID: parent.ID, OwnerID: 1, NBookmarks: 2},
+		{ID: parent.ID, Name: "Work", OwnerID: 1},
+	}
+
+	if !reflect.DeepEqual(collections, want) {
+		t.Errorf("GetCollections() = %+v, want %+v", collections, want)
+	}
+
+	if collections, _ = db.GetCollections(2); len(collections) != 0 {
+		t.Errorf("GetCollections() of other account = %+v, want none", collections)
+	}
+
+	// Deleting collection moves its bookmarks to its parent
+	if err = db.DeleteCollection(child.ID); err != nil {
+		t.Fatal(err)
+	}
+
+	collections, _ = db.GetCollections(0)
+	want = []model.Collection{{ID: parent.ID, Name: "Work", OwnerID: 1, NBookmarks: 2}}
+	if !reflect.DeepEqual(collections, want) {
+		t.Errorf("GetCollections() after deleted = %+v, want %+v", collections, want)
+	}
+
+	// Saving bookmark again doesn't move it
+	_, err = db.SaveBookmarks(model.Bookmark{ID: 2, URL: "https://example.com/2", Title: "Second"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if book, _ := db.GetBookmark(2, ""); book.CollectionID != parent.ID {
+		t.Errorf("GetBookmark() collection = %d after saved, want %d", book.CollectionID, parent.ID)
+	}
+}
+
+func TestSQLiteDatabase_OrderMethods(t *testing.T) {
+	db, cleanup := openTestSQLiteDatabase(t)
+	defer cleanup()
+
+	_, err := db.SaveBookmarks(
+		model.Bookmark{ID: 1, URL: "https://zeta.com/post", Title: "beta", Created: "2020-01-02 00:00:00"},
+		model.Bookmark{ID: 2, URL: "http://alpha.org", Title: "Gamma", Created: "2020-01-03 00:00:00"},
+		model.Bookmark{ID: 3, URL: "https://zeta.com/about", Title: "Alpha", Created: "2020-01-01 00:00:00"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name    string
+		order   OrderMethod
+		wantIDs []int
+	}{
+		{"last added", ByLastAdded, []int{2, 1, 3}},
+		{"first added", ByFirstAdded, []int{3, 1, 2}},
+		{"title", ByTitle, []int{3, 1, 2}},
+		{"domain", ByDomain, []int{2, 3, 1}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			bookmarks, err := db.GetBookmarks(GetBookmarksOptions{OrderMethod: tt.order})
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			ids := []int{}
+			for _, book := range bookmarks {
+				ids = append(ids, book.ID)
+			}
+
+			if !reflect.DeepEqual(ids, tt.wantIDs) {
+				t.Errorf("GetBookmarks() IDs = %v, want %v", ids, tt.wantIDs)
+			}
+		})
+	}
+}
+
+func TestSQLiteDatabase_DateRange(t *testing.T) {
+	db, cleanup := openTestSQLiteDatabase(t)
+	defer cleanup()
+
+	_, err := db.SaveBookmarks(
+		model.Bookmark{ID: 1, URL: "https://example.com/1", Title: "February", Created: "2023-02-28 23:59:59"},
+		model.Bookmark{ID: 2, URL: "https://example.com/2", Title: "March", Created: "2023-03-01 00:00:00"},
+		model.Bookmark{ID: 3, URL: "https://example.com/3", Title: "Late March", Created: "2023-03-31 23:59:59"},
+		model.Bookmark{ID: 4, URL: "https://example.com/4", Title: "April", Created: "2023-04-01 00:00:00"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name    string
+		opts    GetBookmarksOptions
+		wantIDs []int
+	}{
+		{"created in March", GetBookmarksOptions{
+			CreatedSince:  "2023-03-01 00:00:00",
+			CreatedBefore: "2023-04-01 00:00:00",
+		}, []int{2, 3}},
+		{"created before March", GetBookmarksOptions{CreatedBefore: "2023-03-01 00:00:00"}, []int{1}},
+		{"modified in the past", GetBookmarksOptions{UpdatedBefore: "2000-01-01 00:00:00"}, []int{}},
+		{"modified until now", GetBookmarksOptions{UpdatedBefore: "9999-01-01 00:00:00"}, []int{1, 2, 3, 4}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			bookmarks, err := db.GetBookmarks(tt.opts)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			ids := []int{}
+			for _, book := range bookmarks {
+				ids = append(ids, book.ID)
+			}
+
+			if !reflect.DeepEqual(ids, tt.wantIDs) {
+				t.Errorf("GetBookmarks() IDs = %v, want %v", ids, tt.wantIDs)
+			}
+		})
+	}
+}
+
+func TestSQLiteDatabase_CreatedTime(t *testing.T) {
+	db, cleanup := openTestSQLiteDatabase(t)
+	defer cleanup()
+
+	_, err := db.SaveBookmarks(
+		model.Bookmark{ID: 1, URL: "https://example.com/1", Title: "Old", Created: "2010-05-01 10:00:00"},
+		model.Bookmark{ID: 2, URL: "https://example.com/2", Title: "New"},
+		model.Bookmark{ID: 3, URL: "https://example.com/3", Title: "Older", Created: "2005-05-01T10:00:00Z"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Updating bookmark must not change its created time
+	book, _ := db.GetBookmark(1, "")
+	book.Created = ""
+	if _, err = db.SaveBookmarks(book); err != nil {
+		t.Fatal(err)
+	}
+
+	bookmarks, err := db.GetBookmarks(GetBookmarksOptions{OrderMethod: ByLastAdded})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	gotIDs := []int{}
+	for _, book := range bookmarks {
+		gotIDs = append(gotIDs, book.ID)
+	}
+
+	if !reflect.DeepEqual(gotIDs, []int{2, 1, 3}) {
+		t.Errorf("GetBookmarks() order = %v, want [2 1 3]", gotIDs)
+	}
+
+	if bookmarks[1].Created != "2010-05-01 10:00:00" || bookmarks[2].Created != "2005-05-01 10:00:00" {
+		t.Errorf("GetBookmarks() created = %q and %q, want the imported time",
+			bookmarks[1].Created, bookmarks[2].Created)
+	}
+}
+
+func TestSQLiteDatabase_GetRelatedTags(t *testing.T) {
+	db, cleanup := openTestSQLiteDatabase(t)
+	defer cleanup()
+
+	tags := func(names ...string) []model.Tag {
+		result := []model.Tag{}
+		for _, name := range names {
+			result = append(result, model.Tag{Name: name})
+		}
+		return result
+	}
+
+	_, err := db.SaveBookmarks(
+		model.Bookmark{ID: 1, URL: "https://example.com/1", Title: "One", Tags: tags("go", "web", "tutorial")},
+		model.Bookmark{ID: 2, URL: "https://example.com/2", Title: "Two", Tags: tags("go", "web")},
+		model.Bookmark{ID: 3, URL: "https://example.com/3", Title: "Three", Tags: tags("go", "cli")},
+		model.Bookmark{ID: 4, URL: "https://example.com/4", Title: "Four", Tags: tags("rust", "cli")},
+		model.Bookmark{ID: 5, URL: "https://example.com/5", Title: "Five", Tags: tags("go", "rust"), OwnerID: 7})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	allTags, err := db.GetTags(GetTagsOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tagIDs := map[string]int{}
+	for _, tag := range allTags {
+		tagIDs[tag.Name] = tag.ID
+	}
+
+	tests := []struct {
+		name  string
+		tag   string
+		owner int
+		limit int
+		want  []string
+	}{
+		{"ranked by count then name", "go", 0, 10, []string{"web:2", "cli:1", "rust:1", "tutorial:1"}},
+		{"limited", "go", 0, 1, []string{"web:2"}},
+		{"other tag", "rust", 0, 10, []string{"cli:1", "go:1"}},
+		{"unknown tag", "", 0, 10, []string{}},
+		{"other account", "go", 7, 10, []string{"rust:1"}},
+		{"account without the tag", "rust", 8, 10, []string{}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			related, err := db.GetRelatedTags(tagIDs[tt.tag], tt.owner, tt.limit)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			got := []string{}
+			for _, tag := range related {
+				got = append(got, fmt.Sprintf("%s:%d", tag.Name, tag.NBookmarks))
+			}
+
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("GetRelatedTags() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestSQLiteDatabase_GetTagsLastUsed(t *testing.T) {
+	db, cleanup := openTestSQLiteDatabase(t)
+	defer cleanup()
+
+	tags := func(names ...string) []model.Tag {
+		result := []model.Tag{}
+		for _, name := range names {
+			result = append(result, model.Tag{Name: name})
+		}
+		return result
+	}
+
+	_, err := db.SaveBookmarks(
+		model.Bookmark{ID: 1, URL: "https://example.com/1", Title: "One", Tags: tags("go"), Created: "2020-01-01 00:00:00"},
+		model.Bookmark{ID: 2, URL: "https://example.com/2", Title: "Two", Tags: tags("go"), Created: "2020-03-01 00:00:00"},
+		model.Bookmark{ID: 3, URL: "https://example.com/3", Title: "Three", Tags: tags("stale"), Created: "2020-02-01 00:00:00"},
+		model.Bookmark{ID: 4, URL: "https://example.com/4", Title: "Four", Tags: tags("web"), Created: "2020-04-01 00:00:00"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Tag of deleted bookmark is no longer used
+	if _, err = db.DeleteBookmarks(3); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name string
+		opts GetTagsOptions
+		want []string
+	}{
+		{"by name", GetTagsOptions{}, []string{"go:2:2020-03-01 00:00:00", "web:1:2020-04-01 00:00:00"}},
+		{"by last used", GetTagsOptions{OrderMethod: TagsByLastUsed},
+			[]string{"web:1:2020-04-01 00:00:00", "go:2:2020-03-01 00:00:00"}},
+		{"with unused", GetTagsOptions{WithUnused: true},
+			[]string{"go:2:2020-03-01 00:00:00", "stale:0:", "web:1:2020-04-01 00:00:00"}},
+		{"unused ordered last", GetTagsOptions{WithUnused: true, OrderMethod: TagsByLastUsed},
+			[]string{"web:1:2020-04-01 00:00:00", "go:2:2020-03-01 00:00:00", "stale:0:"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			allTags, err := db.GetTags(tt.opts)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			got := []string{}
+			for _, tag := range allTags {
+				lastUsed := ""
+				if tag.LastUsed != nil {
+					lastUsed = *tag.LastUsed
+				}
+				got = append(got, fmt.Sprintf("%s:%d:%s", tag.Name, tag.NBookmarks, lastUsed))
+			}
+
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("GetTags() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestSQLiteDatabase_ContentType(t *testing.T) {
+	db, cleanup := openTestSQLiteDatabase(t)
+	defer cleanup()
+
+	_, err := db.SaveBookmarks(
+		model.Bookmark{ID: 1, URL: "https://example.com/1", Title: "Page", ContentType: "text/html"},
+		model.Bookmark{ID: 2, URL: "https://example.com/2", Title: "Paper", ContentType: "application/pdf"},
+		model.Bookmark{ID: 3, URL: "https://example.com/3", Title: "Photo", ContentType: "image/png"},
+		model.Bookmark{ID: 4, URL: "https://example.com/4", Title: "Icon", ContentType: "image/svg+xml"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name        string
+		contentType string
+		wantCount   int
+	}{
+		{"no filter", "", 4},
+		{"exact type", "application/pdf", 1},
+		{"case insensitive", "Text/HTML", 1},
+		{"wildcard subtype", "image/*", 2},
+		{"unknown type", "video/*", 0},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			opts := GetBookmarksOptions{ContentType: tt.contentType}
+			nBookmarks, err := db.GetBookmarksCount(opts)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if nBookmarks != tt.wantCount {
+				t.Errorf("GetBookmarksCount() = %d, want %d", nBookmarks, tt.wantCount)
+			}
+		})
+	}
+
+	book, _ := db.GetBookmark(2, "")
+	if book.ContentType != "application/pdf" {
+		t.Errorf("GetBookmark() content type = %q, want application/pdf", book.ContentType)
+	}
+}
+
+func TestSQLiteDatabase_RenameTags(t *testing.T) {
+	tags := func(names ...string) []model.Tag {
+		result := []model.Tag{}
+		for _, name := range names {
+			result = append(result, model.Tag{Name: name})
+		}
+		return result
+	}
+
+	tests := []struct {
+		name    string
+		renames map[string]string
+		want    []string
+	}{
+		{
+			name:    "rename without collision",
+			renames: map[string]string{"javasript/web": "javascript/web"},
+			want:    []string{"a:1", "b:2", "javascript/node:1", "javascript/web:2", "javasript/node:1"},
+		},
+		{
+			name:    "merge with existing tag",
+			renames: map[string]string{"javasript/node": "javascript/node", "javasript/web": "javascript/web"},
+			want:    []string{"a:1", "b:2", "javascript/node:2", "javascript/web:2"},
+		},
+		{
+			name:    "merge renamed tags",
+			renames: map[string]string{"a": "c", "b": "c"},
+			want:    []string{"c:2", "javascript/node:1", "javasript/node:1", "javasript/web:2"},
+		},
+		{
+			name:    "take name of renamed tag",
+			renames: map[string]string{"a": "b", "b": "c"},
+			want:    []string{"b:1", "c:2", "javascript/node:1", "javasript/node:1", "javasript/web:2"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			db, cleanup := openTestSQLiteDatabase(t)
+			defer cleanup()
+
+			_, err := db.SaveBookmarks(
+				model.Bookmark{ID: 1, URL: "https://example.com/1", Title: "One", Tags: tags("javasript/web", "javasript/node", "a", "b")},
+				model.Bookmark{ID: 2, URL: "https://example.com/2", Title: "Two", Tags: tags("javasript/web", "javascript/node", "b")})
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			allTags, err := db.GetTags(GetTagsOptions{})
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			renames := map[int]string{}
+			for _, tag := range allTags {
+				if newName, ok := tt.renames[tag.Name]; ok {
+					renames[tag.ID] = newName
+				}
+			}
+
+			if err = db.RenameTags(renames); err != nil {
+				t.Fatalf("RenameTags() error = %v", err)
+			}
+
+			allTags, err = db.GetTags(GetTagsOptions{})
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			got := []string{}
+			for _, tag := range allTags {
+				got = append(got, fmt.Sprintf("%s:%d", tag.Name, tag.NBookmarks))
+			}
+
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("tags after RenameTags() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestSQLiteDatabase_HierarchicalTags(t *testing.T) {
+	db, cleanup := openTestSQLiteDatabase(t)
+	defer cleanup()
+
+	_, err := db.SaveBookmarks(
+		model.Bookmark{ID: 1, URL: "https://example.com/1", Title: "One", Tags: []model.Tag{{Name: "dev/go/web"}}},
+		model.Bookmark{ID: 2, URL: "https://example.com/2", Title: "Two", Tags: []model.Tag{{Name: "dev"}}},
+		model.Bookmark{ID: 3, URL: "https://example.com/3", Title: "Three", Tags: []model.Tag{{Name: "devops"}}},
+		model.Bookmark{ID: 4, URL: "https://example.com/4", Title: "Four", Tags: []model.Tag{{Name: "dev_x/go"}}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Parents of nested tag are created and linked
+	parents := func() map[string]string {
+		allTags, err := db.GetTags(GetTagsOptions{WithUnused: true})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		names := map[int]string{}
+		for _, tag := range allTags {
+			names[tag.ID] = tag.Name
+		}
+
+		result := map[string]string{}
+		for _, tag := range allTags {
+			result[tag.Name] = names[tag.ParentID]
+		}
+		return result
+	}
+
+	want := map[string]string{"dev": "", "dev/go": "dev", "dev/go/web": "dev/go",
+		"devops": "", "dev_x": "", "dev_x/go": "dev_x"}
+	if got := parents(); !reflect.DeepEqual(got, want) {
+		t.Errorf("tag parents = %v, want %v", got, want)
+	}
+
+	// Tag filter includes the nested tags
+	tests := []struct {
+		opts GetBookmarksOptions
+		want []int
+	}{
+		{GetBookmarksOptions{Tags: []string{"dev"}}, []int{1, 2}},
+		{GetBookmarksOptions{Tags: []string{"dev/go"}}, []int{1}},
+		{GetBookmarksOptions{Tags: []string{"dev", "dev/go"}}, []int{1}},
+		{GetBookmarksOptions{ExcludedTags: []string{"dev"}}, []int{3, 4}},
+		{GetBookmarksOptions{ExcludedTags: []string{"dev/go"}}, []int{2, 3, 4}},
+		{GetBookmarksOptions{AnyTags: []string{"dev/go", "devops"}}, []int{1, 3}},
+		{GetBookmarksOptions{AnyTags: []string{"dev_x"}, Tags: []string{"dev"}}, []int{}},
+	}
+
+	for _, tt := range tests {
+		bookmarks, err := db.GetBookmarks(tt.opts)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		got := []int{}
+		for _, book := range bookmarks {
+			got = append(got, book.ID)
+		}
+
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("GetBookmarks(%+v) = %v, want %v", tt.opts, got, tt.want)
+		}
+	}
+
+	// Renamed tag is linked to its new parent
+	allTags, err := db.GetTags(GetTagsOptions{WithUnused: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, tag := range allTags {
+		if tag.Name == "devops" {
+			if err = db.RenameTag(tag.ID, "dev/ops"); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+
+	if got := parents()["dev/ops"]; got != "dev" {
+		t.Errorf("parent of renamed tag = %q, want dev", got)
+	}
+}
+
+func TestSQLiteDatabase_DeleteTag(t *testing.T) {
+	db, cleanup := openTestSQLiteDatabase(t)
+	defer cleanup()
+
+	_, err := db.SaveBookmarks(
+		model.Bookmark{ID: 1, URL: "https://example.com/1", Title: "One", Tags: []model.Tag{{Name: "dev"}}},
+		model.Bookmark{ID: 2, URL: "https://example.com/2", Title: "Two", Tags: []model.Tag{{Name: "dev"}, {Name: "go"}}},
+		model.Bookmark{ID: 3, URL: "https://example.com/3", Title: "Three", Tags: []model.Tag{{Name: "dev/web"}}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tagID := func(name string) int {
+		tags, err := db.GetTags(GetTagsOptions{WithUnused: true})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		for _, tag := range tags {
+			if tag.Name == name {
+				return tag.ID
+			}
+		}
+		return 0
+	}
+
+	orphanIDs, err := db.DeleteTag(tagID("dev"))
+	if err != nil {
+		t.Fatalf("DeleteTag() error = %v", err)
+	}
+
+	if !reflect.DeepEqual(orphanIDs, []int{1}) {
+		t.Errorf("DeleteTag() orphans = %v, want [1]", orphanIDs)
+	}
+
+	if tagID("dev") != 0 {
+		t.Errorf("tag dev still exists after DeleteTag()")
+	}
+
+	bookmarks, err := db.GetBookmarks(GetBookmarksOptions{IDs: []int{2}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(bookmarks) != 1 || len(bookmarks[0].Tags) != 1 || bookmarks[0].Tags[0].Name != "go" {
+		t.Errorf("bookmark 2 = %+v, want only tag go", bookmarks)
+	}
+
+	// Nested tag is kept at the top level, without bringing the deleted tag back
+	tags, _ := db.GetTags(GetTagsOptions{WithUnused: true})
+	names := []string{}
+	for _, tag := range tags {
+		names = append(names, tag.Name)
+		if tag.Name == "dev/web" && (tag.ParentID != 0 || tag.NBookmarks != 1) {
+			t.Errorf("nested tag after DeleteTag() = %+v, want top level with 1 bookmark", tag)
+		}
+	}
+
+	sort.Strings(names)
+	if !reflect.DeepEqual(names, []string{"dev/web", "go"}) {
+		t.Errorf("tags after DeleteTag() = %v, want [dev/web go]", names)
+	}
+
+	// Renaming another tag links the tags again, which must not create it
+	if err = db.RenameTag(tagID("go"), "golang"); err != nil {
+		t.Fatal(err)
+	}
+
+	if tagID("dev") != 0 {
+		t.Errorf("tag dev created again after RenameTag()")
+	}
+
+	// Renaming a tag into the deleted tag creates it, and links the nested tag again
+	if err = db.RenameTag(tagID("golang"), "dev/go"); err != nil {
+		t.Fatal(err)
+	}
+
+	tags, _ = db.GetTags(GetTagsOptions{WithUnused: true})
+	for _, tag := range tags {
+		if tag.Name == "dev/web" && (tag.ParentID == 0 || tag.ParentID != tagID("dev")) {
+			t.Errorf("nested tag after RenameTag() = %+v, want linked to dev", tag)
+		}
+	}
+}
+
+func TestSQLiteDatabase_GetBookmarksRegex(t *testing.T) {
+	db, cleanup := openTestSQLiteDatabase(t)
+	defer cleanup()
+
+	_, err := db.SaveBookmarks(
+		model.Bookmark{ID: 1, URL: "https://example.com/1", Title: "Golang tips"},
+		model.Bookmark{ID: 2, URL: "https://example.com/2", Title: "Go tricks"},
+		model.Bookmark{ID: 3, URL: "https://example.com/3", Title: "Rust", Excerpt: "Not Go at all"},
+		model.Bookmark{ID: 4, URL: "https://example.com/4", Title: "Gopher"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name    string
+		regex   string
+		wantIDs []int
+	}{
+		{"anchored", `^go(lang)? (tips|tricks)$`, []int{1, 2}},
+		{"case insensitive", `^GOPHER$`, []int{4}},
+		{"matches excerpt", `not go`, []int{3}},
+		{"no match", `^python`, []int{}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			opts := GetBookmarksOptions{Regex: tt.regex}
+			bookmarks, err := db.GetBookmarks(opts)
+			if err != nil {
+				t.Fatalf("GetBookmarks() error = %v", err)
+			}
+
+			gotIDs := []int{}
+			for _, book := range bookmarks {
+				gotIDs = append(gotIDs, book.ID)
+			}
+
+			if !reflect.DeepEqual(gotIDs, tt.wantIDs) {
+				t.Errorf("GetBookmarks() = %v, want %v", gotIDs, tt.wantIDs)
+			}
+
+			count, err := db.GetBookmarksCount(opts)
+			if err != nil || count != len(tt.wantIDs) {
+				t.Errorf("GetBookmarksCount() = %d, %v, want %d", count, err, len(tt.wantIDs))
+			}
+		})
+	}
+}
+
+func TestSQLiteDatabase_GetBookmarksRegexTimeout(t *testing.T) {
+	db, cleanup := openTestSQLiteDatabase(t)
+	defer cleanup()
+
+	oldTimeout := regexTimeout
+	regexTimeout = 0
+	defer func() { regexTimeout = oldTimeout }()
+
+	_, err := db.GetBookmarks(GetBookmarksOptions{Regex: `^go`})
+	if err != ErrRegexTimeout {
+		t.Errorf("GetBookmarks() error = %v, want %v", err, ErrRegexTimeout)
+	}
+}
+
+func TestSQLiteDatabase_GetBookmarksMetadata(t *testing.T) {
+	db, cleanup := openTestSQLiteDatabase(t)
+	defer cleanup()
+
+	_, err := db.SaveBookmarks(
+		model.Bookmark{ID: 1, URL: "https://example.com/1", Title: "One",
+			Metadata: model.Metadata{"isbn": "978-0134190440", "priority": "high"}},
+		model.Bookmark{ID: 2, URL: "https://example.com/2", Title: "Two",
+			Metadata: model.Metadata{"note": `"priority":"high"`, "source": "100%_sure"}},
+		model.Bookmark{ID: 3, URL: "https://example.com/3", Title: "Three"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name     string
+		metadata map[string]string
+		wantIDs  []int
+	}{
+		{"has key", map[string]string{"isbn": ""}, []int{1}},
+		{"exact value", map[string]string{"priority": "high"}, []int{1}},
+		{"different value", map[string]string{"priority": "low"}, []int{}},
+		{"all must match", map[string]string{"isbn": "", "source": ""}, []int{}},
+		{"wildcard characters are literal", map[string]string{"source": "100%_sure"}, []int{2}},
+		{"wildcard doesn't match other value", map[string]string{"source": "100%"}, []int{}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			bookmarks, err := db.GetBookmarks(GetBookmarksOptions{Metadata: tt.metadata})
+			if err != nil {
+				t.Fatalf("GetBookmarks() error = %v", err)
+			}
+
+			gotIDs := []int{}
+			for _, book := range bookmarks {
+				gotIDs = append(gotIDs, book.ID)
+			}
+
+			if !reflect.DeepEqual(gotIDs, tt.wantIDs) {
+				t.Errorf("GetBookmarks() = %v, want %v", gotIDs, tt.wantIDs)
+			}
+		})
+	}
+
+	// Metadata is returned as it's saved, and missing metadata is nil
+	book, _ := db.GetBookmark(1, "")
+	if want := (model.Metadata{"isbn": "978-0134190440", "priority": "high"}); !reflect.DeepEqual(book.Metadata, want) {
+		t.Errorf("GetBookmark() metadata = %v, want %v", book.Metadata, want)
+	}
+
+	book, _ = db.GetBookmark(3, "")
+	if book.Metadata != nil {
+		t.Errorf("GetBookmark() metadata = %v, want nil", book.Metadata)
+	}
+}
+
+func TestSQLiteDatabase_APITokens(t *testing.T) {
+	db, cleanup := openTestSQLiteDatabase(t)
+	defer cleanup()
+
+	for _, username := range []string{"alice", "bob"} {
+		if err := db.SaveAccount(model.Account{Username: username, Password: "secret"}); err != nil {
+			t.Fatalf("SaveAccount() error = %v", err)
+		}
+	}
+
+	alice, _ := db.GetAccount("alice")
+	bob, _ := db.GetAccount("bob")
+
+	token, err := db.SaveAPIToken(model.APIToken{AccountID: alice.ID, Name: "script", Hash: "hash-1"})
+	if err != nil || token.ID == 0 || token.Created == "" {
+		t.Fatalf("SaveAPIToken() = %+v, %v", token, err)
+	}
+
+	if _, err := db.SaveAPIToken(model.APIToken{AccountID: bob.ID, Name: "ext", Hash: "hash-2", Scope: model.TokenScopeRead}); err != nil {
+		t.Fatalf("SaveAPIToken() error = %v", err)
+	}
+
+	_, account, exist := db.GetAPITokenAccount("hash-1")
+	if !exist || account.Username != "alice" || account.Password != "" {
+		t.Errorf("GetAPITokenAccount() = %+v, %v, want alice without password", account, exist)
+	}
+
+	if apiToken, _, _ := db.GetAPITokenAccount("hash-2"); apiToken.Scope != model.TokenScopeRead {
+		t.Errorf("GetAPITokenAccount() scope = %q, want %q", apiToken.Scope, model.TokenScopeRead)
+	}
+
+	if _, _, exist := db.GetAPITokenAccount("unknown"); exist {
+		t.Errorf("GetAPITokenAccount() found account for unknown hash")
+	}
+
+	tokens, err := db.GetAPITokens(alice.ID)
+	if err != nil || len(tokens) != 1 || tokens[0].Name != "script" || tokens[0].Hash != "" {
+		t.Errorf("GetAPITokens() = %+v, %v, want only alice's token without hash", tokens, err)
+	}
+
+	// Token can only be revoked by its owner
+	if deleted, err := db.DeleteAPIToken(bob.ID, token.ID); err != nil || deleted {
+		t.Errorf("DeleteAPIToken() by other account = %v, %v", deleted, err)
+	}
+
+	if deleted, err := db.DeleteAPIToken(alice.ID, token.ID); err != nil || !deleted {
+		t.Errorf("DeleteAPIToken() = %v, %v", deleted, err)
+	}
+
+	if _, _, exist := db.GetAPITokenAccount("hash-1"); exist {
+		t.Errorf("GetAPITokenAccount() still finds revoked token")
+	}
+
+	// Tokens are removed together with their account
+	if err := db.DeleteAccounts("bob"); err != nil {
+		t.Fatalf("DeleteAccounts() error = %v", err)
+	}
+
+	if _, _, exist := db.GetAPITokenAccount("hash-2"); exist {
+		t.Errorf("GetAPITokenAccount() still finds token of deleted account")
+	}
+}
+
+func TestSQLiteDatabase_ShareLinks(t *testing.T) {
+	db, cleanup := openTestSQLiteDatabase(t)
+	defer cleanup()
+
+	_, err := db.SaveBookmarks(
+		model.Bookmark{ID: 1, URL: "https://example.com/1", Title: "One"},
+		model.Bookmark{ID: 2, URL: "https://example.com/2", Title: "Two"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	link, err := db.SaveShareLink(model.ShareLink{BookmarkID: 1, Hash: "hash-1"})
+	if err != nil || link.ID == 0 || link.Created == "" {
+		t.Fatalf("SaveShareLink() = %+v, %v", link, err)
+	}
+
+	if _, err := db.SaveShareLink(model.ShareLink{BookmarkID: 2, Hash: "hash-2"}); err != nil {
+		t.Fatalf("SaveShareLink() error = %v", err)
+	}
+
+	book, exist := db.GetSharedBookmark("hash-1")
+	if !exist || book.ID != 1 {
+		t.Errorf("GetSharedBookmark() = %d, %v, want bookmark 1", book.ID, exist)
+	}
+
+	if _, exist := db.GetSharedBookmark("unknown"); exist {
+		t.Errorf("GetSharedBookmark() found bookmark for unknown hash")
+	}
+
+	links, err := db.GetShareLinks(1)
+	if err != nil || len(links) != 1 || links[0].ID != link.ID || links[0].Hash != "" {
+		t.Errorf("GetShareLinks() = %+v, %v, want only link of bookmark 1 without hash", links, err)
+	}
+
+	// Link can only be revoked through its bookmark
+	if deleted, err := db.DeleteShareLink(2, link.ID); err != nil || deleted {
+		t.Errorf("DeleteShareLink() of other bookmark = %v, %v", deleted, err)
+	}
+
+	if deleted, err := db.DeleteShareLink(1, link.ID); err != nil || !deleted {
+		t.Errorf("DeleteShareLink() = %v, %v", deleted, err)
+	}
+
+	if _, exist := db.GetSharedBookmark("hash-1"); exist {
+		t.Errorf("GetSharedBookmark() still finds revoked link")
+	}
+
+	// Links are removed together with their bookmark
+	if _, err := db.DeleteBookmarks(2); err != nil {
+		t.Fatalf("DeleteBookmarks() error = %v", err)
+	}
+
+	if links, _ := db.GetShareLinks(2); len(links) != 0 {
+		t.Errorf("GetShareLinks() of deleted bookmark = %+v, want none", links)
+	}
+}
+
+func TestSQLiteDatabase_Sessions(t *testing.T) {
+	db, cleanup := openTestSQLiteDatabase(t)
+	defer cleanup()
+
+	if err := db.SaveAccount(model.Account{Username: "alice", Password: "secret", Owner: true}); err != nil {
+		t.Fatalf("SaveAccount() error = %v", err)
+	}
+
+	alice, _ := db.GetAccount("alice")
+	future := time.Now().UTC().Add(time.Hour).Format("2006-01-02 15:04:05")
+	past := time.Now().UTC().Add(-time.Hour).Format("2006-01-02 15:04:05")
+
+	expired, err := db.SaveSession(model.Session{AccountID: alice.ID, Hash: "hash-1", Expires: past})
+	if err != nil {
+		t.Fatalf("SaveSession() error = %v", err)
+	}
+
+	session, err := db.SaveSession(model.Session{AccountID: alice.ID, Hash: "hash-2", Expires: future})
+	if err != nil || session.ID == 0 {
+		t.Fatalf("SaveSession() = %+v, %v", session, err)
+	}
+
+	got, account, exist := db.GetSessionAccount(session.ID)
+	if !exist || got.Hash != "hash-2" || account.Username != "alice" || !account.Owner {
+		t.Errorf("GetSessionAccount() = %+v, %+v, %v", got, account, exist)
+	}
+
+	if _, _, exist := db.GetSessionAccount(expired.ID); exist {
+		t.Errorf("GetSessionAccount() finds expired session")
+	}
+
+	sessions, err := db.GetSessions(alice.ID)
+	if err != nil || len(sessions) != 1 || sessions[0].ID != session.ID {
+		t.Errorf("GetSessions() = %+v, %v, want only the unexpired session", sessions, err)
+	}
+
+	if deleted, err := db.DeleteSession(alice.ID, session.ID); err != nil || !deleted {
+		t.Errorf("DeleteSession() = %v, %v", deleted, err)
+	}
+
+	if _, _, exist := db.GetSessionAccount(session.ID); exist {
+		t.Errorf("GetSessionAccount() still finds revoked session")
+	}
+}
+
+func TestSQLiteDatabase_AccountRole(t *testing.T) {
+	db, cleanup := openTestSQLiteDatabase(t)
+	defer cleanup()
+
+	tests := []struct {
+		account   model.Account
+		wantRole  string
+		wantOwner bool
+	}{
+		{model.Account{Username: "owner", Owner: true}, model.RoleOwner, true},
+		{model.Account{Username: "visitor"}, model.RoleViewer, false},
+		{model.Account{Username: "editor", Role: model.RoleEditor}, model.RoleEditor, false},
+		{model.Account{Username: "promoted", Role: model.RoleOwner}, model.RoleOwner, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.account.Username, func(t *testing.T) {
+			tt.account.Password = "secret"
+			if err := db.SaveAccount(tt.account); err != nil {
+				t.Fatalf("SaveAccount() error = %v", err)
+			}
+
+			account, _ := db.GetAccount(tt.account.Username)
+			if account.Role != tt.wantRole || account.Owner != tt.wantOwner {
+				t.Errorf("GetAccount() role = %q, owner = %v, want %q, %v",
+					account.Role, account.Owner, tt.wantRole, tt.wantOwner)
+			}
+		})
+	}
+}
+
+func TestSQLiteDatabase_BookmarkOwner(t *testing.T) {
+	db, cleanup := openTestSQLiteDatabase(t)
+	defer cleanup()
+
+	_, err := db.SaveBookmarks(
+		model.Bookmark{ID: 1, URL: "https://example.com/1", Title: "One", OwnerID: 1},
+		model.Bookmark{ID: 2, URL: "https://example.com/2", Title: "Two", OwnerID: 2},
+		model.Bookmark{ID: 3, URL: "https://example.com/3", Title: "Three", OwnerID: 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Updating bookmark must keep its owner
+	book, _ := db.GetBookmark(2, "")
+	book.Title = "Two edited"
+	book.OwnerID = 1
+	if _, err = db.SaveBookmarks(book); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		ownerID int
+		wantIDs []int
+	}{
+		{0, []int{1, 2, 3}},
+		{1, []int{1, 3}},
+		{2, []int{2}},
+		{3, []int{}},
+	}
+
+	for _, tt := range tests {
+		t.Run(fmt.Sprint(tt.ownerID), func(t *testing.T) {
+			bookmarks, err := db.GetBookmarks(GetBookmarksOptions{OwnerID: tt.ownerID})
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			gotIDs := []int{}
+			for _, book := range bookmarks {
+				gotIDs = append(gotIDs, book.ID)
+			}
+
+			if fmt.Sprint(gotIDs) != fmt.Sprint(tt.wantIDs) {
+				t.Errorf("GetBookmarks() returns %v, want %v", gotIDs, tt.wantIDs)
+			}
+		})
+	}
+}
+
+func TestSQLiteDatabase_Backup(t *testing.T) {
+	db, cleanup := openTestSQLiteDatabase(t)
+	defer cleanup()
+
+	_, err := db.SaveBookmarks(model.Bookmark{ID: 1, URL: "https://example.com", Title: "Example"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tmpDir, err := ioutil.TempDir("", "shiori-backup")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	backupPath := fp.Join(tmpDir, "shiori.db")
+	if err = db.Backup(backupPath); err != nil {
+		t.Fatalf("Backup() error = %v", err)
+	}
+
+	// Backup is never written over existing file
+	if err = db.Backup(backupPath); err == nil {
+		t.Errorf("Backup() into existing file doesn't return error")
+	}
+
+	backupDB, err := OpenSQLiteDatabase(backupPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer backupDB.Close()
+
+	if book, exist := backupDB.GetBookmark(1, ""); !exist || book.URL != "https://example.com" {
+		t.Errorf("backup has bookmark %+v, %v", book, exist)
+	}
+
+	// Database that isn't a file can't be backed up
+	memoryDB, err := OpenSQLiteDatabase(":memory:")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer memoryDB.Close()
+
+	if err = memoryDB.Backup(fp.Join(tmpDir, "memory.db")); err != ErrBackupUnsupported {
+		t.Errorf("Backup() of memory database error = %v, want %v", err, ErrBackupUnsupported)
+	}
+}
+
+func TestSQLiteDatabase_SaveBookmarksVersion(t *testing.T) {
+	db, cleanup := openTestSQLiteDatabase(t)
+	defer cleanup()
+
+	saved, err := db.SaveBookmarks(model.Bookmark{ID: 1, URL: "https://example.com", Title: "First"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Both read the first version, so only the first save wins
+	first, second := saved[0], saved[0]
+	first.Title = "Updated"
+	if _, err := db.SaveBookmarks(first); err != nil {
+		t.Fatalf("SaveBookmarks() error = %v", err)
+	}
+
+	second.Title = "Overwritten"
+	if _, err := db.SaveBookmarks(second); err != ErrVersionConflict {
+		t.Errorf("SaveBookmarks() with stale version error = %v, want %v", err, ErrVersionConflict)
+	}
+
+	book, _ := db.GetBookmark(1, "")
+	if book.Title != "Updated" || book.Version != 2 {
+		t.Errorf("saved bookmark = %q version %d, want %q version 2", book.Title, book.Version, "Updated")
+	}
+}
+
+func TestSQLiteDatabase_SaveBookmarksNewID(t *testing.T) {
+	db, cleanup := openTestSQLiteDatabase(t)
+	defer cleanup()
+
+	if _, err := db.SaveBookmarks(model.Bookmark{ID: 5, URL: "https://example.com/5", Title: "Five"}); err != nil {
+		t.Fatal(err)
+	}
+
+	saved, err := db.SaveBookmarks(
+		model.Bookmark{URL: "https://example.com/a", Title: "A", Content: "a"},
+		model.Bookmark{URL: "https://example.com/b", Title: "B", Content: "b"})
+	if err != nil {
+		t.Fatalf("SaveBookmarks() error = %v", err)
+	}
+
+	if len(saved) != 2 || saved[0].ID != 6 || saved[1].ID != 7 {
+		t.Fatalf("SaveBookmarks() = %+v, want IDs 6 and 7", saved)
+	}
+
+	books, _ := db.GetBookmarks(GetBookmarksOptions{IDs: []int{7}, WithContent: true})
+	if len(books) != 1 || books[0].URL != "https://example.com/b" || books[0].Content != "b" {
+		t.Errorf("bookmark with assigned ID = %+v", books)
+	}
+
+	if _, err := db.SaveBookmarks(model.Bookmark{URL: "https://example.com/c", Title: "C", Version: 1}); err == nil {
+		t.Errorf("SaveBookmarks() saves existing bookmark without ID")
+	}
+}
+
+func TestSQLiteDatabase_SaveBookmarksWithoutContent(t *testing.T) {
+	db, cleanup := openTestSQLiteDatabase(t)
+	defer cleanup()
+
+	saved, err := db.SaveBookmarks(
+		model.Bookmark{ID: 1, URL: "https://example.com/1", Title: "First", Content: "first content", HTML: "<p>first content</p>"},
+		model.Bookmark{ID: 2, URL: "https://example.com/2", Title: "Second", Content: "second content"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	first, second := saved[0], saved[1]
+	first.Content, first.HTML = "", ""
+	first.Tags = []model.Tag{{Name: "kept"}}
+	if _, err := db.SaveBookmarksWithoutContent(first); err != nil {
+		t.Fatalf("SaveBookmarksWithoutContent() error = %v", err)
+	}
+
+	books, _ := db.GetBookmarks(GetBookmarksOptions{IDs: []int{1}, WithContent: true})
+	if len(books) != 1 || books[0].Content != "first content" || books[0].HTML != "<p>first content</p>" || len(books[0].Tags) != 1 {
+		t.Errorf("saved bookmarks = %+v, want its tag saved and its content kept", books)
+	}
+
+	// Every bookmark is saved in the same transaction, so none is saved
+	// when one of them is stale
+	second.Title = "Second updated"
+	if _, err := db.SaveBookmarksWithoutContent(second, first); err != ErrVersionConflict {
+		t.Errorf("SaveBookmarksWithoutContent() with stale version error = %v, want %v", err, ErrVersionConflict)
+	}
+
+	if book, _ := db.GetBookmark(2, ""); book.Title != "Second" {
+		t.Errorf("bookmark saved together with stale one has title %q, want %q", book.Title, "Second")
+	}
+
+	if _, err := db.SaveBookmarksWithoutContent(model.Bookmark{ID: 3, URL: "https://example.com/3", Title: "Third"}); err == nil {
+		t.Errorf("SaveBookmarksWithoutContent() saves new bookmark without its content")
+	}
+}
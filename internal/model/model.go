@@ -1,35 +1,276 @@
 package model
 
+import (
+	"database/sql/driver"
+	"encoding/json"
+	"fmt"
+)
+
 // Tag is the tag for a bookmark.
 type Tag struct {
 	ID         int    `db:"id"          json:"id"`
 	Name       string `db:"name"        json:"name"`
 	NBookmarks int    `db:"n_bookmarks" json:"nBookmarks,omitempty"`
 	Deleted    bool   `json:"-"`
+
+	// ParentID is the ID of the tag whose name is the path before the last
+	// slash, e.g. "dev/go" for "dev/go/web". It's zero for top level tag.
+	ParentID int `db:"parent_id" json:"parentId,omitempty"`
+
+	// DefaultPublic is the visibility given to bookmarks when the tag
+	// is added to them. Nil means the visibility is left as it is.
+	DefaultPublic *int `db:"default_public" json:"defaultPublic,omitempty"`
+
+	// Color, Description and Icon are only used by clients to show the tag.
+	// Color is hex color like "#ff8800", while Icon is usually an emoji.
+	Color       string `db:"color"       json:"color,omitempty"`
+	Description string `db:"description" json:"description,omitempty"`
+	Icon        string `db:"icon"        json:"icon,omitempty"`
+
+	// LastUsed is the latest created time among the bookmarks that
+	// use the tag. Nil means no bookmark is using the tag.
+	LastUsed *string `db:"last_used" json:"lastUsed,omitempty"`
 }
 
 // Bookmark is the record for an URL.
 type Bookmark struct {
-	ID            int    `db:"id"            json:"id"`
-	URL           string `db:"url"           json:"url"`
-	Title         string `db:"title"         json:"title"`
-	Excerpt       string `db:"excerpt"       json:"excerpt"`
-	Author        string `db:"author"        json:"author"`
-	Public        int    `db:"public"        json:"public"`
-	Modified      string `db:"modified"      json:"modified"`
-	Content       string `db:"content"       json:"-"`
-	HTML          string `db:"html"          json:"html,omitempty"`
-	ImageURL      string `db:"image_url"     json:"imageURL"`
-	HasContent    bool   `db:"has_content"   json:"hasContent"`
-	HasArchive    bool   `json:"hasArchive"`
-	Tags          []Tag  `json:"tags"`
-	CreateArchive bool   `json:"createArchive"`
-}
-
-// Account is person that allowed to access web interface.
+	ID            int      `db:"id"            json:"id"`
+	URL           string   `db:"url"           json:"url"`
+	Title         string   `db:"title"         json:"title"`
+	Excerpt       string   `db:"excerpt"       json:"excerpt"`
+	Author        string   `db:"author"        json:"author"`
+	Public        int      `db:"public"        json:"public"`
+	Created       string   `db:"created"       json:"created"`
+	Modified      string   `db:"modified"      json:"modified"`
+	Version       int      `db:"version"       json:"version"`
+	ContentType   string   `db:"content_type"  json:"contentType"`
+	ContentHash   string   `db:"content_hash"  json:"contentHash"`
+	Content       string   `db:"content"       json:"-"`
+	HTML          string   `db:"html"          json:"html,omitempty"`
+	ImageURL      string   `db:"image_url"     json:"imageURL"`
+	HasContent    bool     `db:"has_content"   json:"hasContent"`
+	Order         *int     `db:"sort_order"    json:"order,omitempty"`
+	Metadata      Metadata `db:"metadata"      json:"metadata,omitempty"`
+	HasArchive    bool     `json:"hasArchive"`
+	HasScreenshot bool     `json:"hasScreenshot"`
+	HasPDF        bool     `json:"hasPDF"`
+	HasSingleFile bool     `json:"hasSingleFile"`
+	Tags          []Tag    `json:"tags"`
+	CreateArchive bool     `json:"createArchive"`
+	CreatePDF     bool     `json:"createPDF"`
+	ArchiveFormat string   `json:"archiveFormat,omitempty"`
+
+	// LastStatusCode is the HTTP status code returned when the bookmark was
+	// last downloaded. It's zero when unknown, e.g. when it's never been
+	// downloaded or the page was rendered in headless browser.
+	LastStatusCode int `db:"last_status" json:"lastStatusCode,omitempty"`
+
+	// WaybackURL is the snapshot in Wayback Machine that the bookmark was
+	// archived from, since its page was gone when it's last downloaded.
+	// It's empty when the bookmark is archived from the page itself.
+	WaybackURL string `db:"wayback_url" json:"waybackURL,omitempty"`
+
+	// VersionedArchive decides whether the past archives of the bookmark
+	// are kept as snapshots when it's archived again.
+	VersionedArchive bool `db:"versioned" json:"versionedArchive"`
+
+	// OwnerID is the ID of account that owns the bookmark. Zero means it's
+	// shared, e.g. it's saved before bookmarks had owner or without login.
+	// It's only set when the bookmark is created, and never changed after.
+	OwnerID int `db:"owner_id" json:"ownerId,omitempty"`
+
+	// Read is whether the bookmark has been read, and ReadAt is when it's
+	// marked as read. Both are only changed by marking it read or unread.
+	Read   bool    `db:"is_read" json:"read"`
+	ReadAt *string `db:"read_at" json:"readAt,omitempty"`
+
+	// Starred is whether the bookmark is marked as favorite,
+	// which is only changed by starring or unstarring it.
+	Starred bool `db:"starred" json:"starred"`
+
+	// CollectionID is the ID of collection that contains the bookmark. Zero
+	// means it's not in any collection. It's only set when the bookmark is
+	// created, and after that only changed by moving it to other collection.
+	CollectionID int `db:"collection_id" json:"collectionId,omitempty"`
+
+	// LinkStatus is the HTTP status code returned when the bookmark URL was
+	// last checked by the link checker, or zero when it couldn't be reached.
+	// LinkChecked is when it's last checked, nil means it's never checked.
+	// Both are only changed by the link checker.
+	LinkStatus  int     `db:"link_status" json:"linkStatus,omitempty"`
+	LinkChecked *string `db:"link_checked" json:"linkChecked,omitempty"`
+
+	// Warnings is the non-fatal problems that happened while processing
+	// the bookmark, e.g. when the archive is only partially created.
+	Warnings []string `json:"warnings,omitempty"`
+
+	// RefreshResult is the outcome of refreshing the bookmark's content,
+	// either "refreshed", "failed" or "unchanged".
+	RefreshResult string `json:"refreshResult,omitempty"`
+}
+
+// Metadata is arbitrary key-value data attached to a bookmark by user,
+// e.g. ISBN of a book. It's stored in database as JSON object.
+type Metadata map[string]string
+
+// Value implements driver.Valuer. Nil metadata is stored as empty object.
+func (m Metadata) Value() (driver.Value, error) {
+	if m == nil {
+		return "{}", nil
+	}
+
+	bt, err := json.Marshal(m)
+	if err != nil {
+		return nil, err
+	}
+
+	return string(bt), nil
+}
+
+// Scan implements sql.Scanner.
+func (m *Metadata) Scan(src interface{}) error {
+	var bt []byte
+	switch v := src.(type) {
+	case nil:
+		*m = nil
+		return nil
+	case string:
+		bt = []byte(v)
+	case []byte:
+		bt = v
+	default:
+		return fmt.Errorf("can't scan %T into metadata", src)
+	}
+
+	result := Metadata{}
+	if err := json.Unmarshal(bt, &result); err != nil {
+		return err
+	}
+
+	if len(result) == 0 {
+		result = nil
+	}
+
+	*m = result
+	return nil
+}
+
+// Tombstone is the record of a deleted bookmark, kept so sync
+// clients are able to remove the bookmark from their local copy.
+type Tombstone struct {
+	ID      int    `db:"id"      json:"id"`
+	URL     string `db:"url"     json:"url"`
+	Deleted string `db:"deleted" json:"deleted"`
+}
+
+// Collection is a folder of bookmarks, e.g. a folder imported from browser.
+// It may be nested in other collection, which is identified by ParentID.
+type Collection struct {
+	ID         int    `db:"id"          json:"id"`
+	Name       string `db:"name"        json:"name"`
+	ParentID   int    `db:"parent_id"   json:"parentId"`
+	OwnerID    int    `db:"owner_id"    json:"ownerId,omitempty"`
+	NBookmarks int    `db:"n_bookmarks" json:"nBookmarks"`
+}
+
+// APIToken is token that authenticates non-interactive client, e.g. script
+// or browser extension, as an account. Only the hash of the token is stored,
+// so the token itself is only known when it's created.
+type APIToken struct {
+	ID        int    `db:"id"         json:"id"`
+	AccountID int    `db:"account_id" json:"-"`
+	Name      string `db:"name"       json:"name"`
+	Hash      string `db:"token_hash" json:"-"`
+	Scope     string `db:"scope"      json:"scope"`
+	Created   string `db:"created"    json:"created"`
+	Token     string `db:"-"          json:"token,omitempty"`
+}
+
+// Scopes of API token. Full token can do anything its account can do, while
+// read-only token can only read, whatever the role of its account is.
+const (
+	TokenScopeFull = "full"
+	TokenScopeRead = "read"
+)
+
+// ValidTokenScope checks if scope is one of the known scopes of API token.
+func ValidTokenScope(scope string) bool {
+	return scope == TokenScopeFull || scope == TokenScopeRead
+}
+
+// ShareLink is link that lets anyone view the readable content and archive
+// of a bookmark without login. Only the hash of its token is stored, so the
+// link itself is only known when it's created.
+type ShareLink struct {
+	ID         int    `db:"id"          json:"id"`
+	BookmarkID int    `db:"bookmark_id" json:"bookmarkId"`
+	Hash       string `db:"token_hash"  json:"-"`
+	Created    string `db:"created"     json:"created"`
+	Token      string `db:"-"           json:"token,omitempty"`
+	URL        string `db:"-"           json:"url,omitempty"`
+}
+
+// Session is login session of an account. It's identified by its refresh
+// token, which is used to get new access token until the session expired
+// or revoked. Only the hash of the refresh token is stored.
+type Session struct {
+	ID        int    `db:"id"         json:"id"`
+	AccountID int    `db:"account_id" json:"-"`
+	Hash      string `db:"token_hash" json:"-"`
+	Created   string `db:"created"    json:"created"`
+	Expires   string `db:"expires"    json:"expires"`
+}
+
+// Roles of account, from the least privileged. Viewer can only read, editor
+// can add and edit bookmarks as well, while owner can do anything including
+// managing accounts and deleting data.
+const (
+	RoleViewer = "viewer"
+	RoleEditor = "editor"
+	RoleOwner  = "owner"
+)
+
+// roleRanks is the rank of each role, higher is more privileged.
+var roleRanks = map[string]int{
+	RoleViewer: 1,
+	RoleEditor: 2,
+	RoleOwner:  3,
+}
+
+// ValidRole checks if role is one of the known roles.
+func ValidRole(role string) bool {
+	_, ok := roleRanks[role]
+	return ok
+}
+
+// Account is person that allowed to access web interface. Owner is kept
+// for older clients, it's true when the account has owner role.
 type Account struct {
 	ID       int    `db:"id"       json:"id"`
 	Username string `db:"username" json:"username"`
 	Password string `db:"password" json:"password,omitempty"`
 	Owner    bool   `db:"owner"    json:"owner"`
+	Role     string `db:"role"     json:"role"`
+
+	// Provider is where the account is authenticated, which is empty for
+	// local account that logs in with password saved in database.
+	Provider string `db:"provider" json:"provider,omitempty"`
+
+	// Issuer and Subject identify the user of OpenID Connect provider that
+	// the account is linked to, which never changes unlike its username.
+	Issuer  string `db:"issuer"  json:"-"`
+	Subject string `db:"subject" json:"-"`
+}
+
+// Providers of account that created for external user on its first login,
+// i.e. user of the LDAP directory or the OpenID Connect provider.
+const (
+	AccountProviderLDAP = "ldap"
+	AccountProviderOIDC = "oidc"
+)
+
+// HasRole checks if account has the role or a more privileged one.
+func (a Account) HasRole(role string) bool {
+	rank, ok := roleRanks[role]
+	return ok && roleRanks[a.Role] >= rank
 }
@@ -74,7 +74,12 @@ export default {
 				case Error:
 					return err.message;
 				case Response:
+					if (err.status === 401) this.$root.showLoginDialog();
+
 					var text = await err.text();
+					try {
+						text = JSON.parse(text).error || text;
+					} catch (e) {}
 					return `${text} (${err.status})`;
 				default:
 					return err;
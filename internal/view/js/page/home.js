@@ -370,6 +370,16 @@ export default {
 					label: "Create archive",
 					type: "check",
 					value: this.appOptions.useArchive,
+				}, {
+					name: "singleFile",
+					label: "Archive as single HTML file",
+					type: "check",
+					value: false,
+				}, {
+					name: "createPDF",
+					label: "Save as PDF",
+					type: "check",
+					value: false,
 				}, {
 					name: "makePublic",
 					label: "Make archive publicly available",
@@ -405,6 +415,8 @@ export default {
 						public: data.makePublic ? 1 : 0,
 						tags: tags,
 						createArchive: data.createArchive,
+						createPDF: data.createPDF,
+						archiveFormat: data.singleFile ? "singlefile" : "warc",
 					};
 
 					this.dialog.loading = true;
@@ -611,6 +623,16 @@ export default {
 					label: "Update archive as well",
 					type: "check",
 					value: this.appOptions.useArchive,
+				}, {
+					name: "singleFile",
+					label: "Archive as single HTML file",
+					type: "check",
+					value: false,
+				}, {
+					name: "createPDF",
+					label: "Save as PDF as well",
+					type: "check",
+					value: false,
 				}],
 				mainText: "Yes",
 				secondText: "No",
@@ -618,6 +640,8 @@ export default {
 					var data = {
 						ids: ids,
 						createArchive: data.createArchive,
+						createPDF: data.createPDF,
+						archiveFormat: data.singleFile ? "singlefile" : "warc",
 						keepMetadata: data.keepMetadata,
 					};
 
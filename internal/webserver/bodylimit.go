@@ -0,0 +1,14 @@
+// +build go1.19
+
+package webserver
+
+import (
+	"errors"
+	"net/http"
+)
+
+// isBodyTooLarge checks if the error caused by request body
+// that exceeds the limit set by http.MaxBytesReader.
+func isBodyTooLarge(err error) bool {
+	return errors.As(err, new(*http.MaxBytesError))
+}
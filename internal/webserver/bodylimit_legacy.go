@@ -0,0 +1,12 @@
+// +build !go1.19
+
+package webserver
+
+import "strings"
+
+// isBodyTooLarge checks if the error caused by request body
+// that exceeds the limit set by http.MaxBytesReader. Go before 1.19
+// doesn't have http.MaxBytesError, so only its message can be checked.
+func isBodyTooLarge(err error) bool {
+	return strings.Contains(err.Error(), errBodyTooLarge)
+}
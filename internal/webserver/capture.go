@@ -0,0 +1,134 @@
+package webserver
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"shiori/internal/core"
+	"shiori/internal/model"
+	"github.com/julienschmidt/httprouter"
+)
+
+// apiInsertCapture is handler for POST /api/bookmarks/capture
+//
+// The request body is a page that captured by other tool, either as WARC
+// file, which might be gzipped, or as MHTML file. It's uploaded as it is or
+// as `file` field of multipart form, just like POST /api/import. The page
+// becomes a new bookmark, whose title and excerpt are parsed from it, and
+// it's archived as single-file archive from the captured sub-resources, so
+// nothing is downloaded except its thumbnail. The bookmark's tags may be
+// specified as comma-separated `tags`.
+//
+// Like POST /api/bookmark, the captured page is sanitized before it's
+// processed, and it's only saved over the bookmark with the same URL when
+// `force=true` is specified.
+func (h *handler) apiInsertCapture(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	src, options := readUploadedFile(r)
+
+	capture, err := core.ParseCapture(src)
+	if err != nil {
+		panic(newClientError(http.StatusBadRequest, fmt.Errorf("failed to parse captured file: %v", err)))
+	}
+
+	capture.Content, err = core.SanitizeHTML(bytes.NewReader(capture.Content))
+	if err != nil {
+		panic(newClientError(http.StatusBadRequest, fmt.Errorf("invalid captured html: %v", err)))
+	}
+
+	// Clean up bookmark URL
+	url, err := core.RemoveUTMParams(capture.URL, h.KeptQueryParams)
+	if err != nil {
+		panic(newClientError(http.StatusBadRequest, fmt.Errorf("failed to clean URL: %v", err)))
+	}
+
+	// Captured page is only saved over the existing bookmark when forced,
+	// which is checked before the duplicate uses up the quota
+	existing, exist, ok := h.checkDuplicateURL(w, r, url)
+	if !ok {
+		return
+	}
+
+	// Make sure client still has quota left
+	account := quotaAccount(r)
+	err = h.useInsertQuota(account)
+	if quotaErr, isQuotaErr := err.(errQuotaExceeded); isQuotaErr {
+		writeQuotaError(w, quotaErr)
+		return
+	}
+
+	releaseQuota, err := h.useArchivalQuota(account, 1)
+	if quotaErr, isQuotaErr := err.(errQuotaExceeded); isQuotaErr {
+		writeQuotaError(w, quotaErr)
+		return
+	}
+	checkError(err)
+	defer releaseQuota()
+
+	book := model.Bookmark{
+		URL:           url,
+		OwnerID:       newBookmarkOwner(r),
+		CreateArchive: true,
+		ArchiveFormat: core.ArchiveFormatSingleFile,
+	}
+
+	for _, name := range strings.Split(options.Get("tags"), ",") {
+		book.Tags = append(book.Tags, model.Tag{Name: name})
+	}
+	book.Tags = uniqueTags(book.Tags)
+
+	// Create bookmark ID, or keep the existing one when it's forced
+	if exist {
+		book.ID = existing.ID
+		book.OwnerID = existing.OwnerID
+		book.Version = existing.Version
+	} else {
+		book.ID, err = h.DB.CreateNewID("bookmark")
+		if err != nil {
+			panic(fmt.Errorf("failed to create ID: %v", err))
+		}
+	}
+
+	request := core.ProcessRequest{
+		DataDir:        h.DataDir,
+		Bookmark:       book,
+		Content:        bytes.NewReader(capture.Content),
+		ContentType:    capture.ContentType,
+		ArchivalPolicy: h.archivalPolicy(r),
+		Capture:        capture,
+	}
+
+	book, isFatalErr, err := core.ProcessBookmark(request)
+	if err != nil && isFatalErr {
+		panic(fmt.Errorf("failed to process bookmark: %v", err))
+	}
+
+	// Unlike fetched page, captured page can't be processed again later,
+	// so it's not saved when the processing failed. Files of the
+	// existing bookmark that it's forced over aren't removed, though.
+	if err != nil {
+		if !exist {
+			core.RemoveBookmarkArtifacts(h.DataDir, book.ID)
+		}
+
+		msg := fmt.Sprintf("failed to process captured page: %v", err)
+		writeAPIError(w, http.StatusUnprocessableEntity, msg)
+		return
+	}
+
+	// Make sure bookmark's title not empty
+	core.EnsureTitle(&book)
+
+	results, err := h.DB.SaveBookmarks(book)
+	if err != nil || len(results) == 0 {
+		panic(fmt.Errorf("failed to save bookmark: %v", err))
+	}
+	book = results[0]
+	h.Webhooks.send(eventBookmarkCreated, book)
+
+	w.Header().Set("Content-Type", "application/json")
+	err = json.NewEncoder(w).Encode(&book)
+	checkError(err)
+}
@@ -0,0 +1,117 @@
+package webserver
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"shiori/internal/model"
+	"github.com/julienschmidt/httprouter"
+)
+
+func Test_apiInsertCapture(t *testing.T) {
+	hdl, cleanup := newTestHandler(t)
+	defer cleanup()
+
+	mhtml := "MIME-Version: 1.0\r\n" +
+		"Content-Type: multipart/related; boundary=\"boundary\"\r\n\r\n" +
+		"--boundary\r\n" +
+		"Content-Type: text/html\r\n" +
+		"Content-Location: https://example.com/receipt?utm_source=mail\r\n\r\n" +
+		"<html><head><title>Receipt</title></head><body><p onclick=\"alert(1)\">Paid</p><script>alert(1)</script></body></html>\r\n" +
+		"--boundary--\r\n"
+
+	router := httprouter.New()
+	router.POST("/api/bookmarks/capture", hdl.apiInsertCapture)
+	router.PanicHandler = hdl.handlePanic
+
+	tests := []struct {
+		name       string
+		query      string
+		body       string
+		wantStatus int
+	}{
+		{"mhtml", "", mhtml, http.StatusOK},
+		{"same url", "", mhtml, http.StatusConflict},
+		{"forced", "&force=true", mhtml, http.StatusOK},
+		{"not captured", "", "<html><body>page</body></html>", http.StatusBadRequest},
+	}
+
+	var firstID int
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rec := httptest.NewRecorder()
+			req := newOwnerRequest("POST", "/api/bookmarks/capture?tags=receipt,Tax"+tt.query, strings.NewReader(tt.body))
+			router.ServeHTTP(rec, req)
+
+			if rec.Code != tt.wantStatus {
+				t.Fatalf("apiInsertCapture() status = %d, want %d, body = %s", rec.Code, tt.wantStatus, rec.Body)
+			}
+
+			if tt.wantStatus != http.StatusOK {
+				return
+			}
+
+			book := model.Bookmark{}
+			if err := json.NewDecoder(rec.Body).Decode(&book); err != nil {
+				t.Fatal(err)
+			}
+
+			if book.URL != "https://example.com/receipt" || len(book.Tags) != 2 {
+				t.Errorf("apiInsertCapture() = %q with tags %v", book.URL, book.Tags)
+			}
+
+			if firstID == 0 {
+				firstID = book.ID
+			} else if book.ID != firstID {
+				t.Errorf("forced capture saved as bookmark %d, want %d", book.ID, firstID)
+			}
+
+			saved, exist := hdl.DB.GetBookmark(book.ID, "")
+			if !exist {
+				t.Fatalf("bookmark %d is not saved", book.ID)
+			}
+
+			if strings.Contains(saved.HTML, "alert") {
+				t.Errorf("captured page is not sanitized: %s", saved.HTML)
+			}
+		})
+	}
+}
+
+func Test_apiInsertCaptureLarge(t *testing.T) {
+	hdl, cleanup := newTestHandler(t)
+	defer cleanup()
+
+	hdl.MaxBodySize = 1 << 20
+	hdl.MaxUploadSize = 32 << 20
+
+	router := httprouter.New()
+	router.POST("/api/bookmarks/capture", hdl.apiInsertCapture)
+	router.PanicHandler = hdl.handlePanic
+	server := hdl.limitRequestBody(router)
+
+	// Captured page is usually larger than the limit of other requests
+	mhtml := "MIME-Version: 1.0\r\n" +
+		"Content-Type: multipart/related; boundary=\"boundary\"\r\n\r\n" +
+		"--boundary\r\n" +
+		"Content-Type: text/html\r\n" +
+		"Content-Location: https://example.com/report\r\n\r\n" +
+		"<html><head><title>Report</title></head><body><p>" +
+		strings.Repeat("lorem ipsum ", 1<<17) +
+		"</p></body></html>\r\n" +
+		"--boundary--\r\n"
+	if int64(len(mhtml)) <= hdl.MaxBodySize {
+		t.Fatalf("captured page has %d bytes, want more than %d", len(mhtml), hdl.MaxBodySize)
+	}
+
+	rec := httptest.NewRecorder()
+	req := newOwnerRequest("POST", "/api/bookmarks/capture", strings.NewReader(mhtml))
+	server.ServeHTTP(rec, req)
+
+	if rec.Code != http.StatusOK {
+		t.Errorf("apiInsertCapture() status = %d, want %d", rec.Code, http.StatusOK)
+	}
+}
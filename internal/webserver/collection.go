@@ -0,0 +1,240 @@
+package webserver
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"path"
+	"strconv"
+	"strings"
+
+	"shiori/internal/database"
+	"shiori/internal/model"
+	"github.com/julienschmidt/httprouter"
+)
+
+// collectionDescendants returns the ID of collection followed by the IDs
+// of every collection nested in it, however deep they are.
+func collectionDescendants(collections []model.Collection, id int) []int {
+	children := map[int][]int{}
+	for _, collection := range collections {
+		children[collection.ParentID] = append(children[collection.ParentID], collection.ID)
+	}
+
+	ids := []int{id}
+	for i := 0; i < len(ids); i++ {
+		ids = append(ids, children[ids[i]]...)
+	}
+
+	return ids
+}
+
+// findCollection returns the collection with the ID in the list.
+func findCollection(collections []model.Collection, id int) (model.Collection, bool) {
+	for _, collection := range collections {
+		if collection.ID == id {
+			return collection, true
+		}
+	}
+
+	return model.Collection{}, false
+}
+
+// expandCollectionFilter makes the collection filter of bookmarks include
+// the collections nested in it, so bookmarks in sub folders are listed too.
+func (h *handler) expandCollectionFilter(opts *database.GetBookmarksOptions) {
+	if len(opts.CollectionIDs) == 0 {
+		return
+	}
+
+	collections, err := h.DB.GetCollections(opts.OwnerID)
+	checkError(err)
+
+	ids := []int{}
+	for _, id := range opts.CollectionIDs {
+		ids = append(ids, collectionDescendants(collections, id)...)
+	}
+
+	opts.CollectionIDs = ids
+}
+
+// collectionParam returns the collection that client may manage,
+// which is identified by `id` in URL, and the collections it can see.
+func (h *handler) collectionParam(r *http.Request, ps httprouter.Params) (model.Collection, []model.Collection) {
+	id, err := strconv.Atoi(ps.ByName("id"))
+	if err != nil {
+		panic(newClientError(http.StatusBadRequest, fmt.Errorf("collection id must be a number")))
+	}
+
+	collections, err := h.DB.GetCollections(bookmarkOwner(r))
+	checkError(err)
+
+	collection, exist := findCollection(collections, id)
+	if !exist {
+		panic(newClientError(http.StatusNotFound, fmt.Errorf("collection not found")))
+	}
+
+	return collection, collections
+}
+
+// decodeCollection reads collection from request body, then makes sure its
+// name isn't empty and its parent is one of the collections client can see.
+func (h *handler) decodeCollection(r *http.Request, collections []model.Collection) model.Collection {
+	request := model.Collection{}
+	err := h.decodeJSON(r.Body, &request)
+	checkError(err)
+
+	request.Name = strings.Join(strings.Fields(request.Name), " ")
+	if request.Name == "" {
+		panic(newClientError(http.StatusBadRequest, fmt.Errorf("name must not empty")))
+	}
+
+	if request.ParentID != 0 {
+		if _, exist := findCollection(collections, request.ParentID); !exist {
+			panic(newClientError(http.StatusBadRequest, fmt.Errorf("parent collection %d not found", request.ParentID)))
+		}
+	}
+
+	return request
+}
+
+// apiGetCollections is handler for GET /api/collections
+//
+// It lists the collections, each with the number of bookmarks directly inside
+// it. They're listed flat, so client builds the tree following `parentId`.
+func (h *handler) apiGetCollections(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	ownerID, err := bookmarkListOwner(r)
+	if err != nil {
+		panic(newClientError(http.StatusForbidden, err))
+	}
+
+	collections, err := h.DB.GetCollections(ownerID)
+	checkError(err)
+
+	w.Header().Set("Content-Type", "application/json")
+	err = json.NewEncoder(w).Encode(&collections)
+	checkError(err)
+}
+
+// apiInsertCollection is handler for POST /api/collections
+//
+// It creates a collection with `name`, nested in collection `parentId`,
+// or at the top level when it's zero.
+func (h *handler) apiInsertCollection(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	ownerID := newBookmarkOwner(r)
+	collections, err := h.DB.GetCollections(ownerID)
+	checkError(err)
+
+	collection := h.decodeCollection(r, collections)
+	collection.OwnerID = ownerID
+
+	collection, err = h.DB.SaveCollection(collection)
+	checkError(err)
+
+	w.Header().Set("Location", path.Join(h.RootPath, "api/collections", strconv.Itoa(collection.ID)))
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	err = json.NewEncoder(w).Encode(&collection)
+	checkError(err)
+}
+
+// apiUpdateCollection is handler for PUT /api/collections/:id
+//
+// It renames the collection and moves it into other collection, which
+// can't be the collection itself or any collection nested in it.
+func (h *handler) apiUpdateCollection(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	collection, collections := h.collectionParam(r, ps)
+
+	request := h.decodeCollection(r, collections)
+	for _, id := range collectionDescendants(collections, collection.ID) {
+		if request.ParentID == id {
+			panic(newClientError(http.StatusBadRequest, fmt.Errorf("collection can't be moved into itself")))
+		}
+	}
+
+	collection.Name = request.Name
+	collection.ParentID = request.ParentID
+
+	collection, err := h.DB.SaveCollection(collection)
+	checkError(err)
+
+	w.Header().Set("Content-Type", "application/json")
+	err = json.NewEncoder(w).Encode(&collection)
+	checkError(err)
+}
+
+// apiDeleteCollection is handler for DELETE /api/collections/:id
+//
+// It removes the collection, while its bookmarks and collections are moved
+// to its parent, so nothing is deleted together with it.
+func (h *handler) apiDeleteCollection(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	collection, _ := h.collectionParam(r, ps)
+
+	err := h.DB.DeleteCollection(collection.ID)
+	checkError(err)
+
+	fmt.Fprint(w, 1)
+}
+
+// apiSetBookmarksCollection is handler for PUT /api/bookmarks/collection
+//
+// It moves bookmarks in `ids` into collection `collectionId`, or out of any
+// collection when it's zero. Bookmarks can only be in one collection at once,
+// unlike tags. It returns the bookmarks in their new collection.
+func (h *handler) apiSetBookmarksCollection(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	// Decode request
+	request := struct {
+		IDs          []int `json:"ids"`
+		CollectionID int   `json:"collectionId"`
+	}{}
+
+	err := h.decodeJSON(r.Body, &request)
+	checkError(err)
+
+	// Validate input
+	if len(request.IDs) == 0 {
+		panic(newClientError(http.StatusBadRequest, fmt.Errorf("IDs must not empty")))
+	}
+
+	ownerID := bookmarkOwner(r)
+	if request.CollectionID != 0 {
+		collections, err := h.DB.GetCollections(ownerID)
+		checkError(err)
+
+		if _, exist := findCollection(collections, request.CollectionID); !exist {
+			panic(newClientError(http.StatusNotFound, fmt.Errorf("collection not found")))
+		}
+	}
+
+	// Only the bookmarks accessible by the account are moved
+	filter := database.GetBookmarksOptions{
+		IDs:     request.IDs,
+		OwnerID: ownerID,
+	}
+
+	bookmarks, err := h.DB.GetBookmarks(filter)
+	checkError(err)
+	if len(bookmarks) == 0 {
+		panic(newClientError(http.StatusNotFound, fmt.Errorf("no bookmark with matching ids")))
+	}
+
+	filter.IDs = []int{}
+	for _, book := range bookmarks {
+		filter.IDs = append(filter.IDs, book.ID)
+	}
+
+	// Update database, then return the bookmarks in their new collection
+	nMoved, err := h.DB.SetBookmarksCollection(filter.IDs, request.CollectionID)
+	checkError(err)
+
+	bookmarks, err = h.DB.GetBookmarks(filter)
+	checkError(err)
+
+	if nMoved > 0 {
+		h.Webhooks.send(eventBookmarkUpdated, bookmarks...)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	err = json.NewEncoder(w).Encode(&bookmarks)
+	checkError(err)
+}
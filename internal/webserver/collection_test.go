@@ -0,0 +1,142 @@
+package webserver
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"shiori/internal/model"
+	"github.com/julienschmidt/httprouter"
+)
+
+func Test_collectionDescendants(t *testing.T) {
+	collections := []model.Collection{
+		{ID: 1, Name: "Work"},
+		{ID: 2, Name: "Projects", ParentID: 1},
+		{ID: 3, Name: "Old", ParentID: 2},
+		{ID: 4, Name: "Home"},
+	}
+
+	tests := []struct {
+		id   int
+		want []int
+	}{
+		{1, []int{1, 2, 3}},
+		{2, []int{2, 3}},
+		{4, []int{4}},
+		{99, []int{99}},
+	}
+
+	for _, tt := range tests {
+		got := collectionDescendants(collections, tt.id)
+		if fmt.Sprint(got) != fmt.Sprint(tt.want) {
+			t.Errorf("collectionDescendants(%d) = %v, want %v", tt.id, got, tt.want)
+		}
+	}
+}
+
+func Test_collections(t *testing.T) {
+	hdl, cleanup := newTestHandler(t)
+	defer cleanup()
+
+	_, err := hdl.DB.SaveBookmarks(
+		model.Bookmark{ID: 1, URL: "https://example.com/1", Title: "One"},
+		model.Bookmark{ID: 2, URL: "https://example.com/2", Title: "Two"},
+		model.Bookmark{ID: 3, URL: "https://example.com/3", Title: "Three"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	withID := func(handle httprouter.Handle, id string) httprouter.Handle {
+		return func(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
+			handle(w, r, httprouter.Params{{Key: "id", Value: id}})
+		}
+	}
+
+	router := httprouter.New()
+	router.GET("/api/collections", hdl.apiGetCollections)
+	router.POST("/api/collections", hdl.apiInsertCollection)
+	router.PUT("/api/collections/1", withID(hdl.apiUpdateCollection, "1"))
+	router.DELETE("/api/collections/2", withID(hdl.apiDeleteCollection, "2"))
+	router.PUT("/api/bookmarks/collection", hdl.apiSetBookmarksCollection)
+	router.PanicHandler = hdl.handlePanic
+
+	serve := func(method, target, body string) *httptest.ResponseRecorder {
+		rec := httptest.NewRecorder()
+		router.ServeHTTP(rec, newOwnerRequest(method, target, strings.NewReader(body)))
+		return rec
+	}
+
+	// Create Work, then Projects inside it
+	for _, body := range []string{`{"name": " Work "}`, `{"name": "Projects", "parentId": 1}`} {
+		if rec := serve("POST", "/api/collections", body); rec.Code != http.StatusCreated {
+			t.Fatalf("apiInsertCollection(%s) status = %d: %s", body, rec.Code, rec.Body)
+		}
+	}
+
+	tests := []struct {
+		name       string
+		method     string
+		target     string
+		body       string
+		wantStatus int
+	}{
+		{"empty name", "POST", "/api/collections", `{"name": " "}`, http.StatusBadRequest},
+		{"unknown parent", "POST", "/api/collections", `{"name": "Home", "parentId": 99}`, http.StatusBadRequest},
+		{"move into itself", "PUT", "/api/collections/1", `{"name": "Work", "parentId": 1}`, http.StatusBadRequest},
+		{"move into its child", "PUT", "/api/collections/1", `{"name": "Work", "parentId": 2}`, http.StatusBadRequest},
+		{"rename", "PUT", "/api/collections/1", `{"name": "Office"}`, http.StatusOK},
+		{"move to unknown collection", "PUT", "/api/bookmarks/collection", `{"ids": [1], "collectionId": 99}`, http.StatusNotFound},
+		{"move to parent", "PUT", "/api/bookmarks/collection", `{"ids": [1], "collectionId": 1}`, http.StatusOK},
+		{"move to child", "PUT", "/api/bookmarks/collection", `{"ids": [2], "collectionId": 2}`, http.StatusOK},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if rec := serve(tt.method, tt.target, tt.body); rec.Code != tt.wantStatus {
+				t.Errorf("status = %d, want %d: %s", rec.Code, tt.wantStatus, rec.Body)
+			}
+		})
+	}
+
+	// Listing a collection includes the bookmarks in its sub collections
+	listIDs := func(query string) string {
+		rec := httptest.NewRecorder()
+		hdl.apiGetBookmarks(rec, newOwnerRequest("GET", "/api/bookmarks?"+query, nil), nil)
+
+		resp := struct {
+			Bookmarks []model.Bookmark `json:"bookmarks"`
+		}{}
+		json.NewDecoder(rec.Body).Decode(&resp)
+
+		ids := []int{}
+		for _, book := range resp.Bookmarks {
+			ids = append(ids, book.ID)
+		}
+
+		return fmt.Sprint(ids)
+	}
+
+	if got := listIDs("collection=1"); got != "[2 1]" {
+		t.Errorf("apiGetBookmarks() in collection 1 = %s, want [2 1]", got)
+	}
+
+	if got := listIDs("collection=2"); got != "[2]" {
+		t.Errorf("apiGetBookmarks() in collection 2 = %s, want [2]", got)
+	}
+
+	// Deleting Projects moves its bookmark to Office
+	if rec := serve("DELETE", "/api/collections/2", ""); rec.Code != http.StatusOK {
+		t.Fatalf("apiDeleteCollection() status = %d: %s", rec.Code, rec.Body)
+	}
+
+	collections := []model.Collection{}
+	json.NewDecoder(serve("GET", "/api/collections", "").Body).Decode(&collections)
+	want := []model.Collection{{ID: 1, Name: "Office", NBookmarks: 2}}
+	if fmt.Sprint(collections) != fmt.Sprint(want) {
+		t.Errorf("apiGetCollections() = %+v, want %+v", collections, want)
+	}
+}
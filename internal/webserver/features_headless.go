@@ -0,0 +1,7 @@
+// +build headless
+
+package webserver
+
+func init() {
+	features = append(features, "screenshot", "pdf")
+}
@@ -0,0 +1,159 @@
+package webserver
+
+import (
+	"encoding/xml"
+	"net/http"
+	"path"
+	"strconv"
+	"strings"
+	"time"
+
+	"shiori/internal/database"
+	"shiori/internal/model"
+	"github.com/julienschmidt/httprouter"
+)
+
+// feedSize is max number of the latest bookmarks in feed.
+var feedSize = 50
+
+// atomFeed is the feed in Atom format (RFC 4287).
+type atomFeed struct {
+	XMLName xml.Name    `xml:"http://www.w3.org/2005/Atom feed"`
+	ID      string      `xml:"id"`
+	Title   string      `xml:"title"`
+	Updated string      `xml:"updated"`
+	Author  atomPerson  `xml:"author"`
+	Links   []atomLink  `xml:"link"`
+	Entries []atomEntry `xml:"entry"`
+}
+
+type atomPerson struct {
+	Name string `xml:"name"`
+}
+
+type atomLink struct {
+	Rel  string `xml:"rel,attr,omitempty"`
+	Type string `xml:"type,attr,omitempty"`
+	Href string `xml:"href,attr"`
+}
+
+type atomCategory struct {
+	Term string `xml:"term,attr"`
+}
+
+type atomEntry struct {
+	ID         string         `xml:"id"`
+	Title      string         `xml:"title"`
+	Updated    string         `xml:"updated"`
+	Published  string         `xml:"published,omitempty"`
+	Author     *atomPerson    `xml:"author,omitempty"`
+	Links      []atomLink     `xml:"link"`
+	Summary    string         `xml:"summary,omitempty"`
+	Categories []atomCategory `xml:"category"`
+}
+
+// atomTime converts time in database, i.e. UTC in "2006-01-02 15:04:05"
+// format, into RFC3339 that required by Atom.
+func atomTime(dbTime string) string {
+	t, err := time.Parse("2006-01-02 15:04:05", dbTime)
+	if err != nil {
+		return ""
+	}
+
+	return t.UTC().Format(time.RFC3339)
+}
+
+// newAtomFeed creates feed of the bookmarks, whose links are relative to
+// baseURL, i.e. the scheme, host and root path of the server.
+func newAtomFeed(title, selfURL, baseURL string, bookmarks []model.Bookmark) atomFeed {
+	feed := atomFeed{
+		ID:      selfURL,
+		Title:   title,
+		Updated: time.Unix(0, 0).UTC().Format(time.RFC3339),
+		Author:  atomPerson{Name: "Shiori"},
+		Links: []atomLink{
+			{Rel: "self", Type: "application/atom+xml", Href: selfURL},
+			{Rel: "alternate", Type: "text/html", Href: baseURL + "/"},
+		},
+		Entries: []atomEntry{},
+	}
+
+	for _, book := range bookmarks {
+		strID := strconv.Itoa(book.ID)
+		contentURL := baseURL + path.Join("/bookmark", strID, "content")
+
+		entry := atomEntry{
+			ID:        contentURL,
+			Title:     book.Title,
+			Updated:   atomTime(book.Modified),
+			Published: atomTime(book.Created),
+			Summary:   book.Excerpt,
+			Links: []atomLink{
+				{Rel: "alternate", Href: book.URL},
+				{Rel: "related", Type: "text/html", Href: contentURL},
+			},
+		}
+
+		if entry.Updated == "" {
+			entry.Updated = entry.Published
+		}
+
+		if book.Author != "" {
+			entry.Author = &atomPerson{Name: book.Author}
+		}
+
+		for _, tag := range book.Tags {
+			entry.Categories = append(entry.Categories, atomCategory{Term: tag.Name})
+		}
+
+		// Feed is updated whenever its latest entry is
+		if entry.Updated > feed.Updated {
+			feed.Updated = entry.Updated
+		}
+
+		feed.Entries = append(feed.Entries, entry)
+	}
+
+	return feed
+}
+
+// serveFeed is handler for GET /feed.xml and GET /tag/:name/feed.xml
+//
+// It serves the latest public bookmarks as Atom feed, optionally only the
+// ones with the tag, so they can be followed in feed reader. Private
+// bookmarks are never included, whoever requests it. The links are made
+// absolute using host of the request.
+func (h *handler) serveFeed(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	tagName := strings.TrimSpace(ps.ByName("name"))
+
+	filter := database.GetBookmarksOptions{
+		PublicOnly:  true,
+		OrderMethod: database.ByLastAdded,
+		Limit:       feedSize,
+	}
+
+	title := "Shiori bookmarks"
+	if tagName != "" {
+		filter.Tags = []string{tagName}
+		title += " tagged " + tagName
+	}
+
+	bookmarks, err := h.DB.GetBookmarks(filter)
+	checkError(err)
+
+	scheme := "http"
+	if r.TLS != nil {
+		scheme = "https"
+	}
+
+	baseURL := scheme + "://" + r.Host + strings.TrimSuffix(h.RootPath, "/")
+	selfURL := scheme + "://" + r.Host + r.URL.Path
+	feed := newAtomFeed(title, selfURL, baseURL, bookmarks)
+
+	w.Header().Set("Content-Type", "application/atom+xml; charset=utf-8")
+	_, err = w.Write([]byte(xml.Header))
+	checkError(err)
+
+	err = xml.NewEncoder(w).Encode(&feed)
+	checkError(err)
+}
@@ -0,0 +1,97 @@
+package webserver
+
+import (
+	"encoding/xml"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"shiori/internal/model"
+	"github.com/julienschmidt/httprouter"
+)
+
+func Test_serveFeed(t *testing.T) {
+	hdl, cleanup := newTestHandler(t)
+	defer cleanup()
+
+	hdl.RootPath = "/shiori/"
+	_, err := hdl.DB.SaveBookmarks(
+		model.Bookmark{ID: 1, URL: "https://example.com/go", Title: "Go", Public: 1,
+			Created: "2020-01-01 10:00:00", Tags: []model.Tag{{Name: "golang"}}},
+		model.Bookmark{ID: 2, URL: "https://example.com/secret", Title: "Secret",
+			Created: "2020-01-02 10:00:00", Tags: []model.Tag{{Name: "golang"}}},
+		model.Bookmark{ID: 3, URL: "https://example.com/rust", Title: "Rust", Public: 1,
+			Created: "2020-01-03 10:00:00", Tags: []model.Tag{{Name: "rust"}}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name       string
+		target     string
+		tag        string
+		wantTitles []string
+	}{
+		{"all public", "/shiori/feed.xml", "", []string{"Rust", "Go"}},
+		{"tagged", "/shiori/tag/golang/feed.xml", "golang", []string{"Go"}},
+		{"unknown tag", "/shiori/tag/python/feed.xml", "python", []string{}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest("GET", "http://bookmarks.test"+tt.target, nil)
+			rec := httptest.NewRecorder()
+			hdl.serveFeed(rec, req, httprouter.Params{{Key: "name", Value: tt.tag}})
+
+			if ct := rec.Header().Get("Content-Type"); !strings.HasPrefix(ct, "application/atom+xml") {
+				t.Errorf("serveFeed() content type = %q, want Atom", ct)
+			}
+
+			feed := atomFeed{}
+			if err := xml.NewDecoder(rec.Body).Decode(&feed); err != nil {
+				t.Fatal(err)
+			}
+
+			if feed.ID != "http://bookmarks.test"+tt.target {
+				t.Errorf("feed ID = %q, want the feed URL", feed.ID)
+			}
+
+			titles := []string{}
+			for _, entry := range feed.Entries {
+				titles = append(titles, entry.Title)
+			}
+
+			if strings.Join(titles, ",") != strings.Join(tt.wantTitles, ",") {
+				t.Errorf("feed entries = %v, want %v", titles, tt.wantTitles)
+			}
+		})
+	}
+}
+
+func Test_newAtomFeed(t *testing.T) {
+	book := model.Bookmark{
+		ID:       7,
+		URL:      "https://example.com/post",
+		Title:    "Post",
+		Author:   "Jane",
+		Created:  "2021-03-04 05:06:07",
+		Modified: "2021-03-05 00:00:00",
+		Tags:     []model.Tag{{Name: "news"}},
+	}
+
+	feed := newAtomFeed("Feed", "https://bookmarks.test/feed.xml", "https://bookmarks.test", []model.Bookmark{book})
+	if feed.Updated != "2021-03-05T00:00:00Z" {
+		t.Errorf("feed updated = %q, want the latest entry", feed.Updated)
+	}
+
+	entry := feed.Entries[0]
+	if entry.ID != "https://bookmarks.test/bookmark/7/content" || entry.Published != "2021-03-04T05:06:07Z" ||
+		entry.Author == nil || entry.Author.Name != "Jane" ||
+		len(entry.Categories) != 1 || entry.Categories[0].Term != "news" {
+		t.Errorf("entry = %+v", entry)
+	}
+
+	if entry.Links[0].Href != book.URL {
+		t.Errorf("entry link = %q, want %q", entry.Links[0].Href, book.URL)
+	}
+}
@@ -6,9 +6,6 @@ import (
 	"fmt"
 	"io"
 	"net/http"
-	"os"
-	fp "path/filepath"
-	"strconv"
 
 	"shiori/internal/core"
 	"shiori/internal/model"
@@ -19,17 +16,22 @@ import (
 func (h *handler) apiInsertViaExtension(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
 	// Decode request
 	request := model.Bookmark{}
-	err := json.NewDecoder(r.Body).Decode(&request)
+	err := h.decodeJSON(r.Body, &request)
 	checkError(err)
 
+	if err = validateMetadata(request.Metadata); err != nil {
+		panic(newClientError(http.StatusBadRequest, err))
+	}
+
 	// Clean up bookmark URL
-	request.URL, err = core.RemoveUTMParams(request.URL)
+	request.URL, err = core.RemoveUTMParams(request.URL, h.KeptQueryParams)
 	if err != nil {
-		panic(fmt.Errorf("failed to clean URL: %v", err))
+		panic(newClientError(http.StatusBadRequest, fmt.Errorf("failed to clean URL: %v", err)))
 	}
 
-	// Check if bookmark already exists.
-	book, exist := h.DB.GetBookmark(0, request.URL)
+	// Check if the account already has the bookmark.
+	book, exist, err := h.findBookmarkByURL(newBookmarkOwner(r), request.URL)
+	checkError(err)
 
 	// If it already exists, we need to set ID and tags.
 	if exist {
@@ -47,6 +49,7 @@ func (h *handler) apiInsertViaExtension(w http.ResponseWriter, r *http.Request,
 		}
 	} else {
 		book = request
+		book.OwnerID = newBookmarkOwner(r)
 		book.ID, err = h.DB.CreateNewID("bookmark")
 		if err != nil {
 			panic(fmt.Errorf("failed to create ID: %v", err))
@@ -60,7 +63,7 @@ func (h *handler) apiInsertViaExtension(w http.ResponseWriter, r *http.Request,
 	var contentBuffer io.Reader
 
 	if book.HTML == "" {
-		contentBuffer, contentType, _ = core.DownloadBookmark(book.URL)
+		contentBuffer, contentType, book.LastStatusCode, book.WaybackURL, _ = core.FetchBookmark(book.URL, h.RenderPolicy)
 	} else {
 		contentType = "text/html; charset=UTF-8"
 		contentBuffer = bytes.NewBufferString(book.HTML)
@@ -71,10 +74,14 @@ func (h *handler) apiInsertViaExtension(w http.ResponseWriter, r *http.Request,
 	if contentBuffer != nil {
 		book.CreateArchive = true
 		request := core.ProcessRequest{
-			DataDir:     h.DataDir,
-			Bookmark:    book,
-			Content:     contentBuffer,
-			ContentType: contentType,
+			DataDir:        h.DataDir,
+			Bookmark:       book,
+			Content:        contentBuffer,
+			ContentType:    contentType,
+			MaxResources:   h.MaxResources,
+			MaxSnapshots:   h.MaxSnapshots,
+			RenderPolicy:   h.RenderPolicy,
+			ArchivalPolicy: h.archivalPolicy(r),
 		}
 
 		var isFatalErr bool
@@ -89,6 +96,9 @@ func (h *handler) apiInsertViaExtension(w http.ResponseWriter, r *http.Request,
 		}
 	}
 
+	// Make sure bookmark's title not empty, e.g. when download failed
+	core.EnsureTitle(&book)
+
 	// Save bookmark to database
 	results, err := h.DB.SaveBookmarks(book)
 	if err != nil || len(results) == 0 {
@@ -96,6 +106,12 @@ func (h *handler) apiInsertViaExtension(w http.ResponseWriter, r *http.Request,
 	}
 	book = results[0]
 
+	if exist {
+		h.Webhooks.send(eventBookmarkUpdated, book)
+	} else {
+		h.Webhooks.send(eventBookmarkCreated, book)
+	}
+
 	// Return the new bookmark
 	w.Header().Set("Content-Type", "application/json")
 	err = json.NewEncoder(w).Encode(&book)
@@ -106,23 +122,21 @@ func (h *handler) apiInsertViaExtension(w http.ResponseWriter, r *http.Request,
 func (h *handler) apiDeleteViaExtension(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
 	// Decode request
 	request := model.Bookmark{}
-	err := json.NewDecoder(r.Body).Decode(&request)
+	err := h.decodeJSON(r.Body, &request)
+	checkError(err)
+
+	// Check if the account has the bookmark.
+	book, exist, err := h.findBookmarkByURL(newBookmarkOwner(r), request.URL)
 	checkError(err)
 
-	// Check if bookmark already exists.
-	book, exist := h.DB.GetBookmark(0, request.URL)
 	if exist {
 		// Delete bookmarks
-		err = h.DB.DeleteBookmarks(book.ID)
+		_, err = h.DB.DeleteBookmarks(book.ID)
 		checkError(err)
+		h.Webhooks.send(eventBookmarkDeleted, book)
 
 		// Delete thumbnail image and archives from local disk
-		strID := strconv.Itoa(book.ID)
-		imgPath := fp.Join(h.DataDir, "thumb", strID)
-		archivePath := fp.Join(h.DataDir, "archive", strID)
-
-		os.Remove(imgPath)
-		os.Remove(archivePath)
+		core.RemoveBookmarkArtifacts(h.DataDir, book.ID)
 	}
 
 	fmt.Fprint(w, 1)
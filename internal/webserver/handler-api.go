@@ -1,446 +1,2322 @@
 package webserver
 
 import (
+	"bytes"
+	"encoding/csv"
 	"encoding/json"
 	"fmt"
+	"html"
+	"io"
+	"io/ioutil"
 	"math"
+	"mime"
+	"mime/multipart"
 	"net/http"
+	"net/textproto"
+	nurl "net/url"
 	"os"
 	"path"
 	fp "path/filepath"
+	"regexp"
+	"runtime"
 	"strconv"
 	"strings"
 	"sync"
+	"time"
 
 	"shiori/internal/core"
 	"shiori/internal/database"
 	"shiori/internal/model"
 	"github.com/julienschmidt/httprouter"
+	cch "github.com/patrickmn/go-cache"
+	"github.com/sirupsen/logrus"
 	"golang.org/x/crypto/bcrypt"
 )
 
-// apiGetBookmarks is handler for GET /api/bookmarks
-func (h *handler) apiGetBookmarks(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
-	// Get URL queries
-	keyword := r.URL.Query().Get("keyword")
-	strPage := r.URL.Query().Get("page")
-	strTags := r.URL.Query().Get("tags")
-	strExcludedTags := r.URL.Query().Get("exclude")
+// apiGetVersion is handler for GET /api/version
+func (h *handler) apiGetVersion(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	resp := struct {
+		BuildInfo
+		GoVersion string   `json:"goVersion"`
+		Features  []string `json:"features"`
+	}{h.Build, runtime.Version(), features}
+
+	w.Header().Set("Content-Type", "application/json")
+	err := json.NewEncoder(w).Encode(&resp)
+	checkError(err)
+}
+
+// apiGetSchema is handler for GET /api/schema
+//
+// Returns the `current` version of database schema and the `target` version
+// required by this binary. Pending migrations are applied on startup, so
+// both are the same, unless a newer binary has migrated the database since
+// this server started.
+func (h *handler) apiGetSchema(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	version, err := h.DB.SchemaVersion()
+	checkError(err)
+
+	w.Header().Set("Content-Type", "application/json")
+	err = json.NewEncoder(w).Encode(&version)
+	checkError(err)
+}
+
+// maintenanceStatus is the maintenance mode of the server.
+type maintenanceStatus struct {
+	ReadOnly bool `json:"readOnly"`
+}
+
+// apiGetMaintenance is handler for GET /api/maintenance
+func (h *handler) apiGetMaintenance(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	status := maintenanceStatus{ReadOnly: h.isReadOnly()}
+
+	w.Header().Set("Content-Type", "application/json")
+	err := json.NewEncoder(w).Encode(&status)
+	checkError(err)
+}
+
+// apiSetMaintenance is handler for PUT /api/maintenance
+//
+// When `readOnly` is true, every request that might change something is
+// rejected with 503 Service Unavailable, while bookmarks and their content
+// are still served. Background jobs, e.g. archive pruning, are paused as well.
+// It's meant for backing up or migrating the database safely.
+func (h *handler) apiSetMaintenance(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	status := maintenanceStatus{}
+	err := h.decodeJSON(r.Body, &status)
+	checkError(err)
+
+	h.setReadOnly(status.ReadOnly)
+	if status.ReadOnly {
+		logrus.Info("server is now in read-only mode")
+	} else {
+		logrus.Info("server is no longer in read-only mode")
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	err = json.NewEncoder(w).Encode(&status)
+	checkError(err)
+}
+
+// apiBackup is handler for GET /api/backup
+//
+// It writes backup of the database, thumbnails and archives as gzipped tar
+// file, which is restored by `shiori restore` command. The database is
+// copied first, so the backup is consistent even while the server is in use.
+// MySQL and PostgreSQL databases can't be included, since they should be
+// backed up using their own dump tool, so 501 is returned for them unless
+// `skipDatabase=true` is specified to back up only the data dir.
+func (h *handler) apiBackup(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	skipDatabase, _ := strconv.ParseBool(r.URL.Query().Get("skipDatabase"))
+
+	tmpDir, err := ioutil.TempDir("", "shiori-backup-")
+	checkError(err)
+	defer os.RemoveAll(tmpDir)
+
+	dbPath := fp.Join(tmpDir, "shiori.db")
+	err = h.DB.Backup(dbPath)
+	switch {
+	case err == database.ErrBackupUnsupported && skipDatabase:
+		dbPath = ""
+	case err == database.ErrBackupUnsupported:
+		msg := "database can't be backed up by Shiori, back it up using its own dump tool " +
+			"and use skipDatabase=true to back up the rest"
+		writeAPIError(w, http.StatusNotImplemented, msg)
+		return
+	default:
+		checkError(err)
+	}
 
-	tags := strings.Split(strTags, ",")
-	if len(tags) == 1 && tags[0] == "" {
-		tags = []string{}
+	// Writing the whole data dir might take longer than the server's
+	// write timeout, which would cut the backup short
+	http.NewResponseController(w).SetWriteDeadline(time.Time{})
+
+	created := time.Now().UTC()
+	manifest := core.BackupManifest{
+		Version: h.Build.Version,
+		Created: created.Format(time.RFC3339),
 	}
 
-	excludedTags := strings.Split(strExcludedTags, ",")
-	if len(excludedTags) == 1 && excludedTags[0] == "" {
-		excludedTags = []string{}
+	fileName := fmt.Sprintf("shiori-backup-%s.tar.gz", created.Format("20060102-150405"))
+	w.Header().Set("Content-Type", "application/gzip")
+	w.Header().Set("Content-Disposition", `attachment; filename="`+fileName+`"`)
+
+	// The response has been started, so failure can only be logged
+	if err = core.WriteBackup(w, h.DataDir, dbPath, manifest); err != nil {
+		logrus.WithError(err).Error("failed to write backup")
 	}
+}
 
+// apiGetBookmarks is handler for GET /api/bookmarks
+//
+// The `keyword` may scope a term to a field using `title:`, `url:` or `tag:`
+// prefix, e.g. `title:"web server" tag:tutorial golang`. Terms without prefix
+// are searched in every field. See database.ParseKeyword for the grammar.
+//
+// The `searchFields` limits the fields that terms without prefix are searched
+// in, e.g. `searchFields=title,excerpt` to skip the slow search in content.
+// The fields are `url`, `title`, `excerpt` and `content`, all by default.
+//
+// When `regex=true` is specified, the keyword is a case insensitive regular
+// expression matched against title or excerpt instead. Stick to the syntax
+// that common in every database, e.g. `^go(lang)? (tips|tricks)$`. Pattern
+// that takes too long to evaluate is rejected.
+//
+// The `tags` and `exclude` are comma separated tag names. Bookmark must have
+// all of the included tags, and is never returned when it has any of the
+// excluded tags, even if that tag is included as well. Use `*` to match any tag.
+// When `untagged=true` is specified, only bookmarks without any tag are returned.
+//
+// When `broken=true` is specified, only bookmarks whose URL was found broken
+// by the link checker are returned, i.e. it couldn't be reached or responded
+// with error status. Their `linkStatus` and `linkChecked` tell the result.
+//
+// The `recentDays` limits the result to bookmarks added in that many last
+// days, e.g. `recentDays=7` for bookmarks added in the last week.
+//
+// The `created_after`, `created_before`, `modified_after` and `modified_before`
+// limit the result to bookmarks added or modified in that date range. They're
+// either RFC3339, date in UTC or Unix epoch. The lower bound is inclusive while
+// the upper one is exclusive, e.g. `created_after=2023-03-01` with
+// `created_before=2023-04-01` for bookmarks added in March 2023.
+//
+// The `contentType` limits the result to bookmarks with that media type,
+// e.g. `application/pdf`. Wildcard subtype like `image/*` is supported.
+//
+// The `metadata` limits the result to bookmarks that have the metadata key,
+// or the exact value when specified as `key:value`, e.g. `metadata=isbn` or
+// `metadata=priority:high`. When it's specified several times, all must match.
+//
+// Bookmarks are ordered from the newest addition, unless `order=manual` is
+// specified, which orders them by position that set in PUT /api/bookmarks/order.
+// Otherwise `sort` may order them from the `newest` or `oldest` addition,
+// by `last-modified` time, by `title`, or by `domain` then title.
+//
+// When `updatedSince` is specified (RFC3339 or Unix epoch in seconds), only
+// bookmarks modified at or after that time are returned, ordered by their
+// modified time then by ID, so a page never skips a bookmark that changed
+// in between. The response also contains the tombstones of bookmarks that
+// deleted since that time, and `syncTime` which should be used as
+// `updatedSince` on the next sync once all pages have been fetched. Since
+// the comparison is inclusive, a client might receive the same bookmark
+// twice, so applying the changes must be idempotent.
+//
+// The `fields` trims each bookmark to the comma separated fields, e.g.
+// `fields=id,title,url`, named the same as in the full representation.
+// `fields=compact` is a shorthand for id, title, url and imageURL, which is
+// enough for a list. Unknown fields are ignored and reported in `Warning`
+// header. By default every field is returned.
+//
+// Authenticated account only gets the bookmarks it owns, unless it's an
+// owner that specifies `all=true` to get the bookmarks of every account.
+//
+// There are 30 bookmarks in each page, unless `per_page` is specified, which
+// is capped at the server's max page size.
+//
+// Besides `page` and `maxPage` in the body, the pages are linked in `Link`
+// header (RFC 5988) with the same queries, so generic clients can page through.
+func (h *handler) apiGetBookmarks(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	// Get URL queries
+	strPage := r.URL.Query().Get("page")
 	page, _ := strconv.Atoi(strPage)
 	if page < 1 {
 		page = 1
 	}
 
+	perPage, err := h.parsePerPage(r.URL.Query().Get("per_page"))
+	if err != nil {
+		writeAPIError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	// Sync time is taken before fetching anything, so bookmarks that
+	// modified while this request is processed will be fetched again later.
+	syncTime := time.Now().UTC().Truncate(time.Second)
+
 	// Prepare filter for database
-	searchOptions := database.GetBookmarksOptions{
-		Tags:         tags,
-		ExcludedTags: excludedTags,
-		Keyword:      keyword,
-		Limit:        30,
-		Offset:       (page - 1) * 30,
-		OrderMethod:  database.ByLastAdded,
+	searchOptions, err := parseBookmarksFilter(r)
+	if err != nil {
+		writeAPIError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	searchOptions.OwnerID, err = bookmarkListOwner(r)
+	if err != nil {
+		writeAPIError(w, http.StatusForbidden, err.Error())
+		return
+	}
+
+	h.expandCollectionFilter(&searchOptions)
+
+	fields, unknownFields := parseFieldsParam(r.URL.Query().Get("fields"))
+	if len(unknownFields) > 0 {
+		msg := fmt.Sprintf(`299 - "unknown fields are ignored: %s"`, strings.Join(unknownFields, ", "))
+		w.Header().Set("Warning", msg)
+	}
+
+	// Only sync clients use updatedSince, other clients use modified_after
+	updatedSince := ""
+	if r.URL.Query().Get("updatedSince") != "" {
+		updatedSince = searchOptions.UpdatedSince
 	}
+	searchOptions.Limit = perPage
+	searchOptions.Offset = (page - 1) * perPage
 
 	// Calculate max page
 	nBookmarks, err := h.DB.GetBookmarksCount(searchOptions)
-	checkError(err)
-	maxPage := int(math.Ceil(float64(nBookmarks) / 30))
+	checkQueryError(err)
+	maxPage := int(math.Ceil(float64(nBookmarks) / float64(perPage)))
 
 	// Fetch all matching bookmarks
 	bookmarks, err := h.DB.GetBookmarks(searchOptions)
-	checkError(err)
+	checkQueryError(err)
 
-	// Get image URL for each bookmark, and check if it has archive
+	// Get image URL for each bookmark, and check if it has archive or screenshot
 	for i := range bookmarks {
 		strID := strconv.Itoa(bookmarks[i].ID)
-		imgPath := fp.Join(h.DataDir, "thumb", strID)
 		archivePath := fp.Join(h.DataDir, "archive", strID)
 
-		if fileExists(imgPath) {
-			bookmarks[i].ImageURL = path.Join(h.RootPath, "bookmark", strID, "thumb")
+		if imgURL := h.thumbnailURL(strID); imgURL != "" {
+			bookmarks[i].ImageURL = imgURL
 		}
 
 		if fileExists(archivePath) {
 			bookmarks[i].HasArchive = true
 		}
+
+		if fileExists(core.ScreenshotPath(h.DataDir, bookmarks[i].ID)) {
+			bookmarks[i].HasScreenshot = true
+		}
+
+		if fileExists(core.PDFPath(h.DataDir, bookmarks[i].ID)) {
+			bookmarks[i].HasPDF = true
+		}
+
+		if fileExists(core.SingleFilePath(h.DataDir, bookmarks[i].ID)) {
+			bookmarks[i].HasSingleFile = true
+		}
 	}
 
 	// Return JSON response
 	resp := map[string]interface{}{
 		"page":      page,
 		"maxPage":   maxPage,
+		"perPage":   perPage,
 		"bookmarks": bookmarks,
 	}
 
+	if len(fields) > 0 {
+		resp["bookmarks"] = projectBookmarks(bookmarks, fields)
+	}
+
+	// Sync clients also need to know which bookmarks have been deleted
+	if updatedSince != "" {
+		tombstones, err := h.DB.GetTombstones(updatedSince, searchOptions.OwnerID)
+		checkError(err)
+
+		resp["tombstones"] = tombstones
+		resp["syncTime"] = syncTime.Format(time.RFC3339)
+	}
+
+	w.Header().Set("Link", paginationLinks(r.URL, page, maxPage))
 	w.Header().Set("Content-Type", "application/json")
 	err = json.NewEncoder(w).Encode(&resp)
 	checkError(err)
 }
 
-// apiGetTags is handler for GET /api/tags
-func (h *handler) apiGetTags(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
-	// Fetch all tags
-	tags, err := h.DB.GetTags()
-	checkError(err)
+// apiExportBookmarksCSV is handler for GET /api/bookmarks/export.csv
+//
+// It accepts the same filter as apiGetBookmarks. Bookmarks are fetched and
+// written page by page, so the whole export is never kept in memory.
+func (h *handler) apiExportBookmarksCSV(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	// Prepare filter for database
+	searchOptions, err := parseBookmarksFilter(r)
+	if err != nil {
+		writeAPIError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	searchOptions.OwnerID, err = bookmarkListOwner(r)
+	if err != nil {
+		writeAPIError(w, http.StatusForbidden, err.Error())
+		return
+	}
+
+	h.expandCollectionFilter(&searchOptions)
+
+	// Prepare response
+	w.Header().Set("Content-Type", "text/csv; charset=utf-8")
+	w.Header().Set("Content-Disposition", `attachment; filename="shiori-bookmarks.csv"`)
+
+	csvWriter := csv.NewWriter(w)
+	csvWriter.Write([]string{"id", "url", "title", "excerpt", "tags", "created", "public", "hasArchive"})
+
+	flusher, _ := w.(http.Flusher)
+	searchOptions.Limit = exportPageSize
+	for {
+		bookmarks, err := h.DB.GetBookmarks(searchOptions)
+		checkQueryError(err)
+
+		for _, book := range bookmarks {
+			tagNames := make([]string, len(book.Tags))
+			for i, tag := range book.Tags {
+				tagNames[i] = tag.Name
+			}
+
+			strID := strconv.Itoa(book.ID)
+			hasArchive := fileExists(fp.Join(h.DataDir, "archive", strID))
+
+			csvWriter.Write([]string{
+				strID,
+				book.URL,
+				book.Title,
+				book.Excerpt,
+				strings.Join(tagNames, ";"),
+				book.Created,
+				strconv.FormatBool(book.Public == 1),
+				strconv.FormatBool(hasArchive),
+			})
+		}
+
+		csvWriter.Flush()
+		checkError(csvWriter.Error())
+		if flusher != nil {
+			flusher.Flush()
+		}
+
+		if len(bookmarks) < exportPageSize {
+			break
+		}
+
+		searchOptions.Offset += exportPageSize
+	}
+}
+
+// apiExportBookmarksPinboard is handler for GET /api/bookmarks/export.json
+//
+// It writes the bookmarks as array in the JSON format of Pinboard's export,
+// which is accepted by POST /api/import with `format=pinboard` as well, so
+// the bookmarks can be moved between Shiori and Pinboard in either way. It
+// accepts the same filter as apiGetBookmarks, and like the CSV export, the
+// bookmarks are fetched and written page by page.
+func (h *handler) apiExportBookmarksPinboard(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	// Prepare filter for database
+	searchOptions, err := parseBookmarksFilter(r)
+	if err != nil {
+		writeAPIError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	searchOptions.OwnerID, err = bookmarkListOwner(r)
+	if err != nil {
+		writeAPIError(w, http.StatusForbidden, err.Error())
+		return
+	}
 
+	h.expandCollectionFilter(&searchOptions)
+
+	// Prepare response
 	w.Header().Set("Content-Type", "application/json")
-	err = json.NewEncoder(w).Encode(&tags)
+	w.Header().Set("Content-Disposition", `attachment; filename="shiori-bookmarks.json"`)
+
+	_, err = io.WriteString(w, "[")
+	checkError(err)
+
+	flusher, _ := w.(http.Flusher)
+	separator := "\n"
+	searchOptions.Limit = exportPageSize
+	for {
+		bookmarks, err := h.DB.GetBookmarks(searchOptions)
+		checkQueryError(err)
+
+		for _, book := range bookmarks {
+			item, err := json.Marshal(core.NewPinboardBookmark(book))
+			checkError(err)
+
+			_, err = io.WriteString(w, separator+string(item))
+			checkError(err)
+			separator = ",\n"
+		}
+
+		if flusher != nil {
+			flusher.Flush()
+		}
+
+		if len(bookmarks) < exportPageSize {
+			break
+		}
+
+		searchOptions.Offset += exportPageSize
+	}
+
+	_, err = io.WriteString(w, "\n]\n")
+	checkError(err)
+}
+
+// apiExportBookmarks is handler for GET /api/export
+//
+// With `format=netscape`, which is the default, it writes the bookmarks as
+// HTML file in Netscape Bookmark format, which every browser is able to
+// import, with their tags as TAGS attribute and their created time as
+// ADD_DATE. With `format=csv` and `format=pinboard`, it's the same as
+// GET /api/bookmarks/export.csv and GET /api/bookmarks/export.json. It
+// accepts the same filter as apiGetBookmarks, and the bookmarks are fetched
+// and written page by page.
+func (h *handler) apiExportBookmarks(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	switch r.URL.Query().Get("format") {
+	case "", "netscape":
+	case "csv":
+		h.apiExportBookmarksCSV(w, r, ps)
+		return
+	case "pinboard":
+		h.apiExportBookmarksPinboard(w, r, ps)
+		return
+	default:
+		writeAPIError(w, http.StatusBadRequest, "format must be netscape, csv or pinboard")
+		return
+	}
+
+	// Prepare filter for database
+	searchOptions, err := parseBookmarksFilter(r)
+	if err != nil {
+		writeAPIError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	searchOptions.OwnerID, err = bookmarkListOwner(r)
+	if err != nil {
+		writeAPIError(w, http.StatusForbidden, err.Error())
+		return
+	}
+
+	h.expandCollectionFilter(&searchOptions)
+
+	// Prepare response
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.Header().Set("Content-Disposition", `attachment; filename="bookmarks.html"`)
+
+	writer, err := core.NewNetscapeWriter(w)
+	checkError(err)
+
+	flusher, _ := w.(http.Flusher)
+	searchOptions.Limit = exportPageSize
+	for {
+		bookmarks, err := h.DB.GetBookmarks(searchOptions)
+		checkQueryError(err)
+
+		for _, book := range bookmarks {
+			err = writer.Write(book)
+			checkError(err)
+		}
+
+		if flusher != nil {
+			flusher.Flush()
+		}
+
+		if len(bookmarks) < exportPageSize {
+			break
+		}
+
+		searchOptions.Offset += exportPageSize
+	}
+
+	err = writer.Close()
 	checkError(err)
 }
 
-// apiRenameTag is handler for PUT /api/tag
-func (h *handler) apiRenameTag(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+// apiSetBookmarksOrder is handler for PUT /api/bookmarks/order
+//
+// It sets the manual position of bookmarks in `ids`, following their order
+// in the list, e.g. to curate a reading list within a tag. Bookmarks that
+// not in the list keep their position, and bookmarks that never positioned
+// are put after the positioned ones when ordered manually.
+func (h *handler) apiSetBookmarksOrder(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
 	// Decode request
-	tag := model.Tag{}
-	err := json.NewDecoder(r.Body).Decode(&tag)
+	request := struct {
+		IDs []int `json:"ids"`
+	}{}
+
+	err := h.decodeJSON(r.Body, &request)
 	checkError(err)
 
-	// Update name
-	err = h.DB.RenameTag(tag.ID, tag.Name)
+	// Validate input
+	if len(request.IDs) == 0 {
+		panic(newClientError(http.StatusBadRequest, fmt.Errorf("IDs must not empty")))
+	}
+
+	seen := map[int]struct{}{}
+	for _, id := range request.IDs {
+		if _, exist := seen[id]; exist {
+			panic(newClientError(http.StatusBadRequest, fmt.Errorf("bookmark %d is listed more than once", id)))
+		}
+		seen[id] = struct{}{}
+	}
+
+	// Make sure every bookmark is accessible by the account
+	if ownerID := bookmarkOwner(r); ownerID != 0 {
+		bookmarks, err := h.DB.GetBookmarks(database.GetBookmarksOptions{
+			IDs:     request.IDs,
+			OwnerID: ownerID,
+		})
+		checkError(err)
+		if len(bookmarks) != len(request.IDs) {
+			panic(newClientError(http.StatusNotFound, fmt.Errorf("no bookmark with matching ids")))
+		}
+	}
+
+	// Update database
+	err = h.DB.SetBookmarksOrder(request.IDs)
 	checkError(err)
 
 	fmt.Fprint(w, 1)
 }
 
-// apiInsertBookmark is handler for POST /api/bookmark
-func (h *handler) apiInsertBookmark(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+// apiSetBookmarksRead is handler for PUT /api/bookmarks/read
+//
+// It marks bookmarks in `ids` as read or unread following `read`, so the
+// unread ones can be fetched later as reading queue with `read=false`.
+// Bookmarks that already have the status keep their read time.
+func (h *handler) apiSetBookmarksRead(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
 	// Decode request
-	book := model.Bookmark{}
-	err := json.NewDecoder(r.Body).Decode(&book)
+	request := struct {
+		IDs  []int `json:"ids"`
+		Read *bool `json:"read"`
+	}{}
+
+	err := h.decodeJSON(r.Body, &request)
 	checkError(err)
 
-	// Create bookmark ID
-	book.ID, err = h.DB.CreateNewID("bookmark")
-	if err != nil {
-		panic(fmt.Errorf("failed to create ID: %v", err))
+	// Validate input
+	if len(request.IDs) == 0 || request.Read == nil {
+		panic(newClientError(http.StatusBadRequest, fmt.Errorf("IDs and read status must not empty")))
 	}
 
-	// Clean up bookmark URL
-	book.URL, err = core.RemoveUTMParams(book.URL)
-	if err != nil {
-		panic(fmt.Errorf("failed to clean URL: %v", err))
+	// Only the bookmarks accessible by the account are marked
+	filter := database.GetBookmarksOptions{
+		IDs:     request.IDs,
+		OwnerID: bookmarkOwner(r),
 	}
 
-	// Fetch data from internet
-	var isFatalErr bool
-	content, contentType, err := core.DownloadBookmark(book.URL)
-	if err == nil && content != nil {
-		request := core.ProcessRequest{
-			DataDir:     h.DataDir,
-			Bookmark:    book,
-			Content:     content,
-			ContentType: contentType,
-		}
+	bookmarks, err := h.DB.GetBookmarks(filter)
+	checkError(err)
+	if len(bookmarks) == 0 {
+		panic(newClientError(http.StatusNotFound, fmt.Errorf("no bookmark with matching ids")))
+	}
 
-		book, isFatalErr, err = core.ProcessBookmark(request)
-		content.Close()
+	filter.IDs = []int{}
+	for _, book := range bookmarks {
+		filter.IDs = append(filter.IDs, book.ID)
+	}
 
-		if err != nil && isFatalErr {
-			panic(fmt.Errorf("failed to process bookmark: %v", err))
-		}
+	// Update database, then return the bookmarks with their new status
+	nChanged, err := h.DB.SetBookmarksRead(filter.IDs, *request.Read)
+	checkError(err)
+
+	bookmarks, err = h.DB.GetBookmarks(filter)
+	checkError(err)
+
+	if nChanged > 0 {
+		h.Webhooks.send(eventBookmarkUpdated, bookmarks...)
 	}
 
-	// Make sure bookmark's title not empty
-	if book.Title == "" {
-		book.Title = book.URL
+	w.Header().Set("Content-Type", "application/json")
+	err = json.NewEncoder(w).Encode(&bookmarks)
+	checkError(err)
+}
+
+// apiStarBookmark is handler for PUT and DELETE /api/bookmark/:id/star
+//
+// PUT stars the bookmark while DELETE unstars it, so starred bookmarks
+// can be listed with `starred=true` apart from their tags. It returns
+// the bookmark with its new status.
+func (h *handler) apiStarBookmark(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	id, err := strconv.Atoi(ps.ByName("id"))
+	if err != nil {
+		panic(newClientError(http.StatusBadRequest, fmt.Errorf("bookmark id must be a number")))
 	}
 
-	// Save bookmark to database
-	results, err := h.DB.SaveBookmarks(book)
-	if err != nil || len(results) == 0 {
-		panic(fmt.Errorf("failed to save bookmark: %v", err))
+	book, exist := h.DB.GetBookmark(id, "")
+	if !exist || !canAccessBookmark(r, book) {
+		panic(newClientError(http.StatusNotFound, fmt.Errorf("Bookmark not found")))
 	}
-	book = results[0]
 
-	// Return the new bookmark
+	starred := r.Method != http.MethodDelete
+	if book.Starred != starred {
+		err = h.DB.SetBookmarkStarred(id, starred)
+		checkError(err)
+
+		book, _ = h.DB.GetBookmark(id, "")
+		h.Webhooks.send(eventBookmarkUpdated, book)
+	}
+
+	// Readable content isn't needed by client
+	book.HTML = ""
+
 	w.Header().Set("Content-Type", "application/json")
 	err = json.NewEncoder(w).Encode(&book)
 	checkError(err)
 }
 
-// apiDeleteBookmarks is handler for DELETE /api/bookmark
-func (h *handler) apiDeleteBookmark(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
-	// Decode request
-	ids := []int{}
-	err := json.NewDecoder(r.Body).Decode(&ids)
-	checkError(err)
+// maxThumbnailBatch is max number of thumbnails fetched in a single request.
+const maxThumbnailBatch = 100
+
+// apiGetThumbnails is handler for GET /api/bookmarks/thumbs
+//
+// It bundles the thumbnails of bookmarks in `ids` into a single multipart
+// response, so grid view doesn't need a request for each of them. Every part
+// has the bookmark ID in its `Content-ID` header. Just like apiGetBookmarks,
+// bookmarks that don't have thumbnail are omitted.
+func (h *handler) apiGetThumbnails(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	// Parse bookmark IDs
+	strIDs := parseListParam(r.URL.Query().Get("ids"))
+	if len(strIDs) == 0 || len(strIDs) > maxThumbnailBatch {
+		msg := fmt.Sprintf("ids must contain between 1 and %d bookmark IDs", maxThumbnailBatch)
+		writeAPIError(w, http.StatusBadRequest, msg)
+		return
+	}
 
-	// Delete bookmarks
-	err = h.DB.DeleteBookmarks(ids...)
-	checkError(err)
+	ids := make([]int, len(strIDs))
+	for i, strID := range strIDs {
+		id, err := strconv.Atoi(strID)
+		if err != nil {
+			writeAPIError(w, http.StatusBadRequest, "bookmark id must be a number")
+			return
+		}
+
+		ids[i] = id
+	}
+
+	// Thumbnails of bookmarks that not accessible are omitted as well
+	if ownerID := bookmarkOwner(r); ownerID != 0 {
+		bookmarks, err := h.DB.GetBookmarks(database.GetBookmarksOptions{
+			IDs:     ids,
+			OwnerID: ownerID,
+		})
+		checkError(err)
+
+		ids = ids[:0]
+		for _, book := range bookmarks {
+			ids = append(ids, book.ID)
+		}
+	}
+
+	// Write each thumbnail as a part of response
+	mw := multipart.NewWriter(w)
+	w.Header().Set("Content-Type", "multipart/mixed; boundary="+mw.Boundary())
 
-	// Delete thumbnail image and archives from local disk
 	for _, id := range ids {
 		strID := strconv.Itoa(id)
-		imgPath := fp.Join(h.DataDir, "thumb", strID)
-		archivePath := fp.Join(h.DataDir, "archive", strID)
+		img, err := ioutil.ReadFile(fp.Join(h.DataDir, "thumb", strID))
+		if err != nil {
+			continue
+		}
 
-		os.Remove(imgPath)
-		os.Remove(archivePath)
+		header := textproto.MIMEHeader{}
+		header.Set("Content-Type", http.DetectContentType(img))
+		header.Set("Content-ID", strID)
+
+		part, err := mw.CreatePart(header)
+		checkError(err)
+
+		_, err = part.Write(img)
+		checkError(err)
 	}
 
-	fmt.Fprint(w, 1)
+	err := mw.Close()
+	checkError(err)
+}
+
+// apiGetTags is handler for GET /api/tags
+//
+// Each tag has `lastUsed`, the latest created time among its bookmarks.
+// Tags are ordered by name, unless `order=lastUsed` is specified, which
+// orders them from the most recently used. By default only tags that used
+// by any bookmark are returned, specify `unused=true` to include the rest,
+// which have no `lastUsed` and are ordered last, e.g. to find tags to prune.
+// Just like bookmarks, only the account's own bookmarks are counted, unless
+// owner specifies `all=true`.
+func (h *handler) apiGetTags(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	opts := database.GetTagsOptions{}
+	opts.WithUnused, _ = strconv.ParseBool(r.URL.Query().Get("unused"))
+
+	var err error
+	opts.OwnerID, err = bookmarkListOwner(r)
+	if err != nil {
+		writeAPIError(w, http.StatusForbidden, err.Error())
+		return
+	}
+
+	switch r.URL.Query().Get("order") {
+	case "", "name":
+	case "lastUsed":
+		opts.OrderMethod = database.TagsByLastUsed
+	default:
+		writeAPIError(w, http.StatusBadRequest, "order must be empty, name or lastUsed")
+		return
+	}
+
+	// Fetch all tags
+	tags, err := h.DB.GetTags(opts)
+	checkError(err)
+
+	w.Header().Set("Content-Type", "application/json")
+	err = json.NewEncoder(w).Encode(&tags)
+	checkError(err)
+}
+
+// apiGetRelatedTags is handler for GET /api/tags/:id/related
+//
+// Returns the tags that used together with the tag in the same bookmarks,
+// with `nBookmarks` as the number of shared bookmarks. Only the bookmarks
+// of the account are counted, unless owner asks for every account's
+// bookmarks with `all=true`. By default only the top 10 tags are returned,
+// which can be changed using `limit`.
+func (h *handler) apiGetRelatedTags(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	id, err := strconv.Atoi(ps.ByName("id"))
+	if err != nil {
+		writeAPIError(w, http.StatusBadRequest, "tag id must be a number")
+		return
+	}
+
+	limit := 10
+	if strLimit := r.URL.Query().Get("limit"); strLimit != "" {
+		limit, err = strconv.Atoi(strLimit)
+		if err != nil || limit < 1 || limit > 100 {
+			writeAPIError(w, http.StatusBadRequest, "limit must be a number between 1 and 100")
+			return
+		}
+	}
+
+	ownerID, err := bookmarkListOwner(r)
+	if err != nil {
+		writeAPIError(w, http.StatusForbidden, err.Error())
+		return
+	}
+
+	tags, err := h.DB.GetRelatedTags(id, ownerID, limit)
+	checkError(err)
+
+	w.Header().Set("Content-Type", "application/json")
+	err = json.NewEncoder(w).Encode(&tags)
+	checkError(err)
 }
 
-// apiUpdateBookmark is handler for PUT /api/bookmarks
-func (h *handler) apiUpdateBookmark(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
-	// Decode request
-	request := model.Bookmark{}
-	err := json.NewDecoder(r.Body).Decode(&request)
+// apiUpdateTag is handler for PUT /api/tag
+//
+// It renames the tag to `name`, and sets its `color`, `description` and
+// `icon`, which are left as they are when omitted. Empty value removes them.
+// It returns the tag with its new name and metadata.
+func (h *handler) apiUpdateTag(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	// Decode request
+	request := struct {
+		ID          int     `json:"id"`
+		Name        string  `json:"name"`
+		Color       *string `json:"color"`
+		Description *string `json:"description"`
+		Icon        *string `json:"icon"`
+	}{}
+
+	err := h.decodeJSON(r.Body, &request)
+	checkError(err)
+
+	tag, _ := h.tagByID(request.ID)
+
+	// Update name
+	if request.Name != "" && request.Name != tag.Name {
+		err = h.DB.RenameTag(tag.ID, request.Name)
+		checkError(err)
+		tag.Name = request.Name
+	}
+
+	// Update metadata, keeping the omitted ones
+	if request.Color != nil || request.Description != nil || request.Icon != nil {
+		if request.Color != nil {
+			tag.Color = strings.ToLower(strings.TrimSpace(*request.Color))
+		}
+
+		if request.Description != nil {
+			tag.Description = strings.TrimSpace(*request.Description)
+		}
+
+		if request.Icon != nil {
+			tag.Icon = strings.TrimSpace(*request.Icon)
+		}
+
+		if err = validateTagMetadata(tag); err != nil {
+			panic(newClientError(http.StatusBadRequest, err))
+		}
+
+		err = h.DB.SetTagMetadata(tag)
+		checkError(err)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	err = json.NewEncoder(w).Encode(&tag)
+	checkError(err)
+}
+
+// apiSetTagDefaultPublic is handler for PUT /api/tags/visibility
+//
+// It sets `defaultPublic` of the tag, which is the visibility given to
+// bookmarks whenever the tag is added to them. Null removes it. Only owner
+// may change it, since it applies to the bookmarks of every account.
+func (h *handler) apiSetTagDefaultPublic(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	// Decode request
+	tag := model.Tag{}
+	err := h.decodeJSON(r.Body, &tag)
+	checkError(err)
+
+	// Validate input
+	if tag.DefaultPublic != nil && *tag.DefaultPublic != 0 && *tag.DefaultPublic != 1 {
+		panic(newClientError(http.StatusBadRequest, fmt.Errorf("defaultPublic must be 0, 1 or null")))
+	}
+
+	err = h.DB.SetTagDefaultPublic(tag.ID, tag.DefaultPublic)
+	checkError(err)
+
+	fmt.Fprint(w, 1)
+}
+
+// apiReplaceTagNames is handler for PUT /api/tags/replace
+//
+// It replaces `find` in the name of every tag with `replace`. When `regex` is
+// true, `find` is a regular expression and `replace` may refer to its groups,
+// e.g. `$1`. Tags that end up with the same name are merged. When
+// `dryRun=true` is specified, only the changes that would be made are returned.
+func (h *handler) apiReplaceTagNames(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	// Decode request
+	request := struct {
+		Find    string `json:"find"`
+		Replace string `json:"replace"`
+		Regex   bool   `json:"regex"`
+	}{}
+
+	err := h.decodeJSON(r.Body, &request)
+	checkError(err)
+
+	if request.Find == "" {
+		writeAPIError(w, http.StatusBadRequest, "find pattern is required")
+		return
+	}
+
+	replaceName := func(name string) string {
+		return strings.Replace(name, request.Find, request.Replace, -1)
+	}
+
+	if request.Regex {
+		rx, err := regexp.Compile(request.Find)
+		if err != nil {
+			writeAPIError(w, http.StatusBadRequest, fmt.Sprintf("invalid find pattern: %v", err))
+			return
+		}
+
+		replaceName = func(name string) string {
+			return rx.ReplaceAllString(name, request.Replace)
+		}
+	}
+
+	// Find the tags that will be renamed
+	tags, err := h.DB.GetTags(database.GetTagsOptions{})
+	checkError(err)
+
+	type tagChange struct {
+		ID         int    `json:"id"`
+		Name       string `json:"name"`
+		NewName    string `json:"newName"`
+		NBookmarks int    `json:"nBookmarks"`
+	}
+
+	changes := []tagChange{}
+	renames := map[int]string{}
+	for _, tag := range tags {
+		newName := strings.TrimSpace(replaceName(tag.Name))
+		if newName == tag.Name {
+			continue
+		}
+
+		if newName == "" {
+			writeAPIError(w, http.StatusBadRequest, fmt.Sprintf("tag %q would be renamed to empty name", tag.Name))
+			return
+		}
+
+		changes = append(changes, tagChange{tag.ID, tag.Name, newName, tag.NBookmarks})
+		renames[tag.ID] = newName
+	}
+
+	// Rename the tags, unless it's only a dry run
+	resp := map[string]interface{}{"changes": changes}
+
+	if !isDryRun(r) && len(renames) > 0 {
+		err = h.DB.RenameTags(renames)
+		checkError(err)
+
+		// Return the resulting tags with their final count
+		tags, err = h.DB.GetTags(database.GetTagsOptions{})
+		checkError(err)
+
+		newNames := map[string]struct{}{}
+		for _, newName := range renames {
+			newNames[newName] = struct{}{}
+		}
+
+		resultTags := []model.Tag{}
+		for _, tag := range tags {
+			if _, renamed := newNames[tag.Name]; renamed {
+				resultTags = append(resultTags, tag)
+			}
+		}
+
+		resp["tags"] = resultTags
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	err = json.NewEncoder(w).Encode(&resp)
+	checkError(err)
+}
+
+// findBookmarkByURL returns bookmark of the owner whose URL is any of urls,
+// preferring the one that comes first. Since every account may bookmark the
+// same page, zero owner ID means the bookmark of any account is returned.
+func (h *handler) findBookmarkByURL(ownerID int, urls ...string) (model.Bookmark, bool, error) {
+	bookmarks, err := h.DB.GetBookmarks(database.GetBookmarksOptions{
+		URLs:        urls,
+		OwnerID:     ownerID,
+		WithContent: true,
+	})
+	if err != nil {
+		return model.Bookmark{}, false, err
+	}
+
+	for _, url := range urls {
+		for _, book := range bookmarks {
+			if book.URL == url {
+				return book, true, nil
+			}
+		}
+	}
+
+	return model.Bookmark{}, false, nil
+}
+
+// checkDuplicateURL looks for bookmark of the account whose URL is the same as
+// url, once both are canonicalized. When it exists, 409 Conflict is written
+// and false is returned, unless client forces to save over it with
+// `force=true`. Bookmarks of other accounts never conflict, since each account
+// may bookmark the same page.
+func (h *handler) checkDuplicateURL(w http.ResponseWriter, r *http.Request, url string) (model.Bookmark, bool, bool) {
+	existing, exist, err := h.findBookmarkByURL(newBookmarkOwner(r), core.EquivalentURLs(url)...)
+	checkError(err)
+
+	force, _ := strconv.ParseBool(r.URL.Query().Get("force"))
+	if !exist || force {
+		return existing, exist, true
+	}
+
+	resp := struct {
+		apiError
+		Bookmark *model.Bookmark `json:"bookmark,omitempty"`
+	}{apiError: apiError{
+		Error: "bookmark with the same URL already exists",
+		Code:  apiErrorCodes[http.StatusConflict],
+	}}
+
+	existing.HTML = ""
+	resp.Bookmark = &existing
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusConflict)
+	err = json.NewEncoder(w).Encode(&resp)
+	checkError(err)
+	return existing, exist, false
+}
+
+// apiInsertBookmark is handler for POST /api/bookmark
+//
+// The `created` time may be specified (RFC3339 or Unix epoch in seconds)
+// to keep the original date of imported bookmark. By default it's now.
+//
+// Whether the bookmark is archived follows the server's archive-on-insert
+// setting, unless `createArchive` is specified in the request.
+//
+// By default, bookmark is still saved when its content failed to be processed.
+// When `strict=true` is specified, or the server runs in strict processing
+// mode, the bookmark is not saved and the processing error is returned
+// instead. Strict mode can be disabled per request using `strict=false`.
+//
+// The HTTP status code of the downloaded page is saved as `lastStatusCode`.
+// When it's one of the server's fail-on-status codes, e.g. 404 for a dead
+// page, the bookmark is not saved and 422 is returned instead.
+//
+// Client that already has the page, e.g. behind a login that server can't
+// pass, may submit it as `html`, or its readable text as `content`. Then the
+// page is not downloaded, but the submitted one is sanitized and processed
+// instead, so the excerpt, thumbnail and archive are still created from it.
+// The submitted title and excerpt are kept. The request body is still
+// limited by the server's max body size.
+//
+// If the request has `Idempotency-Key` header, the result is cached for a day,
+// so a retried request with the same key returns the original bookmark
+// instead of saving a new one.
+//
+// When a bookmark with the same URL, after it's cleaned up, already exists,
+// 409 Conflict is returned with the existing bookmark as `bookmark`. With
+// `force=true`, the existing bookmark is downloaded and saved again instead.
+func (h *handler) apiInsertBookmark(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	// Check if this request has been submitted before
+	idempotencyKey := r.Header.Get("Idempotency-Key")
+	if idempotencyKey != "" {
+		// Reserve the key, so concurrent retries won't insert it twice
+		err := h.InsertCache.Add(idempotencyKey, nil, cch.DefaultExpiration)
+		if err != nil {
+			cached, _ := h.InsertCache.Get(idempotencyKey)
+			cachedBook, finished := cached.(model.Bookmark)
+			if !finished {
+				writeAPIError(w, http.StatusConflict, "request with the same idempotency key is still in progress")
+				return
+			}
+
+			w.Header().Set("Content-Type", "application/json")
+			err = json.NewEncoder(w).Encode(&cachedBook)
+			checkError(err)
+			return
+		}
+
+		// If the insert failed, release the key so client may retry
+		defer func() {
+			if cached, _ := h.InsertCache.Get(idempotencyKey); cached == nil {
+				h.InsertCache.Delete(idempotencyKey)
+			}
+		}()
+	}
+
+	// Decode request. CreateArchive is decoded separately,
+	// so we know whether client omits it.
+	payload := struct {
+		model.Bookmark
+		CreateArchive *bool  `json:"createArchive"`
+		Content       string `json:"content"`
+	}{}
+	err := h.decodeJSON(r.Body, &payload)
+	checkError(err)
+
+	book := payload.Bookmark
+	book.HTML = ""
+	book.OwnerID = newBookmarkOwner(r)
+	book.CreateArchive = h.ArchiveOnInsert
+	if payload.CreateArchive != nil {
+		book.CreateArchive = *payload.CreateArchive
+	}
+
+	// Validate created time, e.g. when importing old bookmarks
+	if book.Created != "" {
+		created, err := parseTimeParam(book.Created)
+		if err != nil {
+			writeAPIError(w, http.StatusBadRequest, fmt.Sprintf("invalid created time: %v", err))
+			return
+		}
+		book.Created = created.UTC().Format("2006-01-02 15:04:05")
+	}
+
+	if err = validateMetadata(book.Metadata); err != nil {
+		panic(newClientError(http.StatusBadRequest, err))
+	}
+
+	if err = core.CheckArchiveFormat(book.ArchiveFormat); err != nil {
+		panic(newClientError(http.StatusBadRequest, err))
+	}
+
+	// Sanitize the page that submitted by client, if any
+	suppliedHTML, err := suppliedPage(payload.HTML, payload.Content)
+	if err != nil {
+		panic(newClientError(http.StatusBadRequest, err))
+	}
+
+	// Clean up bookmark URL
+	book.URL, err = core.RemoveUTMParams(book.URL, h.KeptQueryParams)
+	if err != nil {
+		panic(newClientError(http.StatusBadRequest, fmt.Errorf("failed to clean URL: %v", err)))
+	}
+
+	// Bookmark with the same URL is returned instead of saved again, unless
+	// client forces to save it over the existing one. It's checked before
+	// the quota, so the duplicate doesn't use it up.
+	existing, exist, ok := h.checkDuplicateURL(w, r, book.URL)
+	if !ok {
+		return
+	}
+
+	// Make sure client still has quota left
+	account := quotaAccount(r)
+	err = h.useInsertQuota(account)
+	if quotaErr, isQuotaErr := err.(errQuotaExceeded); isQuotaErr {
+		writeQuotaError(w, quotaErr)
+		return
+	}
+
+	if book.CreateArchive || book.CreatePDF {
+		releaseQuota, err := h.useArchivalQuota(account, 1)
+		if quotaErr, isQuotaErr := err.(errQuotaExceeded); isQuotaErr {
+			writeQuotaError(w, quotaErr)
+			return
+		}
+		checkError(err)
+		defer releaseQuota()
+	}
+
+	// Create bookmark ID, or keep the existing one when it's forced
+	if exist {
+		book.ID = existing.ID
+		book.OwnerID = existing.OwnerID
+		book.Version = existing.Version
+	} else {
+		book.ID, err = h.DB.CreateNewID("bookmark")
+		if err != nil {
+			panic(fmt.Errorf("failed to create ID: %v", err))
+		}
+	}
+
+	// Fetch data from internet, unless client has submitted the page. The
+	// status code is recorded whatever it is, unless server is configured
+	// to reject the bookmark for that status.
+	var isFatalErr bool
+	var content io.ReadCloser
+	var contentType string
+
+	if suppliedHTML != nil {
+		content = ioutil.NopCloser(bytes.NewReader(suppliedHTML))
+		contentType = "text/html; charset=UTF-8"
+	} else {
+		var statusCode int
+		content, contentType, statusCode, book.WaybackURL, err = core.FetchBookmark(book.URL, h.RenderPolicy)
+		book.LastStatusCode = statusCode
+
+		if err == nil && h.failOnStatus(statusCode) {
+			content.Close()
+			msg := fmt.Sprintf("page returned status %d", statusCode)
+			writeAPIError(w, http.StatusUnprocessableEntity, msg)
+			return
+		}
+	}
+
+	if err == nil && content != nil {
+		request := core.ProcessRequest{
+			DataDir:        h.DataDir,
+			Bookmark:       book,
+			Content:        content,
+			ContentType:    contentType,
+			KeepTitle:      suppliedHTML != nil,
+			KeepExcerpt:    suppliedHTML != nil,
+			MaxResources:   h.MaxResources,
+			MaxSnapshots:   h.MaxSnapshots,
+			RenderPolicy:   h.RenderPolicy,
+			ArchivalPolicy: h.archivalPolicy(r),
+		}
+
+		book, isFatalErr, err = core.ProcessBookmark(request)
+		content.Close()
+
+		if err != nil && isFatalErr {
+			panic(fmt.Errorf("failed to process bookmark: %v", err))
+		}
+
+		// In strict mode, bookmark that failed to be processed is not saved,
+		// so remove the thumbnail and archive that might already be created.
+		if err != nil && h.strictProcessing(r) {
+			core.RemoveBookmarkArtifacts(h.DataDir, book.ID)
+
+			msg := fmt.Sprintf("failed to process bookmark: %v", err)
+			writeAPIError(w, http.StatusUnprocessableEntity, msg)
+			return
+		}
+	}
+
+	// Make sure bookmark's title not empty
+	core.EnsureTitle(&book)
+
+	// Save bookmark to database
+	results, err := h.DB.SaveBookmarks(book)
+	if err != nil || len(results) == 0 {
+		panic(fmt.Errorf("failed to save bookmark: %v", err))
+	}
+	book = results[0]
+	if exist {
+		h.Webhooks.send(eventBookmarkUpdated, book)
+	} else {
+		h.Webhooks.send(eventBookmarkCreated, book)
+	}
+
+	if idempotencyKey != "" {
+		h.InsertCache.Set(idempotencyKey, book, cch.DefaultExpiration)
+	}
+
+	// Return the new bookmark
+	w.Header().Set("Content-Type", "application/json")
+	err = json.NewEncoder(w).Encode(&book)
+	checkError(err)
+}
+
+// suppliedPage returns the sanitized page that client submitted when
+// inserting bookmark, either as HTML or as readable text. Text is converted
+// into HTML, with each block of text separated by blank line as paragraph.
+// Nil is returned when client doesn't submit any.
+func suppliedPage(pageHTML, text string) ([]byte, error) {
+	if strings.TrimSpace(pageHTML) == "" && strings.TrimSpace(text) == "" {
+		return nil, nil
+	}
+
+	if strings.TrimSpace(pageHTML) == "" {
+		buffer := bytes.NewBufferString("<html><body>")
+		text = strings.Replace(text, "\r\n", "\n", -1)
+		for _, paragraph := range strings.Split(text, "\n\n") {
+			if paragraph = strings.TrimSpace(paragraph); paragraph != "" {
+				buffer.WriteString("<p>" + html.EscapeString(paragraph) + "</p>")
+			}
+		}
+		buffer.WriteString("</body></html>")
+		pageHTML = buffer.String()
+	}
+
+	sanitized, err := core.SanitizeHTML(strings.NewReader(pageHTML))
+	if err != nil {
+		return nil, fmt.Errorf("invalid submitted html: %v", err)
+	}
+
+	return sanitized, nil
+}
+
+// apiDeleteBookmarks is handler for DELETE /api/bookmark
+//
+// Empty list of IDs means all bookmarks will be deleted. When `dryRun=true`
+// is specified, nothing is deleted and the bookmarks that would be deleted
+// are returned instead.
+func (h *handler) apiDeleteBookmark(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	// Decode request
+	ids := []int{}
+	err := h.decodeJSON(r.Body, &ids)
+	checkError(err)
+
+	// In dry run, only return the bookmarks that will be deleted
+	if isDryRun(r) {
+		bookmarks, err := h.DB.GetBookmarks(database.GetBookmarksOptions{IDs: ids})
+		checkError(err)
+
+		w.Header().Set("Content-Type", "application/json")
+		err = json.NewEncoder(w).Encode(&bookmarks)
+		checkError(err)
+		return
+	}
+
+	// Keep the bookmarks that will be deleted, so webhooks know them
+	var deleted []model.Bookmark
+	if h.Webhooks != nil {
+		deleted, err = h.DB.GetBookmarks(database.GetBookmarksOptions{IDs: ids})
+		checkError(err)
+	}
+
+	// Delete bookmarks
+	nDeleted, err := h.DB.DeleteBookmarks(ids...)
+	checkError(err)
+	h.Webhooks.send(eventBookmarkDeleted, deleted...)
+
+	// Delete thumbnail image and archives from local disk.
+	// Missing files are fine, since the ID might not exist.
+	for _, id := range ids {
+		core.RemoveBookmarkArtifacts(h.DataDir, id)
+	}
+
+	// Return number of deleted bookmarks
+	resp := map[string]int{"deleted": nDeleted}
+
+	w.Header().Set("Content-Type", "application/json")
+	err = json.NewEncoder(w).Encode(&resp)
+	checkError(err)
+}
+
+// apiUpdateBookmark is handler for PUT /api/bookmarks
+//
+// Client must submit the version of bookmark it has read, either in `version`
+// field or in `If-Match` header. If the bookmark has been changed since then,
+// the update is rejected with 409 Conflict, so no changes are silently lost.
+func (h *handler) apiUpdateBookmark(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	// Decode request
+	request := model.Bookmark{}
+	err := h.decodeJSON(r.Body, &request)
+	checkError(err)
+
+	// Validate input. Title that only has whitespace is empty as well.
+	request.Title = core.CleanTitle(request.Title, "")
+	if request.Title == "" {
+		panic(newClientError(http.StatusBadRequest, fmt.Errorf("Title must not empty")))
+	}
+
+	if err = validateMetadata(request.Metadata); err != nil {
+		panic(newClientError(http.StatusBadRequest, err))
+	}
+
+	// Get existing bookmark from database
+	filter := database.GetBookmarksOptions{
+		IDs:         []int{request.ID},
+		OwnerID:     bookmarkOwner(r),
+		WithContent: true,
+	}
+
+	bookmarks, err := h.DB.GetBookmarks(filter)
+	checkError(err)
+	if len(bookmarks) == 0 {
+		panic(newClientError(http.StatusNotFound, fmt.Errorf("no bookmark with matching ids")))
+	}
+
+	// Make sure the bookmark hasn't been changed since client read it
+	book := bookmarks[0]
+	version := request.Version
+	if ifMatch, ok := ifMatchVersion(r); ok {
+		version = ifMatch
+	}
+
+	if version == 0 {
+		writeAPIError(w, http.StatusPreconditionRequired, "bookmark version is required")
+		return
+	}
+
+	// The bookmark is only saved if it's still in the version,
+	// which checked by database in the same statement that saves it
+	book.Version = version
+
+	// Set new bookmark data
+	oldTags, oldPublic := book.Tags, book.Public
+	book.URL = request.URL
+	book.Title = request.Title
+	book.Excerpt = request.Excerpt
+	book.Public = request.Public
+	book.VersionedArchive = request.VersionedArchive
+
+	// Old clients don't know metadata, so it's only replaced when submitted
+	if request.Metadata != nil {
+		book.Metadata = request.Metadata
+	}
+
+	// Clean up bookmark URL
+	book.URL, err = core.RemoveUTMParams(book.URL, h.KeptQueryParams)
+	if err != nil {
+		panic(newClientError(http.StatusBadRequest, fmt.Errorf("failed to clean URL: %v", err)))
+	}
+
+	// Set new tags. The added tags may change the visibility,
+	// unless client has changed it in the same request.
+	book.Tags = replaceTags(book.Tags, request.Tags)
+	if book.Public == oldPublic {
+		applyTagDefaultPublic(&book, oldTags, h.tagDefaultPublic())
+	}
+
+	// Update database
+	res, err := h.DB.SaveBookmarks(book)
+	if err == database.ErrVersionConflict {
+		msg := fmt.Sprintf("bookmark has been modified since version %d", version)
+		writeAPIError(w, http.StatusConflict, msg)
+		return
+	}
+	checkError(err)
+
+	// Add thumbnail image to the saved bookmarks again
+	newBook := res[0]
+	newBook.ImageURL = request.ImageURL
+	newBook.HasArchive = request.HasArchive
+	h.Webhooks.send(eventBookmarkUpdated, newBook)
+
+	// Return new saved result
+	w.Header().Set("ETag", strconv.Quote(strconv.Itoa(newBook.Version)))
+	w.Header().Set("Content-Type", "application/json")
+	err = json.NewEncoder(w).Encode(&newBook)
+	checkError(err)
+}
+
+// apiPatchBookmark is handler for PATCH /api/bookmarks/:id
+//
+// Unlike PUT, only fields that present in request body are updated,
+// so client doesn't have to resend the whole bookmark. The version is
+// optional here, but when submitted it's checked just like in PUT.
+func (h *handler) apiPatchBookmark(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	// Get bookmark ID from URL
+	strID := ps.ByName("id")
+	id, err := strconv.Atoi(strID)
+	if err != nil {
+		panic(newClientError(http.StatusBadRequest, fmt.Errorf("bookmark id must be a number")))
+	}
+
+	// Decode request. Pointer is used to tell missing field from empty value.
+	request := struct {
+		URL      *string        `json:"url"`
+		Title    *string        `json:"title"`
+		Excerpt  *string        `json:"excerpt"`
+		Author   *string        `json:"author"`
+		Public   *int           `json:"public"`
+		Tags     *[]model.Tag   `json:"tags"`
+		Metadata model.Metadata `json:"metadata"`
+		Version  *int           `json:"version"`
+
+		VersionedArchive *bool `json:"versionedArchive"`
+	}{}
+
+	err = h.decodeJSON(r.Body, &request)
+	checkError(err)
+
+	// Validate input
+	if request.URL != nil && *request.URL == "" {
+		panic(newClientError(http.StatusBadRequest, fmt.Errorf("URL must not empty")))
+	}
+
+	if request.Title != nil {
+		*request.Title = core.CleanTitle(*request.Title, "")
+		if *request.Title == "" {
+			panic(newClientError(http.StatusBadRequest, fmt.Errorf("Title must not empty")))
+		}
+	}
+
+	if err = validateMetadata(request.Metadata); err != nil {
+		panic(newClientError(http.StatusBadRequest, err))
+	}
+
+	// Get existing bookmark from database
+	filter := database.GetBookmarksOptions{
+		IDs:         []int{id},
+		OwnerID:     bookmarkOwner(r),
+		WithContent: true,
+	}
+
+	bookmarks, err := h.DB.GetBookmarks(filter)
+	checkError(err)
+	if len(bookmarks) == 0 {
+		panic(newClientError(http.StatusNotFound, fmt.Errorf("no bookmark with matching ids")))
+	}
+
+	// If version is submitted, the bookmark is only saved if it's still in
+	// that version. Otherwise it's the version that just read.
+	book := bookmarks[0]
+	if ifMatch, ok := ifMatchVersion(r); ok {
+		request.Version = &ifMatch
+	}
+
+	if request.Version != nil {
+		book.Version = *request.Version
+	}
+
+	// Set submitted fields
+	if request.URL != nil {
+		book.URL, err = core.RemoveUTMParams(*request.URL, h.KeptQueryParams)
+		if err != nil {
+			panic(newClientError(http.StatusBadRequest, fmt.Errorf("failed to clean URL: %v", err)))
+		}
+	}
+
+	if request.Title != nil {
+		book.Title = *request.Title
+	}
+
+	if request.Excerpt != nil {
+		book.Excerpt = *request.Excerpt
+	}
+
+	if request.Author != nil {
+		book.Author = *request.Author
+	}
+
+	if request.Public != nil {
+		book.Public = *request.Public
+	}
+
+	if request.Metadata != nil {
+		book.Metadata = request.Metadata
+	}
+
+	if request.VersionedArchive != nil {
+		book.VersionedArchive = *request.VersionedArchive
+	}
+
+	if request.Tags != nil {
+		oldTags := book.Tags
+		book.Tags = replaceTags(book.Tags, *request.Tags)
+		if request.Public == nil {
+			applyTagDefaultPublic(&book, oldTags, h.tagDefaultPublic())
+		}
+	}
+
+	// Update database
+	res, err := h.DB.SaveBookmarks(book)
+	if err == database.ErrVersionConflict {
+		msg := fmt.Sprintf("bookmark has been modified since version %d", book.Version)
+		writeAPIError(w, http.StatusConflict, msg)
+		return
+	}
+	checkError(err)
+
+	// Add thumbnail image and archive status to the saved bookmark
+	newBook := res[0]
+	archivePath := fp.Join(h.DataDir, "archive", strID)
+	h.Webhooks.send(eventBookmarkUpdated, newBook)
+
+	if imgURL := h.thumbnailURL(strID); imgURL != "" {
+		newBook.ImageURL = imgURL
+	}
+
+	if fileExists(archivePath) {
+		newBook.HasArchive = true
+	}
+
+	// Return new saved result
+	w.Header().Set("ETag", strconv.Quote(strconv.Itoa(newBook.Version)))
+	w.Header().Set("Content-Type", "application/json")
+	err = json.NewEncoder(w).Encode(&newBook)
+	checkError(err)
+}
+
+// replaceTags replaces the old tags of a bookmark with the new ones.
+// Old tags that not exist in the new tags are marked as deleted,
+// so they will be removed when the bookmark saved.
+//
+// The new tags are matched by their name, so the same tag submitted twice
+// is only added once, and ID submitted by client is never trusted.
+func replaceTags(oldTags, newTags []model.Tag) []model.Tag {
+	tags := make([]model.Tag, len(oldTags))
+	for i, oldTag := range oldTags {
+		oldTag.Deleted = true
+		tags[i] = oldTag
+	}
+
+	for _, newTag := range uniqueTags(newTags) {
+		newTag.ID = 0
+		for i, oldTag := range tags {
+			if newTag.Name == oldTag.Name {
+				newTag.ID = oldTag.ID
+				tags[i].Deleted = false
+				break
+			}
+		}
+
+		if newTag.ID == 0 {
+			tags = append(tags, newTag)
+		}
+	}
+
+	return tags
+}
+
+// uniqueTags normalizes the name of tags submitted by client, then drops
+// the empty and duplicate ones, keeping the first tag of each name.
+func uniqueTags(tags []model.Tag) []model.Tag {
+	result := []model.Tag{}
+	names := map[string]struct{}{}
+	for _, tag := range tags {
+		tag.Name = normalizeTagName(tag.Name)
+		if _, exist := names[tag.Name]; exist || tag.Name == "" {
+			continue
+		}
+
+		names[tag.Name] = struct{}{}
+		result = append(result, tag)
+	}
+
+	return result
+}
+
+// tagDefaultPublic returns the default visibility of tags, keyed by tag name.
+func (h *handler) tagDefaultPublic() map[string]int {
+	defaultPublic, err := h.DB.GetTagDefaultPublic()
+	checkError(err)
+	return defaultPublic
+}
+
+// applyTagDefaultPublic sets the visibility of bookmark following the default
+// visibility of tags that newly added to it. Tags without default visibility
+// never change it. When the added tags disagree, the bookmark is made private
+// so it's never published by accident.
+func applyTagDefaultPublic(book *model.Bookmark, oldTags []model.Tag, defaultPublic map[string]int) {
+	oldNames := map[string]struct{}{}
+	for _, tag := range oldTags {
+		oldNames[tag.Name] = struct{}{}
+	}
+
+	public, found := 0, false
+	for _, tag := range book.Tags {
+		if _, exist := oldNames[tag.Name]; exist || tag.Deleted {
+			continue
+		}
+
+		tagPublic, ok := defaultPublic[tag.Name]
+		if !ok {
+			continue
+		}
+
+		if !found || tagPublic == 0 {
+			public, found = tagPublic, true
+		}
+	}
+
+	if found {
+		book.Public = public
+	}
+}
+
+// Modes for updating tags of several bookmarks at once.
+const (
+	tagModeAdd     = "add"
+	tagModeRemove  = "remove"
+	tagModeReplace = "replace"
+)
+
+// validTagMode checks if the mode for updating tags in bulk is valid. The
+// tags may be empty only when replacing, which removes every tag.
+func validTagMode(mode string, tags []model.Tag) error {
+	switch mode {
+	case tagModeAdd, tagModeRemove:
+		if len(tags) == 0 {
+			return fmt.Errorf("tags must not empty")
+		}
+		return nil
+	case tagModeReplace:
+		return nil
+	default:
+		return fmt.Errorf("mode must be add, remove or replace")
+	}
+}
+
+// setBookmarkTags adds, removes or replaces the tags of bookmark following
+// the mode. Removed tags are kept but marked as deleted, so they're removed
+// when the bookmark is saved. It returns whether the tags are changed.
+func setBookmarkTags(book *model.Bookmark, tags []model.Tag, mode string) bool {
+	names := map[string]struct{}{}
+	for _, tag := range tags {
+		names[normalizeTagName(tag.Name)] = struct{}{}
+	}
+
+	changed := false
+	existing := map[string]struct{}{}
+	for i, tag := range book.Tags {
+		existing[tag.Name] = struct{}{}
+
+		_, listed := names[tag.Name]
+		if (mode == tagModeRemove && listed) || (mode == tagModeReplace && !listed) {
+			book.Tags[i].Deleted = true
+			changed = true
+		}
+	}
+
+	if mode == tagModeRemove {
+		return changed
+	}
+
+	for _, tag := range tags {
+		name := normalizeTagName(tag.Name)
+		if _, exist := existing[name]; exist || name == "" {
+			continue
+		}
+
+		existing[name] = struct{}{}
+		book.Tags = append(book.Tags, model.Tag{Name: name})
+		changed = true
+	}
+
+	return changed
+}
+
+// apiUpdateCache is handler for PUT /api/cache
+//
+// The request might specify `concurrency` to download fewer bookmarks
+// at once than the server allows, e.g. to spare a slow network. With
+// `createPDF`, the page is printed as PDF as well, which counted toward
+// the archival limit and quota just like `createArchive`. The archive is
+// created in `archiveFormat`, which is either "warc" or "singlefile".
+//
+// Each returned bookmark has `refreshResult`, which is "refreshed" when its
+// content is downloaded and processed again, "failed" when it couldn't be
+// downloaded or processed so the old content is kept, or "unchanged" when
+// its content hash is the same as before so nothing is saved.
+func (h *handler) apiUpdateCache(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	// Decode request
+	request := struct {
+		IDs           []int  `json:"ids"`
+		KeepMetadata  bool   `json:"keepMetadata"`
+		CreateArchive bool   `json:"createArchive"`
+		CreatePDF     bool   `json:"createPDF"`
+		ArchiveFormat string `json:"archiveFormat"`
+		Concurrency   int    `json:"concurrency"`
+	}{}
+
+	err := h.decodeJSON(r.Body, &request)
+	checkError(err)
+
+	if err = core.CheckArchiveFormat(request.ArchiveFormat); err != nil {
+		panic(newClientError(http.StatusBadRequest, err))
+	}
+
+	// Get existing bookmark from database
+	filter := database.GetBookmarksOptions{
+		IDs:         request.IDs,
+		OwnerID:     bookmarkOwner(r),
+		WithContent: true,
+	}
+
+	bookmarks, err := h.DB.GetBookmarks(filter)
+	checkError(err)
+	if len(bookmarks) == 0 {
+		panic(newClientError(http.StatusNotFound, fmt.Errorf("no bookmark with matching ids")))
+	}
+
+	// For web interface, let's limit to max 20 IDs to update, and fewer for archival.
+	// This is done to prevent the REST request from client took too long to finish.
+	archival := request.CreateArchive || request.CreatePDF
+	if len(bookmarks) > 20 {
+		panic(newClientError(http.StatusBadRequest, fmt.Errorf("max 20 bookmarks to update")))
+	} else if len(bookmarks) > h.ArchiveLimit && archival {
+		panic(newClientError(http.StatusBadRequest, fmt.Errorf("max %d bookmarks to update with archival", h.ArchiveLimit)))
+	}
+
+	// Make sure client may run that many archival
+	if archival {
+		releaseQuota, err := h.useArchivalQuota(quotaAccount(r), len(bookmarks))
+		if quotaErr, isQuotaErr := err.(errQuotaExceeded); isQuotaErr {
+			writeQuotaError(w, quotaErr)
+			return
+		}
+		checkError(err)
+		defer releaseQuota()
+	}
+
+	// Client may lower the concurrency, but not raise it above server's setting
+	concurrency := h.Concurrency
+	if request.Concurrency > 0 && request.Concurrency < concurrency {
+		concurrency = request.Concurrency
+	}
+
+	// Fetch data from internet
+	mx := sync.RWMutex{}
+	wg := sync.WaitGroup{}
+	semaphore := make(chan struct{}, concurrency)
+
+	for i, book := range bookmarks {
+		wg.Add(1)
+
+		// Mark whether book will be archived
+		book.CreateArchive = request.CreateArchive
+		book.CreatePDF = request.CreatePDF
+		book.ArchiveFormat = request.ArchiveFormat
+
+		go func(i int, book model.Bookmark, keepMetadata bool) {
+			// Make sure to finish the WG
+			defer wg.Done()
+
+			// Register goroutine to semaphore
+			semaphore <- struct{}{}
+			defer func() {
+				<-semaphore
+			}()
+
+			// Report the outcome once finished
+			defer func() {
+				mx.Lock()
+				bookmarks[i] = book
+				mx.Unlock()
+			}()
+
+			// Download data from internet
+			content, contentType, statusCode, waybackURL, err := core.FetchBookmark(book.URL, h.RenderPolicy)
+			if err != nil {
+				book.RefreshResult = refreshFailed
+				return
+			}
+
+			book.LastStatusCode = statusCode
+			book.WaybackURL = waybackURL
+
+			request := core.ProcessRequest{
+				DataDir:        h.DataDir,
+				Bookmark:       book,
+				Content:        content,
+				ContentType:    contentType,
+				KeepTitle:      keepMetadata,
+				KeepExcerpt:    keepMetadata,
+				MaxResources:   h.MaxResources,
+				MaxSnapshots:   h.MaxSnapshots,
+				RenderPolicy:   h.RenderPolicy,
+				ArchivalPolicy: h.archivalPolicy(r),
+				SkipUnchanged:  true,
+			}
+
+			processed, _, err := core.ProcessBookmark(request)
+			content.Close()
+
+			if err == core.ErrUnchanged {
+				book.RefreshResult = refreshUnchanged
+				return
+			} else if err != nil {
+				book.RefreshResult = refreshFailed
+				return
+			}
+
+			book = processed
+			book.RefreshResult = refreshRefreshed
+		}(i, book, request.KeepMetadata)
+	}
+
+	// Wait until all download finished
+	wg.Wait()
+
+	// Update database, only for bookmarks that actually refreshed
+	refreshed := []model.Bookmark{}
+	for _, book := range bookmarks {
+		if book.RefreshResult == refreshRefreshed {
+			refreshed = append(refreshed, book)
+		}
+	}
+
+	if len(refreshed) > 0 {
+		saved, err := h.DB.SaveBookmarks(refreshed...)
+		checkError(err)
+
+		event := eventBookmarkUpdated
+		if request.CreateArchive {
+			event = eventBookmarkArchived
+		}
+		h.Webhooks.send(event, saved...)
+
+		savedByID := map[int]model.Bookmark{}
+		for _, book := range saved {
+			savedByID[book.ID] = book
+		}
+
+		for i, book := range bookmarks {
+			if savedBook, ok := savedByID[book.ID]; ok {
+				bookmarks[i] = savedBook
+			}
+		}
+	}
+
+	// Return new saved result
+	w.Header().Set("Content-Type", "application/json")
+	err = json.NewEncoder(w).Encode(&bookmarks)
+	checkError(err)
+}
+
+// apiRepair is handler for POST /api/repair
+//
+// It reconciles the data that derived from bookmarks, which might drift after
+// importing or restoring the data directory. The thumbnail and archive flags
+// are checked from the data directory on every request so they never drift,
+// but the files of deleted bookmarks are removed here, and the search index
+// is rebuilt. It's safe to run repeatedly, the second run corrects nothing.
+func (h *handler) apiRepair(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	// Rebuild search index
+	nIndex, err := h.DB.RebuildSearchIndex()
+	checkError(err)
+
+	// Remove thumbnails and archives of bookmarks that no longer exist
+	bookmarks, err := h.DB.GetBookmarks(database.GetBookmarksOptions{})
+	checkError(err)
+
+	ids := make(map[int]struct{}, len(bookmarks))
+	for _, book := range bookmarks {
+		ids[book.ID] = struct{}{}
+	}
+
+	nThumbs, nArchives, err := core.RemoveOrphanFiles(h.DataDir, ids, func(id int) {
+		h.ArchiveCache.Delete(strconv.Itoa(id))
+	})
+	checkError(err)
+
+	// Return the number of corrected records
+	resp := map[string]int{
+		"searchIndex":     nIndex,
+		"orphanThumbnail": nThumbs,
+		"orphanArchive":   nArchives,
+		"corrected":       nIndex + nThumbs + nArchives,
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	err = json.NewEncoder(w).Encode(&resp)
+	checkError(err)
+}
+
+// apiGetSnapshots is handler for GET /api/bookmark/:id/snapshots
+//
+// It lists the past archives of bookmark with versioned archive, newest first.
+// Each snapshot is served in /bookmark/:id/snapshot/:name/, while the latest
+// archive is still served in /bookmark/:id/archive/ as usual.
+func (h *handler) apiGetSnapshots(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	id, err := strconv.Atoi(ps.ByName("id"))
+	if err != nil {
+		panic(newClientError(http.StatusBadRequest, fmt.Errorf("bookmark id must be a number")))
+	}
+
+	if book, exist := h.DB.GetBookmark(id, ""); !exist || !canAccessBookmark(r, book) {
+		panic(newClientError(http.StatusNotFound, fmt.Errorf("Bookmark not found")))
+	}
+
+	snapshots, err := core.ListSnapshots(h.DataDir, id)
+	checkError(err)
+
+	w.Header().Set("Content-Type", "application/json")
+	err = json.NewEncoder(w).Encode(&snapshots)
+	checkError(err)
+}
+
+// apiGetScreenshot is handler for GET /api/bookmark/:id/screenshot
+//
+// It serves the full-page screenshot that captured in headless browser when
+// the bookmark was archived, which only exists if the server is configured
+// to capture it.
+func (h *handler) apiGetScreenshot(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	id, err := strconv.Atoi(ps.ByName("id"))
+	if err != nil {
+		panic(newClientError(http.StatusBadRequest, fmt.Errorf("bookmark id must be a number")))
+	}
+
+	if book, exist := h.DB.GetBookmark(id, ""); !exist || !canAccessBookmark(r, book) {
+		panic(newClientError(http.StatusNotFound, fmt.Errorf("Bookmark not found")))
+	}
+
+	h.serveScreenshot(w, r, id)
+}
+
+// apiGetArchiveResources is handler for GET /api/bookmark/:id/archive/resources
+//
+// It lists the resources that stored in archive of the bookmark, which useful
+// to find out why an archived page is not rendered completely. The original
+// URL of resources is not stored, so each resource is listed by the archival
+// name that derived from its URL, which is also used to serve it.
+func (h *handler) apiGetArchiveResources(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	id, err := strconv.Atoi(ps.ByName("id"))
+	if err != nil {
+		writeAPIError(w, http.StatusBadRequest, "bookmark id must be a number")
+		return
+	}
+
+	if book, exist := h.DB.GetBookmark(id, ""); exist && !canAccessBookmark(r, book) {
+		writeAPIError(w, http.StatusNotFound, "Bookmark not found")
+		return
+	}
+
+	strID := strconv.Itoa(id)
+	archivePath := fp.Join(h.DataDir, "archive", strID)
+	if !fileExists(archivePath) {
+		writeAPIError(w, http.StatusNotFound, "bookmark doesn't have archive")
+		return
+	}
+
+	// Make sure the archive is valid and kept in cache,
+	// since its resources are likely opened afterward.
+	_, err = h.getArchive(strID)
+	checkError(err)
+
+	resources, err := core.ListArchiveResources(archivePath)
+	checkError(err)
+
+	w.Header().Set("Content-Type", "application/json")
+	err = json.NewEncoder(w).Encode(&resources)
+	checkError(err)
+}
+
+// apiUpdateBookmarkTags is handler for PUT /api/bookmarks/tags
+//
+// The `mode` decides whether the tags are added to the bookmarks, removed
+// from them, or replace their tags altogether. By default they're added.
+//
+// When `dryRun=true` is specified, the updated bookmarks are returned
+// without being saved to database.
+func (h *handler) apiUpdateBookmarkTags(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	// Decode request
+	request := struct {
+		IDs  []int       `json:"ids"`
+		Tags []model.Tag `json:"tags"`
+		Mode string      `json:"mode"`
+	}{}
+
+	err := h.decodeJSON(r.Body, &request)
 	checkError(err)
 
 	// Validate input
-	if request.Title == "" {
-		panic(fmt.Errorf("Title must not empty"))
+	request.Tags = uniqueTags(request.Tags)
+	if request.Mode == "" {
+		request.Mode = tagModeAdd
+	}
+
+	if len(request.IDs) == 0 || (len(request.Tags) == 0 && request.Mode != tagModeReplace) {
+		panic(newClientError(http.StatusBadRequest, fmt.Errorf("IDs and tags must not empty")))
+	}
+
+	if err = validTagMode(request.Mode, request.Tags); err != nil {
+		panic(newClientError(http.StatusBadRequest, err))
 	}
 
 	// Get existing bookmark from database
 	filter := database.GetBookmarksOptions{
-		IDs:         []int{request.ID},
+		IDs:         request.IDs,
+		OwnerID:     bookmarkOwner(r),
 		WithContent: true,
 	}
 
 	bookmarks, err := h.DB.GetBookmarks(filter)
 	checkError(err)
 	if len(bookmarks) == 0 {
-		panic(fmt.Errorf("no bookmark with matching ids"))
+		panic(newClientError(http.StatusNotFound, fmt.Errorf("no bookmark with matching ids")))
 	}
 
-	// Set new bookmark data
-	book := bookmarks[0]
-	book.URL = request.URL
-	book.Title = request.Title
-	book.Excerpt = request.Excerpt
-	book.Public = request.Public
-
-	// Clean up bookmark URL
-	book.URL, err = core.RemoveUTMParams(book.URL)
-	if err != nil {
-		panic(fmt.Errorf("failed to clean URL: %v", err))
+	// Set new tags, which may change the visibility as well
+	defaultPublic := h.tagDefaultPublic()
+	for i, book := range bookmarks {
+		oldTags := append([]model.Tag{}, book.Tags...)
+		setBookmarkTags(&book, request.Tags, request.Mode)
+		applyTagDefaultPublic(&book, oldTags, defaultPublic)
+		bookmarks[i] = book
 	}
 
-	// Set new tags
-	for i := range book.Tags {
-		book.Tags[i].Deleted = true
+	// Update database, unless it's only a dry run
+	if !isDryRun(r) {
+		bookmarks, err = h.DB.SaveBookmarks(bookmarks...)
+		checkError(err)
+		h.Webhooks.send(eventBookmarkUpdated, bookmarks...)
 	}
 
-	for _, newTag := range request.Tags {
-		for i, oldTag := range book.Tags {
-			if newTag.Name == oldTag.Name {
-				newTag.ID = oldTag.ID
-				book.Tags[i].Deleted = false
-				break
-			}
-		}
-
-		if newTag.ID == 0 {
-			book.Tags = append(book.Tags, newTag)
+	// Get image URL for each bookmark
+	for i := range bookmarks {
+		strID := strconv.Itoa(bookmarks[i].ID)
+		if imgURL := h.thumbnailURL(strID); imgURL != "" {
+			bookmarks[i].ImageURL = imgURL
 		}
 	}
 
-	// Update database
-	res, err := h.DB.SaveBookmarks(book)
-	checkError(err)
-
-	// Add thumbnail image to the saved bookmarks again
-	newBook := res[0]
-	newBook.ImageURL = request.ImageURL
-	newBook.HasArchive = request.HasArchive
-
 	// Return new saved result
-	w.Header().Set("Content-Type", "application/json")
-	err = json.NewEncoder(w).Encode(&newBook)
+	err = json.NewEncoder(w).Encode(&bookmarks)
 	checkError(err)
 }
 
-// apiUpdateCache is handler for PUT /api/cache
-func (h *handler) apiUpdateCache(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+// apiUpdateBookmarkTagsByFilter is handler for PUT /api/bookmarks/tags/filter
+//
+// It's like apiUpdateBookmarkTags, except the tags are updated for every
+// bookmark that matches the filter in URL queries, e.g. `tags`, `keyword`
+// and `exclude`, which work the same as in apiGetBookmarks. Since it may
+// change a lot of bookmarks at once, `confirm=true` must be specified
+// unless it's only a dry run using `dryRun=true`.
+//
+// The bookmarks are saved in a single transaction, so either all of them or
+// none is changed, e.g. when a bookmark is changed by other client meanwhile.
+// It returns the number of matching bookmarks and the number whose tags are
+// changed.
+func (h *handler) apiUpdateBookmarkTagsByFilter(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
 	// Decode request
 	request := struct {
-		IDs           []int `json:"ids"`
-		KeepMetadata  bool  `json:"keepMetadata"`
-		CreateArchive bool  `json:"createArchive"`
+		Tags []model.Tag `json:"tags"`
+		Mode string      `json:"mode"`
 	}{}
 
-	err := json.NewDecoder(r.Body).Decode(&request)
+	err := h.decodeJSON(r.Body, &request)
 	checkError(err)
 
-	// Get existing bookmark from database
-	filter := database.GetBookmarksOptions{
-		IDs:         request.IDs,
-		WithContent: true,
+	// Validate input
+	request.Tags = uniqueTags(request.Tags)
+	if request.Mode == "" {
+		request.Mode = tagModeAdd
 	}
 
-	bookmarks, err := h.DB.GetBookmarks(filter)
-	checkError(err)
-	if len(bookmarks) == 0 {
-		panic(fmt.Errorf("no bookmark with matching ids"))
+	if err = validTagMode(request.Mode, request.Tags); err != nil {
+		panic(newClientError(http.StatusBadRequest, err))
 	}
 
-	// For web interface, let's limit to max 20 IDs to update, and 5 for archival.
-	// This is done to prevent the REST request from client took too long to finish.
-	if len(bookmarks) > 20 {
-		panic(fmt.Errorf("max 20 bookmarks to update"))
-	} else if len(bookmarks) > 5 && request.CreateArchive {
-		panic(fmt.Errorf("max 5 bookmarks to update with archival"))
+	dryRun := isDryRun(r)
+	confirmed, _ := strconv.ParseBool(r.URL.Query().Get("confirm"))
+	if !dryRun && !confirmed {
+		panic(newClientError(http.StatusBadRequest, fmt.Errorf("confirm=true is required to update every matching bookmark")))
 	}
 
-	// Fetch data from internet
-	mx := sync.RWMutex{}
-	wg := sync.WaitGroup{}
-	chDone := make(chan struct{})
-	chProblem := make(chan int, 10)
-	semaphore := make(chan struct{}, 10)
-
-	for i, book := range bookmarks {
-		wg.Add(1)
-
-		// Mark whether book will be archived
-		book.CreateArchive = request.CreateArchive
-
-		go func(i int, book model.Bookmark, keepMetadata bool) {
-			// Make sure to finish the WG
-			defer wg.Done()
-
-			// Register goroutine to semaphore
-			semaphore <- struct{}{}
-			defer func() {
-				<-semaphore
-			}()
+	filter, err := parseBookmarksFilter(r)
+	if err != nil {
+		panic(newClientError(http.StatusBadRequest, err))
+	}
 
-			// Download data from internet
-			content, contentType, err := core.DownloadBookmark(book.URL)
-			if err != nil {
-				chProblem <- book.ID
-				return
-			}
+	filter.OwnerID, err = bookmarkListOwner(r)
+	if err != nil {
+		panic(newClientError(http.StatusForbidden, err))
+	}
 
-			request := core.ProcessRequest{
-				DataDir:     h.DataDir,
-				Bookmark:    book,
-				Content:     content,
-				ContentType: contentType,
-				KeepTitle:   keepMetadata,
-				KeepExcerpt: keepMetadata,
-			}
+	h.expandCollectionFilter(&filter)
 
-			book, _, err = core.ProcessBookmark(request)
-			content.Close()
+	// Find the matching bookmarks first, so the bookmarks whose tags are
+	// updated won't change which ones match the filter. Their content isn't
+	// fetched, since it's kept as it is.
+	matches, err := h.DB.GetBookmarks(filter)
+	checkQueryError(err)
 
-			if err != nil {
-				chProblem <- book.ID
-				return
-			}
+	changed := []model.Bookmark{}
+	defaultPublic := h.tagDefaultPublic()
+	for _, book := range matches {
+		oldTags := append([]model.Tag{}, book.Tags...)
+		if !setBookmarkTags(&book, request.Tags, request.Mode) {
+			continue
+		}
 
-			// Update list of bookmarks
-			mx.Lock()
-			bookmarks[i] = book
-			mx.Unlock()
-		}(i, book, request.KeepMetadata)
+		applyTagDefaultPublic(&book, oldTags, defaultPublic)
+		changed = append(changed, book)
 	}
 
-	// Receive all problematic bookmarks
-	idWithProblems := []int{}
-	go func() {
-		for {
-			select {
-			case <-chDone:
-				return
-			case id := <-chProblem:
-				idWithProblems = append(idWithProblems, id)
-			}
+	if !dryRun && len(changed) > 0 {
+		saved, err := h.DB.SaveBookmarksWithoutContent(changed...)
+		if err == database.ErrVersionConflict {
+			writeAPIError(w, http.StatusConflict, "some bookmarks have been modified meanwhile, none is updated")
+			return
 		}
-	}()
+		checkError(err)
 
-	// Wait until all download finished
-	wg.Wait()
-	close(chDone)
+		h.Webhooks.send(eventBookmarkUpdated, saved...)
+	}
 
-	// Update database
-	_, err = h.DB.SaveBookmarks(bookmarks...)
-	checkError(err)
+	resp := map[string]int{
+		"matched": len(matches),
+		"updated": len(changed),
+	}
 
-	// Return new saved result
 	w.Header().Set("Content-Type", "application/json")
-	err = json.NewEncoder(w).Encode(&bookmarks)
+	err = json.NewEncoder(w).Encode(&resp)
 	checkError(err)
 }
 
-// apiUpdateBookmarkTags is handler for PUT /api/bookmarks/tags
-func (h *handler) apiUpdateBookmarkTags(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
-	// Decode request
-	request := struct {
-		IDs  []int       `json:"ids"`
-		Tags []model.Tag `json:"tags"`
-	}{}
+// apiImportBookmarks is handler for POST /api/import
+//
+// The request body is a file in Netscape Bookmark format, either as it is or
+// as `file` field of multipart form. The file is imported in background, so
+// this returns 202 Accepted right away with the progress of import job, which
+// can be followed in GET /api/import/:id. When `generateTag=true` is
+// specified, the folder of each bookmark is added as its tag. When
+// `keepFolders=true` is specified, bookmarks are put in collections
+// that keep the structure of their folders. When `fetch=true` is specified,
+// every imported bookmark is downloaded and archived as part of the job.
+// With `format=pocket`, the file is Pocket's export in HTML or CSV instead,
+// whose tags, favorite and read status are kept. With `format=pinboard`, the
+// file is Pinboard's export in JSON, whose bookmarks are read unless marked
+// as `toread`, and public when they're `shared`. With `format=wallabag`, the
+// file is Wallabag's export in JSON, whose tags, starred and read status are
+// kept, while the content saved by Wallabag is archived. With
+// `format=instapaper`, the file is Instapaper's export in CSV, whose folders
+// are kept as tags and selections as excerpts.
+//
+// The options are read from URL queries, or from the fields of multipart
+// form that come before the file, e.g. when it's submitted by HTML form.
+func (h *handler) apiImportBookmarks(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	src, options := readUploadedFile(r)
+
+	opts := importOptions{OwnerID: newBookmarkOwner(r), QuotaAccount: quotaAccount(r)}
+	opts.GenerateTag, _ = strconv.ParseBool(options.Get("generateTag"))
+	opts.KeepFolders, _ = strconv.ParseBool(options.Get("keepFolders"))
+	opts.Fetch, _ = strconv.ParseBool(options.Get("fetch"))
+
+	switch opts.Format = options.Get("format"); opts.Format {
+	case "", importNetscape, importPocket, importPinboard, importWallabag, importInstapaper:
+	default:
+		panic(newClientError(http.StatusBadRequest, fmt.Errorf("format must be empty, netscape, pocket, pinboard, wallabag or instapaper")))
+	}
 
-	err := json.NewDecoder(r.Body).Decode(&request)
+	// Save the file, since it's read after this request finished
+	tmpFile, err := ioutil.TempFile("", "shiori-import-")
 	checkError(err)
 
-	// Validate input
-	if len(request.IDs) == 0 || len(request.Tags) == 0 {
-		panic(fmt.Errorf("IDs and tags must not empty"))
+	_, err = io.Copy(tmpFile, src)
+	tmpFile.Close()
+	if err != nil {
+		os.Remove(tmpFile.Name())
+		panic(err)
 	}
 
-	// Get existing bookmark from database
-	filter := database.GetBookmarksOptions{
-		IDs:         request.IDs,
-		WithContent: true,
+	// Start the import job
+	job, err := h.startImportJob(tmpFile.Name(), opts)
+	if err != nil {
+		os.Remove(tmpFile.Name())
+		if err == errShuttingDown {
+			panic(newClientError(http.StatusServiceUnavailable, err))
+		}
+		panic(err)
 	}
 
-	bookmarks, err := h.DB.GetBookmarks(filter)
+	progress := job.Progress()
+	w.Header().Set("Location", path.Join(h.RootPath, "api/import", progress.ID))
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusAccepted)
+	err = json.NewEncoder(w).Encode(&progress)
 	checkError(err)
-	if len(bookmarks) == 0 {
-		panic(fmt.Errorf("no bookmark with matching ids"))
-	}
+}
 
-	// Set new tags
-	for i, book := range bookmarks {
-		for _, newTag := range request.Tags {
-			for _, oldTag := range book.Tags {
-				if newTag.Name == oldTag.Name {
-					newTag.ID = oldTag.ID
-					break
-				}
+// readUploadedFile returns the file that uploaded in request body, either as
+// it is or as `file` field of multipart form, together with the options that
+// read from URL queries and the fields of multipart form before the file.
+func readUploadedFile(r *http.Request) (io.Reader, nurl.Values) {
+	options := r.URL.Query()
+
+	var src io.Reader = r.Body
+	if mediaType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type")); mediaType == "multipart/form-data" {
+		reader, err := r.MultipartReader()
+		checkError(err)
+
+		for {
+			part, err := reader.NextPart()
+			if err == io.EOF {
+				panic(newClientError(http.StatusBadRequest, fmt.Errorf("file is required")))
 			}
+			checkError(err)
 
-			if newTag.ID == 0 {
-				book.Tags = append(book.Tags, newTag)
+			if part.FormName() == "file" {
+				src = part
+				break
 			}
-		}
 
-		bookmarks[i] = book
+			// Other field is an option, which is small enough to read at once
+			value, err := ioutil.ReadAll(io.LimitReader(part, 64))
+			checkError(err)
+			options.Set(part.FormName(), string(value))
+		}
 	}
 
-	// Update database
-	bookmarks, err = h.DB.SaveBookmarks(bookmarks...)
+	return src, options
+}
+
+// apiGetImportProgress is handler for GET /api/import/:id
+//
+// It reports the status of import job, either "running", "finished",
+// "cancelled" or "failed", and how many of its bookmarks are processed.
+// The result of each processed entry in the file is listed in `results`,
+// in the order of the file, with the reason when it's skipped or failed.
+// Finished job is only kept for a day.
+func (h *handler) apiGetImportProgress(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	job := h.getImportJob(ps.ByName("id"))
+
+	resp := struct {
+		importProgress
+		Results []importResult `json:"results"`
+	}{job.Progress(), job.Results()}
+
+	w.Header().Set("Content-Type", "application/json")
+	err := json.NewEncoder(w).Encode(&resp)
 	checkError(err)
+}
 
-	// Get image URL for each bookmark
-	for i := range bookmarks {
-		strID := strconv.Itoa(bookmarks[i].ID)
-		imgPath := fp.Join(h.DataDir, "thumb", strID)
-		imgURL := path.Join(h.RootPath, "bookmark", strID, "thumb")
+// apiCancelImport is handler for DELETE /api/import/:id
+//
+// The job stops once the running batches are saved, so it might still
+// be running right after this returns. Bookmarks that have already been
+// imported are kept.
+func (h *handler) apiCancelImport(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	job := h.getImportJob(ps.ByName("id"))
+	job.cancel()
 
-		if fileExists(imgPath) {
-			bookmarks[i].ImageURL = imgURL
-		}
+	progress := job.Progress()
+	w.Header().Set("Content-Type", "application/json")
+	err := json.NewEncoder(w).Encode(&progress)
+	checkError(err)
+}
+
+// getImportJob returns the import job with the specified ID.
+func (h *handler) getImportJob(id string) *importJob {
+	cached, found := h.ImportCache.Get(id)
+	if !found {
+		panic(newClientError(http.StatusNotFound, fmt.Errorf("import job not found")))
 	}
 
-	// Return new saved result
-	err = json.NewEncoder(w).Encode(&bookmarks)
-	checkError(err)
+	return cached.(*importJob)
 }
 
 // apiGetAccounts is handler for GET /api/accounts
@@ -455,12 +2331,19 @@ func (h *handler) apiGetAccounts(w http.ResponseWriter, r *http.Request, ps http
 }
 
 // apiInsertAccount is handler for POST /api/accounts
+//
+// The `role` is either owner, editor or viewer. When it's empty, account
+// is an owner or a viewer following its `owner` field.
 func (h *handler) apiInsertAccount(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
 	// Decode request
 	var account model.Account
-	err := json.NewDecoder(r.Body).Decode(&account)
+	err := h.decodeJSON(r.Body, &account)
 	checkError(err)
 
+	if account.Role != "" && !model.ValidRole(account.Role) {
+		panic(newClientError(http.StatusBadRequest, fmt.Errorf("role must be owner, editor or viewer")))
+	}
+
 	// Save account to database
 	err = h.DB.SaveAccount(account)
 	checkError(err)
@@ -469,6 +2352,9 @@ func (h *handler) apiInsertAccount(w http.ResponseWriter, r *http.Request, ps ht
 }
 
 // apiUpdateAccount is handler for PUT /api/accounts
+//
+// Like in POST, empty `role` makes the account an owner or a viewer
+// following its `owner` field.
 func (h *handler) apiUpdateAccount(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
 	// Decode request
 	request := struct {
@@ -476,26 +2362,32 @@ func (h *handler) apiUpdateAccount(w http.ResponseWriter, r *http.Request, ps ht
 		OldPassword string `json:"oldPassword"`
 		NewPassword string `json:"newPassword"`
 		Owner       bool   `json:"owner"`
+		Role        string `json:"role"`
 	}{}
 
-	err := json.NewDecoder(r.Body).Decode(&request)
+	err := h.decodeJSON(r.Body, &request)
 	checkError(err)
 
+	if request.Role != "" && !model.ValidRole(request.Role) {
+		panic(newClientError(http.StatusBadRequest, fmt.Errorf("role must be owner, editor or viewer")))
+	}
+
 	// Get existing account data from database
 	account, exist := h.DB.GetAccount(request.Username)
 	if !exist {
-		panic(fmt.Errorf("username doesn't exist"))
+		panic(newClientError(http.StatusNotFound, fmt.Errorf("username doesn't exist")))
 	}
 
 	// Compare old password with database
 	err = bcrypt.CompareHashAndPassword([]byte(account.Password), []byte(request.OldPassword))
 	if err != nil {
-		panic(fmt.Errorf("old password doesn't match"))
+		panic(newClientError(http.StatusBadRequest, fmt.Errorf("old password doesn't match")))
 	}
 
 	// Save new password to database
 	account.Password = request.NewPassword
 	account.Owner = request.Owner
+	account.Role = request.Role
 	err = h.DB.SaveAccount(account)
 	checkError(err)
 
@@ -506,7 +2398,7 @@ func (h *handler) apiUpdateAccount(w http.ResponseWriter, r *http.Request, ps ht
 func (h *handler) apiDeleteAccount(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
 	// Decode request
 	usernames := []string{}
-	err := json.NewDecoder(r.Body).Decode(&usernames)
+	err := h.decodeJSON(r.Body, &usernames)
 	checkError(err)
 
 	// Delete accounts
@@ -515,3 +2407,234 @@ func (h *handler) apiDeleteAccount(w http.ResponseWriter, r *http.Request, ps ht
 
 	fmt.Fprint(w, 1)
 }
+
+// sessionResponse is the response of login and refresh. Expires is
+// the time the access token expired, which must be refreshed before.
+type sessionResponse struct {
+	AccessToken  string         `json:"accessToken"`
+	RefreshToken string         `json:"refreshToken,omitempty"`
+	Expires      time.Time      `json:"expires"`
+	Session      *model.Session `json:"session,omitempty"`
+	Account      *model.Account `json:"account,omitempty"`
+}
+
+// apiLogin is handler for POST /api/login
+//
+// It starts new login session of account with matching `username` and
+// `password`. The access token in response is a short-lived JWT that's
+// sent in `Authorization: Bearer <token>` header, while the refresh token
+// is used to get new access token in POST /api/refresh. The refresh token is
+// set as HttpOnly cookie as well, which signs in the web interface.
+func (h *handler) apiLogin(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	// Decode request
+	request := struct {
+		Username string `json:"username"`
+		Password string `json:"password"`
+	}{}
+
+	err := h.decodeJSON(r.Body, &request)
+	checkError(err)
+
+	// Authenticate the account
+	account, valid := h.authenticatePassword(request.Username, request.Password)
+	if !valid {
+		panic(newClientError(http.StatusUnauthorized, fmt.Errorf("username or password doesn't match")))
+	}
+
+	resp, err := h.startSession(account)
+	checkError(err)
+	h.setSessionCookie(w, r, resp)
+
+	w.Header().Set("Content-Type", "application/json")
+	err = json.NewEncoder(w).Encode(&resp)
+	checkError(err)
+}
+
+// apiRefreshSession is handler for POST /api/refresh
+//
+// It creates new access token using `refreshToken` of the session, which
+// is valid until the session expired or revoked.
+func (h *handler) apiRefreshSession(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	// Decode request
+	request := struct {
+		RefreshToken string `json:"refreshToken"`
+	}{}
+
+	err := h.decodeJSON(r.Body, &request)
+	checkError(err)
+
+	session, account, valid := h.refreshSession(request.RefreshToken)
+	if !valid {
+		panic(newClientError(http.StatusUnauthorized, fmt.Errorf("refresh token is invalid, expired or revoked")))
+	}
+
+	accessToken, expires, err := h.createAccessToken(session, account)
+	checkError(err)
+
+	w.Header().Set("Content-Type", "application/json")
+	err = json.NewEncoder(w).Encode(&sessionResponse{
+		AccessToken: accessToken,
+		Expires:     expires,
+	})
+	checkError(err)
+}
+
+// apiLogout is handler for POST /api/logout
+//
+// It revokes the session whose access token or cookie is used by the request.
+func (h *handler) apiLogout(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	account, _ := requestAccount(r)
+	sessionID, ok := requestSession(r)
+	if !ok {
+		panic(newClientError(http.StatusUnauthorized, fmt.Errorf("access token is required")))
+	}
+
+	_, err := h.DB.DeleteSession(account.ID, sessionID)
+	checkError(err)
+	h.clearSessionCookie(w)
+
+	fmt.Fprint(w, 1)
+}
+
+// apiGetSessions is handler for GET /api/sessions
+//
+// It lists the unexpired login sessions of the authenticated account.
+func (h *handler) apiGetSessions(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	account, ok := requestAccount(r)
+	if !ok {
+		panic(newClientError(http.StatusUnauthorized, fmt.Errorf("access token or API token is required")))
+	}
+
+	sessions, err := h.DB.GetSessions(account.ID)
+	checkError(err)
+
+	w.Header().Set("Content-Type", "application/json")
+	err = json.NewEncoder(w).Encode(&sessions)
+	checkError(err)
+}
+
+// apiDeleteSession is handler for DELETE /api/sessions/:id
+//
+// It revokes login session of the authenticated account, e.g. the one
+// on lost device. Its access token is rejected right away.
+func (h *handler) apiDeleteSession(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	account, ok := requestAccount(r)
+	if !ok {
+		panic(newClientError(http.StatusUnauthorized, fmt.Errorf("access token or API token is required")))
+	}
+
+	id, err := strconv.Atoi(ps.ByName("id"))
+	if err != nil {
+		panic(newClientError(http.StatusBadRequest, fmt.Errorf("session id must be a number")))
+	}
+
+	deleted, err := h.DB.DeleteSession(account.ID, id)
+	checkError(err)
+
+	if !deleted {
+		panic(newClientError(http.StatusNotFound, fmt.Errorf("session not found")))
+	}
+
+	fmt.Fprint(w, 1)
+}
+
+// apiGetTokens is handler for GET /api/tokens
+//
+// It lists the API tokens of the authenticated account.
+// The tokens themselves are never shown again once they're created.
+func (h *handler) apiGetTokens(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	account, ok := requestAccount(r)
+	if !ok {
+		panic(newClientError(http.StatusUnauthorized, fmt.Errorf("access token or API token is required")))
+	}
+
+	tokens, err := h.DB.GetAPITokens(account.ID)
+	checkError(err)
+
+	w.Header().Set("Content-Type", "application/json")
+	err = json.NewEncoder(w).Encode(&tokens)
+	checkError(err)
+}
+
+// apiInsertToken is handler for POST /api/tokens
+//
+// The account is authenticated by its `username` and `password`, or by
+// access token of its session or its existing API token. The new token
+// is only returned in this response, so client must keep it since only
+// its hash is stored. Token with `read` scope can only be used to read,
+// while the default `full` scope can do anything the account can do.
+func (h *handler) apiInsertToken(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	// Decode request
+	request := struct {
+		Username string `json:"username"`
+		Password string `json:"password"`
+		Name     string `json:"name"`
+		Scope    string `json:"scope"`
+	}{}
+
+	err := h.decodeJSON(r.Body, &request)
+	checkError(err)
+
+	request.Name = strings.TrimSpace(request.Name)
+	if request.Name == "" {
+		panic(newClientError(http.StatusBadRequest, fmt.Errorf("token name must not be empty")))
+	}
+
+	if request.Scope == "" {
+		request.Scope = model.TokenScopeFull
+	} else if !model.ValidTokenScope(request.Scope) {
+		panic(newClientError(http.StatusBadRequest, fmt.Errorf("token scope %q is not valid", request.Scope)))
+	}
+
+	// Authenticate the account
+	account, ok := requestAccount(r)
+	if !ok {
+		account, ok = h.authenticatePassword(request.Username, request.Password)
+		if !ok {
+			panic(newClientError(http.StatusUnauthorized, fmt.Errorf("username or password doesn't match")))
+		}
+	}
+
+	// Create and save the token
+	token, err := newAPIToken()
+	checkError(err)
+
+	apiToken, err := h.DB.SaveAPIToken(model.APIToken{
+		AccountID: account.ID,
+		Name:      request.Name,
+		Hash:      hashAPIToken(token),
+		Scope:     request.Scope,
+	})
+	checkError(err)
+
+	apiToken.Token = token
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	err = json.NewEncoder(w).Encode(&apiToken)
+	checkError(err)
+}
+
+// apiDeleteToken is handler for DELETE /api/tokens/:id
+//
+// It revokes API token of the authenticated account, which may be
+// the very token that's used by the request.
+func (h *handler) apiDeleteToken(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	account, ok := requestAccount(r)
+	if !ok {
+		panic(newClientError(http.StatusUnauthorized, fmt.Errorf("access token or API token is required")))
+	}
+
+	id, err := strconv.Atoi(ps.ByName("id"))
+	if err != nil {
+		panic(newClientError(http.StatusBadRequest, fmt.Errorf("token id must be a number")))
+	}
+
+	deleted, err := h.DB.DeleteAPIToken(account.ID, id)
+	checkError(err)
+
+	if !deleted {
+		panic(newClientError(http.StatusNotFound, fmt.Errorf("token not found")))
+	}
+
+	fmt.Fprint(w, 1)
+}
@@ -0,0 +1,3157 @@
+package webserver
+
+import (
+	"bytes"
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"mime"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	fp "path/filepath"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"shiori/internal/core"
+	"shiori/internal/database"
+	"shiori/internal/model"
+	"github.com/julienschmidt/httprouter"
+	_ "github.com/mattn/go-sqlite3"
+	cch "github.com/patrickmn/go-cache"
+	"go.etcd.io/bbolt"
+)
+
+// newTestHandler creates handler that backed by SQLite database in
+// temporary directory. The returned function must be called to clean up.
+func newTestHandler(t *testing.T) (*handler, func()) {
+	tmpDir, err := ioutil.TempDir("", "shiori-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	db, err := database.OpenSQLiteDatabase(fp.Join(tmpDir, "shiori.db"))
+	if err != nil {
+		os.RemoveAll(tmpDir)
+		t.Fatal(err)
+	}
+
+	hdl := &handler{
+		DB:           db,
+		DataDir:      tmpDir,
+		ArchiveCache: cch.New(time.Hour, time.Hour),
+		InsertCache:  cch.New(time.Hour, time.Hour),
+		QuotaCache:   cch.New(time.Hour, time.Hour),
+		ImportCache:  cch.New(time.Hour, time.Hour),
+		OIDCCache:    cch.New(time.Hour, time.Hour),
+	}
+
+	return hdl, func() {
+		db.Close()
+		os.RemoveAll(tmpDir)
+	}
+}
+
+// newOwnerRequest creates request for testing handler, which authenticated
+// as owner, so it may access every bookmark.
+func newOwnerRequest(method, target string, body io.Reader) *http.Request {
+	owner := model.Account{Username: "owner", Role: model.RoleOwner, Owner: true}
+	return withAccount(httptest.NewRequest(method, target, body), owner)
+}
+
+func Test_apiBackup(t *testing.T) {
+	hdl, cleanup := newTestHandler(t)
+	defer cleanup()
+
+	_, err := hdl.DB.SaveBookmarks(model.Bookmark{ID: 1, URL: "https://example.com", Title: "Example"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	os.MkdirAll(fp.Join(hdl.DataDir, "thumb"), os.ModePerm)
+	ioutil.WriteFile(fp.Join(hdl.DataDir, "thumb", "1"), []byte("thumbnail"), os.ModePerm)
+
+	hdl.Build.Version = "1.0.0"
+	rec := httptest.NewRecorder()
+	hdl.apiBackup(rec, newOwnerRequest("GET", "/api/backup", nil), nil)
+
+	if contentType := rec.Header().Get("Content-Type"); contentType != "application/gzip" {
+		t.Errorf("Content-Type = %q, want application/gzip", contentType)
+	}
+
+	// The backup is restored into another data dir
+	dstDir, err := ioutil.TempDir("", "shiori-restore")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dstDir)
+
+	manifest, err := core.RestoreBackup(rec.Body, dstDir)
+	if err != nil {
+		t.Fatalf("failed to restore backup: %v", err)
+	}
+
+	if manifest.Version != "1.0.0" || !manifest.Database {
+		t.Errorf("backup manifest = %+v", manifest)
+	}
+
+	if thumb, _ := ioutil.ReadFile(fp.Join(dstDir, "thumb", "1")); string(thumb) != "thumbnail" {
+		t.Errorf("restored thumbnail = %q", thumb)
+	}
+
+	db, err := database.OpenSQLiteDatabase(fp.Join(dstDir, "shiori.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	if _, exist := db.GetBookmark(1, ""); !exist {
+		t.Errorf("restored database doesn't have the bookmark")
+	}
+
+	// Database that can't be backed up fails, unless it's skipped
+	memoryDB, err := database.OpenSQLiteDatabase(":memory:")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer memoryDB.Close()
+	hdl.DB = memoryDB
+
+	rec = httptest.NewRecorder()
+	hdl.apiBackup(rec, newOwnerRequest("GET", "/api/backup", nil), nil)
+	if rec.Code != http.StatusNotImplemented {
+		t.Errorf("backup of unsupported database status = %d, want %d", rec.Code, http.StatusNotImplemented)
+	}
+
+	rec = httptest.NewRecorder()
+	hdl.apiBackup(rec, newOwnerRequest("GET", "/api/backup?skipDatabase=true", nil), nil)
+	manifest, err = core.RestoreBackup(rec.Body, dstDir)
+	if err != nil || manifest.Database {
+		t.Errorf("backup with skipDatabase = %+v, %v, want backup without database", manifest, err)
+	}
+}
+
+func Test_apiDeleteBookmarkDryRun(t *testing.T) {
+	tests := []struct {
+		name      string
+		url       string
+		wantCount int
+	}{
+		{"dry run", "/api/bookmarks?dryRun=true", 2},
+		{"actual delete", "/api/bookmarks", 1},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			hdl, cleanup := newTestHandler(t)
+			defer cleanup()
+
+			_, err := hdl.DB.SaveBookmarks(
+				model.Bookmark{ID: 1, URL: "https://example.com/1", Title: "One"},
+				model.Bookmark{ID: 2, URL: "https://example.com/2", Title: "Two"})
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			req := newOwnerRequest("DELETE", tt.url, strings.NewReader(`[1]`))
+			rec := httptest.NewRecorder()
+			hdl.apiDeleteBookmark(rec, req, nil)
+
+			nBookmarks, err := hdl.DB.GetBookmarksCount(database.GetBookmarksOptions{})
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if nBookmarks != tt.wantCount {
+				t.Errorf("apiDeleteBookmark() left %d bookmarks, want %d", nBookmarks, tt.wantCount)
+			}
+
+			if isDryRun(req) {
+				preview := []model.Bookmark{}
+				if err := json.NewDecoder(rec.Body).Decode(&preview); err != nil {
+					t.Fatal(err)
+				}
+
+				if len(preview) != 1 || preview[0].ID != 1 {
+					t.Errorf("apiDeleteBookmark() preview = %+v, want only bookmark 1", preview)
+				}
+			}
+		})
+	}
+}
+
+func Test_apiDeleteBookmarkCount(t *testing.T) {
+	tests := []struct {
+		name        string
+		body        string
+		wantDeleted int
+	}{
+		{"existing IDs", `[1, 2]`, 2},
+		{"partially missing IDs", `[2, 5]`, 1},
+		{"missing IDs", `[5, 6]`, 0},
+		{"all bookmarks", `[]`, 3},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			hdl, cleanup := newTestHandler(t)
+			defer cleanup()
+
+			_, err := hdl.DB.SaveBookmarks(
+				model.Bookmark{ID: 1, URL: "https://example.com/1", Title: "One"},
+				model.Bookmark{ID: 2, URL: "https://example.com/2", Title: "Two"},
+				model.Bookmark{ID: 3, URL: "https://example.com/3", Title: "Three"})
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			req := newOwnerRequest("DELETE", "/api/bookmarks", strings.NewReader(tt.body))
+			rec := httptest.NewRecorder()
+			hdl.apiDeleteBookmark(rec, req, nil)
+
+			resp := struct {
+				Deleted int `json:"deleted"`
+			}{}
+
+			if err := json.NewDecoder(rec.Body).Decode(&resp); err != nil {
+				t.Fatal(err)
+			}
+
+			if resp.Deleted != tt.wantDeleted {
+				t.Errorf("apiDeleteBookmark() deleted = %d, want %d", resp.Deleted, tt.wantDeleted)
+			}
+		})
+	}
+}
+
+func Test_apiInsertBookmarkIdempotency(t *testing.T) {
+	hdl, cleanup := newTestHandler(t)
+	defer cleanup()
+
+	insert := func(key string) *httptest.ResponseRecorder {
+		// Use unreachable URL, so the bookmark is saved without downloading
+		body := `{"url": "http://127.0.0.1:1/page"}`
+		req := newOwnerRequest("POST", "/api/bookmarks", strings.NewReader(body))
+		req.Header.Set("Idempotency-Key", key)
+
+		rec := httptest.NewRecorder()
+		hdl.apiInsertBookmark(rec, req, nil)
+		return rec
+	}
+
+	first, retry := model.Bookmark{}, model.Bookmark{}
+	json.NewDecoder(insert("key-1").Body).Decode(&first)
+	json.NewDecoder(insert("key-1").Body).Decode(&retry)
+
+	if first.ID == 0 || retry.ID != first.ID {
+		t.Errorf("retried insert returns bookmark %d, want %d", retry.ID, first.ID)
+	}
+
+	nBookmarks, _ := hdl.DB.GetBookmarksCount(database.GetBookmarksOptions{})
+	if nBookmarks != 1 {
+		t.Errorf("got %d bookmarks after retried insert, want 1", nBookmarks)
+	}
+
+	// Request with key that still in progress is rejected
+	hdl.InsertCache.Set("key-2", nil, cch.DefaultExpiration)
+	if rec := insert("key-2"); rec.Code != http.StatusConflict {
+		t.Errorf("insert with pending key status = %d, want %d", rec.Code, http.StatusConflict)
+	}
+}
+
+func Test_apiPatchBookmark(t *testing.T) {
+	tests := []struct {
+		name        string
+		body        string
+		wantTitle   string
+		wantExcerpt string
+		wantTags    int
+	}{
+		{"only excerpt", `{"excerpt": "New excerpt"}`, "Title", "New excerpt", 2},
+		{"clear excerpt", `{"excerpt": ""}`, "Title", "", 2},
+		{"only tags", `{"tags": [{"name": "go"}]}`, "Title", "Excerpt", 1},
+		{"empty body", `{}`, "Title", "Excerpt", 2},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			hdl, cleanup := newTestHandler(t)
+			defer cleanup()
+
+			_, err := hdl.DB.SaveBookmarks(model.Bookmark{
+				ID:      1,
+				URL:     "https://example.com",
+				Title:   "Title",
+				Excerpt: "Excerpt",
+				Tags:    []model.Tag{{Name: "go"}, {Name: "web"}},
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			req := newOwnerRequest("PATCH", "/api/bookmarks/1", strings.NewReader(tt.body))
+			rec := httptest.NewRecorder()
+			hdl.apiPatchBookmark(rec, req, httprouter.Params{{Key: "id", Value: "1"}})
+
+			book, _ := hdl.DB.GetBookmark(1, "")
+			if book.Title != tt.wantTitle || book.Excerpt != tt.wantExcerpt {
+				t.Errorf("apiPatchBookmark() title = %q, excerpt = %q, want %q and %q",
+					book.Title, book.Excerpt, tt.wantTitle, tt.wantExcerpt)
+			}
+
+			bookmarks, _ := hdl.DB.GetBookmarks(database.GetBookmarksOptions{IDs: []int{1}})
+			if len(bookmarks) != 1 || len(bookmarks[0].Tags) != tt.wantTags {
+				t.Errorf("apiPatchBookmark() tags = %+v, want %d tags", bookmarks, tt.wantTags)
+			}
+		})
+	}
+}
+
+func Test_apiGetVersion(t *testing.T) {
+	hdl := &handler{Build: BuildInfo{Version: "1.5.0", Commit: "abc1234", BuildDate: "2020-01-01"}}
+
+	req := httptest.NewRequest("GET", "/api/version", nil)
+	rec := httptest.NewRecorder()
+	hdl.apiGetVersion(rec, req, nil)
+
+	resp := struct {
+		BuildInfo
+		Features []string `json:"features"`
+	}{}
+
+	if err := json.NewDecoder(rec.Body).Decode(&resp); err != nil {
+		t.Fatal(err)
+	}
+
+	if resp.BuildInfo != hdl.Build {
+		t.Errorf("apiGetVersion() build = %+v, want %+v", resp.BuildInfo, hdl.Build)
+	}
+
+	if len(resp.Features) != len(features) {
+		t.Errorf("apiGetVersion() features = %v, want %v", resp.Features, features)
+	}
+}
+
+func Test_apiGetSchema(t *testing.T) {
+	hdl, cleanup := newTestHandler(t)
+	defer cleanup()
+
+	req := newOwnerRequest("GET", "/api/schema", nil)
+	rec := httptest.NewRecorder()
+	hdl.apiGetSchema(rec, req, nil)
+
+	version := database.SchemaVersion{}
+	if err := json.NewDecoder(rec.Body).Decode(&version); err != nil {
+		t.Fatal(err)
+	}
+
+	if version.Current == 0 || version.Current != version.Target {
+		t.Errorf("apiGetSchema() = %+v, want migrated database", version)
+	}
+}
+
+func Test_apiRepair(t *testing.T) {
+	hdl, cleanup := newTestHandler(t)
+	defer cleanup()
+
+	_, err := hdl.DB.SaveBookmarks(
+		model.Bookmark{ID: 1, URL: "https://example.com/1", Title: "One"},
+		model.Bookmark{ID: 2, URL: "https://example.com/2", Title: "Two"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Drop search index of bookmark 1, and leave a thumbnail of deleted bookmark
+	sqliteDB := hdl.DB.(*database.SQLiteDatabase)
+	sqliteDB.MustExec(`DELETE FROM bookmark_content WHERE docid = 1`)
+
+	thumbDir := fp.Join(hdl.DataDir, "thumb")
+	os.MkdirAll(thumbDir, os.ModePerm)
+	ioutil.WriteFile(fp.Join(thumbDir, "3"), []byte("image"), os.ModePerm)
+
+	tests := []struct {
+		name          string
+		wantCorrected int
+	}{
+		{"first run", 2},
+		{"second run", 0},
+	}
+
+	for _, tt := range tests {
+		req := newOwnerRequest("POST", "/api/repair", nil)
+		rec := httptest.NewRecorder()
+		hdl.apiRepair(rec, req, nil)
+
+		resp := map[string]int{}
+		if err := json.NewDecoder(rec.Body).Decode(&resp); err != nil {
+			t.Fatal(err)
+		}
+
+		if resp["corrected"] != tt.wantCorrected {
+			t.Errorf("%s: apiRepair() = %v, want %d corrected", tt.name, resp, tt.wantCorrected)
+		}
+	}
+
+	nBookmarks, _ := hdl.DB.GetBookmarksCount(database.GetBookmarksOptions{Keyword: "one"})
+	if nBookmarks != 1 {
+		t.Errorf("bookmark 1 is not searchable after repair")
+	}
+}
+
+func Test_apiInsertBookmarkInvalidCreated(t *testing.T) {
+	hdl, cleanup := newTestHandler(t)
+	defer cleanup()
+
+	body := `{"url": "https://example.com", "created": "last week"}`
+	req := newOwnerRequest("POST", "/api/bookmarks", strings.NewReader(body))
+	rec := httptest.NewRecorder()
+	hdl.apiInsertBookmark(rec, req, nil)
+
+	if rec.Code != http.StatusBadRequest {
+		t.Errorf("apiInsertBookmark() status = %d, want %d", rec.Code, http.StatusBadRequest)
+	}
+
+	if nBookmarks, _ := hdl.DB.GetBookmarksCount(database.GetBookmarksOptions{}); nBookmarks != 0 {
+		t.Errorf("apiInsertBookmark() saved %d bookmarks, want none", nBookmarks)
+	}
+}
+
+func Test_apiInsertBookmarkQuota(t *testing.T) {
+	hdl, cleanup := newTestHandler(t)
+	defer cleanup()
+	hdl.InsertQuota = 2
+
+	alice := model.Account{Username: "alice", Role: model.RoleEditor}
+	insert := func(url string) *httptest.ResponseRecorder {
+		body := fmt.Sprintf(`{"url": %q}`, url)
+		req := withAccount(httptest.NewRequest("POST", "/api/bookmarks", strings.NewReader(body)), alice)
+		rec := httptest.NewRecorder()
+		hdl.apiInsertBookmark(rec, req, nil)
+		return rec
+	}
+
+	if rec := insert("http://127.0.0.1:1/page"); rec.Code != http.StatusOK {
+		t.Fatalf("first insert status = %d, want %d", rec.Code, http.StatusOK)
+	}
+
+	// Duplicate is rejected without using up the quota
+	if rec := insert("http://127.0.0.1:1/page"); rec.Code != http.StatusConflict {
+		t.Fatalf("duplicate insert status = %d, want %d", rec.Code, http.StatusConflict)
+	}
+
+	if rec := insert("http://127.0.0.1:1/other"); rec.Code != http.StatusOK {
+		t.Fatalf("second insert status = %d, want %d", rec.Code, http.StatusOK)
+	}
+
+	rec := insert("http://127.0.0.1:1/third")
+	if rec.Code != http.StatusTooManyRequests {
+		t.Errorf("insert over quota status = %d, want %d", rec.Code, http.StatusTooManyRequests)
+	}
+
+	if rec.Header().Get("Retry-After") == "" {
+		t.Errorf("insert over quota doesn't set Retry-After")
+	}
+
+	if nBookmarks, _ := hdl.DB.GetBookmarksCount(database.GetBookmarksOptions{}); nBookmarks != 2 {
+		t.Errorf("got %d bookmarks after insert over quota, want 2", nBookmarks)
+	}
+}
+
+func Test_apiInsertBookmarkArchiveOnInsert(t *testing.T) {
+	tests := []struct {
+		name            string
+		archiveOnInsert bool
+		body            string
+		wantArchive     bool
+	}{
+		{"server default off", false, `{"url": "http://127.0.0.1:1/page"}`, false},
+		{"server default on", true, `{"url": "http://127.0.0.1:1/page"}`, true},
+		{"disabled by request", true, `{"url": "http://127.0.0.1:1/page", "createArchive": false}`, false},
+		{"enabled by request", false, `{"url": "http://127.0.0.1:1/page", "createArchive": true}`, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			hdl, cleanup := newTestHandler(t)
+			defer cleanup()
+
+			// Use up the archival quota, so archived insert is rejected
+			hdl.ArchiveOnInsert = tt.archiveOnInsert
+			hdl.ArchivalQuota = 1
+			hdl.QuotaCache.Set("archival:account:alice", 1, cch.NoExpiration)
+
+			alice := model.Account{Username: "alice", Role: model.RoleEditor}
+			req := withAccount(httptest.NewRequest("POST", "/api/bookmarks", strings.NewReader(tt.body)), alice)
+			rec := httptest.NewRecorder()
+			hdl.apiInsertBookmark(rec, req, nil)
+
+			if gotArchive := rec.Code == http.StatusTooManyRequests; gotArchive != tt.wantArchive {
+				t.Errorf("apiInsertBookmark() status = %d, want archival %v", rec.Code, tt.wantArchive)
+			}
+		})
+	}
+}
+
+func Test_apiInsertBookmarkFailOnStatus(t *testing.T) {
+	tests := []struct {
+		name         string
+		pageStatus   int
+		failOnStatus []int
+		wantStatus   int
+	}{
+		{"saved by default", http.StatusNotFound, nil, http.StatusOK},
+		{"rejected status", http.StatusNotFound, []int{404, 410}, http.StatusUnprocessableEntity},
+		{"other status", http.StatusOK, []int{404, 410}, http.StatusOK},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				w.Header().Set("Content-Type", "text/plain")
+				w.WriteHeader(tt.pageStatus)
+				w.Write([]byte("page content"))
+			}))
+			defer srv.Close()
+
+			hdl, cleanup := newTestHandler(t)
+			defer cleanup()
+			hdl.FailOnStatus = tt.failOnStatus
+
+			body := `{"url": "` + srv.URL + `/page"}`
+			req := newOwnerRequest("POST", "/api/bookmarks", strings.NewReader(body))
+			rec := httptest.NewRecorder()
+			hdl.apiInsertBookmark(rec, req, nil)
+
+			if rec.Code != tt.wantStatus {
+				t.Fatalf("apiInsertBookmark() status = %d, want %d: %s", rec.Code, tt.wantStatus, rec.Body)
+			}
+
+			bookmarks, _ := hdl.DB.GetBookmarks(database.GetBookmarksOptions{})
+			if tt.wantStatus != http.StatusOK {
+				if len(bookmarks) != 0 {
+					t.Errorf("rejected bookmark is saved")
+				}
+				return
+			}
+
+			if len(bookmarks) != 1 || bookmarks[0].LastStatusCode != tt.pageStatus {
+				t.Errorf("saved bookmarks = %+v, want one with status %d", bookmarks, tt.pageStatus)
+			}
+		})
+	}
+}
+
+func Test_apiInsertBookmarkSuppliedContent(t *testing.T) {
+	tests := []struct {
+		name string
+		body string
+	}{
+		{"html", `{"url": "%s", "title": "Private page", "html": "<p>Only readable after login</p>"}`},
+		{"text", `{"url": "%s", "title": "Private page", "content": "Only readable after login"}`},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			nDownloads := 0
+			srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				nDownloads++
+				http.Error(w, "login required", http.StatusUnauthorized)
+			}))
+			defer srv.Close()
+
+			hdl, cleanup := newTestHandler(t)
+			defer cleanup()
+
+			body := fmt.Sprintf(tt.body, srv.URL+"/private")
+			req := newOwnerRequest("POST", "/api/bookmarks", strings.NewReader(body))
+			rec := httptest.NewRecorder()
+			hdl.apiInsertBookmark(rec, req, nil)
+
+			if rec.Code != http.StatusOK {
+				t.Fatalf("apiInsertBookmark() status = %d, want %d: %s", rec.Code, http.StatusOK, rec.Body)
+			}
+
+			if nDownloads != 0 {
+				t.Errorf("page is downloaded %d times, want none", nDownloads)
+			}
+
+			book, _ := hdl.DB.GetBookmark(0, srv.URL+"/private")
+			if book.Title != "Private page" || book.ContentType != "text/html" {
+				t.Errorf("bookmark = %+v, want the submitted page", book)
+			}
+		})
+	}
+}
+
+func Test_apiInsertBookmarkDuplicate(t *testing.T) {
+	hdl, cleanup := newTestHandler(t)
+	defer cleanup()
+
+	insert := func(query, url, title string) *httptest.ResponseRecorder {
+		body := fmt.Sprintf(`{"url": %q, "title": %q, "content": "Page"}`, url, title)
+		req := newOwnerRequest("POST", "/api/bookmarks"+query, strings.NewReader(body))
+		rec := httptest.NewRecorder()
+		hdl.apiInsertBookmark(rec, req, nil)
+		return rec
+	}
+
+	if rec := insert("", "https://example.com/page", "First"); rec.Code != http.StatusOK {
+		t.Fatalf("first insert status = %d: %s", rec.Code, rec.Body)
+	}
+
+	// The same URL after clean up returns the existing bookmark
+	rec := insert("", "https://example.com/page?utm_source=feed", "Second")
+	if rec.Code != http.StatusConflict {
+		t.Fatalf("duplicate insert status = %d, want %d: %s", rec.Code, http.StatusConflict, rec.Body)
+	}
+
+	resp := struct {
+		Code     string         `json:"code"`
+		Bookmark model.Bookmark `json:"bookmark"`
+	}{}
+	if err := json.NewDecoder(rec.Body).Decode(&resp); err != nil {
+		t.Fatal(err)
+	}
+
+	if resp.Code != "conflict" || resp.Bookmark.ID != 1 || resp.Bookmark.Title != "First" {
+		t.Errorf("duplicate insert = %+v, want the existing bookmark", resp)
+	}
+
+	// Forced insert saves over the existing bookmark
+	if rec = insert("?force=true", "https://example.com/page", "Second"); rec.Code != http.StatusOK {
+		t.Fatalf("forced insert status = %d: %s", rec.Code, rec.Body)
+	}
+
+	bookmarks, err := hdl.DB.GetBookmarks(database.GetBookmarksOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(bookmarks) != 1 || bookmarks[0].ID != 1 || bookmarks[0].Title != "Second" || bookmarks[0].Version != 2 {
+		t.Errorf("bookmarks after forced insert = %+v, want bookmark 1 saved again", bookmarks)
+	}
+
+	// Equivalent URL is the same bookmark as well
+	for _, url := range []string{"https://EXAMPLE.com:443/page/", "https://example.com/page#top"} {
+		if rec = insert("", url, "Third"); rec.Code != http.StatusConflict {
+			t.Errorf("insert of %s status = %d, want %d", url, rec.Code, http.StatusConflict)
+		}
+	}
+
+	// Other account has its own bookmark of the same URL, which
+	// conflicts with its later insert instead of the owner's one
+	alice := model.Account{ID: 2, Username: "alice", Role: model.RoleEditor}
+	insertAsAlice := func() *httptest.ResponseRecorder {
+		body := `{"url": "https://example.com/page/", "title": "Alice", "content": "Page"}`
+		req := withAccount(httptest.NewRequest("POST", "/api/bookmarks", strings.NewReader(body)), alice)
+		rec := httptest.NewRecorder()
+		hdl.apiInsertBookmark(rec, req, nil)
+		return rec
+	}
+
+	if rec = insertAsAlice(); rec.Code != http.StatusOK {
+		t.Fatalf("insert by other account status = %d: %s", rec.Code, rec.Body)
+	}
+
+	rec = insertAsAlice()
+	if rec.Code != http.StatusConflict || strings.Contains(rec.Body.String(), "Second") {
+		t.Errorf("duplicate insert by other account = %d %s, want conflict with its own bookmark", rec.Code, rec.Body)
+	}
+
+	if book, _ := hdl.DB.GetBookmark(1, ""); book.Title != "Second" || book.OwnerID != 0 {
+		t.Errorf("bookmark after insert by other account = %+v, want it unchanged", book)
+	}
+}
+
+func Test_suppliedPage(t *testing.T) {
+	tests := []struct {
+		name     string
+		html     string
+		text     string
+		want     []string
+		unwanted []string
+	}{
+		{"nothing", " ", "\n", nil, nil},
+		{"html", `<p onclick="alert(1)">Page</p><script>alert(2)</script>`, "",
+			[]string{"<p>Page</p>"}, []string{"alert"}},
+		{"text", "", "First <b>\r\n\r\nSecond\nline",
+			[]string{"<p>First &lt;b&gt;</p>", "<p>Second\nline</p>"}, []string{"<b>"}},
+		{"html over text", "<p>Page</p>", "Text",
+			[]string{"<p>Page</p>"}, []string{"Text"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			page, err := suppliedPage(tt.html, tt.text)
+			if err != nil {
+				t.Fatalf("suppliedPage() error = %v", err)
+			}
+
+			if tt.want == nil && page != nil {
+				t.Errorf("suppliedPage() = %s, want nil", page)
+			}
+
+			for _, want := range tt.want {
+				if !strings.Contains(string(page), want) {
+					t.Errorf("suppliedPage() = %s, want it to contain %s", page, want)
+				}
+			}
+
+			for _, unwanted := range tt.unwanted {
+				if strings.Contains(string(page), unwanted) {
+					t.Errorf("suppliedPage() = %s, want it without %s", page, unwanted)
+				}
+			}
+		})
+	}
+}
+
+func Test_emptyTitleNeverSaved(t *testing.T) {
+	// The page doesn't have any title
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/plain")
+		w.Write([]byte("file without title"))
+	}))
+	defer srv.Close()
+
+	tests := []struct {
+		name       string
+		run        func(hdl *handler) int
+		wantStatus int
+		wantTitle  string
+	}{
+		{"insert", func(hdl *handler) int {
+			body := `{"url": "` + srv.URL + `/insert", "title": " \n "}`
+			rec := httptest.NewRecorder()
+			hdl.apiInsertBookmark(rec, newOwnerRequest("POST", "/api/bookmarks", strings.NewReader(body)), nil)
+			return rec.Code
+		}, http.StatusOK, srv.URL + "/insert"},
+		{"insert via extension", func(hdl *handler) int {
+			body := `{"url": "` + srv.URL + `/ext"}`
+			rec := httptest.NewRecorder()
+			hdl.apiInsertViaExtension(rec, newOwnerRequest("POST", "/api/bookmarks/ext", strings.NewReader(body)), nil)
+			return rec.Code
+		}, http.StatusOK, srv.URL + "/ext"},
+		{"update", func(hdl *handler) int {
+			body := `{"id": 1, "url": "https://example.com/1", "title": "  ", "version": 1}`
+			rec := httptest.NewRecorder()
+			router := httprouter.New()
+			router.PUT("/api/bookmarks", hdl.apiUpdateBookmark)
+			router.PanicHandler = hdl.handlePanic
+			router.ServeHTTP(rec, newOwnerRequest("PUT", "/api/bookmarks", strings.NewReader(body)))
+			return rec.Code
+		}, http.StatusBadRequest, "Existing"},
+		{"patch", func(hdl *handler) int {
+			body := `{"title": "\t"}`
+			rec := httptest.NewRecorder()
+			router := httprouter.New()
+			router.PATCH("/api/bookmarks/1", func(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
+				hdl.apiPatchBookmark(w, r, httprouter.Params{{Key: "id", Value: "1"}})
+			})
+			router.PanicHandler = hdl.handlePanic
+			router.ServeHTTP(rec, newOwnerRequest("PATCH", "/api/bookmarks/1", strings.NewReader(body)))
+			return rec.Code
+		}, http.StatusBadRequest, "Existing"},
+		{"import", func(hdl *handler) int {
+			srcPath := fp.Join(hdl.DataDir, "bookmarks.html")
+			content := `<DL><p><DT><A HREF="https://example.com/import"> </A></DL><p>`
+			if err := ioutil.WriteFile(srcPath, []byte(content), 0644); err != nil {
+				t.Fatal(err)
+			}
+
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+			hdl.runImportJob(ctx, &importJob{cancel: cancel}, srcPath)
+			return http.StatusOK
+		}, http.StatusOK, "https://example.com/import"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			hdl, cleanup := newTestHandler(t)
+			defer cleanup()
+
+			_, err := hdl.DB.SaveBookmarks(model.Bookmark{ID: 1, URL: "https://example.com/1", Title: "Existing"})
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if status := tt.run(hdl); status != tt.wantStatus {
+				t.Fatalf("status = %d, want %d", status, tt.wantStatus)
+			}
+
+			bookmarks, err := hdl.DB.GetBookmarks(database.GetBookmarksOptions{})
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			titles := []string{}
+			for _, book := range bookmarks {
+				if book.Title == "" {
+					t.Errorf("bookmark %s is saved with empty title", book.URL)
+				}
+				titles = append(titles, book.Title)
+			}
+
+			found := false
+			for _, title := range titles {
+				found = found || title == tt.wantTitle
+			}
+
+			if !found {
+				t.Errorf("titles = %q, want one of them to be %q", titles, tt.wantTitle)
+			}
+		})
+	}
+}
+
+func Test_apiUpdateBookmarkVersion(t *testing.T) {
+	tests := []struct {
+		name       string
+		body       string
+		ifMatch    string
+		wantStatus int
+	}{
+		{"missing version", `{"id": 1, "url": "https://example.com", "title": "New"}`, "", http.StatusPreconditionRequired},
+		{"stale version", `{"id": 1, "url": "https://example.com", "title": "New", "version": 1}`, "", http.StatusConflict},
+		{"latest version", `{"id": 1, "url": "https://example.com", "title": "New", "version": 2}`, "", http.StatusOK},
+		{"latest version in header", `{"id": 1, "url": "https://example.com", "title": "New"}`, `"2"`, http.StatusOK},
+		{"header overrides body", `{"id": 1, "url": "https://example.com", "title": "New", "version": 2}`, `W/"1"`, http.StatusConflict},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			hdl, cleanup := newTestHandler(t)
+			defer cleanup()
+
+			// Save twice, so the bookmark is in its second version
+			book := model.Bookmark{ID: 1, URL: "https://example.com", Title: "Old"}
+			for i := 0; i < 2; i++ {
+				res, err := hdl.DB.SaveBookmarks(book)
+				if err != nil {
+					t.Fatal(err)
+				}
+				book = res[0]
+			}
+
+			req := newOwnerRequest("PUT", "/api/bookmarks", strings.NewReader(tt.body))
+			if tt.ifMatch != "" {
+				req.Header.Set("If-Match", tt.ifMatch)
+			}
+
+			rec := httptest.NewRecorder()
+			hdl.apiUpdateBookmark(rec, req, nil)
+
+			if rec.Code != tt.wantStatus {
+				t.Fatalf("apiUpdateBookmark() status = %d, want %d: %s", rec.Code, tt.wantStatus, rec.Body)
+			}
+
+			wantTitle, wantVersion := "Old", 2
+			if tt.wantStatus == http.StatusOK {
+				wantTitle, wantVersion = "New", 3
+				if etag := rec.Header().Get("ETag"); etag != `"3"` {
+					t.Errorf("apiUpdateBookmark() ETag = %s, want \"3\"", etag)
+				}
+			}
+
+			saved, _ := hdl.DB.GetBookmark(1, "")
+			if saved.Title != wantTitle || saved.Version != wantVersion {
+				t.Errorf("saved bookmark = %q version %d, want %q version %d",
+					saved.Title, saved.Version, wantTitle, wantVersion)
+			}
+		})
+	}
+}
+
+func Test_apiGetArchiveResources(t *testing.T) {
+	hdl, cleanup := newTestHandler(t)
+	defer cleanup()
+
+	// Create archive for bookmark 1 with a single resource
+	archiveDir := fp.Join(hdl.DataDir, "archive")
+	os.MkdirAll(archiveDir, os.ModePerm)
+
+	db, err := bbolt.Open(fp.Join(archiveDir, "1"), os.ModePerm, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = db.Update(func(tx *bbolt.Tx) error {
+		bucket, err := tx.CreateBucket([]byte("archive-root"))
+		if err != nil {
+			return err
+		}
+		bucket.Put([]byte("type"), []byte("text/html"))
+		return bucket.Put([]byte("content"), []byte("<html></html>"))
+	})
+	db.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name       string
+		id         string
+		wantStatus int
+		wantCount  int
+	}{
+		{"with archive", "1", http.StatusOK, 1},
+		{"without archive", "2", http.StatusNotFound, 0},
+		{"invalid id", "abc", http.StatusBadRequest, 0},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := newOwnerRequest("GET", "/api/bookmark/"+tt.id+"/archive/resources", nil)
+			rec := httptest.NewRecorder()
+			hdl.apiGetArchiveResources(rec, req, httprouter.Params{{Key: "id", Value: tt.id}})
+
+			if rec.Code != tt.wantStatus {
+				t.Fatalf("apiGetArchiveResources() status = %d, want %d", rec.Code, tt.wantStatus)
+			}
+
+			if tt.wantStatus != http.StatusOK {
+				return
+			}
+
+			resources := []core.ArchiveResource{}
+			if err := json.NewDecoder(rec.Body).Decode(&resources); err != nil {
+				t.Fatal(err)
+			}
+
+			if len(resources) != tt.wantCount {
+				t.Errorf("apiGetArchiveResources() = %+v, want %d resources", resources, tt.wantCount)
+			}
+
+			if _, cached := hdl.ArchiveCache.Get(tt.id); !cached {
+				t.Errorf("archive %s is not kept in cache", tt.id)
+			}
+		})
+	}
+}
+
+func Test_apiGetSnapshots(t *testing.T) {
+	hdl, cleanup := newTestHandler(t)
+	defer cleanup()
+
+	_, err := hdl.DB.SaveBookmarks(
+		model.Bookmark{ID: 1, URL: "https://example.com/1", Title: "1", VersionedArchive: true},
+		model.Bookmark{ID: 2, URL: "https://example.com/2", Title: "2"},
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Bookmark 1 has two snapshots, and a file that isn't snapshot
+	snapshotDir := core.SnapshotDir(hdl.DataDir, 1)
+	os.MkdirAll(snapshotDir, os.ModePerm)
+	for _, name := range []string{"20200101000000", "20200201000000", "unknown"} {
+		ioutil.WriteFile(fp.Join(snapshotDir, name), []byte("archive"), os.ModePerm)
+	}
+
+	tests := []struct {
+		name       string
+		id         string
+		wantStatus int
+		wantNames  []string
+	}{
+		{"with snapshots", "1", http.StatusOK, []string{"20200201000000", "20200101000000"}},
+		{"without snapshot", "2", http.StatusOK, []string{}},
+		{"missing bookmark", "3", http.StatusNotFound, nil},
+		{"invalid id", "abc", http.StatusBadRequest, nil},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			reqURL := "/api/bookmark/" + tt.id + "/snapshots"
+			router := httprouter.New()
+			router.GET(reqURL, func(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
+				hdl.apiGetSnapshots(w, r, httprouter.Params{{Key: "id", Value: tt.id}})
+			})
+			router.PanicHandler = hdl.handlePanic
+
+			rec := httptest.NewRecorder()
+			router.ServeHTTP(rec, newOwnerRequest("GET", reqURL, nil))
+
+			if rec.Code != tt.wantStatus {
+				t.Fatalf("apiGetSnapshots() status = %d, want %d", rec.Code, tt.wantStatus)
+			}
+
+			if tt.wantStatus != http.StatusOK {
+				return
+			}
+
+			snapshots := []core.ArchiveSnapshot{}
+			if err := json.NewDecoder(rec.Body).Decode(&snapshots); err != nil {
+				t.Fatal(err)
+			}
+
+			names := []string{}
+			for _, snapshot := range snapshots {
+				names = append(names, snapshot.Name)
+			}
+
+			if !reflect.DeepEqual(names, tt.wantNames) {
+				t.Errorf("apiGetSnapshots() = %v, want %v", names, tt.wantNames)
+			}
+		})
+	}
+}
+
+func Test_apiGetScreenshot(t *testing.T) {
+	hdl, cleanup := newTestHandler(t)
+	defer cleanup()
+
+	_, err := hdl.DB.SaveBookmarks(
+		model.Bookmark{ID: 1, URL: "https://example.com/1", Title: "1"},
+		model.Bookmark{ID: 2, URL: "https://example.com/2", Title: "2"},
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Only bookmark 1 has screenshot
+	screenshot := []byte("\x89PNG\r\n\x1a\nscreenshot")
+	screenshotPath := core.ScreenshotPath(hdl.DataDir, 1)
+	os.MkdirAll(fp.Dir(screenshotPath), os.ModePerm)
+	ioutil.WriteFile(screenshotPath, screenshot, os.ModePerm)
+
+	tests := []struct {
+		name       string
+		id         string
+		wantStatus int
+	}{
+		{"with screenshot", "1", http.StatusOK},
+		{"without screenshot", "2", http.StatusNotFound},
+		{"missing bookmark", "3", http.StatusNotFound},
+		{"invalid id", "abc", http.StatusBadRequest},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			reqURL := "/api/bookmark/" + tt.id + "/screenshot"
+			router := httprouter.New()
+			router.GET(reqURL, func(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
+				hdl.apiGetScreenshot(w, r, httprouter.Params{{Key: "id", Value: tt.id}})
+			})
+			router.PanicHandler = hdl.handlePanic
+
+			rec := httptest.NewRecorder()
+			router.ServeHTTP(rec, newOwnerRequest("GET", reqURL, nil))
+
+			if rec.Code != tt.wantStatus {
+				t.Fatalf("apiGetScreenshot() status = %d, want %d", rec.Code, tt.wantStatus)
+			}
+
+			if tt.wantStatus != http.StatusOK {
+				return
+			}
+
+			if contentType := rec.Header().Get("Content-Type"); contentType != "image/png" {
+				t.Errorf("apiGetScreenshot() content type = %q, want image/png", contentType)
+			}
+
+			if !bytes.Equal(rec.Body.Bytes(), screenshot) {
+				t.Errorf("apiGetScreenshot() = %q, want %q", rec.Body.Bytes(), screenshot)
+			}
+		})
+	}
+
+	// Screenshot is removed along with its bookmark
+	req := newOwnerRequest("DELETE", "/api/bookmarks", strings.NewReader("[1]"))
+	hdl.apiDeleteBookmark(httptest.NewRecorder(), req, nil)
+
+	if fileExists(screenshotPath) {
+		t.Errorf("apiDeleteBookmark() kept screenshot of deleted bookmark")
+	}
+}
+
+func Test_serveBookmarkScreenshot(t *testing.T) {
+	hdl, cleanup := newTestHandler(t)
+	defer cleanup()
+
+	_, err := hdl.DB.SaveBookmarks(
+		model.Bookmark{ID: 1, URL: "https://example.com/1", Title: "1"},
+		model.Bookmark{ID: 2, URL: "https://example.com/2", Title: "2"},
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	screenshot := []byte("\x89PNG\r\n\x1a\nscreenshot")
+	screenshotPath := core.ScreenshotPath(hdl.DataDir, 1)
+	os.MkdirAll(fp.Dir(screenshotPath), os.ModePerm)
+	ioutil.WriteFile(screenshotPath, screenshot, os.ModePerm)
+
+	tests := []struct {
+		id         string
+		wantStatus int
+	}{
+		{"1", http.StatusOK},
+		{"2", http.StatusNotFound},
+		{"3", http.StatusNotFound},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.id, func(t *testing.T) {
+			reqURL := "/bookmark/" + tt.id + "/screenshot"
+			router := httprouter.New()
+			router.GET(reqURL, func(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
+				hdl.serveBookmarkScreenshot(w, r, httprouter.Params{{Key: "id", Value: tt.id}})
+			})
+			router.PanicHandler = hdl.handlePanic
+
+			rec := httptest.NewRecorder()
+			router.ServeHTTP(rec, newOwnerRequest("GET", reqURL, nil))
+
+			if rec.Code != tt.wantStatus {
+				t.Fatalf("serveBookmarkScreenshot() status = %d, want %d", rec.Code, tt.wantStatus)
+			}
+
+			if tt.wantStatus == http.StatusOK && !bytes.Equal(rec.Body.Bytes(), screenshot) {
+				t.Errorf("serveBookmarkScreenshot() = %q, want %q", rec.Body.Bytes(), screenshot)
+			}
+		})
+	}
+
+	// Bookmarks tell whether they have screenshot
+	rec := httptest.NewRecorder()
+	hdl.apiGetBookmarks(rec, newOwnerRequest("GET", "/api/bookmarks", nil), nil)
+
+	resp := struct {
+		Bookmarks []model.Bookmark `json:"bookmarks"`
+	}{}
+	json.NewDecoder(rec.Body).Decode(&resp)
+
+	for _, book := range resp.Bookmarks {
+		if book.HasScreenshot != (book.ID == 1) {
+			t.Errorf("bookmark %d hasScreenshot = %v", book.ID, book.HasScreenshot)
+		}
+	}
+}
+
+func Test_serveBookmarkPDF(t *testing.T) {
+	hdl, cleanup := newTestHandler(t)
+	defer cleanup()
+
+	_, err := hdl.DB.SaveBookmarks(
+		model.Bookmark{ID: 1, URL: "https://example.com/1", Title: "1"},
+		model.Bookmark{ID: 2, URL: "https://example.com/2", Title: "2"},
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	pdf := []byte("%PDF-1.4 receipt")
+	pdfPath := core.PDFPath(hdl.DataDir, 1)
+	os.MkdirAll(fp.Dir(pdfPath), os.ModePerm)
+	ioutil.WriteFile(pdfPath, pdf, os.ModePerm)
+
+	tests := []struct {
+		id         string
+		wantStatus int
+	}{
+		{"1", http.StatusOK},
+		{"2", http.StatusNotFound},
+		{"3", http.StatusNotFound},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.id, func(t *testing.T) {
+			reqURL := "/bookmark/" + tt.id + "/pdf"
+			router := httprouter.New()
+			router.GET(reqURL, func(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
+				hdl.serveBookmarkPDF(w, r, httprouter.Params{{Key: "id", Value: tt.id}})
+			})
+			router.PanicHandler = hdl.handlePanic
+
+			rec := httptest.NewRecorder()
+			router.ServeHTTP(rec, newOwnerRequest("GET", reqURL, nil))
+
+			if rec.Code != tt.wantStatus {
+				t.Fatalf("serveBookmarkPDF() status = %d, want %d", rec.Code, tt.wantStatus)
+			}
+
+			if tt.wantStatus != http.StatusOK {
+				return
+			}
+
+			if contentType := rec.Header().Get("Content-Type"); contentType != "application/pdf" {
+				t.Errorf("serveBookmarkPDF() content type = %q, want application/pdf", contentType)
+			}
+
+			if !bytes.Equal(rec.Body.Bytes(), pdf) {
+				t.Errorf("serveBookmarkPDF() = %q, want %q", rec.Body.Bytes(), pdf)
+			}
+		})
+	}
+
+	// Bookmarks tell whether they have PDF
+	rec := httptest.NewRecorder()
+	hdl.apiGetBookmarks(rec, newOwnerRequest("GET", "/api/bookmarks", nil), nil)
+
+	resp := struct {
+		Bookmarks []model.Bookmark `json:"bookmarks"`
+	}{}
+	json.NewDecoder(rec.Body).Decode(&resp)
+
+	for _, book := range resp.Bookmarks {
+		if book.HasPDF != (book.ID == 1) {
+			t.Errorf("bookmark %d hasPDF = %v", book.ID, book.HasPDF)
+		}
+	}
+}
+
+func Test_serveBookmarkSingleFile(t *testing.T) {
+	hdl, cleanup := newTestHandler(t)
+	defer cleanup()
+
+	_, err := hdl.DB.SaveBookmarks(model.Bookmark{ID: 1, URL: "https://example.com/1", Title: "1"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	page := []byte(`<html><head><meta charset="utf-8"></head><body>archived</body></html>`)
+	pagePath := core.SingleFilePath(hdl.DataDir, 1)
+	os.MkdirAll(fp.Dir(pagePath), os.ModePerm)
+	ioutil.WriteFile(pagePath, page, os.ModePerm)
+
+	rec := httptest.NewRecorder()
+	req := newOwnerRequest("GET", "/bookmark/1/singlefile", nil)
+	hdl.serveBookmarkSingleFile(rec, req, httprouter.Params{{Key: "id", Value: "1"}})
+
+	if rec.Code != http.StatusOK || !bytes.Equal(rec.Body.Bytes(), page) {
+		t.Fatalf("serveBookmarkSingleFile() = %d, %q, want %q", rec.Code, rec.Body.Bytes(), page)
+	}
+
+	// The archived page is a page from other site, so it must not run in our origin
+	if csp := rec.Header().Get("Content-Security-Policy"); csp != "sandbox" {
+		t.Errorf("serveBookmarkSingleFile() CSP = %q, want sandbox", csp)
+	}
+}
+
+func Test_apiExportBookmarksCSV(t *testing.T) {
+	hdl, cleanup := newTestHandler(t)
+	defer cleanup()
+
+	_, err := hdl.DB.SaveBookmarks(
+		model.Bookmark{
+			ID:    1,
+			URL:   "https://example.com/1",
+			Title: `Title with "quotes", commas`,
+			Tags:  []model.Tag{{Name: "go"}, {Name: "web"}},
+		},
+		model.Bookmark{
+			ID:      2,
+			URL:     "https://example.com/2",
+			Title:   "Second",
+			Excerpt: "first, second",
+			Public:  1,
+		},
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name    string
+		query   string
+		wantIDs []string
+	}{
+		{"all bookmarks", "", []string{"2", "1"}},
+		{"filtered by tag", "?tags=go", []string{"1"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := newOwnerRequest("GET", "/api/bookmarks/export.csv"+tt.query, nil)
+			rec := httptest.NewRecorder()
+			hdl.apiExportBookmarksCSV(rec, req, nil)
+
+			if contentType := rec.Header().Get("Content-Type"); !strings.HasPrefix(contentType, "text/csv") {
+				t.Errorf("Content-Type = %q, want text/csv", contentType)
+			}
+
+			records, err := csv.NewReader(rec.Body).ReadAll()
+			if err != nil {
+				t.Fatalf("failed to parse CSV: %v", err)
+			}
+
+			if len(records) != len(tt.wantIDs)+1 {
+				t.Fatalf("got %d rows, want %d", len(records), len(tt.wantIDs)+1)
+			}
+
+			byID := map[string][]string{}
+			for i, id := range tt.wantIDs {
+				if records[i+1][0] != id {
+					t.Errorf("row %d has id %s, want %s", i+1, records[i+1][0], id)
+				}
+				byID[id] = records[i+1]
+			}
+
+			if row, ok := byID["1"]; ok {
+				if row[2] != `Title with "quotes", commas` || row[4] != "go;web" {
+					t.Errorf("bookmark 1 exported as %q", row)
+				}
+			}
+
+			if row, ok := byID["2"]; ok {
+				if row[3] != "first, second" || row[6] != "true" || row[7] != "false" {
+					t.Errorf("bookmark 2 exported as %q", row)
+				}
+			}
+		})
+	}
+}
+
+func Test_apiExportBookmarks(t *testing.T) {
+	hdl, cleanup := newTestHandler(t)
+	defer cleanup()
+
+	_, err := hdl.DB.SaveBookmarks(
+		model.Bookmark{
+			ID:      1,
+			URL:     "https://example.com/1",
+			Title:   `First & "one"`,
+			Created: "2017-07-14 02:40:00",
+			Tags:    []model.Tag{{Name: "go"}, {Name: "web"}},
+		},
+		model.Bookmark{
+			ID:      2,
+			URL:     "https://example.com/2",
+			Title:   "Second",
+			Created: "2017-07-14 02:40:00",
+		},
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name        string
+		query       string
+		wantStatus  int
+		wantType    string
+		wantURLs    []string
+		wantContent string
+	}{
+		{"netscape by default", "", http.StatusOK, "text/html", []string{"https://example.com/2", "https://example.com/1"}, ""},
+		{"filtered by tag", "?format=netscape&tags=go", http.StatusOK, "text/html", []string{"https://example.com/1"}, ""},
+		{"csv", "?format=csv", http.StatusOK, "text/csv", nil, "https://example.com/1"},
+		{"pinboard", "?format=pinboard", http.StatusOK, "application/json", nil, `"href":"https://example.com/1"`},
+		{"unknown format", "?format=xbel", http.StatusBadRequest, "", nil, ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := newOwnerRequest("GET", "/api/export"+tt.query, nil)
+			rec := httptest.NewRecorder()
+			hdl.apiExportBookmarks(rec, req, nil)
+
+			if rec.Code != tt.wantStatus {
+				t.Fatalf("status = %d, want %d", rec.Code, tt.wantStatus)
+			}
+
+			if contentType := rec.Header().Get("Content-Type"); !strings.HasPrefix(contentType, tt.wantType) {
+				t.Errorf("Content-Type = %q, want %s", contentType, tt.wantType)
+			}
+
+			if !strings.Contains(rec.Body.String(), tt.wantContent) {
+				t.Errorf("body = %s, want it to contain %s", rec.Body.String(), tt.wantContent)
+			}
+
+			if tt.wantURLs == nil {
+				return
+			}
+
+			got := []string{}
+			err := core.ParseNetscapeBookmarks(rec.Body, func(book core.NetscapeBookmark) error {
+				got = append(got, fmt.Sprintf("%s %s [%s] %d", book.URL, book.Title, strings.Join(book.Tags, ","), book.AddDate))
+				return nil
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			want := []string{}
+			for _, url := range tt.wantURLs {
+				if url == "https://example.com/1" {
+					want = append(want, url+` First & "one" [go,web] 1500000000`)
+				} else {
+					want = append(want, url+" Second [] 1500000000")
+				}
+			}
+
+			if !reflect.DeepEqual(got, want) {
+				t.Errorf("exported bookmarks = %q, want %q", got, want)
+			}
+		})
+	}
+}
+
+func Test_apiGetTags(t *testing.T) {
+	hdl, cleanup := newTestHandler(t)
+	defer cleanup()
+
+	_, err := hdl.DB.SaveBookmarks(
+		model.Bookmark{ID: 1, URL: "https://example.com/1", Title: "One", Created: "2020-01-01 00:00:00",
+			Tags: []model.Tag{{Name: "go"}}},
+		model.Bookmark{ID: 2, URL: "https://example.com/2", Title: "Two", Created: "2020-02-01 00:00:00",
+			Tags: []model.Tag{{Name: "web"}, {Name: "stale"}}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Remove tag from bookmark, so it's no longer used
+	bookmarks, err := hdl.DB.GetBookmarks(database.GetBookmarksOptions{IDs: []int{2}})
+	if err != nil || len(bookmarks) != 1 {
+		t.Fatalf("GetBookmarks() = %v, %v", bookmarks, err)
+	}
+
+	book := bookmarks[0]
+	for i := range book.Tags {
+		book.Tags[i].Deleted = book.Tags[i].Name == "stale"
+	}
+
+	if _, err = hdl.DB.SaveBookmarks(book); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name       string
+		query      string
+		wantStatus int
+		want       []string
+	}{
+		{"by name", "", http.StatusOK, []string{"go", "web"}},
+		{"by last used", "order=lastUsed", http.StatusOK, []string{"web", "go"}},
+		{"with unused", "order=lastUsed&unused=true", http.StatusOK, []string{"web", "go", "stale"}},
+		{"invalid order", "order=count", http.StatusBadRequest, nil},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rec := httptest.NewRecorder()
+			req := newOwnerRequest("GET", "/api/tags?"+tt.query, nil)
+			hdl.apiGetTags(rec, req, nil)
+
+			if rec.Code != tt.wantStatus {
+				t.Fatalf("apiGetTags() status = %d, want %d", rec.Code, tt.wantStatus)
+			}
+
+			if tt.wantStatus != http.StatusOK {
+				return
+			}
+
+			tags := []model.Tag{}
+			if err := json.NewDecoder(rec.Body).Decode(&tags); err != nil {
+				t.Fatal(err)
+			}
+
+			got := []string{}
+			for _, tag := range tags {
+				got = append(got, tag.Name)
+			}
+
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("apiGetTags() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func Test_apiReplaceTagNames(t *testing.T) {
+	tests := []struct {
+		name        string
+		query       string
+		body        string
+		wantStatus  int
+		wantChanges []string
+		wantTags    []string
+	}{
+		{
+			name:        "substring",
+			body:        `{"find": "javasript", "replace": "javascript"}`,
+			wantStatus:  http.StatusOK,
+			wantChanges: []string{"javasript/node", "javasript/web"},
+			wantTags:    []string{"javascript/node:2", "javascript/web:1"},
+		},
+		{
+			name:        "regex",
+			body:        `{"find": "^javasript/(.*)$", "replace": "js/$1", "regex": true}`,
+			wantStatus:  http.StatusOK,
+			wantChanges: []string{"javasript/node", "javasript/web"},
+			wantTags:    []string{"javascript/node:1", "js/node:1", "js/web:1"},
+		},
+		{
+			name:        "dry run",
+			query:       "?dryRun=true",
+			body:        `{"find": "javasript", "replace": "javascript"}`,
+			wantStatus:  http.StatusOK,
+			wantChanges: []string{"javasript/node", "javasript/web"},
+			wantTags:    []string{"javascript/node:1", "javasript/node:1", "javasript/web:1"},
+		},
+		{
+			name:       "invalid regex",
+			body:       `{"find": "(", "regex": true}`,
+			wantStatus: http.StatusBadRequest,
+			wantTags:   []string{"javascript/node:1", "javasript/node:1", "javasript/web:1"},
+		},
+		{
+			name:       "empty name",
+			body:       `{"find": "javascript/node"}`,
+			wantStatus: http.StatusBadRequest,
+			wantTags:   []string{"javascript/node:1", "javasript/node:1", "javasript/web:1"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			hdl, cleanup := newTestHandler(t)
+			defer cleanup()
+
+			_, err := hdl.DB.SaveBookmarks(
+				model.Bookmark{ID: 1, URL: "https://example.com/1", Title: "One",
+					Tags: []model.Tag{{Name: "javasript/web"}, {Name: "javasript/node"}}},
+				model.Bookmark{ID: 2, URL: "https://example.com/2", Title: "Two",
+					Tags: []model.Tag{{Name: "javascript/node"}}})
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			req := newOwnerRequest("PUT", "/api/tags/replace"+tt.query, strings.NewReader(tt.body))
+			rec := httptest.NewRecorder()
+			hdl.apiReplaceTagNames(rec, req, nil)
+
+			if rec.Code != tt.wantStatus {
+				t.Fatalf("apiReplaceTagNames() status = %d, want %d", rec.Code, tt.wantStatus)
+			}
+
+			if tt.wantStatus == http.StatusOK {
+				resp := struct {
+					Changes []struct {
+						Name string `json:"name"`
+					} `json:"changes"`
+				}{}
+				json.NewDecoder(rec.Body).Decode(&resp)
+
+				gotChanges := []string{}
+				for _, change := range resp.Changes {
+					gotChanges = append(gotChanges, change.Name)
+				}
+
+				if strings.Join(gotChanges, ",") != strings.Join(tt.wantChanges, ",") {
+					t.Errorf("apiReplaceTagNames() changes = %v, want %v", gotChanges, tt.wantChanges)
+				}
+			}
+
+			allTags, _ := hdl.DB.GetTags(database.GetTagsOptions{})
+			gotTags := []string{}
+			for _, tag := range allTags {
+				gotTags = append(gotTags, fmt.Sprintf("%s:%d", tag.Name, tag.NBookmarks))
+			}
+
+			if strings.Join(gotTags, ",") != strings.Join(tt.wantTags, ",") {
+				t.Errorf("tags after apiReplaceTagNames() = %v, want %v", gotTags, tt.wantTags)
+			}
+		})
+	}
+}
+
+func Test_apiGetBookmarksTagFilter(t *testing.T) {
+	hdl, cleanup := newTestHandler(t)
+	defer cleanup()
+
+	_, err := hdl.DB.SaveBookmarks(
+		model.Bookmark{ID: 1, URL: "https://example.com/1", Title: "One",
+			Tags: []model.Tag{{Name: "a"}, {Name: "b"}}},
+		model.Bookmark{ID: 2, URL: "https://example.com/2", Title: "Two",
+			Tags: []model.Tag{{Name: "a"}}},
+		model.Bookmark{ID: 3, URL: "https://example.com/3", Title: "Three"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name    string
+		query   string
+		wantIDs []int
+	}{
+		{"included tags", "tags=a,b", []int{1}},
+		{"trailing comma", "tags=a,", []int{2, 1}},
+		{"duplicate tag", "tags=a,a", []int{2, 1}},
+		{"excluded tag", "exclude=b,", []int{3, 2}},
+		{"exclude wins over include", "tags=a,b&exclude=b", []int{}},
+		{"exclude wins over any tag", "tags=*&exclude=b", []int{2}},
+		{"exclude any tag", "tags=a&exclude=*", []int{}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := newOwnerRequest("GET", "/api/bookmarks?"+tt.query, nil)
+			rec := httptest.NewRecorder()
+			hdl.apiGetBookmarks(rec, req, nil)
+
+			resp := struct {
+				Bookmarks []model.Bookmark `json:"bookmarks"`
+			}{}
+			json.NewDecoder(rec.Body).Decode(&resp)
+
+			gotIDs := []int{}
+			for _, book := range resp.Bookmarks {
+				gotIDs = append(gotIDs, book.ID)
+			}
+
+			if fmt.Sprint(gotIDs) != fmt.Sprint(tt.wantIDs) {
+				t.Errorf("apiGetBookmarks() returns %v, want %v", gotIDs, tt.wantIDs)
+			}
+		})
+	}
+}
+
+func Test_apiGetBookmarksFields(t *testing.T) {
+	hdl, cleanup := newTestHandler(t)
+	defer cleanup()
+
+	_, err := hdl.DB.SaveBookmarks(model.Bookmark{
+		ID:      1,
+		URL:     "https://example.com/1",
+		Title:   "Example",
+		Excerpt: "Long excerpt",
+		Tags:    []model.Tag{{Name: "go"}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name        string
+		query       string
+		wantKeys    []string
+		wantWarning bool
+	}{
+		{"compact", "fields=compact", []string{"id", "imageURL", "title", "url"}, false},
+		{"explicit", "fields=id,title", []string{"id", "title"}, false},
+		{"unknown ignored", "fields=id,secret", []string{"id"}, true},
+		{"any field of model", "fields=id,read,starred", []string{"id", "read", "starred"}, false},
+		{"field not in JSON", "fields=id,content,Content", []string{"id"}, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := newOwnerRequest("GET", "/api/bookmarks?"+tt.query, nil)
+			rec := httptest.NewRecorder()
+			hdl.apiGetBookmarks(rec, req, nil)
+
+			if rec.Code != http.StatusOK {
+				t.Fatalf("apiGetBookmarks() status = %d, want %d", rec.Code, http.StatusOK)
+			}
+
+			if hasWarning := rec.Header().Get("Warning") != ""; hasWarning != tt.wantWarning {
+				t.Errorf("apiGetBookmarks() has warning = %v, want %v", hasWarning, tt.wantWarning)
+			}
+
+			resp := struct {
+				Bookmarks []map[string]interface{} `json:"bookmarks"`
+			}{}
+			json.NewDecoder(rec.Body).Decode(&resp)
+
+			if len(resp.Bookmarks) != 1 {
+				t.Fatalf("apiGetBookmarks() returns %d bookmarks, want 1", len(resp.Bookmarks))
+			}
+
+			keys := []string{}
+			for key := range resp.Bookmarks[0] {
+				keys = append(keys, key)
+			}
+			sort.Strings(keys)
+
+			if !reflect.DeepEqual(keys, tt.wantKeys) {
+				t.Errorf("apiGetBookmarks() fields = %v, want %v", keys, tt.wantKeys)
+			}
+		})
+	}
+
+	// Without fields, the full representation is returned
+	req := newOwnerRequest("GET", "/api/bookmarks", nil)
+	rec := httptest.NewRecorder()
+	hdl.apiGetBookmarks(rec, req, nil)
+
+	resp := struct {
+		Bookmarks []model.Bookmark `json:"bookmarks"`
+	}{}
+	json.NewDecoder(rec.Body).Decode(&resp)
+
+	if len(resp.Bookmarks) != 1 || resp.Bookmarks[0].Excerpt != "Long excerpt" || len(resp.Bookmarks[0].Tags) != 1 {
+		t.Errorf("apiGetBookmarks() without fields = %+v, want full bookmark", resp.Bookmarks)
+	}
+}
+
+func Test_apiGetBookmarksPerPage(t *testing.T) {
+	hdl, cleanup := newTestHandler(t)
+	defer cleanup()
+	hdl.MaxPageSize = 3
+
+	for i := 1; i <= 5; i++ {
+		book := model.Bookmark{ID: i, URL: fmt.Sprintf("https://example.com/%d", i), Title: "Example"}
+		if _, err := hdl.DB.SaveBookmarks(book); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	tests := []struct {
+		name        string
+		query       string
+		wantStatus  int
+		wantCount   int
+		wantMaxPage int
+	}{
+		{"default", "", http.StatusOK, 5, 1},
+		{"smaller page", "per_page=2", http.StatusOK, 2, 3},
+		{"last page", "per_page=2&page=3", http.StatusOK, 1, 3},
+		{"capped at max", "per_page=10", http.StatusOK, 3, 2},
+		{"zero", "per_page=0", http.StatusBadRequest, 0, 0},
+		{"garbage", "per_page=all", http.StatusBadRequest, 0, 0},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := newOwnerRequest("GET", "/api/bookmarks?"+tt.query, nil)
+			rec := httptest.NewRecorder()
+			hdl.apiGetBookmarks(rec, req, nil)
+
+			if rec.Code != tt.wantStatus {
+				t.Fatalf("apiGetBookmarks() status = %d, want %d", rec.Code, tt.wantStatus)
+			}
+
+			if tt.wantStatus != http.StatusOK {
+				return
+			}
+
+			resp := struct {
+				MaxPage   int              `json:"maxPage"`
+				Bookmarks []model.Bookmark `json:"bookmarks"`
+			}{}
+			json.NewDecoder(rec.Body).Decode(&resp)
+
+			if len(resp.Bookmarks) != tt.wantCount || resp.MaxPage != tt.wantMaxPage {
+				t.Errorf("apiGetBookmarks() returns %d bookmarks in %d pages, want %d in %d pages",
+					len(resp.Bookmarks), resp.MaxPage, tt.wantCount, tt.wantMaxPage)
+			}
+		})
+	}
+}
+
+func Test_apiGetBookmarksRecentDays(t *testing.T) {
+	hdl, cleanup := newTestHandler(t)
+	defer cleanup()
+
+	daysAgo := func(n int) string {
+		return time.Now().UTC().AddDate(0, 0, -n).Format("2006-01-02 15:04:05")
+	}
+
+	_, err := hdl.DB.SaveBookmarks(
+		model.Bookmark{ID: 1, URL: "https://example.com/1", Title: "Old", Created: daysAgo(30)},
+		model.Bookmark{ID: 2, URL: "https://example.com/2", Title: "Recent", Created: daysAgo(3),
+			Tags: []model.Tag{{Name: "go"}}},
+		model.Bookmark{ID: 3, URL: "https://example.com/3", Title: "Today", Created: daysAgo(0)})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name       string
+		query      string
+		wantStatus int
+		wantIDs    []int
+	}{
+		{"last week", "recentDays=7", http.StatusOK, []int{3, 2}},
+		{"last day", "recentDays=1", http.StatusOK, []int{3}},
+		{"combined with tags", "recentDays=7&tags=go", http.StatusOK, []int{2}},
+		{"combined with keyword", "recentDays=7&keyword=today", http.StatusOK, []int{3}},
+		{"zero", "recentDays=0", http.StatusBadRequest, nil},
+		{"negative", "recentDays=-7", http.StatusBadRequest, nil},
+		{"garbage", "recentDays=week", http.StatusBadRequest, nil},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := newOwnerRequest("GET", "/api/bookmarks?"+tt.query, nil)
+			rec := httptest.NewRecorder()
+			hdl.apiGetBookmarks(rec, req, nil)
+
+			if rec.Code != tt.wantStatus {
+				t.Fatalf("apiGetBookmarks() status = %d, want %d", rec.Code, tt.wantStatus)
+			}
+
+			if tt.wantStatus != http.StatusOK {
+				return
+			}
+
+			resp := struct {
+				Bookmarks []model.Bookmark `json:"bookmarks"`
+			}{}
+			json.NewDecoder(rec.Body).Decode(&resp)
+
+			gotIDs := []int{}
+			for _, book := range resp.Bookmarks {
+				gotIDs = append(gotIDs, book.ID)
+			}
+
+			if fmt.Sprint(gotIDs) != fmt.Sprint(tt.wantIDs) {
+				t.Errorf("apiGetBookmarks() returns %v, want %v", gotIDs, tt.wantIDs)
+			}
+		})
+	}
+}
+
+func Test_apiUpdateCacheRefreshResult(t *testing.T) {
+	hdl, cleanup := newTestHandler(t)
+	defer cleanup()
+	hdl.Concurrency = 1
+	hdl.ArchiveLimit = 5
+
+	page := "<html><body><p>Hello</p></body></html>"
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/plain")
+		w.Write([]byte(page))
+	}))
+	defer server.Close()
+
+	_, err := hdl.DB.SaveBookmarks(
+		model.Bookmark{ID: 1, URL: server.URL + "/same", Title: "Same", ContentHash: core.ContentHash([]byte(page))},
+		model.Bookmark{ID: 2, URL: server.URL + "/changed", Title: "Changed", ContentHash: core.ContentHash([]byte("old"))},
+		model.Bookmark{ID: 3, URL: "http://127.0.0.1:1/unreachable", Title: "Unreachable"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	body := `{"ids": [1, 2, 3]}`
+	req := newOwnerRequest("PUT", "/api/cache", strings.NewReader(body))
+	rec := httptest.NewRecorder()
+	hdl.apiUpdateCache(rec, req, nil)
+
+	bookmarks := []model.Bookmark{}
+	if err := json.NewDecoder(rec.Body).Decode(&bookmarks); err != nil {
+		t.Fatalf("failed to decode response: %v", err)
+	}
+
+	tests := []struct {
+		id          int
+		wantResult  string
+		wantVersion int
+	}{
+		{1, "unchanged", 1},
+		{2, "refreshed", 2},
+		{3, "failed", 1},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.wantResult, func(t *testing.T) {
+			var got model.Bookmark
+			for _, book := range bookmarks {
+				if book.ID == tt.id {
+					got = book
+				}
+			}
+
+			if got.RefreshResult != tt.wantResult {
+				t.Errorf("bookmark %d refreshResult = %q, want %q", tt.id, got.RefreshResult, tt.wantResult)
+			}
+
+			saved, _ := hdl.DB.GetBookmark(tt.id, "")
+			if saved.Version != tt.wantVersion {
+				t.Errorf("bookmark %d saved version = %d, want %d", tt.id, saved.Version, tt.wantVersion)
+			}
+		})
+	}
+}
+
+func Test_apiUpdateBookmarkTagDefaultPublic(t *testing.T) {
+	tests := []struct {
+		name       string
+		oldPublic  int
+		body       string
+		wantPublic int
+	}{
+		{"add public tag", 0, `{"tags": [{"name": "public-blog"}]}`, 1},
+		{"add private tag", 1, `{"tags": [{"name": "secret"}]}`, 0},
+		{"add unrelated tag", 1, `{"tags": [{"name": "golang"}]}`, 1},
+		{"keep existing public tag", 0, `{"tags": [{"name": "draft"}, {"name": "golang"}]}`, 0},
+		{"private wins over public", 0, `{"tags": [{"name": "public-blog"}, {"name": "secret"}]}`, 0},
+		{"explicit visibility wins", 0, `{"public": 0, "tags": [{"name": "public-blog"}]}`, 0},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			hdl, cleanup := newTestHandler(t)
+			defer cleanup()
+
+			// Tag "draft" is already on the bookmark before its default visibility is set
+			_, err := hdl.DB.SaveBookmarks(
+				model.Bookmark{ID: 1, URL: "https://example.com/1", Title: "One", Public: tt.oldPublic,
+					Tags: []model.Tag{{Name: "draft"}}},
+				model.Bookmark{ID: 2, URL: "https://example.com/2", Title: "Two",
+					Tags: []model.Tag{{Name: "public-blog"}, {Name: "secret"}}})
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			tags, err := hdl.DB.GetTags(database.GetTagsOptions{})
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			for _, tag := range tags {
+				var body string
+				switch tag.Name {
+				case "public-blog", "draft":
+					body = fmt.Sprintf(`{"id": %d, "defaultPublic": 1}`, tag.ID)
+				case "secret":
+					body = fmt.Sprintf(`{"id": %d, "defaultPublic": 0}`, tag.ID)
+				default:
+					continue
+				}
+
+				rec := httptest.NewRecorder()
+				req := newOwnerRequest("PUT", "/api/tags/visibility", strings.NewReader(body))
+				hdl.apiSetTagDefaultPublic(rec, req, nil)
+				if rec.Code != http.StatusOK {
+					t.Fatalf("apiSetTagDefaultPublic() status = %d: %s", rec.Code, rec.Body)
+				}
+			}
+
+			rec := httptest.NewRecorder()
+			req := newOwnerRequest("PATCH", "/api/bookmarks/1", strings.NewReader(tt.body))
+			hdl.apiPatchBookmark(rec, req, httprouter.Params{{Key: "id", Value: "1"}})
+			if rec.Code != http.StatusOK {
+				t.Fatalf("apiPatchBookmark() status = %d: %s", rec.Code, rec.Body)
+			}
+
+			saved, _ := hdl.DB.GetBookmark(1, "")
+			if saved.Public != tt.wantPublic {
+				t.Errorf("saved bookmark public = %d, want %d", saved.Public, tt.wantPublic)
+			}
+		})
+	}
+}
+
+func Test_apiUpdateBookmarkDuplicateTags(t *testing.T) {
+	tests := []struct {
+		name   string
+		method string
+		body   string
+		update func(hdl *handler, w http.ResponseWriter, r *http.Request)
+	}{
+		{"update bookmark", "PUT",
+			`{"id": 1, "url": "https://example.com", "title": "One", "version": 1,
+			"tags": [{"name": "go"}, {"name": "Go "}, {"name": "web"}, {"id": 99, "name": "go"}]}`,
+			func(hdl *handler, w http.ResponseWriter, r *http.Request) { hdl.apiUpdateBookmark(w, r, nil) }},
+		{"add tags", "PUT",
+			`{"ids": [1], "tags": [{"name": "go"}, {"name": "go"}, {"name": " WEB"}, {"name": "web"}]}`,
+			func(hdl *handler, w http.ResponseWriter, r *http.Request) { hdl.apiUpdateBookmarkTags(w, r, nil) }},
+		{"replace tags", "PUT",
+			`{"ids": [1], "mode": "replace", "tags": [{"name": "go"}, {"name": "GO"}, {"name": "web"}]}`,
+			func(hdl *handler, w http.ResponseWriter, r *http.Request) { hdl.apiUpdateBookmarkTags(w, r, nil) }},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			hdl, cleanup := newTestHandler(t)
+			defer cleanup()
+
+			_, err := hdl.DB.SaveBookmarks(model.Bookmark{ID: 1, URL: "https://example.com", Title: "One"})
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			rec := httptest.NewRecorder()
+			req := newOwnerRequest(tt.method, "/api/bookmarks", strings.NewReader(tt.body))
+			tt.update(hdl, rec, req)
+			if rec.Code != http.StatusOK {
+				t.Fatalf("status = %d: %s", rec.Code, rec.Body)
+			}
+
+			// The returned bookmark has the same tags as the saved one
+			if n := strings.Count(rec.Body.String(), `"name":"go"`); n != 1 {
+				t.Errorf("returned tag go %d times, want once: %s", n, rec.Body)
+			}
+
+			saved, err := hdl.DB.GetBookmarks(database.GetBookmarksOptions{IDs: []int{1}})
+			if err != nil || len(saved) != 1 {
+				t.Fatalf("GetBookmarks() = %v, %v", saved, err)
+			}
+
+			names := []string{}
+			for _, tag := range saved[0].Tags {
+				names = append(names, tag.Name)
+			}
+			sort.Strings(names)
+
+			if !reflect.DeepEqual(names, []string{"go", "web"}) {
+				t.Errorf("saved tags = %v, want [go web]", names)
+			}
+		})
+	}
+}
+
+func Test_apiUpdateBookmarkTagsDefaultPublic(t *testing.T) {
+	hdl, cleanup := newTestHandler(t)
+	defer cleanup()
+
+	_, err := hdl.DB.SaveBookmarks(
+		model.Bookmark{ID: 1, URL: "https://example.com/1", Title: "One", Tags: []model.Tag{{Name: "public-blog"}}},
+		model.Bookmark{ID: 2, URL: "https://example.com/2", Title: "Two"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tags, err := hdl.DB.GetTags(database.GetTagsOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	public := 1
+	if err = hdl.DB.SetTagDefaultPublic(tags[0].ID, &public); err != nil {
+		t.Fatal(err)
+	}
+
+	body := `{"ids": [1, 2], "tags": [{"name": "public-blog"}]}`
+	rec := httptest.NewRecorder()
+	req := newOwnerRequest("PUT", "/api/bookmarks/tags", strings.NewReader(body))
+	hdl.apiUpdateBookmarkTags(rec, req, nil)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("apiUpdateBookmarkTags() status = %d: %s", rec.Code, rec.Body)
+	}
+
+	// Bookmark 1 already has the tag, so only bookmark 2 is published
+	for id, wantPublic := range map[int]int{1: 0, 2: 1} {
+		saved, _ := hdl.DB.GetBookmark(id, "")
+		if saved.Public != wantPublic {
+			t.Errorf("bookmark %d public = %d, want %d", id, saved.Public, wantPublic)
+		}
+	}
+}
+
+func Test_setBookmarkTags(t *testing.T) {
+	tests := []struct {
+		name        string
+		mode        string
+		tags        []string
+		wantTags    []string
+		wantChanged bool
+	}{
+		{"add new tag", tagModeAdd, []string{"Go Lang"}, []string{"go", "web", "go lang"}, true},
+		{"add existing tag", tagModeAdd, []string{"GO"}, []string{"go", "web"}, false},
+		{"remove tag", tagModeRemove, []string{"web", "rust"}, []string{"go"}, true},
+		{"remove missing tag", tagModeRemove, []string{"rust"}, []string{"go", "web"}, false},
+		{"replace tags", tagModeReplace, []string{"go", "rust"}, []string{"go", "rust"}, true},
+		{"replace with same tags", tagModeReplace, []string{"web", "go"}, []string{"go", "web"}, false},
+		{"replace with nothing", tagModeReplace, nil, []string{}, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			book := model.Bookmark{Tags: []model.Tag{{ID: 1, Name: "go"}, {ID: 2, Name: "web"}}}
+
+			tags := []model.Tag{}
+			for _, name := range tt.tags {
+				tags = append(tags, model.Tag{Name: name})
+			}
+
+			changed := setBookmarkTags(&book, tags, tt.mode)
+			if changed != tt.wantChanged {
+				t.Errorf("setBookmarkTags() = %v, want %v", changed, tt.wantChanged)
+			}
+
+			gotTags := []string{}
+			for _, tag := range book.Tags {
+				if !tag.Deleted {
+					gotTags = append(gotTags, tag.Name)
+				}
+			}
+
+			if !reflect.DeepEqual(gotTags, tt.wantTags) {
+				t.Errorf("setBookmarkTags() tags = %v, want %v", gotTags, tt.wantTags)
+			}
+		})
+	}
+}
+
+func Test_apiUpdateBookmarkTagsByFilter(t *testing.T) {
+	tests := []struct {
+		name       string
+		query      string
+		body       string
+		wantStatus int
+		wantResp   map[string]int
+		wantTagged []int
+	}{
+		{"not confirmed", "tags=go", `{"tags": [{"name": "done"}]}`, http.StatusBadRequest, nil, []int{}},
+		{"invalid mode", "tags=go&confirm=true", `{"tags": [{"name": "done"}], "mode": "merge"}`, http.StatusBadRequest, nil, []int{}},
+		{"invalid filter", "order=random&confirm=true", `{"tags": [{"name": "done"}]}`, http.StatusBadRequest, nil, []int{}},
+		{"dry run", "tags=go&dryRun=true", `{"tags": [{"name": "done"}]}`, http.StatusOK,
+			map[string]int{"matched": 2, "updated": 2}, []int{}},
+		{"add by tag", "tags=go&confirm=true", `{"tags": [{"name": "done"}]}`, http.StatusOK,
+			map[string]int{"matched": 2, "updated": 2}, []int{1, 2}},
+		{"add with exclude", "tags=go&exclude=web&confirm=true", `{"tags": [{"name": "done"}]}`, http.StatusOK,
+			map[string]int{"matched": 1, "updated": 1}, []int{1}},
+		{"add to every bookmark", "confirm=true", `{"tags": [{"name": "done"}]}`, http.StatusOK,
+			map[string]int{"matched": 3, "updated": 3}, []int{1, 2, 3}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			hdl, cleanup := newTestHandler(t)
+			defer cleanup()
+
+			_, err := hdl.DB.SaveBookmarks(
+				model.Bookmark{ID: 1, URL: "https://example.com/1", Title: "One", Tags: []model.Tag{{Name: "go"}}},
+				model.Bookmark{ID: 2, URL: "https://example.com/2", Title: "Two", Tags: []model.Tag{{Name: "go"}, {Name: "web"}}},
+				model.Bookmark{ID: 3, URL: "https://example.com/3", Title: "Three"})
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			router := httprouter.New()
+			router.PUT("/api/bookmarks/tags/filter", hdl.apiUpdateBookmarkTagsByFilter)
+			router.PanicHandler = hdl.handlePanic
+
+			rec := httptest.NewRecorder()
+			req := newOwnerRequest("PUT", "/api/bookmarks/tags/filter?"+tt.query, strings.NewReader(tt.body))
+			router.ServeHTTP(rec, req)
+
+			if rec.Code != tt.wantStatus {
+				t.Fatalf("apiUpdateBookmarkTagsByFilter() status = %d, want %d: %s", rec.Code, tt.wantStatus, rec.Body)
+			}
+
+			if tt.wantResp != nil {
+				resp := map[string]int{}
+				json.NewDecoder(rec.Body).Decode(&resp)
+				if !reflect.DeepEqual(resp, tt.wantResp) {
+					t.Errorf("apiUpdateBookmarkTagsByFilter() = %v, want %v", resp, tt.wantResp)
+				}
+			}
+
+			tagged, _ := hdl.DB.GetBookmarks(database.GetBookmarksOptions{Tags: []string{"done"}})
+			gotTagged := []int{}
+			for _, book := range tagged {
+				gotTagged = append(gotTagged, book.ID)
+			}
+			sort.Ints(gotTagged)
+
+			if !reflect.DeepEqual(gotTagged, tt.wantTagged) {
+				t.Errorf("tagged bookmarks = %v, want %v", gotTagged, tt.wantTagged)
+			}
+		})
+	}
+}
+
+func Test_apiUpdateBookmarkTagsByFilterContent(t *testing.T) {
+	hdl, cleanup := newTestHandler(t)
+	defer cleanup()
+
+	bookmarks := []model.Bookmark{}
+	for i := 1; i <= 3; i++ {
+		bookmarks = append(bookmarks, model.Bookmark{
+			ID:      i,
+			URL:     fmt.Sprintf("https://example.com/%d", i),
+			Title:   fmt.Sprintf("Bookmark %d", i),
+			Content: fmt.Sprintf("Content %d", i),
+			HTML:    fmt.Sprintf("<p>Content %d</p>", i),
+			Tags:    []model.Tag{{Name: "inbox"}},
+		})
+	}
+
+	if _, err := hdl.DB.SaveBookmarks(bookmarks...); err != nil {
+		t.Fatal(err)
+	}
+
+	// Every bookmark is updated, while its content is kept
+	body := `{"tags": [{"name": "inbox"}], "mode": "remove"}`
+	rec := httptest.NewRecorder()
+	req := newOwnerRequest("PUT", "/api/bookmarks/tags/filter?tags=inbox&confirm=true", strings.NewReader(body))
+	hdl.apiUpdateBookmarkTagsByFilter(rec, req, nil)
+
+	if rec.Code != http.StatusOK {
+		t.Fatalf("apiUpdateBookmarkTagsByFilter() status = %d: %s", rec.Code, rec.Body)
+	}
+
+	remaining, _ := hdl.DB.GetBookmarks(database.GetBookmarksOptions{Tags: []string{"inbox"}})
+	if len(remaining) != 0 {
+		t.Errorf("%d bookmarks still have the removed tag", len(remaining))
+	}
+
+	book, _ := hdl.DB.GetBookmark(2, "")
+	if book.Content != "Content 2" || book.HTML != "<p>Content 2</p>" {
+		t.Errorf("content after update = %q, %q, want it kept", book.Content, book.HTML)
+	}
+}
+
+func Test_apiGetThumbnails(t *testing.T) {
+	hdl, cleanup := newTestHandler(t)
+	defer cleanup()
+
+	thumbDir := fp.Join(hdl.DataDir, "thumb")
+	if err := os.MkdirAll(thumbDir, os.ModePerm); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, id := range []string{"1", "3"} {
+		err := ioutil.WriteFile(fp.Join(thumbDir, id), []byte("\x89PNG\r\n\x1a\nthumb "+id), 0644)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	tests := []struct {
+		name       string
+		ids        string
+		wantStatus int
+		wantIDs    []string
+	}{
+		{"missing ids", "", http.StatusBadRequest, nil},
+		{"invalid id", "1,a", http.StatusBadRequest, nil},
+		{"omit bookmark without thumbnail", "3,2,1", http.StatusOK, []string{"3", "1"}},
+		{"no thumbnail at all", "2", http.StatusOK, []string{}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rec := httptest.NewRecorder()
+			req := newOwnerRequest("GET", "/api/bookmarks/thumbs?ids="+tt.ids, nil)
+			hdl.apiGetThumbnails(rec, req, nil)
+
+			if rec.Code != tt.wantStatus {
+				t.Fatalf("apiGetThumbnails() status = %d, want %d: %s", rec.Code, tt.wantStatus, rec.Body)
+			}
+
+			if tt.wantStatus != http.StatusOK {
+				return
+			}
+
+			_, params, err := mime.ParseMediaType(rec.Header().Get("Content-Type"))
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			gotIDs := []string{}
+			mr := multipart.NewReader(rec.Body, params["boundary"])
+			for {
+				part, err := mr.NextPart()
+				if err == io.EOF {
+					break
+				} else if err != nil {
+					t.Fatal(err)
+				}
+
+				id := part.Header.Get("Content-ID")
+				content, _ := ioutil.ReadAll(part)
+				if !strings.HasSuffix(string(content), "thumb "+id) {
+					t.Errorf("thumbnail %s content = %q", id, content)
+				}
+
+				if contentType := part.Header.Get("Content-Type"); contentType != "image/png" {
+					t.Errorf("thumbnail %s content type = %q, want image/png", id, contentType)
+				}
+
+				gotIDs = append(gotIDs, id)
+			}
+
+			if !reflect.DeepEqual(gotIDs, tt.wantIDs) {
+				t.Errorf("apiGetThumbnails() ids = %v, want %v", gotIDs, tt.wantIDs)
+			}
+		})
+	}
+}
+
+func Test_apiGetBookmarksManualOrder(t *testing.T) {
+	hdl, cleanup := newTestHandler(t)
+	defer cleanup()
+
+	daysAgo := func(n int) string {
+		return time.Now().UTC().AddDate(0, 0, -n).Format("2006-01-02 15:04:05")
+	}
+
+	readingList := []model.Tag{{Name: "reading-list"}}
+	_, err := hdl.DB.SaveBookmarks(
+		model.Bookmark{ID: 1, URL: "https://example.com/1", Title: "One", Created: daysAgo(4), Tags: readingList},
+		model.Bookmark{ID: 2, URL: "https://example.com/2", Title: "Two", Created: daysAgo(3), Tags: readingList},
+		model.Bookmark{ID: 3, URL: "https://example.com/3", Title: "Three", Created: daysAgo(2), Tags: readingList},
+		model.Bookmark{ID: 4, URL: "https://example.com/4", Title: "Four", Created: daysAgo(1)})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	body := `{"ids": [2, 4, 1]}`
+	rec := httptest.NewRecorder()
+	req := newOwnerRequest("PUT", "/api/bookmarks/order", strings.NewReader(body))
+	hdl.apiSetBookmarksOrder(rec, req, nil)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("apiSetBookmarksOrder() status = %d: %s", rec.Code, rec.Body)
+	}
+
+	// Moving the bookmark is a change, so client with older version can't
+	// overwrite it, while saving the bookmark again must keep its position
+	book, _ := hdl.DB.GetBookmark(1, "")
+	if book.Version != 2 {
+		t.Errorf("version after reorder = %d, want 2", book.Version)
+	}
+
+	book.Title = "One edited"
+	if _, err = hdl.DB.SaveBookmarks(book); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name       string
+		query      string
+		wantStatus int
+		wantIDs    []int
+	}{
+		{"default order", "", http.StatusOK, []int{4, 3, 2, 1}},
+		{"manual order", "order=manual", http.StatusOK, []int{2, 4, 1, 3}},
+		{"manual order within tag", "order=manual&tags=reading-list", http.StatusOK, []int{2, 1, 3}},
+		{"unknown order", "order=random", http.StatusBadRequest, nil},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := newOwnerRequest("GET", "/api/bookmarks?"+tt.query, nil)
+			rec := httptest.NewRecorder()
+			hdl.apiGetBookmarks(rec, req, nil)
+
+			if rec.Code != tt.wantStatus {
+				t.Fatalf("apiGetBookmarks() status = %d, want %d", rec.Code, tt.wantStatus)
+			}
+
+			if tt.wantStatus != http.StatusOK {
+				return
+			}
+
+			resp := struct {
+				Bookmarks []model.Bookmark `json:"bookmarks"`
+			}{}
+			json.NewDecoder(rec.Body).Decode(&resp)
+
+			gotIDs := []int{}
+			for _, book := range resp.Bookmarks {
+				gotIDs = append(gotIDs, book.ID)
+			}
+
+			if fmt.Sprint(gotIDs) != fmt.Sprint(tt.wantIDs) {
+				t.Errorf("apiGetBookmarks() returns %v, want %v", gotIDs, tt.wantIDs)
+			}
+		})
+	}
+}
+
+func Test_apiSetBookmarksOrderInvalid(t *testing.T) {
+	tests := []struct {
+		name string
+		body string
+	}{
+		{"empty ids", `{"ids": []}`},
+		{"duplicate id", `{"ids": [1, 2, 1]}`},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			hdl, cleanup := newTestHandler(t)
+			defer cleanup()
+
+			router := httprouter.New()
+			router.PUT("/api/bookmarks/order", hdl.apiSetBookmarksOrder)
+			router.PanicHandler = hdl.handlePanic
+
+			rec := httptest.NewRecorder()
+			req := newOwnerRequest("PUT", "/api/bookmarks/order", strings.NewReader(tt.body))
+			router.ServeHTTP(rec, req)
+
+			if rec.Code != http.StatusBadRequest {
+				t.Errorf("apiSetBookmarksOrder() status = %d, want %d", rec.Code, http.StatusBadRequest)
+			}
+		})
+	}
+}
+
+func Test_apiSetBookmarksRead(t *testing.T) {
+	hdl, cleanup := newTestHandler(t)
+	defer cleanup()
+
+	_, err := hdl.DB.SaveBookmarks(
+		model.Bookmark{ID: 1, URL: "https://example.com/1", Title: "One"},
+		model.Bookmark{ID: 2, URL: "https://example.com/2", Title: "Two"},
+		model.Bookmark{ID: 3, URL: "https://example.com/3", Title: "Three"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	router := httprouter.New()
+	router.PUT("/api/bookmarks/read", hdl.apiSetBookmarksRead)
+	router.PanicHandler = hdl.handlePanic
+
+	for _, body := range []string{`{"ids": [1]}`, `{"ids": [], "read": true}`} {
+		rec := httptest.NewRecorder()
+		router.ServeHTTP(rec, newOwnerRequest("PUT", "/api/bookmarks/read", strings.NewReader(body)))
+		if rec.Code != http.StatusBadRequest {
+			t.Errorf("apiSetBookmarksRead(%s) status = %d, want %d", body, rec.Code, http.StatusBadRequest)
+		}
+	}
+
+	rec := httptest.NewRecorder()
+	body := strings.NewReader(`{"ids": [1, 2, 99], "read": true}`)
+	router.ServeHTTP(rec, newOwnerRequest("PUT", "/api/bookmarks/read", body))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("apiSetBookmarksRead() status = %d, want %d: %s", rec.Code, http.StatusOK, rec.Body)
+	}
+
+	marked := []model.Bookmark{}
+	json.NewDecoder(rec.Body).Decode(&marked)
+	if len(marked) != 2 || !marked[0].Read || marked[0].ReadAt == nil {
+		t.Errorf("apiSetBookmarksRead() returns %+v, want 2 read bookmarks", marked)
+	}
+
+	tests := []struct {
+		name       string
+		query      string
+		wantStatus int
+		wantIDs    []int
+	}{
+		{"read", "read=true", http.StatusOK, []int{2, 1}},
+		{"unread", "read=false", http.StatusOK, []int{3}},
+		{"both", "", http.StatusOK, []int{3, 2, 1}},
+		{"garbage", "read=maybe", http.StatusBadRequest, nil},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := newOwnerRequest("GET", "/api/bookmarks?"+tt.query, nil)
+			rec := httptest.NewRecorder()
+			hdl.apiGetBookmarks(rec, req, nil)
+
+			if rec.Code != tt.wantStatus {
+				t.Fatalf("apiGetBookmarks() status = %d, want %d", rec.Code, tt.wantStatus)
+			}
+
+			if tt.wantStatus != http.StatusOK {
+				return
+			}
+
+			resp := struct {
+				Bookmarks []model.Bookmark `json:"bookmarks"`
+			}{}
+			json.NewDecoder(rec.Body).Decode(&resp)
+
+			gotIDs := []int{}
+			for _, book := range resp.Bookmarks {
+				gotIDs = append(gotIDs, book.ID)
+			}
+
+			if fmt.Sprint(gotIDs) != fmt.Sprint(tt.wantIDs) {
+				t.Errorf("apiGetBookmarks() returns %v, want %v", gotIDs, tt.wantIDs)
+			}
+		})
+	}
+}
+
+func Test_apiStarBookmark(t *testing.T) {
+	hdl, cleanup := newTestHandler(t)
+	defer cleanup()
+
+	_, err := hdl.DB.SaveBookmarks(
+		model.Bookmark{ID: 1, URL: "https://example.com/1", Title: "One"},
+		model.Bookmark{ID: 2, URL: "https://example.com/2", Title: "Two"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name        string
+		method      string
+		id          string
+		wantStatus  int
+		wantStarred []int
+	}{
+		{"star", "PUT", "1", http.StatusOK, []int{1}},
+		{"star again", "PUT", "1", http.StatusOK, []int{1}},
+		{"star another", "PUT", "2", http.StatusOK, []int{2, 1}},
+		{"unstar", "DELETE", "1", http.StatusOK, []int{2}},
+		{"unknown bookmark", "PUT", "99", http.StatusNotFound, []int{2}},
+		{"invalid id", "PUT", "one", http.StatusBadRequest, []int{2}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			target := "/api/bookmark/" + tt.id + "/star"
+			router := httprouter.New()
+			router.Handle(tt.method, target, func(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
+				hdl.apiStarBookmark(w, r, httprouter.Params{{Key: "id", Value: tt.id}})
+			})
+			router.PanicHandler = hdl.handlePanic
+
+			rec := httptest.NewRecorder()
+			router.ServeHTTP(rec, newOwnerRequest(tt.method, target, nil))
+			if rec.Code != tt.wantStatus {
+				t.Fatalf("apiStarBookmark() status = %d, want %d", rec.Code, tt.wantStatus)
+			}
+
+			if tt.wantStatus == http.StatusOK {
+				book := model.Bookmark{}
+				json.NewDecoder(rec.Body).Decode(&book)
+				if book.Starred != (tt.method == "PUT") {
+					t.Errorf("apiStarBookmark() starred = %v, want %v", book.Starred, tt.method == "PUT")
+				}
+			}
+
+			rec = httptest.NewRecorder()
+			hdl.apiGetBookmarks(rec, newOwnerRequest("GET", "/api/bookmarks?starred=true", nil), nil)
+
+			resp := struct {
+				Bookmarks []model.Bookmark `json:"bookmarks"`
+			}{}
+			json.NewDecoder(rec.Body).Decode(&resp)
+
+			gotIDs := []int{}
+			for _, book := range resp.Bookmarks {
+				gotIDs = append(gotIDs, book.ID)
+			}
+
+			if fmt.Sprint(gotIDs) != fmt.Sprint(tt.wantStarred) {
+				t.Errorf("apiGetBookmarks() of starred returns %v, want %v", gotIDs, tt.wantStarred)
+			}
+		})
+	}
+}
+
+func Test_apiGetBookmarksBroken(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/page" {
+			http.NotFound(w, r)
+		}
+	}))
+	defer srv.Close()
+
+	hdl, cleanup := newTestHandler(t)
+	defer cleanup()
+
+	bookmarks, err := hdl.DB.SaveBookmarks(
+		model.Bookmark{ID: 1, URL: srv.URL + "/page", Title: "Reachable"},
+		model.Bookmark{ID: 2, URL: srv.URL + "/missing", Title: "Not found"},
+		model.Bookmark{ID: 3, URL: "http://127.0.0.1:1/", Title: "Unreachable"},
+		model.Bookmark{ID: 4, URL: "https://example.com/unchecked", Title: "Unchecked"},
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, book := range bookmarks[:3] {
+		if broken := hdl.checkLink(book); broken != (book.ID != 1) {
+			t.Errorf("checkLink() of bookmark %d = %v", book.ID, broken)
+		}
+	}
+
+	rec := httptest.NewRecorder()
+	hdl.apiGetBookmarks(rec, newOwnerRequest("GET", "/api/bookmarks?broken=true", nil), nil)
+
+	resp := struct {
+		Bookmarks []model.Bookmark `json:"bookmarks"`
+	}{}
+	json.NewDecoder(rec.Body).Decode(&resp)
+
+	gotStatus := map[int]int{}
+	for _, book := range resp.Bookmarks {
+		if book.LinkChecked == nil {
+			t.Errorf("bookmark %d has no checked time", book.ID)
+		}
+		gotStatus[book.ID] = book.LinkStatus
+	}
+
+	if want := map[int]int{2: http.StatusNotFound, 3: 0}; !reflect.DeepEqual(gotStatus, want) {
+		t.Errorf("apiGetBookmarks() of broken returns status %v, want %v", gotStatus, want)
+	}
+}
+
+func Test_apiGetBookmarksRegex(t *testing.T) {
+	hdl, cleanup := newTestHandler(t)
+	defer cleanup()
+
+	_, err := hdl.DB.SaveBookmarks(
+		model.Bookmark{ID: 1, URL: "https://example.com/1", Title: "Golang tips"},
+		model.Bookmark{ID: 2, URL: "https://example.com/2", Title: "Go tricks"},
+		model.Bookmark{ID: 3, URL: "https://example.com/3", Title: "Tips for golang"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name       string
+		query      string
+		wantStatus int
+		wantIDs    []int
+	}{
+		{"regex", "regex=true&keyword=" + url.QueryEscape(`^go(lang)? `), http.StatusOK, []int{2, 1}},
+		{"plain substring by default", "keyword=golang", http.StatusOK, []int{3, 1}},
+		{"invalid regex", "regex=true&keyword=" + url.QueryEscape(`(go`), http.StatusBadRequest, nil},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := newOwnerRequest("GET", "/api/bookmarks?"+tt.query, nil)
+			rec := httptest.NewRecorder()
+			hdl.apiGetBookmarks(rec, req, nil)
+
+			if rec.Code != tt.wantStatus {
+				t.Fatalf("apiGetBookmarks() status = %d, want %d", rec.Code, tt.wantStatus)
+			}
+
+			if tt.wantStatus != http.StatusOK {
+				return
+			}
+
+			resp := struct {
+				Bookmarks []model.Bookmark `json:"bookmarks"`
+			}{}
+			json.NewDecoder(rec.Body).Decode(&resp)
+
+			gotIDs := []int{}
+			for _, book := range resp.Bookmarks {
+				gotIDs = append(gotIDs, book.ID)
+			}
+
+			if fmt.Sprint(gotIDs) != fmt.Sprint(tt.wantIDs) {
+				t.Errorf("apiGetBookmarks() returns %v, want %v", gotIDs, tt.wantIDs)
+			}
+		})
+	}
+}
+
+func Test_apiUpdateBookmarkMetadata(t *testing.T) {
+	tests := []struct {
+		name         string
+		body         string
+		wantStatus   int
+		wantMetadata model.Metadata
+	}{
+		{"omitted metadata is kept", `{"id": 1, "url": "https://example.com", "title": "New", "version": 1}`,
+			http.StatusOK, model.Metadata{"isbn": "123"}},
+		{"submitted metadata replaces", `{"id": 1, "url": "https://example.com", "title": "New", "version": 1, "metadata": {"priority": "high"}}`,
+			http.StatusOK, model.Metadata{"priority": "high"}},
+		{"empty metadata clears", `{"id": 1, "url": "https://example.com", "title": "New", "version": 1, "metadata": {}}`,
+			http.StatusOK, nil},
+		{"invalid key", `{"id": 1, "url": "https://example.com", "title": "New", "version": 1, "metadata": {"a b": "c"}}`,
+			http.StatusBadRequest, model.Metadata{"isbn": "123"}},
+		{"empty value", `{"id": 1, "url": "https://example.com", "title": "New", "version": 1, "metadata": {"isbn": ""}}`,
+			http.StatusBadRequest, model.Metadata{"isbn": "123"}},
+		{"too large", `{"id": 1, "url": "https://example.com", "title": "New", "version": 1, "metadata": {"note": "` +
+			strings.Repeat("a", maxMetadataSize) + `"}}`, http.StatusBadRequest, model.Metadata{"isbn": "123"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			hdl, cleanup := newTestHandler(t)
+			defer cleanup()
+
+			book := model.Bookmark{ID: 1, URL: "https://example.com", Title: "Old", Metadata: model.Metadata{"isbn": "123"}}
+			if _, err := hdl.DB.SaveBookmarks(book); err != nil {
+				t.Fatal(err)
+			}
+
+			router := httprouter.New()
+			router.PUT("/api/bookmarks", hdl.apiUpdateBookmark)
+			router.PanicHandler = hdl.handlePanic
+
+			rec := httptest.NewRecorder()
+			req := newOwnerRequest("PUT", "/api/bookmarks", strings.NewReader(tt.body))
+			router.ServeHTTP(rec, req)
+
+			if rec.Code != tt.wantStatus {
+				t.Fatalf("apiUpdateBookmark() status = %d, want %d: %s", rec.Code, tt.wantStatus, rec.Body)
+			}
+
+			saved, _ := hdl.DB.GetBookmark(1, "")
+			if !reflect.DeepEqual(saved.Metadata, tt.wantMetadata) {
+				t.Errorf("saved metadata = %v, want %v", saved.Metadata, tt.wantMetadata)
+			}
+		})
+	}
+}
+
+func Test_apiGetBookmarksMetadata(t *testing.T) {
+	hdl, cleanup := newTestHandler(t)
+	defer cleanup()
+
+	_, err := hdl.DB.SaveBookmarks(
+		model.Bookmark{ID: 1, URL: "https://example.com/1", Title: "One", Metadata: model.Metadata{"priority": "high"}},
+		model.Bookmark{ID: 2, URL: "https://example.com/2", Title: "Two", Metadata: model.Metadata{"priority": "low", "isbn": "123"}},
+		model.Bookmark{ID: 3, URL: "https://example.com/3", Title: "Three"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name       string
+		query      string
+		wantStatus int
+		wantIDs    []int
+	}{
+		{"has key", "metadata=priority", http.StatusOK, []int{2, 1}},
+		{"exact value", "metadata=priority:high", http.StatusOK, []int{1}},
+		{"several filters", "metadata=priority&metadata=isbn:123", http.StatusOK, []int{2}},
+		{"invalid key", "metadata=" + url.QueryEscape("a b:c"), http.StatusBadRequest, nil},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := newOwnerRequest("GET", "/api/bookmarks?"+tt.query, nil)
+			rec := httptest.NewRecorder()
+			hdl.apiGetBookmarks(rec, req, nil)
+
+			if rec.Code != tt.wantStatus {
+				t.Fatalf("apiGetBookmarks() status = %d, want %d", rec.Code, tt.wantStatus)
+			}
+
+			if tt.wantStatus != http.StatusOK {
+				return
+			}
+
+			resp := struct {
+				Bookmarks []model.Bookmark `json:"bookmarks"`
+			}{}
+			json.NewDecoder(rec.Body).Decode(&resp)
+
+			gotIDs := []int{}
+			for _, book := range resp.Bookmarks {
+				gotIDs = append(gotIDs, book.ID)
+			}
+
+			if fmt.Sprint(gotIDs) != fmt.Sprint(tt.wantIDs) {
+				t.Errorf("apiGetBookmarks() returns %v, want %v", gotIDs, tt.wantIDs)
+			}
+		})
+	}
+}
+
+func Test_apiTokens(t *testing.T) {
+	hdl, cleanup := newTestHandler(t)
+	defer cleanup()
+
+	err := hdl.DB.SaveAccount(model.Account{Username: "alice", Password: "secret"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	router := httprouter.New()
+	router.GET("/api/tokens", hdl.apiGetTokens)
+	router.POST("/api/tokens", hdl.apiInsertToken)
+	router.DELETE("/api/tokens/1", func(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
+		hdl.apiDeleteToken(w, r, httprouter.Params{{Key: "id", Value: "1"}})
+	})
+	router.PanicHandler = hdl.handlePanic
+	server := hdl.authenticateToken(hdl.authorizeRole(router))
+
+	request := func(method, path, token, body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, path, strings.NewReader(body))
+		if token != "" {
+			req.Header.Set("Authorization", "Bearer "+token)
+		}
+
+		rec := httptest.NewRecorder()
+		server.ServeHTTP(rec, req)
+		return rec
+	}
+
+	// Token can't be created with wrong password
+	rec := request("POST", "/api/tokens", "", `{"username":"alice","password":"wrong","name":"script"}`)
+	if rec.Code != http.StatusUnauthorized {
+		t.Fatalf("create token with wrong password status = %d, want 401", rec.Code)
+	}
+
+	rec = request("POST", "/api/tokens", "", `{"username":"alice","password":"secret","name":"script"}`)
+	if rec.Code != http.StatusCreated {
+		t.Fatalf("create token status = %d, want 201: %s", rec.Code, rec.Body.String())
+	}
+
+	var created model.APIToken
+	if err := json.NewDecoder(rec.Body).Decode(&created); err != nil || created.Token == "" {
+		t.Fatalf("create token response = %+v, %v", created, err)
+	}
+
+	// Listing tokens requires a valid token
+	if rec := request("GET", "/api/tokens", "", ""); rec.Code != http.StatusUnauthorized {
+		t.Errorf("list tokens without token status = %d, want 401", rec.Code)
+	}
+
+	if rec := request("GET", "/api/tokens", "invalid", ""); rec.Code != http.StatusUnauthorized {
+		t.Errorf("list tokens with invalid token status = %d, want 401", rec.Code)
+	}
+
+	rec = request("GET", "/api/tokens", created.Token, "")
+	var tokens []model.APIToken
+	if err := json.NewDecoder(rec.Body).Decode(&tokens); err != nil {
+		t.Fatalf("failed to decode tokens: %v", err)
+	}
+
+	if len(tokens) != 1 || tokens[0].Name != "script" || tokens[0].Token != "" {
+		t.Errorf("list tokens = %+v, want one token without its value", tokens)
+	}
+
+	// Once revoked, the token no longer works
+	if rec := request("DELETE", "/api/tokens/1", created.Token, ""); rec.Code != http.StatusOK {
+		t.Fatalf("revoke token status = %d, want 200", rec.Code)
+	}
+
+	if rec := request("GET", "/api/tokens", created.Token, ""); rec.Code != http.StatusUnauthorized {
+		t.Errorf("list tokens with revoked token status = %d, want 401", rec.Code)
+	}
+
+	// Read-only token can read, but can't change anything
+	rec = request("POST", "/api/tokens", "", `{"username":"alice","password":"secret","name":"feed","scope":"write"}`)
+	if rec.Code != http.StatusBadRequest {
+		t.Errorf("create token with unknown scope status = %d, want 400", rec.Code)
+	}
+
+	rec = request("POST", "/api/tokens", "", `{"username":"alice","password":"secret","name":"feed","scope":"read"}`)
+	var readOnly model.APIToken
+	if err := json.NewDecoder(rec.Body).Decode(&readOnly); err != nil || readOnly.Scope != model.TokenScopeRead {
+		t.Fatalf("create read-only token response = %+v, %v", readOnly, err)
+	}
+
+	rec = request("GET", "/api/tokens", readOnly.Token, "")
+	tokens = nil
+	if err := json.NewDecoder(rec.Body).Decode(&tokens); err != nil || len(tokens) != 1 || tokens[0].Scope != model.TokenScopeRead {
+		t.Errorf("list tokens with read-only token = %+v, %v, want its scope", tokens, err)
+	}
+
+	if rec := request("POST", "/api/tokens", readOnly.Token, `{"name":"escalate"}`); rec.Code != http.StatusForbidden {
+		t.Errorf("create token with read-only token status = %d, want 403", rec.Code)
+	}
+}
+
+func Test_apiSessions(t *testing.T) {
+	hdl, cleanup := newTestHandler(t)
+	defer cleanup()
+
+	hdl.SessionKey = []byte("0123456789abcdef0123456789abcdef")
+	err := hdl.DB.SaveAccount(model.Account{Username: "alice", Password: "secret"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	router := httprouter.New()
+	router.POST("/api/login", hdl.apiLogin)
+	router.POST("/api/refresh", hdl.apiRefreshSession)
+	router.POST("/api/logout", hdl.apiLogout)
+	router.GET("/api/sessions", hdl.apiGetSessions)
+	router.DELETE("/api/sessions/1", func(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
+		hdl.apiDeleteSession(w, r, httprouter.Params{{Key: "id", Value: "1"}})
+	})
+	router.PanicHandler = hdl.handlePanic
+	server := hdl.authenticateToken(hdl.rejectWritesInReadOnly(router))
+
+	request := func(method, path, token, body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, path, strings.NewReader(body))
+		if token != "" {
+			req.Header.Set("Authorization", "Bearer "+token)
+		}
+
+		rec := httptest.NewRecorder()
+		server.ServeHTTP(rec, req)
+		return rec
+	}
+
+	// Users can still sign in and out in read-only mode,
+	// though they can't revoke other sessions
+	hdl.setReadOnly(true)
+
+	login := func() sessionResponse {
+		rec := request("POST", "/api/login", "", `{"username":"alice","password":"secret"}`)
+		if rec.Code != http.StatusOK {
+			t.Fatalf("login status = %d, want 200: %s", rec.Code, rec.Body.String())
+		}
+
+		var resp sessionResponse
+		if err := json.NewDecoder(rec.Body).Decode(&resp); err != nil {
+			t.Fatalf("failed to decode login response: %v", err)
+		}
+
+		return resp
+	}
+
+	if rec := request("POST", "/api/login", "", `{"username":"alice","password":"wrong"}`); rec.Code != http.StatusUnauthorized {
+		t.Fatalf("login with wrong password status = %d, want 401", rec.Code)
+	}
+
+	first := login()
+	second := login()
+
+	// Refresh token gives new access token that works
+	rec := request("POST", "/api/refresh", "", `{"refreshToken":"`+first.RefreshToken+`"}`)
+	var refreshed sessionResponse
+	json.NewDecoder(rec.Body).Decode(&refreshed)
+	if rec.Code != http.StatusOK || refreshed.AccessToken == "" {
+		t.Fatalf("refresh status = %d, response = %+v", rec.Code, refreshed)
+	}
+
+	rec = request("GET", "/api/sessions", refreshed.AccessToken, "")
+	var sessions []model.Session
+	json.NewDecoder(rec.Body).Decode(&sessions)
+	if rec.Code != http.StatusOK || len(sessions) != 2 {
+		t.Fatalf("list sessions status = %d, sessions = %+v, want 2 sessions", rec.Code, sessions)
+	}
+
+	// Forged refresh token is rejected
+	forged := strconv.Itoa(first.Session.ID) + ".forged"
+	if rec := request("POST", "/api/refresh", "", `{"refreshToken":"`+forged+`"}`); rec.Code != http.StatusUnauthorized {
+		t.Errorf("refresh with forged token status = %d, want 401", rec.Code)
+	}
+
+	// Once the first session is revoked from the second one, neither
+	// its access token nor its refresh token work anymore
+	if rec := request("DELETE", "/api/sessions/1", second.AccessToken, ""); rec.Code != http.StatusServiceUnavailable {
+		t.Fatalf("revoke session in read-only mode status = %d, want 503", rec.Code)
+	}
+
+	hdl.setReadOnly(false)
+	if rec := request("DELETE", "/api/sessions/1", second.AccessToken, ""); rec.Code != http.StatusOK {
+		t.Fatalf("revoke session status = %d, want 200", rec.Code)
+	}
+
+	if rec := request("GET", "/api/sessions", first.AccessToken, ""); rec.Code != http.StatusUnauthorized {
+		t.Errorf("access token of revoked session status = %d, want 401", rec.Code)
+	}
+
+	if rec := request("POST", "/api/refresh", "", `{"refreshToken":"`+first.RefreshToken+`"}`); rec.Code != http.StatusUnauthorized {
+		t.Errorf("refresh token of revoked session status = %d, want 401", rec.Code)
+	}
+
+	// Logout revokes the current session
+	hdl.setReadOnly(true)
+	if rec := request("POST", "/api/logout", second.AccessToken, ""); rec.Code != http.StatusOK {
+		t.Fatalf("logout status = %d, want 200", rec.Code)
+	}
+
+	if rec := request("GET", "/api/sessions", second.AccessToken, ""); rec.Code != http.StatusUnauthorized {
+		t.Errorf("access token after logout status = %d, want 401", rec.Code)
+	}
+
+	// Web interface is signed in by the session cookie instead,
+	// which stops working once it's logged out
+	rec = request("POST", "/api/login", "", `{"username":"alice","password":"secret"}`)
+	cookies := rec.Result().Cookies()
+	if len(cookies) != 1 || cookies[0].Name != sessionCookie || !cookies[0].HttpOnly {
+		t.Fatalf("login cookies = %+v, want HttpOnly session cookie", cookies)
+	}
+
+	cookieRequest := func(method, path string) int {
+		req := httptest.NewRequest(method, path, nil)
+		req.AddCookie(cookies[0])
+
+		rec := httptest.NewRecorder()
+		server.ServeHTTP(rec, req)
+		return rec.Code
+	}
+
+	if status := cookieRequest("GET", "/api/sessions"); status != http.StatusOK {
+		t.Fatalf("list sessions with cookie status = %d, want 200", status)
+	}
+
+	if status := cookieRequest("POST", "/api/logout"); status != http.StatusOK {
+		t.Fatalf("logout with cookie status = %d, want 200", status)
+	}
+
+	if status := cookieRequest("GET", "/api/sessions"); status != http.StatusUnauthorized {
+		t.Errorf("cookie after logout status = %d, want 401", status)
+	}
+}
+
+func Test_apiBookmarkOwner(t *testing.T) {
+	hdl, cleanup := newTestHandler(t)
+	defer cleanup()
+
+	alice := model.Account{ID: 1, Username: "alice", Role: model.RoleEditor}
+	admin := model.Account{ID: 2, Username: "admin", Role: model.RoleOwner, Owner: true}
+
+	_, err := hdl.DB.SaveBookmarks(
+		model.Bookmark{ID: 1, URL: "https://example.com/1", Title: "Alice's", OwnerID: alice.ID,
+			Tags: []model.Tag{{Name: "alice"}}},
+		model.Bookmark{ID: 2, URL: "https://example.com/2", Title: "Admin's", OwnerID: admin.ID,
+			Tags: []model.Tag{{Name: "admin"}}},
+		model.Bookmark{ID: 3, URL: "https://example.com/3", Title: "Nobody's", Public: 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	withRole := func(req *http.Request, account *model.Account) *http.Request {
+		if account == nil {
+			return req
+		}
+		return withAccount(req, *account)
+	}
+
+	tests := []struct {
+		name       string
+		account    *model.Account
+		query      string
+		wantStatus int
+		wantIDs    []int
+	}{
+		{"anonymous", nil, "", http.StatusOK, []int{}},
+		{"editor", &alice, "", http.StatusOK, []int{1}},
+		{"editor lists all", &alice, "all=true", http.StatusForbidden, nil},
+		{"owner", &admin, "", http.StatusOK, []int{2}},
+		{"owner lists all", &admin, "all=true", http.StatusOK, []int{3, 2, 1}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := withRole(httptest.NewRequest("GET", "/api/bookmarks?"+tt.query, nil), tt.account)
+			rec := httptest.NewRecorder()
+			hdl.apiGetBookmarks(rec, req, nil)
+
+			if rec.Code != tt.wantStatus {
+				t.Fatalf("apiGetBookmarks() status = %d, want %d", rec.Code, tt.wantStatus)
+			}
+
+			if tt.wantStatus != http.StatusOK {
+				return
+			}
+
+			resp := struct {
+				Bookmarks []model.Bookmark `json:"bookmarks"`
+			}{}
+			json.NewDecoder(rec.Body).Decode(&resp)
+
+			gotIDs := []int{}
+			for _, book := range resp.Bookmarks {
+				gotIDs = append(gotIDs, book.ID)
+			}
+
+			if fmt.Sprint(gotIDs) != fmt.Sprint(tt.wantIDs) {
+				t.Errorf("apiGetBookmarks() returns %v, want %v", gotIDs, tt.wantIDs)
+			}
+		})
+	}
+
+	// Editor can only change its own bookmarks, while owner can change any
+	router := httprouter.New()
+	for _, id := range []string{"1", "2"} {
+		id := id
+		router.PATCH("/api/bookmarks/"+id, func(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
+			hdl.apiPatchBookmark(w, r, httprouter.Params{{Key: "id", Value: id}})
+		})
+	}
+	router.PanicHandler = hdl.handlePanic
+
+	patchTests := []struct {
+		name       string
+		account    *model.Account
+		id         string
+		wantStatus int
+	}{
+		{"editor patches its own", &alice, "1", http.StatusOK},
+		{"editor patches other's", &alice, "2", http.StatusNotFound},
+		{"owner patches other's", &admin, "1", http.StatusOK},
+	}
+
+	for _, tt := range patchTests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest("PATCH", "/api/bookmarks/"+tt.id, strings.NewReader(`{"excerpt":"edited"}`))
+			rec := httptest.NewRecorder()
+			router.ServeHTTP(rec, withRole(req, tt.account))
+
+			if rec.Code != tt.wantStatus {
+				t.Errorf("apiPatchBookmark() status = %d, want %d: %s", rec.Code, tt.wantStatus, rec.Body)
+			}
+		})
+	}
+
+	// Tags are only counted from bookmarks that the account may list
+	tagTests := []struct {
+		name    string
+		account *model.Account
+		query   string
+		want    string
+	}{
+		{"editor", &alice, "", "[alice]"},
+		{"owner", &admin, "", "[admin]"},
+		{"owner lists all", &admin, "all=true", "[admin alice]"},
+	}
+
+	for _, tt := range tagTests {
+		t.Run("tags of "+tt.name, func(t *testing.T) {
+			rec := httptest.NewRecorder()
+			hdl.apiGetTags(rec, withRole(httptest.NewRequest("GET", "/api/tags?"+tt.query, nil), tt.account), nil)
+
+			tags := []model.Tag{}
+			json.NewDecoder(rec.Body).Decode(&tags)
+
+			names := []string{}
+			for _, tag := range tags {
+				names = append(names, tag.Name)
+			}
+
+			if got := fmt.Sprint(names); got != tt.want {
+				t.Errorf("apiGetTags() returns %s, want %s", got, tt.want)
+			}
+		})
+	}
+
+	// Thumbnail is only served for bookmark that the request may access,
+	// which is only the public one for anonymous request
+	os.MkdirAll(fp.Join(hdl.DataDir, "thumb"), os.ModePerm)
+	for _, id := range []string{"1", "2", "3"} {
+		ioutil.WriteFile(fp.Join(hdl.DataDir, "thumb", id), []byte("thumbnail"), os.ModePerm)
+	}
+
+	thumbTests := []struct {
+		name       string
+		account    *model.Account
+		id         string
+		wantStatus int
+	}{
+		{"anonymous gets public", nil, "3", http.StatusOK},
+		{"anonymous gets private", nil, "1", http.StatusNotFound},
+		{"editor gets its own", &alice, "1", http.StatusOK},
+		{"editor gets other's", &alice, "2", http.StatusNotFound},
+		{"owner gets other's", &admin, "1", http.StatusOK},
+	}
+
+	for _, tt := range thumbTests {
+		t.Run("thumbnail "+tt.name, func(t *testing.T) {
+			req := withRole(httptest.NewRequest("GET", "/bookmark/"+tt.id+"/thumb", nil), tt.account)
+			rec := httptest.NewRecorder()
+			router := httprouter.New()
+			router.GET("/bookmark/"+tt.id+"/thumb", func(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
+				hdl.serveThumbnailImage(w, r, httprouter.Params{{Key: "id", Value: tt.id}})
+			})
+			router.PanicHandler = hdl.handlePanic
+			router.ServeHTTP(rec, req)
+
+			if rec.Code != tt.wantStatus {
+				t.Errorf("serveThumbnailImage() status = %d, want %d", rec.Code, tt.wantStatus)
+			}
+		})
+	}
+
+	// Editor's new bookmark is owned by it, whatever client submits
+	req := httptest.NewRequest("POST", "/api/bookmarks", strings.NewReader(
+		`{"url":"https://example.com/4","title":"Four","ownerId":2,"createArchive":false}`))
+	rec := httptest.NewRecorder()
+	hdl.apiInsertBookmark(rec, withAccount(req, alice), nil)
+
+	var inserted model.Bookmark
+	json.NewDecoder(rec.Body).Decode(&inserted)
+	if inserted.OwnerID != alice.ID {
+		t.Errorf("apiInsertBookmark() owner = %d, want %d: %s", inserted.OwnerID, alice.ID, rec.Body)
+	}
+}
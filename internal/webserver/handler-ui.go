@@ -13,6 +13,7 @@ import (
 	"strings"
 
 	"github.com/PuerkitoBio/goquery"
+	"shiori/internal/core"
 	"shiori/internal/model"
 	"github.com/go-shiori/warc"
 	"github.com/julienschmidt/httprouter"
@@ -65,33 +66,34 @@ func (h *handler) serveBookmarkContent(w http.ResponseWriter, r *http.Request, p
 
 	// Get bookmark in database
 	bookmark, exist := h.DB.GetBookmark(id, "")
-	if !exist {
-		panic(fmt.Errorf("Bookmark not found"))
+	if !exist || !canAccessBookmark(r, bookmark) {
+		panic(newClientError(http.StatusNotFound, fmt.Errorf("Bookmark not found")))
 	}
 
-	// Check if it has archive.
+	h.renderBookmarkContent(w, r, bookmark, path.Join(h.RootPath, "bookmark", strID))
+}
+
+// renderBookmarkContent renders the readable content of bookmark. Images
+// in it are served from its archive, whose URL is under basePath along
+// with the other links in the page.
+func (h *handler) renderBookmarkContent(w http.ResponseWriter, r *http.Request, bookmark model.Bookmark, basePath string) {
+	// Check if it has screenshot or archive
+	strID := strconv.Itoa(bookmark.ID)
 	archivePath := fp.Join(h.DataDir, "archive", strID)
+	bookmark.HasScreenshot = fileExists(core.ScreenshotPath(h.DataDir, bookmark.ID))
+	bookmark.HasPDF = fileExists(core.PDFPath(h.DataDir, bookmark.ID))
+	bookmark.HasSingleFile = fileExists(core.SingleFilePath(h.DataDir, bookmark.ID))
 	if fileExists(archivePath) {
 		bookmark.HasArchive = true
 
 		// Open archive, look in cache first
-		var archive *warc.Archive
-		cacheData, found := h.ArchiveCache.Get(strID)
-
-		if found {
-			archive = cacheData.(*warc.Archive)
-		} else {
-			archivePath := fp.Join(h.DataDir, "archive", strID)
-			archive, err = warc.Open(archivePath)
-			checkError(err)
-
-			h.ArchiveCache.Set(strID, archive, 0)
-		}
+		archive, err := h.getArchive(strID)
+		checkError(err)
 
 		// Find all image and convert its source to use the archive URL.
 		createArchivalURL := func(archivalName string) string {
 			archivalURL := *r.URL
-			archivalURL.Path = path.Join(h.RootPath, "bookmark", strID, "archive", archivalName)
+			archivalURL.Path = path.Join(basePath, "archive", archivalName)
 			return archivalURL.String()
 		}
 
@@ -106,8 +108,8 @@ func (h *handler) serveBookmarkContent(w http.ResponseWriter, r *http.Request, p
 
 			// Convert `src` attributes
 			if src != "" {
-				archivalName := getArchivalName(src)
-				if archivalName != "" && archive.HasResource(archivalName) {
+				archivalName := findArchivalName(archive, src)
+				if archivalName != "" {
 					node.SetAttr("src", createArchivalURL(archivalName))
 				}
 			}
@@ -121,8 +123,8 @@ func (h *handler) serveBookmarkContent(w http.ResponseWriter, r *http.Request, p
 					continue
 				}
 
-				archivalName := getArchivalName(parts[0])
-				if archivalName != "" && archive.HasResource(archivalName) {
+				archivalName := findArchivalName(archive, parts[0])
+				if archivalName != "" {
 					archivalURL := createArchivalURL(archivalName)
 					srcSets[i] = strings.Replace(srcSets[i], parts[0], archivalURL, 1)
 				}
@@ -144,24 +146,40 @@ func (h *handler) serveBookmarkContent(w http.ResponseWriter, r *http.Request, p
 
 	tplData := struct {
 		RootPath string
+		BasePath string
 		Book     model.Bookmark
-	}{h.RootPath, bookmark}
+	}{h.RootPath, basePath, bookmark}
 
-	err = h.templates["content"].Execute(w, &tplData)
+	err := h.templates["content"].Execute(w, &tplData)
 	checkError(err)
 }
 
 // serveThumbnailImage is handler for GET /bookmark/:id/thumb
 func (h *handler) serveThumbnailImage(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
 	// Get bookmark ID from URL
-	id := ps.ByName("id")
+	id, err := strconv.Atoi(ps.ByName("id"))
+	if err != nil {
+		panic(newClientError(http.StatusBadRequest, fmt.Errorf("bookmark id must be a number")))
+	}
+
+	if book, exist := h.DB.GetBookmark(id, ""); !exist || !canAccessBookmark(r, book) {
+		panic(newClientError(http.StatusNotFound, fmt.Errorf("Bookmark not found")))
+	}
 
 	// Open image
-	imgPath := fp.Join(h.DataDir, "thumb", id)
+	imgPath := fp.Join(h.DataDir, "thumb", strconv.Itoa(id))
 	img, err := os.Open(imgPath)
 	checkError(err)
 	defer img.Close()
 
+	// Set cache value, thumbnail URL is changed whenever it's updated
+	info, err := img.Stat()
+	checkError(err)
+
+	if checkETag(w, r, `W/"`+fileVersion(info)+`"`) {
+		return
+	}
+
 	// Get image type from its 512 first bytes
 	buffer := make([]byte, 512)
 	_, err = img.Read(buffer)
@@ -170,20 +188,189 @@ func (h *handler) serveThumbnailImage(w http.ResponseWriter, r *http.Request, ps
 	mimeType := http.DetectContentType(buffer)
 	w.Header().Set("Content-Type", mimeType)
 
-	// Set cache value
+	// Serve image
+	img.Seek(0, 0)
+	_, err = io.Copy(w, img)
+	checkError(err)
+}
+
+// serveBookmarkScreenshot is handler for GET /bookmark/:id/screenshot
+//
+// It serves the full-page screenshot of bookmark, just like
+// GET /api/bookmark/:id/screenshot, so it can be linked from its content.
+func (h *handler) serveBookmarkScreenshot(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	id, err := strconv.Atoi(ps.ByName("id"))
+	if err != nil {
+		panic(newClientError(http.StatusBadRequest, fmt.Errorf("bookmark id must be a number")))
+	}
+
+	if book, exist := h.DB.GetBookmark(id, ""); !exist || !canAccessBookmark(r, book) {
+		panic(newClientError(http.StatusNotFound, fmt.Errorf("Bookmark not found")))
+	}
+
+	h.serveScreenshot(w, r, id)
+}
+
+// serveScreenshot serves the full-page screenshot of bookmark as PNG image.
+func (h *handler) serveScreenshot(w http.ResponseWriter, r *http.Request, id int) {
+	img, err := os.Open(core.ScreenshotPath(h.DataDir, id))
+	if os.IsNotExist(err) {
+		panic(newClientError(http.StatusNotFound, fmt.Errorf("bookmark doesn't have screenshot")))
+	}
+	checkError(err)
+	defer img.Close()
+
+	// Screenshot is only replaced when the bookmark is archived again
 	info, err := img.Stat()
 	checkError(err)
 
-	etag := fmt.Sprintf(`W/"%x-%x"`, info.ModTime().Unix(), info.Size())
-	w.Header().Set("ETag", etag)
-	w.Header().Set("Cache-Control", "max-age=86400")
+	if checkETag(w, r, `W/"`+fileVersion(info)+`"`) {
+		return
+	}
 
-	// Serve image
-	img.Seek(0, 0)
+	w.Header().Set("Content-Type", "image/png")
 	_, err = io.Copy(w, img)
 	checkError(err)
 }
 
+// serveBookmarkPDF is handler for GET /bookmark/:id/pdf
+//
+// It serves the PDF snapshot of bookmark, which saved when it's requested
+// on insert or cache update.
+func (h *handler) serveBookmarkPDF(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	id, err := strconv.Atoi(ps.ByName("id"))
+	if err != nil {
+		panic(newClientError(http.StatusBadRequest, fmt.Errorf("bookmark id must be a number")))
+	}
+
+	if book, exist := h.DB.GetBookmark(id, ""); !exist || !canAccessBookmark(r, book) {
+		panic(newClientError(http.StatusNotFound, fmt.Errorf("Bookmark not found")))
+	}
+
+	h.servePDF(w, r, id)
+}
+
+// servePDF serves the PDF snapshot of bookmark. It's shown inline by the
+// browser, while saving it uses the bookmark ID as file name.
+func (h *handler) servePDF(w http.ResponseWriter, r *http.Request, id int) {
+	pdf, err := os.Open(core.PDFPath(h.DataDir, id))
+	if os.IsNotExist(err) {
+		panic(newClientError(http.StatusNotFound, fmt.Errorf("bookmark doesn't have PDF")))
+	}
+	checkError(err)
+	defer pdf.Close()
+
+	// PDF is only replaced when the bookmark is printed again
+	info, err := pdf.Stat()
+	checkError(err)
+
+	if checkETag(w, r, `W/"`+fileVersion(info)+`"`) {
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/pdf")
+	w.Header().Set("Content-Disposition", fmt.Sprintf(`inline; filename="bookmark-%d.pdf"`, id))
+	_, err = io.Copy(w, pdf)
+	checkError(err)
+}
+
+// serveBookmarkSingleFile is handler for GET /bookmark/:id/singlefile
+//
+// It serves the single-file archive of bookmark, i.e. standalone HTML page
+// with its sub-resources inlined, which can be saved and opened anywhere.
+func (h *handler) serveBookmarkSingleFile(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	id, err := strconv.Atoi(ps.ByName("id"))
+	if err != nil {
+		panic(newClientError(http.StatusBadRequest, fmt.Errorf("bookmark id must be a number")))
+	}
+
+	if book, exist := h.DB.GetBookmark(id, ""); !exist || !canAccessBookmark(r, book) {
+		panic(newClientError(http.StatusNotFound, fmt.Errorf("Bookmark not found")))
+	}
+
+	h.serveSingleFile(w, r, id)
+}
+
+// serveSingleFile serves the single-file archive of bookmark. It's served
+// in sandbox, since it's a page from other site served under our origin.
+func (h *handler) serveSingleFile(w http.ResponseWriter, r *http.Request, id int) {
+	page, err := os.Open(core.SingleFilePath(h.DataDir, id))
+	if os.IsNotExist(err) {
+		panic(newClientError(http.StatusNotFound, fmt.Errorf("bookmark doesn't have single-file archive")))
+	}
+	checkError(err)
+	defer page.Close()
+
+	// Archive is only replaced when the bookmark is archived again
+	info, err := page.Stat()
+	checkError(err)
+
+	if checkETag(w, r, `W/"`+fileVersion(info)+`"`) {
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	setSandbox(w)
+	w.Header().Set("Content-Disposition", fmt.Sprintf(`inline; filename="bookmark-%d.html"`, id))
+	_, err = io.Copy(w, page)
+	checkError(err)
+}
+
+// serveArchivedResource is handler for GET /bookmark/:id/resource
+//
+// It serves a resource in archive by its original URL, which specified in
+// `url` query, so the URL doesn't have to be converted into archival name.
+func (h *handler) serveArchivedResource(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	// Get parameter from URL
+	strID := ps.ByName("id")
+	resourceURL := r.URL.Query().Get("url")
+	if resourceURL == "" {
+		http.Error(w, "url of resource is required", http.StatusBadRequest)
+		return
+	}
+
+	id, err := strconv.Atoi(strID)
+	if err != nil {
+		http.Error(w, "bookmark id must be a number", http.StatusBadRequest)
+		return
+	}
+
+	if book, exist := h.DB.GetBookmark(id, ""); !exist || !canAccessBookmark(r, book) {
+		http.Error(w, "bookmark not found", http.StatusNotFound)
+		return
+	}
+
+	// Open archive, look in cache first
+	strID = strconv.Itoa(id)
+	archiveInfo, err := os.Stat(fp.Join(h.DataDir, "archive", strID))
+	if err != nil {
+		http.Error(w, "bookmark doesn't have archive", http.StatusNotFound)
+		return
+	}
+
+	archive, err := h.getArchive(strID)
+	checkError(err)
+
+	// Find the resource, then serve it as it is
+	archivalName := findArchivalName(archive, resourceURL)
+	if archivalName == "" {
+		http.Error(w, "resource is not archived", http.StatusNotFound)
+		return
+	}
+
+	if checkETag(w, r, `W/"`+fileVersion(archiveInfo)+`"`) {
+		return
+	}
+
+	content, contentType, err := archive.Read(archivalName)
+	checkError(err)
+
+	setSandbox(w)
+	w.Header().Set("Content-Encoding", "gzip")
+	w.Header().Set("Content-Type", contentType)
+	w.Write(content)
+}
+
 // serveBookmarkArchive is handler for GET /bookmark/:id/archive/*filepath
 func (h *handler) serveBookmarkArchive(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
 	// Get parameter from URL
@@ -196,28 +383,79 @@ func (h *handler) serveBookmarkArchive(w http.ResponseWriter, r *http.Request, p
 	checkError(err)
 
 	bookmark, exist := h.DB.GetBookmark(id, "")
-	if !exist {
-		panic(fmt.Errorf("Bookmark not found"))
+	if !exist || !canAccessBookmark(r, bookmark) {
+		panic(newClientError(http.StatusNotFound, fmt.Errorf("Bookmark not found")))
 	}
 
-	// Open archive, look in cache first
-	var archive *warc.Archive
-	cacheData, found := h.ArchiveCache.Get(strID)
-
-	if found {
-		archive = cacheData.(*warc.Archive)
-	} else {
-		archivePath := fp.Join(h.DataDir, "archive", strID)
-		archive, err = warc.Open(archivePath)
+	// Sub-resources are never changed until the archive itself is updated.
+	// The root page is not cached, since it contains bookmark's data.
+	if resourcePath != "" {
+		archiveInfo, err := os.Stat(fp.Join(h.DataDir, "archive", strID))
 		checkError(err)
 
-		h.ArchiveCache.Set(strID, archive, 0)
+		if checkETag(w, r, `W/"`+fileVersion(archiveInfo)+`"`) {
+			return
+		}
+	}
+
+	// Open archive, look in cache first
+	archive, err := h.getArchive(strID)
+	checkError(err)
+
+	h.serveArchiveResource(w, bookmark, archive, resourcePath, path.Join(h.RootPath, "bookmark", strID))
+}
+
+// serveBookmarkSnapshot is handler for GET /bookmark/:id/snapshot/:name/*filepath
+//
+// It serves a past archive of bookmark with versioned archive, just like
+// serveBookmarkArchive serves the latest one.
+func (h *handler) serveBookmarkSnapshot(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	// Get parameter from URL
+	strID := ps.ByName("id")
+	name := ps.ByName("name")
+	resourcePath := ps.ByName("filepath")
+	resourcePath = strings.TrimPrefix(resourcePath, "/")
+
+	id, err := strconv.Atoi(strID)
+	if err != nil {
+		panic(newClientError(http.StatusBadRequest, fmt.Errorf("bookmark id must be a number")))
+	}
+
+	if !core.ValidSnapshotName(name) {
+		panic(newClientError(http.StatusNotFound, fmt.Errorf("snapshot not found")))
+	}
+
+	// Get bookmark and its snapshot
+	bookmark, exist := h.DB.GetBookmark(id, "")
+	if !exist || !canAccessBookmark(r, bookmark) {
+		panic(newClientError(http.StatusNotFound, fmt.Errorf("Bookmark not found")))
+	}
+
+	snapshotInfo, err := os.Stat(fp.Join(core.SnapshotDir(h.DataDir, id), name))
+	if err != nil {
+		panic(newClientError(http.StatusNotFound, fmt.Errorf("snapshot not found")))
 	}
 
+	if resourcePath != "" && checkETag(w, r, `W/"`+fileVersion(snapshotInfo)+`"`) {
+		return
+	}
+
+	snapshot, err := h.getSnapshot(id, name)
+	checkError(err)
+
+	h.serveArchiveResource(w, bookmark, snapshot, resourcePath, path.Join(h.RootPath, "bookmark", strID))
+}
+
+// serveArchiveResource serves the resource in archive of the bookmark. The
+// root page of archive is served with Shiori header injected into it, whose
+// links are under basePath.
+func (h *handler) serveArchiveResource(w http.ResponseWriter, bookmark model.Bookmark, archive *warc.Archive, resourcePath string, basePath string) {
 	content, contentType, err := archive.Read(resourcePath)
 	checkError(err)
 
-	// Set response header
+	// Set response header. Archive is a page from other site that served
+	// under our origin, so it's served in sandbox.
+	setSandbox(w)
 	w.Header().Set("Content-Encoding", "gzip")
 	w.Header().Set("Content-Type", contentType)
 
@@ -234,7 +472,12 @@ func (h *handler) serveBookmarkArchive(w http.ResponseWriter, r *http.Request, p
 
 		// Add Shiori overlay
 		tplOutput := bytes.NewBuffer(nil)
-		err = h.templates["archive"].Execute(tplOutput, &bookmark)
+		tplData := struct {
+			BasePath string
+			Book     model.Bookmark
+		}{basePath, bookmark}
+
+		err = h.templates["archive"].Execute(tplOutput, &tplData)
 		checkError(err)
 
 		archiveCSSPath := path.Join(h.RootPath, "/css/archive.css")
@@ -1,26 +1,272 @@
 package webserver
 
 import (
+	"encoding/json"
+	"fmt"
 	"html/template"
+	"io"
+	"net/http"
+	"os"
+	"path"
+	fp "path/filepath"
+	"strconv"
+	"sync/atomic"
 
+	"shiori/internal/core"
 	"shiori/internal/database"
 	"github.com/go-shiori/warc"
 	cch "github.com/patrickmn/go-cache"
+	"github.com/sirupsen/logrus"
 )
 
 var developmentMode = false
 
+// features is list of optional API features that supported by this build,
+// so clients are able to check whether they can use it or not. Feature that
+// enabled by build tag should add itself here in init function.
+var features = []string{
+	"sync",
+	"dry-run",
+	"idempotency-key",
+	"patch",
+	"archival-policy",
+	"strict-processing",
+	"optimistic-concurrency",
+	"quota",
+	"csv-export",
+	"tag-visibility",
+	"thumbnail-batch",
+	"manual-order",
+	"regex-search",
+	"metadata",
+	"fail-on-status",
+	"tag-by-filter",
+	"import-job",
+	"archive-snapshots",
+	"search-fields",
+	"tag-last-used",
+	"schema-version",
+	"supplied-content",
+	"untagged-filter",
+	"bookmark-fields",
+	"read-only-mode",
+	"bookmark-sort",
+	"page-size",
+	"date-range",
+	"api-tokens",
+	"sessions",
+	"account-roles",
+	"bookmark-owner",
+	"ldap",
+	"oidc",
+	"login-lockout",
+	"webhooks",
+	"health-checks",
+	"atom-feed",
+	"share-links",
+	"read-status",
+	"starred",
+	"collections",
+	"tag-hierarchy",
+	"tag-deletion",
+	"tag-metadata",
+	"duplicate-urls",
+	"pinboard",
+	"wallabag",
+	"instapaper",
+	"netscape-export",
+	"backup",
+	"rearchive-schedule",
+	"link-check",
+	"wayback-fallback",
+	"singlefile",
+	"capture-import",
+}
+
+// BuildInfo is the information about the build of running server.
+type BuildInfo struct {
+	Version   string `json:"version"`
+	Commit    string `json:"commit"`
+	BuildDate string `json:"buildDate"`
+}
+
 // Handler is handler for serving the web interface.
 type handler struct {
-	DB           database.DB
-	DataDir      string
-	RootPath     string
-	UserCache    *cch.Cache
-	ArchiveCache *cch.Cache
+	DB              database.DB
+	DataDir         string
+	RootPath        string
+	ArchiveCache    *cch.Cache
+	InsertCache     *cch.Cache
+	QuotaCache      *cch.Cache
+	ImportCache     *cch.Cache
+	OIDCCache       *cch.Cache
+	MaxBodySize     int64
+	MaxUploadSize   int64
+	StrictJSON      bool
+	StrictProcess   bool
+	ArchiveOnInsert bool
+	Concurrency     int
+	ArchiveLimit    int
+	MaxResources    int
+	MaxSnapshots    int
+	MaxPageSize     int
+	InsertQuota     int
+	ArchivalQuota   int
+	Build           BuildInfo
+	ArchivalPolicy  core.ArchivalPolicy
+	RenderPolicy    core.RenderPolicy
+	KeptQueryParams core.KeptQueryParams
+	FailOnStatus    []int
+	SessionKey      []byte
+	LDAP            LDAPConfig
+	OIDC            *oidcProvider
+	LoginLimiter    *loginLimiter
+	Webhooks        *webhookDispatcher
+	Background      *backgroundWork
+	Rearchive       *rearchiveScheduler
+	Snapshots       *rearchiveScheduler
+
+	// readOnly is non-zero while the server rejects every change,
+	// e.g. while its database is backed up. Use atomic to access it.
+	readOnly int32
 
 	templates map[string]*template.Template
 }
 
+// isReadOnly checks if the server is in read-only mode.
+func (h *handler) isReadOnly() bool {
+	return atomic.LoadInt32(&h.readOnly) != 0
+}
+
+// setReadOnly enables or disables the read-only mode.
+func (h *handler) setReadOnly(readOnly bool) {
+	var value int32
+	if readOnly {
+		value = 1
+	}
+
+	atomic.StoreInt32(&h.readOnly, value)
+}
+
+// handlePanic is used to recover from panic that happened inside handler.
+func (h *handler) handlePanic(w http.ResponseWriter, r *http.Request, arg interface{}) {
+	status := http.StatusInternalServerError
+	if err, ok := arg.(error); ok {
+		if clientErr, isClientErr := err.(*clientError); isClientErr {
+			status = clientErr.status
+		} else if isBodyTooLarge(err) {
+			status = http.StatusRequestEntityTooLarge
+		}
+	}
+
+	// Client errors are logged along with the request, but the cause of
+	// server error, e.g. failing database, is only known here.
+	if status >= http.StatusInternalServerError {
+		logrus.WithFields(logrus.Fields{
+			"method": r.Method,
+			"path":   r.URL.Path,
+		}).Errorf("%v", arg)
+	}
+
+	h.writeError(w, r, status, fmt.Sprint(arg))
+}
+
+// decodeJSON decodes JSON from src into dst. In strict mode,
+// unknown fields in src are treated as error.
+func (h *handler) decodeJSON(src io.Reader, dst interface{}) error {
+	decoder := json.NewDecoder(src)
+	if h.StrictJSON {
+		decoder.DisallowUnknownFields()
+	}
+
+	err := decoder.Decode(dst)
+	switch {
+	case err == nil:
+		return nil
+	case isBodyTooLarge(err):
+		return newClientError(http.StatusRequestEntityTooLarge, err)
+	case isDecodeError(err):
+		return newClientError(http.StatusBadRequest, err)
+	default:
+		return err
+	}
+}
+
+// archivalPolicy returns the archival policy for the request. Client may
+// ignore server's policy by specifying `ignoreArchivalPolicy=true`.
+func (h *handler) archivalPolicy(r *http.Request) core.ArchivalPolicy {
+	ignorePolicy, _ := strconv.ParseBool(r.URL.Query().Get("ignoreArchivalPolicy"))
+	if ignorePolicy {
+		return core.ArchivalPolicy{}
+	}
+
+	return h.ArchivalPolicy
+}
+
+// strictProcessing returns whether bookmark that failed to be processed
+// should not be saved. Client may override the server default using `strict`.
+func (h *handler) strictProcessing(r *http.Request) bool {
+	strict, err := strconv.ParseBool(r.URL.Query().Get("strict"))
+	if err != nil {
+		return h.StrictProcess
+	}
+
+	return strict
+}
+
+// failOnStatus returns whether new bookmark whose page is downloaded
+// with the specified status code should be rejected.
+func (h *handler) failOnStatus(statusCode int) bool {
+	for _, code := range h.FailOnStatus {
+		if code == statusCode {
+			return true
+		}
+	}
+
+	return false
+}
+
+// getArchive opens the archive of bookmark with the specified ID. The opened
+// archive is kept in cache, and closed once it's evicted from the cache.
+func (h *handler) getArchive(strID string) (*warc.Archive, error) {
+	return h.openArchive(strID, fp.Join(h.DataDir, "archive", strID))
+}
+
+// getSnapshot opens the snapshot of bookmark's archive with the specified
+// name. Like getArchive, the opened snapshot is kept in cache.
+func (h *handler) getSnapshot(id int, name string) (*warc.Archive, error) {
+	key := fmt.Sprintf("%d/%s", id, name)
+	return h.openArchive(key, fp.Join(core.SnapshotDir(h.DataDir, id), name))
+}
+
+// openArchive opens the archive at archivePath, or returns
+// the one that already opened and cached with the key.
+func (h *handler) openArchive(key string, archivePath string) (*warc.Archive, error) {
+	if cacheData, found := h.ArchiveCache.Get(key); found {
+		return cacheData.(*warc.Archive), nil
+	}
+
+	archive, err := warc.Open(archivePath)
+	if err != nil {
+		return nil, err
+	}
+
+	h.ArchiveCache.Set(key, archive, 0)
+	return archive, nil
+}
+
+// thumbnailURL returns URL of the bookmark's thumbnail, or empty string if
+// it doesn't have any. The URL contains version of the thumbnail, so it's
+// changed whenever the thumbnail is updated and may be cached for long.
+func (h *handler) thumbnailURL(strID string) string {
+	info, err := os.Stat(fp.Join(h.DataDir, "thumb", strID))
+	if err != nil {
+		return ""
+	}
+
+	return path.Join(h.RootPath, "bookmark", strID, "thumb") + "?v=" + fileVersion(info)
+}
+
 func (h *handler) prepareArchiveCache() {
 	h.ArchiveCache.OnEvicted(func(key string, data interface{}) {
 		archive := data.(*warc.Archive)
@@ -51,9 +297,9 @@ func (h *handler) prepareTemplates() error {
 	// Create template for archive overlay
 	h.templates["archive"], err = template.New("archive").Delims("$$", "$$").Parse(
 		`<div id="shiori-archive-header">
-		<a href="$$.URL$$" target="_blank">View Original</a>
-		$$if .HasContent$$
-		<a href="/bookmark/$$.ID$$/content">View Readable</a>
+		<a href="$$.Book.URL$$">View Original</a>
+		$$if .Book.HasContent$$
+		<a href="$$.BasePath$$/content">View Readable</a>
 		$$end$$
 		</div>`)
 	if err != nil {
@@ -0,0 +1,199 @@
+package webserver
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/julienschmidt/httprouter"
+	"shiori/internal/model"
+)
+
+func Test_decodeJSON(t *testing.T) {
+	tests := []struct {
+		name    string
+		strict  bool
+		body    string
+		wantErr bool
+	}{{
+		name:    "known fields in strict mode",
+		strict:  true,
+		body:    `{"id": 1, "title": "Title", "excerpt": "Excerpt"}`,
+		wantErr: false,
+	}, {
+		name:    "unknown field in strict mode",
+		strict:  true,
+		body:    `{"id": 1, "title": "Title", "excrpt": "Excerpt"}`,
+		wantErr: true,
+	}, {
+		name:    "unknown field in lenient mode",
+		strict:  false,
+		body:    `{"id": 1, "title": "Title", "excrpt": "Excerpt"}`,
+		wantErr: false,
+	}}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			hdl := &handler{StrictJSON: tt.strict}
+
+			book := model.Bookmark{}
+			err := hdl.decodeJSON(strings.NewReader(tt.body), &book)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("decodeJSON() error = %v, wantErr %v", err, tt.wantErr)
+				return
+			}
+
+			if err == nil && (book.ID != 1 || book.Title != "Title") {
+				t.Errorf("decodeJSON() = %+v, known fields are not decoded", book)
+			}
+		})
+	}
+}
+
+func Test_strictJSONStatus(t *testing.T) {
+	hdl := &handler{StrictJSON: true}
+
+	router := httprouter.New()
+	router.PUT("/api/bookmarks", hdl.apiUpdateBookmark)
+	router.PanicHandler = hdl.handlePanic
+
+	body := `{"id": 1, "title": "Title", "excrpt": "Excerpt"}`
+	req := newOwnerRequest("PUT", "/api/bookmarks", strings.NewReader(body))
+	rec := httptest.NewRecorder()
+	router.ServeHTTP(rec, req)
+
+	if rec.Code != http.StatusBadRequest {
+		t.Errorf("apiUpdateBookmark() status = %d, want %d", rec.Code, http.StatusBadRequest)
+	}
+}
+
+func Test_strictProcessing(t *testing.T) {
+	tests := []struct {
+		name          string
+		serverDefault bool
+		url           string
+		want          bool
+	}{
+		{"lenient by default", false, "/api/bookmarks", false},
+		{"strict by server", true, "/api/bookmarks", true},
+		{"strict by request", false, "/api/bookmarks?strict=true", true},
+		{"lenient by request", true, "/api/bookmarks?strict=false", false},
+		{"invalid value uses server default", true, "/api/bookmarks?strict=maybe", true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			hdl := &handler{StrictProcess: tt.serverDefault}
+			req := httptest.NewRequest("POST", tt.url, nil)
+			if got := hdl.strictProcessing(req); got != tt.want {
+				t.Errorf("strictProcessing() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+// bodyTooLargeError returns the error of reading request body over its limit.
+func bodyTooLargeError() error {
+	body := http.MaxBytesReader(httptest.NewRecorder(), ioutil.NopCloser(strings.NewReader("too large")), 1)
+	_, err := ioutil.ReadAll(body)
+	return err
+}
+
+func Test_handlePanic(t *testing.T) {
+	hdl := &handler{}
+	tests := []struct {
+		name       string
+		arg        interface{}
+		wantStatus int
+	}{
+		{"bad request", newClientError(http.StatusBadRequest, fmt.Errorf("title must not empty")), http.StatusBadRequest},
+		{"not found", newClientError(http.StatusNotFound, fmt.Errorf("bookmark not found")), http.StatusNotFound},
+		{"body too large", bodyTooLargeError(), http.StatusRequestEntityTooLarge},
+		{"server error", fmt.Errorf("failed to save bookmark"), http.StatusInternalServerError},
+		{"non error", "something went wrong", http.StatusInternalServerError},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rec := httptest.NewRecorder()
+			hdl.handlePanic(rec, newOwnerRequest("GET", "/", nil), tt.arg)
+
+			if rec.Code != tt.wantStatus {
+				t.Errorf("handlePanic() status = %d, want %d", rec.Code, tt.wantStatus)
+			}
+
+			if got := strings.TrimSpace(rec.Body.String()); got != fmt.Sprint(tt.arg) {
+				t.Errorf("handlePanic() body = %q, want %q", got, fmt.Sprint(tt.arg))
+			}
+		})
+	}
+}
+
+func Test_handlePanicAPI(t *testing.T) {
+	hdl := &handler{RootPath: "/shiori/"}
+	tests := []struct {
+		name       string
+		arg        interface{}
+		wantStatus int
+		wantCode   string
+	}{
+		{"bad request", newClientError(http.StatusBadRequest, fmt.Errorf("title must not empty")), http.StatusBadRequest, "invalid_request"},
+		{"not found", newClientError(http.StatusNotFound, fmt.Errorf("bookmark not found")), http.StatusNotFound, "not_found"},
+		{"validation", newClientError(http.StatusUnprocessableEntity, fmt.Errorf("url is invalid")), http.StatusUnprocessableEntity, "validation_failed"},
+		{"body too large", bodyTooLargeError(), http.StatusRequestEntityTooLarge, "request_too_large"},
+		{"server error", fmt.Errorf("failed to save bookmark"), http.StatusInternalServerError, "internal_error"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rec := httptest.NewRecorder()
+			hdl.handlePanic(rec, newOwnerRequest("GET", "/shiori/api/bookmarks", nil), tt.arg)
+
+			if rec.Code != tt.wantStatus {
+				t.Errorf("handlePanic() status = %d, want %d", rec.Code, tt.wantStatus)
+			}
+
+			if got := rec.Header().Get("Content-Type"); got != "application/json" {
+				t.Errorf("handlePanic() content type = %q, want application/json", got)
+			}
+
+			var body apiError
+			if err := json.NewDecoder(rec.Body).Decode(&body); err != nil {
+				t.Fatalf("failed to decode error body: %v", err)
+			}
+
+			want := apiError{Error: fmt.Sprint(tt.arg), Code: tt.wantCode}
+			if body != want {
+				t.Errorf("handlePanic() body = %+v, want %+v", body, want)
+			}
+		})
+	}
+}
+
+func Test_decodeJSONClientError(t *testing.T) {
+	hdl := &handler{}
+	tests := []struct {
+		name string
+		body string
+	}{
+		{"empty body", ""},
+		{"malformed JSON", `{"id": 1`},
+		{"wrong type", `{"id": "one"}`},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			book := model.Bookmark{}
+			err := hdl.decodeJSON(strings.NewReader(tt.body), &book)
+
+			clientErr, ok := err.(*clientError)
+			if !ok || clientErr.status != http.StatusBadRequest {
+				t.Errorf("decodeJSON() error = %#v, want client error with status 400", err)
+			}
+		})
+	}
+}
@@ -0,0 +1,201 @@
+package webserver
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"os/signal"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/julienschmidt/httprouter"
+	"github.com/sirupsen/logrus"
+)
+
+// errShuttingDown is returned when background work is started
+// while the server is shutting down.
+var errShuttingDown = errors.New("server is shutting down")
+
+// probeRoutes is list of routes that polled by orchestrator like Kubernetes,
+// so they're only logged in debug level.
+var probeRoutes = []string{
+	"/healthz",
+	"/readyz",
+}
+
+// backgroundWork keeps track of work that runs after its request finished,
+// e.g. import job or scheduled archival, so the server can wait for them
+// before it exits. Once closed, no more work may be started.
+type backgroundWork struct {
+	sync.Mutex
+	wg     sync.WaitGroup
+	closed bool
+}
+
+// begin registers new work, then returns false if the server is shutting
+// down so the work must not be started. Nil tracker accepts every work.
+func (b *backgroundWork) begin() bool {
+	if b == nil {
+		return true
+	}
+
+	b.Lock()
+	defer b.Unlock()
+
+	if b.closed {
+		return false
+	}
+
+	b.wg.Add(1)
+	return true
+}
+
+// done marks the work that registered in begin as finished.
+func (b *backgroundWork) done() {
+	if b != nil {
+		b.wg.Done()
+	}
+}
+
+// isClosed checks if the server is shutting down.
+func (b *backgroundWork) isClosed() bool {
+	if b == nil {
+		return false
+	}
+
+	b.Lock()
+	defer b.Unlock()
+	return b.closed
+}
+
+// drain rejects new work, then waits until the running ones finished
+// or the context is done, whichever comes first.
+func (b *backgroundWork) drain(ctx context.Context) error {
+	b.Lock()
+	b.closed = true
+	b.Unlock()
+
+	finished := make(chan struct{})
+	go func() {
+		b.wg.Wait()
+		close(finished)
+	}()
+
+	select {
+	case <-finished:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// serveUntilShutdown serves the server using listen until it receives
+// SIGINT or SIGTERM. Then the server stops accepting new connections, and
+// waits for the requests and background work that still running, e.g.
+// archival, before it returns. Whatever still running after the timeout
+// is abandoned.
+func (h *handler) serveUntilShutdown(svr *http.Server, listen func() error, timeout time.Duration) error {
+	errs := make(chan error, 1)
+	go func() {
+		errs <- listen()
+	}()
+
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, os.Interrupt, syscall.SIGTERM)
+	defer signal.Stop(signals)
+
+	select {
+	case err := <-errs:
+		return err
+	case sig := <-signals:
+		logrus.WithField("signal", sig.String()).Info("shutting down, waiting for running work to finish")
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	// New background work is rejected while the running requests finish
+	drained := make(chan error, 1)
+	go func() {
+		drained <- h.Background.drain(ctx)
+	}()
+
+	if err := svr.Shutdown(ctx); err != nil {
+		return fmt.Errorf("failed to finish running requests: %v", err)
+	}
+
+	if err := <-drained; err != nil {
+		return fmt.Errorf("failed to finish background work: %v", err)
+	}
+
+	logrus.Info("server stopped")
+	return nil
+}
+
+// serveHealth is handler for GET /healthz
+//
+// It only tells that the process is up, so orchestrator like Kubernetes
+// restarts it once it stops responding.
+func (h *handler) serveHealth(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	resp := map[string]string{"status": "ok"}
+
+	w.Header().Set("Content-Type", "application/json")
+	err := json.NewEncoder(w).Encode(&resp)
+	checkError(err)
+}
+
+// serveReadiness is handler for GET /readyz
+//
+// It checks whether the server is able to serve requests, i.e. its database
+// is reachable and its data directory is writable. While the server is
+// shutting down it's never ready, so no more traffic is routed to it. It
+// responds with 503 when any of the checks failed.
+func (h *handler) serveReadiness(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	checks := map[string]string{
+		"database": "ok",
+		"dataDir":  "ok",
+	}
+
+	ready := true
+	fail := func(name string, err error) {
+		checks[name] = err.Error()
+		ready = false
+	}
+
+	if _, err := h.DB.SchemaVersion(); err != nil {
+		fail("database", err)
+	}
+
+	if tmpFile, err := ioutil.TempFile(h.DataDir, ".readyz-"); err != nil {
+		fail("dataDir", err)
+	} else {
+		tmpFile.Close()
+		os.Remove(tmpFile.Name())
+	}
+
+	if h.Background.isClosed() {
+		checks["shutdown"] = "server is shutting down"
+		ready = false
+	}
+
+	status := http.StatusOK
+	resp := map[string]interface{}{
+		"status": "ready",
+		"checks": checks,
+	}
+
+	if !ready {
+		status = http.StatusServiceUnavailable
+		resp["status"] = "not ready"
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	err := json.NewEncoder(w).Encode(&resp)
+	checkError(err)
+}
@@ -0,0 +1,111 @@
+package webserver
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	fp "path/filepath"
+	"testing"
+	"time"
+)
+
+func Test_backgroundWork(t *testing.T) {
+	work := &backgroundWork{}
+	if !work.begin() {
+		t.Fatalf("begin() before drain = false, want true")
+	}
+
+	// Drain waits for the running work, but rejects new one meanwhile
+	drained := make(chan error, 1)
+	go func() {
+		drained <- work.drain(context.Background())
+	}()
+
+	deadline := time.Now().Add(5 * time.Second)
+	for !work.isClosed() && time.Now().Before(deadline) {
+		time.Sleep(time.Millisecond)
+	}
+
+	if work.begin() {
+		t.Errorf("begin() while draining = true, want false")
+	}
+
+	select {
+	case err := <-drained:
+		t.Fatalf("drain() returned %v before work is done", err)
+	case <-time.After(20 * time.Millisecond):
+	}
+
+	work.done()
+	if err := <-drained; err != nil {
+		t.Errorf("drain() error = %v", err)
+	}
+
+	// Drain gives up once the context is done
+	work = &backgroundWork{}
+	work.begin()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	if err := work.drain(ctx); err != context.DeadlineExceeded {
+		t.Errorf("drain() with running work error = %v, want %v", err, context.DeadlineExceeded)
+	}
+}
+
+func Test_serveReadiness(t *testing.T) {
+	tests := []struct {
+		name       string
+		dataDir    string
+		shutdown   bool
+		wantStatus int
+		wantFailed string
+	}{
+		{"ready", "", false, http.StatusOK, ""},
+		{"data dir missing", "missing", false, http.StatusServiceUnavailable, "dataDir"},
+		{"shutting down", "", true, http.StatusServiceUnavailable, "shutdown"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			hdl, cleanup := newTestHandler(t)
+			defer cleanup()
+
+			hdl.Background = &backgroundWork{}
+			if tt.dataDir != "" {
+				hdl.DataDir = fp.Join(hdl.DataDir, tt.dataDir)
+			}
+
+			if tt.shutdown {
+				hdl.Background.drain(context.Background())
+			}
+
+			req := httptest.NewRequest("GET", "/readyz", nil)
+			rec := httptest.NewRecorder()
+			hdl.serveReadiness(rec, req, nil)
+
+			if rec.Code != tt.wantStatus {
+				t.Errorf("serveReadiness() status = %d, want %d: %s", rec.Code, tt.wantStatus, rec.Body)
+			}
+
+			resp := struct {
+				Status string            `json:"status"`
+				Checks map[string]string `json:"checks"`
+			}{}
+
+			if err := json.NewDecoder(rec.Body).Decode(&resp); err != nil {
+				t.Fatal(err)
+			}
+
+			if tt.wantFailed == "" {
+				for name, result := range resp.Checks {
+					if result != "ok" {
+						t.Errorf("check %s = %q, want ok", name, result)
+					}
+				}
+			} else if result := resp.Checks[tt.wantFailed]; result == "" || result == "ok" {
+				t.Errorf("check %s = %q, want failure", tt.wantFailed, result)
+			}
+		})
+	}
+}
@@ -0,0 +1,662 @@
+package webserver
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"shiori/internal/core"
+	"shiori/internal/model"
+	cch "github.com/patrickmn/go-cache"
+	"github.com/sirupsen/logrus"
+)
+
+// importBatchSize is the number of imported bookmarks saved at once.
+const importBatchSize = 100
+
+// Status of import job.
+const (
+	importRunning   = "running"
+	importFinished  = "finished"
+	importCancelled = "cancelled"
+	importFailed    = "failed"
+)
+
+// importProgress is the progress of import job. Total is only known once
+// the whole file has been read. Processed counts every bookmark that has
+// been handled, including the skipped duplicates and the failed ones.
+// Archived and ArchiveFailed are only counted for bookmarks that archived,
+// i.e. when the job fetches archives or the file has their content.
+type importProgress struct {
+	ID            string `json:"id"`
+	Status        string `json:"status"`
+	Total         int    `json:"total"`
+	Processed     int    `json:"processed"`
+	Skipped       int    `json:"skipped"`
+	Failed        int    `json:"failed"`
+	Archived      int    `json:"archived"`
+	ArchiveFailed int    `json:"archiveFailed"`
+	Error         string `json:"error,omitempty"`
+}
+
+// Result of each entry in imported file.
+const (
+	entryImported = "imported"
+	entrySkipped  = "skipped"
+	entryFailed   = "failed"
+)
+
+// importResult is the result of an entry in imported file, so client can
+// tell which entries are not imported and why. Input is the URL as it's
+// written in the file.
+type importResult struct {
+	Input  string `json:"input"`
+	Status string `json:"status"`
+	Reason string `json:"reason,omitempty"`
+}
+
+// Format of imported file.
+const (
+	importNetscape   = "netscape"
+	importPocket     = "pocket"
+	importPinboard   = "pinboard"
+	importWallabag   = "wallabag"
+	importInstapaper = "instapaper"
+)
+
+// importOptions is the options of import job.
+type importOptions struct {
+	// Format is the format of imported file, either importNetscape,
+	// which is the default when it's empty, importPocket, importPinboard,
+	// importWallabag or importInstapaper.
+	Format string
+
+	// GenerateTag adds the innermost folder of bookmark as its tag.
+	GenerateTag bool
+
+	// KeepFolders puts bookmarks in collections that mirror their folders.
+	KeepFolders bool
+
+	// Fetch downloads and archives every imported bookmark once it's saved.
+	Fetch bool
+
+	// OwnerID is the account that owns the imported bookmarks.
+	OwnerID int
+
+	// QuotaAccount is the account whose archival quota is used by the
+	// fetched bookmarks, as returned by quotaAccount.
+	QuotaAccount string
+}
+
+// importJob imports bookmarks from uploaded file in background.
+// Results are ordered like the entries in the file.
+type importJob struct {
+	sync.Mutex
+	importOptions
+	progress importProgress
+	results  []importResult
+	cancel   context.CancelFunc
+}
+
+// Progress returns the current progress of the job.
+func (job *importJob) Progress() importProgress {
+	job.Lock()
+	defer job.Unlock()
+	return job.progress
+}
+
+// Results returns the results of entries that have been processed.
+func (job *importJob) Results() []importResult {
+	job.Lock()
+	defer job.Unlock()
+
+	results := []importResult{}
+	for _, result := range job.results {
+		if result.Status != "" {
+			results = append(results, result)
+		}
+	}
+
+	return results
+}
+
+// update changes the progress of the job.
+func (job *importJob) update(fn func(progress *importProgress)) {
+	job.Lock()
+	defer job.Unlock()
+	fn(&job.progress)
+}
+
+// report records the result of the entry at the index in imported file,
+// and counts it in the progress.
+func (job *importJob) report(index int, result importResult) {
+	job.Lock()
+	defer job.Unlock()
+
+	for len(job.results) <= index {
+		job.results = append(job.results, importResult{})
+	}
+	job.results[index] = result
+
+	job.progress.Processed++
+	switch result.Status {
+	case entrySkipped:
+		job.progress.Skipped++
+	case entryFailed:
+		job.progress.Failed++
+	}
+}
+
+// importEntry is a bookmark that read from the entry at the index in
+// imported file, waiting to be saved.
+type importEntry struct {
+	index int
+	input string
+	book  model.Bookmark
+}
+
+// newImportJobID creates random ID for import job.
+func newImportJobID() (string, error) {
+	buf := make([]byte, 16)
+	if _, err := rand.Read(buf); err != nil {
+		return "", err
+	}
+
+	return hex.EncodeToString(buf), nil
+}
+
+// startImportJob starts importing bookmarks from the file in the format of
+// the options. The file is removed once the job finished.
+func (h *handler) startImportJob(srcPath string, opts importOptions) (*importJob, error) {
+	id, err := newImportJobID()
+	if err != nil {
+		return nil, err
+	}
+
+	if !h.Background.begin() {
+		return nil, errShuttingDown
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	job := &importJob{
+		importOptions: opts,
+		cancel:        cancel,
+		progress: importProgress{
+			ID:     id,
+			Status: importRunning,
+		},
+	}
+
+	// Running job never expires, it's only kept for a while once finished
+	h.ImportCache.Set(id, job, cch.NoExpiration)
+
+	go func() {
+		defer h.Background.done()
+		defer os.Remove(srcPath)
+		h.runImportJob(ctx, job, srcPath)
+		h.ImportCache.Set(id, job, cch.DefaultExpiration)
+	}()
+
+	return job, nil
+}
+
+// runImportJob reads the bookmarks from file and saves them in batches,
+// which run in parallel as many as the server's concurrency allows. When
+// the job is cancelled, bookmarks that already saved are kept, while the
+// ones that are not archived yet stay without archive.
+func (h *handler) runImportJob(ctx context.Context, job *importJob, srcPath string) {
+	// Count the bookmarks first, so client knows how long it will take
+	total := 0
+	err := parseImportFile(srcPath, job.Format, func(importItem) error {
+		total++
+		return ctx.Err()
+	})
+
+	if err == nil {
+		job.update(func(progress *importProgress) {
+			progress.Total = total
+		})
+	}
+
+	// Save the bookmarks in batches, using bounded number of workers.
+	// Worker is reserved before the batch is dispatched, so the file
+	// is never read much faster than the bookmarks are saved.
+	concurrency := h.Concurrency
+	if concurrency < 1 {
+		concurrency = 1
+	}
+
+	wg := sync.WaitGroup{}
+	semaphore := make(chan struct{}, concurrency)
+
+	dispatch := func(batch []importEntry) {
+		wg.Add(1)
+		semaphore <- struct{}{}
+		go func() {
+			defer wg.Done()
+			defer func() {
+				<-semaphore
+			}()
+
+			saved := h.saveImportBatch(job, batch)
+			h.archiveImportBatch(ctx, job, saved)
+		}()
+	}
+
+	skip := func(index int, input string, status string, reason string) {
+		job.report(index, importResult{Input: input, Status: status, Reason: reason})
+	}
+
+	index := -1
+	batch := []importEntry{}
+	mapURL := map[string]struct{}{}
+	defaultPublic := h.tagDefaultPublic()
+
+	// Existing collections are reused, so the folders won't be duplicated
+	// when the same file is imported again
+	collectionIDs := map[string]int{}
+	if err == nil && job.KeepFolders {
+		var collections []model.Collection
+		collections, err = h.DB.GetCollections(job.OwnerID)
+		for _, collection := range collections {
+			collectionIDs[collectionKey(collection.ParentID, collection.Name)] = collection.ID
+		}
+	}
+
+	if err == nil {
+		err = parseImportFile(srcPath, job.Format, func(item importItem) error {
+			if err := ctx.Err(); err != nil {
+				return err
+			}
+			index++
+
+			// Clean up URL, then make sure it doesn't already exist
+			// in the imported file or in the database
+			url, err := core.RemoveUTMParams(item.URL, h.KeptQueryParams)
+			if err != nil {
+				skip(index, item.URL, entryFailed, "URL is not valid")
+				return nil
+			}
+
+			if _, exist := mapURL[url]; exist {
+				skip(index, item.URL, entrySkipped, "URL is duplicated in the file")
+				return nil
+			}
+
+			mapURL[url] = struct{}{}
+			_, exist, err := h.findBookmarkByURL(job.OwnerID, url)
+			if err != nil {
+				return err
+			} else if exist {
+				skip(index, item.URL, entrySkipped, "URL already exists")
+				return nil
+			}
+
+			// Create the bookmark, with its folder as tag if needed
+			book := model.Bookmark{
+				URL:     url,
+				Title:   item.Title,
+				Excerpt: item.Excerpt,
+				OwnerID: job.OwnerID,
+				Read:    item.Read,
+				Starred: item.Starred,
+			}
+
+			core.EnsureTitle(&book)
+
+			// Content in the file is archived once the bookmark is saved
+			if item.Content != "" {
+				content, err := suppliedPage(item.Content, "")
+				if err != nil {
+					logrus.WithError(err).WithField("url", book.URL).Warn("failed to read content of imported bookmark")
+				} else {
+					book.HTML = string(content)
+				}
+			}
+
+			if item.AddDate > 0 {
+				book.Created = time.Unix(item.AddDate, 0).UTC().Format("2006-01-02 15:04:05")
+			}
+
+			for _, tag := range item.Tags {
+				book.Tags = append(book.Tags, model.Tag{Name: tag})
+			}
+
+			if job.GenerateTag && item.Folder != "" {
+				book.Tags = append(book.Tags, model.Tag{Name: item.Folder})
+			}
+
+			if job.KeepFolders && len(item.Folders) > 0 {
+				book.CollectionID, err = h.importCollection(item.Folders, job.OwnerID, collectionIDs)
+				if err != nil {
+					logrus.WithError(err).WithField("url", book.URL).Warn("failed to create collection for imported bookmark")
+					skip(index, item.URL, entryFailed, "failed to create collection: "+err.Error())
+					return nil
+				}
+			}
+
+			// Visibility in the file wins over the default of its tags
+			if item.Public != nil {
+				book.Public = *item.Public
+			} else {
+				applyTagDefaultPublic(&book, nil, defaultPublic)
+			}
+
+			batch = append(batch, importEntry{index, item.URL, book})
+			if len(batch) == importBatchSize {
+				dispatch(batch)
+				batch = []importEntry{}
+			}
+
+			return nil
+		})
+	}
+
+	if err == nil && len(batch) > 0 {
+		dispatch(batch)
+	}
+
+	wg.Wait()
+
+	// Report the final status
+	job.update(func(progress *importProgress) {
+		switch {
+		case ctx.Err() != nil:
+			progress.Status = importCancelled
+		case err != nil:
+			progress.Status = importFailed
+			progress.Error = err.Error()
+		default:
+			progress.Status = importFinished
+		}
+	})
+}
+
+// saveImportBatch saves imported bookmarks at once. If it failed, e.g. when
+// one of them conflicts with bookmark that inserted in the meantime, they're
+// saved one by one so only the problematic ones are lost. The result of each
+// entry is reported to the job, and the bookmarks that saved successfully
+// are returned.
+func (h *handler) saveImportBatch(job *importJob, batch []importEntry) []model.Bookmark {
+	bookmarks := make([]model.Bookmark, len(batch))
+	for i, entry := range batch {
+		bookmarks[i] = entry.book
+	}
+
+	if saved, err := h.DB.SaveBookmarks(bookmarks...); err == nil {
+		h.saveImportedStatus(saved)
+		h.Webhooks.send(eventBookmarkCreated, saved...)
+		for _, entry := range batch {
+			job.report(entry.index, importResult{Input: entry.input, Status: entryImported})
+		}
+		return saved
+	}
+
+	result := []model.Bookmark{}
+	for _, entry := range batch {
+		saved, err := h.DB.SaveBookmarks(entry.book)
+		if err != nil {
+			logrus.WithError(err).WithField("url", entry.book.URL).Warn("failed to import bookmark")
+			job.report(entry.index, importResult{Input: entry.input, Status: entryFailed, Reason: err.Error()})
+			continue
+		}
+
+		h.saveImportedStatus(saved)
+		h.Webhooks.send(eventBookmarkCreated, saved...)
+		job.report(entry.index, importResult{Input: entry.input, Status: entryImported})
+		result = append(result, saved...)
+	}
+
+	return result
+}
+
+// saveImportedStatus marks the imported bookmarks as read and starred
+// following the file, since they're not saved together with bookmark.
+// Bookmark whose status failed to be saved is still kept. Every change
+// increases the version of bookmark, which is followed in the bookmarks,
+// so they can be archived and saved again without conflict.
+func (h *handler) saveImportedStatus(bookmarks []model.Bookmark) {
+	readIDs := []int{}
+	for i, book := range bookmarks {
+		if book.Read {
+			readIDs = append(readIDs, book.ID)
+		}
+
+		if book.Starred {
+			if err := h.DB.SetBookmarkStarred(book.ID, true); err != nil {
+				logrus.WithError(err).WithField("url", book.URL).Warn("failed to star imported bookmark")
+			} else {
+				bookmarks[i].Version++
+			}
+		}
+	}
+
+	if _, err := h.DB.SetBookmarksRead(readIDs, true); err != nil {
+		logrus.WithError(err).Warn("failed to mark imported bookmarks as read")
+		return
+	}
+
+	for i, book := range bookmarks {
+		if book.Read {
+			bookmarks[i].Version++
+		}
+	}
+}
+
+// archiveImportBatch archives the imported bookmarks one by one, until the
+// job is cancelled. Bookmark whose content is in the file is archived from
+// it, while the others are only downloaded when the job fetches archives,
+// each one once the archival quota of the job's account allows it.
+// Bookmark that failed to be archived is still kept, like bookmark that
+// inserted while its page is unreachable.
+func (h *handler) archiveImportBatch(ctx context.Context, job *importJob, bookmarks []model.Bookmark) {
+	for _, book := range bookmarks {
+		if ctx.Err() != nil {
+			return
+		}
+
+		var err error
+		switch {
+		case book.HTML != "":
+			err = h.archiveImportedContent(book)
+		case job.Fetch:
+			// Fetched page uses the archival quota like any other archival
+			var releaseQuota func()
+			releaseQuota, err = h.waitArchivalQuota(ctx, job.QuotaAccount)
+			if ctx.Err() != nil {
+				return
+			}
+
+			if err == nil {
+				err = h.archiveAgain(book)
+				releaseQuota()
+			}
+		default:
+			continue
+		}
+
+		if err != nil {
+			logrus.WithError(err).WithField("url", book.URL).Warn("failed to archive imported bookmark")
+		}
+
+		job.update(func(progress *importProgress) {
+			if err != nil {
+				progress.ArchiveFailed++
+			} else {
+				progress.Archived++
+			}
+		})
+	}
+}
+
+// archiveImportedContent archives the bookmark from its HTML, which is the
+// page read from the imported file, e.g. the article saved by Wallabag, so
+// it's kept even when the page is no longer reachable.
+func (h *handler) archiveImportedContent(book model.Bookmark) error {
+	book.CreateArchive = true
+
+	request := core.ProcessRequest{
+		DataDir:        h.DataDir,
+		Bookmark:       book,
+		Content:        strings.NewReader(book.HTML),
+		ContentType:    "text/html; charset=UTF-8",
+		KeepTitle:      true,
+		KeepExcerpt:    true,
+		MaxResources:   h.MaxResources,
+		MaxSnapshots:   h.MaxSnapshots,
+		RenderPolicy:   h.RenderPolicy,
+		ArchivalPolicy: h.ArchivalPolicy,
+	}
+
+	book, _, err := core.ProcessBookmark(request)
+	if err != nil {
+		return err
+	}
+
+	saved, err := h.DB.SaveBookmarks(book)
+	if err != nil {
+		return err
+	}
+
+	h.Webhooks.send(eventBookmarkArchived, saved...)
+	return nil
+}
+
+// collectionKey identifies collection by its parent and name.
+func collectionKey(parentID int, name string) string {
+	return strconv.Itoa(parentID) + "/" + name
+}
+
+// importCollection returns ID of the collection for the imported folders,
+// outermost first, creating the ones that don't exist yet in collectionIDs.
+func (h *handler) importCollection(folders []string, ownerID int, collectionIDs map[string]int) (int, error) {
+	parentID := 0
+	for _, name := range folders {
+		key := collectionKey(parentID, name)
+		id, exist := collectionIDs[key]
+		if !exist {
+			collection, err := h.DB.SaveCollection(model.Collection{
+				Name:     name,
+				ParentID: parentID,
+				OwnerID:  ownerID,
+			})
+			if err != nil {
+				return 0, err
+			}
+
+			id = collection.ID
+			collectionIDs[key] = id
+		}
+
+		parentID = id
+	}
+
+	return parentID, nil
+}
+
+// importItem is a bookmark read from imported file, whatever its format.
+// Folders are only known in Netscape Bookmark format, while the other fields
+// are only known in the exports of read-it-later services.
+type importItem struct {
+	core.NetscapeBookmark
+	Excerpt string
+	Content string // HTML of the page, which is archived as it is
+	Read    bool
+	Starred bool
+	Public  *int // nil when the file doesn't specify it
+}
+
+// parseImportFile reads bookmarks from file in the specified format.
+func parseImportFile(srcPath string, format string, fn func(importItem) error) error {
+	srcFile, err := os.Open(srcPath)
+	if err != nil {
+		return err
+	}
+	defer srcFile.Close()
+
+	switch format {
+	case importPocket:
+		return core.ParsePocketBookmarks(srcFile, func(book core.PocketBookmark) error {
+			return fn(importItem{
+				NetscapeBookmark: core.NetscapeBookmark{
+					URL:     book.URL,
+					Title:   book.Title,
+					Tags:    book.Tags,
+					AddDate: book.AddDate,
+				},
+				Read:    book.Read,
+				Starred: book.Favorite,
+			})
+		})
+
+	case importPinboard:
+		return core.ParsePinboardBookmarks(srcFile, func(book core.PinboardBookmark) error {
+			public := 0
+			if book.IsShared() {
+				public = 1
+			}
+
+			return fn(importItem{
+				NetscapeBookmark: core.NetscapeBookmark{
+					URL:     book.Href,
+					Title:   strings.Join(strings.Fields(book.Description), " "),
+					Tags:    book.TagNames(),
+					AddDate: book.AddDate(),
+				},
+				Excerpt: strings.TrimSpace(book.Extended),
+				Read:    !book.IsToRead(),
+				Public:  &public,
+			})
+		})
+
+	case importWallabag:
+		return core.ParseWallabagEntries(srcFile, func(entry core.WallabagEntry) error {
+			public := 0
+			if entry.Public {
+				public = 1
+			}
+
+			return fn(importItem{
+				NetscapeBookmark: core.NetscapeBookmark{
+					URL:     entry.URL,
+					Title:   entry.Title,
+					Tags:    entry.Tags,
+					AddDate: entry.CreatedAt,
+				},
+				Content: entry.Content,
+				Read:    entry.Archived,
+				Starred: entry.Starred,
+				Public:  &public,
+			})
+		})
+
+	case importInstapaper:
+		return core.ParseInstapaperBookmarks(srcFile, func(book core.InstapaperBookmark) error {
+			item := importItem{
+				NetscapeBookmark: core.NetscapeBookmark{
+					URL:     book.URL,
+					Title:   book.Title,
+					AddDate: book.AddDate,
+				},
+				Excerpt: book.Selection,
+				Read:    book.Read,
+				Starred: book.Starred,
+			}
+
+			if book.Folder != "" {
+				item.Tags = []string{book.Folder}
+			}
+
+			return fn(item)
+		})
+	}
+
+	return core.ParseNetscapeBookmarks(srcFile, func(book core.NetscapeBookmark) error {
+		return fn(importItem{NetscapeBookmark: book})
+	})
+}
@@ -0,0 +1,549 @@
+package webserver
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	fp "path/filepath"
+	"reflect"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+
+	"shiori/internal/core"
+	"shiori/internal/database"
+	"shiori/internal/model"
+	"github.com/julienschmidt/httprouter"
+)
+
+const importedFile = `<!DOCTYPE NETSCAPE-Bookmark-file-1>
+<DL><p>
+	<DT><A HREF="https://example.com/existing">Existing</A>
+	<DT><H3>Reading</H3>
+	<DL><p>
+		<DT><A HREF="https://example.com/a?utm_source=mail" ADD_DATE="1500000000" TAGS="later">A</A>
+		<DT><A HREF="https://example.com/a">A again</A>
+		<DT><A HREF="not a url">Invalid</A>
+		<DT><A HREF="https://example.com/b"></A>
+	</DL><p>
+</DL><p>`
+
+// waitImportJob waits until the import job is no longer running.
+func waitImportJob(t *testing.T, hdl *handler, id string) importProgress {
+	for i := 0; i < 100; i++ {
+		rec := httptest.NewRecorder()
+		req := newOwnerRequest("GET", "/api/import/"+id, nil)
+		hdl.apiGetImportProgress(rec, req, httprouter.Params{{Key: "id", Value: id}})
+
+		progress := importProgress{}
+		if err := json.NewDecoder(rec.Body).Decode(&progress); err != nil {
+			t.Fatal(err)
+		}
+
+		if progress.Status != importRunning {
+			return progress
+		}
+
+		time.Sleep(50 * time.Millisecond)
+	}
+
+	t.Fatalf("import job %s never finished", id)
+	return importProgress{}
+}
+
+func Test_apiImportBookmarks(t *testing.T) {
+	multipartBody := &bytes.Buffer{}
+	mw := multipart.NewWriter(multipartBody)
+	part, _ := mw.CreateFormFile("file", "bookmarks.html")
+	part.Write([]byte(importedFile))
+	mw.Close()
+
+	tests := []struct {
+		name        string
+		contentType string
+		body        string
+	}{
+		{"raw file", "text/html", importedFile},
+		{"multipart form", mw.FormDataContentType(), multipartBody.String()},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			hdl, cleanup := newTestHandler(t)
+			defer cleanup()
+			hdl.RootPath = "/"
+
+			existing := model.Bookmark{ID: 1, URL: "https://example.com/existing", Title: "Existing"}
+			if _, err := hdl.DB.SaveBookmarks(existing); err != nil {
+				t.Fatal(err)
+			}
+
+			rec := httptest.NewRecorder()
+			req := newOwnerRequest("POST", "/api/import?generateTag=true", strings.NewReader(tt.body))
+			req.Header.Set("Content-Type", tt.contentType)
+			hdl.apiImportBookmarks(rec, req, nil)
+
+			if rec.Code != http.StatusAccepted {
+				t.Fatalf("apiImportBookmarks() status = %d: %s", rec.Code, rec.Body)
+			}
+
+			started := importProgress{}
+			json.NewDecoder(rec.Body).Decode(&started)
+			if location := rec.Header().Get("Location"); location != "/api/import/"+started.ID {
+				t.Errorf("apiImportBookmarks() location = %q", location)
+			}
+
+			progress := waitImportJob(t, hdl, started.ID)
+			want := importProgress{ID: started.ID, Status: importFinished, Total: 5, Processed: 5, Skipped: 2, Failed: 1}
+			if progress != want {
+				t.Errorf("import progress = %+v, want %+v", progress, want)
+			}
+
+			// Each entry is reported in the order of the file
+			wantResults := []importResult{
+				{"https://example.com/existing", entrySkipped, "URL already exists"},
+				{"https://example.com/a?utm_source=mail", entryImported, ""},
+				{"https://example.com/a", entrySkipped, "URL is duplicated in the file"},
+				{"not a url", entryFailed, "URL is not valid"},
+				{"https://example.com/b", entryImported, ""},
+			}
+
+			if results := hdl.getImportJob(started.ID).Results(); !reflect.DeepEqual(results, wantResults) {
+				t.Errorf("import results = %+v, want %+v", results, wantResults)
+			}
+
+			// Check the imported bookmarks
+			book, exist := hdl.DB.GetBookmark(0, "https://example.com/a")
+			if !exist {
+				t.Fatalf("bookmark A is not imported")
+			}
+
+			withTags, _ := hdl.DB.GetBookmarks(database.GetBookmarksOptions{IDs: []int{book.ID}})
+			book = withTags[0]
+
+			tags := []string{}
+			for _, tag := range book.Tags {
+				tags = append(tags, tag.Name)
+			}
+
+			if book.Title != "A" || book.Created != "2017-07-14 02:40:00" || strings.Join(tags, ",") != "later,reading" {
+				t.Errorf("imported bookmark = %+v", book)
+			}
+
+			book, exist = hdl.DB.GetBookmark(0, "https://example.com/b")
+			if !exist || book.Title != "https://example.com/b" {
+				t.Errorf("imported bookmark without title = %+v, exist %v", book, exist)
+			}
+		})
+	}
+}
+
+func Test_apiImportBookmarksKeepFolders(t *testing.T) {
+	hdl, cleanup := newTestHandler(t)
+	defer cleanup()
+
+	srcFile := `<DL><p>
+	<DT><A HREF="https://example.com/top">Top</A>
+	<DT><H3>Work</H3>
+	<DL><p>
+		<DT><A HREF="https://example.com/work">Work</A>
+		<DT><H3>Projects</H3>
+		<DL><p>
+			<DT><A HREF="https://example.com/%s">Project</A>
+		</DL><p>
+	</DL><p>
+</DL><p>`
+
+	// The second import reuses the collections created by the first one
+	for _, name := range []string{"first", "second"} {
+		rec := httptest.NewRecorder()
+		req := newOwnerRequest("POST", "/api/import?keepFolders=true", strings.NewReader(fmt.Sprintf(srcFile, name)))
+		hdl.apiImportBookmarks(rec, req, nil)
+
+		started := importProgress{}
+		json.NewDecoder(rec.Body).Decode(&started)
+		if progress := waitImportJob(t, hdl, started.ID); progress.Status != importFinished || progress.Failed != 0 {
+			t.Fatalf("import progress = %+v, want finished", progress)
+		}
+	}
+
+	collections, err := hdl.DB.GetCollections(0)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := []model.Collection{
+		{ID: 2, Name: "Projects", ParentID: 1, NBookmarks: 2},
+		{ID: 1, Name: "Work", NBookmarks: 1},
+	}
+
+	if fmt.Sprint(collections) != fmt.Sprint(want) {
+		t.Errorf("imported collections = %+v, want %+v", collections, want)
+	}
+
+	if book, _ := hdl.DB.GetBookmark(0, "https://example.com/top"); book.CollectionID != 0 {
+		t.Errorf("bookmark outside folder is in collection %d, want none", book.CollectionID)
+	}
+}
+
+func Test_apiImportBookmarksFetch(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/page" {
+			http.NotFound(w, r)
+			return
+		}
+
+		w.Header().Set("Content-Type", "text/html; charset=UTF-8")
+		fmt.Fprint(w, "<html><head><title>Fetched</title></head><body><p>Fetched content</p></body></html>")
+	}))
+	defer srv.Close()
+
+	hdl, cleanup := newTestHandler(t)
+	defer cleanup()
+
+	// Options may be sent as form fields, before the file
+	body := &bytes.Buffer{}
+	mw := multipart.NewWriter(body)
+	mw.WriteField("fetch", "true")
+	part, _ := mw.CreateFormFile("file", "bookmarks.html")
+	fmt.Fprintf(part, `<DL><p>
+	<DT><A HREF="%[1]s/page">Imported</A>
+	<DT><A HREF="%[1]s/missing">Missing</A>
+</DL><p>`, srv.URL)
+	mw.Close()
+
+	rec := httptest.NewRecorder()
+	req := newOwnerRequest("POST", "/api/import", body)
+	req.Header.Set("Content-Type", mw.FormDataContentType())
+	hdl.apiImportBookmarks(rec, req, nil)
+
+	started := importProgress{}
+	json.NewDecoder(rec.Body).Decode(&started)
+
+	progress := waitImportJob(t, hdl, started.ID)
+	want := importProgress{ID: started.ID, Status: importFinished, Total: 2, Processed: 2, Archived: 2}
+	if progress != want {
+		t.Fatalf("import progress = %+v, want %+v", progress, want)
+	}
+
+	// The title from file is kept, while the page is fetched
+	tests := []struct {
+		path       string
+		wantTitle  string
+		wantStatus int
+	}{
+		{"/page", "Imported", http.StatusOK},
+		{"/missing", "Missing", http.StatusNotFound},
+	}
+
+	for _, tt := range tests {
+		book, exist := hdl.DB.GetBookmark(0, srv.URL+tt.path)
+		if !exist || book.Title != tt.wantTitle || book.LastStatusCode != tt.wantStatus {
+			t.Errorf("fetched bookmark %s = %+v, want %q with status %d", tt.path, book, tt.wantTitle, tt.wantStatus)
+		}
+	}
+}
+
+func Test_apiImportBookmarksPocket(t *testing.T) {
+	hdl, cleanup := newTestHandler(t)
+	defer cleanup()
+
+	srcFile := "title,url,time_added,tags,status,favorite\n" +
+		"Unread,https://example.com/unread,1500000000,go|web,unread,0\n" +
+		"Read,https://example.com/read,1500000000,,archive,1\n"
+
+	rec := httptest.NewRecorder()
+	req := newOwnerRequest("POST", "/api/import?format=pocket", strings.NewReader(srcFile))
+	hdl.apiImportBookmarks(rec, req, nil)
+
+	started := importProgress{}
+	json.NewDecoder(rec.Body).Decode(&started)
+	if progress := waitImportJob(t, hdl, started.ID); progress.Status != importFinished || progress.Processed != 2 || progress.Failed != 0 {
+		t.Fatalf("import progress = %+v, want finished", progress)
+	}
+
+	bookmarks, err := hdl.DB.GetBookmarks(database.GetBookmarksOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	got := []string{}
+	for _, book := range bookmarks {
+		tags := []string{}
+		for _, tag := range book.Tags {
+			tags = append(tags, tag.Name)
+		}
+
+		got = append(got, fmt.Sprintf("%s %s [%s] read:%v starred:%v",
+			book.Title, book.Created, strings.Join(tags, ","), book.Read, book.Starred))
+	}
+
+	want := []string{
+		"Unread 2017-07-14 02:40:00 [go,web] read:false starred:false",
+		"Read 2017-07-14 02:40:00 [] read:true starred:true",
+	}
+
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("imported bookmarks = %q, want %q", got, want)
+	}
+
+	// Unknown format is rejected
+	router := httprouter.New()
+	router.POST("/api/import", hdl.apiImportBookmarks)
+	router.PanicHandler = hdl.handlePanic
+
+	rec = httptest.NewRecorder()
+	router.ServeHTTP(rec, newOwnerRequest("POST", "/api/import?format=delicious", strings.NewReader(srcFile)))
+	if rec.Code != http.StatusBadRequest {
+		t.Errorf("apiImportBookmarks() with unknown format status = %d, want 400", rec.Code)
+	}
+}
+
+func Test_apiImportBookmarksPinboard(t *testing.T) {
+	hdl, cleanup := newTestHandler(t)
+	defer cleanup()
+
+	srcFile := `[
+		{"href":"https://example.com/unread","description":"Unread","extended":"Some notes","time":"2017-07-14T02:40:00Z","shared":"yes","toread":"yes","tags":"go web"},
+		{"href":"https://example.com/read","description":"Read","extended":"","time":"2017-07-14T02:40:00Z","shared":"no","toread":"no","tags":""}
+	]`
+
+	rec := httptest.NewRecorder()
+	req := newOwnerRequest("POST", "/api/import?format=pinboard", strings.NewReader(srcFile))
+	hdl.apiImportBookmarks(rec, req, nil)
+
+	started := importProgress{}
+	json.NewDecoder(rec.Body).Decode(&started)
+	if progress := waitImportJob(t, hdl, started.ID); progress.Status != importFinished || progress.Processed != 2 || progress.Failed != 0 {
+		t.Fatalf("import progress = %+v, want finished", progress)
+	}
+
+	bookmarks, err := hdl.DB.GetBookmarks(database.GetBookmarksOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	got := map[string]string{}
+	for _, book := range bookmarks {
+		tags := []string{}
+		for _, tag := range book.Tags {
+			tags = append(tags, tag.Name)
+		}
+
+		got[book.URL] = fmt.Sprintf("%s %q %s [%s] read:%v public:%d",
+			book.Title, book.Excerpt, book.Created, strings.Join(tags, ","), book.Read, book.Public)
+	}
+
+	want := map[string]string{
+		"https://example.com/unread": `Unread "Some notes" 2017-07-14 02:40:00 [go,web] read:false public:1`,
+		"https://example.com/read":   `Read "" 2017-07-14 02:40:00 [] read:true public:0`,
+	}
+
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("imported bookmarks = %q, want %q", got, want)
+	}
+
+	// Exported bookmarks are the same as the imported ones
+	rec = httptest.NewRecorder()
+	hdl.apiExportBookmarksPinboard(rec, newOwnerRequest("GET", "/api/bookmarks/export.json", nil), nil)
+
+	exported := []core.PinboardBookmark{}
+	if err := json.NewDecoder(rec.Body).Decode(&exported); err != nil {
+		t.Fatalf("failed to decode export: %v", err)
+	}
+
+	imported := []core.PinboardBookmark{}
+	json.Unmarshal([]byte(srcFile), &imported)
+
+	sort.Slice(exported, func(i, j int) bool { return exported[i].Href > exported[j].Href })
+	if !reflect.DeepEqual(exported, imported) {
+		t.Errorf("exported bookmarks =\n%+v\nwant\n%+v", exported, imported)
+	}
+
+	// Export of filter that matches nothing is still an array
+	rec = httptest.NewRecorder()
+	hdl.apiExportBookmarksPinboard(rec, newOwnerRequest("GET", "/api/bookmarks/export.json?tags=missing", nil), nil)
+
+	exported = nil
+	if err := json.NewDecoder(rec.Body).Decode(&exported); err != nil || exported == nil || len(exported) != 0 {
+		t.Errorf("empty export = %+v, %v, want empty array", exported, err)
+	}
+}
+
+func Test_apiImportBookmarksWallabag(t *testing.T) {
+	hdl, cleanup := newTestHandler(t)
+	defer cleanup()
+
+	srcFile := `[
+		{"is_archived":1,"is_starred":1,"tags":["go","web"],"is_public":true,"title":"Saved",
+		 "url":"https://example.com/saved","content":"<p>Saved article</p><script>alert(1)</script>",
+		 "created_at":"2017-07-14T04:40:00+0200"},
+		{"is_archived":0,"is_starred":0,"tags":[],"is_public":false,"title":"Empty",
+		 "url":"https://example.com/empty","content":"","created_at":"2017-07-14T04:40:00+0200"}
+	]`
+
+	rec := httptest.NewRecorder()
+	req := newOwnerRequest("POST", "/api/import?format=wallabag", strings.NewReader(srcFile))
+	hdl.apiImportBookmarks(rec, req, nil)
+
+	started := importProgress{}
+	json.NewDecoder(rec.Body).Decode(&started)
+	progress := waitImportJob(t, hdl, started.ID)
+	if progress.Status != importFinished || progress.Processed != 2 || progress.Failed != 0 {
+		t.Fatalf("import progress = %+v, want finished", progress)
+	}
+
+	// Only the bookmark with content is archived, since fetch isn't requested
+	if progress.Archived != 1 || progress.ArchiveFailed != 0 {
+		t.Errorf("import progress = %+v, want 1 archived", progress)
+	}
+
+	bookmarks, err := hdl.DB.GetBookmarks(database.GetBookmarksOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	got := map[string]string{}
+	for _, book := range bookmarks {
+		tags := []string{}
+		for _, tag := range book.Tags {
+			tags = append(tags, tag.Name)
+		}
+
+		got[book.URL] = fmt.Sprintf("%s %s [%s] read:%v starred:%v public:%d",
+			book.Title, book.Created, strings.Join(tags, ","), book.Read, book.Starred, book.Public)
+	}
+
+	want := map[string]string{
+		"https://example.com/saved": "Saved 2017-07-14 02:40:00 [go,web] read:true starred:true public:1",
+		"https://example.com/empty": "Empty 2017-07-14 02:40:00 [] read:false starred:false public:0",
+	}
+
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("imported bookmarks = %q, want %q", got, want)
+	}
+}
+
+func Test_apiImportBookmarksInstapaper(t *testing.T) {
+	hdl, cleanup := newTestHandler(t)
+	defer cleanup()
+
+	srcFile := "URL,Title,Selection,Folder,Timestamp\n" +
+		"https://example.com/unread,Unread,,Unread,1500000000\n" +
+		"https://example.com/read,Read,Selected text,Archive,1500000000\n" +
+		"https://example.com/starred,Starred,,Starred,1500000000\n" +
+		"https://example.com/folder,Folder,,Go,1500000000\n"
+
+	rec := httptest.NewRecorder()
+	req := newOwnerRequest("POST", "/api/import?format=instapaper", strings.NewReader(srcFile))
+	hdl.apiImportBookmarks(rec, req, nil)
+
+	started := importProgress{}
+	json.NewDecoder(rec.Body).Decode(&started)
+	if progress := waitImportJob(t, hdl, started.ID); progress.Status != importFinished || progress.Processed != 4 || progress.Failed != 0 {
+		t.Fatalf("import progress = %+v, want finished", progress)
+	}
+
+	bookmarks, err := hdl.DB.GetBookmarks(database.GetBookmarksOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	got := map[string]string{}
+	for _, book := range bookmarks {
+		tags := []string{}
+		for _, tag := range book.Tags {
+			tags = append(tags, tag.Name)
+		}
+
+		got[book.URL] = fmt.Sprintf("%s %q %s [%s] read:%v starred:%v",
+			book.Title, book.Excerpt, book.Created, strings.Join(tags, ","), book.Read, book.Starred)
+	}
+
+	want := map[string]string{
+		"https://example.com/unread":  `Unread "" 2017-07-14 02:40:00 [] read:false starred:false`,
+		"https://example.com/read":    `Read "Selected text" 2017-07-14 02:40:00 [] read:true starred:false`,
+		"https://example.com/starred": `Starred "" 2017-07-14 02:40:00 [] read:false starred:true`,
+		"https://example.com/folder":  `Folder "" 2017-07-14 02:40:00 [go] read:false starred:false`,
+	}
+
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("imported bookmarks = %q, want %q", got, want)
+	}
+}
+
+func Test_runImportJob(t *testing.T) {
+	// Create file that needs several batches
+	nBookmarks := importBatchSize*2 + 1
+	buf := &bytes.Buffer{}
+	buf.WriteString("<DL><p>\n")
+	for i := 1; i <= nBookmarks; i++ {
+		fmt.Fprintf(buf, "<DT><A HREF=\"https://example.com/%d\">Bookmark %d</A>\n", i, i)
+	}
+	buf.WriteString("</DL><p>\n")
+
+	tests := []struct {
+		name      string
+		cancelled bool
+		want      importProgress
+		wantSaved int
+	}{
+		{"finished", false, importProgress{Status: importFinished, Total: nBookmarks, Processed: nBookmarks}, nBookmarks},
+		{"cancelled", true, importProgress{Status: importCancelled}, 0},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			hdl, cleanup := newTestHandler(t)
+			defer cleanup()
+			hdl.Concurrency = 2
+
+			srcPath := fp.Join(hdl.DataDir, "bookmarks.html")
+			if err := ioutil.WriteFile(srcPath, buf.Bytes(), 0644); err != nil {
+				t.Fatal(err)
+			}
+
+			ctx, cancel := context.WithCancel(context.Background())
+			if tt.cancelled {
+				cancel()
+			}
+
+			job := &importJob{cancel: cancel}
+			hdl.runImportJob(ctx, job, srcPath)
+			cancel()
+
+			if progress := job.Progress(); progress != tt.want {
+				t.Errorf("runImportJob() progress = %+v, want %+v", progress, tt.want)
+			}
+
+			saved, _ := hdl.DB.GetBookmarks(database.GetBookmarksOptions{})
+			if len(saved) != tt.wantSaved {
+				t.Errorf("runImportJob() saved %d bookmarks, want %d", len(saved), tt.wantSaved)
+			}
+		})
+	}
+}
+
+func Test_apiGetImportProgressNotFound(t *testing.T) {
+	hdl, cleanup := newTestHandler(t)
+	defer cleanup()
+
+	router := httprouter.New()
+	router.GET("/api/import/unknown", func(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
+		hdl.apiGetImportProgress(w, r, httprouter.Params{{Key: "id", Value: "unknown"}})
+	})
+	router.PanicHandler = hdl.handlePanic
+
+	rec := httptest.NewRecorder()
+	router.ServeHTTP(rec, newOwnerRequest("GET", "/api/import/unknown", nil))
+
+	if rec.Code != http.StatusNotFound {
+		t.Errorf("apiGetImportProgress() status = %d, want %d", rec.Code, http.StatusNotFound)
+	}
+}
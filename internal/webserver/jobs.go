@@ -0,0 +1,158 @@
+package webserver
+
+import (
+	"strconv"
+	"time"
+
+	"shiori/internal/core"
+	"shiori/internal/database"
+	"shiori/internal/model"
+	"github.com/sirupsen/logrus"
+)
+
+// runArchivePruner prunes the archives based on the policy in req,
+// then repeats it in the specified interval. It never returns.
+func (h *handler) runArchivePruner(req core.PruneRequest, interval time.Duration) {
+	// Make sure pruned archive is closed and removed from cache
+	req.BeforeRemove = func(id int) {
+		h.ArchiveCache.Delete(strconv.Itoa(id))
+	}
+
+	for {
+		// Nothing is removed while the server is in read-only mode
+		if h.isReadOnly() {
+			time.Sleep(interval)
+			continue
+		}
+
+		pruned, err := core.PruneArchives(req)
+		for _, archive := range pruned {
+			logrus.WithFields(logrus.Fields{
+				"bookmark": archive.ID,
+				"bytes":    archive.Size,
+				"modified": archive.ModTime.Format(time.RFC3339),
+				"reason":   archive.Reason,
+			}).Info("pruned archive")
+		}
+
+		if err != nil {
+			logrus.WithError(err).Error("failed to prune archives")
+		}
+
+		time.Sleep(interval)
+	}
+}
+
+// runLinkChecker checks whether the URL of every bookmark is still
+// reachable, then repeats it in the specified interval. The result is kept
+// in the bookmarks, so the broken ones can be listed. It never returns,
+// unless the server is shutting down.
+func (h *handler) runLinkChecker(interval time.Duration) {
+	for {
+		time.Sleep(interval)
+		if h.isReadOnly() {
+			continue
+		}
+
+		nChecked, nBroken := 0, 0
+		filter := database.GetBookmarksOptions{Limit: exportPageSize}
+		for {
+			bookmarks, err := h.DB.GetBookmarks(filter)
+			if err != nil {
+				logrus.WithError(err).Error("failed to get bookmarks for checking links")
+				break
+			}
+
+			for _, book := range bookmarks {
+				// Stop checking once the server is shutting down
+				if !h.Background.begin() {
+					return
+				}
+
+				if h.checkLink(book) {
+					nBroken++
+				}
+
+				h.Background.done()
+				nChecked++
+			}
+
+			if len(bookmarks) < exportPageSize {
+				break
+			}
+
+			filter.Offset += exportPageSize
+		}
+
+		logrus.WithFields(logrus.Fields{
+			"checked": nChecked,
+			"broken":  nBroken,
+		}).Info("checked links of bookmarks")
+	}
+}
+
+// checkLink checks whether the bookmark URL is still reachable, records
+// the result in the bookmark, then returns true if it's broken.
+func (h *handler) checkLink(book model.Bookmark) bool {
+	entry := logrus.WithFields(logrus.Fields{"bookmark": book.ID, "url": book.URL})
+	statusCode, err := core.CheckLink(book.URL)
+	if err != nil {
+		entry.WithError(err).Debug("failed to reach bookmark")
+	}
+
+	if err := h.DB.SetBookmarkLinkStatus(book.ID, statusCode); err != nil {
+		entry.WithError(err).Error("failed to save link status")
+	}
+
+	broken := statusCode == 0 || statusCode >= 400
+	if broken {
+		entry.WithField("status", statusCode).Warn("bookmark link is broken")
+	}
+
+	return broken
+}
+
+// archiveAgain downloads and archives the bookmark again, keeping its
+// title and excerpt. It returns core.ErrUnchanged when its content hasn't
+// changed since it's archived, so no new archive is created.
+func (h *handler) archiveAgain(book model.Bookmark) error {
+	content, contentType, statusCode, waybackURL, err := core.FetchBookmark(book.URL, h.RenderPolicy)
+	if err != nil {
+		return err
+	}
+	defer content.Close()
+
+	book.LastStatusCode = statusCode
+	book.WaybackURL = waybackURL
+	book.CreateArchive = true
+
+	request := core.ProcessRequest{
+		DataDir:        h.DataDir,
+		Bookmark:       book,
+		Content:        content,
+		ContentType:    contentType,
+		KeepTitle:      true,
+		KeepExcerpt:    true,
+		MaxResources:   h.MaxResources,
+		MaxSnapshots:   h.MaxSnapshots,
+		RenderPolicy:   h.RenderPolicy,
+		ArchivalPolicy: h.ArchivalPolicy,
+		SkipUnchanged:  true,
+	}
+
+	book, _, err = core.ProcessBookmark(request)
+	if err != nil {
+		return err
+	}
+
+	// The archive is replaced, so make sure the old one isn't served
+	h.ArchiveCache.Delete(strconv.Itoa(book.ID))
+
+	saved, err := h.DB.SaveBookmarks(book)
+	if err != nil {
+		return err
+	}
+
+	h.Webhooks.send(eventBookmarkArchived, saved...)
+	return nil
+}
@@ -0,0 +1,72 @@
+package webserver
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// jwtHeader is the header of every JWT signed by the server.
+var jwtHeader = base64.RawURLEncoding.EncodeToString([]byte(`{"alg":"HS256","typ":"JWT"}`))
+
+// sessionClaims is the claims in access token of login session.
+type sessionClaims struct {
+	Subject   string `json:"sub"`
+	AccountID int    `json:"uid"`
+	Role      string `json:"role"`
+	SessionID int    `json:"sid"`
+	IssuedAt  int64  `json:"iat"`
+	ExpiresAt int64  `json:"exp"`
+}
+
+// signJWT creates JWT that contains the claims, signed by key using HS256.
+func signJWT(claims sessionClaims, key []byte) (string, error) {
+	payload, err := json.Marshal(&claims)
+	if err != nil {
+		return "", err
+	}
+
+	unsigned := jwtHeader + "." + base64.RawURLEncoding.EncodeToString(payload)
+	return unsigned + "." + jwtSignature(unsigned, key), nil
+}
+
+// parseJWT verifies JWT that signed by key, then returns its claims.
+// Token that already expired is rejected as well.
+func parseJWT(token string, key []byte) (sessionClaims, error) {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 || parts[0] != jwtHeader {
+		return sessionClaims{}, fmt.Errorf("token is malformed")
+	}
+
+	signature := jwtSignature(parts[0]+"."+parts[1], key)
+	if !hmac.Equal([]byte(signature), []byte(parts[2])) {
+		return sessionClaims{}, fmt.Errorf("token signature is invalid")
+	}
+
+	payload, err := base64.RawURLEncoding.DecodeString(parts[1])
+	if err != nil {
+		return sessionClaims{}, fmt.Errorf("token is malformed")
+	}
+
+	var claims sessionClaims
+	if err = json.Unmarshal(payload, &claims); err != nil {
+		return sessionClaims{}, fmt.Errorf("token is malformed")
+	}
+
+	if time.Now().Unix() >= claims.ExpiresAt {
+		return sessionClaims{}, fmt.Errorf("token is expired")
+	}
+
+	return claims, nil
+}
+
+// jwtSignature returns the HS256 signature of unsigned JWT.
+func jwtSignature(unsigned string, key []byte) string {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(unsigned))
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
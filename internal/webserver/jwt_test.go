@@ -0,0 +1,48 @@
+package webserver
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func Test_parseJWT(t *testing.T) {
+	key := []byte("0123456789abcdef0123456789abcdef")
+	now := time.Now().Unix()
+	valid := sessionClaims{Subject: "alice", AccountID: 1, SessionID: 2, IssuedAt: now, ExpiresAt: now + 60}
+	expired := sessionClaims{Subject: "alice", AccountID: 1, SessionID: 2, IssuedAt: now - 120, ExpiresAt: now - 60}
+
+	validToken, _ := signJWT(valid, key)
+	expiredToken, _ := signJWT(expired, key)
+	otherKeyToken, _ := signJWT(valid, []byte("another key"))
+
+	// Change the payload while keeping the signature
+	parts := strings.Split(validToken, ".")
+	forged, _ := signJWT(sessionClaims{Subject: "bob", AccountID: 1, SessionID: 2, ExpiresAt: now + 60}, key)
+	tamperedToken := parts[0] + "." + strings.Split(forged, ".")[1] + "." + parts[2]
+
+	tests := []struct {
+		name    string
+		token   string
+		wantErr bool
+	}{
+		{"valid", validToken, false},
+		{"expired", expiredToken, true},
+		{"signed by other key", otherKeyToken, true},
+		{"tampered payload", tamperedToken, true},
+		{"malformed", "not-a-jwt", true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			claims, err := parseJWT(tt.token, key)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("parseJWT() error = %v, wantErr %v", err, tt.wantErr)
+			}
+
+			if !tt.wantErr && claims != valid {
+				t.Errorf("parseJWT() = %+v, want %+v", claims, valid)
+			}
+		})
+	}
+}
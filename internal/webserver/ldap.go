@@ -0,0 +1,185 @@
+package webserver
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"shiori/internal/model"
+	"gopkg.in/ldap.v3"
+)
+
+// LDAPConfig is the directory, e.g. LDAP or Active Directory, that used to
+// authenticate accounts in addition to the local ones. It's disabled when
+// URL is empty.
+type LDAPConfig struct {
+	// URL of the directory, e.g. ldaps://ldap.example.com.
+	URL string
+
+	// BindDN and BindPassword is the service account that used to search
+	// the user who logs in. Anonymous search is used when BindDN is empty.
+	BindDN       string
+	BindPassword string
+
+	// BaseDN is where the users are searched, using UserFilter
+	// whose %s is replaced by the escaped username.
+	BaseDN     string
+	UserFilter string
+
+	// OwnerGroup is the DN of group whose members have owner role,
+	// which is found in `memberOf` attribute of the user.
+	OwnerGroup string
+}
+
+// ldapTimeout is how long the server waits for the directory.
+var ldapTimeout = 10 * time.Second
+
+// ldapUser is the user that authenticated by the directory.
+type ldapUser struct {
+	DN     string
+	Groups []string
+}
+
+// enabled checks if the directory is configured.
+func (cfg LDAPConfig) enabled() bool {
+	return cfg.URL != ""
+}
+
+// userFilter returns the filter for searching the user with username.
+func (cfg LDAPConfig) userFilter(username string) string {
+	return strings.Replace(cfg.UserFilter, "%s", ldap.EscapeFilter(username), -1)
+}
+
+// authenticate binds to the directory as the user with matching username
+// and password. Returns false if the user doesn't exist or the password
+// doesn't match, while error means the directory can't be used.
+func (cfg LDAPConfig) authenticate(username, password string) (ldapUser, bool, error) {
+	// Empty password would make an unauthenticated bind succeed
+	if username == "" || password == "" {
+		return ldapUser{}, false, nil
+	}
+
+	conn, err := ldap.DialURL(cfg.URL)
+	if err != nil {
+		return ldapUser{}, false, fmt.Errorf("failed to connect to directory: %v", err)
+	}
+	defer conn.Close()
+	conn.SetTimeout(ldapTimeout)
+
+	// Find the user using service account
+	if cfg.BindDN != "" {
+		if err = conn.Bind(cfg.BindDN, cfg.BindPassword); err != nil {
+			return ldapUser{}, false, fmt.Errorf("failed to bind service account: %v", err)
+		}
+	}
+
+	request := ldap.NewSearchRequest(cfg.BaseDN,
+		ldap.ScopeWholeSubtree, ldap.NeverDerefAliases, 2, int(ldapTimeout.Seconds()), false,
+		cfg.userFilter(username), []string{"memberOf"}, nil)
+
+	result, err := conn.Search(request)
+	if err != nil && !ldap.IsErrorWithCode(err, ldap.LDAPResultSizeLimitExceeded) {
+		return ldapUser{}, false, fmt.Errorf("failed to search user: %v", err)
+	}
+
+	// Username that matches several users is ambiguous, so it's rejected
+	if result == nil || len(result.Entries) != 1 {
+		return ldapUser{}, false, nil
+	}
+
+	// Verify the password by binding as the user
+	entry := result.Entries[0]
+	err = conn.Bind(entry.DN, password)
+	if ldap.IsErrorWithCode(err, ldap.LDAPResultInvalidCredentials) {
+		return ldapUser{}, false, nil
+	} else if err != nil {
+		return ldapUser{}, false, fmt.Errorf("failed to bind user: %v", err)
+	}
+
+	user := ldapUser{
+		DN:     entry.DN,
+		Groups: entry.GetAttributeValues("memberOf"),
+	}
+
+	return user, true, nil
+}
+
+// isOwner checks if the user is a member of owner group.
+func (cfg LDAPConfig) isOwner(user ldapUser) bool {
+	if cfg.OwnerGroup == "" {
+		return false
+	}
+
+	ownerGroup, err := ldap.ParseDN(cfg.OwnerGroup)
+	if err != nil {
+		return false
+	}
+
+	for _, group := range user.Groups {
+		if dn, err := ldap.ParseDN(group); err == nil && dn.Equal(ownerGroup) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// errLocalAccount is returned when user of the directory has the same
+// username as a local account, which is never linked to the directory.
+var errLocalAccount = errors.New("username is used by local account")
+
+// provisionLDAPAccount returns the local account of user that authenticated
+// by the directory, which is created on its first login. When owner group is
+// configured, the account's owner role follows its membership of the group.
+//
+// Account that created here has random password, so it can only log in
+// through the directory. Only such account is linked to the directory, so
+// local account with the same username is refused and kept as it is.
+func (h *handler) provisionLDAPAccount(username string, user ldapUser) (model.Account, error) {
+	account, exist := h.DB.GetAccount(username)
+	if exist && account.Provider != model.AccountProviderLDAP {
+		return model.Account{}, errLocalAccount
+	}
+
+	isOwner := h.LDAP.isOwner(user)
+
+	role := account.Role
+	switch {
+	case isOwner:
+		role = model.RoleOwner
+	case !exist:
+		role = model.RoleViewer
+	case h.LDAP.OwnerGroup != "" && role == model.RoleOwner:
+		// No longer a member of owner group
+		role = model.RoleViewer
+	}
+
+	switch {
+	case exist && role == account.Role:
+		return account, nil
+	case exist:
+		if err := h.DB.UpdateAccountRole(username, role); err != nil {
+			return model.Account{}, err
+		}
+	default:
+		password, err := newAPIToken()
+		if err != nil {
+			return model.Account{}, err
+		}
+
+		err = h.DB.SaveAccount(model.Account{
+			Username: username,
+			Password: password,
+			Owner:    role == model.RoleOwner,
+			Role:     role,
+			Provider: model.AccountProviderLDAP,
+		})
+		if err != nil {
+			return model.Account{}, err
+		}
+	}
+
+	account, _ = h.DB.GetAccount(username)
+	return account, nil
+}
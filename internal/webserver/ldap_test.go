@@ -0,0 +1,126 @@
+package webserver
+
+import (
+	"testing"
+
+	"shiori/internal/model"
+)
+
+func TestLDAPConfig_userFilter(t *testing.T) {
+	cfg := LDAPConfig{UserFilter: "(&(objectClass=person)(uid=%s))"}
+
+	tests := []struct {
+		username string
+		want     string
+	}{
+		{"alice", "(&(objectClass=person)(uid=alice))"},
+		{"*", `(&(objectClass=person)(uid=\2a))`},
+		{"alice)(uid=*", `(&(objectClass=person)(uid=alice\29\28uid=\2a))`},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.username, func(t *testing.T) {
+			if got := cfg.userFilter(tt.username); got != tt.want {
+				t.Errorf("userFilter() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestLDAPConfig_isOwner(t *testing.T) {
+	tests := []struct {
+		name       string
+		ownerGroup string
+		groups     []string
+		want       bool
+	}{
+		{"member", "cn=admins,dc=example,dc=com", []string{"cn=users,dc=example,dc=com", "cn=admins,dc=example,dc=com"}, true},
+		{"member in different case", "cn=admins,dc=example,dc=com", []string{"CN=admins, DC=example, DC=com"}, true},
+		{"not member", "cn=admins,dc=example,dc=com", []string{"cn=users,dc=example,dc=com"}, false},
+		{"no owner group", "", []string{"cn=admins,dc=example,dc=com"}, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := LDAPConfig{OwnerGroup: tt.ownerGroup}
+			if got := cfg.isOwner(ldapUser{Groups: tt.groups}); got != tt.want {
+				t.Errorf("isOwner() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func Test_provisionLDAPAccount(t *testing.T) {
+	hdl, cleanup := newTestHandler(t)
+	defer cleanup()
+
+	hdl.LDAP = LDAPConfig{OwnerGroup: "cn=admins,dc=example,dc=com"}
+	member := ldapUser{Groups: []string{"cn=admins,dc=example,dc=com"}}
+	nonMember := ldapUser{}
+
+	err := hdl.DB.SaveAccount(model.Account{Username: "editor", Password: "secret", Role: model.RoleEditor})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Steps run in order, since they provision the same accounts
+	tests := []struct {
+		name     string
+		username string
+		user     ldapUser
+		wantRole string
+	}{
+		{"new user", "alice", nonMember, model.RoleViewer},
+		{"new member of owner group", "bob", member, model.RoleOwner},
+		{"joins owner group", "alice", member, model.RoleOwner},
+		{"leaves owner group", "alice", nonMember, model.RoleViewer},
+	}
+
+	alice := func() model.Account {
+		account, _ := hdl.DB.GetAccount("alice")
+		return account
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			account, err := hdl.provisionLDAPAccount(tt.username, tt.user)
+			if err != nil {
+				t.Fatalf("provisionLDAPAccount() error = %v", err)
+			}
+
+			if account.Role != tt.wantRole || account.Owner != (tt.wantRole == model.RoleOwner) {
+				t.Errorf("provisionLDAPAccount() role = %q, owner = %v, want %q",
+					account.Role, account.Owner, tt.wantRole)
+			}
+
+			saved, _ := hdl.DB.GetAccount(tt.username)
+			if saved.ID == 0 || saved.ID != account.ID || saved.Role != tt.wantRole {
+				t.Errorf("saved account = %+v, want role %q", saved, tt.wantRole)
+			}
+		})
+	}
+
+	if account := alice(); account.Provider != model.AccountProviderLDAP {
+		t.Errorf("provider of provisioned account = %q, want %q", account.Provider, model.AccountProviderLDAP)
+	}
+
+	// Password is kept when role of provisioned account is changed
+	password := alice().Password
+	if _, err := hdl.provisionLDAPAccount("alice", member); err != nil || alice().Password != password {
+		t.Errorf("provisionLDAPAccount() changes password of existing account, error = %v", err)
+	}
+
+	// Local account is never linked to the directory, even by owner
+	if _, err := hdl.provisionLDAPAccount("editor", member); err != errLocalAccount {
+		t.Errorf("provisionLDAPAccount() of local account error = %v, want %v", err, errLocalAccount)
+	}
+
+	editor, _ := hdl.DB.GetAccount("editor")
+	if editor.Role != model.RoleEditor {
+		t.Errorf("role of local account = %q, want %q", editor.Role, model.RoleEditor)
+	}
+
+	if _, valid := hdl.authenticatePassword("editor", "secret"); !valid {
+		t.Errorf("authenticatePassword() of local account failed")
+	}
+}
@@ -0,0 +1,266 @@
+package webserver
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/julienschmidt/httprouter"
+	cch "github.com/patrickmn/go-cache"
+)
+
+// loginRoutes is list of routes where password is submitted,
+// so their failed attempts are tracked.
+var loginRoutes = []string{
+	"/api/login",
+	"/api/tokens",
+}
+
+// loginFailureWindow is how long a failed login is remembered, while
+// loginMaxBackoff is the longest wait between failed logins.
+var (
+	loginFailureWindow = time.Hour
+	loginMaxBackoff    = 15 * time.Minute
+)
+
+// loginFailures is the failed logins of an IP address or a username.
+type loginFailures struct {
+	Count       int       `json:"failures"`
+	RetryAt     time.Time `json:"retryAt"`
+	LockedUntil time.Time `json:"lockedUntil,omitempty"`
+}
+
+// lockedAccount is the account that locked after too many failed logins.
+type lockedAccount struct {
+	Username string `json:"username"`
+	loginFailures
+}
+
+// loginLimiter slows down failed logins from the same IP address or to
+// the same username, waiting twice as long after each failure. Username
+// is locked for a while once it failed too many times in a row.
+type loginLimiter struct {
+	sync.Mutex
+	cache       *cch.Cache
+	maxFailures int
+	lockout     time.Duration
+}
+
+// newLoginLimiter creates limiter that locks username after maxFailures,
+// for lockout duration. Zero maxFailures means username is never locked.
+func newLoginLimiter(maxFailures int, lockout time.Duration) *loginLimiter {
+	return &loginLimiter{
+		cache:       cch.New(loginFailureWindow, 10*time.Minute),
+		maxFailures: maxFailures,
+		lockout:     lockout,
+	}
+}
+
+// loginKeys returns the keys of failures that apply to the login.
+func loginKeys(ip, username string) []string {
+	keys := []string{"ip:" + ip}
+	if username != "" {
+		keys = append(keys, "user:"+strings.ToLower(username))
+	}
+
+	return keys
+}
+
+// retryAfter returns how long until the login may be tried again,
+// and whether it's because the username is locked.
+func (l *loginLimiter) retryAfter(keys []string, now time.Time) (time.Duration, bool) {
+	l.Lock()
+	defer l.Unlock()
+
+	var wait time.Duration
+	var locked bool
+	for _, key := range keys {
+		cached, found := l.cache.Get(key)
+		if !found {
+			continue
+		}
+
+		failures := cached.(loginFailures)
+		if d := failures.LockedUntil.Sub(now); d > wait {
+			wait, locked = d, true
+		}
+
+		if d := failures.RetryAt.Sub(now); d > wait {
+			wait = d
+		}
+	}
+
+	return wait, locked
+}
+
+// fail records failed login, then returns whether the username got locked.
+func (l *loginLimiter) fail(keys []string, now time.Time) bool {
+	l.Lock()
+	defer l.Unlock()
+
+	locked := false
+	for _, key := range keys {
+		failures := loginFailures{}
+		if cached, found := l.cache.Get(key); found {
+			failures = cached.(loginFailures)
+		}
+
+		// Wait 1s after first failure, then twice as long after each one
+		failures.Count++
+		backoff := loginMaxBackoff
+		if failures.Count <= 20 {
+			backoff = time.Second << uint(failures.Count-1)
+		}
+		if backoff > loginMaxBackoff {
+			backoff = loginMaxBackoff
+		}
+		failures.RetryAt = now.Add(backoff)
+
+		expiration := cch.DefaultExpiration
+		if strings.HasPrefix(key, "user:") && l.maxFailures > 0 && failures.Count >= l.maxFailures {
+			failures.LockedUntil = now.Add(l.lockout)
+			failures.Count = 0
+			locked = true
+
+			if l.lockout > loginFailureWindow {
+				expiration = l.lockout
+			}
+		}
+
+		l.cache.Set(key, failures, expiration)
+	}
+
+	return locked
+}
+
+// reset forgets failed logins, e.g. once the login succeeded.
+func (l *loginLimiter) reset(keys []string) {
+	l.Lock()
+	defer l.Unlock()
+
+	for _, key := range keys {
+		l.cache.Delete(key)
+	}
+}
+
+// lockedAccounts returns usernames that currently locked, sorted by name.
+func (l *loginLimiter) lockedAccounts(now time.Time) []lockedAccount {
+	l.Lock()
+	defer l.Unlock()
+
+	accounts := []lockedAccount{}
+	for key, item := range l.cache.Items() {
+		failures := item.Object.(loginFailures)
+		if !strings.HasPrefix(key, "user:") || !failures.LockedUntil.After(now) {
+			continue
+		}
+
+		accounts = append(accounts, lockedAccount{
+			Username:      strings.TrimPrefix(key, "user:"),
+			loginFailures: failures,
+		})
+	}
+
+	sort.Slice(accounts, func(i, j int) bool {
+		return accounts[i].Username < accounts[j].Username
+	})
+
+	return accounts
+}
+
+// loginUsername returns username that submitted in body of the login,
+// then restores the body so the handler can still read it.
+func loginUsername(r *http.Request) string {
+	body, err := ioutil.ReadAll(r.Body)
+	r.Body.Close()
+	r.Body = ioutil.NopCloser(bytes.NewReader(body))
+	if err != nil {
+		return ""
+	}
+
+	request := struct {
+		Username string `json:"username"`
+	}{}
+	json.Unmarshal(body, &request)
+
+	return strings.TrimSpace(request.Username)
+}
+
+// limitLogin slows down guessing password of accounts. Failed login, i.e.
+// one that rejected as unauthorized, makes the IP address and the username
+// wait before trying again, and too many of them lock the username for a
+// while. Request that already authenticated by token is left as it is.
+func (h *handler) limitLogin(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, authenticated := requestAccount(r)
+		if h.LoginLimiter == nil || authenticated || r.Method != http.MethodPost ||
+			!matchRoute(h.routePath(r), loginRoutes) {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		now := time.Now()
+		keys := loginKeys(clientIP(r), loginUsername(r))
+		if wait, locked := h.LoginLimiter.retryAfter(keys, now); wait > 0 {
+			msg := "too many failed logins, try again later"
+			if locked {
+				msg = "account is locked after too many failed logins, try again later"
+			}
+
+			seconds := int(math.Ceil(wait.Seconds()))
+			w.Header().Set("Retry-After", strconv.Itoa(seconds))
+			h.writeError(w, r, http.StatusTooManyRequests, msg)
+			return
+		}
+
+		rec := &statusRecorder{ResponseWriter: w}
+		next.ServeHTTP(rec, r)
+
+		switch {
+		case rec.status == http.StatusUnauthorized:
+			h.LoginLimiter.fail(keys, now)
+		case rec.status >= 200 && rec.status < 300:
+			h.LoginLimiter.reset(keys)
+		}
+	})
+}
+
+// apiGetLockedAccounts is handler for GET /api/lockouts
+//
+// It lists usernames that locked after too many failed logins,
+// along with when they will be unlocked.
+func (h *handler) apiGetLockedAccounts(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	accounts := []lockedAccount{}
+	if h.LoginLimiter != nil {
+		accounts = h.LoginLimiter.lockedAccounts(time.Now())
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	err := json.NewEncoder(w).Encode(&accounts)
+	checkError(err)
+}
+
+// apiUnlockAccount is handler for DELETE /api/lockouts/:username
+//
+// It unlocks the username before its lockout ends, and forgets its
+// failed logins as well.
+func (h *handler) apiUnlockAccount(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	username := strings.TrimSpace(ps.ByName("username"))
+	if username == "" {
+		panic(newClientError(http.StatusBadRequest, fmt.Errorf("username must not be empty")))
+	}
+
+	if h.LoginLimiter != nil {
+		h.LoginLimiter.reset([]string{"user:" + strings.ToLower(username)})
+	}
+
+	fmt.Fprint(w, 1)
+}
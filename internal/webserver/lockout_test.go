@@ -0,0 +1,102 @@
+package webserver
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"shiori/internal/model"
+	"github.com/julienschmidt/httprouter"
+)
+
+func Test_loginLimiter(t *testing.T) {
+	limiter := newLoginLimiter(3, time.Hour)
+	keys := loginKeys("10.0.0.1", "Alice")
+	now := time.Now()
+
+	// Each failure doubles the wait
+	for i, wantWait := range []time.Duration{time.Second, 2 * time.Second} {
+		if locked := limiter.fail(keys, now); locked {
+			t.Fatalf("failure %d locks the username", i+1)
+		}
+
+		wait, locked := limiter.retryAfter(keys, now)
+		if wait != wantWait || locked {
+			t.Errorf("retryAfter() after %d failures = %v, %v, want %v", i+1, wait, locked, wantWait)
+		}
+	}
+
+	// Once too many, the username is locked, even from other IP address
+	if locked := limiter.fail(keys, now); !locked {
+		t.Fatalf("failure 3 doesn't lock the username")
+	}
+
+	wait, locked := limiter.retryAfter(loginKeys("10.0.0.2", "alice"), now)
+	if wait != time.Hour || !locked {
+		t.Errorf("retryAfter() of locked username = %v, %v, want %v", wait, locked, time.Hour)
+	}
+
+	accounts := limiter.lockedAccounts(now)
+	if len(accounts) != 1 || accounts[0].Username != "alice" {
+		t.Errorf("lockedAccounts() = %+v, want alice", accounts)
+	}
+
+	// Other usernames from other IP address are not affected
+	if wait, _ := limiter.retryAfter(loginKeys("10.0.0.2", "bob"), now); wait != 0 {
+		t.Errorf("retryAfter() of other username = %v, want 0", wait)
+	}
+
+	limiter.reset(keys)
+	if wait, _ := limiter.retryAfter(keys, now); wait != 0 {
+		t.Errorf("retryAfter() after reset = %v, want 0", wait)
+	}
+}
+
+func Test_limitLogin(t *testing.T) {
+	hdl, cleanup := newTestHandler(t)
+	defer cleanup()
+
+	err := hdl.DB.SaveAccount(model.Account{Username: "alice", Password: "secret"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	hdl.LoginLimiter = newLoginLimiter(1, time.Hour)
+	router := httprouter.New()
+	router.POST("/api/login", hdl.apiLogin)
+	router.DELETE("/api/lockouts/alice", func(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
+		hdl.apiUnlockAccount(w, r, httprouter.Params{{Key: "username", Value: "alice"}})
+	})
+	router.PanicHandler = hdl.handlePanic
+	server := hdl.limitLogin(router)
+
+	login := func(ip, password string) *httptest.ResponseRecorder {
+		body := `{"username":"alice","password":"` + password + `"}`
+		req := httptest.NewRequest("POST", "/api/login", strings.NewReader(body))
+		req.RemoteAddr = ip + ":1234"
+
+		rec := httptest.NewRecorder()
+		server.ServeHTTP(rec, req)
+		return rec
+	}
+
+	if rec := login("10.0.0.1", "wrong"); rec.Code != http.StatusUnauthorized {
+		t.Fatalf("login with wrong password status = %d, want 401", rec.Code)
+	}
+
+	// Even the right password is rejected while the username is locked
+	rec := login("10.0.0.2", "secret")
+	if rec.Code != http.StatusTooManyRequests || rec.Header().Get("Retry-After") == "" {
+		t.Fatalf("login to locked username status = %d, want 429 with Retry-After", rec.Code)
+	}
+
+	// Once owner unlocks it, the right password works again
+	req := httptest.NewRequest("DELETE", "/api/lockouts/alice", nil)
+	server.ServeHTTP(httptest.NewRecorder(), req)
+
+	if rec := login("10.0.0.2", "secret"); rec.Code != http.StatusOK {
+		t.Errorf("login after unlocked status = %d, want 200: %s", rec.Code, rec.Body)
+	}
+}
@@ -0,0 +1,335 @@
+package webserver
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"shiori/internal/model"
+	"github.com/sirupsen/logrus"
+)
+
+// largeBodyRoutes is list of routes that receive large request body,
+// e.g. full HTML page from extension, captured page or uploaded bookmark file.
+var largeBodyRoutes = []string{
+	"/api/bookmarks/ext",
+	"/api/bookmarks/capture",
+	"/api/import",
+}
+
+// readOnlyRoutes is list of routes that still accept changes in read-only
+// mode, so the mode itself can be disabled, and users can still sign in
+// to read their bookmarks.
+var readOnlyRoutes = []string{
+	"/api/maintenance",
+	"/api/login",
+	"/api/refresh",
+	"/api/logout",
+}
+
+// publicRoutes is list of routes that may be used without signing in, i.e.
+// the web interface itself, sign in, shared links and public feeds. Routes
+// of bookmark content are public as well, since their handlers only serve
+// public bookmarks to request that isn't authenticated. API token may be
+// created with username and password, so routes of API tokens are public
+// too, while their handlers require authentication for anything else.
+var publicRoutes = []string{
+	"/",
+	"/js",
+	"/res",
+	"/css",
+	"/fonts",
+	"/bookmark",
+	"/share",
+	"/feed.xml",
+	"/tag",
+	"/api/version",
+	"/api/login",
+	"/api/refresh",
+	"/api/tokens",
+	"/auth/oidc",
+	"/healthz",
+	"/readyz",
+}
+
+// accountRoutes is list of routes that any account may use to manage its
+// own sessions and API tokens, whatever its role is.
+var accountRoutes = []string{
+	"/api/login",
+	"/api/refresh",
+	"/api/logout",
+	"/api/sessions",
+	"/api/tokens",
+}
+
+// ownerRoutes is list of routes that only owner may use,
+// since they manage accounts or the server itself.
+var ownerRoutes = []string{
+	"/api/accounts",
+	"/api/maintenance",
+	"/api/backup",
+	"/api/repair",
+	"/api/lockouts",
+	"/api/webhooks",
+	"/api/rearchive",
+	"/api/snapshots",
+}
+
+// ownerDeleteRoutes is list of routes where only owner may delete.
+var ownerDeleteRoutes = []string{
+	"/api/bookmarks",
+}
+
+// ownerWriteRoutes is list of routes where only owner may change anything,
+// since tags and their settings are shared by the bookmarks of every account.
+var ownerWriteRoutes = []string{
+	"/api/tag",
+	"/api/tags",
+}
+
+// routePath returns path of the request relative to root path.
+func (h *handler) routePath(r *http.Request) string {
+	rootPath := strings.TrimSuffix(h.RootPath, "/")
+	return "/" + strings.Trim(strings.TrimPrefix(r.URL.Path, rootPath), "/")
+}
+
+// authenticateToken authenticates the request that has either access token
+// of login session or API token in `Authorization: Bearer <token>` header.
+// Access token is a JWT, which is told apart by its dots. Request with token
+// that isn't valid, e.g. already expired or revoked, is rejected, and so is
+// request that might change something but only has read-only API token.
+// Request without the header is authenticated by the session cookie of web
+// interface instead, which is simply ignored when its session has ended.
+func (h *handler) authenticateToken(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		token, ok := bearerToken(r)
+		if !ok {
+			if cookie, err := r.Cookie(sessionCookie); err == nil {
+				if session, account, valid := h.refreshSession(cookie.Value); valid {
+					r = withSession(r, account, session.ID)
+				}
+			}
+
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		if strings.Count(token, ".") == 2 {
+			account, sessionID, valid := h.authenticateSession(token)
+			if valid {
+				next.ServeHTTP(w, withSession(r, account, sessionID))
+				return
+			}
+		} else if apiToken, account, exist := h.DB.GetAPITokenAccount(hashAPIToken(token)); exist {
+			if apiToken.Scope == model.TokenScopeRead && !isSafeMethod(r.Method) {
+				h.writeError(w, r, http.StatusForbidden, "API token is read-only")
+				return
+			}
+
+			next.ServeHTTP(w, withAccount(r, account))
+			return
+		}
+
+		w.Header().Set("WWW-Authenticate", `Bearer error="invalid_token"`)
+		h.writeError(w, r, http.StatusUnauthorized, "token is invalid, expired or revoked")
+	})
+}
+
+// isSafeMethod checks if the request method only reads, i.e. it doesn't change anything.
+func isSafeMethod(method string) bool {
+	return method == http.MethodGet || method == http.MethodHead || method == http.MethodOptions
+}
+
+// matchRoute checks if routePath is the route or one of its sub routes.
+func matchRoute(routePath string, routes []string) bool {
+	for _, route := range routes {
+		if routePath == route || strings.HasPrefix(routePath, route+"/") {
+			return true
+		}
+	}
+
+	return false
+}
+
+// requiredRole returns the role that account must have for the request.
+func requiredRole(method, routePath string) string {
+	switch {
+	case matchRoute(routePath, accountRoutes):
+		return model.RoleViewer
+	case matchRoute(routePath, ownerRoutes):
+		return model.RoleOwner
+	case method == http.MethodDelete && matchRoute(routePath, ownerDeleteRoutes):
+		return model.RoleOwner
+	case !isSafeMethod(method) && matchRoute(routePath, ownerWriteRoutes):
+		return model.RoleOwner
+	case isSafeMethod(method):
+		return model.RoleViewer
+	default:
+		return model.RoleEditor
+	}
+}
+
+// authorizeRole rejects request from account whose role isn't allowed to do
+// it: viewer can only read, editor can add and edit bookmarks, while owner can
+// manage accounts and delete data as well. Request that isn't authenticated
+// may only use the public routes.
+func (h *handler) authorizeRole(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		routePath := h.routePath(r)
+		account, ok := requestAccount(r)
+		switch {
+		case !ok && matchRoute(routePath, publicRoutes):
+		case !ok:
+			w.Header().Set("WWW-Authenticate", "Bearer")
+			h.writeError(w, r, http.StatusUnauthorized, "login is required")
+			return
+		default:
+			role := requiredRole(r.Method, routePath)
+			if !account.HasRole(role) {
+				h.writeError(w, r, http.StatusForbidden, fmt.Sprintf("%s role is required", role))
+				return
+			}
+		}
+
+		next.ServeHTTP(w, r)
+	})
+}
+
+// limitRequestBody restricts size of the request body, so client can't
+// exhaust server's memory by sending a gigantic payload.
+func (h *handler) limitRequestBody(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		limit := h.MaxBodySize
+		routePath := h.routePath(r)
+		for _, route := range largeBodyRoutes {
+			if strings.HasPrefix(routePath, route) {
+				limit = h.MaxUploadSize
+				break
+			}
+		}
+
+		if limit > 0 {
+			if r.ContentLength > limit {
+				h.writeError(w, r, http.StatusRequestEntityTooLarge, errBodyTooLarge)
+				return
+			}
+
+			r.Body = http.MaxBytesReader(w, r.Body, limit)
+		}
+
+		next.ServeHTTP(w, r)
+	})
+}
+
+// preventAPICache makes sure API responses are never cached, so client
+// always receives the latest list of bookmarks.
+func (h *handler) preventAPICache(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if strings.HasPrefix(h.routePath(r), "/api/") {
+			w.Header().Set("Cache-Control", "no-store")
+		}
+
+		next.ServeHTTP(w, r)
+	})
+}
+
+// rejectWritesInReadOnly rejects every request that might change something
+// while the server is in read-only mode, so the database and data dir can
+// be safely backed up or migrated. Only safe methods are still served.
+func (h *handler) rejectWritesInReadOnly(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.Method {
+		case http.MethodGet, http.MethodHead, http.MethodOptions:
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		if h.isReadOnly() {
+			routePath := h.routePath(r)
+			for _, route := range readOnlyRoutes {
+				if routePath == route {
+					next.ServeHTTP(w, r)
+					return
+				}
+			}
+
+			msg := "server is in read-only mode for maintenance, try again later"
+			h.writeError(w, r, http.StatusServiceUnavailable, msg)
+			return
+		}
+
+		next.ServeHTTP(w, r)
+	})
+}
+
+// statusRecorder is ResponseWriter that remembers the response status
+// and how many bytes of body that written.
+type statusRecorder struct {
+	http.ResponseWriter
+	status int
+	size   int
+}
+
+func (rec *statusRecorder) WriteHeader(status int) {
+	rec.status = status
+	rec.ResponseWriter.WriteHeader(status)
+}
+
+func (rec *statusRecorder) Write(p []byte) (int, error) {
+	if rec.status == 0 {
+		rec.status = http.StatusOK
+	}
+
+	n, err := rec.ResponseWriter.Write(p)
+	rec.size += n
+	return n, err
+}
+
+// Flush sends the buffered response to client, so streamed
+// response like CSV export still works through the recorder.
+func (rec *statusRecorder) Flush() {
+	if flusher, ok := rec.ResponseWriter.(http.Flusher); ok {
+		flusher.Flush()
+	}
+}
+
+// Unwrap returns the original ResponseWriter, so http.ResponseController
+// may still control the response, e.g. its write deadline.
+func (rec *statusRecorder) Unwrap() http.ResponseWriter {
+	return rec.ResponseWriter
+}
+
+// logRequests logs every request once it's served, along with its status
+// and how long it took. Server errors are logged as error, so they stand
+// out from the rest which only logged as info, while health checks are
+// only logged as debug since they're polled all the time.
+func (h *handler) logRequests(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		rec := &statusRecorder{ResponseWriter: w}
+		next.ServeHTTP(rec, r)
+
+		if rec.status == 0 {
+			rec.status = http.StatusOK
+		}
+
+		entry := logrus.WithFields(logrus.Fields{
+			"method":      r.Method,
+			"path":        r.URL.Path,
+			"status":      rec.status,
+			"bytes":       rec.size,
+			"duration_ms": int64(time.Since(start) / time.Millisecond),
+			"ip":          clientIP(r),
+		})
+
+		switch {
+		case rec.status >= http.StatusInternalServerError:
+			entry.Error("request failed")
+		case matchRoute(h.routePath(r), probeRoutes):
+			entry.Debug("request served")
+		default:
+			entry.Info("request served")
+		}
+	})
+}
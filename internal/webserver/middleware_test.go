@@ -0,0 +1,292 @@
+package webserver
+
+import (
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"shiori/internal/model"
+	"github.com/julienschmidt/httprouter"
+	"github.com/sirupsen/logrus"
+	logtest "github.com/sirupsen/logrus/hooks/test"
+)
+
+func Test_limitRequestBody(t *testing.T) {
+	hdl := &handler{
+		RootPath:      "/",
+		MaxBodySize:   16,
+		MaxUploadSize: 64,
+	}
+
+	router := httprouter.New()
+	router.DELETE("/api/bookmarks", hdl.apiDeleteBookmark)
+	router.POST("/api/bookmarks/ext", func(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+		_, err := ioutil.ReadAll(r.Body)
+		checkError(err)
+	})
+	router.POST("/api/bookmarks/capture", func(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+		_, err := ioutil.ReadAll(r.Body)
+		checkError(err)
+	})
+	router.PanicHandler = hdl.handlePanic
+
+	server := hdl.limitRequestBody(router)
+	largeBody := "[" + strings.Repeat("1,", 20) + "1]"
+
+	tests := []struct {
+		name    string
+		method  string
+		path    string
+		body    io.Reader
+		chunked bool
+		want    int
+	}{{
+		name:   "oversized body with content length",
+		method: "DELETE",
+		path:   "/api/bookmarks",
+		body:   strings.NewReader(largeBody),
+		want:   http.StatusRequestEntityTooLarge,
+	}, {
+		name:    "oversized body without content length",
+		method:  "DELETE",
+		path:    "/api/bookmarks",
+		body:    strings.NewReader(largeBody),
+		chunked: true,
+		want:    http.StatusRequestEntityTooLarge,
+	}, {
+		name:   "large body in upload route",
+		method: "POST",
+		path:   "/api/bookmarks/ext",
+		body:   strings.NewReader(largeBody),
+		want:   http.StatusOK,
+	}, {
+		name:   "large body in capture route",
+		method: "POST",
+		path:   "/api/bookmarks/capture",
+		body:   strings.NewReader(largeBody),
+		want:   http.StatusOK,
+	}, {
+		name:   "oversized body in upload route",
+		method: "POST",
+		path:   "/api/bookmarks/ext",
+		body:   strings.NewReader(strings.Repeat(largeBody, 2)),
+		want:   http.StatusRequestEntityTooLarge,
+	}}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(tt.method, tt.path, tt.body)
+			if tt.chunked {
+				req.ContentLength = -1
+			}
+
+			rec := httptest.NewRecorder()
+			server.ServeHTTP(rec, req)
+
+			if rec.Code != tt.want {
+				t.Errorf("limitRequestBody() status = %d, want %d", rec.Code, tt.want)
+			}
+		})
+	}
+}
+
+func Test_preventAPICache(t *testing.T) {
+	hdl := &handler{RootPath: "/shiori/"}
+	server := hdl.preventAPICache(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+
+	tests := []struct {
+		path string
+		want string
+	}{
+		{"/shiori/api/bookmarks", "no-store"},
+		{"/shiori/api/tags", "no-store"},
+		{"/shiori/bookmark/1/thumb", ""},
+		{"/shiori/js/app.js", ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.path, func(t *testing.T) {
+			rec := httptest.NewRecorder()
+			server.ServeHTTP(rec, httptest.NewRequest("GET", tt.path, nil))
+
+			if got := rec.Header().Get("Cache-Control"); got != tt.want {
+				t.Errorf("Cache-Control = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func Test_rejectWritesInReadOnly(t *testing.T) {
+	hdl := &handler{RootPath: "/shiori/"}
+	hdl.setReadOnly(true)
+
+	ok := func(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {}
+	router := httprouter.New()
+	router.GET("/shiori/api/bookmarks", ok)
+	router.POST("/shiori/api/bookmarks", ok)
+	router.DELETE("/shiori/api/bookmarks", ok)
+	router.GET("/shiori/bookmark/1/content", ok)
+	router.GET("/shiori/api/maintenance", hdl.apiGetMaintenance)
+	router.PUT("/shiori/api/maintenance", hdl.apiSetMaintenance)
+	router.PanicHandler = hdl.handlePanic
+
+	server := hdl.rejectWritesInReadOnly(router)
+
+	tests := []struct {
+		name   string
+		method string
+		path   string
+		body   string
+		want   int
+	}{
+		{"read bookmarks", "GET", "/shiori/api/bookmarks", "", http.StatusOK},
+		{"read content", "GET", "/shiori/bookmark/1/content", "", http.StatusOK},
+		{"insert bookmark", "POST", "/shiori/api/bookmarks", "", http.StatusServiceUnavailable},
+		{"delete bookmarks", "DELETE", "/shiori/api/bookmarks", "", http.StatusServiceUnavailable},
+		{"read maintenance", "GET", "/shiori/api/maintenance", "", http.StatusOK},
+		{"disable read-only", "PUT", "/shiori/api/maintenance", `{"readOnly": false}`, http.StatusOK},
+		{"insert after disabled", "POST", "/shiori/api/bookmarks", "", http.StatusOK},
+		{"enable read-only", "PUT", "/shiori/api/maintenance", `{"readOnly": true}`, http.StatusOK},
+		{"delete after enabled", "DELETE", "/shiori/api/bookmarks", "", http.StatusServiceUnavailable},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(tt.method, tt.path, strings.NewReader(tt.body))
+			rec := httptest.NewRecorder()
+			server.ServeHTTP(rec, req)
+
+			if rec.Code != tt.want {
+				t.Errorf("rejectWritesInReadOnly() status = %d, want %d", rec.Code, tt.want)
+			}
+		})
+	}
+}
+
+func Test_requiredRole(t *testing.T) {
+	tests := []struct {
+		method    string
+		routePath string
+		want      string
+	}{
+		{"GET", "/api/bookmarks", model.RoleViewer},
+		{"GET", "/bookmark/1/archive/", model.RoleViewer},
+		{"POST", "/api/bookmarks", model.RoleEditor},
+		{"PUT", "/api/bookmarks/tags", model.RoleEditor},
+		{"DELETE", "/api/import/abc", model.RoleEditor},
+		{"DELETE", "/api/bookmarks", model.RoleOwner},
+		{"DELETE", "/api/bookmarks/ext", model.RoleOwner},
+		{"GET", "/api/accounts", model.RoleOwner},
+		{"PUT", "/api/maintenance", model.RoleOwner},
+		{"GET", "/api/backup", model.RoleOwner},
+		{"GET", "/api/rearchive/runs", model.RoleOwner},
+		{"GET", "/api/snapshots/runs", model.RoleOwner},
+		{"POST", "/api/tokens", model.RoleViewer},
+		{"DELETE", "/api/sessions/1", model.RoleViewer},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.method+" "+tt.routePath, func(t *testing.T) {
+			if got := requiredRole(tt.method, tt.routePath); got != tt.want {
+				t.Errorf("requiredRole() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func Test_authorizeRole(t *testing.T) {
+	hdl := &handler{}
+	next := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	})
+
+	tests := []struct {
+		name       string
+		method     string
+		path       string
+		account    *model.Account
+		wantStatus int
+	}{
+		{"anonymous reads", "GET", "/api/bookmarks", nil, http.StatusUnauthorized},
+		{"anonymous deletes", "DELETE", "/api/bookmarks", nil, http.StatusUnauthorized},
+		{"anonymous logs out", "POST", "/api/logout", nil, http.StatusUnauthorized},
+		{"anonymous logs in", "POST", "/api/login", nil, http.StatusOK},
+		{"anonymous creates token", "POST", "/api/tokens", nil, http.StatusOK},
+		{"anonymous opens index", "GET", "/", nil, http.StatusOK},
+		{"anonymous opens shared link", "GET", "/share/abc/content", nil, http.StatusOK},
+		{"viewer reads", "GET", "/api/bookmarks", &model.Account{Role: model.RoleViewer}, http.StatusOK},
+		{"viewer inserts", "POST", "/api/bookmarks", &model.Account{Role: model.RoleViewer}, http.StatusForbidden},
+		{"editor inserts", "POST", "/api/bookmarks", &model.Account{Role: model.RoleEditor}, http.StatusOK},
+		{"editor deletes", "DELETE", "/api/bookmarks", &model.Account{Role: model.RoleEditor}, http.StatusForbidden},
+		{"editor deletes tag", "DELETE", "/api/tag/1", &model.Account{Role: model.RoleEditor}, http.StatusForbidden},
+		{"editor renames tag", "PUT", "/api/tag", &model.Account{Role: model.RoleEditor}, http.StatusForbidden},
+		{"editor sets tag visibility", "PUT", "/api/tags/visibility", &model.Account{Role: model.RoleEditor}, http.StatusForbidden},
+		{"editor reads tags", "GET", "/api/tags", &model.Account{Role: model.RoleEditor}, http.StatusOK},
+		{"editor manages accounts", "POST", "/api/accounts", &model.Account{Role: model.RoleEditor}, http.StatusForbidden},
+		{"owner deletes", "DELETE", "/api/bookmarks", &model.Account{Role: model.RoleOwner}, http.StatusOK},
+		{"owner renames tag", "PUT", "/api/tag", &model.Account{Role: model.RoleOwner}, http.StatusOK},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(tt.method, tt.path, nil)
+			if tt.account != nil {
+				req = withAccount(req, *tt.account)
+			}
+
+			rec := httptest.NewRecorder()
+			hdl.authorizeRole(next).ServeHTTP(rec, req)
+
+			if rec.Code != tt.wantStatus {
+				t.Errorf("authorizeRole() status = %d, want %d", rec.Code, tt.wantStatus)
+			}
+		})
+	}
+}
+
+func Test_logRequests(t *testing.T) {
+	hook := logtest.NewGlobal()
+	defer hook.Reset()
+
+	hdl := &handler{RootPath: "/"}
+	server := hdl.logRequests(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/api/broken" {
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+
+		io.WriteString(w, "hello")
+	}))
+
+	tests := []struct {
+		path      string
+		wantLevel logrus.Level
+		wantCode  int
+		wantBytes int
+	}{
+		{"/api/tags", logrus.InfoLevel, http.StatusOK, 5},
+		{"/api/broken", logrus.ErrorLevel, http.StatusInternalServerError, 0},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.path, func(t *testing.T) {
+			hook.Reset()
+			req := httptest.NewRequest("GET", tt.path, nil)
+			server.ServeHTTP(httptest.NewRecorder(), req)
+
+			entry := hook.LastEntry()
+			if entry == nil {
+				t.Fatalf("request is not logged")
+			}
+
+			if entry.Level != tt.wantLevel || entry.Data["path"] != tt.path ||
+				entry.Data["status"] != tt.wantCode || entry.Data["bytes"] != tt.wantBytes {
+				t.Errorf("logged %v %v, want %v with status %d and %d bytes",
+					entry.Level, entry.Data, tt.wantLevel, tt.wantCode, tt.wantBytes)
+			}
+		})
+	}
+}
@@ -0,0 +1,274 @@
+package webserver
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"path"
+	"strings"
+	"time"
+
+	"shiori/internal/model"
+	"github.com/coreos/go-oidc"
+	"github.com/julienschmidt/httprouter"
+	"golang.org/x/oauth2"
+)
+
+// OIDCConfig is the OpenID Connect provider, e.g. Authelia, Keycloak or
+// Google, that accounts may log in with. It's disabled when Issuer is empty.
+type OIDCConfig struct {
+	Issuer       string
+	ClientID     string
+	ClientSecret string
+
+	// RedirectURL is the URL of callback route that registered
+	// in the provider, i.e. <server URL>/auth/oidc/callback.
+	RedirectURL string
+
+	// UsernameClaim is the claim of ID token that links the user
+	// to the local account with the same username.
+	UsernameClaim string
+}
+
+// oidcStateAge is how long user has to log in at the provider.
+var oidcStateAge = 10 * time.Minute
+
+// oidcStateCookie is the cookie that binds the login to the browser
+// which started it, so the callback can't be replayed in another one.
+const oidcStateCookie = "shiori-oidc-state"
+
+// oidcProvider is the OpenID Connect provider that discovered on start.
+type oidcProvider struct {
+	oauth2.Config
+	verifier      *oidc.IDTokenVerifier
+	usernameClaim string
+}
+
+// oidcLogin is the login that started, which kept until the provider
+// redirects the user back to the callback.
+type oidcLogin struct {
+	Nonce    string
+	Verifier string
+}
+
+// newOIDCProvider discovers the endpoints and keys of the provider.
+func newOIDCProvider(ctx context.Context, cfg OIDCConfig) (*oidcProvider, error) {
+	provider, err := oidc.NewProvider(ctx, cfg.Issuer)
+	if err != nil {
+		return nil, err
+	}
+
+	oauthConfig := oauth2.Config{
+		ClientID:     cfg.ClientID,
+		ClientSecret: cfg.ClientSecret,
+		RedirectURL:  cfg.RedirectURL,
+		Endpoint:     provider.Endpoint(),
+		Scopes:       []string{oidc.ScopeOpenID, "profile", "email"},
+	}
+
+	return &oidcProvider{
+		Config:        oauthConfig,
+		verifier:      provider.Verifier(&oidc.Config{ClientID: cfg.ClientID}),
+		usernameClaim: cfg.UsernameClaim,
+	}, nil
+}
+
+// oidcCodeChallenge returns the PKCE challenge of code verifier.
+func oidcCodeChallenge(verifier string) string {
+	sum := sha256.Sum256([]byte(verifier))
+	return base64.RawURLEncoding.EncodeToString(sum[:])
+}
+
+// oidcUsername returns the username in claims of ID token. Email is only
+// used once the provider has verified it, since it names the new account.
+func oidcUsername(claims map[string]interface{}, usernameClaim string) (string, error) {
+	username, _ := claims[usernameClaim].(string)
+	username = strings.TrimSpace(username)
+	if username == "" {
+		return "", fmt.Errorf("ID token doesn't have %s claim", usernameClaim)
+	}
+
+	if usernameClaim == "email" {
+		if verified, _ := claims["email_verified"].(bool); !verified {
+			return "", fmt.Errorf("email is not verified by the provider")
+		}
+	}
+
+	return username, nil
+}
+
+// errUsernameTaken is returned when new user of OpenID Connect provider
+// has the same username as an account that isn't linked to it.
+var errUsernameTaken = errors.New("username is used by another account")
+
+// linkOIDCAccount returns the local account that linked to the user of the
+// provider, identified by its issuer and subject. It's created with viewer
+// role and the username on the user's first login. Account that created here
+// has random password, so it can only log in through the provider. Existing
+// account is never linked just because it has the same username.
+func (h *handler) linkOIDCAccount(issuer, subject, username string) (model.Account, error) {
+	if account, exist := h.DB.GetAccountByIdentity(issuer, subject); exist {
+		return account, nil
+	}
+
+	if _, exist := h.DB.GetAccount(username); exist {
+		return model.Account{}, errUsernameTaken
+	}
+
+	password, err := newAPIToken()
+	if err != nil {
+		return model.Account{}, err
+	}
+
+	err = h.DB.SaveAccount(model.Account{
+		Username: username,
+		Password: password,
+		Role:     model.RoleViewer,
+		Provider: model.AccountProviderOIDC,
+		Issuer:   issuer,
+		Subject:  subject,
+	})
+	if err != nil {
+		return model.Account{}, err
+	}
+
+	account, _ := h.DB.GetAccount(username)
+	return account, nil
+}
+
+// serveOIDCLogin is handler for GET /auth/oidc/login
+//
+// It redirects the user to log in at the provider. The state, which also
+// kept in cookie, ties the callback to this login, while the nonce ties the
+// ID token to it. PKCE is used as well, so the code is useless to anyone
+// who intercepts it.
+func (h *handler) serveOIDCLogin(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	if h.OIDC == nil {
+		h.writeError(w, r, http.StatusNotFound, "OpenID Connect is not configured")
+		return
+	}
+
+	state, err := newAPIToken()
+	checkError(err)
+
+	login := oidcLogin{}
+	login.Nonce, err = newAPIToken()
+	checkError(err)
+
+	login.Verifier, err = newAPIToken()
+	checkError(err)
+
+	h.OIDCCache.Set(state, login, oidcStateAge)
+	http.SetCookie(w, &http.Cookie{
+		Name:     oidcStateCookie,
+		Value:    state,
+		Path:     path.Join(h.RootPath, "auth/oidc"),
+		MaxAge:   int(oidcStateAge.Seconds()),
+		Secure:   r.TLS != nil,
+		HttpOnly: true,
+		SameSite: http.SameSiteLaxMode,
+	})
+
+	authURL := h.OIDC.AuthCodeURL(state,
+		oidc.Nonce(login.Nonce),
+		oauth2.SetAuthURLParam("code_challenge", oidcCodeChallenge(login.Verifier)),
+		oauth2.SetAuthURLParam("code_challenge_method", "S256"))
+
+	http.Redirect(w, r, authURL, http.StatusFound)
+}
+
+// serveOIDCCallback is handler for GET /auth/oidc/callback
+//
+// The provider redirects the user here once logged in. The user is linked
+// to the local account by its issuer and subject, which is created on its
+// first login with the username in the configured claim. Just like POST /api/login, it responds
+// with the tokens of new login session.
+func (h *handler) serveOIDCCallback(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	if h.OIDC == nil {
+		h.writeError(w, r, http.StatusNotFound, "OpenID Connect is not configured")
+		return
+	}
+
+	query := r.URL.Query()
+	if errCode := query.Get("error"); errCode != "" {
+		msg := fmt.Sprintf("provider rejected the login: %s %s", errCode, query.Get("error_description"))
+		h.writeError(w, r, http.StatusUnauthorized, strings.TrimSpace(msg))
+		return
+	}
+
+	// Make sure the callback is for the login that started in this browser.
+	// Each login is only used once, whether it succeeds or not.
+	state := query.Get("state")
+	cookie, err := r.Cookie(oidcStateCookie)
+	if err != nil || state == "" || cookie.Value != state {
+		h.writeError(w, r, http.StatusBadRequest, "login state doesn't match")
+		return
+	}
+
+	cached, exist := h.OIDCCache.Get(state)
+	h.OIDCCache.Delete(state)
+	login, ok := cached.(oidcLogin)
+	if !exist || !ok {
+		h.writeError(w, r, http.StatusBadRequest, "login is expired, please try again")
+		return
+	}
+
+	http.SetCookie(w, &http.Cookie{
+		Name:   oidcStateCookie,
+		Path:   path.Join(h.RootPath, "auth/oidc"),
+		MaxAge: -1,
+	})
+
+	// Exchange the code for ID token, then verify it
+	ctx, cancel := context.WithTimeout(r.Context(), 30*time.Second)
+	defer cancel()
+
+	token, err := h.OIDC.Exchange(ctx, query.Get("code"),
+		oauth2.SetAuthURLParam("code_verifier", login.Verifier))
+	if err != nil {
+		h.writeError(w, r, http.StatusUnauthorized, fmt.Sprintf("failed to exchange code: %v", err))
+		return
+	}
+
+	rawIDToken, _ := token.Extra("id_token").(string)
+	idToken, err := h.OIDC.verifier.Verify(ctx, rawIDToken)
+	if err != nil {
+		h.writeError(w, r, http.StatusUnauthorized, fmt.Sprintf("ID token is invalid: %v", err))
+		return
+	}
+
+	if idToken.Nonce != login.Nonce {
+		h.writeError(w, r, http.StatusUnauthorized, "ID token is not issued for this login")
+		return
+	}
+
+	// Link the user to local account, then start its session
+	claims := map[string]interface{}{}
+	err = idToken.Claims(&claims)
+	checkError(err)
+
+	username, err := oidcUsername(claims, h.OIDC.usernameClaim)
+	if err != nil {
+		h.writeError(w, r, http.StatusUnauthorized, err.Error())
+		return
+	}
+
+	account, err := h.linkOIDCAccount(idToken.Issuer, idToken.Subject, username)
+	if err == errUsernameTaken {
+		h.writeError(w, r, http.StatusConflict, fmt.Sprintf("username %q is already used by another account", username))
+		return
+	}
+	checkError(err)
+
+	resp, err := h.startSession(account)
+	checkError(err)
+	h.setSessionCookie(w, r, resp)
+
+	w.Header().Set("Content-Type", "application/json")
+	err = json.NewEncoder(w).Encode(&resp)
+	checkError(err)
+}
@@ -0,0 +1,148 @@
+package webserver
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+
+	"shiori/internal/model"
+	"golang.org/x/oauth2"
+)
+
+func Test_oidcUsername(t *testing.T) {
+	tests := []struct {
+		name          string
+		claims        map[string]interface{}
+		usernameClaim string
+		want          string
+		wantErr       bool
+	}{
+		{"username", map[string]interface{}{"preferred_username": "alice"}, "preferred_username", "alice", false},
+		{"missing claim", map[string]interface{}{"email": "alice@example.com"}, "preferred_username", "", true},
+		{"claim is not string", map[string]interface{}{"preferred_username": 42.0}, "preferred_username", "", true},
+		{"verified email", map[string]interface{}{"email": "alice@example.com", "email_verified": true}, "email", "alice@example.com", false},
+		{"unverified email", map[string]interface{}{"email": "alice@example.com", "email_verified": false}, "email", "", true},
+		{"email without verification", map[string]interface{}{"email": "alice@example.com"}, "email", "", true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := oidcUsername(tt.claims, tt.usernameClaim)
+			if (err != nil) != tt.wantErr || got != tt.want {
+				t.Errorf("oidcUsername() = %q, %v, want %q, error %v", got, err, tt.want, tt.wantErr)
+			}
+		})
+	}
+}
+
+func Test_linkOIDCAccount(t *testing.T) {
+	hdl, cleanup := newTestHandler(t)
+	defer cleanup()
+
+	err := hdl.DB.SaveAccount(model.Account{Username: "alice", Password: "secret", Role: model.RoleEditor})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Local account is never linked just because of its username
+	issuer := "https://auth.example.com"
+	if _, err = hdl.linkOIDCAccount(issuer, "1001", "alice"); err != errUsernameTaken {
+		t.Errorf("linkOIDCAccount() of local account error = %v, want %v", err, errUsernameTaken)
+	}
+
+	// New user gets viewer account
+	bob, err := hdl.linkOIDCAccount(issuer, "1002", "bob")
+	if err != nil || bob.ID == 0 || bob.Role != model.RoleViewer || bob.Provider != model.AccountProviderOIDC {
+		t.Errorf("linkOIDCAccount() of new user = %+v, %v", bob, err)
+	}
+
+	// The same user is linked again even after its username changed
+	account, err := hdl.linkOIDCAccount(issuer, "1002", "robert")
+	if err != nil || account.ID != bob.ID {
+		t.Errorf("linkOIDCAccount() of renamed user = %+v, %v, want account %d", account, err, bob.ID)
+	}
+
+	// Another user, or the same subject of another issuer, can't take the username
+	if _, err = hdl.linkOIDCAccount(issuer, "1003", "bob"); err != errUsernameTaken {
+		t.Errorf("linkOIDCAccount() of another user error = %v, want %v", err, errUsernameTaken)
+	}
+
+	if _, err = hdl.linkOIDCAccount("https://evil.example.com", "1002", "bob"); err != errUsernameTaken {
+		t.Errorf("linkOIDCAccount() from another issuer error = %v, want %v", err, errUsernameTaken)
+	}
+}
+
+func Test_serveOIDCLogin(t *testing.T) {
+	hdl, cleanup := newTestHandler(t)
+	defer cleanup()
+
+	hdl.RootPath = "/"
+	hdl.OIDC = &oidcProvider{Config: oauth2.Config{
+		ClientID:    "shiori",
+		RedirectURL: "https://shiori.example.com/auth/oidc/callback",
+		Endpoint:    oauth2.Endpoint{AuthURL: "https://auth.example.com/authorize"},
+	}}
+
+	rec := httptest.NewRecorder()
+	hdl.serveOIDCLogin(rec, httptest.NewRequest("GET", "/auth/oidc/login", nil), nil)
+	if rec.Code != http.StatusFound {
+		t.Fatalf("serveOIDCLogin() status = %d, want %d", rec.Code, http.StatusFound)
+	}
+
+	location, err := url.Parse(rec.Header().Get("Location"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	query := location.Query()
+	state := query.Get("state")
+	if state == "" || query.Get("nonce") == "" || query.Get("code_challenge_method") != "S256" {
+		t.Errorf("serveOIDCLogin() redirects to %s, want state, nonce and PKCE", location)
+	}
+
+	cookies := rec.Result().Cookies()
+	if len(cookies) != 1 || cookies[0].Name != oidcStateCookie || cookies[0].Value != state {
+		t.Fatalf("serveOIDCLogin() cookies = %v, want state cookie", cookies)
+	}
+
+	cached, exist := hdl.OIDCCache.Get(state)
+	login, _ := cached.(oidcLogin)
+	if !exist || login.Nonce != query.Get("nonce") || oidcCodeChallenge(login.Verifier) != query.Get("code_challenge") {
+		t.Errorf("serveOIDCLogin() saves login %+v, which doesn't match the redirect", login)
+	}
+
+	// Callback is rejected when the state isn't the one in cookie,
+	// or when the login isn't known, e.g. already used or expired.
+	tests := []struct {
+		name   string
+		query  string
+		cookie string
+	}{
+		{"no cookie", "state=" + state + "&code=x", ""},
+		{"different state", "state=other&code=x", state},
+		{"unknown login", "state=other&code=x", "other"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest("GET", "/auth/oidc/callback?"+tt.query, nil)
+			if tt.cookie != "" {
+				req.AddCookie(&http.Cookie{Name: oidcStateCookie, Value: tt.cookie})
+			}
+
+			rec := httptest.NewRecorder()
+			hdl.serveOIDCCallback(rec, req, nil)
+			if rec.Code != http.StatusBadRequest {
+				t.Errorf("serveOIDCCallback() status = %d, want %d", rec.Code, http.StatusBadRequest)
+			}
+		})
+	}
+
+	// Provider that rejects the login is reported as unauthorized
+	rec = httptest.NewRecorder()
+	hdl.serveOIDCCallback(rec, httptest.NewRequest("GET", "/auth/oidc/callback?error=access_denied", nil), nil)
+	if rec.Code != http.StatusUnauthorized {
+		t.Errorf("serveOIDCCallback() of rejected login status = %d, want %d", rec.Code, http.StatusUnauthorized)
+	}
+}
@@ -0,0 +1,146 @@
+package webserver
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"net"
+	"net/http"
+	"strconv"
+	"time"
+
+	"shiori/internal/model"
+	cch "github.com/patrickmn/go-cache"
+)
+
+// insertQuotaWindow is the period where the insert quota applies.
+var insertQuotaWindow = time.Hour
+
+// archivalQuotaRetry is the interval of checking whether background archival
+// may start, while its account has used all of its archival quota.
+var archivalQuotaRetry = time.Second
+
+// errQuotaExceeded is returned when client has used all of its quota.
+type errQuotaExceeded struct {
+	message    string
+	retryAfter time.Duration
+}
+
+func (err errQuotaExceeded) Error() string {
+	return err.message
+}
+
+// quotaAccount returns the account whose quota is used by the request.
+// Request authenticated by API token uses the quota of its account, while
+// the others are identified by their IP address. The owner is exempted
+// from the quota, which is marked by an empty account.
+func quotaAccount(r *http.Request) string {
+	if account, ok := requestAccount(r); ok {
+		if account.HasRole(model.RoleOwner) {
+			return ""
+		}
+
+		return "account:" + account.Username
+	}
+
+	return clientIP(r)
+}
+
+// clientIP returns IP address of the client that sent the request.
+func clientIP(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+
+	return host
+}
+
+// useInsertQuota records that account inserts a new bookmark. It returns
+// error if the account has inserted more than allowed in current window.
+func (h *handler) useInsertQuota(account string) error {
+	if h.InsertQuota <= 0 || account == "" {
+		return nil
+	}
+
+	// Start a new window if there are none yet. When the window expired
+	// right between Add and Increment, just start it again.
+	key := "insert:" + account
+	h.QuotaCache.Add(key, 0, insertQuotaWindow)
+	nInsert, err := h.QuotaCache.IncrementInt(key, 1)
+	if err != nil {
+		h.QuotaCache.Add(key, 1, insertQuotaWindow)
+		nInsert = 1
+	}
+
+	if nInsert <= h.InsertQuota {
+		return nil
+	}
+
+	var retryAfter time.Duration
+	if _, expiration, found := h.QuotaCache.GetWithExpiration(key); found {
+		retryAfter = time.Until(expiration)
+	}
+
+	return errQuotaExceeded{
+		message:    fmt.Sprintf("max %d bookmarks inserted per hour", h.InsertQuota),
+		retryAfter: retryAfter,
+	}
+}
+
+// useArchivalQuota reserves n concurrent archival operations for account.
+// If it succeed, the returned function must be called once the archival
+// finished to release the reservation.
+func (h *handler) useArchivalQuota(account string, n int) (func(), error) {
+	if h.ArchivalQuota <= 0 || n <= 0 || account == "" {
+		return func() {}, nil
+	}
+
+	key := "archival:" + account
+	h.QuotaCache.Add(key, 0, cch.NoExpiration)
+	nArchival, err := h.QuotaCache.IncrementInt(key, n)
+	if err != nil {
+		return nil, err
+	}
+
+	release := func() {
+		h.QuotaCache.DecrementInt(key, n)
+	}
+
+	if nArchival > h.ArchivalQuota {
+		release()
+		return nil, errQuotaExceeded{
+			message: fmt.Sprintf("max %d archival running at the same time", h.ArchivalQuota),
+		}
+	}
+
+	return release, nil
+}
+
+// waitArchivalQuota reserves an archival for account, like useArchivalQuota,
+// for archival that runs in background. While the account has used all of
+// its quota, it waits for the other archival to finish, until ctx is done.
+func (h *handler) waitArchivalQuota(ctx context.Context, account string) (func(), error) {
+	for {
+		release, err := h.useArchivalQuota(account, 1)
+		if _, isQuotaErr := err.(errQuotaExceeded); !isQuotaErr {
+			return release, err
+		}
+
+		select {
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case <-time.After(archivalQuotaRetry):
+		}
+	}
+}
+
+// writeQuotaError responds to client which has exceeded its quota.
+func writeQuotaError(w http.ResponseWriter, err errQuotaExceeded) {
+	if err.retryAfter > 0 {
+		seconds := int(math.Ceil(err.retryAfter.Seconds()))
+		w.Header().Set("Retry-After", strconv.Itoa(seconds))
+	}
+
+	writeAPIError(w, http.StatusTooManyRequests, err.message)
+}
@@ -0,0 +1,143 @@
+package webserver
+
+import (
+	"context"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"shiori/internal/model"
+	cch "github.com/patrickmn/go-cache"
+)
+
+func Test_quotaAccount(t *testing.T) {
+	tests := []struct {
+		name       string
+		remoteAddr string
+		account    *model.Account
+		want       string
+	}{
+		{"ipv4", "192.0.2.1:1234", nil, "192.0.2.1"},
+		{"ipv6", "[2001:db8::1]:1234", nil, "2001:db8::1"},
+		{"no port", "192.0.2.1", nil, "192.0.2.1"},
+		{"token", "192.0.2.1:1234", &model.Account{ID: 2, Username: "alice"}, "account:alice"},
+		{"editor token", "192.0.2.1:1234", &model.Account{ID: 2, Username: "alice", Role: model.RoleEditor}, "account:alice"},
+		{"owner token", "192.0.2.1:1234", &model.Account{ID: 1, Username: "admin", Role: model.RoleOwner}, ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest("GET", "/", nil)
+			req.RemoteAddr = tt.remoteAddr
+			if tt.account != nil {
+				req = withAccount(req, *tt.account)
+			}
+
+			if got := quotaAccount(req); got != tt.want {
+				t.Errorf("quotaAccount() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func Test_useInsertQuota(t *testing.T) {
+	hdl := &handler{
+		QuotaCache:  cch.New(time.Hour, time.Hour),
+		InsertQuota: 2,
+	}
+
+	tests := []struct {
+		account string
+		wantErr bool
+	}{
+		{"alice", false},
+		{"alice", false},
+		{"alice", true},
+		{"bob", false},
+		{"alice", true},
+	}
+
+	for _, tt := range tests {
+		err := hdl.useInsertQuota(tt.account)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("useInsertQuota(%q) error = %v, wantErr %v", tt.account, err, tt.wantErr)
+		}
+	}
+}
+
+func Test_useArchivalQuota(t *testing.T) {
+	hdl := &handler{
+		QuotaCache:    cch.New(time.Hour, time.Hour),
+		ArchivalQuota: 3,
+	}
+
+	release, err := hdl.useArchivalQuota("alice", 2)
+	if err != nil {
+		t.Fatalf("useArchivalQuota() error = %v", err)
+	}
+
+	if _, err := hdl.useArchivalQuota("alice", 2); err == nil {
+		t.Errorf("useArchivalQuota() over quota returns no error")
+	}
+
+	if _, err := hdl.useArchivalQuota("bob", 3); err != nil {
+		t.Errorf("useArchivalQuota() for other account error = %v", err)
+	}
+
+	// Once released, the quota is available again
+	release()
+	if _, err := hdl.useArchivalQuota("alice", 3); err != nil {
+		t.Errorf("useArchivalQuota() after release error = %v", err)
+	}
+}
+
+func Test_waitArchivalQuota(t *testing.T) {
+	defer func(retry time.Duration) { archivalQuotaRetry = retry }(archivalQuotaRetry)
+	archivalQuotaRetry = 10 * time.Millisecond
+
+	hdl := &handler{
+		QuotaCache:    cch.New(time.Hour, time.Hour),
+		ArchivalQuota: 1,
+	}
+
+	release, err := hdl.useArchivalQuota("alice", 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Archival waits until the running one released its quota
+	acquired := make(chan error)
+	go func() {
+		releaseWaited, err := hdl.waitArchivalQuota(context.Background(), "alice")
+		if err == nil {
+			releaseWaited()
+		}
+		acquired <- err
+	}()
+
+	select {
+	case err := <-acquired:
+		t.Fatalf("waitArchivalQuota() returns %v while quota is used", err)
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	release()
+	select {
+	case err := <-acquired:
+		if err != nil {
+			t.Errorf("waitArchivalQuota() after release error = %v", err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("waitArchivalQuota() never returns after release")
+	}
+
+	// Waiting stops once the context is done
+	release, _ = hdl.useArchivalQuota("alice", 1)
+	defer release()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	if _, err := hdl.waitArchivalQuota(ctx, "alice"); err != context.DeadlineExceeded {
+		t.Errorf("waitArchivalQuota() after context done error = %v, want %v", err, context.DeadlineExceeded)
+	}
+}
@@ -0,0 +1,352 @@
+package webserver
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+
+	"shiori/internal/core"
+	"shiori/internal/database"
+	"shiori/internal/model"
+	"github.com/gofrs/uuid"
+	"github.com/julienschmidt/httprouter"
+	"github.com/sirupsen/logrus"
+)
+
+// rearchiveLogSize is how many of the latest runs are kept, while
+// rearchiveMaxFailures is how many failed bookmarks are kept for each run.
+var (
+	rearchiveLogSize     = 20
+	rearchiveMaxFailures = 100
+)
+
+// rearchivePageSize is number of bookmarks loaded at once in each run,
+// which is small since their content is loaded as well.
+const rearchivePageSize = 20
+
+// Status of run of scheduled re-archiving.
+const (
+	rearchiveRunning     = "running"
+	rearchiveFinished    = "finished"
+	rearchiveFailed      = "failed"
+	rearchiveSkipped     = "skipped"
+	rearchiveInterrupted = "interrupted"
+)
+
+// RearchiveConfig is the schedule for archiving again the bookmarks. When
+// Tags is empty, every bookmark is archived again, otherwise only the ones
+// with any of the tags or their child tags. Nil Schedule disables it.
+type RearchiveConfig struct {
+	Schedule *core.CronSchedule
+	Tags     []string
+}
+
+// rearchiveSchedule tells when the bookmarks are archived again next.
+// Zero time means it never comes again.
+type rearchiveSchedule interface {
+	Next(t time.Time) time.Time
+	String() string
+}
+
+// intervalSchedule is schedule that repeats in fixed interval.
+type intervalSchedule time.Duration
+
+// Next returns when the interval has passed since t.
+func (d intervalSchedule) Next(t time.Time) time.Time {
+	return t.Add(time.Duration(d))
+}
+
+func (d intervalSchedule) String() string {
+	return "@every " + time.Duration(d).String()
+}
+
+// rearchiveFailure is bookmark that failed to be archived again.
+type rearchiveFailure struct {
+	ID    int    `json:"id"`
+	URL   string `json:"url"`
+	Error string `json:"error"`
+}
+
+// rearchiveRun is a run of scheduled re-archiving.
+type rearchiveRun struct {
+	ID        string             `json:"id"`
+	Status    string             `json:"status"`
+	Started   string             `json:"started"`
+	Finished  string             `json:"finished,omitempty"`
+	Processed int                `json:"processed"`
+	Archived  int                `json:"archived"`
+	Unchanged int                `json:"unchanged"`
+	Failed    int                `json:"failed"`
+	Failures  []rearchiveFailure `json:"failures,omitempty"`
+	Error     string             `json:"error,omitempty"`
+}
+
+// rearchiveScheduler archives again the bookmarks that match its filter on
+// its schedule, and keeps the log of its latest runs. It's used both for
+// re-archiving on cron schedule and for taking snapshots in interval.
+type rearchiveScheduler struct {
+	sync.Mutex
+	name     string
+	schedule rearchiveSchedule
+	filter   database.GetBookmarksOptions
+	next     time.Time
+	runs     []*rearchiveRun
+}
+
+// newRearchiveScheduler creates scheduler for re-archiving.
+// Returns nil if it's not scheduled.
+func newRearchiveScheduler(cfg RearchiveConfig) *rearchiveScheduler {
+	if cfg.Schedule == nil {
+		return nil
+	}
+
+	return &rearchiveScheduler{
+		name:     "re-archiving",
+		schedule: cfg.Schedule,
+		filter:   database.GetBookmarksOptions{AnyTags: cfg.Tags},
+	}
+}
+
+// newSnapshotScheduler creates scheduler for archiving again the bookmarks
+// with versioned archive in the interval. Since the past archive is kept as
+// snapshot, each bookmark has a snapshot for every interval its content has
+// changed. Returns nil if interval isn't positive.
+func newSnapshotScheduler(interval time.Duration) *rearchiveScheduler {
+	if interval <= 0 {
+		return nil
+	}
+
+	return &rearchiveScheduler{
+		name:     "snapshot",
+		schedule: intervalSchedule(interval),
+		filter:   database.GetBookmarksOptions{Versioned: true},
+	}
+}
+
+// newRearchiveRunID creates random ID for run of scheduled re-archiving.
+func newRearchiveRunID() (string, error) {
+	id, err := uuid.NewV4()
+	if err != nil {
+		return "", err
+	}
+
+	return id.String(), nil
+}
+
+// start adds new run with the status to the log, dropping the oldest one
+// when it's full.
+func (s *rearchiveScheduler) start(status string) (*rearchiveRun, error) {
+	id, err := newRearchiveRunID()
+	if err != nil {
+		return nil, err
+	}
+
+	run := &rearchiveRun{
+		ID:      id,
+		Status:  status,
+		Started: time.Now().UTC().Format(time.RFC3339),
+	}
+
+	s.Lock()
+	defer s.Unlock()
+
+	s.runs = append(s.runs, run)
+	if len(s.runs) > rearchiveLogSize {
+		s.runs = s.runs[len(s.runs)-rearchiveLogSize:]
+	}
+
+	return run, nil
+}
+
+// record counts the result of archiving the bookmark again in the run.
+func (s *rearchiveScheduler) record(run *rearchiveRun, book model.Bookmark, err error) {
+	s.Lock()
+	defer s.Unlock()
+
+	run.Processed++
+	switch err {
+	case nil:
+		run.Archived++
+	case core.ErrUnchanged:
+		run.Unchanged++
+	default:
+		run.Failed++
+		if len(run.Failures) < rearchiveMaxFailures {
+			run.Failures = append(run.Failures, rearchiveFailure{
+				ID:    book.ID,
+				URL:   book.URL,
+				Error: err.Error(),
+			})
+		}
+	}
+}
+
+// finish marks the run as finished with the status.
+func (s *rearchiveScheduler) finish(run *rearchiveRun, status string, err error) {
+	s.Lock()
+	defer s.Unlock()
+
+	run.Status = status
+	run.Finished = time.Now().UTC().Format(time.RFC3339)
+	if err != nil {
+		run.Error = err.Error()
+	}
+}
+
+// runRearchiveSchedule archives again the bookmarks of the scheduler every
+// time its schedule is due. It returns once the server is shutting down, or
+// when the schedule never comes.
+func (h *handler) runRearchiveSchedule(s *rearchiveScheduler) {
+	for {
+		next := s.schedule.Next(time.Now())
+		if next.IsZero() {
+			logrus.WithFields(logrus.Fields{
+				"job":      s.name,
+				"schedule": s.schedule.String(),
+			}).Error("schedule of archiving never comes")
+			return
+		}
+
+		s.Lock()
+		s.next = next
+		s.Unlock()
+
+		time.Sleep(time.Until(next))
+		if h.Background.isClosed() {
+			return
+		}
+
+		// Nothing is archived while the server is in read-only mode
+		if h.isReadOnly() {
+			run, err := s.start(rearchiveSkipped)
+			if err == nil {
+				s.finish(run, rearchiveSkipped, fmt.Errorf("server is in read-only mode"))
+			}
+			continue
+		}
+
+		h.rearchive(s)
+	}
+}
+
+// rearchive archives again the bookmarks of the scheduler, one by one, and
+// records the result as new run in its log.
+func (h *handler) rearchive(s *rearchiveScheduler) {
+	run, err := s.start(rearchiveRunning)
+	if err != nil {
+		logrus.WithError(err).WithField("job", s.name).Error("failed to start scheduled archiving")
+		return
+	}
+
+	logEntry := logrus.WithFields(logrus.Fields{"job": s.name, "run": run.ID})
+	logEntry.Info("scheduled archiving started")
+
+	filter := s.filter
+	filter.WithContent = true
+	filter.Limit = rearchivePageSize
+
+	for {
+		bookmarks, err := h.DB.GetBookmarks(filter)
+		if err != nil {
+			logEntry.WithError(err).Error("failed to get bookmarks for scheduled archiving")
+			s.finish(run, rearchiveFailed, err)
+			return
+		}
+
+		for _, book := range bookmarks {
+			// Stop archiving once the server is shutting down
+			if !h.Background.begin() {
+				logEntry.Warn("scheduled archiving interrupted by shutdown")
+				s.finish(run, rearchiveInterrupted, nil)
+				return
+			}
+
+			err = h.archiveAgain(book)
+			h.Background.done()
+			s.record(run, book, err)
+
+			entry := logEntry.WithFields(logrus.Fields{"bookmark": book.ID, "url": book.URL})
+			switch err {
+			case nil:
+				entry.Info("archived bookmark again")
+			case core.ErrUnchanged:
+				entry.Debug("bookmark is unchanged since archived")
+			default:
+				entry.WithError(err).Error("failed to archive bookmark again")
+			}
+		}
+
+		if len(bookmarks) < rearchivePageSize {
+			break
+		}
+
+		filter.Offset += rearchivePageSize
+	}
+
+	s.finish(run, rearchiveFinished, nil)
+	logEntry.Info("scheduled archiving finished")
+}
+
+// rearchiveStatus is the schedule of re-archiving and its latest runs.
+type rearchiveStatus struct {
+	Schedule string         `json:"schedule"`
+	Tags     []string       `json:"tags"`
+	Next     string         `json:"next,omitempty"`
+	Runs     []rearchiveRun `json:"runs"`
+}
+
+// status returns copy of the schedule and the runs in log, newest first.
+func (s *rearchiveScheduler) status() rearchiveStatus {
+	status := rearchiveStatus{
+		Tags: []string{},
+		Runs: []rearchiveRun{},
+	}
+
+	if s == nil {
+		return status
+	}
+
+	s.Lock()
+	defer s.Unlock()
+
+	status.Schedule = s.schedule.String()
+	status.Tags = append(status.Tags, s.filter.AnyTags...)
+	if !s.next.IsZero() {
+		status.Next = s.next.UTC().Format(time.RFC3339)
+	}
+
+	for i := len(s.runs) - 1; i >= 0; i-- {
+		run := *s.runs[i]
+		run.Failures = append([]rearchiveFailure(nil), run.Failures...)
+		status.Runs = append(status.Runs, run)
+	}
+
+	return status
+}
+
+// apiGetRearchiveRuns is handler for GET /api/rearchive/runs
+//
+// It returns the schedule of re-archiving, when it's due next, and its
+// latest runs, newest first, including the one that still running. The
+// log is kept in memory, so it's empty again after the server restarted.
+func (h *handler) apiGetRearchiveRuns(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	status := h.Rearchive.status()
+
+	w.Header().Set("Content-Type", "application/json")
+	err := json.NewEncoder(w).Encode(&status)
+	checkError(err)
+}
+
+// apiGetSnapshotRuns is handler for GET /api/snapshots/runs
+//
+// It's like GET /api/rearchive/runs, for the runs that take snapshots of
+// bookmarks with versioned archive in the server's snapshot interval.
+func (h *handler) apiGetSnapshotRuns(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	status := h.Snapshots.status()
+
+	w.Header().Set("Content-Type", "application/json")
+	err := json.NewEncoder(w).Encode(&status)
+	checkError(err)
+}
@@ -0,0 +1,157 @@
+package webserver
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"shiori/internal/core"
+	"shiori/internal/model"
+)
+
+func Test_rearchive(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/html; charset=UTF-8")
+		fmt.Fprint(w, "<html><head><title>Page</title></head><body><p>Fresh content</p></body></html>")
+	}))
+	defer srv.Close()
+
+	hdl, cleanup := newTestHandler(t)
+	defer cleanup()
+
+	schedule, err := core.ParseCronSchedule("@weekly")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	hdl.Rearchive = newRearchiveScheduler(RearchiveConfig{
+		Schedule: &schedule,
+		Tags:     []string{"go", "web"},
+	})
+
+	// Bookmark with any of the tags is archived again, while the untagged
+	// one is left out. The unreachable bookmark fails.
+	_, err = hdl.DB.SaveBookmarks(
+		model.Bookmark{ID: 1, URL: srv.URL + "/page", Title: "Tagged", Tags: []model.Tag{{Name: "go"}}},
+		model.Bookmark{ID: 2, URL: "http://127.0.0.1:1/unreachable", Title: "Unreachable", Tags: []model.Tag{{Name: "go"}}},
+		model.Bookmark{ID: 3, URL: srv.URL + "/other", Title: "Untagged"},
+		model.Bookmark{ID: 4, URL: srv.URL + "/web", Title: "Other tag", Tags: []model.Tag{{Name: "web/css"}}},
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	hdl.rearchive(hdl.Rearchive)
+	hdl.rearchive(hdl.Rearchive)
+
+	rec := httptest.NewRecorder()
+	req := newOwnerRequest("GET", "/api/rearchive/runs", nil)
+	hdl.apiGetRearchiveRuns(rec, req, nil)
+
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status = %d, body = %s", rec.Code, rec.Body)
+	}
+
+	status := rearchiveStatus{}
+	if err := json.NewDecoder(rec.Body).Decode(&status); err != nil {
+		t.Fatal(err)
+	}
+
+	if status.Schedule != "@weekly" || len(status.Tags) != 2 {
+		t.Errorf("schedule = %q, tags = %v, want @weekly for go and web", status.Schedule, status.Tags)
+	}
+
+	if len(status.Runs) != 2 || status.Runs[0].ID == status.Runs[1].ID {
+		t.Fatalf("runs = %+v, want 2 distinct runs", status.Runs)
+	}
+
+	for _, run := range status.Runs {
+		if run.Status != rearchiveFinished || run.Finished == "" {
+			t.Errorf("run status = %q, finished = %q, want finished", run.Status, run.Finished)
+		}
+
+		if run.Processed != 3 || run.Archived+run.Unchanged != 2 || run.Failed != 1 {
+			t.Errorf("run = %+v, want 3 processed with 1 failed", run)
+		}
+
+		if len(run.Failures) != 1 || run.Failures[0].URL != "http://127.0.0.1:1/unreachable" {
+			t.Errorf("failures = %+v, want the unreachable bookmark", run.Failures)
+		}
+	}
+}
+
+func Test_rearchiveSnapshots(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/html; charset=UTF-8")
+		fmt.Fprint(w, "<html><head><title>Page</title></head><body><p>Content</p></body></html>")
+	}))
+	defer srv.Close()
+
+	hdl, cleanup := newTestHandler(t)
+	defer cleanup()
+
+	hdl.Snapshots = newSnapshotScheduler(time.Hour)
+	if newSnapshotScheduler(0) != nil {
+		t.Errorf("snapshot scheduler without interval is created")
+	}
+
+	// Only the bookmark with versioned archive is archived again
+	_, err := hdl.DB.SaveBookmarks(
+		model.Bookmark{ID: 1, URL: srv.URL + "/versioned", Title: "Versioned", VersionedArchive: true},
+		model.Bookmark{ID: 2, URL: srv.URL + "/other", Title: "Other"},
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	hdl.rearchive(hdl.Snapshots)
+
+	rec := httptest.NewRecorder()
+	hdl.apiGetSnapshotRuns(rec, newOwnerRequest("GET", "/api/snapshots/runs", nil), nil)
+
+	status := rearchiveStatus{}
+	if err := json.NewDecoder(rec.Body).Decode(&status); err != nil {
+		t.Fatal(err)
+	}
+
+	if status.Schedule != "@every 1h0m0s" || len(status.Runs) != 1 {
+		t.Fatalf("status = %+v, want 1 run every hour", status)
+	}
+
+	if run := status.Runs[0]; run.Status != rearchiveFinished || run.Processed != 1 || run.Archived != 1 {
+		t.Errorf("run = %+v, want the versioned bookmark archived", run)
+	}
+}
+
+func Test_rearchiveSchedulerLog(t *testing.T) {
+	oldSize := rearchiveLogSize
+	rearchiveLogSize = 2
+	defer func() { rearchiveLogSize = oldSize }()
+
+	// Scheduler that isn't configured has empty log
+	var s *rearchiveScheduler
+	if status := s.status(); status.Schedule != "" || len(status.Runs) != 0 {
+		t.Errorf("status = %+v, want empty", status)
+	}
+
+	schedule, _ := core.ParseCronSchedule("@daily")
+	s = newRearchiveScheduler(RearchiveConfig{Schedule: &schedule})
+
+	ids := []string{}
+	for i := 0; i < 3; i++ {
+		run, err := s.start(rearchiveRunning)
+		if err != nil {
+			t.Fatal(err)
+		}
+		ids = append(ids, run.ID)
+	}
+
+	// Only the latest runs are kept, newest first
+	runs := s.status().Runs
+	if len(runs) != 2 || runs[0].ID != ids[2] || runs[1].ID != ids[1] {
+		t.Errorf("runs = %+v, want %v newest first", runs, ids[1:])
+	}
+}
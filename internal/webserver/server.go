@@ -1,15 +1,20 @@
 package webserver
 
 import (
+	"context"
 	"fmt"
+	"net"
 	"net/http"
 	"path"
+	"strconv"
 	"time"
 
+	"shiori/internal/core"
 	"shiori/internal/database"
 	"github.com/julienschmidt/httprouter"
 	cch "github.com/patrickmn/go-cache"
 	"github.com/sirupsen/logrus"
+	"golang.org/x/crypto/acme/autocert"
 )
 
 // Config is parameter that used for starting web server
@@ -19,22 +24,188 @@ type Config struct {
 	ServerAddress string
 	ServerPort    int
 	RootPath      string
+	MaxBodySize   int64
+	MaxUploadSize int64
+	StrictJSON    bool
+	StrictProcess bool
+	Concurrency   int
+	ArchiveLimit  int
+	MaxResources  int
+	MaxSnapshots  int
+	Build         BuildInfo
+
+	// MaxPageSize is max number of bookmarks in each page that client
+	// may request using `per_page`. Zero means there is no limit.
+	MaxPageSize int
+
+	// ArchiveOnInsert decides whether new bookmark is archived by default.
+	// Client may override it using `createArchive` field when inserting.
+	ArchiveOnInsert bool
+
+	// ArchivalPolicy decides which bookmarks may be archived.
+	// Client may ignore it per request using `ignoreArchivalPolicy=true`.
+	ArchivalPolicy core.ArchivalPolicy
+
+	// RenderPolicy decides which bookmarks are rendered
+	// in headless browser before processed.
+	RenderPolicy core.RenderPolicy
+
+	// KeptQueryParams is query parameters that never removed
+	// from bookmark URL, keyed by domain.
+	KeptQueryParams core.KeptQueryParams
+
+	// FailOnStatus is the HTTP status codes that make new bookmark
+	// rejected when its page is downloaded with one of them. By
+	// default the bookmark is saved whatever the status code is.
+	FailOnStatus []int
+
+	// Archive pruning options. Pruning is disabled
+	// unless ArchiveMaxAge or ArchiveMaxSize is set.
+	ArchiveMaxAge  time.Duration
+	ArchiveMaxSize int64
+	PruneInterval  time.Duration
+
+	// SnapshotInterval is the interval between archiving again the bookmarks
+	// with versioned archive, so their past archives are kept as snapshots.
+	// Zero means they're only archived again on request.
+	SnapshotInterval time.Duration
+
+	// LinkCheckInterval is the interval between checking whether the URL
+	// of every bookmark is still reachable. Zero means it's never checked.
+	LinkCheckInterval time.Duration
+
+	// Rearchive is the schedule for archiving again the bookmarks,
+	// so their archives stay fresh. It's disabled unless scheduled.
+	Rearchive RearchiveConfig
+
+	// Quota for each account, 0 means unlimited. InsertQuota is max number
+	// of bookmarks inserted per hour, while ArchivalQuota is max number of
+	// archival running at the same time.
+	InsertQuota   int
+	ArchivalQuota int
+
+	// ReadOnly starts the server in read-only mode, where every change
+	// is rejected. It can be toggled later in PUT /api/maintenance.
+	ReadOnly bool
+
+	// TLS options. When both TLSCertFile and TLSKeyFile are set, or ACMEDomains
+	// is not empty, the server will use HTTPS instead of plain HTTP.
+	TLSCertFile  string
+	TLSKeyFile   string
+	ACMEDomains  []string
+	ACMEEmail    string
+	ACMECacheDir string
+	RedirectHTTP string
+
+	// LDAP is the directory that used to authenticate accounts
+	// in addition to the local ones, if its URL is set.
+	LDAP LDAPConfig
+
+	// OIDC is the OpenID Connect provider that accounts
+	// may log in with, if its issuer is set.
+	OIDC OIDCConfig
+
+	// LoginMaxFailures is how many failed logins in a row lock the username
+	// for LoginLockout. Zero means username is never locked, although
+	// failed logins are still slowed down.
+	LoginMaxFailures int
+	LoginLockout     time.Duration
+
+	// Webhooks is the URLs that notified when bookmarks are
+	// created, updated, deleted or archived.
+	Webhooks WebhookConfig
+
+	// ShutdownTimeout is how long the server waits for running requests
+	// and background work, e.g. archival, once asked to shut down.
+	ShutdownTimeout time.Duration
 }
 
 // ServeApp serves wb interface in specified port
 func ServeApp(cfg Config) error {
-	// Create handler
+	// Load the key for signing access tokens of login sessions
+	sessionKey, err := loadSessionKey(cfg.DataDir)
+	if err != nil {
+		return fmt.Errorf("failed to load session key: %v", err)
+	}
+
+	// Create handler, whose background work is tracked,
+	// including the webhook deliveries
+	background := &backgroundWork{}
 	hdl := handler{
-		DB:           cfg.DB,
-		DataDir:      cfg.DataDir,
-		UserCache:    cch.New(time.Hour, 10*time.Minute),
-		ArchiveCache: cch.New(time.Minute, 5*time.Minute),
-		RootPath:     cfg.RootPath,
+		DB:              cfg.DB,
+		DataDir:         cfg.DataDir,
+		ArchiveCache:    cch.New(time.Minute, 5*time.Minute),
+		InsertCache:     cch.New(24*time.Hour, time.Hour),
+		QuotaCache:      cch.New(time.Hour, 10*time.Minute),
+		ImportCache:     cch.New(24*time.Hour, time.Hour),
+		OIDCCache:       cch.New(oidcStateAge, time.Minute),
+		RootPath:        cfg.RootPath,
+		MaxBodySize:     cfg.MaxBodySize,
+		MaxUploadSize:   cfg.MaxUploadSize,
+		StrictJSON:      cfg.StrictJSON,
+		StrictProcess:   cfg.StrictProcess,
+		ArchiveOnInsert: cfg.ArchiveOnInsert,
+		Concurrency:     cfg.Concurrency,
+		ArchiveLimit:    cfg.ArchiveLimit,
+		MaxResources:    cfg.MaxResources,
+		MaxSnapshots:    cfg.MaxSnapshots,
+		MaxPageSize:     cfg.MaxPageSize,
+		InsertQuota:     cfg.InsertQuota,
+		ArchivalQuota:   cfg.ArchivalQuota,
+		Build:           cfg.Build,
+		ArchivalPolicy:  cfg.ArchivalPolicy,
+		RenderPolicy:    cfg.RenderPolicy,
+		KeptQueryParams: cfg.KeptQueryParams,
+		FailOnStatus:    cfg.FailOnStatus,
+		SessionKey:      sessionKey,
+		LDAP:            cfg.LDAP,
+		LoginLimiter:    newLoginLimiter(cfg.LoginMaxFailures, cfg.LoginLockout),
+		Webhooks:        newWebhookDispatcher(cfg.Webhooks, background),
+		Background:      background,
+		Rearchive:       newRearchiveScheduler(cfg.Rearchive),
+		Snapshots:       newSnapshotScheduler(cfg.SnapshotInterval),
 	}
 
+	hdl.setReadOnly(cfg.ReadOnly)
 	hdl.prepareArchiveCache()
 
-	err := hdl.prepareTemplates()
+	// Discover the OpenID Connect provider, if needed
+	if cfg.OIDC.Issuer != "" {
+		ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+		hdl.OIDC, err = newOIDCProvider(ctx, cfg.OIDC)
+		cancel()
+		if err != nil {
+			return fmt.Errorf("failed to discover OpenID Connect provider: %v", err)
+		}
+	}
+
+	// Start pruning archives, if needed
+	if cfg.ArchiveMaxAge > 0 || cfg.ArchiveMaxSize > 0 {
+		pruneRequest := core.PruneRequest{
+			DataDir: cfg.DataDir,
+			MaxAge:  cfg.ArchiveMaxAge,
+			MaxSize: cfg.ArchiveMaxSize,
+		}
+
+		go hdl.runArchivePruner(pruneRequest, cfg.PruneInterval)
+	}
+
+	// Start taking snapshots, if needed
+	if hdl.Snapshots != nil {
+		go hdl.runRearchiveSchedule(hdl.Snapshots)
+	}
+
+	// Start checking links, if needed
+	if cfg.LinkCheckInterval > 0 {
+		go hdl.runLinkChecker(cfg.LinkCheckInterval)
+	}
+
+	// Start re-archiving on schedule, if needed
+	if hdl.Rearchive != nil {
+		go hdl.runRearchiveSchedule(hdl.Rearchive)
+	}
+
+	err = hdl.prepareTemplates()
 	if err != nil {
 		return fmt.Errorf("failed to prepare templates: %v", err)
 	}
@@ -56,38 +227,159 @@ func ServeApp(cfg Config) error {
 	router.GET(jp("/bookmark/:id/thumb"), hdl.serveThumbnailImage)
 	router.GET(jp("/bookmark/:id/content"), hdl.serveBookmarkContent)
 	router.GET(jp("/bookmark/:id/archive/*filepath"), hdl.serveBookmarkArchive)
+	router.GET(jp("/bookmark/:id/resource"), hdl.serveArchivedResource)
+	router.GET(jp("/bookmark/:id/snapshot/:name/*filepath"), hdl.serveBookmarkSnapshot)
+	router.GET(jp("/bookmark/:id/screenshot"), hdl.serveBookmarkScreenshot)
+	router.GET(jp("/bookmark/:id/pdf"), hdl.serveBookmarkPDF)
+	router.GET(jp("/bookmark/:id/singlefile"), hdl.serveBookmarkSingleFile)
+
+	router.GET(jp("/share/:token/content"), hdl.serveSharedContent)
+	router.GET(jp("/share/:token/archive/*filepath"), hdl.serveSharedArchive)
+	router.GET(jp("/share/:token/screenshot"), hdl.serveSharedScreenshot)
+	router.GET(jp("/share/:token/pdf"), hdl.serveSharedPDF)
+	router.GET(jp("/share/:token/singlefile"), hdl.serveSharedSingleFile)
 
+	router.GET(jp("/feed.xml"), hdl.serveFeed)
+	router.GET(jp("/tag/:name/feed.xml"), hdl.serveFeed)
+
+	router.GET(jp("/api/version"), hdl.apiGetVersion)
+	router.GET(jp("/api/schema"), hdl.apiGetSchema)
+	router.GET(jp("/api/maintenance"), hdl.apiGetMaintenance)
+	router.PUT(jp("/api/maintenance"), hdl.apiSetMaintenance)
+	router.GET(jp("/api/backup"), hdl.apiBackup)
 	router.GET(jp("/api/bookmarks"), hdl.apiGetBookmarks)
+	router.GET(jp("/api/bookmarks/export.csv"), hdl.apiExportBookmarksCSV)
+	router.GET(jp("/api/bookmarks/export.json"), hdl.apiExportBookmarksPinboard)
+	router.GET(jp("/api/export"), hdl.apiExportBookmarks)
+	router.GET(jp("/api/bookmarks/thumbs"), hdl.apiGetThumbnails)
 	router.GET(jp("/api/tags"), hdl.apiGetTags)
-	router.PUT(jp("/api/tag"), hdl.apiRenameTag)
+	router.GET(jp("/api/tags/:id/related"), hdl.apiGetRelatedTags)
+	router.PUT(jp("/api/tag"), hdl.apiUpdateTag)
+	router.PUT(jp("/api/tags/replace"), hdl.apiReplaceTagNames)
+	router.PUT(jp("/api/tags/visibility"), hdl.apiSetTagDefaultPublic)
+	router.PUT(jp("/api/tag/:id/move"), hdl.apiMoveTag)
+	router.PUT(jp("/api/tag/:id/rename"), hdl.apiRenameTagSubtree)
+	router.DELETE(jp("/api/tag/:id"), hdl.apiDeleteTag)
 	router.POST(jp("/api/bookmarks"), hdl.apiInsertBookmark)
 	router.DELETE(jp("/api/bookmarks"), hdl.apiDeleteBookmark)
 	router.PUT(jp("/api/bookmarks"), hdl.apiUpdateBookmark)
+	router.PATCH(jp("/api/bookmarks/:id"), hdl.apiPatchBookmark)
+	router.GET(jp("/api/bookmark/:id/archive/resources"), hdl.apiGetArchiveResources)
+	router.GET(jp("/api/bookmark/:id/snapshots"), hdl.apiGetSnapshots)
+	router.GET(jp("/api/bookmark/:id/screenshot"), hdl.apiGetScreenshot)
+	router.PUT(jp("/api/bookmark/:id/star"), hdl.apiStarBookmark)
+	router.DELETE(jp("/api/bookmark/:id/star"), hdl.apiStarBookmark)
+	router.GET(jp("/api/bookmark/:id/shares"), hdl.apiGetShareLinks)
+	router.POST(jp("/api/bookmark/:id/shares"), hdl.apiInsertShareLink)
+	router.DELETE(jp("/api/bookmark/:id/shares/:shareId"), hdl.apiDeleteShareLink)
 	router.PUT(jp("/api/cache"), hdl.apiUpdateCache)
+	router.POST(jp("/api/repair"), hdl.apiRepair)
 	router.PUT(jp("/api/bookmarks/tags"), hdl.apiUpdateBookmarkTags)
+	router.PUT(jp("/api/bookmarks/tags/filter"), hdl.apiUpdateBookmarkTagsByFilter)
+	router.PUT(jp("/api/bookmarks/order"), hdl.apiSetBookmarksOrder)
+	router.PUT(jp("/api/bookmarks/read"), hdl.apiSetBookmarksRead)
+	router.PUT(jp("/api/bookmarks/collection"), hdl.apiSetBookmarksCollection)
+	router.GET(jp("/api/collections"), hdl.apiGetCollections)
+	router.POST(jp("/api/collections"), hdl.apiInsertCollection)
+	router.PUT(jp("/api/collections/:id"), hdl.apiUpdateCollection)
+	router.DELETE(jp("/api/collections/:id"), hdl.apiDeleteCollection)
 	router.POST(jp("/api/bookmarks/ext"), hdl.apiInsertViaExtension)
+	router.POST(jp("/api/bookmarks/capture"), hdl.apiInsertCapture)
 	router.DELETE(jp("/api/bookmarks/ext"), hdl.apiDeleteViaExtension)
+	router.POST(jp("/api/import"), hdl.apiImportBookmarks)
+	router.GET(jp("/api/import/:id"), hdl.apiGetImportProgress)
+	router.DELETE(jp("/api/import/:id"), hdl.apiCancelImport)
 
 	router.GET(jp("/api/accounts"), hdl.apiGetAccounts)
 	router.PUT(jp("/api/accounts"), hdl.apiUpdateAccount)
 	router.POST(jp("/api/accounts"), hdl.apiInsertAccount)
 	router.DELETE(jp("/api/accounts"), hdl.apiDeleteAccount)
+	router.POST(jp("/api/login"), hdl.apiLogin)
+	router.POST(jp("/api/refresh"), hdl.apiRefreshSession)
+	router.POST(jp("/api/logout"), hdl.apiLogout)
+	router.GET(jp("/api/sessions"), hdl.apiGetSessions)
+	router.DELETE(jp("/api/sessions/:id"), hdl.apiDeleteSession)
+	router.GET(jp("/api/tokens"), hdl.apiGetTokens)
+	router.POST(jp("/api/tokens"), hdl.apiInsertToken)
+	router.DELETE(jp("/api/tokens/:id"), hdl.apiDeleteToken)
+	router.GET(jp("/api/lockouts"), hdl.apiGetLockedAccounts)
+	router.DELETE(jp("/api/lockouts/:username"), hdl.apiUnlockAccount)
+	router.GET(jp("/api/webhooks/deliveries"), hdl.apiGetWebhookDeliveries)
+	router.GET(jp("/api/rearchive/runs"), hdl.apiGetRearchiveRuns)
+	router.GET(jp("/api/snapshots/runs"), hdl.apiGetSnapshotRuns)
+
+	router.GET(jp("/healthz"), hdl.serveHealth)
+	router.GET(jp("/readyz"), hdl.serveReadiness)
+
+	router.GET(jp("/auth/oidc/login"), hdl.serveOIDCLogin)
+	router.GET(jp("/auth/oidc/callback"), hdl.serveOIDCCallback)
 
 	// Route for panic
-	router.PanicHandler = func(w http.ResponseWriter, r *http.Request, arg interface{}) {
-		http.Error(w, fmt.Sprint(arg), 500)
-	}
+	router.PanicHandler = hdl.handlePanic
 
 	// Create server
 	url := fmt.Sprintf("%s:%d", cfg.ServerAddress, cfg.ServerPort)
 	svr := &http.Server{
-		Addr:         url,
-		Handler:      router,
+		Addr: url,
+		Handler: hdl.logRequests(hdl.limitRequestBody(hdl.authenticateToken(hdl.limitLogin(hdl.authorizeRole(
+			hdl.rejectWritesInReadOnly(hdl.preventAPICache(router))))))),
 		ReadTimeout:  10 * time.Second,
 		WriteTimeout: time.Minute,
 	}
 
+	// If TLS is not used, serve app as plain HTTP
+	useACME := len(cfg.ACMEDomains) > 0
+	if !useACME && cfg.TLSCertFile == "" {
+		logrus.WithField("address", url).Info("serving shiori")
+		return hdl.serveUntilShutdown(svr, svr.ListenAndServe, cfg.ShutdownTimeout)
+	}
+
+	// Prepare handler for plain HTTP listener, which redirects to HTTPS.
+	// When ACME is used, it also answers the HTTP-01 challenge.
+	var httpHandler http.Handler = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		redirectToHTTPS(w, r, cfg.ServerPort)
+	})
+
+	if useACME {
+		certManager := &autocert.Manager{
+			Prompt:     autocert.AcceptTOS,
+			HostPolicy: autocert.HostWhitelist(cfg.ACMEDomains...),
+			Cache:      autocert.DirCache(cfg.ACMECacheDir),
+			Email:      cfg.ACMEEmail,
+		}
+
+		svr.TLSConfig = certManager.TLSConfig()
+		httpHandler = certManager.HTTPHandler(httpHandler)
+	}
+
+	if cfg.RedirectHTTP != "" {
+		go func() {
+			logrus.WithField("address", cfg.RedirectHTTP).Info("redirecting HTTP to HTTPS")
+			err := http.ListenAndServe(cfg.RedirectHTTP, httpHandler)
+			if err != nil {
+				logrus.WithError(err).Error("HTTP redirect server stopped")
+			}
+		}()
+	}
+
 	// Serve app
-	logrus.Infoln("Serve shiori in", url)
-	return svr.ListenAndServe()
+	logrus.WithField("address", url).Info("serving shiori with HTTPS")
+	return hdl.serveUntilShutdown(svr, func() error {
+		return svr.ListenAndServeTLS(cfg.TLSCertFile, cfg.TLSKeyFile)
+	}, cfg.ShutdownTimeout)
+}
+
+// redirectToHTTPS redirects plain HTTP request to the same URL in HTTPS.
+func redirectToHTTPS(w http.ResponseWriter, r *http.Request, httpsPort int) {
+	host := r.Host
+	if h, _, err := net.SplitHostPort(host); err == nil {
+		host = h
+	}
+
+	if httpsPort != 443 {
+		host = net.JoinHostPort(host, strconv.Itoa(httpsPort))
+	}
+
+	target := "https://" + host + r.URL.RequestURI()
+	http.Redirect(w, r, target, http.StatusMovedPermanently)
 }
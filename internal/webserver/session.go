@@ -0,0 +1,234 @@
+package webserver
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path"
+	fp "path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"shiori/internal/model"
+	"github.com/sirupsen/logrus"
+	"golang.org/x/crypto/bcrypt"
+)
+
+// accessTokenAge is how long access token of a session is valid, while
+// sessionAge is how long the session itself, i.e. its refresh token, is.
+var (
+	accessTokenAge = 15 * time.Minute
+	sessionAge     = 30 * 24 * time.Hour
+)
+
+// sessionCookie is the cookie that keeps login session of the web interface,
+// since browser can't send access token in header when it loads thumbnail or
+// opens archive of private bookmark.
+const sessionCookie = "shiori-session"
+
+// sessionContextKey is the key of session ID that authenticated
+// the request, which stored in the request's context.
+type sessionContextKey struct{}
+
+// loadSessionKey reads the key for signing access tokens from data
+// directory. The key is created on first run, so sessions still work
+// after the server restarted.
+func loadSessionKey(dataDir string) ([]byte, error) {
+	keyPath := fp.Join(dataDir, "session.key")
+	key, err := ioutil.ReadFile(keyPath)
+	if err == nil && len(key) >= 32 {
+		return key, nil
+	} else if err != nil && !os.IsNotExist(err) {
+		return nil, err
+	}
+
+	key = make([]byte, 32)
+	if _, err = rand.Read(key); err != nil {
+		return nil, err
+	}
+
+	if err = ioutil.WriteFile(keyPath, key, 0600); err != nil {
+		return nil, err
+	}
+
+	return key, nil
+}
+
+// parseRefreshToken splits refresh token into its session ID and the hash
+// of its secret. Refresh token is the ID of the session followed by random
+// secret, which only its hash is stored in database.
+func parseRefreshToken(token string) (int, string, error) {
+	parts := strings.SplitN(token, ".", 2)
+	if len(parts) != 2 || parts[1] == "" {
+		return 0, "", fmt.Errorf("refresh token is malformed")
+	}
+
+	id, err := strconv.Atoi(parts[0])
+	if err != nil {
+		return 0, "", fmt.Errorf("refresh token is malformed")
+	}
+
+	return id, hashAPIToken(parts[1]), nil
+}
+
+// createAccessToken creates the access token of session for account.
+// Returns the token and the time it's expired.
+func (h *handler) createAccessToken(session model.Session, account model.Account) (string, time.Time, error) {
+	now := time.Now()
+	expires := now.Add(accessTokenAge)
+
+	token, err := signJWT(sessionClaims{
+		Subject:   account.Username,
+		AccountID: account.ID,
+		Role:      account.Role,
+		SessionID: session.ID,
+		IssuedAt:  now.Unix(),
+		ExpiresAt: expires.Unix(),
+	}, h.SessionKey)
+
+	return token, expires, err
+}
+
+// authenticateSession verifies access token of a session. The session must
+// still exist, so revoked session can't be used even before its access
+// token expired. Returns the account and ID of the session.
+func (h *handler) authenticateSession(token string) (model.Account, int, bool) {
+	claims, err := parseJWT(token, h.SessionKey)
+	if err != nil {
+		return model.Account{}, 0, false
+	}
+
+	_, account, exist := h.DB.GetSessionAccount(claims.SessionID)
+	if !exist || account.ID != claims.AccountID {
+		return model.Account{}, 0, false
+	}
+
+	return account, claims.SessionID, true
+}
+
+// refreshSession verifies refresh token, then returns its session and
+// account.
+func (h *handler) refreshSession(token string) (model.Session, model.Account, bool) {
+	id, hash, err := parseRefreshToken(token)
+	if err != nil {
+		return model.Session{}, model.Account{}, false
+	}
+
+	session, account, exist := h.DB.GetSessionAccount(id)
+	if !exist || !hmac.Equal([]byte(session.Hash), []byte(hash)) {
+		return model.Session{}, model.Account{}, false
+	}
+
+	return session, account, true
+}
+
+// withSession returns copy of the request that authenticated
+// as account using access token of the session.
+func withSession(r *http.Request, account model.Account, sessionID int) *http.Request {
+	ctx := context.WithValue(r.Context(), sessionContextKey{}, sessionID)
+	return withAccount(r.WithContext(ctx), account)
+}
+
+// requestSession returns ID of the session that authenticated the request.
+// Returns false if the request isn't authenticated by access token.
+func requestSession(r *http.Request) (int, bool) {
+	id, ok := r.Context().Value(sessionContextKey{}).(int)
+	return id, ok
+}
+
+// startSession starts new login session of the account, then creates
+// its access token and refresh token.
+func (h *handler) startSession(account model.Account) (sessionResponse, error) {
+	secret, err := newAPIToken()
+	if err != nil {
+		return sessionResponse{}, err
+	}
+
+	session, err := h.DB.SaveSession(model.Session{
+		AccountID: account.ID,
+		Hash:      hashAPIToken(secret),
+		Expires:   time.Now().UTC().Add(sessionAge).Format("2006-01-02 15:04:05"),
+	})
+	if err != nil {
+		return sessionResponse{}, err
+	}
+
+	accessToken, expires, err := h.createAccessToken(session, account)
+	if err != nil {
+		return sessionResponse{}, err
+	}
+
+	account.Password = ""
+	resp := sessionResponse{
+		AccessToken:  accessToken,
+		RefreshToken: strconv.Itoa(session.ID) + "." + secret,
+		Expires:      expires,
+		Session:      &session,
+		Account:      &account,
+	}
+
+	return resp, nil
+}
+
+// setSessionCookie keeps refresh token of the session in cookie, which
+// authenticates the requests of web interface. It's HttpOnly so scripts
+// can't read it, and other sites can't send it along with their requests
+// that might change something.
+func (h *handler) setSessionCookie(w http.ResponseWriter, r *http.Request, resp sessionResponse) {
+	http.SetCookie(w, &http.Cookie{
+		Name:     sessionCookie,
+		Value:    resp.RefreshToken,
+		Path:     path.Join("/", h.RootPath),
+		Expires:  time.Now().Add(sessionAge),
+		HttpOnly: true,
+		Secure:   r.TLS != nil,
+		SameSite: http.SameSiteLaxMode,
+	})
+}
+
+// clearSessionCookie removes the session cookie, e.g. after logout.
+func (h *handler) clearSessionCookie(w http.ResponseWriter) {
+	http.SetCookie(w, &http.Cookie{
+		Name:     sessionCookie,
+		Path:     path.Join("/", h.RootPath),
+		MaxAge:   -1,
+		HttpOnly: true,
+	})
+}
+
+// authenticatePassword returns the account with matching username and
+// password. Local account is checked first, then the directory if it's
+// configured, whose user gets local account on its first login. Like the
+// handlers, it panics with client error if the directory can't be used.
+func (h *handler) authenticatePassword(username, password string) (model.Account, bool) {
+	account, exist := h.DB.GetAccount(username)
+	if exist && bcrypt.CompareHashAndPassword([]byte(account.Password), []byte(password)) == nil {
+		return account, true
+	}
+
+	if !h.LDAP.enabled() {
+		return model.Account{}, false
+	}
+
+	user, valid, err := h.LDAP.authenticate(username, password)
+	if err != nil {
+		logrus.WithError(err).WithField("username", username).Warn("failed to authenticate in directory")
+		panic(newClientError(http.StatusServiceUnavailable, fmt.Errorf("directory is unavailable")))
+	} else if !valid {
+		return model.Account{}, false
+	}
+
+	account, err = h.provisionLDAPAccount(username, user)
+	if err == errLocalAccount {
+		logrus.WithField("username", username).Warn("directory user has the same username as local account")
+		return model.Account{}, false
+	}
+	checkError(err)
+
+	return account, true
+}
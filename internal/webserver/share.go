@@ -0,0 +1,174 @@
+package webserver
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"path"
+	fp "path/filepath"
+	"strconv"
+	"strings"
+
+	"shiori/internal/model"
+	"github.com/julienschmidt/httprouter"
+)
+
+// sharedBookmark returns the bookmark that client may manage the share
+// links of, which is identified by `id` in URL.
+func (h *handler) sharedBookmark(r *http.Request, ps httprouter.Params) model.Bookmark {
+	id, err := strconv.Atoi(ps.ByName("id"))
+	if err != nil {
+		panic(newClientError(http.StatusBadRequest, fmt.Errorf("bookmark id must be a number")))
+	}
+
+	book, exist := h.DB.GetBookmark(id, "")
+	if !exist || !canAccessBookmark(r, book) {
+		panic(newClientError(http.StatusNotFound, fmt.Errorf("Bookmark not found")))
+	}
+
+	return book
+}
+
+// shareURL returns the path of readable content that shared by the token.
+func (h *handler) shareURL(token string) string {
+	return path.Join(h.RootPath, "share", token, "content")
+}
+
+// apiInsertShareLink is handler for POST /api/bookmark/:id/shares
+//
+// It creates a link that lets anyone view the readable content and archive
+// of the bookmark without login, even though the bookmark isn't public. The
+// link is only returned here, since only the hash of its token is stored.
+func (h *handler) apiInsertShareLink(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	book := h.sharedBookmark(r, ps)
+
+	token, err := newAPIToken()
+	checkError(err)
+
+	link, err := h.DB.SaveShareLink(model.ShareLink{
+		BookmarkID: book.ID,
+		Hash:       hashAPIToken(token),
+	})
+	checkError(err)
+
+	link.Token = token
+	link.URL = h.shareURL(token)
+
+	w.Header().Set("Location", link.URL)
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	err = json.NewEncoder(w).Encode(&link)
+	checkError(err)
+}
+
+// apiGetShareLinks is handler for GET /api/bookmark/:id/shares
+//
+// It lists the share links of the bookmark, without their token.
+func (h *handler) apiGetShareLinks(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	book := h.sharedBookmark(r, ps)
+
+	links, err := h.DB.GetShareLinks(book.ID)
+	checkError(err)
+
+	w.Header().Set("Content-Type", "application/json")
+	err = json.NewEncoder(w).Encode(&links)
+	checkError(err)
+}
+
+// apiDeleteShareLink is handler for DELETE /api/bookmark/:id/shares/:shareId
+//
+// It revokes the share link, so the bookmark can't be viewed with it anymore.
+func (h *handler) apiDeleteShareLink(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	book := h.sharedBookmark(r, ps)
+
+	shareID, err := strconv.Atoi(ps.ByName("shareId"))
+	if err != nil {
+		panic(newClientError(http.StatusBadRequest, fmt.Errorf("share link id must be a number")))
+	}
+
+	deleted, err := h.DB.DeleteShareLink(book.ID, shareID)
+	checkError(err)
+
+	if !deleted {
+		panic(newClientError(http.StatusNotFound, fmt.Errorf("share link not found")))
+	}
+
+	fmt.Fprint(w, 1)
+}
+
+// sharedBookmarkByToken returns the bookmark that shared by the token in URL.
+func (h *handler) sharedBookmarkByToken(ps httprouter.Params) (model.Bookmark, string) {
+	token := ps.ByName("token")
+	book, exist := h.DB.GetSharedBookmark(hashAPIToken(token))
+	if token == "" || !exist {
+		panic(newClientError(http.StatusNotFound, fmt.Errorf("shared bookmark not found")))
+	}
+
+	return book, path.Join(h.RootPath, "share", token)
+}
+
+// serveSharedContent is handler for GET /share/:token/content
+//
+// It serves the readable content of bookmark that shared by the token,
+// just like GET /bookmark/:id/content, except its links stay under the
+// share link.
+func (h *handler) serveSharedContent(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	book, basePath := h.sharedBookmarkByToken(ps)
+
+	// Shared page shouldn't leak its token to the sites it links to
+	w.Header().Set("Referrer-Policy", "no-referrer")
+	h.renderBookmarkContent(w, r, book, basePath)
+}
+
+// serveSharedScreenshot is handler for GET /share/:token/screenshot
+//
+// It serves the full-page screenshot of bookmark that shared by the token,
+// just like GET /bookmark/:id/screenshot.
+func (h *handler) serveSharedScreenshot(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	book, _ := h.sharedBookmarkByToken(ps)
+	h.serveScreenshot(w, r, book.ID)
+}
+
+// serveSharedPDF is handler for GET /share/:token/pdf
+//
+// It serves the PDF snapshot of bookmark that shared by the token,
+// just like GET /bookmark/:id/pdf.
+func (h *handler) serveSharedPDF(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	book, _ := h.sharedBookmarkByToken(ps)
+	h.servePDF(w, r, book.ID)
+}
+
+// serveSharedSingleFile is handler for GET /share/:token/singlefile
+//
+// It serves the single-file archive of bookmark that shared by the token,
+// just like GET /bookmark/:id/singlefile.
+func (h *handler) serveSharedSingleFile(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	book, _ := h.sharedBookmarkByToken(ps)
+	h.serveSingleFile(w, r, book.ID)
+}
+
+// serveSharedArchive is handler for GET /share/:token/archive/*filepath
+//
+// It serves the archive of bookmark that shared by the token, just like
+// GET /bookmark/:id/archive/*filepath.
+func (h *handler) serveSharedArchive(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	book, basePath := h.sharedBookmarkByToken(ps)
+	resourcePath := strings.TrimPrefix(ps.ByName("filepath"), "/")
+
+	strID := strconv.Itoa(book.ID)
+	archiveInfo, err := os.Stat(fp.Join(h.DataDir, "archive", strID))
+	if err != nil {
+		panic(newClientError(http.StatusNotFound, fmt.Errorf("bookmark has no archive")))
+	}
+
+	if resourcePath != "" && checkETag(w, r, `W/"`+fileVersion(archiveInfo)+`"`) {
+		return
+	}
+
+	archive, err := h.getArchive(strID)
+	checkError(err)
+
+	w.Header().Set("Referrer-Policy", "no-referrer")
+	h.serveArchiveResource(w, book, archive, resourcePath, basePath)
+}
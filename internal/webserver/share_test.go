@@ -0,0 +1,100 @@
+package webserver
+
+import (
+	"encoding/json"
+	"html/template"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"shiori/internal/model"
+	"github.com/julienschmidt/httprouter"
+)
+
+func Test_shareLinks(t *testing.T) {
+	hdl, cleanup := newTestHandler(t)
+	defer cleanup()
+
+	// Keep the template below, instead of reloading the view from disk
+	defer func(dev bool) { developmentMode = dev }(developmentMode)
+	developmentMode = false
+
+	hdl.RootPath = "/"
+	hdl.templates = map[string]*template.Template{
+		"content": template.Must(template.New("content").Delims("$$", "$$").Parse(
+			`<a href="$$.BasePath$$/archive">View Archive</a>$$.Book.HTML$$`)),
+	}
+
+	_, err := hdl.DB.SaveBookmarks(model.Bookmark{ID: 1, URL: "https://example.com",
+		Title: "Private page", Content: "secret", HTML: "<p>Shared content</p>"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	withID := func(handle httprouter.Handle, params ...httprouter.Param) httprouter.Handle {
+		return func(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
+			handle(w, r, append(httprouter.Params{{Key: "id", Value: "1"}}, params...))
+		}
+	}
+
+	router := httprouter.New()
+	router.POST("/api/bookmark/1/shares", withID(hdl.apiInsertShareLink))
+	router.GET("/api/bookmark/1/shares", withID(hdl.apiGetShareLinks))
+	router.PanicHandler = hdl.handlePanic
+
+	serve := func(method, target string) *httptest.ResponseRecorder {
+		rec := httptest.NewRecorder()
+		router.ServeHTTP(rec, newOwnerRequest(method, target, nil))
+		return rec
+	}
+
+	// Create the link
+	rec := serve("POST", "/api/bookmark/1/shares")
+	if rec.Code != http.StatusCreated {
+		t.Fatalf("apiInsertShareLink() status = %d, want 201: %s", rec.Code, rec.Body)
+	}
+
+	link := model.ShareLink{}
+	if err := json.NewDecoder(rec.Body).Decode(&link); err != nil {
+		t.Fatal(err)
+	}
+
+	if link.Token == "" || link.URL != "/share/"+link.Token+"/content" {
+		t.Fatalf("apiInsertShareLink() = %+v, want token and its URL", link)
+	}
+
+	router.GET(link.URL, func(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
+		hdl.serveSharedContent(w, r, httprouter.Params{{Key: "token", Value: link.Token}})
+	})
+	router.GET("/share/wrong/content", func(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
+		hdl.serveSharedContent(w, r, httprouter.Params{{Key: "token", Value: "wrong"}})
+	})
+	router.DELETE("/api/bookmark/1/shares/1", withID(hdl.apiDeleteShareLink,
+		httprouter.Param{Key: "shareId", Value: "1"}))
+
+	// The link is listed without its token
+	rec = serve("GET", "/api/bookmark/1/shares")
+	if body := rec.Body.String(); !strings.Contains(body, `"bookmarkId":1`) || strings.Contains(body, link.Token) {
+		t.Errorf("apiGetShareLinks() = %s, want the link without token", body)
+	}
+
+	// Anyone with the link can view the content, but not with wrong token
+	rec = serve("GET", link.URL)
+	if rec.Code != http.StatusOK || !strings.Contains(rec.Body.String(), "Shared content") {
+		t.Errorf("serveSharedContent() status = %d, want 200 with content", rec.Code)
+	}
+
+	if rec = serve("GET", "/share/wrong/content"); rec.Code != http.StatusNotFound {
+		t.Errorf("serveSharedContent() with wrong token status = %d, want 404", rec.Code)
+	}
+
+	// Once revoked, the link no longer works
+	if rec = serve("DELETE", "/api/bookmark/1/shares/1"); rec.Code != http.StatusOK {
+		t.Fatalf("apiDeleteShareLink() status = %d, want 200: %s", rec.Code, rec.Body)
+	}
+
+	if rec = serve("GET", link.URL); rec.Code != http.StatusNotFound {
+		t.Errorf("serveSharedContent() of revoked link status = %d, want 404", rec.Code)
+	}
+}
@@ -0,0 +1,198 @@
+package webserver
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"shiori/internal/core"
+	"shiori/internal/database"
+	"shiori/internal/model"
+	"github.com/julienschmidt/httprouter"
+)
+
+// normalizeTagPath formats the path of hierarchical tag, e.g. "Dev / Go",
+// the same way as tag name, while the empty segments are removed.
+func normalizeTagPath(path string) string {
+	segments := []string{}
+	for _, segment := range strings.Split(path, "/") {
+		if segment = normalizeTagName(segment); segment != "" {
+			segments = append(segments, segment)
+		}
+	}
+
+	return strings.Join(segments, "/")
+}
+
+// tagSubtreeRenames returns the new names of the tag and every tag nested in
+// it, when the tag is renamed to newPath, e.g. "dev/go/web" is renamed to
+// "lang/go/web" when "dev/go" is renamed to "lang/go".
+func tagSubtreeRenames(tags []model.Tag, tag model.Tag, newPath string) map[int]string {
+	renames := map[int]string{}
+	for _, t := range tags {
+		if t.Name == tag.Name || strings.HasPrefix(t.Name, tag.Name+"/") {
+			renames[t.ID] = newPath + strings.TrimPrefix(t.Name, tag.Name)
+		}
+	}
+
+	return renames
+}
+
+// tagParam returns the tag identified by `id` in URL, and all the tags.
+func (h *handler) tagParam(ps httprouter.Params) (model.Tag, []model.Tag) {
+	id, err := strconv.Atoi(ps.ByName("id"))
+	if err != nil {
+		panic(newClientError(http.StatusBadRequest, fmt.Errorf("tag id must be a number")))
+	}
+
+	return h.tagByID(id)
+}
+
+// tagByID returns the tag with the ID, and all the tags.
+func (h *handler) tagByID(id int) (model.Tag, []model.Tag) {
+	tags, err := h.DB.GetTags(database.GetTagsOptions{WithUnused: true})
+	checkError(err)
+
+	for _, tag := range tags {
+		if tag.ID == id {
+			return tag, tags
+		}
+	}
+
+	panic(newClientError(http.StatusNotFound, fmt.Errorf("tag not found")))
+}
+
+// renameTagSubtree renames the tag to newPath together with the tags nested
+// in it, then writes the resulting tags. Tags that end up with the same name
+// as existing tags are merged into them.
+func (h *handler) renameTagSubtree(w http.ResponseWriter, tag model.Tag, tags []model.Tag, newPath string) {
+	if newPath == "" {
+		panic(newClientError(http.StatusBadRequest, fmt.Errorf("name must not empty")))
+	}
+
+	if strings.HasPrefix(newPath, tag.Name+"/") {
+		panic(newClientError(http.StatusBadRequest, fmt.Errorf("tag can't be moved into itself")))
+	}
+
+	if newPath != tag.Name {
+		err := h.DB.RenameTags(tagSubtreeRenames(tags, tag, newPath))
+		checkError(err)
+	}
+
+	// Return the tags in the subtree with their new name
+	tags, err := h.DB.GetTags(database.GetTagsOptions{WithUnused: true})
+	checkError(err)
+
+	resultTags := []model.Tag{}
+	for _, t := range tags {
+		if t.Name == newPath || strings.HasPrefix(t.Name, newPath+"/") {
+			resultTags = append(resultTags, t)
+		}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	err = json.NewEncoder(w).Encode(&resultTags)
+	checkError(err)
+}
+
+// apiMoveTag is handler for PUT /api/tag/:id/move
+//
+// It moves the tag, together with the tags nested in it, into tag `parent`,
+// e.g. "dev/go" becomes "lang/go" when moved into "lang". Empty parent moves
+// it to the top level. It returns the tags in their new place.
+func (h *handler) apiMoveTag(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	tag, tags := h.tagParam(ps)
+
+	request := struct {
+		Parent string `json:"parent"`
+	}{}
+
+	err := h.decodeJSON(r.Body, &request)
+	checkError(err)
+
+	newPath := tag.Name[strings.LastIndex(tag.Name, "/")+1:]
+	if parent := normalizeTagPath(request.Parent); parent != "" {
+		newPath = parent + "/" + newPath
+	}
+
+	h.renameTagSubtree(w, tag, tags, newPath)
+}
+
+// apiRenameTagSubtree is handler for PUT /api/tag/:id/rename
+//
+// It changes the last segment of the tag name to `name`, which is applied to
+// the tags nested in it as well, e.g. "dev/go/web" becomes "dev/golang/web"
+// when "dev/go" is renamed to "golang". It returns the renamed tags.
+func (h *handler) apiRenameTagSubtree(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	tag, tags := h.tagParam(ps)
+
+	request := struct {
+		Name string `json:"name"`
+	}{}
+
+	err := h.decodeJSON(r.Body, &request)
+	checkError(err)
+
+	if strings.Contains(request.Name, "/") {
+		panic(newClientError(http.StatusBadRequest, fmt.Errorf("name must not contain slash, move the tag instead")))
+	}
+
+	name := normalizeTagName(request.Name)
+	if name == "" {
+		panic(newClientError(http.StatusBadRequest, fmt.Errorf("name must not empty")))
+	}
+
+	newPath := name
+	if idx := strings.LastIndex(tag.Name, "/"); idx >= 0 {
+		newPath = tag.Name[:idx+1] + name
+	}
+
+	h.renameTagSubtree(w, tag, tags, newPath)
+}
+
+// apiDeleteTag is handler for DELETE /api/tag/:id
+//
+// It removes the tag from every bookmark, then deletes it. The tags nested
+// in it are kept at the top level. When `deleteOrphans=true` is specified,
+// the bookmarks left without any tag are deleted as well. Only owner may
+// delete tag, since it's shared by the bookmarks of every account.
+func (h *handler) apiDeleteTag(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	tag, _ := h.tagParam(ps)
+	deleteOrphans, _ := strconv.ParseBool(r.URL.Query().Get("deleteOrphans"))
+
+	orphanIDs, err := h.DB.DeleteTag(tag.ID)
+	checkError(err)
+
+	nDeleted := 0
+	if deleteOrphans && len(orphanIDs) > 0 {
+		// Only the bookmarks accessible by the account are deleted
+		orphans, err := h.DB.GetBookmarks(database.GetBookmarksOptions{
+			IDs:     orphanIDs,
+			OwnerID: bookmarkOwner(r),
+		})
+		checkError(err)
+
+		// Delete them one by one, since no ID means every bookmark
+		for _, book := range orphans {
+			n, err := h.DB.DeleteBookmarks(book.ID)
+			checkError(err)
+			h.Webhooks.send(eventBookmarkDeleted, book)
+			nDeleted += n
+
+			// Delete thumbnail image and archives from local disk
+			core.RemoveBookmarkArtifacts(h.DataDir, book.ID)
+		}
+	}
+
+	// Return the bookmarks left without tag, and how many of them deleted
+	resp := map[string]interface{}{
+		"orphans": orphanIDs,
+		"deleted": nDeleted,
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	err = json.NewEncoder(w).Encode(&resp)
+	checkError(err)
+}
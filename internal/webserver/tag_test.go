@@ -0,0 +1,240 @@
+package webserver
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+
+	"shiori/internal/database"
+	"shiori/internal/model"
+	"github.com/julienschmidt/httprouter"
+)
+
+func Test_normalizeTagPath(t *testing.T) {
+	tests := []struct {
+		path string
+		want string
+	}{
+		{"Dev / Go  Lang", "dev/go lang"},
+		{"/dev//go/", "dev/go"},
+		{" / ", ""},
+	}
+
+	for _, tt := range tests {
+		if got := normalizeTagPath(tt.path); got != tt.want {
+			t.Errorf("normalizeTagPath(%q) = %q, want %q", tt.path, got, tt.want)
+		}
+	}
+}
+
+func Test_tagSubtree(t *testing.T) {
+	hdl, cleanup := newTestHandler(t)
+	defer cleanup()
+
+	_, err := hdl.DB.SaveBookmarks(
+		model.Bookmark{ID: 1, URL: "https://example.com/1", Title: "One", Tags: []model.Tag{{Name: "dev/go/web"}}},
+		model.Bookmark{ID: 2, URL: "https://example.com/2", Title: "Two", Tags: []model.Tag{{Name: "dev/go"}}},
+		model.Bookmark{ID: 3, URL: "https://example.com/3", Title: "Three", Tags: []model.Tag{{Name: "dev/gopher"}}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tagID := func(name string) string {
+		tags, err := hdl.DB.GetTags(database.GetTagsOptions{WithUnused: true})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		for _, tag := range tags {
+			if tag.Name == name {
+				return fmt.Sprint(tag.ID)
+			}
+		}
+
+		t.Fatalf("tag %q not found", name)
+		return ""
+	}
+
+	tagNames := func() string {
+		tags, err := hdl.DB.GetTags(database.GetTagsOptions{})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		names := []string{}
+		for _, tag := range tags {
+			names = append(names, tag.Name)
+		}
+
+		return strings.Join(names, ",")
+	}
+
+	serve := func(handle httprouter.Handle, id, body string) *httptest.ResponseRecorder {
+		router := httprouter.New()
+		router.PUT("/api/tag/"+id, func(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
+			handle(w, r, httprouter.Params{{Key: "id", Value: id}})
+		})
+		router.PanicHandler = hdl.handlePanic
+
+		rec := httptest.NewRecorder()
+		router.ServeHTTP(rec, newOwnerRequest("PUT", "/api/tag/"+id, strings.NewReader(body)))
+		return rec
+	}
+
+	tests := []struct {
+		name       string
+		handle     httprouter.Handle
+		tag        string
+		body       string
+		wantStatus int
+		wantTags   string
+	}{
+		{"move into itself", hdl.apiMoveTag, "dev/go", `{"parent": "dev/go/web"}`, http.StatusBadRequest, "dev/go,dev/go/web,dev/gopher"},
+		{"rename with slash", hdl.apiRenameTagSubtree, "dev/go", `{"name": "lang/go"}`, http.StatusBadRequest, "dev/go,dev/go/web,dev/gopher"},
+		{"rename subtree", hdl.apiRenameTagSubtree, "dev/go", `{"name": "Golang"}`, http.StatusOK, "dev/golang,dev/golang/web,dev/gopher"},
+		{"move subtree", hdl.apiMoveTag, "dev/golang", `{"parent": "Lang "}`, http.StatusOK, "dev/gopher,lang/golang,lang/golang/web"},
+		{"move to top level", hdl.apiMoveTag, "lang/golang/web", `{"parent": ""}`, http.StatusOK, "dev/gopher,lang/golang,web"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rec := serve(tt.handle, tagID(tt.tag), tt.body)
+			if rec.Code != tt.wantStatus {
+				t.Errorf("status = %d, want %d: %s", rec.Code, tt.wantStatus, rec.Body)
+			}
+
+			if got := tagNames(); got != tt.wantTags {
+				t.Errorf("tags = %s, want %s", got, tt.wantTags)
+			}
+		})
+	}
+
+	if rec := serve(hdl.apiMoveTag, "99", `{"parent": "dev"}`); rec.Code != http.StatusNotFound {
+		t.Errorf("apiMoveTag() of unknown tag status = %d, want 404", rec.Code)
+	}
+}
+
+func Test_apiDeleteTag(t *testing.T) {
+	hdl, cleanup := newTestHandler(t)
+	defer cleanup()
+
+	_, err := hdl.DB.SaveBookmarks(
+		model.Bookmark{ID: 1, URL: "https://example.com/1", Title: "One", Tags: []model.Tag{{Name: "old"}}},
+		model.Bookmark{ID: 2, URL: "https://example.com/2", Title: "Two", Tags: []model.Tag{{Name: "old"}, {Name: "go"}}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tags, err := hdl.DB.GetTags(database.GetTagsOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	oldID := ""
+	for _, tag := range tags {
+		if tag.Name == "old" {
+			oldID = fmt.Sprint(tag.ID)
+		}
+	}
+
+	deleteTag := func(id string) *httptest.ResponseRecorder {
+		router := httprouter.New()
+		router.DELETE("/api/tag/"+id, func(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
+			hdl.apiDeleteTag(w, r, httprouter.Params{{Key: "id", Value: id}})
+		})
+		router.PanicHandler = hdl.handlePanic
+
+		rec := httptest.NewRecorder()
+		router.ServeHTTP(rec, newOwnerRequest("DELETE", "/api/tag/"+id+"?deleteOrphans=true", nil))
+		return rec
+	}
+
+	rec := deleteTag(oldID)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("apiDeleteTag() status = %d, want 200: %s", rec.Code, rec.Body)
+	}
+
+	if body := strings.TrimSpace(rec.Body.String()); body != `{"deleted":1,"orphans":[1]}` {
+		t.Errorf("apiDeleteTag() = %s, want orphan 1 deleted", body)
+	}
+
+	// The orphan is deleted, while the other bookmark only loses the tag
+	if _, exist := hdl.DB.GetBookmark(1, ""); exist {
+		t.Errorf("orphaned bookmark 1 still exists")
+	}
+
+	bookmarks, err := hdl.DB.GetBookmarks(database.GetBookmarksOptions{IDs: []int{2}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(bookmarks) != 1 || len(bookmarks[0].Tags) != 1 || bookmarks[0].Tags[0].Name != "go" {
+		t.Errorf("bookmark 2 = %+v, want only tag go", bookmarks)
+	}
+
+	if rec = deleteTag(oldID); rec.Code != http.StatusNotFound {
+		t.Errorf("apiDeleteTag() of deleted tag status = %d, want 404", rec.Code)
+	}
+}
+
+func Test_apiUpdateTag(t *testing.T) {
+	hdl, cleanup := newTestHandler(t)
+	defer cleanup()
+
+	_, err := hdl.DB.SaveBookmarks(model.Bookmark{ID: 1, URL: "https://example.com/1",
+		Title: "One", Tags: []model.Tag{{Name: "go"}}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tags, err := hdl.DB.GetTags(database.GetTagsOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	id := tags[0].ID
+
+	tests := []struct {
+		name       string
+		body       string
+		wantStatus int
+		want       model.Tag
+	}{
+		{"set metadata", `{"color": "#FF8800", "description": " Go stuff ", "icon": "🐹"}`, http.StatusOK,
+			model.Tag{Name: "go", Color: "#ff8800", Description: "Go stuff", Icon: "🐹"}},
+		{"rename keeps metadata", `{"name": "golang"}`, http.StatusOK,
+			model.Tag{Name: "golang", Color: "#ff8800", Description: "Go stuff", Icon: "🐹"}},
+		{"invalid color", `{"color": "orange"}`, http.StatusBadRequest,
+			model.Tag{Name: "golang", Color: "#ff8800", Description: "Go stuff", Icon: "🐹"}},
+		{"remove color", `{"color": ""}`, http.StatusOK,
+			model.Tag{Name: "golang", Description: "Go stuff", Icon: "🐹"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			body := fmt.Sprintf(`{"id": %d, %s`, id, strings.TrimPrefix(tt.body, "{"))
+			rec := httptest.NewRecorder()
+			router := httprouter.New()
+			router.PUT("/api/tag", hdl.apiUpdateTag)
+			router.PanicHandler = hdl.handlePanic
+			router.ServeHTTP(rec, newOwnerRequest("PUT", "/api/tag", strings.NewReader(body)))
+
+			if rec.Code != tt.wantStatus {
+				t.Errorf("status = %d, want %d: %s", rec.Code, tt.wantStatus, rec.Body)
+			}
+
+			tags, err := hdl.DB.GetTags(database.GetTagsOptions{})
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			got := model.Tag{Name: tags[0].Name, Color: tags[0].Color,
+				Description: tags[0].Description, Icon: tags[0].Icon}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("tag = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,115 @@
+package webserver
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"shiori/internal/model"
+)
+
+// accountContextKey is the key of account that authenticated
+// the request, which stored in the request's context.
+type accountContextKey struct{}
+
+// newAPIToken creates random API token.
+func newAPIToken() (string, error) {
+	buf := make([]byte, 32)
+	if _, err := rand.Read(buf); err != nil {
+		return "", err
+	}
+
+	return hex.EncodeToString(buf), nil
+}
+
+// hashAPIToken returns the hash of API token, which is stored in database
+// instead of the token itself. The token is random, so it doesn't need
+// a slow hash like bcrypt that's used for password.
+func hashAPIToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+// bearerToken returns the token in `Authorization: Bearer <token>` header.
+// Returns false if the request doesn't use bearer authentication.
+func bearerToken(r *http.Request) (string, bool) {
+	auth := r.Header.Get("Authorization")
+	if len(auth) < 7 || !strings.EqualFold(auth[:7], "Bearer ") {
+		return "", false
+	}
+
+	return strings.TrimSpace(auth[7:]), true
+}
+
+// withAccount returns copy of the request that authenticated as account.
+func withAccount(r *http.Request, account model.Account) *http.Request {
+	ctx := context.WithValue(r.Context(), accountContextKey{}, account)
+	return r.WithContext(ctx)
+}
+
+// requestAccount returns the account that authenticated the request.
+// Returns false if the request isn't authenticated.
+func requestAccount(r *http.Request) (model.Account, bool) {
+	account, ok := r.Context().Value(accountContextKey{}).(model.Account)
+	return account, ok
+}
+
+// anonymousOwner is the owner ID of bookmarks that request which isn't
+// authenticated may access by their ID. No account has this ID, so such
+// request never gets bookmarks of any account by accident.
+const anonymousOwner = -1
+
+// bookmarkOwner returns the owner ID of bookmarks that the request may
+// access by their ID. Zero means any, which is only the case for owner role.
+func bookmarkOwner(r *http.Request) int {
+	account, ok := requestAccount(r)
+	switch {
+	case !ok:
+		return anonymousOwner
+	case account.HasRole(model.RoleOwner):
+		return 0
+	default:
+		return account.ID
+	}
+}
+
+// canAccessBookmark checks if the request may access the bookmark. Request
+// that isn't authenticated may only access public bookmarks.
+func canAccessBookmark(r *http.Request, book model.Bookmark) bool {
+	ownerID := bookmarkOwner(r)
+	if ownerID == anonymousOwner {
+		return book.Public == 1
+	}
+
+	return ownerID == 0 || book.OwnerID == ownerID
+}
+
+// bookmarkListOwner returns the owner ID of bookmarks that listed for the
+// request. Authenticated account only sees its own bookmarks, unless it's
+// an owner that asks for every account's bookmarks with `all=true`.
+func bookmarkListOwner(r *http.Request) (int, error) {
+	all, _ := strconv.ParseBool(r.URL.Query().Get("all"))
+	account, ok := requestAccount(r)
+	switch {
+	case !ok:
+		return anonymousOwner, nil
+	case all && !account.HasRole(model.RoleOwner):
+		return 0, fmt.Errorf("%s role is required to list bookmarks of all accounts", model.RoleOwner)
+	case all:
+		return 0, nil
+	default:
+		return account.ID, nil
+	}
+}
+
+// newBookmarkOwner returns the owner ID of bookmark that created by the
+// request, which is zero if the request isn't authenticated.
+func newBookmarkOwner(r *http.Request) int {
+	account, _ := requestAccount(r)
+	return account.ID
+}
@@ -1,6 +1,7 @@
 package webserver
 
 import (
+	"encoding/json"
 	"fmt"
 	"html/template"
 	"io"
@@ -11,9 +12,37 @@ import (
 	nurl "net/url"
 	"os"
 	fp "path/filepath"
+	"reflect"
 	"regexp"
+	"sort"
+	"strconv"
 	"strings"
 	"syscall"
+	"time"
+	"unicode/utf8"
+
+	"shiori/internal/database"
+	"shiori/internal/model"
+)
+
+const errBodyTooLarge = "http: request body too large"
+
+// maxReorderedQueries is the max number of queries in resource URL,
+// which every order of them is looked up in archive.
+const maxReorderedQueries = 4
+
+// defaultPageSize is the number of bookmarks in each page
+// of bookmark list, unless client specifies its own.
+const defaultPageSize = 30
+
+// exportPageSize is the number of bookmarks fetched at once while exporting.
+const exportPageSize = 100
+
+// Outcomes of refreshing bookmark's content in apiUpdateCache.
+const (
+	refreshRefreshed = "refreshed"
+	refreshFailed    = "failed"
+	refreshUnchanged = "unchanged"
 )
 
 var (
@@ -72,6 +101,41 @@ func serveFile(w http.ResponseWriter, filePath string, cache bool) error {
 	return err
 }
 
+// fileVersion returns version of a file from its modified time and size,
+// which is used in its ETag.
+func fileVersion(info os.FileInfo) string {
+	return fmt.Sprintf("%x-%x", info.ModTime().Unix(), info.Size())
+}
+
+// setSandbox serves the response in sandbox, where it can't run scripts,
+// submit forms or access cookies of our origin. It's for archived content,
+// which is page from other site that served under our origin.
+func setSandbox(w http.ResponseWriter) {
+	w.Header().Set("Content-Security-Policy", "sandbox")
+}
+
+// checkETag sets ETag and Cache-Control for content that stays the same as
+// long as its ETag does, e.g. thumbnail and archived resource. The content
+// might be private and might be changed or deleted, so it's only kept by the
+// browser and revalidated on every use. If client already has the content,
+// 304 is written and true is returned, in which case the content shouldn't
+// be served.
+func checkETag(w http.ResponseWriter, r *http.Request, etag string) bool {
+	w.Header().Set("ETag", etag)
+	w.Header().Set("Cache-Control", "private, no-cache")
+
+	weakETag := strings.TrimPrefix(etag, "W/")
+	for _, match := range strings.Split(r.Header.Get("If-None-Match"), ",") {
+		match = strings.TrimSpace(match)
+		if match == "*" || strings.TrimPrefix(match, "W/") == weakETag {
+			w.WriteHeader(http.StatusNotModified)
+			return true
+		}
+	}
+
+	return false
+}
+
 func createRedirectURL(newPath, previousPath string) string {
 	urlQueries := nurl.Values{}
 	urlQueries.Set("dst", previousPath)
@@ -150,6 +214,614 @@ func getArchivalName(src string) string {
 	return archivalURL
 }
 
+// resourceChecker is used to check if a resource exists in archive.
+type resourceChecker interface {
+	HasResource(name string) bool
+}
+
+// findArchivalName returns the archival name of resource with the specified
+// original URL. Minor differences between the requested URL and the archived
+// one, i.e. fragment, trailing slash and order of queries, are tolerated.
+// Returns empty string if the resource doesn't exist in archive.
+func findArchivalName(archive resourceChecker, src string) string {
+	for _, name := range archivalNameCandidates(src) {
+		if archive.HasResource(name) {
+			return name
+		}
+	}
+
+	return ""
+}
+
+// archivalNameCandidates returns the possible archival names of an URL,
+// starting from the exact one. Every order of queries is tried as long as
+// there are at most maxReorderedQueries of them.
+func archivalNameCandidates(src string) []string {
+	urls := []string{src}
+
+	if tmp, err := nurl.Parse(src); err == nil {
+		// Without fragment
+		tmp.Fragment = ""
+		urls = append(urls, tmp.String())
+
+		// With queries in different order
+		variants := []nurl.URL{*tmp}
+		queries := strings.Split(tmp.RawQuery, "&")
+		if len(queries) > 1 && len(queries) <= maxReorderedQueries {
+			for _, permutation := range permutations(queries)[1:] {
+				variant := *tmp
+				variant.RawQuery = strings.Join(permutation, "&")
+				variants = append(variants, variant)
+			}
+		}
+
+		// With or without trailing slash
+		for _, variant := range variants {
+			urls = append(urls, variant.String())
+
+			if strings.HasSuffix(variant.Path, "/") {
+				variant.Path = strings.TrimSuffix(variant.Path, "/")
+			} else {
+				variant.Path += "/"
+			}
+
+			variant.RawPath = ""
+			urls = append(urls, variant.String())
+		}
+	}
+
+	names := []string{}
+	seen := make(map[string]struct{})
+	for _, url := range urls {
+		name := getArchivalName(url)
+		if _, exist := seen[name]; name == "" || exist {
+			continue
+		}
+
+		seen[name] = struct{}{}
+		names = append(names, name)
+	}
+
+	return names
+}
+
+// permutations returns every order of items, starting from the original one.
+func permutations(items []string) [][]string {
+	if len(items) <= 1 {
+		return [][]string{items}
+	}
+
+	result := [][]string{}
+	for i := range items {
+		rest := make([]string, 0, len(items)-1)
+		rest = append(rest, items[:i]...)
+		rest = append(rest, items[i+1:]...)
+
+		for _, permutation := range permutations(rest) {
+			result = append(result, append([]string{items[i]}, permutation...))
+		}
+	}
+
+	return result
+}
+
+// parseTimeParam parses time from URL query, which is either formatted
+// as RFC3339, a date in UTC (e.g. 2023-03-01) or a Unix epoch in seconds.
+func parseTimeParam(s string) (time.Time, error) {
+	if epoch, err := strconv.ParseInt(s, 10, 64); err == nil {
+		return time.Unix(epoch, 0), nil
+	}
+
+	if t, err := time.Parse("2006-01-02", s); err == nil {
+		return t, nil
+	}
+
+	t, err := time.Parse(time.RFC3339, s)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("%q is neither RFC3339, date nor Unix epoch", s)
+	}
+
+	return t, nil
+}
+
+// parseListParam splits comma separated URL query into its items. Empty and
+// duplicate items are removed, e.g. from trailing comma.
+func parseListParam(s string) []string {
+	items := []string{}
+	seen := map[string]struct{}{}
+	for _, item := range strings.Split(s, ",") {
+		item = strings.TrimSpace(item)
+		if _, exist := seen[item]; exist || item == "" {
+			continue
+		}
+
+		seen[item] = struct{}{}
+		items = append(items, item)
+	}
+
+	return items
+}
+
+// normalizeTagName formats tag name the same way it's saved in database,
+// i.e. lower case with single space between words.
+func normalizeTagName(name string) string {
+	return strings.Join(strings.Fields(strings.ToLower(name)), " ")
+}
+
+// parseBookmarksFilter creates options for fetching bookmarks from the
+// filter in URL queries. Limit and offset are left for caller to set.
+func parseBookmarksFilter(r *http.Request) (database.GetBookmarksOptions, error) {
+	// Get URL queries
+	keyword := r.URL.Query().Get("keyword")
+	strTags := r.URL.Query().Get("tags")
+	strExcludedTags := r.URL.Query().Get("exclude")
+	strExcludedURLs := r.URL.Query().Get("excludeUrl")
+	strUpdatedSince := r.URL.Query().Get("updatedSince")
+	strRecentDays := r.URL.Query().Get("recentDays")
+	contentType := r.URL.Query().Get("contentType")
+	order := r.URL.Query().Get("order")
+	sortName := r.URL.Query().Get("sort")
+	metadataFilters := r.URL.Query()["metadata"]
+	strSearchFields := r.URL.Query().Get("searchFields")
+	useRegex, _ := strconv.ParseBool(r.URL.Query().Get("regex"))
+	untagged, _ := strconv.ParseBool(r.URL.Query().Get("untagged"))
+	starred, _ := strconv.ParseBool(r.URL.Query().Get("starred"))
+	broken, _ := strconv.ParseBool(r.URL.Query().Get("broken"))
+
+	tags := parseListParam(strTags)
+	excludedTags := parseListParam(strExcludedTags)
+	excludedURLs := parseListParam(strExcludedURLs)
+
+	updatedSince := ""
+	if strUpdatedSince != "" {
+		since, err := parseTimeParam(strUpdatedSince)
+		if err != nil {
+			return database.GetBookmarksOptions{}, fmt.Errorf("invalid updatedSince: %v", err)
+		}
+
+		updatedSince = since.UTC().Format("2006-01-02 15:04:05")
+	}
+
+	createdSince := ""
+	if strRecentDays != "" {
+		recentDays, err := strconv.Atoi(strRecentDays)
+		if err != nil || recentDays < 1 {
+			return database.GetBookmarksOptions{}, fmt.Errorf("recentDays must be a positive integer")
+		}
+
+		since := time.Now().UTC().AddDate(0, 0, -recentDays)
+		createdSince = since.Format("2006-01-02 15:04:05")
+	}
+
+	options := database.GetBookmarksOptions{
+		Tags:         tags,
+		ExcludedTags: excludedTags,
+		ExcludedURLs: excludedURLs,
+		UpdatedSince: updatedSince,
+		CreatedSince: createdSince,
+		ContentType:  contentType,
+		Untagged:     untagged,
+		Starred:      starred,
+		Broken:       broken,
+		OrderMethod:  database.ByLastAdded,
+	}
+
+	// The lower bound of date range is inclusive while the upper one is
+	// exclusive, e.g. `created_after=2023-03-01&created_before=2023-04-01`
+	dateRanges := []struct {
+		param    string
+		conflict string
+		dst      *string
+	}{
+		{"created_after", "recentDays", &options.CreatedSince},
+		{"created_before", "", &options.CreatedBefore},
+		{"modified_after", "updatedSince", &options.UpdatedSince},
+		{"modified_before", "", &options.UpdatedBefore},
+	}
+
+	for _, dateRange := range dateRanges {
+		strTime := r.URL.Query().Get(dateRange.param)
+		if strTime == "" {
+			continue
+		}
+
+		if *dateRange.dst != "" {
+			return database.GetBookmarksOptions{}, fmt.Errorf("%s can't be used together with %s",
+				dateRange.param, dateRange.conflict)
+		}
+
+		t, err := parseTimeParam(strTime)
+		if err != nil {
+			return database.GetBookmarksOptions{}, fmt.Errorf("invalid %s: %v", dateRange.param, err)
+		}
+
+		*dateRange.dst = t.UTC().Format("2006-01-02 15:04:05")
+	}
+
+	if strCollection := r.URL.Query().Get("collection"); strCollection != "" {
+		collectionID, err := strconv.Atoi(strCollection)
+		if err != nil || collectionID < 1 {
+			return database.GetBookmarksOptions{}, fmt.Errorf("collection must be a positive integer")
+		}
+
+		options.CollectionIDs = []int{collectionID}
+	}
+
+	if strRead := r.URL.Query().Get("read"); strRead != "" {
+		read, err := strconv.ParseBool(strRead)
+		if err != nil {
+			return database.GetBookmarksOptions{}, fmt.Errorf("read must be true or false")
+		}
+
+		options.Read = &read
+	}
+
+	for _, filter := range metadataFilters {
+		parts := strings.SplitN(filter, ":", 2)
+		if !rxMetadataKey.MatchString(parts[0]) {
+			return database.GetBookmarksOptions{}, fmt.Errorf("invalid metadata key %q", parts[0])
+		}
+
+		if options.Metadata == nil {
+			options.Metadata = map[string]string{}
+		}
+
+		options.Metadata[parts[0]] = ""
+		if len(parts) == 2 {
+			options.Metadata[parts[0]] = parts[1]
+		}
+	}
+
+	for _, field := range parseListParam(strings.ToLower(strSearchFields)) {
+		if !isSearchField(field) {
+			return database.GetBookmarksOptions{}, fmt.Errorf("searchFields must be some of %s",
+				strings.Join(database.AllSearchFields, ", "))
+		}
+
+		options.SearchFields = append(options.SearchFields, field)
+	}
+
+	switch order {
+	case "":
+	case "manual":
+		options.OrderMethod = database.ByManualOrder
+	default:
+		return database.GetBookmarksOptions{}, fmt.Errorf("order must be empty or manual")
+	}
+
+	if sortName != "" {
+		orderMethod, exist := bookmarkSorts[sortName]
+		if !exist {
+			return database.GetBookmarksOptions{}, fmt.Errorf("sort must be one of %s",
+				strings.Join(bookmarkSortNames(), ", "))
+		}
+
+		if order != "" {
+			return database.GetBookmarksOptions{}, fmt.Errorf("sort can't be used together with order")
+		}
+
+		options.OrderMethod = orderMethod
+	}
+
+	// Keyword might contain terms for specific field, e.g. `title:golang`,
+	// unless it's a regular expression
+	if useRegex {
+		if _, err := regexp.Compile(keyword); err != nil {
+			return database.GetBookmarksOptions{}, fmt.Errorf("invalid regex: %v", err)
+		}
+
+		options.Regex = keyword
+	} else {
+		database.ParseKeyword(keyword, &options)
+	}
+
+	if updatedSince != "" {
+		options.OrderMethod = database.ByFirstModified
+	}
+
+	return options, nil
+}
+
+// bookmarkSorts is the order of bookmarks that client may request
+// in `sort` query, keyed by its name.
+var bookmarkSorts = map[string]database.OrderMethod{
+	"newest":        database.ByLastAdded,
+	"oldest":        database.ByFirstAdded,
+	"last-modified": database.ByLastModified,
+	"title":         database.ByTitle,
+	"domain":        database.ByDomain,
+}
+
+// bookmarkSortNames returns the names in bookmarkSorts, alphabetically.
+func bookmarkSortNames() []string {
+	names := []string{}
+	for name := range bookmarkSorts {
+		names = append(names, name)
+	}
+
+	sort.Strings(names)
+	return names
+}
+
+// isSearchField checks if keyword might be searched in the field.
+func isSearchField(field string) bool {
+	for _, searchField := range database.AllSearchFields {
+		if field == searchField {
+			return true
+		}
+	}
+
+	return false
+}
+
+// maxMetadataSize is max size of bookmark's metadata, encoded as JSON.
+const maxMetadataSize = 4 << 10
+
+// rxMetadataKey is the pattern of valid metadata key.
+var rxMetadataKey = regexp.MustCompile(`^[a-zA-Z0-9_.-]{1,64}$`)
+
+// validateMetadata checks if every key of metadata is valid, every value is
+// not empty, and the whole metadata doesn't exceed maxMetadataSize.
+func validateMetadata(metadata model.Metadata) error {
+	for key, value := range metadata {
+		if !rxMetadataKey.MatchString(key) {
+			return fmt.Errorf("metadata key %q must be 1-64 letters, digits, '_', '-' or '.'", key)
+		}
+
+		if value == "" {
+			return fmt.Errorf("value of metadata %q must not be empty", key)
+		}
+	}
+
+	jsonMetadata, err := json.Marshal(metadata)
+	if err != nil {
+		return err
+	}
+
+	if len(jsonMetadata) > maxMetadataSize {
+		return fmt.Errorf("metadata must not exceed %d bytes", maxMetadataSize)
+	}
+
+	return nil
+}
+
+// rxTagColor is the pattern of valid tag color, which is hex color.
+var rxTagColor = regexp.MustCompile(`^#([0-9a-f]{3}|[0-9a-f]{6})$`)
+
+// validateTagMetadata checks if color of the tag is hex color or empty, and
+// its description and icon don't exceed the length saved in database.
+func validateTagMetadata(tag model.Tag) error {
+	if tag.Color != "" && !rxTagColor.MatchString(tag.Color) {
+		return fmt.Errorf("color must be hex color like #ff8800")
+	}
+
+	if utf8.RuneCountInString(tag.Description) > 1000 {
+		return fmt.Errorf("description must not exceed 1000 characters")
+	}
+
+	if utf8.RuneCountInString(tag.Icon) > 16 {
+		return fmt.Errorf("icon must not exceed 16 characters")
+	}
+
+	return nil
+}
+
+// bookmarkFields is the fields of bookmark that client may request in
+// `fields` query, keyed by their name in the full JSON representation.
+// The value is index of the field in model.Bookmark. It's built from JSON
+// tags of the model, so new fields are selectable as soon as they're added.
+var bookmarkFields = jsonFieldIndexes(reflect.TypeOf(model.Bookmark{}))
+
+// jsonFieldIndexes returns the index of fields in struct that encoded
+// into JSON, keyed by their name in JSON.
+func jsonFieldIndexes(structType reflect.Type) map[string]int {
+	fields := make(map[string]int, structType.NumField())
+	for i := 0; i < structType.NumField(); i++ {
+		field := structType.Field(i)
+		if field.PkgPath != "" {
+			continue
+		}
+
+		name := strings.Split(field.Tag.Get("json"), ",")[0]
+		if name == "-" {
+			continue
+		} else if name == "" {
+			name = field.Name
+		}
+
+		fields[name] = i
+	}
+
+	return fields
+}
+
+// compactFields is the fields requested by `fields=compact`,
+// which is enough to show bookmarks in a list.
+var compactFields = []string{"id", "title", "url", "imageURL"}
+
+// parseFieldsParam parses the bookmark fields requested in `fields` query.
+// It returns the known fields, and the unknown ones that should be ignored.
+// Empty fields means the full representation is requested.
+func parseFieldsParam(s string) (fields []string, unknown []string) {
+	for _, field := range parseListParam(s) {
+		if field == "compact" {
+			fields = append(fields, compactFields...)
+		} else if _, exist := bookmarkFields[field]; exist {
+			fields = append(fields, field)
+		} else {
+			unknown = append(unknown, field)
+		}
+	}
+
+	return fields, unknown
+}
+
+// projectBookmarks trims the bookmarks to only the specified fields.
+func projectBookmarks(bookmarks []model.Bookmark, fields []string) []map[string]interface{} {
+	result := make([]map[string]interface{}, len(bookmarks))
+	for i, book := range bookmarks {
+		value := reflect.ValueOf(book)
+		result[i] = make(map[string]interface{}, len(fields))
+		for _, field := range fields {
+			result[i][field] = value.Field(bookmarkFields[field]).Interface()
+		}
+	}
+
+	return result
+}
+
+// parsePerPage parses the number of bookmarks in each page requested by
+// client. It's capped at the server's max page size, unless it has none.
+func (h *handler) parsePerPage(s string) (int, error) {
+	if s == "" {
+		return defaultPageSize, nil
+	}
+
+	perPage, err := strconv.Atoi(s)
+	if err != nil || perPage < 1 {
+		return 0, fmt.Errorf("per_page must be a positive integer")
+	}
+
+	if h.MaxPageSize > 0 && perPage > h.MaxPageSize {
+		perPage = h.MaxPageSize
+	}
+
+	return perPage, nil
+}
+
+// paginationLinks creates the value of `Link` header that points to the
+// first, previous, next and last page of URL. The previous page is omitted
+// on the first page, and the next page is omitted on the last page.
+func paginationLinks(url *nurl.URL, page, maxPage int) string {
+	if maxPage < 1 {
+		maxPage = 1
+	}
+
+	pageLink := func(n int, rel string) string {
+		pageURL := *url
+		query := pageURL.Query()
+		query.Set("page", strconv.Itoa(n))
+		pageURL.RawQuery = query.Encode()
+		return fmt.Sprintf(`<%s>; rel="%s"`, pageURL.RequestURI(), rel)
+	}
+
+	links := []string{pageLink(1, "first")}
+	if page > 1 {
+		links = append(links, pageLink(page-1, "prev"))
+	}
+
+	if page < maxPage {
+		links = append(links, pageLink(page+1, "next"))
+	}
+
+	links = append(links, pageLink(maxPage, "last"))
+	return strings.Join(links, ", ")
+}
+
+// ifMatchVersion returns the bookmark version from `If-Match` header.
+// Returns false if the header is missing or doesn't contain a version.
+func ifMatchVersion(r *http.Request) (int, bool) {
+	etag := strings.TrimSpace(r.Header.Get("If-Match"))
+	etag = strings.Trim(strings.TrimPrefix(etag, "W/"), `"`)
+
+	version, err := strconv.Atoi(etag)
+	return version, err == nil
+}
+
+// isDryRun checks if the request asks to only preview the changes
+// by specifying `dryRun=true` in its URL query.
+func isDryRun(r *http.Request) bool {
+	dryRun, _ := strconv.ParseBool(r.URL.Query().Get("dryRun"))
+	return dryRun
+}
+
+// clientError is error caused by client's request, e.g. malformed JSON or
+// invalid field. When panicked in handler, its message is returned to
+// client with its status instead of 500 Internal Server Error.
+type clientError struct {
+	status int
+	err    error
+}
+
+func (e *clientError) Error() string {
+	return e.err.Error()
+}
+
+// newClientError marks err as caused by client's request, which should be
+// responded with the specified status.
+func newClientError(status int, err error) error {
+	return &clientError{status: status, err: err}
+}
+
+// apiError is the body of error response from API, so client can
+// tell the kind of error by its code instead of parsing the message.
+type apiError struct {
+	Error string `json:"error"`
+	Code  string `json:"code"`
+}
+
+// apiErrorCodes maps the status of error response to its code.
+// Status that isn't listed here uses "internal_error".
+var apiErrorCodes = map[int]string{
+	http.StatusBadRequest:            "invalid_request",
+	http.StatusUnauthorized:          "unauthorized",
+	http.StatusForbidden:             "forbidden",
+	http.StatusNotFound:              "not_found",
+	http.StatusConflict:              "conflict",
+	http.StatusRequestEntityTooLarge: "request_too_large",
+	http.StatusUnprocessableEntity:   "validation_failed",
+	http.StatusPreconditionRequired:  "precondition_required",
+	http.StatusTooManyRequests:       "quota_exceeded",
+	http.StatusNotImplemented:        "not_implemented",
+	http.StatusServiceUnavailable:    "unavailable",
+}
+
+// writeAPIError responds to API request with error in JSON.
+func writeAPIError(w http.ResponseWriter, status int, msg string) {
+	code, ok := apiErrorCodes[status]
+	if !ok {
+		code = "internal_error"
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(&apiError{Error: msg, Code: code})
+}
+
+// writeError responds with error in JSON for API request,
+// or as plain text for the others like UI and archive.
+func (h *handler) writeError(w http.ResponseWriter, r *http.Request, status int, msg string) {
+	if strings.HasPrefix(h.routePath(r), "/api/") {
+		writeAPIError(w, status, msg)
+		return
+	}
+
+	http.Error(w, msg, status)
+}
+
+// isDecodeError checks if the error caused by malformed JSON
+// that submitted by client.
+func isDecodeError(err error) bool {
+	switch err.(type) {
+	case *json.SyntaxError, *json.UnmarshalTypeError:
+		return true
+	}
+
+	return err == io.EOF || err == io.ErrUnexpectedEOF ||
+		strings.HasPrefix(err.Error(), "json: unknown field")
+}
+
+// checkQueryError is like checkError, except error caused by regular
+// expression that submitted by client is reported as bad request.
+func checkQueryError(err error) {
+	if err == database.ErrRegexTimeout {
+		panic(newClientError(http.StatusBadRequest, err))
+	}
+
+	checkError(err)
+}
+
 func checkError(err error) {
 	if err == nil {
 		return
@@ -0,0 +1,334 @@
+package webserver
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+	"time"
+
+	"shiori/internal/database"
+)
+
+func Test_parseTimeParam(t *testing.T) {
+	tests := []struct {
+		name    string
+		param   string
+		want    time.Time
+		wantErr bool
+	}{
+		{"unix epoch", "1577836800", time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC), false},
+		{"RFC3339 in UTC", "2020-01-01T00:00:00Z", time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC), false},
+		{"RFC3339 with offset", "2020-01-01T07:00:00+07:00", time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC), false},
+		{"date", "2020-01-01", time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC), false},
+		{"database format", "2020-01-01 00:00:00", time.Time{}, true},
+		{"garbage", "yesterday", time.Time{}, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := parseTimeParam(tt.param)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("parseTimeParam() error = %v, wantErr %v", err, tt.wantErr)
+				return
+			}
+
+			if !got.Equal(tt.want) {
+				t.Errorf("parseTimeParam() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func Test_ifMatchVersion(t *testing.T) {
+	tests := []struct {
+		name   string
+		header string
+		want   int
+		wantOK bool
+	}{
+		{"missing", "", 0, false},
+		{"strong etag", `"3"`, 3, true},
+		{"weak etag", `W/"12"`, 12, true},
+		{"unquoted", "7", 7, true},
+		{"not a version", `"abc"`, 0, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest("PUT", "/api/bookmarks", nil)
+			if tt.header != "" {
+				req.Header.Set("If-Match", tt.header)
+			}
+
+			got, ok := ifMatchVersion(req)
+			if got != tt.want || ok != tt.wantOK {
+				t.Errorf("ifMatchVersion() = %d, %v, want %d, %v", got, ok, tt.want, tt.wantOK)
+			}
+		})
+	}
+}
+
+// fakeArchive is archive that only contains resources with the specified names.
+type fakeArchive map[string]struct{}
+
+func (a fakeArchive) HasResource(name string) bool {
+	_, exist := a[name]
+	return exist
+}
+
+func Test_findArchivalName(t *testing.T) {
+	archived := []string{
+		"https://example.com/style.css?b=2&a=1",
+		"https://example.com/images/",
+		"https://example.com/logo.png",
+	}
+
+	archive := fakeArchive{}
+	for _, url := range archived {
+		archive[getArchivalName(url)] = struct{}{}
+	}
+
+	tests := []struct {
+		name string
+		src  string
+		want string
+	}{
+		{"exact URL", archived[2], getArchivalName(archived[2])},
+		{"with fragment", archived[2] + "#top", getArchivalName(archived[2])},
+		{"different query order", "https://example.com/style.css?a=1&b=2", getArchivalName(archived[0])},
+		{"without trailing slash", "https://example.com/images", getArchivalName(archived[1])},
+		{"with trailing slash", "https://example.com/logo.png/", getArchivalName(archived[2])},
+		{"not archived", "https://example.com/other.png", ""},
+		{"different query value", "https://example.com/style.css?a=1&b=3", ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := findArchivalName(archive, tt.src); got != tt.want {
+				t.Errorf("findArchivalName() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func Test_checkETag(t *testing.T) {
+	tests := []struct {
+		name            string
+		ifNoneMatch     string
+		wantNotModified bool
+	}{
+		{"no header", "", false},
+		{"same etag", `W/"5e0c-10"`, true},
+		{"strong form of same etag", `"5e0c-10"`, true},
+		{"one of several etags", `"abc", W/"5e0c-10"`, true},
+		{"wildcard", "*", true},
+		{"different etag", `W/"5e0c-11"`, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest("GET", "/bookmark/1/thumb", nil)
+			if tt.ifNoneMatch != "" {
+				req.Header.Set("If-None-Match", tt.ifNoneMatch)
+			}
+
+			rec := httptest.NewRecorder()
+			if got := checkETag(rec, req, `W/"5e0c-10"`); got != tt.wantNotModified {
+				t.Errorf("checkETag() = %v, want %v", got, tt.wantNotModified)
+			}
+
+			if tt.wantNotModified && rec.Code != http.StatusNotModified {
+				t.Errorf("checkETag() status = %d, want %d", rec.Code, http.StatusNotModified)
+			}
+
+			if etag := rec.Header().Get("ETag"); etag != `W/"5e0c-10"` {
+				t.Errorf("checkETag() ETag = %s", etag)
+			}
+
+			if cacheControl := rec.Header().Get("Cache-Control"); cacheControl != "private, no-cache" {
+				t.Errorf("checkETag() Cache-Control = %s, want private, no-cache", cacheControl)
+			}
+		})
+	}
+}
+
+func Test_parseListParam(t *testing.T) {
+	tests := []struct {
+		name  string
+		param string
+		want  []string
+	}{
+		{"empty", "", []string{}},
+		{"single item", "a", []string{"a"}},
+		{"several items", "a,b", []string{"a", "b"}},
+		{"trailing comma", "a,b,", []string{"a", "b"}},
+		{"empty items", ",a,,b", []string{"a", "b"}},
+		{"spaces around item", " a , b ", []string{"a", "b"}},
+		{"duplicate items", "a,b,a", []string{"a", "b"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := parseListParam(tt.param); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("parseListParam() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func Test_parseBookmarksFilterSearchFields(t *testing.T) {
+	tests := []struct {
+		name    string
+		query   string
+		want    []string
+		wantErr bool
+	}{
+		{"default", "", nil, false},
+		{"some fields", "searchFields=title,excerpt", []string{"title", "excerpt"}, false},
+		{"upper case", "searchFields=Title", []string{"title"}, false},
+		{"unknown field", "searchFields=title,tags", nil, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest("GET", "/api/bookmarks?"+tt.query, nil)
+			got, err := parseBookmarksFilter(req)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("parseBookmarksFilter() error = %v, wantErr %v", err, tt.wantErr)
+			}
+
+			if err == nil && !reflect.DeepEqual(got.SearchFields, tt.want) {
+				t.Errorf("parseBookmarksFilter() SearchFields = %q, want %q", got.SearchFields, tt.want)
+			}
+		})
+	}
+}
+
+func Test_parseBookmarksFilterSort(t *testing.T) {
+	tests := []struct {
+		name    string
+		query   string
+		want    database.OrderMethod
+		wantErr bool
+	}{
+		{"default", "", database.ByLastAdded, false},
+		{"oldest", "sort=oldest", database.ByFirstAdded, false},
+		{"last modified", "sort=last-modified", database.ByLastModified, false},
+		{"title", "sort=title", database.ByTitle, false},
+		{"domain", "sort=domain", database.ByDomain, false},
+		{"unknown sort", "sort=random", 0, true},
+		{"together with order", "sort=title&order=manual", 0, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest("GET", "/api/bookmarks?"+tt.query, nil)
+			got, err := parseBookmarksFilter(req)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("parseBookmarksFilter() error = %v, wantErr %v", err, tt.wantErr)
+			}
+
+			if err == nil && got.OrderMethod != tt.want {
+				t.Errorf("parseBookmarksFilter() OrderMethod = %v, want %v", got.OrderMethod, tt.want)
+			}
+		})
+	}
+}
+
+func Test_parseBookmarksFilterDateRange(t *testing.T) {
+	tests := []struct {
+		name    string
+		query   string
+		want    database.GetBookmarksOptions
+		wantErr bool
+	}{
+		{"created range", "created_after=2023-03-01&created_before=2023-04-01", database.GetBookmarksOptions{
+			CreatedSince:  "2023-03-01 00:00:00",
+			CreatedBefore: "2023-04-01 00:00:00",
+		}, false},
+		{"modified range", "modified_after=2023-03-01T07:00:00%2B07:00&modified_before=1680307200", database.GetBookmarksOptions{
+			UpdatedSince:  "2023-03-01 00:00:00",
+			UpdatedBefore: "2023-04-01 00:00:00",
+		}, false},
+		{"invalid time", "created_before=march", database.GetBookmarksOptions{}, true},
+		{"together with recentDays", "created_after=2023-03-01&recentDays=7", database.GetBookmarksOptions{}, true},
+		{"together with updatedSince", "modified_after=2023-03-01&updatedSince=2023-03-01", database.GetBookmarksOptions{}, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest("GET", "/api/bookmarks?"+tt.query, nil)
+			got, err := parseBookmarksFilter(req)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("parseBookmarksFilter() error = %v, wantErr %v", err, tt.wantErr)
+			}
+
+			if err != nil {
+				return
+			}
+
+			if got.CreatedSince != tt.want.CreatedSince || got.CreatedBefore != tt.want.CreatedBefore ||
+				got.UpdatedSince != tt.want.UpdatedSince || got.UpdatedBefore != tt.want.UpdatedBefore {
+				t.Errorf("parseBookmarksFilter() created [%q, %q) modified [%q, %q), want created [%q, %q) modified [%q, %q)",
+					got.CreatedSince, got.CreatedBefore, got.UpdatedSince, got.UpdatedBefore,
+					tt.want.CreatedSince, tt.want.CreatedBefore, tt.want.UpdatedSince, tt.want.UpdatedBefore)
+			}
+		})
+	}
+}
+
+func Test_paginationLinks(t *testing.T) {
+	tests := []struct {
+		name    string
+		url     string
+		page    int
+		maxPage int
+		want    string
+	}{
+		{
+			name:    "first page",
+			url:     "/api/bookmarks?tags=go",
+			page:    1,
+			maxPage: 3,
+			want: `</api/bookmarks?page=1&tags=go>; rel="first", ` +
+				`</api/bookmarks?page=2&tags=go>; rel="next", ` +
+				`</api/bookmarks?page=3&tags=go>; rel="last"`,
+		},
+		{
+			name:    "middle page",
+			url:     "/api/bookmarks?page=2&keyword=web+server",
+			page:    2,
+			maxPage: 3,
+			want: `</api/bookmarks?keyword=web+server&page=1>; rel="first", ` +
+				`</api/bookmarks?keyword=web+server&page=1>; rel="prev", ` +
+				`</api/bookmarks?keyword=web+server&page=3>; rel="next", ` +
+				`</api/bookmarks?keyword=web+server&page=3>; rel="last"`,
+		},
+		{
+			name:    "last page",
+			url:     "/shiori/api/bookmarks?page=3",
+			page:    3,
+			maxPage: 3,
+			want: `</shiori/api/bookmarks?page=1>; rel="first", ` +
+				`</shiori/api/bookmarks?page=2>; rel="prev", ` +
+				`</shiori/api/bookmarks?page=3>; rel="last"`,
+		},
+		{
+			name:    "no bookmarks",
+			url:     "/api/bookmarks",
+			page:    1,
+			maxPage: 0,
+			want:    `</api/bookmarks?page=1>; rel="first", </api/bookmarks?page=1>; rel="last"`,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest("GET", tt.url, nil)
+			if got := paginationLinks(req.URL, tt.page, tt.maxPage); got != tt.want {
+				t.Errorf("paginationLinks() = %s, want %s", got, tt.want)
+			}
+		})
+	}
+}
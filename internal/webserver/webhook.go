@@ -0,0 +1,280 @@
+package webserver
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"sync"
+	"time"
+
+	"shiori/internal/model"
+	"github.com/gofrs/uuid"
+	"github.com/julienschmidt/httprouter"
+	"github.com/sirupsen/logrus"
+)
+
+// Events of bookmark that sent to webhooks.
+const (
+	eventBookmarkCreated  = "bookmark.created"
+	eventBookmarkUpdated  = "bookmark.updated"
+	eventBookmarkDeleted  = "bookmark.deleted"
+	eventBookmarkArchived = "bookmark.archived"
+)
+
+// webhookRetries is the delays before retrying failed delivery, so
+// each delivery is attempted once more than the number of delays.
+var webhookRetries = []time.Duration{
+	5 * time.Second,
+	30 * time.Second,
+	5 * time.Minute,
+}
+
+// webhookTimeout is how long the server waits for webhook to respond,
+// while webhookLogSize is how many of the latest deliveries are kept.
+var (
+	webhookTimeout = 10 * time.Second
+	webhookLogSize = 100
+)
+
+// WebhookConfig is the URLs that notified when bookmarks changed. Each
+// payload is signed with Secret, so the receiver can verify it's sent
+// by this server.
+type WebhookConfig struct {
+	URLs   []string
+	Secret string
+}
+
+// webhookPayload is the JSON that sent to webhooks.
+type webhookPayload struct {
+	ID        string           `json:"id"`
+	Event     string           `json:"event"`
+	Time      string           `json:"time"`
+	Bookmarks []model.Bookmark `json:"bookmarks"`
+}
+
+// webhookDelivery is the delivery of an event to a webhook.
+type webhookDelivery struct {
+	ID         string `json:"id"`
+	URL        string `json:"url"`
+	Event      string `json:"event"`
+	Created    string `json:"created"`
+	Attempts   int    `json:"attempts"`
+	StatusCode int    `json:"statusCode,omitempty"`
+	Error      string `json:"error,omitempty"`
+	Delivered  bool   `json:"delivered"`
+}
+
+// webhookDispatcher sends events to webhooks in background, and keeps
+// the log of the latest deliveries.
+type webhookDispatcher struct {
+	sync.Mutex
+	config     WebhookConfig
+	client     *http.Client
+	background *backgroundWork
+	deliveries []*webhookDelivery
+}
+
+// newWebhookDispatcher creates dispatcher for the webhooks, whose deliveries
+// are tracked as background work of the server. Returns nil if there are no
+// webhooks configured.
+func newWebhookDispatcher(cfg WebhookConfig, background *backgroundWork) *webhookDispatcher {
+	if len(cfg.URLs) == 0 {
+		return nil
+	}
+
+	return &webhookDispatcher{
+		config:     cfg,
+		client:     &http.Client{Timeout: webhookTimeout},
+		background: background,
+	}
+}
+
+// newWebhookDeliveryID creates random ID of webhook delivery, which is
+// a UUID so the receiver can use it to drop the duplicate deliveries.
+func newWebhookDeliveryID() (string, error) {
+	id, err := uuid.NewV4()
+	if err != nil {
+		return "", err
+	}
+
+	return id.String(), nil
+}
+
+// webhookSignature returns the signature of payload, which sent in
+// `X-Shiori-Signature` header as `sha256=<hex of HMAC-SHA256>`.
+func webhookSignature(secret string, payload []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write(payload)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+// send sends the event of bookmarks to every webhook in background.
+// Their content is left out, since it might be huge.
+func (d *webhookDispatcher) send(event string, bookmarks ...model.Bookmark) {
+	if d == nil || len(bookmarks) == 0 {
+		return
+	}
+
+	id, err := newWebhookDeliveryID()
+	if err != nil {
+		logrus.WithError(err).Warn("failed to create webhook delivery ID")
+		return
+	}
+
+	payload := webhookPayload{
+		ID:        id,
+		Event:     event,
+		Time:      time.Now().UTC().Format(time.RFC3339),
+		Bookmarks: make([]model.Bookmark, len(bookmarks)),
+	}
+
+	for i, book := range bookmarks {
+		book.HTML = ""
+		book.Content = ""
+		payload.Bookmarks[i] = book
+	}
+
+	body, err := json.Marshal(&payload)
+	if err != nil {
+		logrus.WithError(err).Warn("failed to encode webhook payload")
+		return
+	}
+
+	for _, url := range d.config.URLs {
+		delivery := &webhookDelivery{
+			ID:      id,
+			URL:     url,
+			Event:   event,
+			Created: payload.Time,
+		}
+
+		d.log(delivery)
+		go d.deliver(delivery, body)
+	}
+}
+
+// log adds the delivery to the log, dropping the oldest one when it's full.
+func (d *webhookDispatcher) log(delivery *webhookDelivery) {
+	d.Lock()
+	defer d.Unlock()
+
+	d.deliveries = append(d.deliveries, delivery)
+	if len(d.deliveries) > webhookLogSize {
+		d.deliveries = d.deliveries[len(d.deliveries)-webhookLogSize:]
+	}
+}
+
+// deliver posts the payload to webhook, retrying after a while when the
+// webhook can't be reached or responds with error status. Each attempt is
+// background work, so the server waits for it before shutting down, while
+// the remaining retries are dropped.
+func (d *webhookDispatcher) deliver(delivery *webhookDelivery, body []byte) {
+	for attempt := 0; ; attempt++ {
+		if !d.background.begin() {
+			logrus.WithFields(logrus.Fields{
+				"delivery": delivery.ID,
+				"url":      delivery.URL,
+			}).Warn("server is shutting down, webhook is not delivered")
+			return
+		}
+
+		statusCode, err := d.post(delivery, body)
+		d.background.done()
+
+		d.Lock()
+		delivery.Attempts = attempt + 1
+		delivery.StatusCode = statusCode
+		delivery.Error = ""
+		if err != nil {
+			delivery.Error = err.Error()
+		}
+		delivery.Delivered = err == nil
+		d.Unlock()
+
+		entry := logrus.WithFields(logrus.Fields{
+			"delivery": delivery.ID,
+			"event":    delivery.Event,
+			"url":      delivery.URL,
+			"attempt":  attempt + 1,
+		})
+
+		if err == nil {
+			entry.Debug("delivered webhook")
+			return
+		}
+
+		if attempt >= len(webhookRetries) {
+			entry.WithError(err).Warn("failed to deliver webhook")
+			return
+		}
+
+		entry.WithError(err).Debug("failed to deliver webhook, will retry")
+
+		time.Sleep(webhookRetries[attempt])
+	}
+}
+
+// post sends the payload to webhook once.
+func (d *webhookDispatcher) post(delivery *webhookDelivery, body []byte) (int, error) {
+	req, err := http.NewRequest(http.MethodPost, delivery.URL, bytes.NewReader(body))
+	if err != nil {
+		return 0, err
+	}
+
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", "shiori-webhook")
+	req.Header.Set("X-Shiori-Event", delivery.Event)
+	req.Header.Set("X-Shiori-Delivery", delivery.ID)
+	if d.config.Secret != "" {
+		req.Header.Set("X-Shiori-Signature", webhookSignature(d.config.Secret, body))
+	}
+
+	resp, err := d.client.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+	io.Copy(ioutil.Discard, io.LimitReader(resp.Body, 64<<10))
+
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return resp.StatusCode, fmt.Errorf("webhook responded with status %d", resp.StatusCode)
+	}
+
+	return resp.StatusCode, nil
+}
+
+// recentDeliveries returns copy of the deliveries in log, newest first.
+func (d *webhookDispatcher) recentDeliveries() []webhookDelivery {
+	deliveries := []webhookDelivery{}
+	if d == nil {
+		return deliveries
+	}
+
+	d.Lock()
+	defer d.Unlock()
+
+	for i := len(d.deliveries) - 1; i >= 0; i-- {
+		deliveries = append(deliveries, *d.deliveries[i])
+	}
+
+	return deliveries
+}
+
+// apiGetWebhookDeliveries is handler for GET /api/webhooks/deliveries
+//
+// It lists the latest deliveries to webhooks, newest first, including the
+// ones that still being retried. The log is kept in memory, so it's empty
+// again after the server restarted.
+func (h *handler) apiGetWebhookDeliveries(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	deliveries := h.Webhooks.recentDeliveries()
+
+	w.Header().Set("Content-Type", "application/json")
+	err := json.NewEncoder(w).Encode(&deliveries)
+	checkError(err)
+}
@@ -0,0 +1,205 @@
+package webserver
+
+import (
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"shiori/internal/model"
+	"github.com/gofrs/uuid"
+	"github.com/julienschmidt/httprouter"
+)
+
+// webhookReceiver is test server that records the webhooks it received,
+// responding with error to the first `failures` of them.
+type webhookReceiver struct {
+	sync.Mutex
+	failures int
+	requests []*http.Request
+	bodies   [][]byte
+}
+
+func (rcv *webhookReceiver) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	body, _ := ioutil.ReadAll(r.Body)
+
+	rcv.Lock()
+	defer rcv.Unlock()
+
+	rcv.requests = append(rcv.requests, r)
+	rcv.bodies = append(rcv.bodies, body)
+	if len(rcv.requests) <= rcv.failures {
+		w.WriteHeader(http.StatusInternalServerError)
+	}
+}
+
+// waitDelivered waits until the latest delivery is finished.
+func waitDelivered(t *testing.T, d *webhookDispatcher, wantAttempts int) webhookDelivery {
+	deadline := time.Now().Add(5 * time.Second)
+	for time.Now().Before(deadline) {
+		deliveries := d.recentDeliveries()
+		if len(deliveries) > 0 && (deliveries[0].Delivered || deliveries[0].Attempts >= wantAttempts) {
+			return deliveries[0]
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	t.Fatalf("webhook is not delivered in time: %+v", d.recentDeliveries())
+	return webhookDelivery{}
+}
+
+func Test_webhookDispatcher(t *testing.T) {
+	oldRetries := webhookRetries
+	webhookRetries = []time.Duration{time.Millisecond, time.Millisecond}
+	defer func() { webhookRetries = oldRetries }()
+
+	tests := []struct {
+		name          string
+		failures      int
+		wantAttempts  int
+		wantDelivered bool
+	}{
+		{"delivered at once", 0, 1, true},
+		{"delivered after retry", 2, 3, true},
+		{"gives up after retries", 5, 3, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rcv := &webhookReceiver{failures: tt.failures}
+			server := httptest.NewServer(rcv)
+			defer server.Close()
+
+			d := newWebhookDispatcher(WebhookConfig{URLs: []string{server.URL}, Secret: "secret"}, nil)
+			d.send(eventBookmarkCreated, model.Bookmark{ID: 1, URL: "https://example.com", HTML: "<p>page</p>"})
+
+			delivery := waitDelivered(t, d, tt.wantAttempts)
+			if delivery.Attempts != tt.wantAttempts || delivery.Delivered != tt.wantDelivered {
+				t.Errorf("delivery = %+v, want %d attempts, delivered %v",
+					delivery, tt.wantAttempts, tt.wantDelivered)
+			}
+
+			rcv.Lock()
+			defer rcv.Unlock()
+
+			if len(rcv.requests) != tt.wantAttempts {
+				t.Fatalf("webhook received %d requests, want %d", len(rcv.requests), tt.wantAttempts)
+			}
+
+			req, body := rcv.requests[0], rcv.bodies[0]
+			if got, want := req.Header.Get("X-Shiori-Signature"), webhookSignature("secret", body); got != want {
+				t.Errorf("signature = %q, want %q", got, want)
+			}
+
+			if got := req.Header.Get("X-Shiori-Event"); got != eventBookmarkCreated {
+				t.Errorf("event header = %q, want %q", got, eventBookmarkCreated)
+			}
+
+			payload := webhookPayload{}
+			if err := json.Unmarshal(body, &payload); err != nil {
+				t.Fatal(err)
+			}
+
+			if payload.Event != eventBookmarkCreated || len(payload.Bookmarks) != 1 ||
+				payload.Bookmarks[0].ID != 1 || payload.Bookmarks[0].HTML != "" {
+				t.Errorf("payload = %+v, want bookmark 1 without HTML", payload)
+			}
+		})
+	}
+}
+
+func Test_webhookDispatcherShutdown(t *testing.T) {
+	oldRetries := webhookRetries
+	webhookRetries = []time.Duration{50 * time.Millisecond}
+	defer func() { webhookRetries = oldRetries }()
+
+	rcv := &webhookReceiver{failures: 1}
+	server := httptest.NewServer(rcv)
+	defer server.Close()
+
+	background := &backgroundWork{}
+	d := newWebhookDispatcher(WebhookConfig{URLs: []string{server.URL}}, background)
+	d.send(eventBookmarkCreated, model.Bookmark{ID: 1, URL: "https://example.com"})
+
+	delivery := waitDelivered(t, d, 1)
+	if _, err := uuid.FromString(delivery.ID); err != nil {
+		t.Errorf("delivery ID %q is not UUID: %v", delivery.ID, err)
+	}
+
+	// Shutdown doesn't wait for the retry, which is dropped instead
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+
+	if err := background.drain(ctx); err != nil {
+		t.Fatalf("drain() error = %v", err)
+	}
+
+	time.Sleep(100 * time.Millisecond)
+	if deliveries := d.recentDeliveries(); deliveries[0].Attempts != 1 || deliveries[0].Delivered {
+		t.Errorf("delivery after shutdown = %+v, want 1 failed attempt", deliveries[0])
+	}
+
+	rcv.Lock()
+	defer rcv.Unlock()
+
+	if len(rcv.requests) != 1 {
+		t.Errorf("webhook received %d requests, want 1", len(rcv.requests))
+	}
+}
+
+func Test_apiDeleteBookmarkWebhook(t *testing.T) {
+	hdl, cleanup := newTestHandler(t)
+	defer cleanup()
+
+	rcv := &webhookReceiver{}
+	server := httptest.NewServer(rcv)
+	defer server.Close()
+
+	hdl.Webhooks = newWebhookDispatcher(WebhookConfig{URLs: []string{server.URL}}, nil)
+	_, err := hdl.DB.SaveBookmarks(model.Bookmark{ID: 1, URL: "https://example.com", Title: "Example"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	router := httprouter.New()
+	router.DELETE("/api/bookmarks", hdl.apiDeleteBookmark)
+	router.GET("/api/webhooks/deliveries", hdl.apiGetWebhookDeliveries)
+	router.PanicHandler = hdl.handlePanic
+
+	req := newOwnerRequest("DELETE", "/api/bookmarks", strings.NewReader("[1]"))
+	rec := httptest.NewRecorder()
+	router.ServeHTTP(rec, req)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("apiDeleteBookmark() status = %d, want 200: %s", rec.Code, rec.Body)
+	}
+
+	waitDelivered(t, hdl.Webhooks, 1)
+
+	// The deliveries are listed in the API
+	req = newOwnerRequest("GET", "/api/webhooks/deliveries", nil)
+	rec = httptest.NewRecorder()
+	router.ServeHTTP(rec, req)
+
+	deliveries := []webhookDelivery{}
+	if err := json.NewDecoder(rec.Body).Decode(&deliveries); err != nil {
+		t.Fatal(err)
+	}
+
+	if len(deliveries) != 1 || deliveries[0].Event != eventBookmarkDeleted || !deliveries[0].Delivered {
+		t.Errorf("apiGetWebhookDeliveries() = %+v, want delivered %s", deliveries, eventBookmarkDeleted)
+	}
+
+	rcv.Lock()
+	defer rcv.Unlock()
+
+	payload := webhookPayload{}
+	if len(rcv.bodies) != 1 || json.Unmarshal(rcv.bodies[0], &payload) != nil ||
+		len(payload.Bookmarks) != 1 || payload.Bookmarks[0].URL != "https://example.com" {
+		t.Errorf("webhook payload = %+v, want the deleted bookmark", payload)
+	}
+}